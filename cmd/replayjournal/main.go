@@ -0,0 +1,57 @@
+// replayjournal replays the journals written by the order books, and checks that
+// the order books still do what the journals say they did.
+//
+// Usage: go run ./cmd/replayjournal journals/stock_1.journal [journals/stock_2.journal ...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/delta/dalal-street-server/matchingengine"
+	"github.com/delta/dalal-street-server/utils"
+)
+
+func main() {
+	// utils parses the flags when it's initialized, so the journals are taken from the leftover arguments
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: replayjournal <journal file>...")
+		os.Exit(2)
+	}
+
+	// the replayed order books don't touch the database, so utils isn't initialized fully
+	utils.Logger = logrus.New()
+	utils.Logger.Level = logrus.WarnLevel
+
+	failed := false
+	for _, fileName := range flag.Args() {
+		if err := replayFile(fileName); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %+v\n", fileName, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// replayFile replays a single journal and prints what was replayed
+func replayFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := matchingengine.ReplayJournal(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: OK. %d sessions, %d inputs, %d fills, %d trades\n", fileName, report.Sessions, report.Inputs, report.Fills, report.Trades)
+	return nil
+}
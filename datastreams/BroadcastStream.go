@@ -3,10 +3,14 @@ package datastreams
 import (
 	"sync"
 
+	"github.com/delta/dalal-street-server/metrics"
 	"github.com/delta/dalal-street-server/utils"
 	"github.com/sirupsen/logrus"
 )
 
+// streamListeners counts the listeners of all the streams, by the name of the stream
+var streamListeners = metrics.NewGaugeVec("dalal_stream_listeners", "Number of listeners of a stream.", "stream")
+
 // BroadcastStream represents an object that provides methods for handling a single stream
 // All updates are broadcast to *all* listeners.
 type BroadcastStream interface {
@@ -25,15 +29,17 @@ type listenersMap struct {
 // broadcastStream implements BroadcastStream interface
 type broadcastStream struct {
 	logger    *logrus.Entry
+	name      string
 	listeners *listenersMap
 }
 
-// NewBroadcastStream creates a BroadcastStream
-func NewBroadcastStream() BroadcastStream {
+// NewBroadcastStream creates a BroadcastStream. Its listeners are counted under the given name.
+func NewBroadcastStream(name string) BroadcastStream {
 	return &broadcastStream{
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.BroadcastStream",
 		}),
+		name: name,
 		listeners: &listenersMap{
 			m: make(map[string]*listener),
 		},
@@ -48,7 +54,9 @@ func (bs *broadcastStream) AddListener(sessionId string, lis *listener) {
 	})
 
 	bs.listeners.Lock()
+	oldCount := len(bs.listeners.m)
 	bs.listeners.m[sessionId] = lis
+	streamListeners.Add(float64(len(bs.listeners.m)-oldCount), bs.name)
 	bs.listeners.Unlock()
 
 	l.Debugf("Added")
@@ -62,7 +70,9 @@ func (bs *broadcastStream) RemoveListener(sessionId string) {
 	})
 
 	bs.listeners.Lock()
+	oldCount := len(bs.listeners.m)
 	delete(bs.listeners.m, sessionId)
+	streamListeners.Add(float64(len(bs.listeners.m)-oldCount), bs.name)
 	bs.listeners.Unlock()
 
 	l.Debugf("Removed")
@@ -94,9 +104,11 @@ func (bs *broadcastStream) BroadcastUpdate(update interface{}) {
 	}
 
 	bs.listeners.Lock()
+	oldCount := len(bs.listeners.m)
 	for _, sessionId := range deadListenerIds {
 		delete(bs.listeners.m, sessionId)
 	}
+	streamListeners.Add(float64(len(bs.listeners.m)-oldCount), bs.name)
 	bs.listeners.Unlock()
 
 	l.Debugf("Deleted dead listeners")
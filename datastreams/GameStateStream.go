@@ -29,7 +29,7 @@ func newGameStateStream() GameStateStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.GameStateStream",
 		}),
-		multicastStream: NewMulticastStream(),
+		multicastStream: NewMulticastStream("GameState"),
 	}
 }
 
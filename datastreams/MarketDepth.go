@@ -21,6 +21,8 @@ type MarketDepthStream interface {
 	AddOrder(isMarket bool, isAsk bool, price uint64, stockQuantity uint64)
 	AddTrade(price uint64, qty uint64, createdAt string)
 	CloseOrder(isMarket bool, isAsk bool, price uint64, stockQuantity uint64) // To be called after trades as well
+	SetIndicativePrice(price uint64, volume uint64)                           // To be called during call auctions
+	Clear()
 }
 
 // trade represents a single trade for a given stock
@@ -57,6 +59,13 @@ type marketDepthStream struct {
 	latestTrades     []*trade
 	latestTradesDiff []*trade
 
+	// price and volume at which the book would uncross if the call auction ended now.
+	// Both are 0 when there's no auction running.
+	indicativeLock    sync.Mutex
+	indicativePrice   uint64
+	indicativeVolume  uint64
+	indicativeChanged bool
+
 	broadcastStream BroadcastStream
 }
 
@@ -73,7 +82,7 @@ func newMarketDepthStream(stockId uint32) MarketDepthStream {
 		bidDepth:     make(map[uint64]uint64),
 		bidDepthDiff: make(map[uint64]int64),
 
-		broadcastStream: NewBroadcastStream(),
+		broadcastStream: NewBroadcastStream("MarketDepth"),
 	}
 
 	go mds.run()
@@ -132,6 +141,15 @@ func (mds *marketDepthStream) run() {
 		}
 		mds.latestTradesLock.Unlock()
 
+		mds.indicativeLock.Lock()
+		if mds.indicativeChanged {
+			mdUpdate.IndicativePrice = mds.indicativePrice
+			mdUpdate.IndicativeVolume = mds.indicativeVolume
+			mds.indicativeChanged = false
+			shouldSend = true
+		}
+		mds.indicativeLock.Unlock()
+
 		if !shouldSend {
 			l.Debugf("No update to send. Sleeping for 2 seconds")
 			time.Sleep(time.Second * 2)
@@ -169,6 +187,11 @@ func (mds *marketDepthStream) AddListener(done <-chan struct{}, update chan inte
 	}
 	mds.latestTradesLock.Unlock()
 
+	mds.indicativeLock.Lock()
+	mdUpdate.IndicativePrice = mds.indicativePrice
+	mdUpdate.IndicativeVolume = mds.indicativeVolume
+	mds.indicativeLock.Unlock()
+
 	l.Debugf("Sending %+v", mdUpdate)
 
 	// Required to be done in a go-func, otherwise deadlock results. update chan isn't read until this function returns.
@@ -307,3 +330,58 @@ func (mds *marketDepthStream) CloseOrder(isMarket bool, isAsk bool, price uint64
 	}
 	mds.bidDepthLock.Unlock()
 }
+
+// SetIndicativePrice sets the price and volume at which the book would uncross if the call auction
+// ended now. It should be called with 0s once the auction is over.
+func (mds *marketDepthStream) SetIndicativePrice(price uint64, volume uint64) {
+	var l = mds.logger.WithFields(logrus.Fields{
+		"method":       "MarketDepth.SetIndicativePrice",
+		"param_price":  price,
+		"param_volume": volume,
+	})
+
+	mds.indicativeLock.Lock()
+	if mds.indicativePrice != price || mds.indicativeVolume != volume {
+		mds.indicativePrice = price
+		mds.indicativeVolume = volume
+		mds.indicativeChanged = true
+	}
+	mds.indicativeLock.Unlock()
+
+	l.Debugf("Set")
+}
+
+// Clear takes all the orders off the depth, and forgets the latest trades. It is used when the order
+// book of a stock is loaded again, after its prices and quantities changed in a corporate action.
+func (mds *marketDepthStream) Clear() {
+	var l = mds.logger.WithFields(logrus.Fields{
+		"method": "MarketDepth.Clear",
+	})
+
+	mds.askDepthLock.Lock()
+	for price, stockQuantity := range mds.askDepth {
+		mds.askDepthDiff[price] -= int64(stockQuantity)
+		if mds.askDepthDiff[price] == 0 {
+			delete(mds.askDepthDiff, price)
+		}
+	}
+	mds.askDepth = make(map[uint64]uint64)
+	mds.askDepthLock.Unlock()
+
+	mds.bidDepthLock.Lock()
+	for price, stockQuantity := range mds.bidDepth {
+		mds.bidDepthDiff[price] -= int64(stockQuantity)
+		if mds.bidDepthDiff[price] == 0 {
+			delete(mds.bidDepthDiff, price)
+		}
+	}
+	mds.bidDepth = make(map[uint64]uint64)
+	mds.bidDepthLock.Unlock()
+
+	mds.latestTradesLock.Lock()
+	mds.latestTrades = nil
+	mds.latestTradesDiff = nil
+	mds.latestTradesLock.Unlock()
+
+	l.Debugf("Cleared")
+}
@@ -27,7 +27,7 @@ func newMarketEventsStream() MarketEventsStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.MarketEventsStream",
 		}),
-		broadcastStream: NewBroadcastStream(),
+		broadcastStream: NewBroadcastStream("MarketEvents"),
 	}
 }
 
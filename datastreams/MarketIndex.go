@@ -0,0 +1,138 @@
+package datastreams
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// MarketIndexStream interface defines the interface to interact with MarketIndex stream
+type MarketIndexStream interface {
+	Run()
+	SendMarketIndexUpdate(indexName string, value uint64)
+	SendMarketIndexHistoryUpdate(history *models_pb.MarketIndexHistory)
+	AddListener(done <-chan struct{}, updates chan interface{}, sessionId string)
+	RemoveListener(sessionId string)
+}
+
+// marketIndexStream implements MarketIndexStream interface
+type marketIndexStream struct {
+	logger          *logrus.Entry
+	broadcastStream BroadcastStream
+
+	marketIndexMutex sync.Mutex
+	isDirty          bool   // true if we haven't sent the latest value yet
+	indexName        string // name of the index the latest value is for
+	value            uint64
+}
+
+// newMarketIndexStream creates a new MarketIndexStream
+func newMarketIndexStream() MarketIndexStream {
+	return &marketIndexStream{
+		logger: utils.Logger.WithFields(logrus.Fields{
+			"module": "datastreams.MarketIndexStream",
+		}),
+		broadcastStream: NewBroadcastStream("MarketIndex"),
+	}
+}
+
+// Run runs the MarketIndexStream. Call in a gofunc. Repeatedly broadcasts the value of the market index
+// every 5 seconds, if it changed
+func (mis *marketIndexStream) Run() {
+	var l = mis.logger.WithFields(logrus.Fields{
+		"method": "Run",
+	})
+
+	defer func() {
+		if r := recover(); r != nil {
+			l.Errorf("Error! Stack trace: %s", string(debug.Stack()))
+		}
+	}()
+
+	for {
+		mis.marketIndexMutex.Lock()
+
+		if !mis.isDirty {
+			mis.marketIndexMutex.Unlock()
+			time.Sleep(time.Second * 5)
+			continue
+		}
+
+		updateProto := &datastreams_pb.MarketIndexUpdate{
+			IndexName: mis.indexName,
+			Value:     mis.value,
+		}
+		mis.isDirty = false
+		mis.marketIndexMutex.Unlock()
+
+		mis.broadcastStream.BroadcastUpdate(updateProto)
+
+		l.Debugf("Sent to %d listeners! Sleeping for 5 seconds", mis.broadcastStream.GetListenersCount())
+
+		time.Sleep(time.Second * 5)
+	}
+}
+
+// SendMarketIndexUpdate updates the value of the market index. It doesn't send it immediately. That's done by Run.
+func (mis *marketIndexStream) SendMarketIndexUpdate(indexName string, value uint64) {
+	var l = mis.logger.WithFields(logrus.Fields{
+		"method":          "SendMarketIndexUpdate",
+		"param_indexName": indexName,
+		"param_value":     value,
+	})
+
+	l.Debugf("Adding to the next market index update")
+	mis.marketIndexMutex.Lock()
+	mis.isDirty = true
+	mis.indexName = indexName
+	mis.value = value
+	mis.marketIndexMutex.Unlock()
+}
+
+// SendMarketIndexHistoryUpdate sends out an interval of the market index's history to all the listeners
+func (mis *marketIndexStream) SendMarketIndexHistoryUpdate(history *models_pb.MarketIndexHistory) {
+	var l = mis.logger.WithFields(logrus.Fields{
+		"method": "SendMarketIndexHistoryUpdate",
+	})
+
+	l.Debugf("Sending history broadcast")
+	update := &datastreams_pb.MarketIndexUpdate{
+		IndexName: history.IndexName,
+		Value:     history.Close,
+		History:   history,
+	}
+
+	mis.broadcastStream.BroadcastUpdate(update)
+}
+
+// AddListener adds a listener to the MarketIndexStream
+func (mis *marketIndexStream) AddListener(done <-chan struct{}, update chan interface{}, sessionId string) {
+	var l = mis.logger.WithFields(logrus.Fields{
+		"method":          "AddListener",
+		"param_sessionId": sessionId,
+	})
+
+	mis.broadcastStream.AddListener(sessionId, &listener{
+		update: update,
+		done:   done,
+	})
+
+	l.Infof("Added")
+}
+
+// RemoveListener removes a listener from the MarketIndexStream
+func (mis *marketIndexStream) RemoveListener(sessionId string) {
+	var l = mis.logger.WithFields(logrus.Fields{
+		"method":          "RemoveListener",
+		"param_sessionId": sessionId,
+	})
+
+	mis.broadcastStream.RemoveListener(sessionId)
+
+	l.Infof("Removed")
+}
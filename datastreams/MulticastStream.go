@@ -25,15 +25,17 @@ type groupsMap struct {
 // multicastStream implements MulticastStream interface
 type multicastStream struct {
 	logger *logrus.Entry
+	name   string
 	groups *groupsMap
 }
 
-// NewMulticastStream creates a MulticastStream
-func NewMulticastStream() MulticastStream {
+// NewMulticastStream creates a MulticastStream. The listeners of all its groups are counted under the given name.
+func NewMulticastStream(name string) MulticastStream {
 	return &multicastStream{
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.MulticastStream",
 		}),
+		name: name,
 		groups: &groupsMap{
 			m: make(map[uint32]BroadcastStream),
 		},
@@ -54,7 +56,7 @@ func (ms *multicastStream) AddListener(groupId uint32, sessionId string, lis *li
 	if !exists {
 		l.Debugf("Groups map not found. Creating")
 		// create group if it doesn't exist
-		ms.groups.m[groupId] = NewBroadcastStream()
+		ms.groups.m[groupId] = NewBroadcastStream(ms.name)
 		group = ms.groups.m[groupId]
 	}
 	ms.groups.Unlock()
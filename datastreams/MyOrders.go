@@ -26,7 +26,7 @@ func newMyOrdersStream() MyOrdersStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.MyOrdersStream",
 		}),
-		multicastStream: NewMulticastStream(),
+		multicastStream: NewMulticastStream("MyOrders"),
 	}
 }
 
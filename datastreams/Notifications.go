@@ -29,7 +29,7 @@ func newNotificationsStream() NotificationsStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.NotificationsStream",
 		}),
-		multicastStream: NewMulticastStream(),
+		multicastStream: NewMulticastStream("Notifications"),
 	}
 }
 
@@ -34,7 +34,7 @@ func newStockExchangeStream() StockExchangeStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.StockExchangeStream",
 		}),
-		broadcastStream:       NewBroadcastStream(),
+		broadcastStream:       NewBroadcastStream("StockExchange"),
 		dirtyStocksInExchange: make(map[uint32]*datastreams_pb.StockExchangeDataPoint),
 	}
 }
@@ -29,7 +29,7 @@ func newStockHistoryStream(stockId uint32) StockHistoryStream {
 			"module":  "datastreams.StockHistoryStream",
 			"stockId": stockId,
 		}),
-		broadcastStream: NewBroadcastStream(),
+		broadcastStream: NewBroadcastStream("StockHistory"),
 	}
 	return shs
 }
@@ -14,6 +14,7 @@ import (
 type StockPricesStream interface {
 	Run()
 	SendStockPriceUpdate(stockId uint32, price uint64)
+	RemoveStock(stockId uint32)
 	AddListener(done <-chan struct{}, updates chan interface{}, sessionId string)
 	RemoveListener(sessionId string)
 }
@@ -33,7 +34,7 @@ func newStockPricesStream() StockPricesStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.StockPricesStream",
 		}),
-		broadcastStream: NewBroadcastStream(),
+		broadcastStream: NewBroadcastStream("StockPrices"),
 		dirtyStocks:     make(map[uint32]uint64),
 	}
 }
@@ -90,6 +91,21 @@ func (sps *stockPricesStream) SendStockPriceUpdate(stockId uint32, price uint64)
 	sps.stockPricesMutex.Unlock()
 }
 
+// RemoveStock drops a delisted stock from the StockPricesStream. An update of its price that hasn't
+// been sent yet is dropped too.
+func (sps *stockPricesStream) RemoveStock(stockId uint32) {
+	var l = sps.logger.WithFields(logrus.Fields{
+		"method":        "RemoveStock",
+		"param_stockId": stockId,
+	})
+
+	sps.stockPricesMutex.Lock()
+	delete(sps.dirtyStocks, stockId)
+	sps.stockPricesMutex.Unlock()
+
+	l.Infof("Removed")
+}
+
 // AddListener adds a listener to the StockPricesStream
 func (sps *stockPricesStream) AddListener(done <-chan struct{}, update chan interface{}, sessionId string) {
 	var l = sps.logger.WithFields(logrus.Fields{
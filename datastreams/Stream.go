@@ -27,6 +27,8 @@ func Init(config *utils.Config) {
 // Manager manages access to all data streams
 type Manager interface {
 	GetMarketDepthStream(stockId uint32) MarketDepthStream
+	GetOptionMarketDepthStream(optionId uint32) MarketDepthStream
+	GetFutureMarketDepthStream(futureId uint32) MarketDepthStream
 	GetMarketEventsStream() MarketEventsStream
 	GetMyOrdersStream() MyOrdersStream
 	GetNotificationsStream() NotificationsStream
@@ -35,6 +37,7 @@ type Manager interface {
 	GetTransactionsStream() TransactionsStream
 	GetStockHistoryStream(stockId uint32) StockHistoryStream
 	GetGameStateStream() GameStateStream
+	GetMarketIndexStream() MarketIndexStream
 }
 
 // dataStreamsManager implements the Manager interface
@@ -45,6 +48,14 @@ type dataStreamsManager struct {
 	marketDepthsLock sync.RWMutex
 	marketDepthsMap  map[uint32]MarketDepthStream
 
+	// market depth streams of options
+	optionDepthsLock sync.RWMutex
+	optionDepthsMap  map[uint32]MarketDepthStream
+
+	// market depth streams of futures
+	futureDepthsLock sync.RWMutex
+	futureDepthsMap  map[uint32]MarketDepthStream
+
 	// stock history streams
 	stockHistoryLock      sync.RWMutex
 	stockHistoryStreamMap map[uint32]StockHistoryStream
@@ -63,6 +74,8 @@ type dataStreamsManager struct {
 	transactionsStreamInstance TransactionsStream
 	// game state stream
 	gameStateStreamInstance GameStateStream
+	// market index stream
+	marketIndexStreamInstance MarketIndexStream
 }
 
 // dataStreamsManagerInstance holds the singleton instance of dataStreamsManager
@@ -76,6 +89,8 @@ func GetManager() Manager {
 		}),
 
 		marketDepthsMap:             make(map[uint32]MarketDepthStream),
+		optionDepthsMap:             make(map[uint32]MarketDepthStream),
+		futureDepthsMap:             make(map[uint32]MarketDepthStream),
 		stockHistoryStreamMap:       make(map[uint32]StockHistoryStream),
 		marketEventsStreamInstance:  newMarketEventsStream(),
 		myOrdersStreamInstance:      newMyOrdersStream(),
@@ -84,6 +99,7 @@ func GetManager() Manager {
 		stockPricesStreamInstance:   newStockPricesStream(),
 		transactionsStreamInstance:  newTransactionsStream(),
 		gameStateStreamInstance:     newGameStateStream(),
+		marketIndexStreamInstance:   newMarketIndexStream(),
 	}
 }
 
@@ -99,6 +115,30 @@ func (dsm *dataStreamsManager) GetMarketDepthStream(stockId uint32) MarketDepthS
 	return dsm.marketDepthsMap[stockId]
 }
 
+// GetOptionMarketDepthStream returns a singleton instance MarketDepthStream for a given optionId
+func (dsm *dataStreamsManager) GetOptionMarketDepthStream(optionId uint32) MarketDepthStream {
+	dsm.optionDepthsLock.Lock()
+	defer dsm.optionDepthsLock.Unlock()
+
+	_, ok := dsm.optionDepthsMap[optionId]
+	if !ok {
+		dsm.optionDepthsMap[optionId] = newMarketDepthStream(optionId)
+	}
+	return dsm.optionDepthsMap[optionId]
+}
+
+// GetFutureMarketDepthStream returns a singleton instance MarketDepthStream for a given futureId
+func (dsm *dataStreamsManager) GetFutureMarketDepthStream(futureId uint32) MarketDepthStream {
+	dsm.futureDepthsLock.Lock()
+	defer dsm.futureDepthsLock.Unlock()
+
+	_, ok := dsm.futureDepthsMap[futureId]
+	if !ok {
+		dsm.futureDepthsMap[futureId] = newMarketDepthStream(futureId)
+	}
+	return dsm.futureDepthsMap[futureId]
+}
+
 // GetMarketEventsStream returns a singleton instance of MarketEvents stream
 func (dsm *dataStreamsManager) GetMarketEventsStream() MarketEventsStream {
 	return dsm.marketEventsStreamInstance
@@ -144,3 +184,8 @@ func (dsm *dataStreamsManager) GetTransactionsStream() TransactionsStream {
 func (dsm *dataStreamsManager) GetGameStateStream() GameStateStream {
 	return dsm.gameStateStreamInstance
 }
+
+// GetMarketIndexStream returns a singleton instance of MarketIndex stream
+func (dsm *dataStreamsManager) GetMarketIndexStream() MarketIndexStream {
+	return dsm.marketIndexStreamInstance
+}
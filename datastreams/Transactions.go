@@ -27,7 +27,7 @@ func newTransactionsStream() TransactionsStream {
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "datastreams.TransactionsStream",
 		}),
-		multicastStream: NewMulticastStream(),
+		multicastStream: NewMulticastStream("Transactions"),
 	}
 }
 
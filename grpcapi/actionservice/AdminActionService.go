@@ -3,6 +3,7 @@ package actionservice
 import (
 	"fmt"
 
+	"github.com/delta/dalal-street-server/matchingengine"
 	"github.com/delta/dalal-street-server/models"
 	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
 	models_pb "github.com/delta/dalal-street-server/proto_build/models"
@@ -37,6 +38,11 @@ func (d *dalalActionService) OpenMarket(ctx context.Context, req *actions_pb.Ope
 		return makeError(actions_pb.OpenMarketResponse_NotAdminUserError, "User is not admin")
 	}
 
+	// The opening call auction uncrosses first, so that the day opens at the auction price
+	if models.IsCallAuctionRunning() {
+		d.matchingEngine.Uncross()
+	}
+
 	err := models.OpenMarket(req.UpdateDayHighAndLow)
 
 	if err != nil {
@@ -68,6 +74,11 @@ func (d *dalalActionService) CloseMarket(ctx context.Context, req *actions_pb.Cl
 		return makeError(actions_pb.CloseMarketResponse_NotAdminUserError, "User is not admin")
 	}
 
+	// The closing call auction uncrosses first, so that the day closes at the auction price
+	if models.IsCallAuctionRunning() {
+		d.matchingEngine.Uncross()
+	}
+
 	err := models.CloseMarket(req.UpdatePrevDayClose)
 
 	if err != nil {
@@ -75,6 +86,15 @@ func (d *dalalActionService) CloseMarket(ctx context.Context, req *actions_pb.Cl
 		return makeError(actions_pb.CloseMarketResponse_InternalServerError, getInternalErrorMessage(err))
 	}
 
+	// Day orders and good-till-date orders expiring today are closed once the market is closed
+	d.matchingEngine.ExpireOrders()
+
+	// Options expiring today settle against the closing prices
+	d.matchingEngine.SettleExpiredOptions()
+
+	// and so do futures, after the ones that don't expire are marked to market
+	d.matchingEngine.SettleExpiredFutures()
+
 	resp.StatusCode = actions_pb.CloseMarketResponse_OK
 	resp.StatusMessage = "OK"
 
@@ -82,6 +102,40 @@ func (d *dalalActionService) CloseMarket(ctx context.Context, req *actions_pb.Cl
 
 }
 
+func (d *dalalActionService) StartCallAuction(ctx context.Context, req *actions_pb.StartCallAuctionRequest) (*actions_pb.StartCallAuctionResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "StartCallAuction",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.StartCallAuctionResponse{}
+	makeError := func(st actions_pb.StartCallAuctionResponse_StatusCode, msg string) (*actions_pb.StartCallAuctionResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.StartCallAuctionResponse_NotAdminUserError, "User is not admin")
+	}
+
+	if models.IsCallAuctionRunning() {
+		return makeError(actions_pb.StartCallAuctionResponse_CallAuctionAlreadyRunningError, "A call auction is running already")
+	}
+
+	// The order books stop matching before orders are accepted for the auction
+	d.matchingEngine.StartCallAuction()
+	models.StartCallAuction()
+
+	l.Infof("Started call auction")
+
+	resp.StatusCode = actions_pb.StartCallAuctionResponse_OK
+	resp.StatusMessage = "OK"
+
+	return resp, nil
+}
+
 func (d *dalalActionService) UpdateEndOfDayValues(ctx context.Context, req *actions_pb.UpdateEndOfDayValuesRequest) (*actions_pb.UpdateEndOfDayValuesResponse, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "UpdateEndOfDayValues",
@@ -496,6 +550,134 @@ func (d *dalalActionService) SetBankruptcy(ctx context.Context, req *actions_pb.
 	return resp, nil
 }
 
+func (d *dalalActionService) SetSelfTradePrevention(ctx context.Context, req *actions_pb.SetSelfTradePreventionRequest) (*actions_pb.SetSelfTradePreventionResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SetSelfTradePrevention",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("Request for setting self-trade prevention")
+
+	resp := &actions_pb.SetSelfTradePreventionResponse{}
+	makeError := func(st actions_pb.SetSelfTradePreventionResponse_StatusCode, msg string) (*actions_pb.SetSelfTradePreventionResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.SetSelfTradePreventionResponse_NotAdminUserError, "User is not admin")
+	}
+
+	stockID := req.GetStockId()
+	mode := models.SelfTradePreventionFromProto(req.GetSelfTradePrevention())
+
+	err := models.SetSelfTradePrevention(stockID, mode)
+
+	if err == models.InvalidStockError {
+		return makeError(actions_pb.SetSelfTradePreventionResponse_InvalidStockIdError, "Invalid stock id provided.")
+	}
+
+	if err != nil {
+		return makeError(actions_pb.SetSelfTradePreventionResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.StatusCode = 0
+	resp.StatusMessage = "Self-trade prevention set succesfully."
+
+	return resp, nil
+}
+
+func (d *dalalActionService) SetStockFees(ctx context.Context, req *actions_pb.SetStockFeesRequest) (*actions_pb.SetStockFeesResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SetStockFees",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("Request for setting stock fees")
+
+	resp := &actions_pb.SetStockFeesResponse{}
+	makeError := func(st actions_pb.SetStockFeesResponse_StatusCode, msg string) (*actions_pb.SetStockFeesResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.SetStockFeesResponse_NotAdminUserError, "User is not admin")
+	}
+
+	// a fee of models.ConfigFeeBasisPoints makes the stock use the fee in the config
+	err := models.SetStockFees(req.GetStockId(), req.GetMakerFeeBasisPoints(), req.GetTakerFeeBasisPoints())
+
+	if err == models.InvalidStockError {
+		return makeError(actions_pb.SetStockFeesResponse_InvalidStockIdError, "Invalid stock id provided.")
+	}
+
+	if err == models.InvalidFeeError {
+		return makeError(actions_pb.SetStockFeesResponse_InvalidFeeError, "Fees can't be negative.")
+	}
+
+	if err != nil {
+		return makeError(actions_pb.SetStockFeesResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.StatusCode = 0
+	resp.StatusMessage = "Stock fees set succesfully."
+
+	return resp, nil
+}
+
+func (d *dalalActionService) SetTaxBrackets(ctx context.Context, req *actions_pb.SetTaxBracketsRequest) (*actions_pb.SetTaxBracketsResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SetTaxBrackets",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("Request for setting tax brackets")
+
+	resp := &actions_pb.SetTaxBracketsResponse{}
+	makeError := func(st actions_pb.SetTaxBracketsResponse_StatusCode, msg string) (*actions_pb.SetTaxBracketsResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.SetTaxBracketsResponse_NotAdminUserError, "User is not admin")
+	}
+
+	var brackets []models.TaxBracket
+	for _, bracket := range req.GetBrackets() {
+		brackets = append(brackets, models.TaxBracket{
+			LowerLimit: bracket.GetLowerLimit(),
+			TaxPercent: bracket.GetTaxPercent(),
+		})
+	}
+
+	err := models.SetTaxBrackets(brackets)
+
+	switch e := err.(type) {
+	case models.InvalidTaxBracketsError:
+		return makeError(actions_pb.SetTaxBracketsResponse_InvalidTaxBracketsError, e.Error())
+	}
+
+	if err != nil {
+		return makeError(actions_pb.SetTaxBracketsResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.StatusCode = 0
+	resp.StatusMessage = "Tax brackets set succesfully."
+
+	return resp, nil
+}
+
 func (d *dalalActionService) InspectUser(ctx context.Context, req *actions_pb.InspectUserRequest) (*actions_pb.InspectUserResponse, error) {
 
 	var l = logger.WithFields(logrus.Fields{
@@ -870,7 +1052,7 @@ func (d *dalalActionService) SquareOffShortSells(ctx context.Context, req *actio
 	}
 
 	res.StatusCode = actions_pb.SquareOffShortSellResponse_OK
-	res.StatusMessage = "successfully squared off short sell stocks"
+	res.StatusMessage = "successfully settled short sell lends"
 	return res, nil
 }
 
@@ -935,7 +1117,7 @@ func (d *dalalActionService) CloseIpoBidding(ctx context.Context, req *actions_p
 		return makeError(actions_pb.CloseIpoBiddingResponse_NotAdminUserError, "User is not admin")
 	}
 
-	err := models.AllotSlots(req.IpoStockId)
+	stockId, err := models.AllotSlots(req.IpoStockId)
 
 	switch err.(type) {
 	case models.InvalidStockIdError:
@@ -948,7 +1130,376 @@ func (d *dalalActionService) CloseIpoBidding(ctx context.Context, req *actions_p
 		return makeError(actions_pb.CloseIpoBiddingResponse_InternalServerError, getInternalErrorMessage(err))
 	}
 
+	// the listed stock can be traded right away
+	if err := d.matchingEngine.AddStock(stockId); err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.CloseIpoBiddingResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
 	resp.StatusMessage = "Done"
 	resp.StatusCode = actions_pb.CloseIpoBiddingResponse_OK
 	return resp, nil
 }
+
+func (d *dalalActionService) ListOption(ctx context.Context, req *actions_pb.ListOptionRequest) (*actions_pb.ListOptionResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "ListOption",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.ListOptionResponse{}
+
+	makeError := func(st actions_pb.ListOptionResponse_StatusCode, msg string) (*actions_pb.ListOptionResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.ListOptionResponse_NotAdminUserError, "User is not admin")
+	}
+
+	option, err := models.ListOption(req.StockId, models.OptionTypeFromProto(req.Type), req.StrikePrice, req.LotSize, req.ExpiryDay)
+
+	switch e := err.(type) {
+	case models.InvalidStockIdError:
+		return makeError(actions_pb.ListOptionResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.ListOptionResponse_StockBankruptError, e.Error())
+	case models.InvalidOptionError:
+		return makeError(actions_pb.ListOptionResponse_InvalidOptionError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.ListOptionResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	// the option can be traded right away
+	if err := d.matchingEngine.AddOption(option.Id, option.StockId); err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.ListOptionResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Option = option.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.ListOptionResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) ListFuture(ctx context.Context, req *actions_pb.ListFutureRequest) (*actions_pb.ListFutureResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "ListFuture",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.ListFutureResponse{}
+
+	makeError := func(st actions_pb.ListFutureResponse_StatusCode, msg string) (*actions_pb.ListFutureResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.ListFutureResponse_NotAdminUserError, "User is not admin")
+	}
+
+	future, err := models.ListFuture(req.StockId, req.LotSize, req.ExpiryDay)
+
+	switch e := err.(type) {
+	case models.InvalidStockIdError:
+		return makeError(actions_pb.ListFutureResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.ListFutureResponse_StockBankruptError, e.Error())
+	case models.InvalidFutureError:
+		return makeError(actions_pb.ListFutureResponse_InvalidFutureError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.ListFutureResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	// the future can be traded right away
+	if err := d.matchingEngine.AddFuture(future.Id, future.StockId); err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.ListFutureResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Future = future.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.ListFutureResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) SplitStock(ctx context.Context, req *actions_pb.SplitStockRequest) (*actions_pb.SplitStockResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SplitStock",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.SplitStockResponse{}
+
+	makeError := func(st actions_pb.SplitStockResponse_StatusCode, msg string) (*actions_pb.SplitStockResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.SplitStockResponse_NotAdminUserError, "User is not admin")
+	}
+
+	// for a bonus issue, NewShares bonus shares are given for every OldShares shares held
+	err := d.matchingEngine.RestructureStock(req.StockId, func() error {
+		if req.IsBonusIssue {
+			return models.IssueBonusShares(req.StockId, req.NewShares, req.OldShares)
+		}
+		return models.SplitStock(req.StockId, req.NewShares, req.OldShares)
+	})
+
+	switch e := err.(type) {
+	case matchingengine.UnknownStockError, models.InvalidStockIdError:
+		return makeError(actions_pb.SplitStockResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.SplitStockResponse_StockBankruptError, e.Error())
+	case models.CorporateActionError:
+		return makeError(actions_pb.SplitStockResponse_CorporateActionError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.SplitStockResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.SplitStockResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) AnnounceRightsIssue(ctx context.Context, req *actions_pb.AnnounceRightsIssueRequest) (*actions_pb.AnnounceRightsIssueResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "AnnounceRightsIssue",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.AnnounceRightsIssueResponse{}
+
+	makeError := func(st actions_pb.AnnounceRightsIssueResponse_StatusCode, msg string) (*actions_pb.AnnounceRightsIssueResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.AnnounceRightsIssueResponse_NotAdminUserError, "User is not admin")
+	}
+
+	rightsIssue, err := models.AnnounceRightsIssue(req.StockId, req.NewShares, req.HeldShares, req.Price, req.ClosesOnDay)
+
+	switch e := err.(type) {
+	case models.InvalidStockIdError:
+		return makeError(actions_pb.AnnounceRightsIssueResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.AnnounceRightsIssueResponse_StockBankruptError, e.Error())
+	case models.InvalidRightsIssueError:
+		return makeError(actions_pb.AnnounceRightsIssueResponse_InvalidRightsIssueError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.AnnounceRightsIssueResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.RightsIssue = rightsIssue.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.AnnounceRightsIssueResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) AnnounceBuyback(ctx context.Context, req *actions_pb.AnnounceBuybackRequest) (*actions_pb.AnnounceBuybackResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "AnnounceBuyback",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.AnnounceBuybackResponse{}
+
+	makeError := func(st actions_pb.AnnounceBuybackResponse_StatusCode, msg string) (*actions_pb.AnnounceBuybackResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.AnnounceBuybackResponse_NotAdminUserError, "User is not admin")
+	}
+
+	buyback, err := models.AnnounceBuyback(req.StockId, req.Price, req.StockQuantity, req.ClosesOnDay)
+
+	switch e := err.(type) {
+	case models.InvalidStockIdError:
+		return makeError(actions_pb.AnnounceBuybackResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.AnnounceBuybackResponse_StockBankruptError, e.Error())
+	case models.InvalidBuybackError:
+		return makeError(actions_pb.AnnounceBuybackResponse_InvalidBuybackError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.AnnounceBuybackResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Buyback = buyback.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.AnnounceBuybackResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) MergeStocks(ctx context.Context, req *actions_pb.MergeStocksRequest) (*actions_pb.MergeStocksResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "MergeStocks",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.MergeStocksResponse{}
+
+	makeError := func(st actions_pb.MergeStocksResponse_StatusCode, msg string) (*actions_pb.MergeStocksResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.MergeStocksResponse_NotAdminUserError, "User is not admin")
+	}
+
+	// the absorbed stock stops trading while its holdings are converted, and for good after that
+	var merger *models.Merger
+	err := d.matchingEngine.RestructureStock(req.FromStockId, func() error {
+		var err error
+		merger, err = models.MergeStocks(req.FromStockId, req.ToStockId, req.NewShares, req.OldShares, req.CashPerShare)
+		return err
+	})
+
+	switch e := err.(type) {
+	case matchingengine.UnknownStockError, models.InvalidStockIdError:
+		return makeError(actions_pb.MergeStocksResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.MergeStocksResponse_StockBankruptError, e.Error())
+	case models.CorporateActionError:
+		return makeError(actions_pb.MergeStocksResponse_CorporateActionError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.MergeStocksResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	if err := d.matchingEngine.RemoveStock(req.FromStockId); err != nil {
+		l.Errorf("Unable to remove the order book of the absorbed stock: %+v", err)
+	}
+
+	resp.Merger = merger.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.MergeStocksResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) AnnounceDividend(ctx context.Context, req *actions_pb.AnnounceDividendRequest) (*actions_pb.AnnounceDividendResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "AnnounceDividend",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.AnnounceDividendResponse{}
+
+	makeError := func(st actions_pb.AnnounceDividendResponse_StatusCode, msg string) (*actions_pb.AnnounceDividendResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.AnnounceDividendResponse_NotAdminUserError, "User is not admin")
+	}
+
+	dividend, err := models.AnnounceDividend(req.StockId, req.DividendAmount, req.RecordDay, req.PaymentDay)
+
+	switch e := err.(type) {
+	case models.InvalidStockIdError:
+		return makeError(actions_pb.AnnounceDividendResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.StockBankruptError:
+		return makeError(actions_pb.AnnounceDividendResponse_StockBankruptError, e.Error())
+	case models.InvalidDividendError:
+		return makeError(actions_pb.AnnounceDividendResponse_InvalidDividendError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.AnnounceDividendResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Dividend = dividend.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.AnnounceDividendResponse_OK
+	return resp, nil
+}
+
+func (d *dalalActionService) LiquidateStock(ctx context.Context, req *actions_pb.LiquidateStockRequest) (*actions_pb.LiquidateStockResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "LiquidateStock",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	resp := &actions_pb.LiquidateStockResponse{}
+
+	makeError := func(st actions_pb.LiquidateStockResponse_StatusCode, msg string) (*actions_pb.LiquidateStockResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsAdminAuth(userId) {
+		return makeError(actions_pb.LiquidateStockResponse_NotAdminUserError, "User is not admin")
+	}
+
+	// the stock stops trading while its holdings are paid out, and for good after that
+	var liquidation *models.Liquidation
+	err := d.matchingEngine.RestructureStock(req.StockId, func() error {
+		var err error
+		liquidation, err = models.LiquidateStock(req.StockId, req.LiquidationValue)
+		return err
+	})
+
+	switch e := err.(type) {
+	case matchingengine.UnknownStockError, models.InvalidStockIdError:
+		return makeError(actions_pb.LiquidateStockResponse_InvalidStockIdError, "Invalid stock id provided.")
+	case models.CorporateActionError:
+		return makeError(actions_pb.LiquidateStockResponse_CorporateActionError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to %+v: ", err)
+		return makeError(actions_pb.LiquidateStockResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	if err := d.matchingEngine.RemoveStock(req.StockId); err != nil {
+		l.Errorf("Unable to remove the order book of the liquidated stock: %+v", err)
+	}
+
+	resp.Liquidation = liquidation.ToProto()
+	resp.StatusMessage = "Done"
+	resp.StatusCode = actions_pb.LiquidateStockResponse_OK
+	return resp, nil
+}
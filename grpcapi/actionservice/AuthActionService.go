@@ -140,7 +140,7 @@ func (d *dalalActionService) Login(ctx context.Context, req *actions_pb.LoginReq
 
 	l.Debugf("Session successfully set. UserId: %+v, Session id: %+v", user.Id, sess.GetID())
 
-	stockList := models.GetAllStocks()
+	stockList := models.GetListedStocks()
 	stockListProto := make(map[uint32]*models_pb.Stock)
 	for stockId, stock := range stockList {
 		stockListProto[stockId] = stock.ToProto()
@@ -163,15 +163,17 @@ func (d *dalalActionService) Login(ctx context.Context, req *actions_pb.LoginReq
 		"GET_NOTIFICATION_COUNT":  models.GET_NOTIFICATION_COUNT,
 		"GET_TRANSACTION_COUNT":   models.GET_TRANSACTION_COUNT,
 		"LEADERBOARD_COUNT":       models.LEADERBOARD_COUNT,
-		"ORDER_FEE_PERCENT":       models.ORDER_FEE_PERCENT,
+		"MAKER_FEE_BASIS_POINTS":  int32(utils.GetConfiguration().MakerFeeBasisPoints),
+		"TAKER_FEE_BASIS_POINTS":  int32(utils.GetConfiguration().TakerFeeBasisPoints),
 	}
 
 	resp = &actions_pb.LoginResponse{
-		SessionId:      sess.GetID(),
-		User:           user.ToProto(),
-		Constants:      constantsMap,
-		IsMarketOpen:   models.IsMarketOpen(),
-		VapidPublicKey: utils.GetConfiguration().PushNotificationVAPIDPublicKey,
+		SessionId:            sess.GetID(),
+		User:                 user.ToProto(),
+		Constants:            constantsMap,
+		IsMarketOpen:         models.IsMarketOpen(),
+		IsCallAuctionRunning: models.IsCallAuctionRunning(),
+		VapidPublicKey:       utils.GetConfiguration().PushNotificationVAPIDPublicKey,
 	}
 
 	l.Infof("Request completed successfully")
@@ -0,0 +1,221 @@
+package actionservice
+
+import (
+	"fmt"
+
+	"github.com/delta/dalal-street-server/models"
+	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func (d *dalalActionService) GetRightsIssues(ctx context.Context, req *actions_pb.GetRightsIssuesRequest) (*actions_pb.GetRightsIssuesResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetRightsIssues",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetRightsIssues requested")
+
+	resp := &actions_pb.GetRightsIssuesResponse{}
+
+	rightsIssues, err := models.GetOpenRightsIssues()
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetRightsIssuesResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	entitlements, err := models.GetRightsEntitlements(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetRightsIssuesResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, rightsIssue := range rightsIssues {
+		resp.RightsIssues = append(resp.RightsIssues, rightsIssue.ToProto())
+	}
+	for _, entitlement := range entitlements {
+		resp.Entitlements = append(resp.Entitlements, entitlement.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) SubscribeRightsIssue(ctx context.Context, req *actions_pb.SubscribeRightsIssueRequest) (*actions_pb.SubscribeRightsIssueResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SubscribeRightsIssue",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("SubscribeRightsIssue requested")
+
+	resp := &actions_pb.SubscribeRightsIssueResponse{}
+	makeError := func(st actions_pb.SubscribeRightsIssueResponse_StatusCode, msg string) (*actions_pb.SubscribeRightsIssueResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() {
+		return makeError(actions_pb.SubscribeRightsIssueResponse_MarketClosedError, "Market is closed. You cannot subscribe to rights issues right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.SubscribeRightsIssueResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.SubscribeRightsIssueResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	transaction, err := models.SubscribeRightsIssue(userId, req.RightsIssueId, req.StockQuantity)
+
+	switch e := err.(type) {
+	case models.InvalidRightsIssueError:
+		return makeError(actions_pb.SubscribeRightsIssueResponse_InvalidRightsIssueError, e.Error())
+	case models.NotEnoughCashError:
+		return makeError(actions_pb.SubscribeRightsIssueResponse_NotEnoughCashError, e.Error())
+	case models.StockBankruptError:
+		return makeError(actions_pb.SubscribeRightsIssueResponse_StockBankruptError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.SubscribeRightsIssueResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Transaction = transaction.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) GetBuybacks(ctx context.Context, req *actions_pb.GetBuybacksRequest) (*actions_pb.GetBuybacksResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetBuybacks",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetBuybacks requested")
+
+	resp := &actions_pb.GetBuybacksResponse{}
+
+	buybacks, err := models.GetOpenBuybacks()
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetBuybacksResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	tenders, err := models.GetBuybackTenders(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetBuybacksResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, buyback := range buybacks {
+		resp.Buybacks = append(resp.Buybacks, buyback.ToProto())
+	}
+	for _, tender := range tenders {
+		resp.Tenders = append(resp.Tenders, tender.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) TenderForBuyback(ctx context.Context, req *actions_pb.TenderForBuybackRequest) (*actions_pb.TenderForBuybackResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "TenderForBuyback",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("TenderForBuyback requested")
+
+	resp := &actions_pb.TenderForBuybackResponse{}
+	makeError := func(st actions_pb.TenderForBuybackResponse_StatusCode, msg string) (*actions_pb.TenderForBuybackResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() {
+		return makeError(actions_pb.TenderForBuybackResponse_MarketClosedError, "Market is closed. You cannot tender shares right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.TenderForBuybackResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.TenderForBuybackResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	transaction, err := models.TenderForBuyback(userId, req.BuybackId, req.StockQuantity)
+
+	switch e := err.(type) {
+	case models.InvalidBuybackError:
+		return makeError(actions_pb.TenderForBuybackResponse_InvalidBuybackError, e.Error())
+	case models.NotEnoughStocksError:
+		return makeError(actions_pb.TenderForBuybackResponse_NotEnoughStocksError, e.Error())
+	case models.StockBankruptError:
+		return makeError(actions_pb.TenderForBuybackResponse_StockBankruptError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.TenderForBuybackResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Transaction = transaction.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) GetDividendHistory(ctx context.Context, req *actions_pb.GetDividendHistoryRequest) (*actions_pb.GetDividendHistoryResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetDividendHistory",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetDividendHistory requested")
+
+	resp := &actions_pb.GetDividendHistoryResponse{}
+
+	dividends, yields, err := models.GetDividendHistory(req.StockId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetDividendHistoryResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, dividend := range dividends {
+		resp.Dividends = append(resp.Dividends, dividend.ToProto())
+	}
+	resp.DividendYields = yields
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
@@ -0,0 +1,67 @@
+package actionservice
+
+import (
+	"fmt"
+
+	"github.com/delta/dalal-street-server/models"
+	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func (d *dalalActionService) GetFutures(ctx context.Context, req *actions_pb.GetFuturesRequest) (*actions_pb.GetFuturesResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetFutures",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetFutures requested")
+
+	resp := &actions_pb.GetFuturesResponse{}
+
+	futures, err := models.GetOpenFutures()
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetFuturesResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, future := range futures {
+		resp.Futures = append(resp.Futures, future.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) GetFuturePositions(ctx context.Context, req *actions_pb.GetFuturePositionsRequest) (*actions_pb.GetFuturePositionsResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetFuturePositions",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetFuturePositions requested")
+
+	resp := &actions_pb.GetFuturePositionsResponse{}
+
+	userId := getUserId(ctx)
+	positions, err := models.GetFuturePositions(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetFuturePositionsResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, position := range positions {
+		resp.Positions = append(resp.Positions, position.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
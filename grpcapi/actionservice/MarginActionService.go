@@ -0,0 +1,225 @@
+package actionservice
+
+import (
+	"fmt"
+
+	"github.com/delta/dalal-street-server/models"
+	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func (d *dalalActionService) OpenMarginAccount(ctx context.Context, req *actions_pb.OpenMarginAccountRequest) (*actions_pb.OpenMarginAccountResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "OpenMarginAccount",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("OpenMarginAccount requested")
+
+	resp := &actions_pb.OpenMarginAccountResponse{}
+	makeError := func(st actions_pb.OpenMarginAccountResponse_StatusCode, msg string) (*actions_pb.OpenMarginAccountResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.OpenMarginAccountResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.OpenMarginAccountResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	account, err := models.OpenMarginAccount(userId)
+
+	switch e := err.(type) {
+	case models.MarginAccountAlreadyOpenError:
+		return makeError(actions_pb.OpenMarginAccountResponse_MarginAccountAlreadyOpenError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.OpenMarginAccountResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.MarginAccount = account.ToProto()
+	resp.StatusMessage = "Margin account opened"
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) CloseMarginAccount(ctx context.Context, req *actions_pb.CloseMarginAccountRequest) (*actions_pb.CloseMarginAccountResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "CloseMarginAccount",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("CloseMarginAccount requested")
+
+	resp := &actions_pb.CloseMarginAccountResponse{}
+	makeError := func(st actions_pb.CloseMarginAccountResponse_StatusCode, msg string) (*actions_pb.CloseMarginAccountResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+
+	err := models.CloseMarginAccount(userId)
+
+	switch e := err.(type) {
+	case models.MarginAccountNotOpenError:
+		return makeError(actions_pb.CloseMarginAccountResponse_MarginAccountNotOpenError, e.Error())
+	case models.MarginLoanOutstandingError:
+		return makeError(actions_pb.CloseMarginAccountResponse_MarginLoanOutstandingError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.CloseMarginAccountResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.StatusMessage = "Margin account closed"
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) BorrowOnMargin(ctx context.Context, req *actions_pb.BorrowOnMarginRequest) (*actions_pb.BorrowOnMarginResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "BorrowOnMargin",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("BorrowOnMargin requested")
+
+	resp := &actions_pb.BorrowOnMarginResponse{}
+	makeError := func(st actions_pb.BorrowOnMarginResponse_StatusCode, msg string) (*actions_pb.BorrowOnMarginResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() {
+		return makeError(actions_pb.BorrowOnMarginResponse_MarketClosedError, "Market is closed. You cannot borrow right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.BorrowOnMarginResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.BorrowOnMarginResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	if req.Amount == 0 {
+		return makeError(actions_pb.BorrowOnMarginResponse_InvalidAmountError, "You must borrow more than 0.")
+	}
+
+	transaction, err := models.BorrowOnMargin(userId, req.Amount)
+
+	switch e := err.(type) {
+	case models.MarginAccountNotOpenError:
+		return makeError(actions_pb.BorrowOnMarginResponse_MarginAccountNotOpenError, e.Error())
+	case models.MarginLimitExceededError:
+		return makeError(actions_pb.BorrowOnMarginResponse_MarginLimitExceededError, e.Error())
+	case models.MarginCallError:
+		return makeError(actions_pb.BorrowOnMarginResponse_MarginCallError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.BorrowOnMarginResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Transaction = transaction.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) RepayMarginLoan(ctx context.Context, req *actions_pb.RepayMarginLoanRequest) (*actions_pb.RepayMarginLoanResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "RepayMarginLoan",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("RepayMarginLoan requested")
+
+	resp := &actions_pb.RepayMarginLoanResponse{}
+	makeError := func(st actions_pb.RepayMarginLoanResponse_StatusCode, msg string) (*actions_pb.RepayMarginLoanResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+
+	if req.Amount == 0 {
+		return makeError(actions_pb.RepayMarginLoanResponse_InvalidAmountError, "You must repay more than 0.")
+	}
+
+	transaction, err := models.RepayMarginLoan(userId, req.Amount)
+
+	switch e := err.(type) {
+	case models.MarginAccountNotOpenError:
+		return makeError(actions_pb.RepayMarginLoanResponse_MarginAccountNotOpenError, e.Error())
+	case models.NotEnoughCashError:
+		return makeError(actions_pb.RepayMarginLoanResponse_NotEnoughCashError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.RepayMarginLoanResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Transaction = transaction.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) GetMarginAccount(ctx context.Context, req *actions_pb.GetMarginAccountRequest) (*actions_pb.GetMarginAccountResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetMarginAccount",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetMarginAccount requested")
+
+	resp := &actions_pb.GetMarginAccountResponse{}
+	makeError := func(st actions_pb.GetMarginAccountResponse_StatusCode, msg string) (*actions_pb.GetMarginAccountResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+
+	account, err := models.GetMarginAccount(userId)
+
+	switch e := err.(type) {
+	case models.MarginAccountNotOpenError:
+		return makeError(actions_pb.GetMarginAccountResponse_MarginAccountNotOpenError, e.Error())
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.GetMarginAccountResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.MarginAccount = account.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
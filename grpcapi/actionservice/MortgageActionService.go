@@ -50,6 +50,8 @@ func (d *dalalActionService) MortgageStocks(ctx context.Context, req *actions_pb
 		return makeError(actions_pb.MortgageStocksResponse_NotEnoughStocksError, e.Error())
 	case models.StockBankruptError:
 		return makeError(actions_pb.MortgageStocksResponse_StockBankruptError, e.Error())
+	case models.StockDelistedError:
+		return makeError(actions_pb.MortgageStocksResponse_StockDelistedError, e.Error())
 	}
 	if err != nil {
 		l.Errorf("Request failed due to: %+v", err)
@@ -106,6 +108,8 @@ func (d *dalalActionService) RetrieveMortgageStocks(ctx context.Context, req *ac
 		return makeError(actions_pb.RetrieveMortgageStocksResponse_InvalidRetrievePriceError, e.Error())
 	case models.StockBankruptError:
 		return makeError(actions_pb.RetrieveMortgageStocksResponse_StockBankruptError, e.Error())
+	case models.StockDelistedError:
+		return makeError(actions_pb.RetrieveMortgageStocksResponse_StockDelistedError, e.Error())
 	}
 	if err != nil {
 		l.Errorf("Request failed due to %+v: ", err)
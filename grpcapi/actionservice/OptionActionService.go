@@ -0,0 +1,67 @@
+package actionservice
+
+import (
+	"fmt"
+
+	"github.com/delta/dalal-street-server/models"
+	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func (d *dalalActionService) GetOptions(ctx context.Context, req *actions_pb.GetOptionsRequest) (*actions_pb.GetOptionsResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOptions",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetOptions requested")
+
+	resp := &actions_pb.GetOptionsResponse{}
+
+	options, err := models.GetOpenOptions()
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetOptionsResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, option := range options {
+		resp.Options = append(resp.Options, option.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) GetOptionPositions(ctx context.Context, req *actions_pb.GetOptionPositionsRequest) (*actions_pb.GetOptionPositionsResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOptionPositions",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetOptionPositions requested")
+
+	resp := &actions_pb.GetOptionPositionsResponse{}
+
+	userId := getUserId(ctx)
+	positions, err := models.GetOptionPositions(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetOptionPositionsResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	for _, position := range positions {
+		resp.Positions = append(resp.Positions, position.ToProto())
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
@@ -24,7 +24,7 @@ func (d *dalalActionService) CancelOrder(ctx context.Context, req *actions_pb.Ca
 		return resp, nil
 	}
 
-	if !models.IsMarketOpen() {
+	if !models.IsMarketOpen() && !models.IsCallAuctionRunning() {
 		return makeError(actions_pb.CancelOrderResponse_MarketClosedError, "Market is closed. You cannot cancel orders right now.")
 	}
 
@@ -66,6 +66,66 @@ func (d *dalalActionService) CancelOrder(ctx context.Context, req *actions_pb.Ca
 	return resp, nil
 }
 
+func (d *dalalActionService) ModifyOrder(ctx context.Context, req *actions_pb.ModifyOrderRequest) (*actions_pb.ModifyOrderResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "ModifyOrder",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+	l.Infof("ModifyOrder requested")
+
+	resp := &actions_pb.ModifyOrderResponse{}
+	makeError := func(st actions_pb.ModifyOrderResponse_StatusCode, msg string) (*actions_pb.ModifyOrderResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() && !models.IsCallAuctionRunning() {
+		return makeError(actions_pb.ModifyOrderResponse_MarketClosedError, "Market is closed. You cannot modify orders right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.ModifyOrderResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.ModifyOrderResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	// the order is modified by the matching engine, so that it doesn't get matched meanwhile
+	err := d.matchingEngine.ModifyOrder(userId, req.OrderId, req.IsAsk, req.Price, req.StockQuantity)
+
+	switch e := err.(type) {
+	case models.InvalidOrderIDError:
+		return makeError(actions_pb.ModifyOrderResponse_InvalidOrderId, "Invalid Order ID. Cannot modify this order.")
+	case models.OrderNotModifiableError:
+		return makeError(actions_pb.ModifyOrderResponse_OrderNotModifiableError, e.Error())
+	case models.OrderStockLimitExceeded:
+		return makeError(actions_pb.ModifyOrderResponse_StockQuantityLimitExceeded, e.Error())
+	case models.MinimumPriceThresholdError:
+		return makeError(actions_pb.ModifyOrderResponse_OrderPriceOutOfWindowError, e.Error())
+	case models.OrderPriceOutOfWindowError:
+		return makeError(actions_pb.ModifyOrderResponse_OrderPriceOutOfWindowError, e.Error())
+	case models.NotEnoughStocksError:
+		return makeError(actions_pb.ModifyOrderResponse_NotEnoughStocksError, e.Error())
+	case models.NotEnoughCashError:
+		return makeError(actions_pb.ModifyOrderResponse_NotEnoughCashError, e.Error())
+	case models.StockHaltedError:
+		return makeError(actions_pb.ModifyOrderResponse_StockHaltedError, e.Error())
+	}
+
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.ModifyOrderResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
 func (d *dalalActionService) PlaceOrder(ctx context.Context, req *actions_pb.PlaceOrderRequest) (*actions_pb.PlaceOrderResponse, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "PlaceOrder",
@@ -82,7 +142,7 @@ func (d *dalalActionService) PlaceOrder(ctx context.Context, req *actions_pb.Pla
 		return resp, nil
 	}
 
-	if !models.IsMarketOpen() {
+	if !models.IsMarketOpen() && !models.IsCallAuctionRunning() {
 		return makeError(actions_pb.PlaceOrderResponse_MarketClosedError, "Market Is closed. You cannot place orders right now.")
 	}
 
@@ -95,30 +155,70 @@ func (d *dalalActionService) PlaceOrder(ctx context.Context, req *actions_pb.Pla
 		return makeError(actions_pb.PlaceOrderResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
 	}
 
+	// options have no call auctions of their own
+	if req.OptionId != 0 && !models.IsMarketOpen() {
+		return makeError(actions_pb.PlaceOrderResponse_MarketClosedError, "Market Is closed. You cannot place orders for options right now.")
+	}
+
+	// neither do futures
+	if req.FutureId != 0 && !models.IsMarketOpen() {
+		return makeError(actions_pb.PlaceOrderResponse_MarketClosedError, "Market Is closed. You cannot place orders for futures right now.")
+	}
+
 	var orderId uint32
 	var err error
 
 	if req.IsAsk {
 		ask := &models.Ask{
-			UserId:        userId,
-			StockId:       req.StockId,
-			OrderType:     models.OrderTypeFromProto(req.OrderType),
-			Price:         req.Price,
-			StockQuantity: req.StockQuantity,
+			UserId:          userId,
+			StockId:         req.StockId,
+			OptionId:        req.OptionId,
+			FutureId:        req.FutureId,
+			OrderType:       models.OrderTypeFromProto(req.OrderType),
+			Price:           req.Price,
+			StockQuantity:   req.StockQuantity,
+			TimeInForce:     models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:    req.ExpiresOnDay,
+			IsIceberg:       req.IsIceberg,
+			DisplayQuantity: req.DisplayQuantity,
+			TrailingAmount:  req.TrailingAmount,
+			TrailingPercent: req.TrailingPercent,
+			LimitPrice:      req.LimitPrice,
+		}
+		if ask.FutureId != 0 {
+			orderId, err = models.PlaceFutureAskOrder(userId, ask)
+		} else if ask.OptionId != 0 {
+			orderId, err = models.PlaceOptionAskOrder(userId, ask)
+		} else {
+			orderId, err = models.PlaceAskOrder(userId, ask)
 		}
-		orderId, err = models.PlaceAskOrder(userId, ask)
 		if err == nil {
 			go d.matchingEngine.AddAskOrder(ask)
 		}
 	} else {
 		bid := &models.Bid{
-			UserId:        userId,
-			StockId:       req.StockId,
-			OrderType:     models.OrderTypeFromProto(req.OrderType),
-			Price:         req.Price,
-			StockQuantity: req.StockQuantity,
+			UserId:          userId,
+			StockId:         req.StockId,
+			OptionId:        req.OptionId,
+			FutureId:        req.FutureId,
+			OrderType:       models.OrderTypeFromProto(req.OrderType),
+			Price:           req.Price,
+			StockQuantity:   req.StockQuantity,
+			TimeInForce:     models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:    req.ExpiresOnDay,
+			IsIceberg:       req.IsIceberg,
+			DisplayQuantity: req.DisplayQuantity,
+			TrailingAmount:  req.TrailingAmount,
+			TrailingPercent: req.TrailingPercent,
+			LimitPrice:      req.LimitPrice,
+		}
+		if bid.FutureId != 0 {
+			orderId, err = models.PlaceFutureBidOrder(userId, bid)
+		} else if bid.OptionId != 0 {
+			orderId, err = models.PlaceOptionBidOrder(userId, bid)
+		} else {
+			orderId, err = models.PlaceBidOrder(userId, bid)
 		}
-		orderId, err = models.PlaceBidOrder(userId, bid)
 		if err == nil {
 			go d.matchingEngine.AddBidOrder(bid)
 		}
@@ -135,6 +235,26 @@ func (d *dalalActionService) PlaceOrder(ctx context.Context, req *actions_pb.Pla
 		return makeError(actions_pb.PlaceOrderResponse_NotEnoughCashError, e.Error())
 	case models.StockBankruptError:
 		return makeError(actions_pb.PlaceOrderResponse_StockBankruptError, err.Error())
+	case models.StockDelistedError:
+		return makeError(actions_pb.PlaceOrderResponse_StockDelistedError, e.Error())
+	case models.StockHaltedError:
+		return makeError(actions_pb.PlaceOrderResponse_StockHaltedError, e.Error())
+	case models.InvalidTimeInForceError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidTimeInForceError, e.Error())
+	case models.InvalidIcebergOrderError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidIcebergOrderError, e.Error())
+	case models.InvalidTrailingStopError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidTrailingStopError, e.Error())
+	case models.InvalidStopLimitOrderError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidStopLimitOrderError, e.Error())
+	case models.InvalidOptionError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidOptionError, e.Error())
+	case models.InvalidOptionOrderError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidOptionOrderError, e.Error())
+	case models.NotEnoughOptionsError:
+		return makeError(actions_pb.PlaceOrderResponse_NotEnoughOptionsError, e.Error())
+	case models.InvalidFutureError:
+		return makeError(actions_pb.PlaceOrderResponse_InvalidFutureError, e.Error())
 	}
 
 	if err != nil {
@@ -149,6 +269,141 @@ func (d *dalalActionService) PlaceOrder(ctx context.Context, req *actions_pb.Pla
 	return resp, nil
 }
 
+func (d *dalalActionService) PlaceOcoOrder(ctx context.Context, req *actions_pb.PlaceOcoOrderRequest) (*actions_pb.PlaceOcoOrderResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "PlaceOcoOrder",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("PlaceOcoOrder requested")
+
+	resp := &actions_pb.PlaceOcoOrderResponse{}
+	makeError := func(st actions_pb.PlaceOcoOrderResponse_StatusCode, msg string) (*actions_pb.PlaceOcoOrderResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() && !models.IsCallAuctionRunning() {
+		return makeError(actions_pb.PlaceOcoOrderResponse_MarketClosedError, "Market Is closed. You cannot place orders right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.PlaceOcoOrderResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.PlaceOcoOrderResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	// the protective leg is a stop-limit order if a limit price is given, and a stoploss otherwise
+	stopOrderType := models.StopLoss
+	if req.StopLimitPrice > 0 {
+		stopOrderType = models.StopLimit
+	}
+
+	var groupId uint32
+	var err error
+
+	if req.IsAsk {
+		takeProfit := &models.Ask{
+			UserId:        userId,
+			StockId:       req.StockId,
+			OrderType:     models.Limit,
+			Price:         req.TakeProfitPrice,
+			StockQuantity: req.StockQuantity,
+			TimeInForce:   models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:  req.ExpiresOnDay,
+		}
+		stop := &models.Ask{
+			UserId:        userId,
+			StockId:       req.StockId,
+			OrderType:     stopOrderType,
+			Price:         req.StopPrice,
+			LimitPrice:    req.StopLimitPrice,
+			StockQuantity: req.StockQuantity,
+			TimeInForce:   models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:  req.ExpiresOnDay,
+		}
+		groupId, err = models.PlaceAskOcoOrder(userId, takeProfit, stop)
+		if err == nil {
+			go func() {
+				d.matchingEngine.AddAskOrder(takeProfit)
+				d.matchingEngine.AddAskOrder(stop)
+			}()
+			resp.TakeProfitOrderId = takeProfit.Id
+			resp.StopOrderId = stop.Id
+		}
+	} else {
+		takeProfit := &models.Bid{
+			UserId:        userId,
+			StockId:       req.StockId,
+			OrderType:     models.Limit,
+			Price:         req.TakeProfitPrice,
+			StockQuantity: req.StockQuantity,
+			TimeInForce:   models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:  req.ExpiresOnDay,
+		}
+		stop := &models.Bid{
+			UserId:        userId,
+			StockId:       req.StockId,
+			OrderType:     stopOrderType,
+			Price:         req.StopPrice,
+			LimitPrice:    req.StopLimitPrice,
+			StockQuantity: req.StockQuantity,
+			TimeInForce:   models.TimeInForceFromProto(req.TimeInForce),
+			ExpiresOnDay:  req.ExpiresOnDay,
+		}
+		groupId, err = models.PlaceBidOcoOrder(userId, takeProfit, stop)
+		if err == nil {
+			go func() {
+				d.matchingEngine.AddBidOrder(takeProfit)
+				d.matchingEngine.AddBidOrder(stop)
+			}()
+			resp.TakeProfitOrderId = takeProfit.Id
+			resp.StopOrderId = stop.Id
+		}
+	}
+
+	switch e := err.(type) {
+	case models.OrderStockLimitExceeded:
+		return makeError(actions_pb.PlaceOcoOrderResponse_StockQuantityLimitExceeded, e.Error())
+	case models.MinimumPriceThresholdError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_OrderPriceOutOfWindowError, e.Error())
+	case models.OrderPriceOutOfWindowError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_OrderPriceOutOfWindowError, e.Error())
+	case models.NotEnoughStocksError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_NotEnoughStocksError, e.Error())
+	case models.NotEnoughCashError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_NotEnoughCashError, e.Error())
+	case models.StockBankruptError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_StockBankruptError, e.Error())
+	case models.StockDelistedError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_StockDelistedError, e.Error())
+	case models.StockHaltedError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_StockHaltedError, e.Error())
+	case models.InvalidTimeInForceError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_InvalidTimeInForceError, e.Error())
+	case models.InvalidStopLimitOrderError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_InvalidOcoOrderError, e.Error())
+	case models.InvalidOrderGroupError:
+		return makeError(actions_pb.PlaceOcoOrderResponse_InvalidOcoOrderError, e.Error())
+	}
+
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.PlaceOcoOrderResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.GroupId = groupId
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
 func (d *dalalActionService) PlaceIpoBid(ctx context.Context, req *actions_pb.PlaceIpoBidRequest) (*actions_pb.PlaceIpoBidResponse, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "PlaceIpoBid",
@@ -0,0 +1,102 @@
+package actionservice
+
+import (
+	"fmt"
+
+	"github.com/delta/dalal-street-server/models"
+	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func (d *dalalActionService) GetShortSellLends(ctx context.Context, req *actions_pb.GetShortSellLendsRequest) (*actions_pb.GetShortSellLendsResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetShortSellLends",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetShortSellLends requested")
+
+	resp := &actions_pb.GetShortSellLendsResponse{}
+
+	userId := getUserId(ctx)
+	lends, err := models.GetShortSellLends(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetShortSellLendsResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	// the borrow fee the lends would be charged for the market day
+	resp.BorrowFeeBasisPoints = make(map[uint32]uint64)
+	for i := range lends {
+		resp.Lends = append(resp.Lends, lends[i].ToProto())
+
+		if _, ok := resp.BorrowFeeBasisPoints[lends[i].StockId]; ok {
+			continue
+		}
+		fee, err := models.GetBorrowFeeBasisPoints(lends[i].StockId)
+		if err != nil {
+			l.Errorf("Request failed due to: %+v", err)
+			resp.StatusCode = actions_pb.GetShortSellLendsResponse_InternalServerError
+			resp.StatusMessage = getInternalErrorMessage(err)
+			return resp, nil
+		}
+		resp.BorrowFeeBasisPoints[lends[i].StockId] = fee
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
+func (d *dalalActionService) CoverShortSell(ctx context.Context, req *actions_pb.CoverShortSellRequest) (*actions_pb.CoverShortSellResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "CoverShortSell",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("CoverShortSell requested")
+
+	resp := &actions_pb.CoverShortSellResponse{}
+	makeError := func(st actions_pb.CoverShortSellResponse_StatusCode, msg string) (*actions_pb.CoverShortSellResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	if !models.IsMarketOpen() {
+		return makeError(actions_pb.CoverShortSellResponse_MarketClosedError, "Market is closed. You cannot cover short sells right now.")
+	}
+
+	userId := getUserId(ctx)
+	if !models.IsUserPhoneVerified(userId) {
+		return makeError(actions_pb.CoverShortSellResponse_UserNotPhoneVerfiedError, "Your phone number has not been verified. Please verify phone number in order to play the game.")
+	}
+
+	if models.IsUserBlocked(userId) {
+		return makeError(actions_pb.CoverShortSellResponse_UserBlockedError, "Your account has been blocked due to malpractice.")
+	}
+
+	transaction, err := models.CoverShortSell(userId, req.LendId)
+
+	switch e := err.(type) {
+	case models.InvalidShortSellLendError:
+		return makeError(actions_pb.CoverShortSellResponse_InvalidLendIdError, e.Error())
+	case models.NotEnoughStocksError:
+		return makeError(actions_pb.CoverShortSellResponse_NotEnoughStocksError, "Buy the stocks lent to you before covering the short sell.")
+	}
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.CoverShortSellResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.Transaction = transaction.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
@@ -52,6 +52,8 @@ func (d *dalalActionService) BuyStocksFromExchange(ctx context.Context, req *act
 		return makeError(actions_pb.BuyStocksFromExchangeResponse_NotEnoughStocksError, e.Error())
 	case models.StockBankruptError:
 		return makeError(actions_pb.BuyStocksFromExchangeResponse_StockBankruptError, e.Error())
+	case models.StockDelistedError:
+		return makeError(actions_pb.BuyStocksFromExchangeResponse_StockDelistedError, e.Error())
 	}
 
 	if err != nil {
@@ -97,6 +99,37 @@ func (d *dalalActionService) GetStockHistory(ctx context.Context, req *actions_p
 	return resp, nil
 }
 
+func (d *dalalActionService) GetMarketIndex(ctx context.Context, req *actions_pb.GetMarketIndexRequest) (*actions_pb.GetMarketIndexResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetMarketIndex",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetMarketIndex requested")
+
+	resp := &actions_pb.GetMarketIndexResponse{}
+
+	histories, err := models.GetMarketIndexHistory(models.ResolutionFromProto(req.GetResolution()))
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetMarketIndexResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	resp.IndexName = models.GetMarketIndexName()
+	resp.Value = models.GetMarketIndexValue()
+	resp.MarketIndexHistoryMap = make(map[string]*models_pb.MarketIndexHistory)
+	for _, history := range histories {
+		resp.MarketIndexHistoryMap[history.CreatedAt] = history.ToProto()
+	}
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
 func (d *dalalActionService) GetCompanyProfile(ctx context.Context, req *actions_pb.GetCompanyProfileRequest) (*actions_pb.GetCompanyProfileResponse, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "GetCompanyProfile",
@@ -133,7 +166,7 @@ func (d *dalalActionService) GetStockList(ctx context.Context, req *actions_pb.G
 
 	resp := &actions_pb.GetStockListResponse{}
 
-	stockList := models.GetAllStocks()
+	stockList := models.GetListedStocks()
 	stockListProto := make(map[uint32]*models_pb.Stock)
 
 	for stockId, stock := range stockList {
@@ -190,3 +223,50 @@ func (d *dalalActionService) GetIpoStockList(ctx context.Context, req *actions_p
 
 	return resp, nil
 }
+
+func (d *dalalActionService) GetFeeSchedule(ctx context.Context, req *actions_pb.GetFeeScheduleRequest) (*actions_pb.GetFeeScheduleResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetFeeSchedule",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetFeeSchedule requested")
+
+	resp := &actions_pb.GetFeeScheduleResponse{}
+	makeError := func(st actions_pb.GetFeeScheduleResponse_StatusCode, msg string) (*actions_pb.GetFeeScheduleResponse, error) {
+		resp.StatusCode = st
+		resp.StatusMessage = msg
+		return resp, nil
+	}
+
+	userId := getUserId(ctx)
+	feeSchedule, err := models.GetFeeSchedule(userId, req.GetStockId())
+
+	if err == models.InvalidStockError {
+		return makeError(actions_pb.GetFeeScheduleResponse_InvalidStockIdError, "Invalid stock id provided.")
+	}
+
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		return makeError(actions_pb.GetFeeScheduleResponse_InternalServerError, getInternalErrorMessage(err))
+	}
+
+	resp.MakerFeeBasisPoints = feeSchedule.MakerFeeBasisPoints
+	resp.TakerFeeBasisPoints = feeSchedule.TakerFeeBasisPoints
+	resp.DayVolume = feeSchedule.DayVolume
+	resp.DiscountPercent = feeSchedule.DiscountPercent
+	for _, tier := range feeSchedule.Tiers {
+		resp.Tiers = append(resp.Tiers, &actions_pb.FeeTier{
+			MinVolume:       tier.MinVolume,
+			DiscountPercent: tier.DiscountPercent,
+		})
+	}
+
+	resp.StatusCode = actions_pb.GetFeeScheduleResponse_OK
+	resp.StatusMessage = "Success"
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
@@ -165,6 +165,34 @@ func (d *dalalActionService) GetTransactions(ctx context.Context, req *actions_p
 	return resp, nil
 }
 
+func (d *dalalActionService) GetTaxReport(ctx context.Context, req *actions_pb.GetTaxReportRequest) (*actions_pb.GetTaxReportResponse, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetTaxReport",
+		"param_session": fmt.Sprintf("%+v", ctx.Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+
+	l.Infof("GetTaxReport requested")
+
+	resp := &actions_pb.GetTaxReportResponse{}
+
+	userId := getUserId(ctx)
+
+	report, err := models.GetTaxReport(userId)
+	if err != nil {
+		l.Errorf("Request failed due to: %+v", err)
+		resp.StatusCode = actions_pb.GetTaxReportResponse_InternalServerError
+		resp.StatusMessage = getInternalErrorMessage(err)
+		return resp, nil
+	}
+
+	resp.TaxReport = report.ToProto()
+
+	l.Infof("Request completed successfully")
+
+	return resp, nil
+}
+
 func (d *dalalActionService) GetLeaderboard(ctx context.Context, req *actions_pb.GetLeaderboardRequest) (*actions_pb.GetLeaderboardResponse, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "GetLeaderboard",
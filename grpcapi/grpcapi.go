@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/sirupsen/logrus"
@@ -21,6 +22,7 @@ import (
 	"github.com/delta/dalal-street-server/grpcapi/actionservice"
 	"github.com/delta/dalal-street-server/grpcapi/streamservice"
 	"github.com/delta/dalal-street-server/matchingengine"
+	"github.com/delta/dalal-street-server/metrics"
 	pb "github.com/delta/dalal-street-server/proto_build"
 	actions_pb "github.com/delta/dalal-street-server/proto_build/actions"
 	"github.com/delta/dalal-street-server/session"
@@ -33,8 +35,35 @@ var (
 
 	grpcServer    *grpc.Server
 	wrappedServer *grpcweb.WrappedGrpcServer
+
+	rpcDuration = metrics.NewHistogramVec("dalal_grpc_request_duration_seconds", "Time taken to handle a gRPC request. Streams are timed till they end.", nil, "method")
+	rpcErrors   = metrics.NewCounterVec("dalal_grpc_request_errors_total", "Number of gRPC requests that failed, by status code.", "method", "code")
 )
 
+// observeRPC records how long a request took, and its status code if it failed
+func observeRPC(method string, start time.Time, err error) {
+	rpcDuration.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		rpcErrors.Inc(method, status.Code(err).String())
+	}
+}
+
+// unaryMetricsInterceptor times every request, and counts the ones that failed
+func unaryMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// streamMetricsInterceptor times every stream, and counts the ones that failed
+func streamMetricsInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
 func authFunc(ctx context.Context) (context.Context, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "authFunc",
@@ -206,10 +235,12 @@ func Init(conf *utils.Config, matchingEngine matchingengine.MatchingEngine, dsm
 	grpcServer = grpc.NewServer(
 		grpc.Creds(creds),
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			streamMetricsInterceptor,
 			streamAuthInterceptor, // all streams expect StockPrices, MarketEvents require authentication
 			grpc_recovery.StreamServerInterceptor(),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+			unaryMetricsInterceptor,
 			unaryAuthInterceptor, // all routes except Login require authentication
 			grpc_recovery.UnaryServerInterceptor(),
 		)),
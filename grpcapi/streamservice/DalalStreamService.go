@@ -54,6 +54,8 @@ func NewDalalStreamService(dsm datastreams.Manager) pb.DalalStreamServiceServer
 
 	types := []datastreams_pb.DataStreamType{
 		datastreams_pb.DataStreamType_MARKET_DEPTH,
+		datastreams_pb.DataStreamType_OPTION_MARKET_DEPTH,
+		datastreams_pb.DataStreamType_FUTURE_MARKET_DEPTH,
 		datastreams_pb.DataStreamType_MARKET_EVENTS,
 		datastreams_pb.DataStreamType_MY_ORDERS,
 		datastreams_pb.DataStreamType_NOTIFICATIONS,
@@ -62,6 +64,7 @@ func NewDalalStreamService(dsm datastreams.Manager) pb.DalalStreamServiceServer
 		datastreams_pb.DataStreamType_TRANSACTIONS,
 		datastreams_pb.DataStreamType_STOCK_HISTORY,
 		datastreams_pb.DataStreamType_GAME_STATE,
+		datastreams_pb.DataStreamType_MARKET_INDEX,
 	}
 
 	for _, t := range types {
@@ -53,6 +53,94 @@ loop:
 	return nil
 }
 
+func (d *dalalStreamService) GetOptionMarketDepthUpdates(req *datastreams_pb.SubscriptionId, stream pb.DalalStreamService_GetOptionMarketDepthUpdatesServer) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOptionMarketDepthUpdates",
+		"param_session": fmt.Sprintf("%+v", stream.Context().Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+	l.Infof("GetOptionMarketDepthUpdates requested")
+
+	subscription, err := d.getSubscription(req, datastreams_pb.DataStreamType_OPTION_MARKET_DEPTH)
+	if err != nil {
+		return err
+	}
+
+	subscribeReq := subscription.subscribeReq
+	done := subscription.doneChan
+	updates := make(chan interface{})
+
+	optionId, _ := strconv.ParseUint(subscribeReq.DataStreamId, 10, 32)
+
+	depthStream := d.datastreamsManager.GetOptionMarketDepthStream(uint32(optionId))
+	depthStream.AddListener(done, updates, req.Id)
+
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-stream.Context().Done():
+			d.removeSubscriptionFromMap(req)
+			close(done)
+			break loop
+		case update := <-updates:
+			err := stream.Send(update.(*datastreams_pb.MarketDepthUpdate))
+			if err != nil {
+				// log the error
+				break
+			}
+		}
+	}
+	l.Infof("Request completed successfully")
+
+	return nil
+}
+
+func (d *dalalStreamService) GetFutureMarketDepthUpdates(req *datastreams_pb.SubscriptionId, stream pb.DalalStreamService_GetFutureMarketDepthUpdatesServer) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetFutureMarketDepthUpdates",
+		"param_session": fmt.Sprintf("%+v", stream.Context().Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+	l.Infof("GetFutureMarketDepthUpdates requested")
+
+	subscription, err := d.getSubscription(req, datastreams_pb.DataStreamType_FUTURE_MARKET_DEPTH)
+	if err != nil {
+		return err
+	}
+
+	subscribeReq := subscription.subscribeReq
+	done := subscription.doneChan
+	updates := make(chan interface{})
+
+	futureId, _ := strconv.ParseUint(subscribeReq.DataStreamId, 10, 32)
+
+	depthStream := d.datastreamsManager.GetFutureMarketDepthStream(uint32(futureId))
+	depthStream.AddListener(done, updates, req.Id)
+
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-stream.Context().Done():
+			d.removeSubscriptionFromMap(req)
+			close(done)
+			break loop
+		case update := <-updates:
+			err := stream.Send(update.(*datastreams_pb.MarketDepthUpdate))
+			if err != nil {
+				// log the error
+				break
+			}
+		}
+	}
+	l.Infof("Request completed successfully")
+
+	return nil
+}
+
 func (d *dalalStreamService) GetMarketEventUpdates(req *datastreams_pb.SubscriptionId, stream pb.DalalStreamService_GetMarketEventUpdatesServer) error {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "GetMarketEventUpdates",
@@ -93,3 +181,44 @@ loop:
 
 	return nil
 }
+
+func (d *dalalStreamService) GetMarketIndexUpdates(req *datastreams_pb.SubscriptionId, stream pb.DalalStreamService_GetMarketIndexUpdatesServer) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetMarketIndexUpdates",
+		"param_session": fmt.Sprintf("%+v", stream.Context().Value("session")),
+		"param_req":     fmt.Sprintf("%+v", req),
+	})
+	l.Infof("GetMarketIndexUpdates requested")
+
+	subscription, err := d.getSubscription(req, datastreams_pb.DataStreamType_MARKET_INDEX)
+	if err != nil {
+		return err
+	}
+
+	done := subscription.doneChan
+	updates := make(chan interface{})
+
+	marketIndexStream := d.datastreamsManager.GetMarketIndexStream()
+	marketIndexStream.AddListener(done, updates, req.Id)
+
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-stream.Context().Done():
+			d.removeSubscriptionFromMap(req)
+			close(done)
+			break loop
+		case update := <-updates:
+			err := stream.Send(update.(*datastreams_pb.MarketIndexUpdate))
+			if err != nil {
+				// log the error
+				break
+			}
+		}
+	}
+	l.Infof("Request completed successfully")
+
+	return nil
+}
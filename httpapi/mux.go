@@ -2,6 +2,8 @@ package httpapi
 
 import (
 	"net/http"
+
+	"github.com/delta/dalal-street-server/metrics"
 )
 
 var HttpMux *http.ServeMux
@@ -12,6 +14,9 @@ func Init() {
 	// verification route
 	HttpMux.HandleFunc("/verify", handleVerification)
 
+	// metrics route, scraped by Prometheus
+	HttpMux.Handle("/metrics", metrics.Handler())
+
 	//serve public dir
 	HttpMux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./public"))))
 }
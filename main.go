@@ -37,12 +37,15 @@ func main() {
 	datastreamsManager := datastreams.GetManager()
 	go datastreamsManager.GetStockExchangeStream().Run()
 	go datastreamsManager.GetStockPricesStream().Run()
+	go datastreamsManager.GetMarketIndexStream().Run()
 
 	models.Init(config, datastreamsManager)
 	go models.UpdateLeaderboardTicker()
+	go models.UpdateMarketIndexTicker()
 
 	matchingEngine := matchingengine.NewMatchingEngine(datastreamsManager)
 	grpcapi.Init(config, matchingEngine, datastreamsManager)
+	go matchingEngine.StartMarginRiskChecker(time.Duration(config.MarginCheckInterval) * time.Second)
 
 	if !utils.IsDockerEnv() {
 		models.OpenMarket(false)
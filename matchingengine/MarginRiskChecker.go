@@ -0,0 +1,58 @@
+package matchingengine
+
+import (
+	"time"
+
+	"github.com/delta/dalal-street-server/models"
+)
+
+// CheckMarginAccounts is a type definition for a function that checks margin accounts and returns
+// the stocks to sell to pay off margin loans
+type CheckMarginAccounts func() ([]*models.MarginLiquidation, error)
+
+// PlaceAskOrder is a type definition for a function that places an ask order for a user
+type PlaceAskOrder func(userId uint32, ask *models.Ask) (uint32, error)
+
+// checkMarginAccountsFn and placeAskOrderFn are the actual functions used by the risk checker.
+// They have been separated from implementation to ease testing.
+var checkMarginAccountsFn CheckMarginAccounts = models.CheckMarginAccounts
+var placeAskOrderFn PlaceAskOrder = models.PlaceAskOrder
+
+// StartMarginRiskChecker checks margin accounts every interval while the market is open, and sells
+// the stocks of users who didn't meet their margin calls. It never returns.
+func (m *matchingEngine) StartMarginRiskChecker(interval time.Duration) {
+	m.logger.Infof("Started margin risk checker")
+
+	for range time.Tick(interval) {
+		if models.IsMarketOpen() {
+			m.checkMargins()
+		}
+	}
+}
+
+// checkMargins places market orders for the stocks that have to be sold to pay off margin loans
+func (m *matchingEngine) checkMargins() {
+	liquidations, err := checkMarginAccountsFn()
+	if err != nil {
+		m.logger.Errorf("Unable to check margin accounts: %+v", err)
+		return
+	}
+
+	for _, liq := range liquidations {
+		ask := &models.Ask{
+			UserId:        liq.UserId,
+			StockId:       liq.StockId,
+			OrderType:     models.Market,
+			StockQuantity: liq.StockQuantity,
+			TimeInForce:   models.ImmediateOrCancel,
+		}
+
+		if _, err := placeAskOrderFn(liq.UserId, ask); err != nil {
+			m.logger.Errorf("Unable to sell %d of stock %d for user %d's margin loan: %+v", liq.StockQuantity, liq.StockId, liq.UserId, err)
+			continue
+		}
+
+		m.logger.Infof("Selling %d of stock %d for user %d's margin loan", liq.StockQuantity, liq.StockId, liq.UserId)
+		m.AddAskOrder(ask)
+	}
+}
@@ -0,0 +1,52 @@
+package matchingengine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/delta/dalal-street-server/models"
+	"github.com/delta/dalal-street-server/utils"
+)
+
+func TestCheckMargins(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, mockOrderBook, mengine, stockID, _, _ := getMockMatchingEngine(t)
+	defer mockControl.Finish()
+
+	oldCheckMarginAccountsFn, oldPlaceAskOrderFn := checkMarginAccountsFn, placeAskOrderFn
+	defer func() {
+		checkMarginAccountsFn, placeAskOrderFn = oldCheckMarginAccountsFn, oldPlaceAskOrderFn
+	}()
+
+	checkMarginAccountsFn = func() ([]*models.MarginLiquidation, error) {
+		return []*models.MarginLiquidation{
+			{UserId: 2, StockId: stockID, StockQuantity: 5},
+			{UserId: 3, StockId: stockID, StockQuantity: 7},
+		}, nil
+	}
+
+	var placed []*models.Ask
+	placeAskOrderFn = func(userId uint32, ask *models.Ask) (uint32, error) {
+		if userId == 3 {
+			return 0, fmt.Errorf("Not enough stocks")
+		}
+		placed = append(placed, ask)
+		return 1, nil
+	}
+
+	// only the order that was placed goes to the order book
+	mockOrderBook.EXPECT().AddAskOrder(gomock.Any()).Times(1)
+
+	mengine.checkMargins()
+
+	if len(placed) != 1 {
+		t.Fatalf("Placed %d orders, expected 1", len(placed))
+	}
+	if ask := placed[0]; ask.UserId != 2 || ask.StockQuantity != 5 || ask.OrderType != models.Market || ask.TimeInForce != models.ImmediateOrCancel {
+		t.Errorf("Placed %+v, expected an immediate-or-cancel market ask of 5 stocks for user 2", ask)
+	}
+}
@@ -1,11 +1,15 @@
 package matchingengine
 
 import (
+	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/delta/dalal-street-server/datastreams"
+	"github.com/delta/dalal-street-server/metrics"
 	"github.com/delta/dalal-street-server/models"
 	"github.com/delta/dalal-street-server/utils"
 )
@@ -14,12 +18,95 @@ import (
 
 var logger *logrus.Entry
 
+// SuspendStock is a type definition for a function that suspends or unsuspends trading in a stock
+type SuspendStock func(stockId uint32)
+
+// suspendStockFn and unsuspendStockFn are the actual functions that suspend and unsuspend trading.
+// They have been separated from implementation to ease testing.
+var suspendStockFn SuspendStock = models.SuspendStock
+var unsuspendStockFn SuspendStock = models.UnsuspendStock
+
+// GetExpiringOptions is a type definition for a function that returns the options that have to be settled
+type GetExpiringOptions func() ([]*models.OptionContract, error)
+
+// SettleOption is a type definition for a function that settles an expired option
+type SettleOption func(optionId uint32) error
+
+// getExpiringOptionsFn and settleOptionFn are the actual functions that settle options.
+// They have been separated from implementation to ease testing.
+var getExpiringOptionsFn GetExpiringOptions = models.GetExpiringOptions
+var settleOptionFn SettleOption = models.SettleOption
+
+// GetExpiringFutures is a type definition for a function that returns the futures that have to be settled
+type GetExpiringFutures func() ([]*models.FutureContract, error)
+
+// SettleFuture is a type definition for a function that settles an expired future
+type SettleFuture func(futureId uint32) error
+
+// getExpiringFuturesFn and settleFutureFn are the actual functions that settle futures.
+// They have been separated from implementation to ease testing.
+var getExpiringFuturesFn GetExpiringFutures = models.GetExpiringFutures
+var settleFutureFn SettleFuture = models.SettleFuture
+
+// GetOpenAsksForStock is a type definition for a function that returns the open asks of a stock
+type GetOpenAsksForStock func(stockId uint32) ([]*models.Ask, error)
+
+// GetOpenBidsForStock is a type definition for a function that returns the open bids of a stock
+type GetOpenBidsForStock func(stockId uint32) ([]*models.Bid, error)
+
+// getOpenAsksForStockFn and getOpenBidsForStockFn are the actual functions that load the orders of a stock
+// after a corporate action. They have been separated from implementation to ease testing.
+var getOpenAsksForStockFn GetOpenAsksForStock = models.GetOpenAsksForStock
+var getOpenBidsForStockFn GetOpenBidsForStock = models.GetOpenBidsForStock
+
 // MatchingEngine represents a collection of OrderBooks for all stocks in the exchange.
 type MatchingEngine interface {
 	AddAskOrder(*models.Ask)
 	AddBidOrder(*models.Bid)
 	CancelAskOrder(*models.Ask)
 	CancelBidOrder(*models.Bid)
+	ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error
+	ExpireOrders()
+	StartCallAuction()
+	Uncross()
+	AddStock(stockId uint32) error
+	RemoveStock(stockId uint32) error
+	SuspendStock(stockId uint32) error
+	UnsuspendStock(stockId uint32) error
+	RestructureStock(stockId uint32, restructure func() error) error
+	AddOption(optionId, stockId uint32) error
+	SettleExpiredOptions()
+	AddFuture(futureId, stockId uint32) error
+	SettleExpiredFutures()
+	StartMarginRiskChecker(interval time.Duration)
+}
+
+// StockAlreadyAddedError is returned by AddStock if the stock already has an order book
+type StockAlreadyAddedError struct{ stockId uint32 }
+
+func (e StockAlreadyAddedError) Error() string {
+	return fmt.Sprintf("Stock %d already has an order book", e.stockId)
+}
+
+// UnknownStockError is returned if a stock has no order book
+type UnknownStockError struct{ stockId uint32 }
+
+func (e UnknownStockError) Error() string {
+	return fmt.Sprintf("Stock %d has no order book", e.stockId)
+}
+
+// OptionAlreadyAddedError is returned by AddOption if the option already has an order book
+type OptionAlreadyAddedError struct{ optionId uint32 }
+
+func (e OptionAlreadyAddedError) Error() string {
+	return fmt.Sprintf("Option %d already has an order book", e.optionId)
+}
+
+// FutureAlreadyAddedError is returned by AddFuture if the future already has an order book
+type FutureAlreadyAddedError struct{ futureId uint32 }
+
+func (e FutureAlreadyAddedError) Error() string {
+	return fmt.Sprintf("Future %d already has an order book", e.futureId)
 }
 
 // matchingEngine implements the MatchingEngine interface
@@ -28,7 +115,17 @@ type matchingEngine struct {
 
 	// orderBooks stores details of placed orders.
 	// Each entry in orderBooks corresponds to a particular stock.
-	orderBooks map[uint32]OrderBook
+	// orderBooksLock guards it, as stocks can be added and removed while the server is running.
+	orderBooksLock sync.RWMutex
+	orderBooks     map[uint32]OrderBook
+
+	// optionBooks stores the order books of options that haven't been settled yet, by option id.
+	// orderBooksLock guards it too.
+	optionBooks map[uint32]OrderBook
+
+	// futureBooks stores the order books of futures that haven't been settled yet, by future id.
+	// orderBooksLock guards it too.
+	futureBooks map[uint32]OrderBook
 
 	// datastreamsManager is used to manage datastreams
 	datastreamsManager datastreams.Manager
@@ -36,18 +133,20 @@ type matchingEngine struct {
 
 // Init configures the matching engine
 func Init(config *utils.Config) {
-	// nothing to do here
+	journalDir = config.MatchingEngineJournalDir
 }
 
 // NewMatchingEngine returns an instance of MatchingEngine
 // It calls StartStockmatching for all the stocks in concurrent goroutines.
-// WARNING: Do NOT call this again for a given stock, once the server has restarted
+// WARNING: Do NOT call this again once the server has started. Use AddStock for stocks listed later.
 func NewMatchingEngine(dsm datastreams.Manager) MatchingEngine {
 	engine := &matchingEngine{
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module": "matchingengine",
 		}),
 		orderBooks:         make(map[uint32]OrderBook),
+		optionBooks:        make(map[uint32]OrderBook),
+		futureBooks:        make(map[uint32]OrderBook),
 		datastreamsManager: dsm,
 	}
 
@@ -63,29 +162,406 @@ func NewMatchingEngine(dsm datastreams.Manager) MatchingEngine {
 		}(ob)
 	}
 
+	for _, ob := range engine.optionBooks {
+		wg.Add(1)
+		go func(ob OrderBook) {
+			ob.StartStockMatching()
+			wg.Done()
+		}(ob)
+	}
+
+	for _, ob := range engine.futureBooks {
+		wg.Add(1)
+		go func(ob OrderBook) {
+			ob.StartStockMatching()
+			wg.Done()
+		}(ob)
+	}
+
 	wg.Wait() // Don't return till the orderbooks have been initialized
+
+	metrics.NewGaugeFunc("dalal_order_book_orders", "Number of orders in a queue of an order book.", []string{"stock_id", "queue"}, engine.collectQueueSizes)
+
 	engine.logger.Info("Started matching engine")
 	return engine
 }
 
+// getOrderBook returns the order book of a stock. An error is logged if the stock has none.
+func (m *matchingEngine) getOrderBook(stockId uint32) (OrderBook, bool) {
+	m.orderBooksLock.RLock()
+	ob, ok := m.orderBooks[stockId]
+	m.orderBooksLock.RUnlock()
+
+	if !ok {
+		m.logger.Errorf("Stock %d has no order book", stockId)
+	}
+	return ob, ok
+}
+
+// getOrderBookFor returns the order book an order goes to. Orders of options and futures go to the books
+// of their options and futures.
+func (m *matchingEngine) getOrderBookFor(stockId, optionId, futureId uint32) (OrderBook, bool) {
+	if futureId != 0 {
+		m.orderBooksLock.RLock()
+		ob, ok := m.futureBooks[futureId]
+		m.orderBooksLock.RUnlock()
+
+		if !ok {
+			m.logger.Errorf("Future %d has no order book", futureId)
+		}
+		return ob, ok
+	}
+
+	if optionId == 0 {
+		return m.getOrderBook(stockId)
+	}
+
+	m.orderBooksLock.RLock()
+	ob, ok := m.optionBooks[optionId]
+	m.orderBooksLock.RUnlock()
+
+	if !ok {
+		m.logger.Errorf("Option %d has no order book", optionId)
+	}
+	return ob, ok
+}
+
+// getAllOrderBooks returns the order books of all the stocks
+func (m *matchingEngine) getAllOrderBooks() []OrderBook {
+	m.orderBooksLock.RLock()
+	defer m.orderBooksLock.RUnlock()
+
+	obs := make([]OrderBook, 0, len(m.orderBooks))
+	for _, ob := range m.orderBooks {
+		obs = append(obs, ob)
+	}
+	return obs
+}
+
+// collectQueueSizes reports the number of orders in each queue of each order book
+func (m *matchingEngine) collectQueueSizes(set func(value float64, labelValues ...string)) {
+	m.orderBooksLock.RLock()
+	defer m.orderBooksLock.RUnlock()
+
+	for stockId, ob := range m.orderBooks {
+		id := strconv.FormatUint(uint64(stockId), 10)
+		asks, bids, askStoplosses, bidStoplosses := ob.QueueSizes()
+		set(float64(asks), id, "asks")
+		set(float64(bids), id, "bids")
+		set(float64(askStoplosses), id, "ask_stoplosses")
+		set(float64(bidStoplosses), id, "bid_stoplosses")
+	}
+}
+
 // AddAskOrder adds an ask order to the relevant order book
 func (m *matchingEngine) AddAskOrder(askOrder *models.Ask) {
-	m.orderBooks[askOrder.StockId].AddAskOrder(askOrder)
+	if ob, ok := m.getOrderBookFor(askOrder.StockId, askOrder.OptionId, askOrder.FutureId); ok {
+		ob.AddAskOrder(askOrder)
+	}
 }
 
 // AddBidOrder adds a bid order to the relevant order book
 func (m *matchingEngine) AddBidOrder(bidOrder *models.Bid) {
-	m.orderBooks[bidOrder.StockId].AddBidOrder(bidOrder)
+	if ob, ok := m.getOrderBookFor(bidOrder.StockId, bidOrder.OptionId, bidOrder.FutureId); ok {
+		ob.AddBidOrder(bidOrder)
+	}
 }
 
 // CancelAskOrder removes the ask order from the orderbook.
 func (m *matchingEngine) CancelAskOrder(askOrder *models.Ask) {
-	m.orderBooks[askOrder.StockId].CancelAskOrder(askOrder)
+	if ob, ok := m.getOrderBookFor(askOrder.StockId, askOrder.OptionId, askOrder.FutureId); ok {
+		ob.CancelAskOrder(askOrder)
+	}
 }
 
 // CancelBidOrder removes the bid order from the orderbook.
 func (m *matchingEngine) CancelBidOrder(bidOrder *models.Bid) {
-	m.orderBooks[bidOrder.StockId].CancelBidOrder(bidOrder)
+	if ob, ok := m.getOrderBookFor(bidOrder.StockId, bidOrder.OptionId, bidOrder.FutureId); ok {
+		ob.CancelBidOrder(bidOrder)
+	}
+}
+
+// ModifyOrder modifies the price and/or quantity of an order through the relevant order book
+func (m *matchingEngine) ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error {
+	stockId, err := models.GetOrderStockId(userId, orderId, isAsk)
+	if err != nil {
+		return err
+	}
+	ob, ok := m.getOrderBook(stockId)
+	if !ok {
+		return UnknownStockError{stockId}
+	}
+	return ob.ModifyOrder(userId, orderId, isAsk, price, stockQuantity)
+}
+
+// ExpireOrders expires the orders whose time in force runs out when the market closes,
+// and removes them from their order books
+func (m *matchingEngine) ExpireOrders() {
+	var l = m.logger.WithFields(logrus.Fields{
+		"method": "ExpireOrders",
+	})
+
+	expiredAsks, expiredBids, err := models.ExpireOrders()
+	if err != nil {
+		l.Errorf("Unable to expire orders: %+v", err)
+		return
+	}
+
+	for _, ask := range expiredAsks {
+		m.CancelAskOrder(ask)
+	}
+
+	for _, bid := range expiredBids {
+		m.CancelBidOrder(bid)
+	}
+}
+
+// StartCallAuction makes all the order books collect orders without matching them
+func (m *matchingEngine) StartCallAuction() {
+	for _, ob := range m.getAllOrderBooks() {
+		ob.StartCallAuction()
+	}
+}
+
+// Uncross ends the call auction in all the order books. Each book matches the orders
+// collected during the auction at a single price. It returns once all of them have uncrossed.
+func (m *matchingEngine) Uncross() {
+	var wg sync.WaitGroup
+
+	for _, ob := range m.getAllOrderBooks() {
+		wg.Add(1)
+		go func(ob OrderBook) {
+			ob.Uncross()
+			wg.Done()
+		}(ob)
+	}
+
+	wg.Wait()
+}
+
+// AddStock creates an order book for a stock listed while the server is running, like at the end
+// of an IPO, and starts matching its orders. The stock mustn't have any open orders yet.
+func (m *matchingEngine) AddStock(stockId uint32) error {
+	m.orderBooksLock.Lock()
+	defer m.orderBooksLock.Unlock()
+
+	if _, ok := m.orderBooks[stockId]; ok {
+		return StockAlreadyAddedError{stockId}
+	}
+
+	marketDepth := m.datastreamsManager.GetMarketDepthStream(stockId)
+	ob := NewOrderBook(stockId, marketDepth)
+	ob.StartStockMatching()
+	m.orderBooks[stockId] = ob
+
+	m.logger.Infof("Added order book for stock %d", stockId)
+	return nil
+}
+
+// RemoveStock stops matching the orders of a stock, and drops its order book. Orders still open in
+// it are left as they are, so it should only be used for stocks that won't trade again.
+func (m *matchingEngine) RemoveStock(stockId uint32) error {
+	m.orderBooksLock.Lock()
+	ob, ok := m.orderBooks[stockId]
+	delete(m.orderBooks, stockId)
+	m.orderBooksLock.Unlock()
+
+	if !ok {
+		return UnknownStockError{stockId}
+	}
+
+	ob.Stop()
+
+	m.logger.Infof("Removed order book for stock %d", stockId)
+	return nil
+}
+
+// AddOption creates an order book for an option listed while the server is running, and starts matching its orders
+func (m *matchingEngine) AddOption(optionId, stockId uint32) error {
+	m.orderBooksLock.Lock()
+	defer m.orderBooksLock.Unlock()
+
+	if _, ok := m.optionBooks[optionId]; ok {
+		return OptionAlreadyAddedError{optionId}
+	}
+
+	marketDepth := m.datastreamsManager.GetOptionMarketDepthStream(optionId)
+	ob := NewOptionOrderBook(optionId, stockId, marketDepth)
+	ob.StartStockMatching()
+	m.optionBooks[optionId] = ob
+
+	m.logger.Infof("Added order book for option %d", optionId)
+	return nil
+}
+
+// SettleExpiredOptions settles the options expiring on the current market day. It's called when the market
+// closes. The order book of each option is dropped before it settles, so that nothing trades meanwhile.
+// An option that fails to settle is tried again the next time, and its orders are loaded back on a restart.
+func (m *matchingEngine) SettleExpiredOptions() {
+	var l = m.logger.WithFields(logrus.Fields{
+		"method": "SettleExpiredOptions",
+	})
+
+	options, err := getExpiringOptionsFn()
+	if err != nil {
+		l.Errorf("Unable to get the expiring options: %+v", err)
+		return
+	}
+
+	for _, option := range options {
+		m.orderBooksLock.Lock()
+		ob, ok := m.optionBooks[option.Id]
+		delete(m.optionBooks, option.Id)
+		m.orderBooksLock.Unlock()
+
+		if ok {
+			ob.Stop()
+		}
+
+		if err := settleOptionFn(option.Id); err != nil {
+			l.Errorf("Unable to settle option %d: %+v", option.Id, err)
+			continue
+		}
+		l.Infof("Settled option %d", option.Id)
+	}
+}
+
+// AddFuture creates an order book for a future listed while the server is running, and starts matching its orders
+func (m *matchingEngine) AddFuture(futureId, stockId uint32) error {
+	m.orderBooksLock.Lock()
+	defer m.orderBooksLock.Unlock()
+
+	if _, ok := m.futureBooks[futureId]; ok {
+		return FutureAlreadyAddedError{futureId}
+	}
+
+	marketDepth := m.datastreamsManager.GetFutureMarketDepthStream(futureId)
+	ob := NewFutureOrderBook(futureId, stockId, marketDepth)
+	ob.StartStockMatching()
+	m.futureBooks[futureId] = ob
+
+	m.logger.Infof("Added order book for future %d", futureId)
+	return nil
+}
+
+// SettleExpiredFutures settles the futures expiring on the current market day, like SettleExpiredOptions
+// does for options. Their open orders are expired by the settlement.
+func (m *matchingEngine) SettleExpiredFutures() {
+	var l = m.logger.WithFields(logrus.Fields{
+		"method": "SettleExpiredFutures",
+	})
+
+	futures, err := getExpiringFuturesFn()
+	if err != nil {
+		l.Errorf("Unable to get the expiring futures: %+v", err)
+		return
+	}
+
+	for _, future := range futures {
+		m.orderBooksLock.Lock()
+		ob, ok := m.futureBooks[future.Id]
+		delete(m.futureBooks, future.Id)
+		m.orderBooksLock.Unlock()
+
+		if ok {
+			ob.Stop()
+		}
+
+		if err := settleFutureFn(future.Id); err != nil {
+			l.Errorf("Unable to settle future %d: %+v", future.Id, err)
+			continue
+		}
+		l.Infof("Settled future %d", future.Id)
+	}
+}
+
+// SuspendStock suspends trading in a stock till UnsuspendStock is called. New orders are rejected,
+// and the open ones rest in the order book without matching.
+func (m *matchingEngine) SuspendStock(stockId uint32) error {
+	ob, ok := m.getOrderBook(stockId)
+	if !ok {
+		return UnknownStockError{stockId}
+	}
+
+	suspendStockFn(stockId)
+	ob.Suspend()
+	return nil
+}
+
+// UnsuspendStock lifts the suspension of a stock. The orders that rested in the order book
+// meanwhile are matched at a single price, like after a call auction.
+func (m *matchingEngine) UnsuspendStock(stockId uint32) error {
+	ob, ok := m.getOrderBook(stockId)
+	if !ok {
+		return UnknownStockError{stockId}
+	}
+
+	ob.Unsuspend()
+	unsuspendStockFn(stockId)
+	return nil
+}
+
+// RestructureStock runs restructure, a corporate action that changes the prices and quantities of the
+// orders of a stock, with trading in the stock suspended. The order book of the stock is stopped
+// meanwhile, and loaded again from the database afterwards. It is loaded again even if restructure
+// fails, as some orders may have been closed by then. The error of restructure is returned.
+func (m *matchingEngine) RestructureStock(stockId uint32, restructure func() error) error {
+	var l = m.logger.WithFields(logrus.Fields{
+		"method":        "RestructureStock",
+		"param_stockId": stockId,
+	})
+
+	m.orderBooksLock.RLock()
+	ob, ok := m.orderBooks[stockId]
+	m.orderBooksLock.RUnlock()
+
+	if !ok {
+		return UnknownStockError{stockId}
+	}
+
+	// no new orders get placed from here on
+	suspendStockFn(stockId)
+	defer unsuspendStockFn(stockId)
+
+	m.orderBooksLock.Lock()
+	delete(m.orderBooks, stockId)
+	m.orderBooksLock.Unlock()
+
+	ob.Stop()
+
+	err := restructure()
+	if err != nil {
+		l.Errorf("Restructuring failed: %+v", err)
+	}
+
+	asks, loadErr := getOpenAsksForStockFn(stockId)
+	if loadErr != nil {
+		l.Errorf("Unable to load the open asks. They'll be loaded on a restart: %+v", loadErr)
+	}
+	bids, loadErr := getOpenBidsForStockFn(stockId)
+	if loadErr != nil {
+		l.Errorf("Unable to load the open bids. They'll be loaded on a restart: %+v", loadErr)
+	}
+
+	// the old trades aren't loaded, as they were at the old prices
+	marketDepth := m.datastreamsManager.GetMarketDepthStream(stockId)
+	marketDepth.Clear()
+
+	ob = NewOrderBook(stockId, marketDepth)
+	for _, ask := range asks {
+		ob.LoadOldAsk(ask)
+	}
+	for _, bid := range bids {
+		ob.LoadOldBid(bid)
+	}
+	ob.StartStockMatching()
+
+	m.orderBooksLock.Lock()
+	m.orderBooks[stockId] = ob
+	m.orderBooksLock.Unlock()
+
+	l.Infof("Loaded the order book again with %d asks and %d bids", len(asks), len(bids))
+	return err
 }
 
 // loadOldOrders() loads old unfulfilled orders from database
@@ -99,15 +575,29 @@ func (m *matchingEngine) loadOldOrders() {
 	var (
 		openAskOrders []*models.Ask
 		openBidOrders []*models.Bid
+		openOptions   []*models.OptionContract
+		openFutures   []*models.FutureContract
 		stockIDs      []uint32
 		err           error
 	)
 
-	//Load stock ids from database
-	if err = db.Model(&models.Stock{}).Pluck("id", &stockIDs).Error; err != nil {
+	//Load ids of stocks that are still listed from database
+	if err = db.Model(&models.Stock{}).Where("isDelisted = ?", false).Pluck("id", &stockIDs).Error; err != nil {
 		panic("Failed to load stock ids in matching engine: " + err.Error())
 	}
 
+	//Load options that haven't been settled yet from database
+	openOptions, err = models.GetOpenOptions()
+	if err != nil {
+		panic("Error loading options in matching engine: " + err.Error())
+	}
+
+	//Load futures that haven't been settled yet from database
+	openFutures, err = models.GetOpenFutures()
+	if err != nil {
+		panic("Error loading futures in matching engine: " + err.Error())
+	}
+
 	//Load open ask orders from database
 	openAskOrders, err = models.GetAllOpenAsks()
 	if err != nil {
@@ -131,13 +621,39 @@ func (m *matchingEngine) loadOldOrders() {
 		}
 	}
 
+	for _, option := range openOptions {
+		marketDepth := m.datastreamsManager.GetOptionMarketDepthStream(option.Id)
+		m.optionBooks[option.Id] = NewOptionOrderBook(option.Id, option.StockId, marketDepth)
+	}
+
+	for _, future := range openFutures {
+		marketDepth := m.datastreamsManager.GetFutureMarketDepthStream(future.Id)
+		m.futureBooks[future.Id] = NewFutureOrderBook(future.Id, future.StockId, marketDepth)
+	}
+
 	//Load open ask orders into priority queue
 	for _, openAskOrder := range openAskOrders {
+		if openAskOrder.FutureId != 0 {
+			m.futureBooks[openAskOrder.FutureId].LoadOldAsk(openAskOrder)
+			continue
+		}
+		if openAskOrder.OptionId != 0 {
+			m.optionBooks[openAskOrder.OptionId].LoadOldAsk(openAskOrder)
+			continue
+		}
 		m.orderBooks[openAskOrder.StockId].LoadOldAsk(openAskOrder)
 	}
 
 	//Load open bid orders into priority queue
 	for _, openBidOrder := range openBidOrders {
+		if openBidOrder.FutureId != 0 {
+			m.futureBooks[openBidOrder.FutureId].LoadOldBid(openBidOrder)
+			continue
+		}
+		if openBidOrder.OptionId != 0 {
+			m.optionBooks[openBidOrder.OptionId].LoadOldBid(openBidOrder)
+			continue
+		}
 		m.orderBooks[openBidOrder.StockId].LoadOldBid(openBidOrder)
 	}
 
@@ -1,6 +1,7 @@
 package matchingengine
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -33,6 +34,8 @@ func getMockMatchingEngine(t *testing.T) (
 			"module": "matchingengine.MatchingEngine.test",
 		}),
 		orderBooks:         make(map[uint32]OrderBook),
+		optionBooks:        make(map[uint32]OrderBook),
+		futureBooks:        make(map[uint32]OrderBook),
 		datastreamsManager: mockDataStreamsManager,
 	}
 	mengine.orderBooks[stockID] = mockOrderBook
@@ -104,6 +107,238 @@ func TestCancelBidOrder(t *testing.T) {
 	mengine.CancelBidOrder(limitBid)
 }
 
+func TestAddAndRemoveStock(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl := gomock.NewController(t)
+	defer mockControl.Finish()
+
+	var stockID uint32 = 2
+
+	mockDataStreamsManager := mocks.NewMockManager(mockControl)
+	mockDepth := mocks.NewMockMarketDepthStream(mockControl)
+
+	mengine := &matchingEngine{
+		logger: utils.Logger.WithFields(logrus.Fields{
+			"module": "matchingengine.MatchingEngine.test",
+		}),
+		orderBooks:         make(map[uint32]OrderBook),
+		datastreamsManager: mockDataStreamsManager,
+	}
+
+	// orders of a stock without an order book are dropped
+	mengine.AddAskOrder(makeAsk(1, stockID, models.Limit, 10, 20, ""))
+
+	mockDataStreamsManager.EXPECT().GetMarketDepthStream(stockID).Return(mockDepth)
+
+	if err := mengine.AddStock(stockID); err != nil {
+		t.Fatalf("AddStock failed: %+v", err)
+	}
+	if _, ok := mengine.orderBooks[stockID]; !ok {
+		t.Fatalf("Order book of stock %d wasn't added", stockID)
+	}
+	if err := mengine.AddStock(stockID); err != (StockAlreadyAddedError{stockID}) {
+		t.Fatalf("Got %+v adding stock %d again, expected StockAlreadyAddedError", err, stockID)
+	}
+
+	if err := mengine.RemoveStock(stockID); err != nil {
+		t.Fatalf("RemoveStock failed: %+v", err)
+	}
+	if _, ok := mengine.orderBooks[stockID]; ok {
+		t.Fatalf("Order book of stock %d wasn't removed", stockID)
+	}
+	if err := mengine.RemoveStock(stockID); err != (UnknownStockError{stockID}) {
+		t.Fatalf("Got %+v removing stock %d again, expected UnknownStockError", err, stockID)
+	}
+}
+
+func TestSuspendStock(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, mockOrderBook, mengine, stockID, _, _ := getMockMatchingEngine(t)
+	defer mockControl.Finish()
+
+	oldSuspendStockFn, oldUnsuspendStockFn := suspendStockFn, unsuspendStockFn
+	defer func() {
+		suspendStockFn, unsuspendStockFn = oldSuspendStockFn, oldUnsuspendStockFn
+	}()
+
+	var suspended []bool
+	suspendStockFn = func(stockId uint32) { suspended = append(suspended, true) }
+	unsuspendStockFn = func(stockId uint32) { suspended = append(suspended, false) }
+
+	if err := mengine.SuspendStock(stockID + 1); err != (UnknownStockError{stockID + 1}) {
+		t.Fatalf("Got %+v suspending an unknown stock, expected UnknownStockError", err)
+	}
+
+	gomock.InOrder(
+		mockOrderBook.EXPECT().Suspend(),
+		mockOrderBook.EXPECT().Unsuspend(),
+	)
+
+	mengine.SuspendStock(stockID)
+	mengine.UnsuspendStock(stockID)
+
+	if len(suspended) != 2 || !suspended[0] || suspended[1] {
+		t.Fatalf("Got suspensions %v, expected the stock to be suspended and then unsuspended", suspended)
+	}
+}
+
+func TestRestructureStock(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, mockOrderBook, mengine, stockID, _, _ := getMockMatchingEngine(t)
+	defer mockControl.Finish()
+
+	oldSuspendStockFn, oldUnsuspendStockFn := suspendStockFn, unsuspendStockFn
+	oldGetOpenAsksForStockFn, oldGetOpenBidsForStockFn := getOpenAsksForStockFn, getOpenBidsForStockFn
+	defer func() {
+		suspendStockFn, unsuspendStockFn = oldSuspendStockFn, oldUnsuspendStockFn
+		getOpenAsksForStockFn, getOpenBidsForStockFn = oldGetOpenAsksForStockFn, oldGetOpenBidsForStockFn
+	}()
+
+	var suspended []bool
+	suspendStockFn = func(stockId uint32) { suspended = append(suspended, true) }
+	unsuspendStockFn = func(stockId uint32) { suspended = append(suspended, false) }
+	getOpenAsksForStockFn = func(stockId uint32) ([]*models.Ask, error) { return nil, nil }
+	getOpenBidsForStockFn = func(stockId uint32) ([]*models.Bid, error) { return nil, nil }
+
+	if err := mengine.RestructureStock(stockID+1, func() error { return nil }); err != (UnknownStockError{stockID + 1}) {
+		t.Fatalf("Got %+v restructuring an unknown stock, expected UnknownStockError", err)
+	}
+
+	mockDataStreamsManager := mengine.datastreamsManager.(*mocks.MockManager)
+	mockDepth := mocks.NewMockMarketDepthStream(mockControl)
+
+	gomock.InOrder(
+		mockOrderBook.EXPECT().Stop(),
+		mockDataStreamsManager.EXPECT().GetMarketDepthStream(stockID).Return(mockDepth),
+		mockDepth.EXPECT().Clear(),
+	)
+
+	restructureErr := errors.New("restructuring failed")
+	err := mengine.RestructureStock(stockID, func() error {
+		if _, ok := mengine.orderBooks[stockID]; ok {
+			t.Fatalf("Order book of stock %d wasn't stopped while restructuring", stockID)
+		}
+		if len(suspended) != 1 || !suspended[0] {
+			t.Fatalf("Stock %d wasn't suspended while restructuring", stockID)
+		}
+		return restructureErr
+	})
+
+	if err != restructureErr {
+		t.Fatalf("Got %+v, expected the error of the restructuring", err)
+	}
+	// the order book is loaded again even if restructuring fails
+	if ob, ok := mengine.orderBooks[stockID]; !ok || ob == mockOrderBook {
+		t.Fatalf("Order book of stock %d wasn't loaded again", stockID)
+	}
+	if len(suspended) != 2 || suspended[1] {
+		t.Fatalf("Got suspensions %v, expected the stock to be suspended and then unsuspended", suspended)
+	}
+
+	mengine.RemoveStock(stockID)
+}
+
+func TestSettleExpiredOptions(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, mockOrderBook, mengine, stockID, stockQuantity, stockPrice := getMockMatchingEngine(t)
+	defer mockControl.Finish()
+
+	oldGetExpiringOptionsFn, oldSettleOptionFn := getExpiringOptionsFn, settleOptionFn
+	defer func() {
+		getExpiringOptionsFn, settleOptionFn = oldGetExpiringOptionsFn, oldSettleOptionFn
+	}()
+
+	var optionID uint32 = 5
+	mockOptionBook := mocks.NewMockOrderBook(mockControl)
+	mengine.optionBooks[optionID] = mockOptionBook
+
+	// orders of the option go to its book, and not to the book of its stock
+	optionAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	optionAsk.OptionId = optionID
+	mockOptionBook.EXPECT().AddAskOrder(optionAsk)
+	mockOrderBook.EXPECT().AddAskOrder(gomock.Any()).Times(0)
+
+	mengine.AddAskOrder(optionAsk)
+
+	getExpiringOptionsFn = func() ([]*models.OptionContract, error) {
+		return []*models.OptionContract{
+			{Id: optionID, StockId: stockID},
+			{Id: optionID + 1, StockId: stockID},
+		}, nil
+	}
+
+	var settled []uint32
+	settleOptionFn = func(optionId uint32) error {
+		settled = append(settled, optionId)
+		return nil
+	}
+
+	mockOptionBook.EXPECT().Stop()
+
+	mengine.SettleExpiredOptions()
+
+	if len(settled) != 2 || settled[0] != optionID || settled[1] != optionID+1 {
+		t.Fatalf("Settled options %v, expected %d and %d", settled, optionID, optionID+1)
+	}
+	if _, ok := mengine.optionBooks[optionID]; ok {
+		t.Fatalf("Order book of option %d wasn't removed", optionID)
+	}
+}
+
+func TestSettleExpiredFutures(t *testing.T) {
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, mockOrderBook, mengine, stockID, stockQuantity, stockPrice := getMockMatchingEngine(t)
+	defer mockControl.Finish()
+
+	oldGetExpiringFuturesFn, oldSettleFutureFn := getExpiringFuturesFn, settleFutureFn
+	defer func() {
+		getExpiringFuturesFn, settleFutureFn = oldGetExpiringFuturesFn, oldSettleFutureFn
+	}()
+
+	var futureID uint32 = 7
+	mockFutureBook := mocks.NewMockOrderBook(mockControl)
+	mengine.futureBooks[futureID] = mockFutureBook
+
+	// orders of the future go to its book, and not to the book of its stock
+	futureBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	futureBid.FutureId = futureID
+	mockFutureBook.EXPECT().AddBidOrder(futureBid)
+	mockOrderBook.EXPECT().AddBidOrder(gomock.Any()).Times(0)
+
+	mengine.AddBidOrder(futureBid)
+
+	getExpiringFuturesFn = func() ([]*models.FutureContract, error) {
+		return []*models.FutureContract{{Id: futureID, StockId: stockID}}, nil
+	}
+
+	var settled []uint32
+	settleFutureFn = func(futureId uint32) error {
+		settled = append(settled, futureId)
+		return nil
+	}
+
+	mockFutureBook.EXPECT().Stop()
+
+	mengine.SettleExpiredFutures()
+
+	if len(settled) != 1 || settled[0] != futureID {
+		t.Fatalf("Settled futures %v, expected %d", settled, futureID)
+	}
+	if _, ok := mengine.futureBooks[futureID]; ok {
+		t.Fatalf("Order book of future %d wasn't removed", futureID)
+	}
+}
+
 func Test_LoadOldOrders(t *testing.T) {
 
 	config := utils.GetConfiguration()
@@ -174,10 +409,10 @@ func Test_LoadOldOrders(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -1,22 +1,129 @@
 package matchingengine
 
 import (
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
 
 	"github.com/delta/dalal-street-server/datastreams"
+	"github.com/delta/dalal-street-server/metrics"
 	"github.com/delta/dalal-street-server/models"
 	"github.com/delta/dalal-street-server/utils"
 )
 
 //go:generate mockgen -source OrderBook.go -destination ../mocks/mock_OrderBook.go -package mocks
 
-// FillOrder is a type definition for a function that fills an order with given ask, bid, stockPrice and stockQty
-type FillOrder func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction)
+// FillOrder is a type definition for a function that fills an order with given ask, bid, stockPrice and stockQty.
+// The incoming order is the taker. Both orders are makers when an uncrossing book trades them.
+type FillOrder func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction)
 
 // fillOrderFn is the actual function that filles an order.
 // It has been separated from implementation to ease testing.
 var fillOrderFn FillOrder = models.PerformOrderFillTransaction
 
+// CanFill is a type definition for a function that checks if a trade between an ask and a bid would go through
+type CanFill func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, bidIsTaker bool) bool
+
+// canFillFn is the actual function that checks if a trade would go through.
+// It has been separated from implementation to ease testing.
+var canFillFn CanFill = models.CanFillOrder
+
+// ModifyOrder is a type definition for a function that modifies the price and quantity of a user's order
+type ModifyOrder func(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) (*models.ModifiedOrder, error)
+
+// modifyOrderFn is the actual function that modifies an order.
+// It has been separated from implementation to ease testing.
+var modifyOrderFn ModifyOrder = models.ModifyOrder
+
+// ExpireAsk is a type definition for a function that closes an ask whose time in force has run out
+type ExpireAsk func(ask *models.Ask) error
+
+// ExpireBid is a type definition for a function that closes a bid whose time in force has run out
+type ExpireBid func(bid *models.Bid) error
+
+// expireAskFn and expireBidFn are the actual functions that expire orders.
+// They have been separated from implementation to ease testing.
+var expireAskFn ExpireAsk = models.ExpireAskOrder
+var expireBidFn ExpireBid = models.ExpireBidOrder
+
+// TrailAsk is a type definition for a function that moves the trigger price of a trailing stoploss ask
+type TrailAsk func(ask *models.Ask, price uint64) (bool, error)
+
+// TrailBid is a type definition for a function that moves the trigger price of a trailing stoploss bid
+type TrailBid func(bid *models.Bid, price uint64) (bool, error)
+
+// trailAskFn and trailBidFn are the actual functions that move the trigger prices of trailing stoplosses.
+// They have been separated from implementation to ease testing.
+var trailAskFn TrailAsk = models.UpdateTrailingAskStop
+var trailBidFn TrailBid = models.UpdateTrailingBidStop
+
+// SetAuctionPrice is a type definition for a function that sets a stock's price after its order book uncrossed
+type SetAuctionPrice func(stockId uint32, price uint64, volume uint64) error
+
+// setAuctionPriceFn is the actual function that sets the auction price.
+// It has been separated from implementation to ease testing.
+var setAuctionPriceFn SetAuctionPrice = models.SetAuctionPrice
+
+// CheckCircuitBreaker is a type definition for a function that decides if trading in a stock has to be halted after a trade
+type CheckCircuitBreaker func(stockId uint32, price uint64) (bool, time.Duration)
+
+// ResumeStock is a type definition for a function that resumes trading in a halted stock
+type ResumeStock func(stockId uint32)
+
+// checkCircuitBreakerFn and resumeStockFn are the actual functions that halt and resume trading.
+// They have been separated from implementation to ease testing.
+var checkCircuitBreakerFn CheckCircuitBreaker = models.CheckCircuitBreaker
+var resumeStockFn ResumeStock = models.ResumeStock
+
+// GetSelfTradePrevention is a type definition for a function that returns the self-trade prevention mode of a stock
+type GetSelfTradePrevention func(stockId uint32) models.SelfTradePrevention
+
+// getSelfTradePreventionFn is the actual function that returns the self-trade prevention mode.
+// It has been separated from implementation to ease testing.
+var getSelfTradePreventionFn GetSelfTradePrevention = models.GetSelfTradePrevention
+
+// CancelSelfTradeAsk is a type definition for a function that cancels an ask to prevent a self-trade
+type CancelSelfTradeAsk func(ask *models.Ask) error
+
+// CancelSelfTradeBid is a type definition for a function that cancels a bid to prevent a self-trade
+type CancelSelfTradeBid func(bid *models.Bid) error
+
+// DecrementSelfTradeAsk is a type definition for a function that takes stocks off an ask to prevent a self-trade
+type DecrementSelfTradeAsk func(ask *models.Ask, stockQuantity uint64) error
+
+// DecrementSelfTradeBid is a type definition for a function that takes stocks off a bid to prevent a self-trade
+type DecrementSelfTradeBid func(bid *models.Bid, stockQuantity uint64) error
+
+// cancelSelfTradeAskFn, cancelSelfTradeBidFn, decrementSelfTradeAskFn and decrementSelfTradeBidFn are the
+// actual functions that prevent self-trades. They have been separated from implementation to ease testing.
+var cancelSelfTradeAskFn CancelSelfTradeAsk = models.CancelSelfTradeAsk
+var cancelSelfTradeBidFn CancelSelfTradeBid = models.CancelSelfTradeBid
+var decrementSelfTradeAskFn DecrementSelfTradeAsk = models.DecrementSelfTradeAsk
+var decrementSelfTradeBidFn DecrementSelfTradeBid = models.DecrementSelfTradeBid
+
+// TriggerAskStoploss is a type definition for a function that turns a stoploss ask into an active order
+type TriggerAskStoploss func(ask *models.Ask) error
+
+// TriggerBidStoploss is a type definition for a function that turns a stoploss bid into an active order
+type TriggerBidStoploss func(bid *models.Bid) error
+
+// triggerAskStoplossFn and triggerBidStoplossFn are the actual functions that trigger stoplosses.
+// They have been separated from implementation to ease testing.
+var triggerAskStoplossFn TriggerAskStoploss = (*models.Ask).TriggerStoploss
+var triggerBidStoplossFn TriggerBidStoploss = (*models.Bid).TriggerStoploss
+
+// GetStockCopy is a type definition for a function that returns a copy of a stock
+type GetStockCopy func(stockId uint32) (models.Stock, error)
+
+// getStockCopyFn is the actual function that returns a copy of a stock.
+// It has been separated from implementation to ease testing.
+var getStockCopyFn GetStockCopy = models.GetStockCopy
+
+// orderProcessingDuration is the time an order book takes to process an input, like a new order or a cancellation
+var orderProcessingDuration = metrics.NewHistogramVec("dalal_order_processing_duration_seconds", "Time taken by an order book to process an input.", nil, "stock_id", "input")
+
 // OrderBook stores the order book for a given stock
 type OrderBook interface {
 	LoadOldTransactions(txs []*models.Transaction)
@@ -26,58 +133,176 @@ type OrderBook interface {
 	AddBidOrder(*models.Bid)
 	CancelAskOrder(*models.Ask)
 	CancelBidOrder(*models.Bid)
+	ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error
+	StartCallAuction()
+	Uncross()
+	Suspend()
+	Unsuspend()
 	StartStockMatching()
+	Stop()
+	QueueSizes() (asks, bids, askStoplosses, bidStoplosses int)
+}
+
+// orderModification is a request to modify an order, sent to the goroutine matching orders.
+// The result of the modification is sent back on done.
+type orderModification struct {
+	userId        uint32
+	orderId       uint32
+	isAsk         bool
+	price         uint64
+	stockQuantity uint64
+	done          chan error
 }
 
 // orderBook implements the OrderBook interface
 type orderBook struct {
-	logger      *logrus.Entry
-	stockId     uint32
-	askChan     chan *models.Ask
-	bidChan     chan *models.Bid
-	asks        AskPQueue
-	bids        BidPQueue
-	askStoploss AskPQueue
-	bidStoploss BidPQueue
-	depth       datastreams.MarketDepthStream
+	logger          *logrus.Entry
+	stockId         uint32
+	askChan         chan *models.Ask
+	bidChan         chan *models.Bid
+	stoplossAskChan chan *models.Ask
+	stoplossBidChan chan *models.Bid
+	cancelAskChan   chan *models.Ask
+	cancelBidChan   chan *models.Bid
+	modifyChan      chan *orderModification
+	auctionChan     chan struct{}
+	uncrossChan     chan chan struct{}
+	resumeChan      chan struct{}
+	suspendChan     chan bool
+	stopChan        chan chan struct{}
+	stopped         chan struct{}
+	asks            AskQueue
+	bids            BidQueue
+	askStoploss     AskQueue
+	bidStoploss     BidQueue
+	depth           datastreams.MarketDepthStream
+
+	// trailing stoplosses in askStoploss and bidStoploss, by id. Their trigger
+	// prices are moved after every trade. trailingLock guards both the maps.
+	trailingLock sync.Mutex
+	trailingAsks map[uint32]*models.Ask
+	trailingBids map[uint32]*models.Bid
+
+	// legs of one-cancels-other groups, by group id. Once a leg trades or gets
+	// cancelled, the other legs are taken out of the book. groupLock guards both the maps.
+	groupLock sync.Mutex
+	askGroups map[uint32][]*models.Ask
+	bidGroups map[uint32][]*models.Bid
+
+	// stop-limit orders triggered by a trade. They can cross the book at their limit price, so they're
+	// matched like incoming orders once the order being matched is done. Only touched by the goroutine matching orders.
+	triggeredAsks []*models.Ask
+	triggeredBids []*models.Bid
+
+	// call auction state. It's only touched by the goroutine matching orders. While an auction
+	// is running, orders rest in the book without matching. auctionPrice is set only while the
+	// book uncrosses, and every trade happens at that price. auctionVolume adds up those trades.
+	inAuction     bool
+	auctionPrice  uint64
+	auctionVolume uint64
+
+	// isHalted is true while trading in the stock is halted by its circuit breaker. Orders that
+	// reach the book meanwhile rest in it without matching. It's only touched by the goroutine matching orders.
+	isHalted bool
+	// isCoolingOff is true till the cooling-off period of a circuit breaker halt is over
+	isCoolingOff bool
+	// isSuspended is true while trading in the stock is suspended. The book stays halted till it's unsuspended.
+	isSuspended bool
+
+	// journal records every input to the book and what came out of it. It's nil if journaling is disabled.
+	journal *journal
+
+	// optionId is the option whose contracts the book matches. It's 0 for books of stocks. Books of options
+	// have no circuit breakers or call auctions of their own, and they aren't journaled.
+	optionId uint32
+	// futureId is the future whose contracts the book matches. It's 0 for books of stocks and options.
+	// Books of futures work like the books of options.
+	futureId uint32
 }
 
 // NewOrderBook returns a new OrderBook instance for a given stockId.
+// Its journal is opened if journaling is enabled.
 func NewOrderBook(stockId uint32, mds datastreams.MarketDepthStream) OrderBook {
+	ob := newOrderBook(stockId, mds)
+
+	if journalDir != "" {
+		j, err := openJournal(journalDir, stockId)
+		if err != nil {
+			ob.logger.Errorf("Unable to open the journal. Orders won't be journaled: %+v", err)
+		} else {
+			ob.journal = j
+		}
+	}
+
+	return ob
+}
+
+// NewOptionOrderBook returns a new OrderBook instance for an option on a given stockId
+func NewOptionOrderBook(optionId, stockId uint32, mds datastreams.MarketDepthStream) OrderBook {
+	ob := newOrderBook(stockId, mds)
+	ob.optionId = optionId
+	ob.logger = ob.logger.WithField("param_optionId", optionId)
+	return ob
+}
+
+// NewFutureOrderBook returns a new OrderBook instance for a future on a given stockId. stockId is 0 for
+// futures on the index.
+func NewFutureOrderBook(futureId, stockId uint32, mds datastreams.MarketDepthStream) OrderBook {
+	ob := newOrderBook(stockId, mds)
+	ob.futureId = futureId
+	ob.logger = ob.logger.WithField("param_futureId", futureId)
+	return ob
+}
+
+// newOrderBook returns an empty order book for a given stockId, without a journal
+func newOrderBook(stockId uint32, mds datastreams.MarketDepthStream) *orderBook {
 	return &orderBook{
 		logger: utils.Logger.WithFields(logrus.Fields{
 			"module":        "matchingengine.OrderBook",
 			"param_stockId": stockId,
 		}),
-		stockId:     stockId,
-		askChan:     make(chan *models.Ask),
-		bidChan:     make(chan *models.Bid),
-		asks:        NewAskPQueue(MINPQ), //lower price has higher priority
-		bids:        NewBidPQueue(MAXPQ), //higher price has higher priority
-		askStoploss: NewAskPQueue(MAXPQ), // stoplosses work like opposite of limit/market.
-		bidStoploss: NewBidPQueue(MINPQ), // They sell when price goes below a certain trigger price.
-		depth:       mds,
+		stockId:         stockId,
+		askChan:         make(chan *models.Ask),
+		bidChan:         make(chan *models.Bid),
+		stoplossAskChan: make(chan *models.Ask),
+		stoplossBidChan: make(chan *models.Bid),
+		cancelAskChan:   make(chan *models.Ask),
+		cancelBidChan:   make(chan *models.Bid),
+		modifyChan:      make(chan *orderModification),
+		auctionChan:     make(chan struct{}),
+		uncrossChan:     make(chan chan struct{}),
+		resumeChan:      make(chan struct{}),
+		suspendChan:     make(chan bool),
+		stopChan:        make(chan chan struct{}),
+		stopped:         make(chan struct{}),
+		asks:            NewAskQueue(MinPriceFirst), //lower price has higher priority
+		bids:            NewBidQueue(MaxPriceFirst), //higher price has higher priority
+		askStoploss:     NewAskQueue(MaxPriceFirst), // stoplosses work like opposite of limit/market.
+		bidStoploss:     NewBidQueue(MinPriceFirst), // They sell when price goes below a certain trigger price.
+		depth:           mds,
+		trailingAsks:    make(map[uint32]*models.Ask),
+		trailingBids:    make(map[uint32]*models.Bid),
+		askGroups:       make(map[uint32][]*models.Ask),
+		bidGroups:       make(map[uint32][]*models.Bid),
 	}
 }
 
 // addAskToDepth adds an ask to the depth
-// NOTE: 1. Only adds unfulfilled qty
+// NOTE: 1. Only adds unfulfilled qty. For an iceberg ask, only the unfulfilled part of its current slice.
 //		 2. It uses ask.StockQuantityFulfilled which gets written to by fillOrderFn, but this
 //			function doesn't run concurrently with fillOrderFn
 func (ob *orderBook) addAskToDepth(ask *models.Ask) {
-	// use unfulfilled qty here, because depth should have only the unfulfilled qty
-	askUnfulfilledQuantity := ask.StockQuantity - ask.StockQuantityFulfilled
-	ob.depth.AddOrder(isMarket(ask.OrderType), true, ask.Price, askUnfulfilledQuantity)
+	// use visible qty here, because depth should have only the unfulfilled qty that's shown
+	ob.depth.AddOrder(isMarket(ask.OrderType), true, ask.Price, visibleAskQuantity(ask))
 }
 
 // addBidToDepth adds a bid to the depth.
-// NOTE: 1. Only adds unfulfilled qty
+// NOTE: 1. Only adds unfulfilled qty. For an iceberg bid, only the unfulfilled part of its current slice.
 //		 2. It uses bid.StockQuantityFulfilled which gets written to by fillOrderFn, but this
 //			function doesn't run concurrently with fillOrderFn
 func (ob *orderBook) addBidToDepth(bid *models.Bid) {
-	// use unfulfilled qty here, because depth should have only the unfulfilled qty
-	bidUnfulfilledQuantity := bid.StockQuantity - bid.StockQuantityFulfilled
-	ob.depth.AddOrder(isMarket(bid.OrderType), false, bid.Price, bidUnfulfilledQuantity)
+	// use visible qty here, because depth should have only the unfulfilled qty that's shown
+	ob.depth.AddOrder(isMarket(bid.OrderType), false, bid.Price, visibleBidQuantity(bid))
 }
 
 func (ob *orderBook) LoadOldTransactions(txs []*models.Transaction) {
@@ -87,18 +312,31 @@ func (ob *orderBook) LoadOldTransactions(txs []*models.Transaction) {
 }
 
 // LoadOldAsk loads an old ask into the order book
-// NOTE: 1. If it's a stoploss order it adds to the stoploss queue
-// 		 2. Otherwise it adds it to the regular queue, and updates depth
+// NOTE: 1. If it's a stoploss or stop-limit order it adds to the stoploss queue
+// 		 2. If it's an immediate-or-cancel or fill-or-kill order, it expires it. It never
+//			got processed before the server went down, and the market has moved on since.
+// 		 3. Otherwise it adds it to the regular queue, and updates depth
 func (ob *orderBook) LoadOldAsk(ask *models.Ask) {
 	l := ob.logger.WithFields(logrus.Fields{
 		"method":      "LoadOldAsk",
 		"param_askId": ask.Id,
 	})
 
+	ob.journal.recordAsk(journalLoadAsk, ask)
+
+	if ask.TimeInForce.IsImmediate() {
+		l.Debugf("Expiring unprocessed ask %d", ask.Id)
+		ob.expireAsk(ask)
+		return
+	}
+
+	ob.trackGroupAsk(ask)
+
 	// in case of stoploss order, add it to stoploss queue and return
-	if ask.OrderType == models.StopLoss {
+	if ask.OrderType.IsStop() {
 		l.Debugf("Adding stopLoss with ask_id %d to the queue", ask.Id)
 		ob.askStoploss.Push(ask)
+		ob.trackTrailingAsk(ask)
 		return
 	}
 
@@ -107,18 +345,31 @@ func (ob *orderBook) LoadOldAsk(ask *models.Ask) {
 }
 
 // LoadOldBid loads an old bid into the order book
-// NOTE: 1. If it's a stoploss order it adds to the stoploss queue
-// 		 2. Otherwise it adds it to the regular queue, and updates depth
+// NOTE: 1. If it's a stoploss or stop-limit order it adds to the stoploss queue
+// 		 2. If it's an immediate-or-cancel or fill-or-kill order, it expires it. It never
+//			got processed before the server went down, and the market has moved on since.
+// 		 3. Otherwise it adds it to the regular queue, and updates depth
 func (ob *orderBook) LoadOldBid(bid *models.Bid) {
 	l := ob.logger.WithFields(logrus.Fields{
 		"method":      "LoadOldBid",
 		"param_bidId": bid.Id,
 	})
 
+	ob.journal.recordBid(journalLoadBid, bid)
+
+	if bid.TimeInForce.IsImmediate() {
+		l.Debugf("Expiring unprocessed bid %d", bid.Id)
+		ob.expireBid(bid)
+		return
+	}
+
+	ob.trackGroupBid(bid)
+
 	// in case of stoploss order, add it to stoploss queue and return
-	if bid.OrderType == models.StopLoss {
+	if bid.OrderType.IsStop() {
 		l.Debugf("Adding stopLoss with bid_id %d to the queue", bid.Id)
 		ob.bidStoploss.Push(bid)
+		ob.trackTrailingBid(bid)
 		return
 	}
 
@@ -134,17 +385,12 @@ func (ob *orderBook) AddAskOrder(ask *models.Ask) {
 		"param_bidId": ask.Id,
 	})
 
-	// in case of stoploss order, add it to stoploss queue and return
-	if ask.OrderType == models.StopLoss {
-		l.Debugf("Adding stopLoss with ask_id %d to the queue", ask.Id)
-		stockCopy, _ := models.GetStockCopy(ask.StockId)
+	ob.trackGroupAsk(ask)
 
-		if stockCopy.CurrentPrice <= ask.Price {
-			ask.TriggerStoploss()
-			ob.askChan <- ask
-		} else {
-			ob.askStoploss.Push(ask)
-		}
+	// in case of stoploss order, synchronize calls to addStoplossAsk(ask) and return
+	if ask.OrderType.IsStop() {
+		l.Debugf("Adding stopLoss with ask_id %d to the queue", ask.Id)
+		ob.stoplossAskChan <- ask
 		return
 	}
 
@@ -160,17 +406,12 @@ func (ob *orderBook) AddBidOrder(bid *models.Bid) {
 		"param_bidId": bid.Id,
 	})
 
-	// in case of stoploss order, add it to queue and return
-	if bid.OrderType == models.StopLoss {
-		l.Debugf("Adding stopLoss with bid_id %d to the queue", bid.Id)
-		stockCopy, _ := models.GetStockCopy(bid.StockId)
+	ob.trackGroupBid(bid)
 
-		if stockCopy.CurrentPrice >= bid.Price {
-			bid.TriggerStoploss()
-			ob.bidChan <- bid
-		} else {
-			ob.bidStoploss.Push(bid)
-		}
+	// in case of stoploss order, synchronize calls to addStoplossBid(bid) and return
+	if bid.OrderType.IsStop() {
+		l.Debugf("Adding stopLoss with bid_id %d to the queue", bid.Id)
+		ob.stoplossBidChan <- bid
 		return
 	}
 
@@ -179,37 +420,241 @@ func (ob *orderBook) AddBidOrder(bid *models.Bid) {
 }
 
 // CancelAskOrder removes an Ask Order from the OrderBook
-// NOTE: 1. It *ONLY* removes it from the depth, and not from the queue
-// 			Removal from queue will happen when the order gets selected for trading
-// 			at some point in time. This is inefficient and needs to be fixed by moving
-// 			from pqueue to a better data structure to handle orders.
+// NOTE: It should be called only after models.CancelOrder has closed the ask.
+// 		 The removal is synchronized with the matching of orders (see cancelAsk)
+func (ob *orderBook) CancelAskOrder(ask *models.Ask) {
+	ob.cancelAskChan <- ask
+}
+
+// CancelBidOrder removes a Bid Order from the OrderBook
+// NOTE: It should be called only after models.CancelOrder has closed the bid.
+// 		 The removal is synchronized with the matching of orders (see cancelBid)
+func (ob *orderBook) CancelBidOrder(bid *models.Bid) {
+	ob.cancelBidChan <- bid
+}
+
+// cancelAsk removes a cancelled ask from the queues and from the depth
+// NOTE: 1. Whoever removes an order from the asks queue is responsible for removing it from the depth.
+// 			If the ask isn't in the queue anymore, makeTrade has already removed it from there and
+// 			updated the depth. If it never made it to the queue, processAsk will drop it as it's closed.
 //		 2. It accesses StockQuantityFulfilled but this will be called only after
 //		    models.CancelOrder has been called, so the ask's properties won't be modified now
-func (ob *orderBook) CancelAskOrder(ask *models.Ask) {
-	// stopLoss orders haven't even been added to the depth. So they shouldn't be removed.
-	if ask.OrderType == models.StopLoss {
+//		 3. The other legs of the ask's group have been closed along with it. They're removed too.
+func (ob *orderBook) cancelAsk(ask *models.Ask) {
+	ob.removeAsk(ask)
+	ob.cancelAskGroup(ask)
+}
+
+// cancelBid removes a cancelled bid from the queues and from the depth
+// NOTE: 1. Whoever removes an order from the bids queue is responsible for removing it from the depth.
+// 			If the bid isn't in the queue anymore, makeTrade has already removed it from there and
+// 			updated the depth. If it never made it to the queue, processBid will drop it as it's closed.
+//		 2. It accesses StockQuantityFulfilled but this will be called only after
+//		    models.CancelOrder has been called, so the bid's properties won't be modified now
+//		 3. The other legs of the bid's group have been closed along with it. They're removed too.
+func (ob *orderBook) cancelBid(bid *models.Bid) {
+	ob.removeBid(bid)
+	ob.cancelBidGroup(bid)
+}
+
+// removeAsk takes an ask out of whichever queue it's in, and updates the depth
+func (ob *orderBook) removeAsk(ask *models.Ask) {
+	// stopLoss orders haven't even been added to the depth. So they shouldn't be removed from it.
+	if ob.askStoploss.Remove(ask.Id) != nil {
 		return
 	}
 	// Others have been added. So remove those.
-	unfulfilled := ask.StockQuantity - ask.StockQuantityFulfilled
-	ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, unfulfilled)
+	if ob.asks.Remove(ask.Id) != nil {
+		ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, visibleAskQuantity(ask))
+	}
 }
 
-// CancelBidOrder removes an Bid Order from the OrderBook
-// NOTE: 1. It *ONLY* removes it from the depth, and not from the queue
-// 			Removal from queue will happen when the order gets selected for trading
-// 			at some point in time. This is inefficient and needs to be fixed by moving
-// 			from pqueue to a better data structure to handle orders
-// 		 2. It accesses StockQuantityFulfilled but this will be called only after
-//		    models.CancelOrder has been called, so the bid's properties won't be modified now
-func (ob *orderBook) CancelBidOrder(bid *models.Bid) {
-	// stopLoss orders haven't even been added to the depth. So they shouldn't be removed.
-	if bid.OrderType == models.StopLoss {
+// removeBid takes a bid out of whichever queue it's in, and updates the depth
+func (ob *orderBook) removeBid(bid *models.Bid) {
+	// stopLoss orders haven't even been added to the depth. So they shouldn't be removed from it.
+	if ob.bidStoploss.Remove(bid.Id) != nil {
 		return
 	}
 	// Others have been added. So remove those.
-	unfulfilled := bid.StockQuantity - bid.StockQuantityFulfilled
-	ob.depth.CloseOrder(isMarket(bid.OrderType), false, bid.Price, unfulfilled)
+	if ob.bids.Remove(bid.Id) != nil {
+		ob.depth.CloseOrder(isMarket(bid.OrderType), false, bid.Price, visibleBidQuantity(bid))
+	}
+}
+
+// ModifyOrder modifies the price and/or quantity of an order of this stock, and updates the OrderBook.
+// The modification is done by the goroutine matching orders, so that the order doesn't get matched
+// while it's being modified. It returns once the order has been modified, or the modification failed.
+func (ob *orderBook) ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error {
+	m := &orderModification{
+		userId:        userId,
+		orderId:       orderId,
+		isAsk:         isAsk,
+		price:         price,
+		stockQuantity: stockQuantity,
+		done:          make(chan error, 1),
+	}
+	ob.modifyChan <- m
+	return <-m.done
+}
+
+// StartCallAuction makes the order book collect orders without matching them, till it uncrosses
+func (ob *orderBook) StartCallAuction() {
+	ob.auctionChan <- struct{}{}
+}
+
+// Uncross ends the call auction, and matches the collected orders at a single price.
+// It returns once the book has uncrossed.
+func (ob *orderBook) Uncross() {
+	done := make(chan struct{})
+	ob.uncrossChan <- done
+	<-done
+}
+
+// Suspend halts matching till Unsuspend is called. Orders that reach the book meanwhile rest in it.
+func (ob *orderBook) Suspend() {
+	ob.suspendChan <- true
+}
+
+// Unsuspend lifts a suspension. Orders collected during it are matched at a single price.
+func (ob *orderBook) Unsuspend() {
+	ob.suspendChan <- false
+}
+
+// QueueSizes returns the number of orders in each of the queues of the book
+func (ob *orderBook) QueueSizes() (asks, bids, askStoplosses, bidStoplosses int) {
+	return ob.asks.Size(), ob.bids.Size(), ob.askStoploss.Size(), ob.bidStoploss.Size()
+}
+
+// Stop stops the goroutine matching orders, and closes the journal. The book mustn't be used after it.
+// It returns once the goroutine has stopped.
+func (ob *orderBook) Stop() {
+	done := make(chan struct{})
+	ob.stopChan <- done
+	<-done
+}
+
+// modifyOrder modifies an order using modifyOrderFn, and then amends the OrderBook as per the modification
+func (ob *orderBook) modifyOrder(m *orderModification) error {
+	var l = ob.logger.WithFields(logrus.Fields{
+		"method":     "modifyOrder",
+		"paramOrder": m.orderId,
+		"paramIsAsk": m.isAsk,
+	})
+
+	modifiedOrder, err := modifyOrderFn(m.userId, m.orderId, m.isAsk, m.price, m.stockQuantity)
+	ob.journal.record(&journalEntry{Type: journalModifyResult, OrderId: m.orderId, IsAsk: m.isAsk, Failed: err != nil})
+	if err != nil {
+		l.Debugf("Could not modify order: %+v", err)
+		return err
+	}
+
+	if m.isAsk {
+		ob.amendAsk(modifiedOrder.Ask, modifiedOrder.OldPrice, modifiedOrder.OldStockQuantity)
+	} else {
+		ob.amendBid(modifiedOrder.Bid, modifiedOrder.OldPrice, modifiedOrder.OldStockQuantity)
+	}
+
+	return nil
+}
+
+// addStoplossAsk puts a new stoploss ask in the stoploss queue. If the price has already
+// gone below its trigger price, it's triggered and processed like an incoming ask instead.
+func (ob *orderBook) addStoplossAsk(ask *models.Ask) {
+	if ob.currentPrice() <= ask.Price {
+		ob.triggerAsk(ask)
+		ob.processAsk(ask)
+		return
+	}
+	ob.askStoploss.Push(ask)
+	ob.trackTrailingAsk(ask)
+}
+
+// addStoplossBid puts a new stoploss bid in the stoploss queue. If the price has already
+// gone above its trigger price, it's triggered and processed like an incoming bid instead.
+func (ob *orderBook) addStoplossBid(bid *models.Bid) {
+	if ob.currentPrice() >= bid.Price {
+		ob.triggerBid(bid)
+		ob.processBid(bid)
+		return
+	}
+	ob.bidStoploss.Push(bid)
+	ob.trackTrailingBid(bid)
+}
+
+// amendAsk updates the queues and the depth for an ask that has been modified
+// NOTE: 1. Only Limit asks and StopLoss asks that haven't been triggered can be modified.
+//		 2. If only the quantity got reduced, the ask keeps its time priority.
+//		 3. Otherwise the ask is taken out and processed again like an incoming ask. It might get
+//			matched right away at its new price, or it goes to the back of its new price level.
+//		 4. If the ask isn't in any queue, it's yet to reach the order book. processAsk will then
+//			use the modified price and quantity itself.
+func (ob *orderBook) amendAsk(ask *models.Ask, oldPrice uint64, oldStockQuantity uint64) {
+	oldVisibleQuantity := visibleQuantity(ask.IsIceberg, oldStockQuantity, ask.StockQuantityFulfilled, ask.DisplayQuantity)
+
+	if ask.Price == oldPrice && ask.StockQuantity <= oldStockQuantity {
+		// stoploss orders haven't been added to the depth
+		// an iceberg might show more of its current slice, as the slices are counted from the end of the ask
+		newVisibleQuantity := visibleAskQuantity(ask)
+		if newVisibleQuantity < oldVisibleQuantity && ob.asks.Contains(ask.Id) {
+			ob.depth.CloseOrder(false, true, ask.Price, oldVisibleQuantity-newVisibleQuantity)
+		} else if newVisibleQuantity > oldVisibleQuantity && ob.asks.Contains(ask.Id) {
+			ob.depth.AddOrder(false, true, ask.Price, newVisibleQuantity-oldVisibleQuantity)
+		}
+		return
+	}
+
+	if ob.askStoploss.Remove(ask.Id) != nil {
+		if ob.currentPrice() <= ask.Price {
+			ob.triggerAsk(ask)
+			ob.processAsk(ask)
+		} else {
+			ob.askStoploss.Push(ask)
+		}
+		return
+	}
+
+	if ob.asks.Remove(ask.Id) != nil {
+		ob.depth.CloseOrder(false, true, oldPrice, oldVisibleQuantity)
+		ob.processAsk(ask)
+	}
+}
+
+// amendBid updates the queues and the depth for a bid that has been modified
+// NOTE: 1. Only Limit bids and StopLoss bids that haven't been triggered can be modified.
+//		 2. If only the quantity got reduced, the bid keeps its time priority.
+//		 3. Otherwise the bid is taken out and processed again like an incoming bid. It might get
+//			matched right away at its new price, or it goes to the back of its new price level.
+//		 4. If the bid isn't in any queue, it's yet to reach the order book. processBid will then
+//			use the modified price and quantity itself.
+func (ob *orderBook) amendBid(bid *models.Bid, oldPrice uint64, oldStockQuantity uint64) {
+	oldVisibleQuantity := visibleQuantity(bid.IsIceberg, oldStockQuantity, bid.StockQuantityFulfilled, bid.DisplayQuantity)
+
+	if bid.Price == oldPrice && bid.StockQuantity <= oldStockQuantity {
+		// stoploss orders haven't been added to the depth
+		// an iceberg might show more of its current slice, as the slices are counted from the end of the bid
+		newVisibleQuantity := visibleBidQuantity(bid)
+		if newVisibleQuantity < oldVisibleQuantity && ob.bids.Contains(bid.Id) {
+			ob.depth.CloseOrder(false, false, bid.Price, oldVisibleQuantity-newVisibleQuantity)
+		} else if newVisibleQuantity > oldVisibleQuantity && ob.bids.Contains(bid.Id) {
+			ob.depth.AddOrder(false, false, bid.Price, newVisibleQuantity-oldVisibleQuantity)
+		}
+		return
+	}
+
+	if ob.bidStoploss.Remove(bid.Id) != nil {
+		if ob.currentPrice() >= bid.Price {
+			ob.triggerBid(bid)
+			ob.processBid(bid)
+		} else {
+			ob.bidStoploss.Push(bid)
+		}
+		return
+	}
+
+	if ob.bids.Remove(bid.Id) != nil {
+		ob.depth.CloseOrder(false, false, oldPrice, oldVisibleQuantity)
+		ob.processBid(bid)
+	}
 }
 
 /**
@@ -225,12 +670,19 @@ func (ob *orderBook) StartStockMatching() {
 	l.Infof("Started with ask_count = %d, bid_count = %d", ob.asks.Size(), ob.bids.Size())
 
 	// Clear the existing orders first
+	ob.journal.record(&journalEntry{Type: journalStartMatching})
 	ob.clearExistingOrders()
+	ob.processTriggeredOrders()
 
-	// run infinite loop
+	// run till the book is stopped
 	go func() {
 		for {
-			ob.waitForOrder()
+			select {
+			case <-ob.stopped:
+				return
+			default:
+				ob.waitForOrder()
+			}
 		}
 	}()
 }
@@ -238,6 +690,8 @@ func (ob *orderBook) StartStockMatching() {
 // getTopMatchingBid checks for a matching bid from the orderbook for an incoming ask
 // NOTE: 1. It does NOT remove the bid from the queue.
 //       2. It does NOT update the market depth
+//       3. Bids of the same user are skipped. preventSelfTradeAsk has dealt with them already,
+//          unless the stock's self-trade prevention mode is SkipOwnOrders.
 func (ob *orderBook) getTopMatchingBid(ask *models.Ask) (*models.Bid, func()) {
 	bidTop := ob.bids.Head()
 
@@ -248,10 +702,11 @@ func (ob *orderBook) getTopMatchingBid(ask *models.Ask) (*models.Bid, func()) {
 		bidTop = ob.bids.Head()
 	}
 
-	// Function to add back the sameUserBids in the PQueue
+	// Function to add back the sameUserBids in the queue. They are added back to the
+	// front in reverse order so that they don't lose their time priority.
 	addBackOrders := func() {
-		for _, bid := range sameUserBids {
-			ob.bids.Push(bid)
+		for i := len(sameUserBids) - 1; i >= 0; i-- {
+			ob.bids.PushFront(sameUserBids[i])
 			// do not add to the market depth!
 		}
 	}
@@ -269,6 +724,8 @@ func (ob *orderBook) getTopMatchingBid(ask *models.Ask) (*models.Bid, func()) {
 // getTopMatchingAsk returns a matching ask from the orderbook for an incoming bid
 // NOTE: 1. It does NOT remove the ask from the queue
 //       2. It does NOT update the market depth
+//       3. Asks of the same user are skipped. preventSelfTradeBid has dealt with them already,
+//          unless the stock's self-trade prevention mode is SkipOwnOrders.
 func (ob *orderBook) getTopMatchingAsk(bid *models.Bid) (*models.Ask, func()) {
 	askTop := ob.asks.Head()
 
@@ -279,10 +736,11 @@ func (ob *orderBook) getTopMatchingAsk(bid *models.Bid) (*models.Ask, func()) {
 		askTop = ob.asks.Head()
 	}
 
-	// Function to add back the sameUserAsks in the PQueue
+	// Function to add back the sameUserAsks in the queue. They are added back to the
+	// front in reverse order so that they don't lose their time priority.
 	addBackOrders := func() {
-		for _, ask := range sameUserAsks {
-			ob.asks.Push(ask)
+		for i := len(sameUserAsks) - 1; i >= 0; i-- {
+			ob.asks.PushFront(sameUserAsks[i])
 			// do not add to the market depth!
 		}
 	}
@@ -297,12 +755,291 @@ func (ob *orderBook) getTopMatchingAsk(bid *models.Bid) (*models.Ask, func()) {
 	return askTop, addBackOrders
 }
 
+// getSelfTradePrevention returns the self-trade prevention mode of the book. Books of options and futures
+// skip the user's own orders, as their orders can't be decremented.
+func (ob *orderBook) getSelfTradePrevention() models.SelfTradePrevention {
+	if ob.optionId != 0 || ob.futureId != 0 {
+		return models.SkipOwnOrders
+	}
+	return getSelfTradePreventionFn(ob.stockId)
+}
+
+// preventSelfTradeAsk applies the stock's self-trade prevention mode to an incoming ask, before it is
+// matched with the bid at the top of the queue. It returns true if the ask got cancelled.
+// NOTE: 1. Nothing is done with SkipOwnOrders. getTopMatchingBid skips the user's own bids then.
+//		 2. Only the top bid is looked at, as it's the one the ask would trade with next.
+//			It is looked at again till it's another user's bid, or it doesn't match the ask.
+//		 3. Bids that get cancelled are removed from the queue and the depth, along with the other
+//			legs of their group. A bid that only gets decremented keeps its place in the queue.
+func (ob *orderBook) preventSelfTradeAsk(ask *models.Ask) bool {
+	// the mode is only looked up once there's a self-trade to prevent
+	var mode models.SelfTradePrevention
+
+	for {
+		bidTop := ob.bids.Head()
+		if bidTop == nil || bidTop.UserId != ask.UserId || !isOrderMatching(ask, bidTop) {
+			return false
+		}
+
+		if mode == models.DefaultSelfTradePrevention {
+			mode = ob.getSelfTradePrevention()
+			ob.journal.record(&journalEntry{Type: journalSelfTradePrevention, Mode: mode})
+		}
+
+		switch mode {
+		case models.SkipOwnOrders, models.DefaultSelfTradePrevention:
+			return false
+		case models.CancelNewest:
+			ob.cancelSelfTradeAsk(ask)
+			return true
+		case models.CancelOldest:
+			ob.cancelSelfTradeBid(bidTop)
+		case models.CancelBoth:
+			ob.cancelSelfTradeBid(bidTop)
+			ob.cancelSelfTradeAsk(ask)
+			return true
+		case models.DecrementAndCancel:
+			askLeft := unfulfilledAskQuantity(ask)
+			bidLeft := unfulfilledBidQuantity(bidTop)
+
+			// legs of a group share their reservation. So they're cancelled instead of being decremented
+			if bidLeft <= askLeft || bidTop.GroupId != 0 {
+				ob.cancelSelfTradeBid(bidTop)
+			} else {
+				ob.decrementSelfTradeBid(bidTop, askLeft)
+			}
+
+			if askLeft <= bidLeft || ask.GroupId != 0 {
+				ob.cancelSelfTradeAsk(ask)
+				return true
+			}
+			ob.decrementSelfTradeAsk(ask, bidLeft)
+		}
+	}
+}
+
+// preventSelfTradeBid applies the stock's self-trade prevention mode to an incoming bid, before it is
+// matched with the ask at the top of the queue. It returns true if the bid got cancelled.
+// NOTE: It works just like preventSelfTradeAsk
+func (ob *orderBook) preventSelfTradeBid(bid *models.Bid) bool {
+	// the mode is only looked up once there's a self-trade to prevent
+	var mode models.SelfTradePrevention
+
+	for {
+		askTop := ob.asks.Head()
+		if askTop == nil || askTop.UserId != bid.UserId || !isOrderMatching(askTop, bid) {
+			return false
+		}
+
+		if mode == models.DefaultSelfTradePrevention {
+			mode = ob.getSelfTradePrevention()
+			ob.journal.record(&journalEntry{Type: journalSelfTradePrevention, Mode: mode})
+		}
+
+		switch mode {
+		case models.SkipOwnOrders, models.DefaultSelfTradePrevention:
+			return false
+		case models.CancelNewest:
+			ob.cancelSelfTradeBid(bid)
+			return true
+		case models.CancelOldest:
+			ob.cancelSelfTradeAsk(askTop)
+		case models.CancelBoth:
+			ob.cancelSelfTradeAsk(askTop)
+			ob.cancelSelfTradeBid(bid)
+			return true
+		case models.DecrementAndCancel:
+			askLeft := unfulfilledAskQuantity(askTop)
+			bidLeft := unfulfilledBidQuantity(bid)
+
+			// legs of a group share their reservation. So they're cancelled instead of being decremented
+			if askLeft <= bidLeft || askTop.GroupId != 0 {
+				ob.cancelSelfTradeAsk(askTop)
+			} else {
+				ob.decrementSelfTradeAsk(askTop, bidLeft)
+			}
+
+			if bidLeft <= askLeft || bid.GroupId != 0 {
+				ob.cancelSelfTradeBid(bid)
+				return true
+			}
+			ob.decrementSelfTradeBid(bid, askLeft)
+		}
+	}
+}
+
+// cancelSelfTradeAsk cancels an ask to prevent a self-trade. It is taken out of the book even if
+// the cancellation failed, so that it isn't looked at again.
+func (ob *orderBook) cancelSelfTradeAsk(ask *models.Ask) {
+	if err := cancelSelfTradeAskFn(ask); err != nil {
+		ob.logger.Errorf("Error while cancelling ask %d to prevent a self-trade: %+v", ask.Id, err)
+	}
+	ob.cancelAsk(ask)
+}
+
+// cancelSelfTradeBid cancels a bid to prevent a self-trade. It is taken out of the book even if
+// the cancellation failed, so that it isn't looked at again.
+func (ob *orderBook) cancelSelfTradeBid(bid *models.Bid) {
+	if err := cancelSelfTradeBidFn(bid); err != nil {
+		ob.logger.Errorf("Error while cancelling bid %d to prevent a self-trade: %+v", bid.Id, err)
+	}
+	ob.cancelBid(bid)
+}
+
+// decrementSelfTradeAsk takes stockQuantity off an ask to prevent a self-trade.
+// The depth is updated if the ask is in the queue.
+func (ob *orderBook) decrementSelfTradeAsk(ask *models.Ask, stockQuantity uint64) {
+	inQueue := ob.asks.Remove(ask.Id) != nil
+	if inQueue {
+		ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, visibleAskQuantity(ask))
+	}
+
+	if err := decrementSelfTradeAskFn(ask, stockQuantity); err != nil {
+		ob.logger.Errorf("Error while decrementing ask %d to prevent a self-trade: %+v", ask.Id, err)
+	}
+
+	if inQueue {
+		ob.asks.PushFront(ask)
+		ob.addAskToDepth(ask)
+	}
+}
+
+// decrementSelfTradeBid takes stockQuantity off a bid to prevent a self-trade.
+// The depth is updated if the bid is in the queue.
+func (ob *orderBook) decrementSelfTradeBid(bid *models.Bid, stockQuantity uint64) {
+	inQueue := ob.bids.Remove(bid.Id) != nil
+	if inQueue {
+		ob.depth.CloseOrder(isMarket(bid.OrderType), false, bid.Price, visibleBidQuantity(bid))
+	}
+
+	if err := decrementSelfTradeBidFn(bid, stockQuantity); err != nil {
+		ob.logger.Errorf("Error while decrementing bid %d to prevent a self-trade: %+v", bid.Id, err)
+	}
+
+	if inQueue {
+		ob.bids.PushFront(bid)
+		ob.addBidToDepth(bid)
+	}
+}
+
+// canFillAsk checks if an incoming fill-or-kill ask can be fulfilled completely by the bids in
+// the book right now.
+// NOTE: 1. Bids of the same user are skipped, as they won't be matched with the ask. So are bids
+//			whose users can't pay for the trade, as they'd be closed instead of traded.
+//		 2. Bids are popped off the queue while checking, and are added back to the front in reverse
+//			order, so that they don't lose their time priority. The depth isn't touched.
+func (ob *orderBook) canFillAsk(ask *models.Ask) bool {
+	var (
+		unfulfilled = ask.StockQuantity - ask.StockQuantityFulfilled
+		available   uint64
+		checkedBids []*models.Bid
+	)
+
+	defer func() {
+		for i := len(checkedBids) - 1; i >= 0; i-- {
+			ob.bids.PushFront(checkedBids[i])
+		}
+	}()
+
+	for available < unfulfilled {
+		bidTop := ob.bids.Head()
+		if bidTop == nil {
+			return false
+		}
+
+		bidTop.Lock()
+		if bidTop.UserId != ask.UserId && !bidTop.IsClosed {
+			if !isOrderMatching(ask, bidTop) {
+				bidTop.Unlock()
+				return false
+			}
+			stockTradePrice, _ := getTradePriceAndQty(ask, bidTop)
+			stockTradeQty := utils.MinInt64(unfulfilled-available, bidTop.StockQuantity-bidTop.StockQuantityFulfilled)
+			if canFillFn(ask, bidTop, stockTradePrice, stockTradeQty, false) {
+				available += stockTradeQty
+			}
+		}
+		bidTop.Unlock()
+
+		checkedBids = append(checkedBids, ob.bids.Pop())
+	}
+
+	return true
+}
+
+// canFillBid checks if an incoming fill-or-kill bid can be fulfilled completely by the asks in
+// the book right now.
+// NOTE: 1. Asks of the same user are skipped, as they won't be matched with the bid. So are trades
+//			that the bidding user can't pay for, as the bid would be closed instead of traded.
+//		 2. Asks are popped off the queue while checking, and are added back to the front in reverse
+//			order, so that they don't lose their time priority. The depth isn't touched.
+func (ob *orderBook) canFillBid(bid *models.Bid) bool {
+	var (
+		unfulfilled = bid.StockQuantity - bid.StockQuantityFulfilled
+		available   uint64
+		checkedAsks []*models.Ask
+	)
+
+	defer func() {
+		for i := len(checkedAsks) - 1; i >= 0; i-- {
+			ob.asks.PushFront(checkedAsks[i])
+		}
+	}()
+
+	for available < unfulfilled {
+		askTop := ob.asks.Head()
+		if askTop == nil {
+			return false
+		}
+
+		askTop.Lock()
+		if askTop.UserId != bid.UserId && !askTop.IsClosed {
+			if !isOrderMatching(askTop, bid) {
+				askTop.Unlock()
+				return false
+			}
+			stockTradePrice, _ := getTradePriceAndQty(askTop, bid)
+			stockTradeQty := utils.MinInt64(unfulfilled-available, askTop.StockQuantity-askTop.StockQuantityFulfilled)
+			if !canFillFn(askTop, bid, stockTradePrice, stockTradeQty, true) {
+				askTop.Unlock()
+				return false
+			}
+			available += stockTradeQty
+		}
+		askTop.Unlock()
+
+		checkedAsks = append(checkedAsks, ob.asks.Pop())
+	}
+
+	return true
+}
+
+// expireAsk closes an ask that can't rest in the book. Its reserved stocks are returned to the user.
+// NOTE: The ask must not be in any queue or in the depth
+func (ob *orderBook) expireAsk(ask *models.Ask) {
+	if err := expireAskFn(ask); err != nil {
+		ob.logger.Errorf("Error while expiring ask %d: %+v", ask.Id, err)
+	}
+}
+
+// expireBid closes a bid that can't rest in the book. Its reserved cash is returned to the user.
+// NOTE: The bid must not be in any queue or in the depth
+func (ob *orderBook) expireBid(bid *models.Bid) {
+	if err := expireBidFn(bid); err != nil {
+		ob.logger.Errorf("Error while expiring bid %d: %+v", bid.Id, err)
+	}
+}
+
 // processAsk tries to match an incoming ask with existing bids
 // and carry out a trade if possible.
 // NOTE: 0. The ask shouldn't be a stoploss. It's either market, or limit.
 // 		 1. The ask hasn't been added to the queue or to the depth
 // 	     2. After dealing with all possible matching bids, if the ask
 //          is still not fulfilled, it is put in the queue, and depth gets updated.
+//			During a call auction or a halt, the ask is put in the queue without being matched.
+//			If a trade halts the stock, the rest of the ask is put in the queue too.
+//			Immediate-or-cancel and fill-or-kill asks expire instead. A fill-or-kill ask
+//			also expires without any trade if it can't be fulfilled completely.
 //		 3. For every matching bid that is handled for this ask,
 //			the bid will be removed if it's either closed already, or if it got
 //			fulfilled, or if the buyer doesn't have enough cash to fulfill the
@@ -313,7 +1050,40 @@ func (ob *orderBook) processAsk(ask *models.Ask) {
 		"paramAsk": ask,
 	})
 
-	var askDone, bidDone bool
+	// the ask might have been cancelled before it reached the order book
+	ask.Lock()
+	isClosed := ask.IsClosed
+	ask.Unlock()
+	if isClosed {
+		l.Debugf("Ask %d was closed before it could be processed. Dropping it", ask.Id)
+		return
+	}
+
+	// orders are only collected during a call auction or a halt. They're matched once the book uncrosses
+	if ob.inAuction || ob.isHalted {
+		if ask.TimeInForce.IsImmediate() {
+			l.Debugf("Expiring immediate ask %d that can't be matched now", ask.Id)
+			ob.expireAsk(ask)
+			return
+		}
+		ob.asks.Push(ask)
+		ob.addAskToDepth(ask)
+		return
+	}
+
+	// a fill-or-kill ask expires right away if it can't be fulfilled completely
+	if ask.TimeInForce == models.FillOrKill && !ob.canFillAsk(ask) {
+		l.Debugf("Fill-or-kill ask %d can't be fulfilled. Expiring it", ask.Id)
+		ob.expireAsk(ask)
+		return
+	}
+
+	var askDone, bidDone, traded bool
+
+	// the ask might get cancelled before it trades with an order of the same user
+	if ob.preventSelfTradeAsk(ask) {
+		return
+	}
 
 	// matchingBid is still in the queue. It must be removed once it finishes
 	matchingBid, addBackOrders := ob.getTopMatchingBid(ask)
@@ -324,33 +1094,51 @@ func (ob *orderBook) processAsk(ask *models.Ask) {
 		 * It handles changes in market depth, closing of orders
 		 * It does not handle popping of the opposing order
 		 */
-		askDone, bidDone = ob.makeTrade(ask, matchingBid, true, false)
+		askDone, bidDone, traded = ob.makeTrade(ask, matchingBid, true, false)
 		if bidDone {
-			ob.bids.Pop()
+			ob.bids.Remove(matchingBid.Id)
 		}
 		addBackOrders()
 
 		// Check if error occurred in acquiring locks or database transactions
-		if askDone == false && bidDone == false {
+		if !traded && askDone == false && bidDone == false {
 			l.Errorf("makeTrade returned both askDone, bidDone false")
+			// an order that can't rest in the book mustn't be left open
+			if ask.TimeInForce.IsImmediate() {
+				ob.expireAsk(ask)
+			}
 			return
 		}
 
 		// check if ask is fulfilled
 		if askDone == true {
 			// nothing more needs to be done here. addBackOrders has been called
-			// and if the bid was fulfilled, it has been popped above already.
+			// and if the bid was fulfilled, it has been removed above already.
 			return
 		}
 
+		// matching stops if the trade halted the stock. The rest of the ask waits for trading to resume
+		if ob.isHalted {
+			break
+		}
+
 		// If ask has not been completely fulfilled, find the next top matching bid and repeat
+		if ob.preventSelfTradeAsk(ask) {
+			return
+		}
 		matchingBid, addBackOrders = ob.getTopMatchingBid(ask)
 	}
 
-	// addBackOrders doesn't need to be called here because matchingBid is nil
+	// addBackOrders doesn't need to be called here because it has been called for the last matchingBid
 
-	// if ask is still not fulfilled, add it to queue & update depth
+	// if ask is still not fulfilled, add it to queue & update depth.
+	// Immediate-or-cancel and fill-or-kill asks expire instead.
 	if askDone == false {
+		if ask.TimeInForce.IsImmediate() {
+			l.Debugf("Expiring the unfulfilled part of ask %d", ask.Id)
+			ob.expireAsk(ask)
+			return
+		}
 		ob.asks.Push(ask)
 		ob.addAskToDepth(ask)
 	}
@@ -362,6 +1150,10 @@ func (ob *orderBook) processAsk(ask *models.Ask) {
 // 		 1. The bid hasn't been added to the queue or to the depth
 // 	     2. After dealing with all possible matching asks, if the bid
 //          is still not fulfilled, it is put in the queue and depth gets updated.
+//			During a call auction or a halt, the bid is put in the queue without being matched.
+//			If a trade halts the stock, the rest of the bid is put in the queue too.
+//			Immediate-or-cancel and fill-or-kill bids expire instead. A fill-or-kill bid
+//			also expires without any trade if it can't be fulfilled completely.
 //		 3. For every matching ask that is handled for this bid,
 //			the ask will be removed if it's either closed already, or if it got
 //			fulfilled, or if the seller doesn't have enough stocks to fulfill the
@@ -372,8 +1164,41 @@ func (ob *orderBook) processBid(bid *models.Bid) {
 		"paramBid": bid,
 	})
 
+	// the bid might have been cancelled before it reached the order book
+	bid.Lock()
+	isClosed := bid.IsClosed
+	bid.Unlock()
+	if isClosed {
+		l.Debugf("Bid %d was closed before it could be processed. Dropping it", bid.Id)
+		return
+	}
+
+	// orders are only collected during a call auction or a halt. They're matched once the book uncrosses
+	if ob.inAuction || ob.isHalted {
+		if bid.TimeInForce.IsImmediate() {
+			l.Debugf("Expiring immediate bid %d that can't be matched now", bid.Id)
+			ob.expireBid(bid)
+			return
+		}
+		ob.bids.Push(bid)
+		ob.addBidToDepth(bid)
+		return
+	}
+
+	// a fill-or-kill bid expires right away if it can't be fulfilled completely
+	if bid.TimeInForce == models.FillOrKill && !ob.canFillBid(bid) {
+		l.Debugf("Fill-or-kill bid %d can't be fulfilled. Expiring it", bid.Id)
+		ob.expireBid(bid)
+		return
+	}
+
 	// if control reaches here, it's NOT a stoploss order
-	var askDone, bidDone bool
+	var askDone, bidDone, traded bool
+
+	// the bid might get cancelled before it trades with an order of the same user
+	if ob.preventSelfTradeBid(bid) {
+		return
+	}
 
 	// matchingAsk is still in the queue. It must be removed once it finishes
 	matchingAsk, addBackOrders := ob.getTopMatchingAsk(bid)
@@ -384,33 +1209,51 @@ func (ob *orderBook) processBid(bid *models.Bid) {
 		 * It handles changes in market depth, closing of orders
 		 * It does not handle popping of the opposing order
 		 */
-		askDone, bidDone = ob.makeTrade(matchingAsk, bid, false, true)
+		askDone, bidDone, traded = ob.makeTrade(matchingAsk, bid, false, true)
 		if askDone {
-			ob.asks.Pop()
+			ob.asks.Remove(matchingAsk.Id)
 		}
 		addBackOrders()
 
 		// Check if error occurred in acquiring locks or database transactions
-		if askDone == false && bidDone == false {
+		if !traded && askDone == false && bidDone == false {
 			l.Errorf("makeTrade returned both askDone, bidDone false")
+			// an order that can't rest in the book mustn't be left open
+			if bid.TimeInForce.IsImmediate() {
+				ob.expireBid(bid)
+			}
 			return
 		}
 
 		// check if bid is fulfilled
 		if bidDone == true {
 			// nothing more needs to be done here. addBackOrders has been called
-			// and if the ask was fulfilled, it has been popped above already.
+			// and if the ask was fulfilled, it has been removed above already.
 			return
 		}
 
+		// matching stops if the trade halted the stock. The rest of the bid waits for trading to resume
+		if ob.isHalted {
+			break
+		}
+
 		// If bid has not been completely fulfilled, find the next top matching ask and repeat
+		if ob.preventSelfTradeBid(bid) {
+			return
+		}
 		matchingAsk, addBackOrders = ob.getTopMatchingAsk(bid)
 	}
 
-	// addBackOrders doesn't need to be called here because matchingAsk is nil
+	// addBackOrders doesn't need to be called here because it has been called for the last matchingAsk
 
-	// if bid is still not fulfilled, add it to queue & update depth
+	// if bid is still not fulfilled, add it to queue & update depth.
+	// Immediate-or-cancel and fill-or-kill bids expire instead.
 	if bidDone == false {
+		if bid.TimeInForce.IsImmediate() {
+			l.Debugf("Expiring the unfulfilled part of bid %d", bid.Id)
+			ob.expireBid(bid)
+			return
+		}
 		ob.bids.Push(bid)
 		ob.addBidToDepth(bid)
 	}
@@ -421,17 +1264,25 @@ func (ob *orderBook) processBid(bid *models.Bid) {
 //		 1. incomingAsk/Bid is true if the ask/bid is incoming - ie not added to queue yet
 //       2. Both incomingAsk and incomingBid SHOULDN'T be true. Usually exactly one
 //			is true, but when clearing existing orders, both will be false
-//       3. It returns two booleans: askDone, bidDone. Each is true if the ask/bid order has been
-//     		closed and is safe to remove it from the queue.
+//       3. It returns three booleans: askDone, bidDone, traded. askDone/bidDone is true if the ask/bid
+//     		order has been closed and is safe to remove it from the queue. traded is true if a trade
+//			happened. Both orders stay open after a trade if an iceberg order only traded its visible slice.
 //		 4. The *CALLER* is responsible for dealing with the queue (adding/removing)
-//			[except for triggerStoploss], as makeTrade only deals with the market depth datastream
-//		 5. If transaction happens:
+//			[except for triggerStoploss and replenishing icebergs], as makeTrade only deals with the
+//			market depth datastream
+//		 5. While the book uncrosses after a call auction, the trade happens at the auction price.
+//			Otherwise, the trade might halt the stock if its price moved too much.
+//		 6. A non-incoming iceberg order trades at most its visible slice. Once that's filled, the
+//			next slice is shown in the depth, and the order moves to the back of its price level.
+//		 7. If transaction happens:
 //			a). A new trade is added to the depth.
-//		 	b). Stoplossess get triggered
-//			c). Non-incoming order(s) are removed from depth where removed qty==tr.qty
-//		 6. If transaction doesn't happen:
+//		 	b). The other legs of one-cancels-other groups are removed from the book
+//		 	c). Trailing stoplosses follow the price, and then stoplossess get triggered. While the book
+//				uncrosses, matchCollectedOrders does that once it's done instead.
+//			d). Non-incoming order(s) are removed from depth where removed qty==tr.qty
+//		 8. If transaction doesn't happen:
 //			a). Non-incoming order(s) are removed from depth where removed qty==unfulfilled.
-//				This is done ONLY if the order wasn't already closed (See below)
+//				This is done even if the order was already closed (See below)
 //
 // NOTE: Whether the order was closed already has to be decided by the fillOrderFn
 // as accessing the IsClosed attribute of the Bid from here is unsafe. It
@@ -444,15 +1295,14 @@ func (ob *orderBook) processBid(bid *models.Bid) {
 // Therefore, fillOrderFn and models.CancelOrder are *strongly ordered*. One happens before
 // the other.
 //
-// So, if fillOrderFn returns orderstatus as AlreadyClosed, then that means
-// models.CancelOrder executed before it for that order. Since models.CancelOrder will be followed
-// by OrderBook.CancelOrder, makeTrade doesn't handle that case. Instead, OrderBook.CancelOrder will
-// be called sometime (soon, or even concurrently with makeTrade), and it'll remove the required amount
-// of stockqty from depth.
+// OrderBook.CancelOrder, which follows models.CancelOrder, is handled by the same goroutine
+// that calls makeTrade. So if fillOrderFn returns orderstatus as AlreadyClosed for a non-incoming
+// order, that order is still in the queue and OrderBook.CancelOrder hasn't been handled yet.
+// The caller will remove the order from the queue, so makeTrade removes it from depth too.
+// OrderBook.CancelOrder won't find the order in the queue later on, and will leave the depth alone.
 
 // Basically, makeTrade will update depth only when it's a non-incoming order
-// AND the orderstatus is either Done or Undone, but not when it's AlreadyClosed
-func (ob *orderBook) makeTrade(ask *models.Ask, bid *models.Bid, incomingAsk bool, incomingBid bool) (bool, bool) {
+func (ob *orderBook) makeTrade(ask *models.Ask, bid *models.Bid, incomingAsk bool, incomingBid bool) (bool, bool, bool) {
 	var l = ob.logger.WithFields(logrus.Fields{
 		"method":   "makeTrade",
 		"paramAsk": ask,
@@ -467,12 +1317,33 @@ func (ob *orderBook) makeTrade(ask *models.Ask, bid *models.Bid, incomingAsk boo
 	 */
 
 	stockTradePrice, stockTradeQty := getTradePriceAndQty(ask, bid)
-	askStatus, bidStatus, tr := fillOrderFn(ask, bid, stockTradePrice, stockTradeQty)
+
+	// every trade of an uncrossing book happens at the auction price
+	if ob.auctionPrice != 0 {
+		stockTradePrice = ob.auctionPrice
+	}
+
+	// The hidden part of a resting iceberg order can't be traded till it's shown
+	if !incomingAsk {
+		stockTradeQty = utils.MinInt64(stockTradeQty, visibleAskQuantity(ask))
+	}
+	if !incomingBid {
+		stockTradeQty = utils.MinInt64(stockTradeQty, visibleBidQuantity(bid))
+	}
+
+	askStatus, bidStatus, tr := fillOrderFn(ask, bid, stockTradePrice, stockTradeQty, incomingAsk, incomingBid)
+	ob.journal.recordFill(ask, bid, stockTradePrice, stockTradeQty, askStatus, bidStatus, tr)
 
 	if tr != nil {
 		l.Infof("Trade made between ask_id %d and bid_id %d at price %d", ask.Id, bid.Id, tr.Price)
 		// tr is always AskTransaction. So its StockQty < 0. Make it positive.
 		ob.depth.AddTrade(tr.Price, uint64(-tr.StockQuantity), tr.CreatedAt)
+		if ob.auctionPrice != 0 {
+			ob.auctionVolume += uint64(-tr.StockQuantity)
+		} else if halted, coolOffPeriod := ob.checkCircuitBreaker(tr.Price); halted {
+			ob.journal.record(&journalEntry{Type: journalHalt, Price: tr.Price})
+			ob.halt(coolOffPeriod)
+		}
 
 		// if ask is incoming, close just bid depth as ask hasn't even been added to depth
 		if !incomingBid {
@@ -482,38 +1353,226 @@ func (ob *orderBook) makeTrade(ask *models.Ask, bid *models.Bid, incomingAsk boo
 			ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, uint64(-tr.StockQuantity))
 		}
 
-		// Trigger stop losses here
-		ob.triggerStopLosses(tr)
+		// Show the next slice of icebergs whose visible slice just got filled
+		if !incomingBid && isSliceFilled(bid.IsIceberg, bid.StockQuantity, bid.StockQuantityFulfilled, bid.DisplayQuantity) {
+			ob.replenishBid(bid)
+		}
+		if !incomingAsk && isSliceFilled(ask.IsIceberg, ask.StockQuantity, ask.StockQuantityFulfilled, ask.DisplayQuantity) {
+			ob.replenishAsk(ask)
+		}
+
+		// The other legs of the orders' groups got cancelled by the trade
+		ob.cancelAskGroup(ask)
+		ob.cancelBidGroup(bid)
+
+		// Move the trailing stop losses along with the price, and then trigger stop losses here
+		if ob.auctionPrice == 0 {
+			ob.trailStopLosses(tr.Price)
+			ob.triggerStopLosses(tr.Price)
+		}
 	} else {
 		// If transaction didn't happen, but bidDone is true
 		// Thus the bid was faulty in some way or the order was cancelled
 		// Thus, the remaining stock quantity needs to be closed
-		// If the bid was already closed, then CancelOrder was called sometime before fillOrderFn
-		// was called. That means orderBook.CancelBidOrder would (have been)/(be) called. It will
-		// handle updating the depth.
-		if !incomingBid && bidStatus != models.BidAlreadyClosed {
-			unfulfilled := bid.StockQuantity - bid.StockQuantityFulfilled
-			ob.depth.CloseOrder(isMarket(bid.OrderType), false, bid.Price, unfulfilled)
+		// If the bid was already closed, orderBook.CancelBidOrder will be handled after this, and
+		// it won't find the bid in the queue anymore. So the depth has to be updated here.
+		if !incomingBid && bidStatus != models.BidUndone {
+			ob.depth.CloseOrder(isMarket(bid.OrderType), false, bid.Price, visibleBidQuantity(bid))
 		}
 		// If transaction didn't happen, but askDone is true
 		// Thus the ask was faulty in some way or the order was cancelled
 		// Thus, the remaining stock quantity needs to be closed
-		// If the ask was already closed, then CancelOrder was called sometime before fillOrderFn
-		// was called. That means orderBook.CancelAskOrder would (have been)/(be) called. It will
-		// handle updating the depth.
-		if !incomingAsk && askStatus != models.AskAlreadyClosed {
-			unfulfilled := ask.StockQuantity - ask.StockQuantityFulfilled
-			ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, unfulfilled)
+		// If the ask was already closed, orderBook.CancelAskOrder will be handled after this, and
+		// it won't find the ask in the queue anymore. So the depth has to be updated here.
+		if !incomingAsk && askStatus != models.AskUndone {
+			ob.depth.CloseOrder(isMarket(ask.OrderType), true, ask.Price, visibleAskQuantity(ask))
+		}
+	}
+
+	return askStatus != models.AskUndone, bidStatus != models.BidUndone, tr != nil
+}
+
+// checkCircuitBreaker checks if trading has to be halted after a trade at price. Books of options and
+// futures never halt.
+func (ob *orderBook) checkCircuitBreaker(price uint64) (bool, time.Duration) {
+	if ob.optionId != 0 || ob.futureId != 0 {
+		return false, 0
+	}
+	return checkCircuitBreakerFn(ob.stockId, price)
+}
+
+// replenishAsk shows the next slice of an iceberg ask whose visible slice has been filled.
+// The ask loses its time priority, and moves to the back of its price level.
+func (ob *orderBook) replenishAsk(ask *models.Ask) {
+	if ob.asks.Remove(ask.Id) == nil {
+		return
+	}
+	ob.asks.Push(ask)
+	ob.addAskToDepth(ask)
+}
+
+// replenishBid shows the next slice of an iceberg bid whose visible slice has been filled.
+// The bid loses its time priority, and moves to the back of its price level.
+func (ob *orderBook) replenishBid(bid *models.Bid) {
+	if ob.bids.Remove(bid.Id) == nil {
+		return
+	}
+	ob.bids.Push(bid)
+	ob.addBidToDepth(bid)
+}
+
+// trackGroupAsk remembers an ask that's a leg of a one-cancels-other group
+func (ob *orderBook) trackGroupAsk(ask *models.Ask) {
+	if ask.GroupId == 0 {
+		return
+	}
+	ob.groupLock.Lock()
+	ob.askGroups[ask.GroupId] = append(ob.askGroups[ask.GroupId], ask)
+	ob.groupLock.Unlock()
+}
+
+// trackGroupBid remembers a bid that's a leg of a one-cancels-other group
+func (ob *orderBook) trackGroupBid(bid *models.Bid) {
+	if bid.GroupId == 0 {
+		return
+	}
+	ob.groupLock.Lock()
+	ob.bidGroups[bid.GroupId] = append(ob.bidGroups[bid.GroupId], bid)
+	ob.groupLock.Unlock()
+}
+
+// cancelAskGroup removes the other legs of an ask's group from the book, once the ask has traded
+// or got cancelled. models has closed them already, in the same database transaction.
+func (ob *orderBook) cancelAskGroup(ask *models.Ask) {
+	if ask.GroupId == 0 {
+		return
+	}
+	ob.groupLock.Lock()
+	legs := ob.askGroups[ask.GroupId]
+	delete(ob.askGroups, ask.GroupId)
+	ob.groupLock.Unlock()
+
+	for _, leg := range legs {
+		if leg != ask {
+			ob.removeAsk(leg)
+		}
+	}
+}
+
+// cancelBidGroup removes the other legs of a bid's group from the book, once the bid has traded
+// or got cancelled. models has closed them already, in the same database transaction.
+func (ob *orderBook) cancelBidGroup(bid *models.Bid) {
+	if bid.GroupId == 0 {
+		return
+	}
+	ob.groupLock.Lock()
+	legs := ob.bidGroups[bid.GroupId]
+	delete(ob.bidGroups, bid.GroupId)
+	ob.groupLock.Unlock()
+
+	for _, leg := range legs {
+		if leg != bid {
+			ob.removeBid(leg)
 		}
 	}
+}
 
-	return askStatus != models.AskUndone, bidStatus != models.BidUndone
+// trackTrailingAsk remembers a trailing stoploss ask that has been added to the stoploss queue,
+// so that its trigger price can be moved after every trade
+func (ob *orderBook) trackTrailingAsk(ask *models.Ask) {
+	if !ask.IsTrailingStop() {
+		return
+	}
+	ob.trailingLock.Lock()
+	ob.trailingAsks[ask.Id] = ask
+	ob.trailingLock.Unlock()
+}
+
+// trackTrailingBid remembers a trailing stoploss bid that has been added to the stoploss queue,
+// so that its trigger price can be moved after every trade
+func (ob *orderBook) trackTrailingBid(bid *models.Bid) {
+	if !bid.IsTrailingStop() {
+		return
+	}
+	ob.trailingLock.Lock()
+	ob.trailingBids[bid.Id] = bid
+	ob.trailingLock.Unlock()
+}
+
+// trailStopLosses moves the trigger prices of trailing stoplosses after a trade at the given price
+// NOTE: 1. The trigger price of a trailing ask only goes up, and that of a trailing bid only goes down.
+//		 2. An order whose trigger price moved is taken out of its stoploss queue and pushed back in,
+//			so that the queue stays ordered by trigger price.
+//		 3. Orders that aren't in the stoploss queues anymore have been triggered or cancelled.
+//			They're forgotten.
+//		 4. Orders are trailed in the order of their ids, so that a replay of the journal trails them
+//			in the same order.
+func (ob *orderBook) trailStopLosses(price uint64) {
+	var l = ob.logger.WithFields(logrus.Fields{
+		"method":     "trailStopLosses",
+		"paramPrice": price,
+	})
+
+	ob.trailingLock.Lock()
+	defer ob.trailingLock.Unlock()
+
+	for _, askId := range sortedAskIds(ob.trailingAsks) {
+		ask := ob.trailingAsks[askId]
+		if !ob.askStoploss.Contains(askId) {
+			delete(ob.trailingAsks, askId)
+			continue
+		}
+
+		moved, err := trailAskFn(ask, price)
+		if err != nil {
+			l.Errorf("Error while moving the trigger price of ask %d: %+v", askId, err)
+		}
+		ob.journal.record(&journalEntry{Type: journalTrail, OrderId: askId, IsAsk: true, Price: ask.Price, Moved: moved})
+		if moved && ob.askStoploss.Remove(askId) != nil {
+			ob.askStoploss.Push(ask)
+		}
+	}
+
+	for _, bidId := range sortedBidIds(ob.trailingBids) {
+		bid := ob.trailingBids[bidId]
+		if !ob.bidStoploss.Contains(bidId) {
+			delete(ob.trailingBids, bidId)
+			continue
+		}
+
+		moved, err := trailBidFn(bid, price)
+		if err != nil {
+			l.Errorf("Error while moving the trigger price of bid %d: %+v", bidId, err)
+		}
+		ob.journal.record(&journalEntry{Type: journalTrail, OrderId: bidId, IsAsk: false, Price: bid.Price, Moved: moved})
+		if moved && ob.bidStoploss.Remove(bidId) != nil {
+			ob.bidStoploss.Push(bid)
+		}
+	}
+}
+
+// triggerAsk turns a stoploss ask into an active order. It isn't put in any queue.
+func (ob *orderBook) triggerAsk(ask *models.Ask) {
+	ob.journal.record(&journalEntry{Type: journalTrigger, OrderId: ask.Id, IsAsk: true})
+	if err := triggerAskStoplossFn(ask); err != nil {
+		ob.logger.Errorf("Error while triggering %+v", ask)
+	}
+}
+
+// triggerBid turns a stoploss bid into an active order. It isn't put in any queue.
+func (ob *orderBook) triggerBid(bid *models.Bid) {
+	ob.journal.record(&journalEntry{Type: journalTrigger, OrderId: bid.Id})
+	if err := triggerBidStoplossFn(bid); err != nil {
+		ob.logger.Errorf("Error while triggering %+v", bid)
+	}
 }
 
 /*
- *	Method to check and trigger(if possible) StopLoss orders whenever a transaction occurs
+ *	Method to check and trigger(if possible) StopLoss and StopLimit orders whenever a transaction occurs.
+ *	Triggered StopLoss orders trade like market orders. Triggered StopLimit orders are matched at their limit price
+ *	by processTriggeredOrders, and rest in the book if they can't be filled.
  */
-func (ob *orderBook) triggerStopLosses(tr *models.Transaction) {
+func (ob *orderBook) triggerStopLosses(price uint64) {
 	var l = ob.logger.WithFields(logrus.Fields{
 		"method": "triggerStopLosses",
 	})
@@ -522,16 +1581,28 @@ func (ob *orderBook) triggerStopLosses(tr *models.Transaction) {
 	topAskStoploss := ob.askStoploss.Head()
 	// We trigger asks having higher than current price. That's because if it's an ask stoploss, then we will sell
 	// if the current price goes below the trigger price.
-	for topAskStoploss != nil && tr.Price <= topAskStoploss.Price {
+	for topAskStoploss != nil && price <= topAskStoploss.Price {
 		l.Debugf("Triggering ask %+v", topAskStoploss)
 
 		topAskStoploss = ob.askStoploss.Pop()
-		if err := topAskStoploss.TriggerStoploss(); err != nil {
-			l.Errorf("Error while changing state of %+v from StopLoss to StopLossActive", topAskStoploss)
+
+		// it might have been cancelled after it got added to the stoploss queue
+		topAskStoploss.Lock()
+		isClosed := topAskStoploss.IsClosed
+		topAskStoploss.Unlock()
+		if isClosed {
+			topAskStoploss = ob.askStoploss.Head()
+			continue
 		}
 
-		ob.asks.Push(topAskStoploss)
-		ob.depth.AddOrder(true, true, topAskStoploss.Price, topAskStoploss.StockQuantity)
+		ob.triggerAsk(topAskStoploss)
+
+		if topAskStoploss.OrderType == models.StopLimitActive {
+			ob.triggeredAsks = append(ob.triggeredAsks, topAskStoploss)
+		} else {
+			ob.asks.Push(topAskStoploss)
+			ob.addAskToDepth(topAskStoploss)
+		}
 		topAskStoploss = ob.askStoploss.Head()
 	}
 
@@ -539,20 +1610,49 @@ func (ob *orderBook) triggerStopLosses(tr *models.Transaction) {
 	// We trigger bids having lower than current price. That's because if it's a bid stoploss, we will buy if the
 	// current price goes above the trigger price.
 	topBidStoploss := ob.bidStoploss.Head()
-	for topBidStoploss != nil && tr.Price >= topBidStoploss.Price {
+	for topBidStoploss != nil && price >= topBidStoploss.Price {
 		l.Debugf("Triggering bid %+v", topBidStoploss)
 
 		topBidStoploss = ob.bidStoploss.Pop()
-		if err := topBidStoploss.TriggerStoploss(); err != nil {
-			l.Errorf("Error while changing state of %+v from StopLoss to StopLossActive", topBidStoploss)
+
+		// it might have been cancelled after it got added to the stoploss queue
+		topBidStoploss.Lock()
+		isClosed := topBidStoploss.IsClosed
+		topBidStoploss.Unlock()
+		if isClosed {
+			topBidStoploss = ob.bidStoploss.Head()
+			continue
 		}
 
-		ob.bids.Push(topBidStoploss)
-		ob.depth.AddOrder(true, false, topBidStoploss.Price, topBidStoploss.StockQuantity)
+		ob.triggerBid(topBidStoploss)
+
+		if topBidStoploss.OrderType == models.StopLimitActive {
+			ob.triggeredBids = append(ob.triggeredBids, topBidStoploss)
+		} else {
+			ob.bids.Push(topBidStoploss)
+			ob.addBidToDepth(topBidStoploss)
+		}
 		topBidStoploss = ob.bidStoploss.Head()
 	}
 }
 
+// processTriggeredOrders matches the stop-limit orders triggered while handling the last input, like
+// incoming orders. Their trades might trigger more of them, which get matched too.
+func (ob *orderBook) processTriggeredOrders() {
+	for len(ob.triggeredAsks) > 0 || len(ob.triggeredBids) > 0 {
+		if len(ob.triggeredAsks) > 0 {
+			ask := ob.triggeredAsks[0]
+			ob.triggeredAsks = ob.triggeredAsks[1:]
+			ob.processAsk(ask)
+			continue
+		}
+
+		bid := ob.triggeredBids[0]
+		ob.triggeredBids = ob.triggeredBids[1:]
+		ob.processBid(bid)
+	}
+}
+
 /*
  *	Method to clear the existing orders for a particular stock
  */
@@ -561,48 +1661,190 @@ func (ob *orderBook) clearExistingOrders() {
 		"method": "clearExistingOrders",
 	})
 
-	if ob.bids.Head() == nil {
+	var askDone, bidDone, traded bool
+
+	// bidTop stays in the queue till it's done. It is removed from there once it finishes
+	bidTop := ob.bids.Head()
+	if bidTop == nil {
 		return
 	}
-
-	var askDone, bidDone bool
-
-	bidTop := ob.bids.Pop()
 	askTop, addBackOrders := ob.getTopMatchingAsk(bidTop)
 
 	for bidTop != nil && askTop != nil {
 		// treating both ask and bid as non-incoming orders
 		// which means depth will be updated for both.
-		askDone, bidDone = ob.makeTrade(askTop, bidTop, false, false)
+		askDone, bidDone, traded = ob.makeTrade(askTop, bidTop, false, false)
+
+		// if ask is done, remove it. Depth is already updated. No need to worry.
+		if askDone {
+			ob.asks.Remove(askTop.Id)
+		}
 		addBackOrders()
 
 		// Check if error occurred in acquiring locks or database transactions
-		if askDone == false && bidDone == false {
+		if !traded && askDone == false && bidDone == false {
 			l.Errorf("makeTrade returned both askDone, bidDone false")
 			return
 		}
 
-		// if ask is done, remove it. Depth is already updated. No need to worry.
-		if askDone {
-			ob.asks.Pop()
-		}
-		// if current bid is fulfilled, move to next bid
+		// if current bid is fulfilled, remove it. If the visible slice of an iceberg bid got
+		// filled, it has moved to the back of its price level. Either way, move to the next bid
 		if bidDone {
-			bidTop = ob.bids.Pop()
+			ob.bids.Remove(bidTop.Id)
 		}
+
+		// matching stops if the trade halted the stock
+		if ob.isHalted {
+			return
+		}
+
+		bidTop = ob.bids.Head()
 		if bidTop != nil {
 			// this will work even when askDone = false, bidDone = true including
 			// the weird case where same user bids-asks get involved
 			askTop, addBackOrders = ob.getTopMatchingAsk(bidTop)
 		}
-		// otherwise the loop will break. Since askTop hasn't been popped from
+		// otherwise the loop will break. Since askTop hasn't been removed from
 		// asks queue, so no need to worry about it
 	}
+}
 
-	// if bid is not fulfilled and asks queue becomes empty. Shouldn't update depth
-	if bidTop != nil {
-		ob.bids.Push(bidTop)
+// uncross ends the call auction, and matches the orders collected during it
+func (ob *orderBook) uncross() {
+	if !ob.inAuction {
+		return
 	}
+	ob.inAuction = false
+
+	// a halted stock uncrosses when trading resumes
+	if ob.isHalted {
+		return
+	}
+
+	ob.matchCollectedOrders()
+}
+
+// matchCollectedOrders matches the orders collected during a call auction or a halt at the price
+// that trades the most stocks. The stock's price is then set to that price.
+// NOTE: 1. Only orders that can trade at the auction price get matched. Crossing the best bids
+//			with the best asks, like clearExistingOrders does, trades exactly the auction volume, as
+//			no stoplosses join the book while it uncrosses.
+//		 2. The indicative price published meanwhile is cleared.
+//		 3. Once the book has uncrossed, stoplosses are trailed and triggered at the auction price.
+//			The triggered ones then trade with the orders left in the book, at their prices.
+func (ob *orderBook) matchCollectedOrders() {
+	var l = ob.logger.WithFields(logrus.Fields{
+		"method": "matchCollectedOrders",
+	})
+
+	ob.depth.SetIndicativePrice(0, 0)
+
+	price, volume := getAuctionPrice(ob.asks.Orders(), ob.bids.Orders(), ob.currentPrice())
+	if volume == 0 {
+		l.Infof("No orders cross. Nothing to uncross")
+		return
+	}
+
+	l.Infof("Uncrossing %d stocks at price %d", volume, price)
+
+	ob.auctionPrice = price
+	ob.auctionVolume = 0
+	ob.clearExistingOrders()
+	tradedVolume := ob.auctionVolume
+	ob.auctionPrice = 0
+	ob.auctionVolume = 0
+
+	if tradedVolume == 0 {
+		return
+	}
+
+	if err := setAuctionPriceFn(ob.stockId, price, tradedVolume); err != nil {
+		l.Errorf("Error while setting the auction price: %+v", err)
+	}
+
+	ob.trailStopLosses(price)
+	ob.triggerStopLosses(price)
+	ob.clearExistingOrders()
+}
+
+// halt stops matching orders till the cooling-off period is over
+func (ob *orderBook) halt(coolOffPeriod time.Duration) {
+	ob.logger.Infof("Halting matching for %s", coolOffPeriod)
+
+	ob.isHalted = true
+	ob.isCoolingOff = true
+	time.AfterFunc(coolOffPeriod, func() {
+		select {
+		case ob.resumeChan <- struct{}{}:
+		case <-ob.stopped:
+		}
+	})
+}
+
+// resume starts matching orders again once the cooling-off period of a halt is over. Orders that
+// reached the book during the halt are matched at a single price first, like after a call auction.
+// If a call auction is running, they wait for it to uncross instead.
+// NOTE: The book stays halted while it's suspended, or a cooling-off period is running.
+func (ob *orderBook) resume() {
+	if ob.isSuspended || ob.isCoolingOff {
+		return
+	}
+	ob.isHalted = false
+
+	if !ob.inAuction {
+		ob.matchCollectedOrders()
+	}
+
+	resumeStockFn(ob.stockId)
+}
+
+// endCoolOff resumes matching once the cooling-off period of a halt is over, unless the book is suspended
+func (ob *orderBook) endCoolOff() {
+	ob.isCoolingOff = false
+	ob.resume()
+}
+
+// suspend halts the book till it's unsuspended
+func (ob *orderBook) suspend() {
+	ob.logger.Infof("Suspending matching")
+
+	ob.isSuspended = true
+	ob.isHalted = true
+}
+
+// unsuspend lifts the suspension, and resumes matching
+func (ob *orderBook) unsuspend() {
+	if !ob.isSuspended {
+		return
+	}
+	ob.logger.Infof("Lifting suspension")
+
+	ob.isSuspended = false
+	ob.resume()
+}
+
+// stop makes the goroutine matching orders return, and closes the journal
+func (ob *orderBook) stop() {
+	ob.logger.Infof("Stopping")
+
+	close(ob.stopped)
+	ob.journal.close()
+}
+
+// publishIndicativePrice publishes the price and volume at which the book would uncross right now
+func (ob *orderBook) publishIndicativePrice() {
+	ob.depth.SetIndicativePrice(getAuctionPrice(ob.asks.Orders(), ob.bids.Orders(), ob.currentPrice()))
+}
+
+// currentPrice returns the current price of the stock
+func (ob *orderBook) currentPrice() uint64 {
+	stock, err := getStockCopyFn(ob.stockId)
+	if err != nil {
+		ob.logger.Errorf("Unable to get stock: %+v", err)
+	}
+
+	ob.journal.record(&journalEntry{Type: journalStockPrice, Price: stock.CurrentPrice})
+	return stock.CurrentPrice
 }
 
 /*
@@ -613,13 +1855,106 @@ func (ob *orderBook) waitForOrder() {
 		"method": "waitForOrder",
 	})
 
+	// the input that was processed, and when it was received
+	var input string
+	var start time.Time
+
 	select {
 	case askOrder := <-ob.askChan:
+		input, start = "AddAsk", time.Now()
 		l.Debugf("Got ask %+v. Processing", askOrder)
+		ob.journal.recordAsk(journalAddAsk, askOrder)
 		ob.processAsk(askOrder)
 
 	case bidOrder := <-ob.bidChan:
+		input, start = "AddBid", time.Now()
 		l.Debugf("Got bid %+v. Processing", bidOrder)
+		ob.journal.recordBid(journalAddBid, bidOrder)
 		ob.processBid(bidOrder)
+
+	case askOrder := <-ob.stoplossAskChan:
+		input, start = "AddStoplossAsk", time.Now()
+		l.Debugf("Got stoploss ask %+v. Adding", askOrder)
+		ob.journal.recordAsk(journalAddStoplossAsk, askOrder)
+		ob.addStoplossAsk(askOrder)
+
+	case bidOrder := <-ob.stoplossBidChan:
+		input, start = "AddStoplossBid", time.Now()
+		l.Debugf("Got stoploss bid %+v. Adding", bidOrder)
+		ob.journal.recordBid(journalAddStoplossBid, bidOrder)
+		ob.addStoplossBid(bidOrder)
+
+	case askOrder := <-ob.cancelAskChan:
+		input, start = "CancelAsk", time.Now()
+		l.Debugf("Got cancelled ask %+v. Removing", askOrder)
+		ob.journal.record(&journalEntry{Type: journalCancelAsk, OrderId: askOrder.Id, IsAsk: true})
+		ob.cancelAsk(askOrder)
+
+	case bidOrder := <-ob.cancelBidChan:
+		input, start = "CancelBid", time.Now()
+		l.Debugf("Got cancelled bid %+v. Removing", bidOrder)
+		ob.journal.record(&journalEntry{Type: journalCancelBid, OrderId: bidOrder.Id})
+		ob.cancelBid(bidOrder)
+
+	case modification := <-ob.modifyChan:
+		input, start = "Modify", time.Now()
+		l.Debugf("Got modification %+v. Processing", modification)
+		ob.journal.record(&journalEntry{
+			Type:          journalModify,
+			UserId:        modification.userId,
+			OrderId:       modification.orderId,
+			IsAsk:         modification.isAsk,
+			Price:         modification.price,
+			StockQuantity: modification.stockQuantity,
+		})
+		modification.done <- ob.modifyOrder(modification)
+
+	case <-ob.auctionChan:
+		input, start = "StartCallAuction", time.Now()
+		l.Debugf("Starting call auction")
+		ob.journal.record(&journalEntry{Type: journalStartAuction})
+		ob.inAuction = true
+
+	case done := <-ob.uncrossChan:
+		input, start = "Uncross", time.Now()
+		l.Debugf("Uncrossing")
+		ob.journal.record(&journalEntry{Type: journalUncross})
+		ob.uncross()
+		close(done)
+
+	case <-ob.resumeChan:
+		input, start = "Resume", time.Now()
+		l.Debugf("Resuming after halt")
+		ob.journal.record(&journalEntry{Type: journalResume})
+		ob.endCoolOff()
+
+	case suspend := <-ob.suspendChan:
+		start = time.Now()
+		if suspend {
+			input = "Suspend"
+			l.Debugf("Suspending")
+			ob.journal.record(&journalEntry{Type: journalSuspend})
+			ob.suspend()
+		} else {
+			input = "Unsuspend"
+			l.Debugf("Unsuspending")
+			ob.journal.record(&journalEntry{Type: journalUnsuspend})
+			ob.unsuspend()
+		}
+
+	case done := <-ob.stopChan:
+		l.Debugf("Stopping")
+		ob.journal.record(&journalEntry{Type: journalStop})
+		ob.stop()
+		close(done)
+		return
+	}
+
+	ob.processTriggeredOrders()
+
+	if ob.inAuction || ob.isHalted {
+		ob.publishIndicativePrice()
 	}
+
+	orderProcessingDuration.Observe(time.Since(start).Seconds(), strconv.FormatUint(uint64(ob.stockId), 10), input)
 }
@@ -16,10 +16,10 @@ import (
 func getMockObjects(t *testing.T) (
 	*gomock.Controller,
 	*orderBook,
-	*mocks.MockAskPQueue,
-	*mocks.MockBidPQueue,
-	*mocks.MockAskPQueue,
-	*mocks.MockBidPQueue,
+	*mocks.MockAskQueue,
+	*mocks.MockBidQueue,
+	*mocks.MockAskQueue,
+	*mocks.MockBidQueue,
 	*mocks.MockMarketDepthStream,
 	uint32,
 	uint64,
@@ -31,10 +31,10 @@ func getMockObjects(t *testing.T) (
 	var stockQuantity uint64 = 10
 	var stockPrice uint64 = 20
 
-	mockAskQueue := mocks.NewMockAskPQueue(mockControl)
-	mockBidQueue := mocks.NewMockBidPQueue(mockControl)
-	mockAskStoplossQueue := mocks.NewMockAskPQueue(mockControl)
-	mockBidStoplossQueue := mocks.NewMockBidPQueue(mockControl)
+	mockAskQueue := mocks.NewMockAskQueue(mockControl)
+	mockBidQueue := mocks.NewMockBidQueue(mockControl)
+	mockAskStoplossQueue := mocks.NewMockAskQueue(mockControl)
+	mockBidStoplossQueue := mocks.NewMockBidQueue(mockControl)
 	mockDepth := mocks.NewMockMarketDepthStream(mockControl)
 
 	ob := &orderBook{
@@ -42,14 +42,18 @@ func getMockObjects(t *testing.T) (
 			"module":        "matchingengine.OrderBook.test",
 			"param_stockId": stockID,
 		}),
-		stockId:     stockID,
-		askChan:     make(chan *models.Ask),
-		bidChan:     make(chan *models.Bid),
-		asks:        mockAskQueue,
-		bids:        mockBidQueue,
-		askStoploss: mockAskStoplossQueue,
-		bidStoploss: mockBidStoplossQueue,
-		depth:       mockDepth,
+		stockId:      stockID,
+		askChan:      make(chan *models.Ask),
+		bidChan:      make(chan *models.Bid),
+		asks:         mockAskQueue,
+		bids:         mockBidQueue,
+		askStoploss:  mockAskStoplossQueue,
+		bidStoploss:  mockBidStoplossQueue,
+		depth:        mockDepth,
+		trailingAsks: make(map[uint32]*models.Ask),
+		trailingBids: make(map[uint32]*models.Bid),
+		askGroups:    make(map[uint32][]*models.Ask),
+		bidGroups:    make(map[uint32][]*models.Bid),
 	}
 
 	return mockControl, ob, mockAskQueue, mockBidQueue, mockAskStoplossQueue, mockBidStoplossQueue, mockDepth, stockID, stockQuantity, stockPrice
@@ -58,10 +62,10 @@ func getMockObjects(t *testing.T) (
 func getMockAndTestObjects(t *testing.T) (
 	*gomock.Controller,
 	*orderBook,
-	*AskPQueue,
-	*BidPQueue,
-	*AskPQueue,
-	*BidPQueue,
+	*AskQueue,
+	*BidQueue,
+	*AskQueue,
+	*BidQueue,
 	*mocks.MockMarketDepthStream,
 	uint32,
 	uint64,
@@ -73,10 +77,10 @@ func getMockAndTestObjects(t *testing.T) (
 	var stockQuantity uint64 = 10
 	var stockPrice uint64 = 20
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	mockDepth := mocks.NewMockMarketDepthStream(mockControl)
 
 	ob := &orderBook{
@@ -84,14 +88,18 @@ func getMockAndTestObjects(t *testing.T) (
 			"module":        "matchingengine.OrderBook.test",
 			"param_stockId": stockID,
 		}),
-		stockId:     stockID,
-		askChan:     make(chan *models.Ask),
-		bidChan:     make(chan *models.Bid),
-		asks:        testAskQueue,
-		bids:        testBidQueue,
-		askStoploss: testAskStoplossQueue,
-		bidStoploss: testBidStoplossQueue,
-		depth:       mockDepth,
+		stockId:      stockID,
+		askChan:      make(chan *models.Ask),
+		bidChan:      make(chan *models.Bid),
+		asks:         testAskQueue,
+		bids:         testBidQueue,
+		askStoploss:  testAskStoplossQueue,
+		bidStoploss:  testBidStoplossQueue,
+		depth:        mockDepth,
+		trailingAsks: make(map[uint32]*models.Ask),
+		trailingBids: make(map[uint32]*models.Bid),
+		askGroups:    make(map[uint32][]*models.Ask),
+		bidGroups:    make(map[uint32][]*models.Bid),
 	}
 
 	return mockControl, ob, &testAskQueue, &testBidQueue, &testAskStoplossQueue, &testBidStoplossQueue, mockDepth, stockID, stockQuantity, stockPrice
@@ -293,15 +301,18 @@ func TestOrderBookCancelAskOrder(t *testing.T) {
 	config := utils.GetConfiguration()
 	utils.Init(config)
 
-	mockControl, ob, _, _, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	mockControl, ob, mockAskQueue, _, mockAskStoplossQueue, _, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
 	defer mockControl.Finish()
 
 	limitAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	limitAsk.Id = 1
 	unfulfilled := limitAsk.StockQuantity - limitAsk.StockQuantityFulfilled
 
+	mockAskStoplossQueue.EXPECT().Remove(limitAsk.Id).Return(nil)
+	mockAskQueue.EXPECT().Remove(limitAsk.Id).Return(limitAsk)
 	mockDepth.EXPECT().CloseOrder(isMarket(limitAsk.OrderType), true, limitAsk.Price, unfulfilled)
 
-	ob.CancelAskOrder(limitAsk)
+	ob.cancelAsk(limitAsk)
 
 }
 
@@ -310,29 +321,87 @@ func TestOrderBookCancelAskOrderStoploss(t *testing.T) {
 	config := utils.GetConfiguration()
 	utils.Init(config)
 
-	mockControl, ob, _, _, _, _, _, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	mockControl, ob, _, _, mockAskStoplossQueue, _, _, stockID, stockQuantity, stockPrice := getMockObjects(t)
 	defer mockControl.Finish()
 
 	stoplossAsk := makeAsk(1, stockID, models.StopLoss, stockQuantity, stockPrice, "")
+	stoplossAsk.Id = 1
 
-	ob.CancelAskOrder(stoplossAsk)
+	mockAskStoplossQueue.EXPECT().Remove(stoplossAsk.Id).Return(stoplossAsk)
+
+	ob.cancelAsk(stoplossAsk)
 
 }
 
+func TestOrderBookCancelAskOrderGroup(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, mockAskQueue, _, mockAskStoplossQueue, _, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	defer mockControl.Finish()
+
+	takeProfitAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice+5, "")
+	takeProfitAsk.Id = 1
+	takeProfitAsk.GroupId = 7
+	stopAsk := makeAsk(1, stockID, models.StopLoss, stockQuantity, stockPrice-5, "")
+	stopAsk.Id = 2
+	stopAsk.GroupId = 7
+
+	ob.trackGroupAsk(takeProfitAsk)
+	ob.trackGroupAsk(stopAsk)
+
+	// the cancelled ask is removed first, and then the other leg of its group
+	gomock.InOrder(
+		mockAskStoplossQueue.EXPECT().Remove(takeProfitAsk.Id).Return(nil),
+		mockAskQueue.EXPECT().Remove(takeProfitAsk.Id).Return(takeProfitAsk),
+		mockDepth.EXPECT().CloseOrder(false, true, takeProfitAsk.Price, takeProfitAsk.StockQuantity),
+		mockAskStoplossQueue.EXPECT().Remove(stopAsk.Id).Return(stopAsk),
+	)
+
+	ob.cancelAsk(takeProfitAsk)
+
+	if _, ok := ob.askGroups[takeProfitAsk.GroupId]; ok {
+		t.Errorf("Group %d is still being tracked after being cancelled", takeProfitAsk.GroupId)
+	}
+}
+
 func TestOrderBookCancelBidOrder(t *testing.T) {
 
 	config := utils.GetConfiguration()
 	utils.Init(config)
 
-	mockControl, ob, _, _, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	mockControl, ob, _, mockBidQueue, _, mockBidStoplossQueue, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
 	defer mockControl.Finish()
 
 	limitBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	limitBid.Id = 1
 	unfulfilled := limitBid.StockQuantity - limitBid.StockQuantityFulfilled
 
+	mockBidStoplossQueue.EXPECT().Remove(limitBid.Id).Return(nil)
+	mockBidQueue.EXPECT().Remove(limitBid.Id).Return(limitBid)
 	mockDepth.EXPECT().CloseOrder(isMarket(limitBid.OrderType), false, limitBid.Price, unfulfilled)
 
-	ob.CancelBidOrder(limitBid)
+	ob.cancelBid(limitBid)
+}
+
+func TestOrderBookCancelBidOrderAlreadyRemoved(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, mockBidQueue, _, mockBidStoplossQueue, _, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	defer mockControl.Finish()
+
+	limitBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	limitBid.Id = 1
+
+	// makeTrade has removed the bid from the queue and the depth already.
+	// So depth mustn't be touched here.
+	mockBidStoplossQueue.EXPECT().Remove(limitBid.Id).Return(nil)
+	mockBidQueue.EXPECT().Remove(limitBid.Id).Return(nil)
+
+	ob.cancelBid(limitBid)
 }
 
 func TestOrderBookCancelBidOrderStoploss(t *testing.T) {
@@ -340,13 +409,957 @@ func TestOrderBookCancelBidOrderStoploss(t *testing.T) {
 	config := utils.GetConfiguration()
 	utils.Init(config)
 
-	mockControl, ob, _, _, _, _, _, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	mockControl, ob, _, _, _, mockBidStoplossQueue, _, stockID, stockQuantity, stockPrice := getMockObjects(t)
 	defer mockControl.Finish()
 
 	stoplossBid := makeBid(1, stockID, models.StopLoss, stockQuantity, stockPrice, "")
+	stoplossBid.Id = 1
+
+	mockBidStoplossQueue.EXPECT().Remove(stoplossBid.Id).Return(stoplossBid)
+
+	ob.cancelBid(stoplossBid)
+
+}
+
+func TestOrderBookAmendAskQuantityDecrease(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, mockAskQueue, _, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockObjects(t)
+	defer mockControl.Finish()
+
+	limitAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	limitAsk.Id = 1
+
+	// the ask keeps its place in the queue. Only the depth is updated
+	var newStockQuantity uint64 = 6
+	limitAsk.StockQuantity = newStockQuantity
+
+	mockAskQueue.EXPECT().Contains(limitAsk.Id).Return(true)
+	mockDepth.EXPECT().CloseOrder(false, true, stockPrice, stockQuantity-newStockQuantity)
+
+	ob.amendAsk(limitAsk, stockPrice, stockQuantity)
+}
+
+func TestOrderBookAmendBidQuantityDecreaseKeepsPriority(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, testBidQueue, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
 
-	ob.CancelBidOrder(stoplossBid)
+	firstBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	firstBid.Id = 1
+	secondBid := makeBid(2, stockID, models.Limit, stockQuantity, stockPrice, "")
+	secondBid.Id = 2
+
+	(*testBidQueue).Push(firstBid)
+	(*testBidQueue).Push(secondBid)
+
+	var newStockQuantity uint64 = 4
+	firstBid.StockQuantity = newStockQuantity
+
+	mockDepth.EXPECT().CloseOrder(false, false, stockPrice, stockQuantity-newStockQuantity)
+
+	ob.amendBid(firstBid, stockPrice, stockQuantity)
+
+	if (*testBidQueue).Head() != firstBid {
+		t.Errorf("Bid lost its time priority after its quantity was reduced")
+	}
+}
+
+func TestOrderBookAmendBidPriceChange(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, testBidQueue, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	firstBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	firstBid.Id = 1
+	secondBid := makeBid(2, stockID, models.Limit, stockQuantity, stockPrice+2, "")
+	secondBid.Id = 2
+
+	(*testBidQueue).Push(firstBid)
+	(*testBidQueue).Push(secondBid)
+
+	// there are no asks, so the bid just moves to its new price level
+	var newPrice = stockPrice + 5
+	firstBid.Price = newPrice
+
+	gomock.InOrder(
+		mockDepth.EXPECT().CloseOrder(false, false, stockPrice, stockQuantity),
+		mockDepth.EXPECT().AddOrder(false, false, newPrice, stockQuantity),
+	)
+
+	ob.amendBid(firstBid, stockPrice, stockQuantity)
+
+	if (*testBidQueue).Head() != firstBid {
+		t.Errorf("Bid wasn't moved to its new price level")
+	}
+	if (*testBidQueue).Size() != 2 {
+		t.Errorf("Expected 2 bids in the queue, got %d", (*testBidQueue).Size())
+	}
+}
+
+func TestOrderBookModifyOrderFailed(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	// no expectations are set. The queues and the depth mustn't be touched
+	mockControl, ob, _, _, _, _, _, _, _, _ := getMockObjects(t)
+	defer mockControl.Finish()
+
+	oldModifyOrderFn := modifyOrderFn
+	defer func() { modifyOrderFn = oldModifyOrderFn }()
+
+	modifyErr := models.InvalidOrderIDError{}
+	modifyOrderFn = func(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) (*models.ModifiedOrder, error) {
+		return nil, modifyErr
+	}
+
+	err := ob.modifyOrder(&orderModification{userId: 1, orderId: 1, isAsk: true, price: 20, stockQuantity: 10})
+	if err != modifyErr {
+		t.Errorf("Expected %v, got %v", modifyErr, err)
+	}
+}
+
+func TestOrderBookProcessAskImmediateOrCancel(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
 
+	// the depth mustn't be touched, as the ask never rests in the book
+	mockControl, ob, testAskQueue, _, _, _, _, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	iocAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	iocAsk.Id = 1
+	iocAsk.TimeInForce = models.ImmediateOrCancel
+
+	oldExpireAskFn := expireAskFn
+	defer func() { expireAskFn = oldExpireAskFn }()
+
+	var expiredAsk *models.Ask
+	expireAskFn = func(ask *models.Ask) error {
+		expiredAsk = ask
+		return nil
+	}
+
+	ob.processAsk(iocAsk)
+
+	if expiredAsk != iocAsk {
+		t.Errorf("Unfulfilled immediate-or-cancel ask wasn't expired")
+	}
+	if !(*testAskQueue).Empty() {
+		t.Errorf("Immediate-or-cancel ask was added to the queue")
+	}
+}
+
+func TestOrderBookProcessBidFillOrKill(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, testAskQueue, _, _, _, _, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	// only half of the bid can be fulfilled by the asks of the other users
+	otherUserAsk := makeAsk(2, stockID, models.Limit, stockQuantity/2, stockPrice, "")
+	otherUserAsk.Id = 1
+	sameUserAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	sameUserAsk.Id = 2
+	(*testAskQueue).Push(otherUserAsk)
+	(*testAskQueue).Push(sameUserAsk)
+
+	fokBid := makeBid(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	fokBid.Id = 3
+	fokBid.TimeInForce = models.FillOrKill
+
+	oldFillOrderFn := fillOrderFn
+	oldCanFillFn := canFillFn
+	oldExpireBidFn := expireBidFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		canFillFn = oldCanFillFn
+		expireBidFn = oldExpireBidFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		t.Fatalf("Fill-or-kill bid got traded although it couldn't be fulfilled completely")
+		return models.AskUndone, models.BidUndone, nil
+	}
+
+	canFillFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, bidIsTaker bool) bool {
+		return true
+	}
+
+	var expiredBid *models.Bid
+	expireBidFn = func(bid *models.Bid) error {
+		expiredBid = bid
+		return nil
+	}
+
+	ob.processBid(fokBid)
+
+	if expiredBid != fokBid {
+		t.Errorf("Fill-or-kill bid wasn't expired")
+	}
+	if (*testAskQueue).Size() != 2 || (*testAskQueue).Head() != otherUserAsk {
+		t.Errorf("Asks weren't put back in the same order after checking the fill-or-kill bid")
+	}
+}
+
+func TestOrderBookCanFillAsk(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, testBidQueue, _, _, _, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	firstBid := makeBid(2, stockID, models.Limit, stockQuantity/2, stockPrice+1, "")
+	firstBid.Id = 1
+	secondBid := makeBid(3, stockID, models.Limit, stockQuantity/2, stockPrice, "")
+	secondBid.Id = 2
+	(*testBidQueue).Push(secondBid)
+	(*testBidQueue).Push(firstBid)
+
+	fokAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	fokAsk.TimeInForce = models.FillOrKill
+
+	oldCanFillFn := canFillFn
+	defer func() {
+		canFillFn = oldCanFillFn
+	}()
+
+	canFillFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, bidIsTaker bool) bool {
+		return true
+	}
+
+	if !ob.canFillAsk(fokAsk) {
+		t.Errorf("Expected the fill-or-kill ask to be fulfillable")
+	}
+
+	fokAsk.Price = stockPrice + 1
+	if ob.canFillAsk(fokAsk) {
+		t.Errorf("Expected the fill-or-kill ask not to be fulfillable at a higher price")
+	}
+
+	if (*testBidQueue).Size() != 2 || (*testBidQueue).Pop() != firstBid || (*testBidQueue).Pop() != secondBid {
+		t.Errorf("Bids weren't put back in the same order after checking the fill-or-kill ask")
+	}
+}
+
+func TestOrderBookCanFillAskWithoutCounterpartyCash(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, testBidQueue, _, _, _, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	firstBid := makeBid(2, stockID, models.Limit, stockQuantity/2, stockPrice+1, "")
+	firstBid.Id = 1
+	brokeBid := makeBid(3, stockID, models.Limit, stockQuantity/2, stockPrice, "")
+	brokeBid.Id = 2
+	(*testBidQueue).Push(brokeBid)
+	(*testBidQueue).Push(firstBid)
+
+	fokAsk := makeAsk(1, stockID, models.Limit, stockQuantity, stockPrice, "")
+	fokAsk.TimeInForce = models.FillOrKill
+
+	oldCanFillFn := canFillFn
+	defer func() {
+		canFillFn = oldCanFillFn
+	}()
+
+	// the user of brokeBid doesn't have the cash to pay for the trade
+	canFillFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, bidIsTaker bool) bool {
+		return bid != brokeBid
+	}
+
+	if ob.canFillAsk(fokAsk) {
+		t.Errorf("Expected the fill-or-kill ask not to be fulfillable when a bidder can't pay")
+	}
+
+	// another bid makes up for it
+	otherBid := makeBid(4, stockID, models.Limit, stockQuantity/2, stockPrice, "")
+	otherBid.Id = 3
+	(*testBidQueue).Push(otherBid)
+
+	if !ob.canFillAsk(fokAsk) {
+		t.Errorf("Expected the fill-or-kill ask to be fulfillable by the bidders who can pay")
+	}
+
+	if (*testBidQueue).Size() != 3 || (*testBidQueue).Pop() != firstBid || (*testBidQueue).Pop() != brokeBid || (*testBidQueue).Pop() != otherBid {
+		t.Errorf("Bids weren't put back in the same order after checking the fill-or-kill ask")
+	}
+}
+
+func TestOrderBookProcessAskAgainstIcebergBid(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, testBidQueue, _, _, mockDepth, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	var displayQuantity uint64 = 4
+
+	// the slices are counted from the end of the unfulfilled quantity, so all of them are full
+	icebergBid := makeBid(2, stockID, models.Limit, 3*displayQuantity, stockPrice, "")
+	icebergBid.Id = 1
+	icebergBid.IsIceberg = true
+	icebergBid.DisplayQuantity = displayQuantity
+	otherBid := makeBid(3, stockID, models.Limit, stockQuantity/2, stockPrice, "")
+	otherBid.Id = 2
+	(*testBidQueue).Push(icebergBid)
+	(*testBidQueue).Push(otherBid)
+
+	ask := makeAsk(1, stockID, models.Limit, displayQuantity+2, stockPrice, "")
+	ask.Id = 3
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+	}()
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	gomock.InOrder(
+		// only the visible slice of the iceberg gets traded
+		mockDepth.EXPECT().AddTrade(stockPrice, displayQuantity, ""),
+		mockDepth.EXPECT().CloseOrder(false, false, stockPrice, displayQuantity),
+		// the next slice is shown
+		mockDepth.EXPECT().AddOrder(false, false, stockPrice, displayQuantity),
+		// the rest of the ask goes to the bid that's now ahead of the iceberg
+		mockDepth.EXPECT().AddTrade(stockPrice, uint64(2), ""),
+		mockDepth.EXPECT().CloseOrder(false, false, stockPrice, uint64(2)),
+	)
+
+	ob.processAsk(ask)
+
+	if icebergBid.StockQuantityFulfilled != displayQuantity {
+		t.Errorf("Expected iceberg bid to trade %d stocks, it traded %d", displayQuantity, icebergBid.StockQuantityFulfilled)
+	}
+	if (*testBidQueue).Pop() != otherBid || (*testBidQueue).Pop() != icebergBid {
+		t.Errorf("Replenished iceberg bid didn't move to the back of its price level")
+	}
+}
+
+func TestVisibleQuantity(t *testing.T) {
+	var tests = []struct {
+		isIceberg              bool
+		stockQuantity          uint64
+		stockQuantityFulfilled uint64
+		displayQuantity        uint64
+		visible                uint64
+	}{
+		{false, 10, 3, 0, 7},
+		{true, 10, 0, 4, 2},
+		{true, 10, 2, 4, 4},
+		{true, 10, 3, 4, 3},
+		{true, 10, 6, 4, 4},
+		{true, 10, 8, 4, 2},
+		{true, 10, 10, 4, 0},
+		{true, 12, 0, 4, 4},
+	}
+
+	for _, test := range tests {
+		visible := visibleQuantity(test.isIceberg, test.stockQuantity, test.stockQuantityFulfilled, test.displayQuantity)
+		if visible != test.visible {
+			t.Errorf("visibleQuantity(%+v) = %d, expected %d", test, visible, test.visible)
+		}
+	}
+}
+
+func TestIsSliceFilled(t *testing.T) {
+	var tests = []struct {
+		isIceberg              bool
+		stockQuantity          uint64
+		stockQuantityFulfilled uint64
+		displayQuantity        uint64
+		filled                 bool
+	}{
+		{false, 10, 2, 0, false},
+		{true, 10, 2, 4, true},
+		{true, 10, 3, 4, false},
+		{true, 10, 6, 4, true},
+		{true, 10, 10, 4, false},
+	}
+
+	for _, test := range tests {
+		filled := isSliceFilled(test.isIceberg, test.stockQuantity, test.stockQuantityFulfilled, test.displayQuantity)
+		if filled != test.filled {
+			t.Errorf("isSliceFilled(%+v) = %t, expected %t", test, filled, test.filled)
+		}
+	}
+}
+
+func TestGetAuctionPrice(t *testing.T) {
+	var tests = []struct {
+		asks           []*models.Ask
+		bids           []*models.Bid
+		referencePrice uint64
+		price          uint64
+		volume         uint64
+	}{
+		// the most stocks trade at 105 and 110. 105 is closer to the reference price
+		{
+			[]*models.Ask{makeAsk(1, 1, models.Limit, 10, 100, ""), makeAsk(1, 1, models.Limit, 10, 105, "")},
+			[]*models.Bid{makeBid(2, 1, models.Limit, 15, 110, ""), makeBid(2, 1, models.Limit, 5, 100, "")},
+			100, 105, 15,
+		},
+		// the book doesn't cross
+		{
+			[]*models.Ask{makeAsk(1, 1, models.Limit, 10, 110, "")},
+			[]*models.Bid{makeBid(2, 1, models.Limit, 10, 100, "")},
+			100, 0, 0,
+		},
+		// market orders trade at the limit price of the other side
+		{
+			[]*models.Ask{makeAsk(1, 1, models.Limit, 10, 100, "")},
+			[]*models.Bid{makeBid(2, 1, models.Market, 4, 0, "")},
+			90, 100, 4,
+		},
+		// market orders alone trade at the reference price
+		{
+			[]*models.Ask{makeAsk(1, 1, models.Market, 5, 0, "")},
+			[]*models.Bid{makeBid(2, 1, models.Market, 8, 0, "")},
+			50, 50, 5,
+		},
+	}
+
+	for i, test := range tests {
+		price, volume := getAuctionPrice(test.asks, test.bids, test.referencePrice)
+		if price != test.price || volume != test.volume {
+			t.Errorf("Test %d: getAuctionPrice = (%d, %d), expected (%d, %d)", i, price, volume, test.price, test.volume)
+		}
+	}
+}
+
+func TestOrderBookUncross(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, testAskQueue, testBidQueue, _, _, mockDepth, stockID, _, _ := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	ask := makeAsk(1, stockID, models.Limit, 10, 100, "")
+	ask.Id = 1
+	highBid := makeBid(2, stockID, models.Limit, 6, 110, "")
+	highBid.Id = 2
+	lowBid := makeBid(3, stockID, models.Limit, 6, 102, "")
+	lowBid.Id = 3
+
+	oldFillOrderFn := fillOrderFn
+	oldSetAuctionPriceFn := setAuctionPriceFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		setAuctionPriceFn = oldSetAuctionPriceFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	var auctionPrice, auctionVolume uint64
+	setAuctionPriceFn = func(stockId uint32, price uint64, volume uint64) error {
+		auctionPrice, auctionVolume = price, volume
+		return nil
+	}
+
+	gomock.InOrder(
+		// orders only rest in the book during the auction
+		mockDepth.EXPECT().AddOrder(false, true, uint64(100), uint64(10)),
+		mockDepth.EXPECT().AddOrder(false, false, uint64(110), uint64(6)),
+		mockDepth.EXPECT().AddOrder(false, false, uint64(102), uint64(6)),
+		mockDepth.EXPECT().SetIndicativePrice(uint64(0), uint64(0)),
+		// both bids trade at the auction price, not at their own prices
+		mockDepth.EXPECT().AddTrade(uint64(100), uint64(6), ""),
+		mockDepth.EXPECT().CloseOrder(false, false, uint64(110), uint64(6)),
+		mockDepth.EXPECT().CloseOrder(false, true, uint64(100), uint64(6)),
+		mockDepth.EXPECT().AddTrade(uint64(100), uint64(4), ""),
+		mockDepth.EXPECT().CloseOrder(false, false, uint64(102), uint64(4)),
+		mockDepth.EXPECT().CloseOrder(false, true, uint64(100), uint64(4)),
+	)
+
+	ob.inAuction = true
+	ob.processAsk(ask)
+	ob.processBid(highBid)
+	ob.processBid(lowBid)
+
+	if (*testAskQueue).Size() != 1 || (*testBidQueue).Size() != 2 {
+		t.Fatalf("Orders placed during the auction didn't rest in the book")
+	}
+
+	ob.uncross()
+
+	if ob.inAuction {
+		t.Errorf("Order book is still in the auction after uncrossing")
+	}
+	if auctionPrice != 100 || auctionVolume != 10 {
+		t.Errorf("Auction price set to (%d, %d), expected (100, 10)", auctionPrice, auctionVolume)
+	}
+	if !(*testAskQueue).Empty() || (*testBidQueue).Head() != lowBid {
+		t.Errorf("Expected only the partly filled bid to be left in the book")
+	}
+}
+
+func TestOrderBookUncrossTriggersStopLossesAfterwards(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	var stockID uint32 = 1
+	ob := newOrderBook(stockID, replayDepth{})
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldSetAuctionPriceFn := setAuctionPriceFn
+	oldGetStockCopyFn := getStockCopyFn
+	oldTriggerBidStoplossFn := triggerBidStoplossFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		setAuctionPriceFn = oldSetAuctionPriceFn
+		getStockCopyFn = oldGetStockCopyFn
+		triggerBidStoplossFn = oldTriggerBidStoplossFn
+	}()
+
+	var tradePrices []uint64
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		tradePrices = append(tradePrices, stockTradePrice)
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	var auctionVolume uint64
+	setAuctionPriceFn = func(stockId uint32, price uint64, volume uint64) error {
+		auctionVolume = volume
+		return nil
+	}
+
+	getStockCopyFn = func(stockId uint32) (models.Stock, error) {
+		return models.Stock{Id: stockId, CurrentPrice: 100}, nil
+	}
+
+	triggerBidStoplossFn = func(bid *models.Bid) error {
+		bid.OrderType = models.StopLossActive
+		return nil
+	}
+
+	ask := makeAsk(1, stockID, models.Limit, 10, 100, "")
+	ask.Id = 1
+	highAsk := makeAsk(1, stockID, models.Limit, 3, 105, "")
+	highAsk.Id = 2
+	highBid := makeBid(2, stockID, models.Limit, 6, 110, "")
+	highBid.Id = 3
+	lowBid := makeBid(3, stockID, models.Limit, 6, 102, "")
+	lowBid.Id = 4
+	// it rests through the auction, and is triggered by the auction price
+	stoplossBid := makeBid(4, stockID, models.StopLoss, 5, 100, "")
+	stoplossBid.Id = 5
+
+	ob.LoadOldBid(stoplossBid)
+	ob.inAuction = true
+	ob.processAsk(ask)
+	ob.processAsk(highAsk)
+	ob.processBid(highBid)
+	ob.processBid(lowBid)
+
+	ob.uncross()
+
+	// the stoploss didn't take the place of the low bid in the auction
+	if auctionVolume != 10 || lowBid.StockQuantityFulfilled != 4 {
+		t.Fatalf("Auction traded %d stocks, %d of them to the low bid. Expected 10 and 4", auctionVolume, lowBid.StockQuantityFulfilled)
+	}
+	// then it got triggered, and bought what was left at the price of the ask
+	if stoplossBid.OrderType != models.StopLossActive || stoplossBid.StockQuantityFulfilled != 3 {
+		t.Fatalf("Stoploss bid has type %v and bought %d stocks, expected it to be triggered and buy 3", stoplossBid.OrderType, stoplossBid.StockQuantityFulfilled)
+	}
+	if len(tradePrices) != 3 || tradePrices[0] != 100 || tradePrices[1] != 100 || tradePrices[2] != 105 {
+		t.Errorf("Trades happened at %v, expected [100 100 105]", tradePrices)
+	}
+	if !ob.asks.Empty() || !ob.bidStoploss.Empty() {
+		t.Errorf("Expected the asks and the stoplosses to be empty after uncrossing")
+	}
+}
+
+func TestOrderBookHaltAndResume(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, testAskQueue, testBidQueue, _, _, mockDepth, stockID, _, _ := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	cheapAsk := makeAsk(2, stockID, models.Limit, 5, 20, "")
+	cheapAsk.Id = 1
+	costlyAsk := makeAsk(3, stockID, models.Limit, 5, 21, "")
+	costlyAsk.Id = 2
+	(*testAskQueue).Push(cheapAsk)
+	(*testAskQueue).Push(costlyAsk)
+
+	bid := makeBid(1, stockID, models.Limit, 10, 21, "")
+	bid.Id = 3
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldResumeStockFn := resumeStockFn
+	oldSetAuctionPriceFn := setAuctionPriceFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		resumeStockFn = oldResumeStockFn
+		setAuctionPriceFn = oldSetAuctionPriceFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	// the first trade breaches the band
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return true, time.Hour
+	}
+
+	var resumedStockId uint32
+	resumeStockFn = func(stockId uint32) {
+		resumedStockId = stockId
+	}
+
+	setAuctionPriceFn = func(stockId uint32, price uint64, volume uint64) error {
+		return nil
+	}
+
+	gomock.InOrder(
+		mockDepth.EXPECT().AddTrade(uint64(20), uint64(5), ""),
+		mockDepth.EXPECT().CloseOrder(false, true, uint64(20), uint64(5)),
+		// the rest of the bid rests in the book while the stock is halted
+		mockDepth.EXPECT().AddOrder(false, false, uint64(21), uint64(5)),
+		// once trading resumes, the orders that cross are matched at a single price
+		mockDepth.EXPECT().SetIndicativePrice(uint64(0), uint64(0)),
+		mockDepth.EXPECT().AddTrade(uint64(21), uint64(5), ""),
+		mockDepth.EXPECT().CloseOrder(false, false, uint64(21), uint64(5)),
+		mockDepth.EXPECT().CloseOrder(false, true, uint64(21), uint64(5)),
+	)
+
+	ob.processBid(bid)
+
+	if !ob.isHalted {
+		t.Fatalf("Order book wasn't halted after the circuit breaker tripped")
+	}
+	if (*testAskQueue).Head() != costlyAsk || (*testBidQueue).Head() != bid {
+		t.Fatalf("Expected the rest of the bid to rest in the book without matching")
+	}
+
+	ob.endCoolOff()
+
+	if ob.isHalted {
+		t.Errorf("Order book is still halted after resuming")
+	}
+	if resumedStockId != stockID {
+		t.Errorf("Trading in stock %d wasn't resumed", stockID)
+	}
+	if !(*testAskQueue).Empty() || !(*testBidQueue).Empty() {
+		t.Errorf("Crossing orders weren't matched after resuming")
+	}
+}
+
+func TestOrderBookSelfTradePrevention(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldGetSelfTradePreventionFn := getSelfTradePreventionFn
+	oldCancelSelfTradeAskFn := cancelSelfTradeAskFn
+	oldCancelSelfTradeBidFn := cancelSelfTradeBidFn
+	oldDecrementSelfTradeBidFn := decrementSelfTradeBidFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		getSelfTradePreventionFn = oldGetSelfTradePreventionFn
+		cancelSelfTradeAskFn = oldCancelSelfTradeAskFn
+		cancelSelfTradeBidFn = oldCancelSelfTradeBidFn
+		decrementSelfTradeBidFn = oldDecrementSelfTradeBidFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	cancelSelfTradeBidFn = func(bid *models.Bid) error {
+		t.Fatalf("Incoming bid %d got cancelled", bid.Id)
+		return nil
+	}
+
+	var tests = []struct {
+		mode             models.SelfTradePrevention
+		tradeQuantity    uint64
+		bidQuantity      uint64
+		otherAskQuantity uint64
+	}{
+		// the resting ask is cancelled, and the whole bid trades with the other user's ask
+		{models.CancelOldest, 10, 10, 0},
+		// 4 stocks are taken off both orders. That leaves nothing of the resting ask.
+		{models.DecrementAndCancel, 6, 6, 4},
+	}
+
+	for _, test := range tests {
+		mockControl, ob, testAskQueue, _, _, _, mockDepth, stockID, _, _ := getMockAndTestObjects(t)
+
+		sameUserAsk := makeAsk(1, stockID, models.Limit, 4, 20, "")
+		sameUserAsk.Id = 1
+		otherUserAsk := makeAsk(2, stockID, models.Limit, 10, 20, "")
+		otherUserAsk.Id = 2
+		(*testAskQueue).Push(sameUserAsk)
+		(*testAskQueue).Push(otherUserAsk)
+
+		bid := makeBid(1, stockID, models.Limit, 10, 20, "")
+		bid.Id = 3
+
+		mode := test.mode
+		getSelfTradePreventionFn = func(stockId uint32) models.SelfTradePrevention {
+			return mode
+		}
+
+		var cancelledAsk *models.Ask
+		cancelSelfTradeAskFn = func(ask *models.Ask) error {
+			cancelledAsk = ask
+			return nil
+		}
+
+		decrementSelfTradeBidFn = func(bid *models.Bid, stockQuantity uint64) error {
+			bid.StockQuantity -= stockQuantity
+			return nil
+		}
+
+		gomock.InOrder(
+			mockDepth.EXPECT().CloseOrder(false, true, uint64(20), uint64(4)),
+			mockDepth.EXPECT().AddTrade(uint64(20), test.tradeQuantity, ""),
+			mockDepth.EXPECT().CloseOrder(false, true, uint64(20), test.tradeQuantity),
+		)
+
+		ob.processBid(bid)
+
+		if cancelledAsk != sameUserAsk {
+			t.Errorf("%s: resting ask of the same user wasn't cancelled", mode)
+		}
+		if bid.StockQuantity != test.bidQuantity || !bid.IsClosed {
+			t.Errorf("%s: expected bid to be fulfilled with %d stocks. Got %+v", mode, test.bidQuantity, bid)
+		}
+		if otherUserAsk.StockQuantity-otherUserAsk.StockQuantityFulfilled != test.otherAskQuantity {
+			t.Errorf("%s: expected %d stocks of the other user's ask to be left. Got %+v", mode, test.otherAskQuantity, otherUserAsk)
+		}
+		if (*testAskQueue).Head() == sameUserAsk {
+			t.Errorf("%s: cancelled ask is still in the queue", mode)
+		}
+
+		mockControl.Finish()
+	}
+}
+
+func TestOrderBookTrailStopLosses(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	mockControl, ob, _, _, testAskStoplossQueue, _, _, stockID, stockQuantity, stockPrice := getMockAndTestObjects(t)
+	defer mockControl.Finish()
+
+	// askStoploss serves the highest trigger price first
+	fixedAsk := makeAsk(1, stockID, models.StopLoss, stockQuantity, stockPrice-5, "")
+	fixedAsk.Id = 1
+	trailingAsk := makeAsk(2, stockID, models.StopLoss, stockQuantity, stockPrice-10, "")
+	trailingAsk.Id = 2
+	trailingAsk.TrailingAmount = 2
+	cancelledAsk := makeAsk(3, stockID, models.StopLoss, stockQuantity, stockPrice-10, "")
+	cancelledAsk.Id = 3
+	cancelledAsk.TrailingAmount = 2
+
+	ob.LoadOldAsk(fixedAsk)
+	ob.LoadOldAsk(trailingAsk)
+	ob.LoadOldAsk(cancelledAsk)
+	(*testAskStoplossQueue).Remove(cancelledAsk.Id)
+
+	oldTrailAskFn := trailAskFn
+	defer func() { trailAskFn = oldTrailAskFn }()
+
+	trailAskFn = func(ask *models.Ask, price uint64) (bool, error) {
+		if ask != trailingAsk {
+			t.Fatalf("Trigger price of ask %d was moved", ask.Id)
+		}
+		ask.Price = price - ask.TrailingAmount
+		return true, nil
+	}
+
+	ob.trailStopLosses(stockPrice)
+
+	if (*testAskStoplossQueue).Head() != trailingAsk {
+		t.Errorf("Trailing ask wasn't moved up in the stoploss queue after its trigger price rose")
+	}
+	if _, ok := ob.trailingAsks[cancelledAsk.Id]; ok {
+		t.Errorf("Trailing ask that left the stoploss queue is still being tracked")
+	}
+}
+
+func TestOrderBookTriggeredStopLimitIsMatched(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	var stockID uint32 = 1
+	ob := newOrderBook(stockID, replayDepth{})
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldTriggerAskStoplossFn := triggerAskStoplossFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		triggerAskStoplossFn = oldTriggerAskStoplossFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	triggerAskStoplossFn = func(ask *models.Ask) error {
+		ask.OrderType = models.StopLimitActive
+		ask.Price = ask.LimitPrice
+		return nil
+	}
+
+	restingBid := makeBid(2, stockID, models.Limit, 5, 18, "")
+	restingBid.Id = 1
+	ask := makeAsk(3, stockID, models.Limit, 5, 20, "")
+	ask.Id = 2
+	// triggered at 20, it sells at 18 or more
+	stopLimitAsk := makeAsk(4, stockID, models.StopLimit, 5, 20, "")
+	stopLimitAsk.Id = 3
+	stopLimitAsk.LimitPrice = 18
+
+	ob.LoadOldBid(restingBid)
+	ob.LoadOldAsk(ask)
+	ob.LoadOldAsk(stopLimitAsk)
+
+	bid := makeBid(5, stockID, models.Limit, 5, 20, "")
+	bid.Id = 4
+	ob.processBid(bid)
+	ob.processTriggeredOrders()
+
+	if !stopLimitAsk.IsClosed || !restingBid.IsClosed {
+		t.Fatalf("Triggered stop-limit ask wasn't matched with the bid at its limit price")
+	}
+	if !ob.asks.Empty() || !ob.bids.Empty() || !ob.askStoploss.Empty() {
+		t.Errorf("Expected the book to be empty, with no crossing orders left in it")
+	}
 }
 
 func TestTopMatchingAsk(t *testing.T) {
@@ -469,10 +1482,10 @@ func Test_MakeTrade(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -494,7 +1507,7 @@ func Test_MakeTrade(t *testing.T) {
 	ob.LoadOldBid(bid)
 	models.LoadStocks()
 
-	askStatus, bidStatus := ob.makeTrade(ask, bid, false, false)
+	askStatus, bidStatus, _ := ob.makeTrade(ask, bid, false, false)
 
 	if !askStatus || !bidStatus {
 		l.Errorf("Errored in testMakeTrade")
@@ -575,10 +1588,10 @@ func Test_ProcessAsk(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -681,10 +1694,10 @@ func Test_ProcessBid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -795,10 +1808,10 @@ func Test_TriggerStoploss(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -829,7 +1842,7 @@ func Test_TriggerStoploss(t *testing.T) {
 
 	ob.LoadOldAsk(stoplossAsk)
 	ob.LoadOldBid(stoplossBid)
-	ob.triggerStopLosses(t1)
+	ob.triggerStopLosses(t1.Price)
 
 	if ob.askStoploss.Head() != nil || ob.bidStoploss.Head() != nil {
 		l.Errorf("Errored in processBid")
@@ -902,10 +1915,10 @@ func Test_AddAskOrderStoploss(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -913,14 +1926,16 @@ func Test_AddAskOrderStoploss(t *testing.T) {
 			"module":        "matchingengine.OrderBook.test",
 			"param_stockId": stockID,
 		}),
-		stockId:     stockID,
-		askChan:     make(chan *models.Ask),
-		bidChan:     make(chan *models.Bid),
-		asks:        testAskQueue,
-		bids:        testBidQueue,
-		askStoploss: testAskStoplossQueue,
-		bidStoploss: testBidStoplossQueue,
-		depth:       testDepth,
+		stockId:         stockID,
+		askChan:         make(chan *models.Ask),
+		bidChan:         make(chan *models.Bid),
+		stoplossAskChan: make(chan *models.Ask),
+		stoplossBidChan: make(chan *models.Bid),
+		asks:            testAskQueue,
+		bids:            testBidQueue,
+		askStoploss:     testAskStoplossQueue,
+		bidStoploss:     testBidStoplossQueue,
+		depth:           testDepth,
 	}
 
 	go ob.StartStockMatching()
@@ -997,10 +2012,10 @@ func Test_AddBidOrderStoploss(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testAskQueue := NewAskPQueue(1)
-	testBidQueue := NewBidPQueue(0)
-	testAskStoplossQueue := NewAskPQueue(1)
-	testBidStoplossQueue := NewBidPQueue(0)
+	testAskQueue := NewAskQueue(MinPriceFirst)
+	testBidQueue := NewBidQueue(MaxPriceFirst)
+	testAskStoplossQueue := NewAskQueue(MinPriceFirst)
+	testBidStoplossQueue := NewBidQueue(MaxPriceFirst)
 	testDepth := datastreams.GetManager().GetMarketDepthStream(stockID)
 
 	ob := &orderBook{
@@ -1008,14 +2023,16 @@ func Test_AddBidOrderStoploss(t *testing.T) {
 			"module":        "matchingengine.OrderBook.test",
 			"param_stockId": stockID,
 		}),
-		stockId:     stockID,
-		askChan:     make(chan *models.Ask),
-		bidChan:     make(chan *models.Bid),
-		asks:        testAskQueue,
-		bids:        testBidQueue,
-		askStoploss: testAskStoplossQueue,
-		bidStoploss: testBidStoplossQueue,
-		depth:       testDepth,
+		stockId:         stockID,
+		askChan:         make(chan *models.Ask),
+		bidChan:         make(chan *models.Bid),
+		stoplossAskChan: make(chan *models.Ask),
+		stoplossBidChan: make(chan *models.Bid),
+		asks:            testAskQueue,
+		bids:            testBidQueue,
+		askStoploss:     testAskStoplossQueue,
+		bidStoploss:     testBidStoplossQueue,
+		depth:           testDepth,
 	}
 
 	go ob.StartStockMatching()
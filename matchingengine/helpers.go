@@ -1,6 +1,8 @@
 package matchingengine
 
 import (
+	"sort"
+
 	"github.com/delta/dalal-street-server/models"
 	"github.com/delta/dalal-street-server/utils"
 )
@@ -48,3 +50,149 @@ func getTradePriceAndQty(ask *models.Ask, bid *models.Bid) (uint64, uint64) {
 
 	return stockTradePrice, stockTradeQty
 }
+
+/*
+ *	visibleQuantity returns how much of an order's unfulfilled quantity is shown in the depth.
+ *	The unfulfilled quantity of an iceberg order is split into slices of its display quantity,
+ *	counted from its end, so it only shows what's left of its current slice whatever got traded
+ *	or modified before. The next slice is shown once the current one fills.
+ */
+func visibleQuantity(isIceberg bool, stockQuantity, stockQuantityFulfilled, displayQuantity uint64) uint64 {
+	var unfulfilledStockQuantity = stockQuantity - stockQuantityFulfilled
+	if !isIceberg || displayQuantity == 0 || unfulfilledStockQuantity == 0 {
+		return unfulfilledStockQuantity
+	}
+	return (unfulfilledStockQuantity-1)%displayQuantity + 1
+}
+
+func visibleAskQuantity(ask *models.Ask) uint64 {
+	return visibleQuantity(ask.IsIceberg, ask.StockQuantity, ask.StockQuantityFulfilled, ask.DisplayQuantity)
+}
+
+func visibleBidQuantity(bid *models.Bid) uint64 {
+	return visibleQuantity(bid.IsIceberg, bid.StockQuantity, bid.StockQuantityFulfilled, bid.DisplayQuantity)
+}
+
+func sortedAskIds(asks map[uint32]*models.Ask) []uint32 {
+	ids := make([]uint32, 0, len(asks))
+	for id := range asks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func sortedBidIds(bids map[uint32]*models.Bid) []uint32 {
+	ids := make([]uint32, 0, len(bids))
+	for id := range bids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func unfulfilledAskQuantity(ask *models.Ask) uint64 {
+	return ask.StockQuantity - ask.StockQuantityFulfilled
+}
+
+func unfulfilledBidQuantity(bid *models.Bid) uint64 {
+	return bid.StockQuantity - bid.StockQuantityFulfilled
+}
+
+/*
+ *	Helper function to check if a trade has used up the current slice of an iceberg order
+ */
+func isSliceFilled(isIceberg bool, stockQuantity, stockQuantityFulfilled, displayQuantity uint64) bool {
+	if !isIceberg || displayQuantity == 0 || stockQuantityFulfilled >= stockQuantity {
+		return false
+	}
+	return (stockQuantity-stockQuantityFulfilled)%displayQuantity == 0
+}
+
+/*
+ *	getAuctionPrice returns the price at which a call auction uncrosses the given orders, and the
+ *	quantity that trades at that price. The price is picked from the limit prices of the orders:
+ *		1. The price at which the most stocks trade is picked.
+ *		2. Among those, the one that leaves the least unmatched quantity is picked.
+ *		3. If there's still a tie, the one closest to the reference price is picked.
+ *	Market orders trade at any price. If only market orders cross, they trade at the reference price.
+ *	The whole unfulfilled quantity of iceberg orders takes part in the auction, not just their slices.
+ */
+func getAuctionPrice(asks []*models.Ask, bids []*models.Bid, referencePrice uint64) (uint64, uint64) {
+	type auctionOrder struct {
+		isMarket bool
+		price    uint64
+		quantity uint64
+	}
+
+	var (
+		askOrders []auctionOrder
+		bidOrders []auctionOrder
+		prices    []uint64
+	)
+
+	for _, ask := range asks {
+		ask.Lock()
+		if !ask.IsClosed {
+			askOrders = append(askOrders, auctionOrder{isMarket(ask.OrderType), ask.Price, ask.StockQuantity - ask.StockQuantityFulfilled})
+			if !isMarket(ask.OrderType) {
+				prices = append(prices, ask.Price)
+			}
+		}
+		ask.Unlock()
+	}
+
+	for _, bid := range bids {
+		bid.Lock()
+		if !bid.IsClosed {
+			bidOrders = append(bidOrders, auctionOrder{isMarket(bid.OrderType), bid.Price, bid.StockQuantity - bid.StockQuantityFulfilled})
+			if !isMarket(bid.OrderType) {
+				prices = append(prices, bid.Price)
+			}
+		}
+		bid.Unlock()
+	}
+
+	if len(prices) == 0 {
+		prices = append(prices, referencePrice)
+	}
+
+	distance := func(a, b uint64) uint64 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+
+	var auctionPrice, auctionVolume, auctionImbalance uint64
+
+	for _, price := range prices {
+		var supply, demand uint64
+		for _, o := range askOrders {
+			if o.isMarket || o.price <= price {
+				supply += o.quantity
+			}
+		}
+		for _, o := range bidOrders {
+			if o.isMarket || o.price >= price {
+				demand += o.quantity
+			}
+		}
+
+		volume := utils.MinInt64(supply, demand)
+		imbalance := distance(supply, demand)
+
+		isBetter := volume > auctionVolume ||
+			(volume == auctionVolume && imbalance < auctionImbalance) ||
+			(volume == auctionVolume && imbalance == auctionImbalance && distance(price, referencePrice) < distance(auctionPrice, referencePrice))
+
+		if volume > 0 && isBetter {
+			auctionPrice, auctionVolume, auctionImbalance = price, volume, imbalance
+		}
+	}
+
+	if auctionVolume == 0 {
+		return 0, 0
+	}
+	return auctionPrice, auctionVolume
+}
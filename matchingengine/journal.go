@@ -0,0 +1,242 @@
+package matchingengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/delta/dalal-street-server/models"
+	"github.com/delta/dalal-street-server/utils"
+)
+
+// journalDir is the directory the order books write their journals to. Journals aren't written if it's empty.
+var journalDir string
+
+// journalEntryType says what a journal entry records
+type journalEntryType string
+
+// Inputs to an order book. Replaying them through a fresh order book must give the same outputs.
+const (
+	// journalStart is written when the server starts. The order book is empty then.
+	journalStart journalEntryType = "Start"
+	// journalLoadAsk and journalLoadBid are open orders loaded from the database at startup
+	journalLoadAsk journalEntryType = "LoadAsk"
+	journalLoadBid journalEntryType = "LoadBid"
+	// journalStartMatching is written when the order book starts matching the loaded orders
+	journalStartMatching journalEntryType = "StartMatching"
+	// journalAddAsk and journalAddBid are orders that reached the order book to be matched
+	journalAddAsk journalEntryType = "AddAsk"
+	journalAddBid journalEntryType = "AddBid"
+	// journalAddStoplossAsk and journalAddStoplossBid are new stoplosses. They're put in the stoploss queues,
+	// or triggered right away if the price has already crossed their trigger prices.
+	journalAddStoplossAsk journalEntryType = "AddStoplossAsk"
+	journalAddStoplossBid journalEntryType = "AddStoplossBid"
+	journalCancelAsk      journalEntryType = "CancelAsk"
+	journalCancelBid      journalEntryType = "CancelBid"
+	journalModify         journalEntryType = "Modify"
+	journalStartAuction   journalEntryType = "StartCallAuction"
+	journalUncross        journalEntryType = "Uncross"
+	journalResume         journalEntryType = "Resume"
+	journalSuspend        journalEntryType = "Suspend"
+	journalUnsuspend      journalEntryType = "Unsuspend"
+	// journalStop is written when the order book is stopped, as its stock was removed
+	journalStop journalEntryType = "Stop"
+)
+
+// Outputs of an order book. They're written in the order the order book produced them.
+const (
+	// journalFill is a call to fillOrderFn, along with what it returned
+	journalFill journalEntryType = "Fill"
+	// journalHalt is written when a trade tripped the circuit breaker of the stock
+	journalHalt journalEntryType = "Halt"
+	// journalTrail is a call to trailAskFn or trailBidFn. Price is the trigger price after it.
+	journalTrail journalEntryType = "Trail"
+	// journalTrigger is a stoploss that got triggered
+	journalTrigger journalEntryType = "TriggerStoploss"
+	// journalModifyResult says if modifyOrderFn modified the order
+	journalModifyResult journalEntryType = "ModifyResult"
+	// journalStockPrice is the current price of the stock, whenever the order book looked it up
+	journalStockPrice journalEntryType = "StockPrice"
+	// journalSelfTradePrevention is the self-trade prevention mode, whenever the order book looked it up
+	journalSelfTradePrevention journalEntryType = "SelfTradePrevention"
+)
+
+// isInput says if entries of type t are inputs to an order book
+func (t journalEntryType) isInput() bool {
+	switch t {
+	case journalFill, journalHalt, journalTrail, journalTrigger, journalModifyResult, journalStockPrice, journalSelfTradePrevention:
+		return false
+	}
+	return true
+}
+
+// journalEntry is a single line of a journal. Only the fields relevant to its type are set.
+type journalEntry struct {
+	Seq     uint64           `json:"seq"`
+	Time    string           `json:"time"`
+	Type    journalEntryType `json:"type"`
+	StockId uint32           `json:"stock_id,omitempty"`
+
+	// the order as it was when the entry was written
+	Ask json.RawMessage `json:"ask,omitempty"`
+	Bid json.RawMessage `json:"bid,omitempty"`
+
+	OrderId       uint32 `json:"order_id,omitempty"`
+	UserId        uint32 `json:"user_id,omitempty"`
+	IsAsk         bool   `json:"is_ask,omitempty"`
+	Price         uint64 `json:"price,omitempty"`
+	StockQuantity uint64 `json:"stock_quantity,omitempty"`
+
+	// the result of a fill
+	AskId         uint32                    `json:"ask_id,omitempty"`
+	BidId         uint32                    `json:"bid_id,omitempty"`
+	AskStatus     models.AskOrderFillStatus `json:"ask_status,omitempty"`
+	BidStatus     models.BidOrderFillStatus `json:"bid_status,omitempty"`
+	Traded        bool                      `json:"traded,omitempty"`
+	TradePrice    uint64                    `json:"trade_price,omitempty"`
+	TradeQuantity uint64                    `json:"trade_quantity,omitempty"`
+
+	Moved  bool                       `json:"moved,omitempty"`
+	Failed bool                       `json:"failed,omitempty"`
+	Mode   models.SelfTradePrevention `json:"mode,omitempty"`
+}
+
+// journal appends the inputs and outputs of an order book to a file, one JSON entry per line.
+// Sequence numbers start from 1 every time the server starts. A nil journal records nothing.
+type journal struct {
+	sync.Mutex
+	logger *logrus.Entry
+	file   *os.File
+	enc    *json.Encoder
+	seq    uint64
+}
+
+// openJournal opens the journal of a stock for appending, and writes a journalStart entry to it
+func openJournal(dir string, stockId uint32) (*journal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fileName := filepath.Join(dir, fmt.Sprintf("stock_%d.journal", stockId))
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{
+		logger: utils.Logger.WithFields(logrus.Fields{
+			"module":        "matchingengine.journal",
+			"param_stockId": stockId,
+		}),
+		file: file,
+		enc:  json.NewEncoder(file),
+	}
+	j.record(&journalEntry{Type: journalStart, StockId: stockId})
+
+	return j, nil
+}
+
+// record gives the entry the next sequence number and appends it to the journal
+func (j *journal) record(e *journalEntry) {
+	if j == nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.enc == nil {
+		return
+	}
+
+	j.seq++
+	e.Seq = j.seq
+	e.Time = utils.GetCurrentTimeISO8601()
+
+	if err := j.enc.Encode(e); err != nil {
+		j.logger.Errorf("Error while writing entry %d: %+v", e.Seq, err)
+	}
+
+	// Inputs are synced to the disk before the order book acts on them, so that the journal survives the
+	// machine going down, and not just the server crashing. Outputs get synced along with the next input.
+	if e.Type.isInput() {
+		if err := j.file.Sync(); err != nil {
+			j.logger.Errorf("Error while syncing entry %d: %+v", e.Seq, err)
+		}
+	}
+}
+
+// recordAsk records an entry about an ask, along with the ask as it is now
+func (j *journal) recordAsk(t journalEntryType, ask *models.Ask) {
+	if j == nil {
+		return
+	}
+
+	ask.Lock()
+	askJSON, err := json.Marshal(ask)
+	ask.Unlock()
+	if err != nil {
+		j.logger.Errorf("Error while encoding ask %d: %+v", ask.Id, err)
+	}
+
+	j.record(&journalEntry{Type: t, OrderId: ask.Id, IsAsk: true, Ask: askJSON})
+}
+
+// recordBid records an entry about a bid, along with the bid as it is now
+func (j *journal) recordBid(t journalEntryType, bid *models.Bid) {
+	if j == nil {
+		return
+	}
+
+	bid.Lock()
+	bidJSON, err := json.Marshal(bid)
+	bid.Unlock()
+	if err != nil {
+		j.logger.Errorf("Error while encoding bid %d: %+v", bid.Id, err)
+	}
+
+	j.record(&journalEntry{Type: t, OrderId: bid.Id, Bid: bidJSON})
+}
+
+// recordFill records a call to fillOrderFn and what it returned
+func (j *journal) recordFill(ask *models.Ask, bid *models.Bid, price, stockQuantity uint64, askStatus models.AskOrderFillStatus, bidStatus models.BidOrderFillStatus, tr *models.Transaction) {
+	if j == nil {
+		return
+	}
+
+	e := &journalEntry{
+		Type:          journalFill,
+		AskId:         ask.Id,
+		BidId:         bid.Id,
+		Price:         price,
+		StockQuantity: stockQuantity,
+		AskStatus:     askStatus,
+		BidStatus:     bidStatus,
+	}
+	if tr != nil {
+		e.Traded = true
+		e.TradePrice = tr.Price
+		e.TradeQuantity = uint64(-tr.StockQuantity)
+	}
+
+	j.record(e)
+}
+
+// close closes the journal file. Nothing is recorded after it.
+func (j *journal) close() {
+	if j == nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		j.logger.Errorf("Error while closing the journal: %+v", err)
+	}
+	j.enc = nil
+}
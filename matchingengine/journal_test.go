@@ -0,0 +1,208 @@
+package matchingengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/delta/dalal-street-server/models"
+	"github.com/delta/dalal-street-server/utils"
+)
+
+func TestJournalReplay(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldGetStockCopyFn := getStockCopyFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		getStockCopyFn = oldGetStockCopyFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	getStockCopyFn = func(stockId uint32) (models.Stock, error) {
+		return models.Stock{Id: stockId, CurrentPrice: 20}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "journal")
+	if err != nil {
+		t.Fatalf("Unable to create journal directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ob := newOrderBook(1, replayDepth{})
+	if ob.journal, err = openJournal(dir, 1); err != nil {
+		t.Fatalf("Unable to open journal: %+v", err)
+	}
+
+	ob.LoadOldAsk(&models.Ask{Id: 1, UserId: 2, StockId: 1, OrderType: models.Limit, Price: 20, StockQuantity: 5})
+	ob.StartStockMatching()
+	ob.AddBidOrder(&models.Bid{Id: 2, UserId: 3, StockId: 1, OrderType: models.Limit, Price: 21, StockQuantity: 8})
+	ob.AddAskOrder(&models.Ask{Id: 3, UserId: 4, StockId: 1, OrderType: models.Limit, Price: 21, StockQuantity: 2})
+	// Uncross returns only once every earlier order has been processed
+	ob.Uncross()
+
+	journalBytes, err := os.ReadFile(filepath.Join(dir, "stock_1.journal"))
+	if err != nil {
+		t.Fatalf("Unable to read journal: %+v", err)
+	}
+
+	report, err := ReplayJournal(bytes.NewReader(journalBytes))
+	if err != nil {
+		t.Fatalf("Replay failed: %+v", err)
+	}
+	if report.Sessions != 1 || report.Fills != 2 || report.Trades != 2 {
+		t.Fatalf("Got report %+v, expected 1 session with 2 fills and 2 trades", report)
+	}
+
+	// a journal whose first trade went through at another price mustn't replay
+	var tampered bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(journalBytes))
+	enc := json.NewEncoder(&tampered)
+	changed := false
+	for dec.More() {
+		e := &journalEntry{}
+		if err := dec.Decode(e); err != nil {
+			t.Fatalf("Unable to decode journal: %+v", err)
+		}
+		if e.Type == journalFill && !changed {
+			e.Price++
+			changed = true
+		}
+		enc.Encode(e)
+	}
+
+	if _, err := ReplayJournal(&tampered); err == nil {
+		t.Fatalf("Replay of a tampered journal succeeded")
+	} else if _, ok := err.(ReplayMismatchError); !ok {
+		t.Fatalf("Got %+v, expected a ReplayMismatchError", err)
+	}
+}
+
+func TestJournalReplayTriggeredStoploss(t *testing.T) {
+
+	config := utils.GetConfiguration()
+	utils.Init(config)
+
+	oldFillOrderFn := fillOrderFn
+	oldCheckCircuitBreakerFn := checkCircuitBreakerFn
+	oldGetStockCopyFn := getStockCopyFn
+	oldTriggerAskStoplossFn, oldTriggerBidStoplossFn := triggerAskStoplossFn, triggerBidStoplossFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		checkCircuitBreakerFn = oldCheckCircuitBreakerFn
+		getStockCopyFn = oldGetStockCopyFn
+		triggerAskStoplossFn, triggerBidStoplossFn = oldTriggerAskStoplossFn, oldTriggerBidStoplossFn
+	}()
+
+	fillOrderFn = func(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+		askStatus, bidStatus := models.AskUndone, models.BidUndone
+
+		ask.StockQuantityFulfilled += stockTradeQty
+		if ask.StockQuantityFulfilled == ask.StockQuantity {
+			ask.IsClosed = true
+			askStatus = models.AskDone
+		}
+		bid.StockQuantityFulfilled += stockTradeQty
+		if bid.StockQuantityFulfilled == bid.StockQuantity {
+			bid.IsClosed = true
+			bidStatus = models.BidDone
+		}
+
+		return askStatus, bidStatus, &models.Transaction{Price: stockTradePrice, StockQuantity: -int64(stockTradeQty)}
+	}
+
+	checkCircuitBreakerFn = func(stockId uint32, price uint64) (bool, time.Duration) {
+		return false, 0
+	}
+
+	getStockCopyFn = func(stockId uint32) (models.Stock, error) {
+		return models.Stock{Id: stockId, CurrentPrice: 20}, nil
+	}
+
+	triggerAskStoplossFn = func(ask *models.Ask) error {
+		ask.OrderType = models.StopLossActive
+		return nil
+	}
+	triggerBidStoplossFn = func(bid *models.Bid) error {
+		bid.OrderType = models.StopLossActive
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "journal")
+	if err != nil {
+		t.Fatalf("Unable to create journal directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ob := newOrderBook(1, replayDepth{})
+	if ob.journal, err = openJournal(dir, 1); err != nil {
+		t.Fatalf("Unable to open journal: %+v", err)
+	}
+
+	ob.LoadOldBid(&models.Bid{Id: 1, UserId: 2, StockId: 1, OrderType: models.Limit, Price: 20, StockQuantity: 5})
+	ob.StartStockMatching()
+	// the price is already below the trigger price of the ask, so it's triggered right away
+	ob.AddAskOrder(&models.Ask{Id: 2, UserId: 3, StockId: 1, OrderType: models.StopLoss, Price: 25, StockQuantity: 3})
+	// the price is yet to reach the trigger price of the bid, so it waits in the stoploss queue
+	ob.AddBidOrder(&models.Bid{Id: 3, UserId: 4, StockId: 1, OrderType: models.StopLoss, Price: 30, StockQuantity: 3})
+	ob.Uncross()
+
+	journalBytes, err := os.ReadFile(filepath.Join(dir, "stock_1.journal"))
+	if err != nil {
+		t.Fatalf("Unable to read journal: %+v", err)
+	}
+
+	triggers := 0
+	dec := json.NewDecoder(bytes.NewReader(journalBytes))
+	for dec.More() {
+		e := &journalEntry{}
+		if err := dec.Decode(e); err != nil {
+			t.Fatalf("Unable to decode journal: %+v", err)
+		}
+		if e.Type == journalTrigger {
+			triggers++
+			if e.OrderId != 2 || !e.IsAsk {
+				t.Fatalf("Got trigger %+v, expected only ask 2 to be triggered", e)
+			}
+		}
+	}
+	if triggers != 1 {
+		t.Fatalf("Got %d triggers in the journal, expected 1", triggers)
+	}
+
+	report, err := ReplayJournal(bytes.NewReader(journalBytes))
+	if err != nil {
+		t.Fatalf("Replay failed: %+v", err)
+	}
+	if report.Sessions != 1 || report.Fills != 1 || report.Trades != 1 {
+		t.Fatalf("Got report %+v, expected 1 session with 1 fill and 1 trade", report)
+	}
+}
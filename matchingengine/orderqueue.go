@@ -0,0 +1,411 @@
+package matchingengine
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+
+	"github.com/delta/dalal-street-server/models"
+)
+
+// DO NOT DELETE THIS COMMENT : It is required to generate mocks when running "go generate ./..."
+//go:generate mockgen -source orderqueue.go -destination ../mocks/mock_orderqueue.go -package mocks
+
+// BidQueue stores Bids in price levels. Bids at the same price level are served in
+// the order in which they were pushed, and Market/StopLossActive bids are always
+// served before the rest. Every bid is also indexed by its id, so it can be removed
+// from anywhere in the queue in O(log n).
+// It is synchronized and is safe for concurrent operations.
+type BidQueue interface {
+	Push(*models.Bid)
+	PushFront(*models.Bid)
+	Pop() *models.Bid
+	Head() *models.Bid
+	Remove(bidId uint32) *models.Bid
+	Contains(bidId uint32) bool
+	Orders() []*models.Bid
+	Size() int
+	Empty() bool
+}
+
+// AskQueue stores Asks in price levels. Asks at the same price level are served in
+// the order in which they were pushed, and Market/StopLossActive asks are always
+// served before the rest. Every ask is also indexed by its id, so it can be removed
+// from anywhere in the queue in O(log n).
+// It is synchronized and is safe for concurrent operations.
+type AskQueue interface {
+	Push(*models.Ask)
+	PushFront(*models.Ask)
+	Pop() *models.Ask
+	Head() *models.Ask
+	Remove(askId uint32) *models.Ask
+	Contains(askId uint32) bool
+	Orders() []*models.Ask
+	Size() int
+	Empty() bool
+}
+
+// PriceOrder decides which price level of a queue is served first (see MaxPriceFirst and MinPriceFirst)
+type PriceOrder int
+
+// Constants to determine whether the highest or the lowest price level is served first
+const (
+	MaxPriceFirst PriceOrder = iota
+	MinPriceFirst
+)
+
+// queuedOrder is a single order stored in an orderQueue
+type queuedOrder struct {
+	id    uint32
+	value interface{}
+	level *priceLevel // nil for market orders
+}
+
+// priceLevel holds the orders resting at a single price in FIFO order
+type priceLevel struct {
+	price  uint64
+	orders *list.List
+	index  int // position of the level in levelHeap
+}
+
+// levelHeap implements heap.Interface over price levels
+type levelHeap struct {
+	levels        []*priceLevel
+	maxPriceFirst bool
+}
+
+func (h *levelHeap) Len() int { return len(h.levels) }
+
+func (h *levelHeap) Less(i, j int) bool {
+	if h.maxPriceFirst {
+		return h.levels[i].price > h.levels[j].price
+	}
+	return h.levels[i].price < h.levels[j].price
+}
+
+func (h *levelHeap) Swap(i, j int) {
+	h.levels[i], h.levels[j] = h.levels[j], h.levels[i]
+	h.levels[i].index = i
+	h.levels[j].index = j
+}
+
+func (h *levelHeap) Push(x interface{}) {
+	level := x.(*priceLevel)
+	level.index = len(h.levels)
+	h.levels = append(h.levels, level)
+}
+
+func (h *levelHeap) Pop() interface{} {
+	n := len(h.levels)
+	level := h.levels[n-1]
+	h.levels[n-1] = nil
+	h.levels = h.levels[:n-1]
+	level.index = -1
+	return level
+}
+
+// orderQueue is the untyped implementation shared by askQueue and bidQueue.
+// It is *not* synchronized. The typed wrappers take care of locking.
+type orderQueue struct {
+	market *list.List               // market orders, in FIFO order
+	levels map[uint64]*priceLevel   // price -> level
+	heap   *levelHeap               // non-empty levels ordered by priority
+	orders map[uint32]*list.Element // order id -> element holding a *queuedOrder
+}
+
+func newOrderQueue(priceOrder PriceOrder) *orderQueue {
+	return &orderQueue{
+		market: list.New(),
+		levels: make(map[uint64]*priceLevel),
+		heap:   &levelHeap{maxPriceFirst: priceOrder == MaxPriceFirst},
+		orders: make(map[uint32]*list.Element),
+	}
+}
+
+// push adds an order at the back (or front) of its price level. An order that
+// is already in the queue is left where it is.
+func (q *orderQueue) push(id uint32, price uint64, isMarket bool, value interface{}, atFront bool) {
+	if _, ok := q.orders[id]; ok {
+		return
+	}
+
+	qo := &queuedOrder{id: id, value: value}
+	orders := q.market
+
+	if !isMarket {
+		level, ok := q.levels[price]
+		if !ok {
+			level = &priceLevel{price: price, orders: list.New()}
+			q.levels[price] = level
+			heap.Push(q.heap, level)
+		}
+		qo.level = level
+		orders = level.orders
+	}
+
+	if atFront {
+		q.orders[id] = orders.PushFront(qo)
+	} else {
+		q.orders[id] = orders.PushBack(qo)
+	}
+}
+
+// head returns the front element of the queue without removing it
+func (q *orderQueue) head() *list.Element {
+	if q.market.Len() > 0 {
+		return q.market.Front()
+	}
+	if q.heap.Len() > 0 {
+		return q.heap.levels[0].orders.Front()
+	}
+	return nil
+}
+
+// remove takes an element out of the queue, dropping its price level if it became empty
+func (q *orderQueue) remove(elem *list.Element) interface{} {
+	qo := elem.Value.(*queuedOrder)
+	delete(q.orders, qo.id)
+
+	if qo.level == nil {
+		q.market.Remove(elem)
+		return qo.value
+	}
+
+	qo.level.orders.Remove(elem)
+	if qo.level.orders.Len() == 0 {
+		heap.Remove(q.heap, qo.level.index)
+		delete(q.levels, qo.level.price)
+	}
+	return qo.value
+}
+
+func (q *orderQueue) pop() interface{} {
+	elem := q.head()
+	if elem == nil {
+		return nil
+	}
+	return q.remove(elem)
+}
+
+func (q *orderQueue) peek() interface{} {
+	elem := q.head()
+	if elem == nil {
+		return nil
+	}
+	return elem.Value.(*queuedOrder).value
+}
+
+func (q *orderQueue) removeById(id uint32) interface{} {
+	elem, ok := q.orders[id]
+	if !ok {
+		return nil
+	}
+	return q.remove(elem)
+}
+
+func (q *orderQueue) contains(id uint32) bool {
+	_, ok := q.orders[id]
+	return ok
+}
+
+func (q *orderQueue) all() []interface{} {
+	values := make([]interface{}, 0, len(q.orders))
+	for _, elem := range q.orders {
+		values = append(values, elem.Value.(*queuedOrder).value)
+	}
+	return values
+}
+
+func (q *orderQueue) size() int {
+	return len(q.orders)
+}
+
+// bidQueue implements the BidQueue interface
+type bidQueue struct {
+	sync.RWMutex
+	q *orderQueue
+}
+
+// askQueue implements the AskQueue interface
+type askQueue struct {
+	sync.RWMutex
+	q *orderQueue
+}
+
+// NewBidQueue creates a new BidQueue serving price levels in the given PriceOrder
+func NewBidQueue(priceOrder PriceOrder) BidQueue {
+	return &bidQueue{
+		q: newOrderQueue(priceOrder),
+	}
+}
+
+// NewAskQueue creates a new AskQueue serving price levels in the given PriceOrder
+func NewAskQueue(priceOrder PriceOrder) AskQueue {
+	return &askQueue{
+		q: newOrderQueue(priceOrder),
+	}
+}
+
+// Push adds the bid at the back of its price level
+func (bq *bidQueue) Push(bid *models.Bid) {
+	bq.Lock()
+	bq.q.push(bid.Id, bid.Price, isMarket(bid.OrderType), bid, false)
+	bq.Unlock()
+}
+
+// Push adds the ask at the back of its price level
+func (aq *askQueue) Push(ask *models.Ask) {
+	aq.Lock()
+	aq.q.push(ask.Id, ask.Price, isMarket(ask.OrderType), ask, false)
+	aq.Unlock()
+}
+
+// PushFront adds the bid at the front of its price level. It is used to put
+// back a bid that was taken out of the queue without it losing its time priority.
+func (bq *bidQueue) PushFront(bid *models.Bid) {
+	bq.Lock()
+	bq.q.push(bid.Id, bid.Price, isMarket(bid.OrderType), bid, true)
+	bq.Unlock()
+}
+
+// PushFront adds the ask at the front of its price level. It is used to put
+// back an ask that was taken out of the queue without it losing its time priority.
+func (aq *askQueue) PushFront(ask *models.Ask) {
+	aq.Lock()
+	aq.q.push(ask.Id, ask.Price, isMarket(ask.OrderType), ask, true)
+	aq.Unlock()
+}
+
+// Pop removes and returns the bid with the highest priority
+func (bq *bidQueue) Pop() *models.Bid {
+	bq.Lock()
+	defer bq.Unlock()
+
+	if bid := bq.q.pop(); bid != nil {
+		return bid.(*models.Bid)
+	}
+	return nil
+}
+
+// Pop removes and returns the ask with the highest priority
+func (aq *askQueue) Pop() *models.Ask {
+	aq.Lock()
+	defer aq.Unlock()
+
+	if ask := aq.q.pop(); ask != nil {
+		return ask.(*models.Ask)
+	}
+	return nil
+}
+
+// Head returns the bid with the highest priority without removing it
+func (bq *bidQueue) Head() *models.Bid {
+	bq.RLock()
+	defer bq.RUnlock()
+
+	if bid := bq.q.peek(); bid != nil {
+		return bid.(*models.Bid)
+	}
+	return nil
+}
+
+// Head returns the ask with the highest priority without removing it
+func (aq *askQueue) Head() *models.Ask {
+	aq.RLock()
+	defer aq.RUnlock()
+
+	if ask := aq.q.peek(); ask != nil {
+		return ask.(*models.Ask)
+	}
+	return nil
+}
+
+// Remove removes the bid with the given id from the queue and returns it.
+// nil is returned if the bid isn't in the queue.
+func (bq *bidQueue) Remove(bidId uint32) *models.Bid {
+	bq.Lock()
+	defer bq.Unlock()
+
+	if bid := bq.q.removeById(bidId); bid != nil {
+		return bid.(*models.Bid)
+	}
+	return nil
+}
+
+// Remove removes the ask with the given id from the queue and returns it.
+// nil is returned if the ask isn't in the queue.
+func (aq *askQueue) Remove(askId uint32) *models.Ask {
+	aq.Lock()
+	defer aq.Unlock()
+
+	if ask := aq.q.removeById(askId); ask != nil {
+		return ask.(*models.Ask)
+	}
+	return nil
+}
+
+// Contains checks if the bid with the given id is present in the queue
+func (bq *bidQueue) Contains(bidId uint32) bool {
+	bq.RLock()
+	defer bq.RUnlock()
+	return bq.q.contains(bidId)
+}
+
+// Contains checks if the ask with the given id is present in the queue
+func (aq *askQueue) Contains(askId uint32) bool {
+	aq.RLock()
+	defer aq.RUnlock()
+	return aq.q.contains(askId)
+}
+
+// Orders returns all the bids present in the queue, in no particular order
+func (bq *bidQueue) Orders() []*models.Bid {
+	bq.RLock()
+	defer bq.RUnlock()
+
+	var bids []*models.Bid
+	for _, bid := range bq.q.all() {
+		bids = append(bids, bid.(*models.Bid))
+	}
+	return bids
+}
+
+// Orders returns all the asks present in the queue, in no particular order
+func (aq *askQueue) Orders() []*models.Ask {
+	aq.RLock()
+	defer aq.RUnlock()
+
+	var asks []*models.Ask
+	for _, ask := range aq.q.all() {
+		asks = append(asks, ask.(*models.Ask))
+	}
+	return asks
+}
+
+// Size returns the number of bids present in the queue
+func (bq *bidQueue) Size() int {
+	bq.RLock()
+	defer bq.RUnlock()
+	return bq.q.size()
+}
+
+// Size returns the number of asks present in the queue
+func (aq *askQueue) Size() int {
+	aq.RLock()
+	defer aq.RUnlock()
+	return aq.q.size()
+}
+
+// Empty checks if queue is empty
+func (bq *bidQueue) Empty() bool {
+	bq.RLock()
+	defer bq.RUnlock()
+	return bq.q.size() == 0
+}
+
+// Empty checks if queue is empty
+func (aq *askQueue) Empty() bool {
+	aq.RLock()
+	defer aq.RUnlock()
+	return aq.q.size() == 0
+}
@@ -0,0 +1,278 @@
+package matchingengine
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/delta/dalal-street-server/models"
+	_ "github.com/delta/dalal-street-server/utils/test"
+	"github.com/stretchr/testify/assert"
+)
+
+//helper function to return an Ask object
+func makeAsk(userId uint32, stockId uint32, ot models.OrderType, stockQty uint64, price uint64, placedAt string) *models.Ask {
+	return &models.Ask{
+		UserId:        userId,
+		StockId:       stockId,
+		OrderType:     ot,
+		StockQuantity: stockQty,
+		Price:         price,
+		CreatedAt:     placedAt,
+	}
+}
+
+//helper function to return a Bid object
+func makeBid(userId uint32, stockId uint32, ot models.OrderType, stockQty uint64, price uint64, placedAt string) *models.Bid {
+	return &models.Bid{
+		UserId:        userId,
+		StockId:       stockId,
+		OrderType:     ot,
+		StockQuantity: stockQty,
+		Price:         price,
+		CreatedAt:     placedAt,
+	}
+}
+
+//helper function to return Bids with distinct ids, as a queue stores a given order only once
+func makeTestBids() []*models.Bid {
+	bids := []*models.Bid{
+		makeBid(2, 1, models.Limit, 5, 100, "2017-12-29T01:00:00Z"),
+		makeBid(2, 1, models.Limit, 2, 800, "2017-12-29T02:00:00Z"),
+		makeBid(2, 1, models.Market, 3, 500, "2017-12-29T03:00:00Z"),
+		makeBid(2, 1, models.StopLossActive, 11, 400, "2017-12-29T04:00:00Z"),
+		makeBid(2, 1, models.Limit, 10, 100, "2017-12-29T05:00:00Z"),
+	}
+	for i, bid := range bids {
+		bid.Id = uint32(i + 1)
+	}
+	return bids
+}
+
+//helper function to return Asks with distinct ids, as a queue stores a given order only once
+func makeTestAsks() []*models.Ask {
+	asks := []*models.Ask{
+		makeAsk(2, 1, models.Limit, 5, 100, "2017-12-29T01:00:00Z"),
+		makeAsk(2, 1, models.Limit, 2, 800, "2017-12-29T02:00:00Z"),
+		makeAsk(2, 1, models.Market, 3, 500, "2017-12-29T03:00:00Z"),
+		makeAsk(2, 1, models.StopLossActive, 11, 400, "2017-12-29T04:00:00Z"),
+		makeAsk(2, 1, models.Limit, 10, 100, "2017-12-29T05:00:00Z"),
+	}
+	for i, ask := range asks {
+		ask.Id = uint32(i + 1)
+	}
+	return asks
+}
+
+func TestBidQueue_init(t *testing.T) {
+	queue := NewBidQueue(MaxPriceFirst)
+
+	assert.Equal(t, queue.Size(), 0, "queue.Size() = %d; want %d", queue.Size(), 0)
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+	assert.Nil(t, queue.Head(), "queue.Head() = %v; want nil", queue.Head())
+	assert.Nil(t, queue.Pop(), "queue.Pop() returned a bid from an empty queue")
+}
+
+func TestAskQueue_init(t *testing.T) {
+	queue := NewAskQueue(MinPriceFirst)
+
+	assert.Equal(t, queue.Size(), 0, "queue.Size() = %d; want %d", queue.Size(), 0)
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+	assert.Nil(t, queue.Head(), "queue.Head() = %v; want nil", queue.Head())
+	assert.Nil(t, queue.Pop(), "queue.Pop() returned an ask from an empty queue")
+}
+
+func TestBidQueuePushAndPop_protects_max_price_time_order(t *testing.T) {
+	queue := NewBidQueue(MaxPriceFirst)
+
+	for _, bid := range makeTestBids() {
+		queue.Push(bid)
+	}
+
+	// market orders come first in the order they were pushed, and then
+	// each price level in the order the bids were pushed
+	var expectedPrice = []uint64{500, 400, 800, 100, 100}
+	var expectedQty = []uint64{3, 11, 2, 5, 10}
+
+	for i := 0; i <= 4; i++ {
+		topBid := queue.Pop()
+		assert.Equal(t, topBid.Price, expectedPrice[i], "price = %v; want %v", topBid.Price, expectedPrice[i])
+		assert.Equal(t, topBid.StockQuantity, expectedQty[i], "quantity = %v; want %v", topBid.StockQuantity, expectedQty[i])
+	}
+
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+}
+
+func TestAskQueuePushAndPop_protects_min_price_time_order(t *testing.T) {
+	queue := NewAskQueue(MinPriceFirst)
+
+	for _, ask := range makeTestAsks() {
+		queue.Push(ask)
+	}
+
+	// market orders come first in the order they were pushed, and then
+	// each price level in the order the asks were pushed
+	var expectedPrice = []uint64{500, 400, 100, 100, 800}
+	var expectedQty = []uint64{3, 11, 5, 10, 2}
+
+	for i := 0; i <= 4; i++ {
+		topAsk := queue.Pop()
+		assert.Equal(t, topAsk.Price, expectedPrice[i], "price = %v; want %v", topAsk.Price, expectedPrice[i])
+		assert.Equal(t, topAsk.StockQuantity, expectedQty[i], "quantity = %v; want %v", topAsk.StockQuantity, expectedQty[i])
+	}
+
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+}
+
+func TestBidQueuePushAndPop_concurrently_protects_max_price_order(t *testing.T) {
+	var wg sync.WaitGroup
+
+	queue := NewBidQueue(MaxPriceFirst)
+
+	for _, bid := range makeTestBids() {
+		wg.Add(1)
+
+		go func(bid *models.Bid) {
+			defer wg.Done()
+
+			queue.Push(bid)
+		}(bid)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, queue.Size(), 5, "queue.Size() = %d; want %d", queue.Size(), 5)
+
+	// the order of pushes isn't known, so only check that market orders come
+	// first and that the limit orders come in decreasing order of price
+	for i := 0; i < 2; i++ {
+		topBid := queue.Pop()
+		assert.True(t, isMarket(topBid.OrderType), "bid %+v is not a market order", topBid)
+	}
+	var expectedPrice = []uint64{800, 100, 100}
+	for i := 0; i < 3; i++ {
+		topBid := queue.Pop()
+		assert.Equal(t, topBid.Price, expectedPrice[i], "price = %v; want %v", topBid.Price, expectedPrice[i])
+	}
+}
+
+func TestAskQueuePushAndPop_concurrently_protects_min_price_order(t *testing.T) {
+	var wg sync.WaitGroup
+
+	queue := NewAskQueue(MinPriceFirst)
+
+	for _, ask := range makeTestAsks() {
+		wg.Add(1)
+
+		go func(ask *models.Ask) {
+			defer wg.Done()
+
+			queue.Push(ask)
+		}(ask)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, queue.Size(), 5, "queue.Size() = %d; want %d", queue.Size(), 5)
+
+	// the order of pushes isn't known, so only check that market orders come
+	// first and that the limit orders come in increasing order of price
+	for i := 0; i < 2; i++ {
+		topAsk := queue.Pop()
+		assert.True(t, isMarket(topAsk.OrderType), "ask %+v is not a market order", topAsk)
+	}
+	var expectedPrice = []uint64{100, 100, 800}
+	for i := 0; i < 3; i++ {
+		topAsk := queue.Pop()
+		assert.Equal(t, topAsk.Price, expectedPrice[i], "price = %v; want %v", topAsk.Price, expectedPrice[i])
+	}
+}
+
+func TestBidQueueHead_returns_max_element(t *testing.T) {
+	queue := NewBidQueue(MaxPriceFirst)
+
+	bid1 := makeBid(2, 1, models.Limit, 5, 100, "2017-12-29T01:00:00Z")
+	bid1.Id = 1
+	bid2 := makeBid(2, 1, models.Limit, 11, 400, "2017-12-29T02:00:00Z")
+	bid2.Id = 2
+
+	queue.Push(bid1)
+	queue.Push(bid2)
+
+	assert.Equal(t, queue.Head(), bid2, "queue.Head() = %+v; want %+v", queue.Head(), bid2)
+	assert.Equal(t, queue.Size(), 2, "queue.Size() = %d; want %d", queue.Size(), 2)
+}
+
+func TestAskQueueHead_returns_min_element(t *testing.T) {
+	queue := NewAskQueue(MinPriceFirst)
+
+	ask1 := makeAsk(2, 1, models.Limit, 5, 100, "2017-12-29T01:00:00Z")
+	ask1.Id = 1
+	ask2 := makeAsk(2, 1, models.Limit, 11, 400, "2017-12-29T02:00:00Z")
+	ask2.Id = 2
+
+	queue.Push(ask1)
+	queue.Push(ask2)
+
+	assert.Equal(t, queue.Head(), ask1, "queue.Head() = %+v; want %+v", queue.Head(), ask1)
+	assert.Equal(t, queue.Size(), 2, "queue.Size() = %d; want %d", queue.Size(), 2)
+}
+
+func TestBidQueueRemove_removes_from_anywhere(t *testing.T) {
+	queue := NewBidQueue(MaxPriceFirst)
+
+	bids := makeTestBids()
+	for _, bid := range bids {
+		queue.Push(bid)
+	}
+
+	// remove a market order, the only order of a price level and an order sharing a level
+	assert.Equal(t, queue.Remove(bids[2].Id), bids[2], "Remove didn't return the removed market bid")
+	assert.Equal(t, queue.Remove(bids[1].Id), bids[1], "Remove didn't return the removed limit bid")
+	assert.Equal(t, queue.Remove(bids[0].Id), bids[0], "Remove didn't return the removed limit bid")
+	assert.Nil(t, queue.Remove(bids[0].Id), "Remove returned a bid that was removed already")
+
+	assert.Equal(t, queue.Size(), 2, "queue.Size() = %d; want %d", queue.Size(), 2)
+	assert.Equal(t, queue.Pop(), bids[3], "stoploss active bid should be served first")
+	assert.Equal(t, queue.Pop(), bids[4], "remaining limit bid should be served last")
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+}
+
+func TestAskQueueRemove_removes_from_anywhere(t *testing.T) {
+	queue := NewAskQueue(MinPriceFirst)
+
+	asks := makeTestAsks()
+	for _, ask := range asks {
+		queue.Push(ask)
+	}
+
+	// remove a market order, the only order of a price level and an order sharing a level
+	assert.Equal(t, queue.Remove(asks[2].Id), asks[2], "Remove didn't return the removed market ask")
+	assert.Equal(t, queue.Remove(asks[1].Id), asks[1], "Remove didn't return the removed limit ask")
+	assert.Equal(t, queue.Remove(asks[0].Id), asks[0], "Remove didn't return the removed limit ask")
+	assert.Nil(t, queue.Remove(asks[0].Id), "Remove returned an ask that was removed already")
+
+	assert.Equal(t, queue.Size(), 2, "queue.Size() = %d; want %d", queue.Size(), 2)
+	assert.Equal(t, queue.Pop(), asks[3], "stoploss active ask should be served first")
+	assert.Equal(t, queue.Pop(), asks[4], "remaining limit ask should be served last")
+	assert.True(t, queue.Empty(), "queue.Empty() = false; want true")
+}
+
+func TestAskQueuePushFront_keeps_time_priority(t *testing.T) {
+	queue := NewAskQueue(MinPriceFirst)
+
+	asks := makeTestAsks()
+	for _, ask := range asks {
+		queue.Push(ask)
+	}
+
+	// take out the first two limit asks at price 100 and put them back
+	queue.Remove(asks[2].Id)
+	queue.Remove(asks[3].Id)
+	first, second := queue.Pop(), queue.Pop()
+	queue.PushFront(second)
+	queue.PushFront(first)
+
+	assert.Equal(t, queue.Pop(), asks[0], "ask pushed back to the front lost its priority")
+	assert.Equal(t, queue.Pop(), asks[4], "ask pushed back to the front lost its priority")
+	assert.Equal(t, queue.Pop(), asks[1], "queue.Pop() = %+v; want %+v", asks[1])
+}
@@ -0,0 +1,484 @@
+package matchingengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/delta/dalal-street-server/models"
+)
+
+// replayCoolOffPeriod is how long a replayed halt lasts. It only ends when the journal says trading resumed.
+const replayCoolOffPeriod = 100 * 365 * 24 * time.Hour
+
+// ReplayMismatchError is returned by ReplayJournal when the replayed order book didn't do what
+// the journal says the order book did
+type ReplayMismatchError struct {
+	seq    uint64
+	reason string
+}
+
+func (e ReplayMismatchError) Error() string {
+	return fmt.Sprintf("Replay differs from the journal at entry %d: %s", e.seq, e.reason)
+}
+
+// ReplayReport sums up a replayed journal
+type ReplayReport struct {
+	Sessions uint64 // number of times the server started
+	Inputs   uint64 // number of inputs fed to the order books
+	Fills    uint64 // number of calls to fill an order
+	Trades   uint64 // number of fills that made a trade
+}
+
+// replayDepth is a market depth that ignores everything. A replayed order book doesn't publish anything.
+type replayDepth struct{}
+
+func (replayDepth) AddListener(done <-chan struct{}, updates chan interface{}, sessionId string) {}
+func (replayDepth) RemoveListener(sessionId string)                                              {}
+func (replayDepth) AddOrder(isMarket bool, isAsk bool, price uint64, stockQuantity uint64)       {}
+func (replayDepth) AddTrade(price uint64, qty uint64, createdAt string)                          {}
+func (replayDepth) CloseOrder(isMarket bool, isAsk bool, price uint64, stockQuantity uint64)     {}
+func (replayDepth) SetIndicativePrice(price uint64, volume uint64)                               {}
+
+// replay feeds the inputs of a single session of a journal through a fresh order book. The functions
+// the order book calls into models are replaced by methods of replay, which check each call against
+// the outputs journaled for the current input, and return what the journal says they returned.
+type replay struct {
+	ob     *orderBook
+	asks   map[uint32]*models.Ask
+	bids   map[uint32]*models.Bid
+	report *ReplayReport
+
+	// seq of the input being replayed, and the outputs it should produce
+	seq     uint64
+	outputs []*journalEntry
+
+	// err is the first mismatch found. The order book is wound down as fast as possible after it.
+	err error
+}
+
+func isJournalOutput(t journalEntryType) bool {
+	switch t {
+	case journalFill, journalHalt, journalTrail, journalTrigger, journalModifyResult, journalStockPrice, journalSelfTradePrevention:
+		return true
+	}
+	return false
+}
+
+// ReplayJournal feeds the inputs recorded in a journal through fresh order books, and checks that
+// they fill the same orders in the same way, trigger the same stoplosses, and so on. Every session
+// of the journal starts with an empty order book. Nothing is written to the database.
+// ReplayMismatchError is returned for the first difference found.
+// It replaces the functions the order books use to reach models till it returns, so it mustn't
+// be used while a matching engine is running in the same process.
+func ReplayJournal(r io.Reader) (*ReplayReport, error) {
+	var entries []*journalEntry
+
+	dec := json.NewDecoder(r)
+	for {
+		e := &journalEntry{}
+		if err := dec.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	oldFillOrderFn := fillOrderFn
+	oldModifyOrderFn := modifyOrderFn
+	oldExpireAskFn, oldExpireBidFn := expireAskFn, expireBidFn
+	oldTrailAskFn, oldTrailBidFn := trailAskFn, trailBidFn
+	oldSetAuctionPriceFn := setAuctionPriceFn
+	oldCheckCircuitBreakerFn, oldResumeStockFn := checkCircuitBreakerFn, resumeStockFn
+	oldGetSelfTradePreventionFn := getSelfTradePreventionFn
+	oldCancelSelfTradeAskFn, oldCancelSelfTradeBidFn := cancelSelfTradeAskFn, cancelSelfTradeBidFn
+	oldDecrementSelfTradeAskFn, oldDecrementSelfTradeBidFn := decrementSelfTradeAskFn, decrementSelfTradeBidFn
+	oldTriggerAskStoplossFn, oldTriggerBidStoplossFn := triggerAskStoplossFn, triggerBidStoplossFn
+	oldGetStockCopyFn := getStockCopyFn
+	defer func() {
+		fillOrderFn = oldFillOrderFn
+		modifyOrderFn = oldModifyOrderFn
+		expireAskFn, expireBidFn = oldExpireAskFn, oldExpireBidFn
+		trailAskFn, trailBidFn = oldTrailAskFn, oldTrailBidFn
+		setAuctionPriceFn = oldSetAuctionPriceFn
+		checkCircuitBreakerFn, resumeStockFn = oldCheckCircuitBreakerFn, oldResumeStockFn
+		getSelfTradePreventionFn = oldGetSelfTradePreventionFn
+		cancelSelfTradeAskFn, cancelSelfTradeBidFn = oldCancelSelfTradeAskFn, oldCancelSelfTradeBidFn
+		decrementSelfTradeAskFn, decrementSelfTradeBidFn = oldDecrementSelfTradeAskFn, oldDecrementSelfTradeBidFn
+		triggerAskStoplossFn, triggerBidStoplossFn = oldTriggerAskStoplossFn, oldTriggerBidStoplossFn
+		getStockCopyFn = oldGetStockCopyFn
+	}()
+
+	report := &ReplayReport{}
+	var rp *replay
+
+	for i := 0; i < len(entries); {
+		e := entries[i]
+		i++
+
+		if isJournalOutput(e.Type) {
+			return report, ReplayMismatchError{e.Seq, fmt.Sprintf("%s doesn't follow any input", e.Type)}
+		}
+
+		if e.Type == journalStart {
+			rp = newReplay(e.StockId, report)
+			report.Sessions++
+			continue
+		}
+		if rp == nil {
+			return report, ReplayMismatchError{e.Seq, "the journal doesn't begin with a Start entry"}
+		}
+
+		// the outputs of this input follow it
+		var outputs []*journalEntry
+		for i < len(entries) && isJournalOutput(entries[i].Type) {
+			outputs = append(outputs, entries[i])
+			i++
+		}
+
+		if err := rp.apply(e, outputs); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// newReplay makes a fresh order book for a session of a journal, and points the order book's
+// functions to the new replay
+func newReplay(stockId uint32, report *ReplayReport) *replay {
+	rp := &replay{
+		ob:     newOrderBook(stockId, replayDepth{}),
+		asks:   make(map[uint32]*models.Ask),
+		bids:   make(map[uint32]*models.Bid),
+		report: report,
+	}
+
+	fillOrderFn = rp.fillOrder
+	modifyOrderFn = rp.modifyOrder
+	expireAskFn, expireBidFn = rp.closeAsk, rp.closeBid
+	trailAskFn, trailBidFn = rp.trailAsk, rp.trailBid
+	setAuctionPriceFn = func(stockId uint32, price uint64, volume uint64) error { return nil }
+	checkCircuitBreakerFn, resumeStockFn = rp.checkCircuitBreaker, func(stockId uint32) {}
+	getSelfTradePreventionFn = rp.getSelfTradePrevention
+	cancelSelfTradeAskFn, cancelSelfTradeBidFn = rp.closeAsk, rp.closeBid
+	decrementSelfTradeAskFn, decrementSelfTradeBidFn = rp.decrementAsk, rp.decrementBid
+	triggerAskStoplossFn, triggerBidStoplossFn = rp.triggerAsk, rp.triggerBid
+	getStockCopyFn = rp.getStockCopy
+
+	return rp
+}
+
+// apply feeds an input to the order book, and checks that it produced exactly the given outputs
+func (rp *replay) apply(e *journalEntry, outputs []*journalEntry) error {
+	rp.seq = e.Seq
+	rp.outputs = outputs
+	rp.report.Inputs++
+
+	ob := rp.ob
+	// inputs handled by the goroutine matching orders are followed by an update of the indicative price
+	handledByGoroutine := true
+
+	switch e.Type {
+	case journalLoadAsk, journalAddAsk, journalAddStoplossAsk:
+		ask := &models.Ask{}
+		if err := json.Unmarshal(e.Ask, ask); err != nil {
+			return ReplayMismatchError{e.Seq, fmt.Sprintf("unable to decode ask: %+v", err)}
+		}
+		rp.asks[ask.Id] = ask
+
+		if e.Type == journalLoadAsk {
+			handledByGoroutine = false
+			ob.LoadOldAsk(ask)
+		} else if e.Type == journalAddStoplossAsk {
+			ob.trackGroupAsk(ask)
+			ob.addStoplossAsk(ask)
+		} else {
+			ob.trackGroupAsk(ask)
+			ob.processAsk(ask)
+		}
+
+	case journalLoadBid, journalAddBid, journalAddStoplossBid:
+		bid := &models.Bid{}
+		if err := json.Unmarshal(e.Bid, bid); err != nil {
+			return ReplayMismatchError{e.Seq, fmt.Sprintf("unable to decode bid: %+v", err)}
+		}
+		rp.bids[bid.Id] = bid
+
+		if e.Type == journalLoadBid {
+			handledByGoroutine = false
+			ob.LoadOldBid(bid)
+		} else if e.Type == journalAddStoplossBid {
+			ob.trackGroupBid(bid)
+			ob.addStoplossBid(bid)
+		} else {
+			ob.trackGroupBid(bid)
+			ob.processBid(bid)
+		}
+
+	case journalStartMatching:
+		handledByGoroutine = false
+		ob.clearExistingOrders()
+
+	case journalCancelAsk:
+		// models closed the ask before the order book got to know of it
+		if ask, ok := rp.asks[e.OrderId]; ok {
+			rp.closeAsk(ask)
+			ob.cancelAsk(ask)
+		}
+
+	case journalCancelBid:
+		if bid, ok := rp.bids[e.OrderId]; ok {
+			rp.closeBid(bid)
+			ob.cancelBid(bid)
+		}
+
+	case journalModify:
+		ob.modifyOrder(&orderModification{
+			userId:        e.UserId,
+			orderId:       e.OrderId,
+			isAsk:         e.IsAsk,
+			price:         e.Price,
+			stockQuantity: e.StockQuantity,
+		})
+
+	case journalStartAuction:
+		ob.inAuction = true
+
+	case journalUncross:
+		ob.uncross()
+
+	case journalResume:
+		ob.endCoolOff()
+
+	case journalSuspend:
+		ob.suspend()
+
+	case journalUnsuspend:
+		ob.unsuspend()
+
+	case journalStop:
+		handledByGoroutine = false
+		ob.stop()
+
+	default:
+		return ReplayMismatchError{e.Seq, fmt.Sprintf("unknown entry type %s", e.Type)}
+	}
+
+	if rp.err == nil {
+		ob.processTriggeredOrders()
+	}
+
+	if handledByGoroutine && rp.err == nil && (ob.inAuction || ob.isHalted) {
+		ob.publishIndicativePrice()
+	}
+
+	if rp.err == nil && len(rp.outputs) > 0 {
+		rp.mismatch("the order book didn't produce %s", rp.outputs[0].Type)
+	}
+
+	return rp.err
+}
+
+// mismatch remembers the first difference between the journal and the replay
+func (rp *replay) mismatch(format string, args ...interface{}) {
+	if rp.err == nil {
+		rp.err = ReplayMismatchError{rp.seq, fmt.Sprintf(format, args...)}
+	}
+}
+
+// next returns the next output the current input should produce, if it's of the given type
+func (rp *replay) next(t journalEntryType) *journalEntry {
+	if rp.err != nil {
+		return nil
+	}
+	if len(rp.outputs) == 0 {
+		rp.mismatch("the order book produced %s, which isn't in the journal", t)
+		return nil
+	}
+
+	e := rp.outputs[0]
+	if e.Type != t {
+		rp.mismatch("the order book produced %s, but the journal has %s (entry %d)", t, e.Type, e.Seq)
+		return nil
+	}
+
+	rp.outputs = rp.outputs[1:]
+	return e
+}
+
+func (rp *replay) fillOrder(ask *models.Ask, bid *models.Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (models.AskOrderFillStatus, models.BidOrderFillStatus, *models.Transaction) {
+	e := rp.next(journalFill)
+	if e != nil && (e.AskId != ask.Id || e.BidId != bid.Id || e.Price != stockTradePrice || e.StockQuantity != stockTradeQty) {
+		rp.mismatch("ask %d and bid %d were filled with %d stocks at %d, but the journal has ask %d and bid %d with %d stocks at %d (entry %d)",
+			ask.Id, bid.Id, stockTradeQty, stockTradePrice, e.AskId, e.BidId, e.StockQuantity, e.Price, e.Seq)
+	}
+	// both the orders are dropped, so that the order book stops matching
+	if rp.err != nil {
+		return models.AskAlreadyClosed, models.BidAlreadyClosed, nil
+	}
+
+	rp.report.Fills++
+
+	var tr *models.Transaction
+	if e.Traded {
+		rp.report.Trades++
+		ask.StockQuantityFulfilled += e.TradeQuantity
+		bid.StockQuantityFulfilled += e.TradeQuantity
+		tr = &models.Transaction{
+			StockId:       ask.StockId,
+			Price:         e.TradePrice,
+			StockQuantity: -int64(e.TradeQuantity),
+		}
+	}
+	if e.AskStatus != models.AskUndone {
+		ask.IsClosed = true
+	}
+	if e.BidStatus != models.BidUndone {
+		bid.IsClosed = true
+	}
+
+	return e.AskStatus, e.BidStatus, tr
+}
+
+func (rp *replay) modifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) (*models.ModifiedOrder, error) {
+	e := rp.next(journalModifyResult)
+	if e != nil && (e.OrderId != orderId || e.IsAsk != isAsk) {
+		rp.mismatch("order %d was modified, but the journal has order %d (entry %d)", orderId, e.OrderId, e.Seq)
+	}
+	if rp.err != nil || e.Failed {
+		return nil, errors.New("Order wasn't modified")
+	}
+
+	if isAsk {
+		ask, ok := rp.asks[orderId]
+		if !ok {
+			rp.mismatch("ask %d got modified before it was added", orderId)
+			return nil, rp.err
+		}
+		modifiedOrder := &models.ModifiedOrder{Ask: ask, OldPrice: ask.Price, OldStockQuantity: ask.StockQuantity}
+		ask.Price = price
+		ask.StockQuantity = stockQuantity
+		return modifiedOrder, nil
+	}
+
+	bid, ok := rp.bids[orderId]
+	if !ok {
+		rp.mismatch("bid %d got modified before it was added", orderId)
+		return nil, rp.err
+	}
+	modifiedOrder := &models.ModifiedOrder{Bid: bid, OldPrice: bid.Price, OldStockQuantity: bid.StockQuantity}
+	bid.Price = price
+	bid.StockQuantity = stockQuantity
+	return modifiedOrder, nil
+}
+
+func (rp *replay) trailAsk(ask *models.Ask, price uint64) (bool, error) {
+	e := rp.next(journalTrail)
+	if e == nil || e.OrderId != ask.Id || !e.IsAsk {
+		rp.mismatch("ask %d was trailed when it shouldn't have been", ask.Id)
+		return false, nil
+	}
+	if e.Moved {
+		ask.Price = e.Price
+	}
+	return e.Moved, nil
+}
+
+func (rp *replay) trailBid(bid *models.Bid, price uint64) (bool, error) {
+	e := rp.next(journalTrail)
+	if e == nil || e.OrderId != bid.Id || e.IsAsk {
+		rp.mismatch("bid %d was trailed when it shouldn't have been", bid.Id)
+		return false, nil
+	}
+	if e.Moved {
+		bid.Price = e.Price
+	}
+	return e.Moved, nil
+}
+
+// triggerAsk makes the same changes to the ask as models does, without saving them
+func (rp *replay) triggerAsk(ask *models.Ask) error {
+	if e := rp.next(journalTrigger); e == nil || e.OrderId != ask.Id || !e.IsAsk {
+		rp.mismatch("ask %d was triggered when it shouldn't have been", ask.Id)
+	}
+
+	if ask.OrderType == models.StopLimit {
+		ask.OrderType = models.StopLimitActive
+		ask.Price = ask.LimitPrice
+	} else {
+		ask.OrderType = models.StopLossActive
+	}
+	return nil
+}
+
+// triggerBid makes the same changes to the bid as models does, without saving them
+func (rp *replay) triggerBid(bid *models.Bid) error {
+	if e := rp.next(journalTrigger); e == nil || e.OrderId != bid.Id || e.IsAsk {
+		rp.mismatch("bid %d was triggered when it shouldn't have been", bid.Id)
+	}
+
+	if bid.OrderType == models.StopLimit {
+		bid.OrderType = models.StopLimitActive
+		bid.Price = bid.LimitPrice
+	} else {
+		bid.OrderType = models.StopLossActive
+	}
+	return nil
+}
+
+// checkCircuitBreaker halts the order book only where the journal has a halt
+func (rp *replay) checkCircuitBreaker(stockId uint32, price uint64) (bool, time.Duration) {
+	if rp.err != nil || len(rp.outputs) == 0 || rp.outputs[0].Type != journalHalt {
+		return false, 0
+	}
+	if e := rp.next(journalHalt); e.Price != price {
+		rp.mismatch("trading was halted at price %d, but the journal has %d (entry %d)", price, e.Price, e.Seq)
+	}
+	return true, replayCoolOffPeriod
+}
+
+func (rp *replay) getSelfTradePrevention(stockId uint32) models.SelfTradePrevention {
+	e := rp.next(journalSelfTradePrevention)
+	if e == nil {
+		return models.SkipOwnOrders
+	}
+	return e.Mode
+}
+
+func (rp *replay) getStockCopy(stockId uint32) (models.Stock, error) {
+	e := rp.next(journalStockPrice)
+	if e == nil {
+		return models.Stock{Id: stockId}, nil
+	}
+	return models.Stock{Id: stockId, CurrentPrice: e.Price}, nil
+}
+
+// closeAsk closes an ask that models would have closed
+func (rp *replay) closeAsk(ask *models.Ask) error {
+	ask.Lock()
+	ask.IsClosed = true
+	ask.Unlock()
+	return nil
+}
+
+// closeBid closes a bid that models would have closed
+func (rp *replay) closeBid(bid *models.Bid) error {
+	bid.Lock()
+	bid.IsClosed = true
+	bid.Unlock()
+	return nil
+}
+
+func (rp *replay) decrementAsk(ask *models.Ask, stockQuantity uint64) error {
+	ask.StockQuantity -= stockQuantity
+	return nil
+}
+
+func (rp *replay) decrementBid(bid *models.Bid, stockQuantity uint64) error {
+	bid.StockQuantity -= stockQuantity
+	return nil
+}
@@ -0,0 +1,322 @@
+// Package metrics keeps counters, gauges and histograms of what the server is doing,
+// and serves them in the Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default buckets of a histogram, in seconds
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric that can write itself out in the Prometheus text format
+type collector interface {
+	name() string
+	write(buf *bytes.Buffer)
+}
+
+// registry holds all the metrics, by name
+var registry = struct {
+	sync.RWMutex
+	m map[string]collector
+}{
+	sync.RWMutex{},
+	make(map[string]collector),
+}
+
+// register adds a metric to the registry. A metric registered earlier with the same name is replaced.
+func register(c collector) {
+	registry.Lock()
+	registry.m[c.name()] = c
+	registry.Unlock()
+}
+
+// desc has what's common to all kinds of metrics
+type desc struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func (d *desc) writeHeader(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", d.metricName, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", d.metricName, d.metricType)
+}
+
+// labelKey joins the label values of a series, to key the series by
+func (d *desc) labelKey(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+// formatLabels formats the labels of a series like {a="1",b="2"}. extra is appended as is.
+func (d *desc) formatLabels(labelValues []string, extra string) string {
+	var pairs []string
+	for i, name := range d.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(labelValues[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is a single value of a counter or a gauge
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// sortedKeys returns the keys of the series in a stable order
+func sortedKeys(m map[string]*series) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueVec is a counter or a gauge, split by labels
+type valueVec struct {
+	desc
+	sync.Mutex
+	series map[string]*series
+}
+
+func newValueVec(name, help, metricType string, labelNames []string) *valueVec {
+	return &valueVec{
+		desc:   desc{name, help, metricType, labelNames},
+		series: make(map[string]*series),
+	}
+}
+
+// get returns the series with the given label values, creating it if needed. It must be called with the lock held.
+func (v *valueVec) get(labelValues []string) *series {
+	key := v.labelKey(labelValues)
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *valueVec) write(buf *bytes.Buffer) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.writeHeader(buf)
+	for _, k := range sortedKeys(v.series) {
+		s := v.series[k]
+		fmt.Fprintf(buf, "%s%s %s\n", v.metricName, v.formatLabels(s.labelValues, ""), formatValue(s.value))
+	}
+}
+
+// CounterVec is a counter split by labels. It only goes up.
+type CounterVec struct{ v *valueVec }
+
+// NewCounterVec creates and registers a counter
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := newValueVec(name, help, "counter", labelNames)
+	register(v)
+	return &CounterVec{v}
+}
+
+// Inc adds 1 to the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter with the given label values. delta mustn't be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can't go down", c.v.metricName))
+	}
+
+	c.v.Lock()
+	c.v.get(labelValues).value += delta
+	c.v.Unlock()
+}
+
+// GaugeVec is a gauge split by labels. It can go up and down.
+type GaugeVec struct{ v *valueVec }
+
+// NewGaugeVec creates and registers a gauge
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := newValueVec(name, help, "gauge", labelNames)
+	register(v)
+	return &GaugeVec{v}
+}
+
+// Set sets the gauge with the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.Lock()
+	g.v.get(labelValues).value = value
+	g.v.Unlock()
+}
+
+// Add adds delta to the gauge with the given label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.Lock()
+	g.v.get(labelValues).value += delta
+	g.v.Unlock()
+}
+
+// gaugeFunc is a gauge whose values are collected when the metrics are served
+type gaugeFunc struct {
+	desc
+	collect func(set func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc registers a gauge whose values are collected when the metrics are served. collect
+// calls set once for each series. It replaces a gauge registered earlier with the same name.
+func NewGaugeFunc(name, help string, labelNames []string, collect func(set func(value float64, labelValues ...string))) {
+	register(&gaugeFunc{
+		desc:    desc{name, help, "gauge", labelNames},
+		collect: collect,
+	})
+}
+
+func (g *gaugeFunc) write(buf *bytes.Buffer) {
+	v := newValueVec(g.metricName, g.help, g.metricType, g.labelNames)
+	g.collect(func(value float64, labelValues ...string) {
+		v.get(labelValues).value = value
+	})
+	v.write(buf)
+}
+
+// histogram is a single series of a HistogramVec
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // counts[i] is the number of observations <= buckets[i]
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec counts observations in buckets, split by labels
+type HistogramVec struct {
+	desc
+	sync.Mutex
+	buckets []float64
+	series  map[string]*histogram
+}
+
+// NewHistogramVec creates and registers a histogram. DefBuckets are used if buckets is nil.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	h := &HistogramVec{
+		desc:    desc{name, help, "histogram", labelNames},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.labelKey(labelValues)
+
+	h.Lock()
+	defer h.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	h.Lock()
+	defer h.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(buf)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, upperBound := range h.buckets {
+			le := fmt.Sprintf(`le="%s"`, formatValue(upperBound))
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.metricName, h.formatLabels(s.labelValues, le), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", h.metricName, h.formatLabels(s.labelValues, `le="+Inf"`), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", h.metricName, h.formatLabels(s.labelValues, ""), formatValue(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.metricName, h.formatLabels(s.labelValues, ""), s.count)
+	}
+}
+
+// Handler serves all the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.RLock()
+		collectors := make([]collector, 0, len(registry.m))
+		for _, c := range registry.m {
+			collectors = append(collectors, c)
+		}
+		registry.RUnlock()
+
+		sort.Slice(collectors, func(i, j int) bool {
+			return collectors[i].name() < collectors[j].name()
+		})
+
+		var buf bytes.Buffer
+		for _, c := range collectors {
+			c.write(&buf)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	counter := NewCounterVec("test_requests_total", "Number of requests.", "method")
+	gauge := NewGaugeVec("test_listeners", "Number of listeners.", "stream")
+	histogram := NewHistogramVec("test_duration_seconds", "Time taken.", []float64{0.1, 1}, "method")
+	NewGaugeFunc("test_queue_size", "Size of a queue.", []string{"queue"}, func(set func(value float64, labelValues ...string)) {
+		set(3, "asks")
+	})
+
+	counter.Inc("Buy")
+	counter.Add(2, "Buy")
+	counter.Inc(`Se"ll`)
+	gauge.Add(2, "Prices")
+	gauge.Add(-1, "Prices")
+	histogram.Observe(0.05, "Buy")
+	histogram.Observe(0.5, "Buy")
+	histogram.Observe(5, "Buy")
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	var expectedLines = []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{method="Buy"} 3`,
+		`test_requests_total{method="Se\"ll"} 1`,
+		"# TYPE test_listeners gauge",
+		`test_listeners{stream="Prices"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{method="Buy",le="0.1"} 1`,
+		`test_duration_seconds_bucket{method="Buy",le="1"} 2`,
+		`test_duration_seconds_bucket{method="Buy",le="+Inf"} 3`,
+		`test_duration_seconds_sum{method="Buy"} 5.55`,
+		`test_duration_seconds_count{method="Buy"} 3`,
+		`test_queue_size{queue="asks"} 3`,
+	}
+
+	for _, line := range expectedLines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in\n%s", line, body)
+		}
+	}
+}
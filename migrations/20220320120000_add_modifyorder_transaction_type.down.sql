@@ -0,0 +1,2 @@
+DELETE FROM Transactions WHERE type IN ('ModifyOrderTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction');
@@ -0,0 +1 @@
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction');
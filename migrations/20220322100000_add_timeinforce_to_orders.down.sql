@@ -0,0 +1,2 @@
+ALTER TABLE Asks DROP COLUMN timeInForce, DROP COLUMN expiresOnDay;
+ALTER TABLE Bids DROP COLUMN timeInForce, DROP COLUMN expiresOnDay;
@@ -0,0 +1,2 @@
+ALTER TABLE Asks ADD timeInForce enum('GoodTillCancelled', 'Day', 'GoodTillDate', 'ImmediateOrCancel', 'FillOrKill') NOT NULL DEFAULT 'GoodTillCancelled', ADD expiresOnDay int(11) UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE Bids ADD timeInForce enum('GoodTillCancelled', 'Day', 'GoodTillDate', 'ImmediateOrCancel', 'FillOrKill') NOT NULL DEFAULT 'GoodTillCancelled', ADD expiresOnDay int(11) UNSIGNED NOT NULL DEFAULT 0;
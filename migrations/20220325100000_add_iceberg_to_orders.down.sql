@@ -0,0 +1,2 @@
+ALTER TABLE Asks DROP COLUMN isIceberg, DROP COLUMN displayQuantity;
+ALTER TABLE Bids DROP COLUMN isIceberg, DROP COLUMN displayQuantity;
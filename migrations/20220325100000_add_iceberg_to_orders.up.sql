@@ -0,0 +1,2 @@
+ALTER TABLE Asks ADD isIceberg tinyint(1) NOT NULL DEFAULT 0, ADD displayQuantity bigint(11) UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE Bids ADD isIceberg tinyint(1) NOT NULL DEFAULT 0, ADD displayQuantity bigint(11) UNSIGNED NOT NULL DEFAULT 0;
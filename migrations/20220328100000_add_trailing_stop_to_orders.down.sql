@@ -0,0 +1,2 @@
+ALTER TABLE Asks DROP COLUMN trailingAmount, DROP COLUMN trailingPercent;
+ALTER TABLE Bids DROP COLUMN trailingAmount, DROP COLUMN trailingPercent;
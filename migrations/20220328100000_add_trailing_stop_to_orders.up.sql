@@ -0,0 +1,2 @@
+ALTER TABLE Asks ADD trailingAmount bigint(11) UNSIGNED NOT NULL DEFAULT 0, ADD trailingPercent int(11) UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE Bids ADD trailingAmount bigint(11) UNSIGNED NOT NULL DEFAULT 0, ADD trailingPercent int(11) UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+UPDATE Asks SET orderType = 'StopLoss' WHERE orderType = 'StopLimit';
+UPDATE Asks SET orderType = 'StopLossActive' WHERE orderType = 'StopLimitActive';
+UPDATE Bids SET orderType = 'StopLoss' WHERE orderType = 'StopLimit';
+UPDATE Bids SET orderType = 'StopLossActive' WHERE orderType = 'StopLimitActive';
+ALTER TABLE Asks MODIFY orderType enum('Limit', 'Market', 'StopLoss', 'StopLossActive'), DROP COLUMN limitPrice;
+ALTER TABLE Bids MODIFY orderType enum('Limit', 'Market', 'StopLoss', 'StopLossActive'), DROP COLUMN limitPrice;
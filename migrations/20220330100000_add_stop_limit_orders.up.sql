@@ -0,0 +1,2 @@
+ALTER TABLE Asks MODIFY orderType enum('Limit', 'Market', 'StopLoss', 'StopLossActive', 'StopLimit', 'StopLimitActive'), ADD limitPrice bigint(11) UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE Bids MODIFY orderType enum('Limit', 'Market', 'StopLoss', 'StopLossActive', 'StopLimit', 'StopLimitActive'), ADD limitPrice bigint(11) UNSIGNED NOT NULL DEFAULT 0;
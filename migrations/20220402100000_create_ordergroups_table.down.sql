@@ -0,0 +1,3 @@
+ALTER TABLE Asks DROP groupId;
+ALTER TABLE Bids DROP groupId;
+DROP TABLE IF EXISTS OrderGroups;
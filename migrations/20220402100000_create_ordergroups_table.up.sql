@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS OrderGroups (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	userId int(11) UNSIGNED NOT NULL,
+	stockId int(11) UNSIGNED NOT NULL,
+	isAsk tinyint(1) NOT NULL,
+	stockQuantity bigint(11) UNSIGNED NOT NULL,
+	createdAt varchar(255) NOT NULL DEFAULT "0000-00-00T00:00:00+05:30",
+	PRIMARY KEY (id),
+	FOREIGN KEY (userId) REFERENCES Users(id),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+) AUTO_INCREMENT=1;
+
+ALTER TABLE Asks ADD groupId int(11) UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE Bids ADD groupId int(11) UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE Stocks DROP COLUMN selfTradePrevention;
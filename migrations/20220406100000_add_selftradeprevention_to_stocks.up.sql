@@ -0,0 +1 @@
+ALTER TABLE Stocks ADD selfTradePrevention enum('Default', 'SkipOwnOrders', 'CancelNewest', 'CancelOldest', 'CancelBoth', 'DecrementAndCancel') NOT NULL DEFAULT 'Default';
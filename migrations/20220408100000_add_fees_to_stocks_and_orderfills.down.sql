@@ -0,0 +1,4 @@
+ALTER TABLE OrderFills DROP COLUMN bidFeeTransactionId;
+ALTER TABLE OrderFills DROP COLUMN askFeeTransactionId;
+ALTER TABLE Stocks DROP COLUMN takerFeeBasisPoints;
+ALTER TABLE Stocks DROP COLUMN makerFeeBasisPoints;
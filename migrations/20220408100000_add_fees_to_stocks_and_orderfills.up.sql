@@ -0,0 +1,4 @@
+ALTER TABLE Stocks ADD makerFeeBasisPoints bigint NOT NULL DEFAULT -1;
+ALTER TABLE Stocks ADD takerFeeBasisPoints bigint NOT NULL DEFAULT -1;
+ALTER TABLE OrderFills ADD askFeeTransactionId int(11) unsigned NOT NULL DEFAULT 0;
+ALTER TABLE OrderFills ADD bidFeeTransactionId int(11) unsigned NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS TaxRecords;
+ALTER TABLE TransactionSummary DROP realizedGain;
+DROP TABLE IF EXISTS TaxBrackets;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS TaxBrackets (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	lowerLimit bigint(20) NOT NULL,
+	taxPercent bigint(11) UNSIGNED NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (lowerLimit)
+) AUTO_INCREMENT=1;
+
+INSERT INTO TaxBrackets (lowerLimit, taxPercent) VALUES (0, 2), (100000, 5), (500000, 9), (1000000, 15), (2000000, 25);
+
+ALTER TABLE TransactionSummary ADD realizedGain double NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS TaxRecords (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	userId int(11) UNSIGNED NOT NULL,
+	stockId int(11) UNSIGNED NOT NULL,
+	transactionId int(11) UNSIGNED NOT NULL,
+	taxTransactionId int(11) UNSIGNED NOT NULL,
+	stockQuantity bigint(20) NOT NULL,
+	price double NOT NULL,
+	realizedGain double NOT NULL,
+	tax bigint(20) UNSIGNED NOT NULL,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (userId) REFERENCES Users(id)
+) AUTO_INCREMENT=1;
+
+-- taxes paid so far are kept, without the trades they were paid on
+INSERT INTO TaxRecords (userId, stockId, transactionId, taxTransactionId, stockQuantity, price, realizedGain, tax, createdAt)
+	SELECT userId, COALESCE(stockId, 0), 0, id, 0, 0, 0, -total, createdAt FROM Transactions WHERE type = 'TaxTransaction';
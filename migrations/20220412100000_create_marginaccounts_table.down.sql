@@ -0,0 +1,3 @@
+DELETE FROM Transactions WHERE type IN ('MarginTransaction', 'MarginInterestTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction');
+DROP TABLE IF EXISTS MarginAccounts;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS MarginAccounts (
+	userId int(11) UNSIGNED NOT NULL,
+	loan bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	marginCallAt varchar(255) NOT NULL DEFAULT '',
+	lastInterestDay int(11) UNSIGNED NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	updatedAt varchar(255) NOT NULL,
+	PRIMARY KEY (userId),
+	FOREIGN KEY (userId) REFERENCES Users(id)
+);
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction');
@@ -0,0 +1,3 @@
+DELETE FROM Transactions WHERE type IN ('ShortSellFeeTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction');
+ALTER TABLE ShortSellLends DROP COLUMN createdAt, DROP COLUMN lastFeeDay, DROP COLUMN recalledOnDay, DROP COLUMN unpaidFee;
@@ -0,0 +1,3 @@
+ALTER TABLE ShortSellLends ADD createdAt varchar(255) NOT NULL DEFAULT "0000-00-00T00:00:00+05:30", ADD lastFeeDay int(11) UNSIGNED NOT NULL DEFAULT 0, ADD recalledOnDay int(11) UNSIGNED NOT NULL DEFAULT 0, ADD unpaidFee bigint(20) UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction');
@@ -0,0 +1,7 @@
+DELETE FROM Transactions WHERE type IN ('OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction');
+ALTER TABLE Transactions DROP COLUMN optionId;
+ALTER TABLE Bids DROP COLUMN optionId;
+ALTER TABLE Asks DROP COLUMN optionId, DROP COLUMN isOptionWrite;
+DROP TABLE IF EXISTS OptionPositions;
+DROP TABLE IF EXISTS OptionContracts;
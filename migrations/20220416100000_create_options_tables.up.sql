@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS OptionContracts (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL,
+	type enum('Call', 'Put') NOT NULL,
+	strikePrice bigint(20) UNSIGNED NOT NULL,
+	lotSize bigint(20) UNSIGNED NOT NULL,
+	expiryDay int(11) UNSIGNED NOT NULL,
+	isSettled tinyint(1) NOT NULL DEFAULT 0,
+	settlementPrice bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+);
+
+CREATE TABLE IF NOT EXISTS OptionPositions (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	userId int(11) UNSIGNED NOT NULL,
+	optionId int(11) UNSIGNED NOT NULL,
+	quantity bigint(20) NOT NULL DEFAULT 0,
+	reservedQuantity bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	collateralCash bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	collateralStocks bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	updatedAt varchar(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE KEY (userId, optionId),
+	FOREIGN KEY (userId) REFERENCES Users(id),
+	FOREIGN KEY (optionId) REFERENCES OptionContracts(id)
+);
+
+ALTER TABLE Asks ADD optionId int(11) UNSIGNED NOT NULL DEFAULT 0, ADD isOptionWrite tinyint(1) NOT NULL DEFAULT 0;
+
+ALTER TABLE Bids ADD optionId int(11) UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE Transactions ADD optionId int(11) UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction');
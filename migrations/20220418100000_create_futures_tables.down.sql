@@ -0,0 +1,7 @@
+DELETE FROM Transactions WHERE type IN ('FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction');
+ALTER TABLE Transactions DROP COLUMN futureId;
+ALTER TABLE Bids DROP COLUMN futureId, MODIFY stockId int(11) UNSIGNED NOT NULL;
+ALTER TABLE Asks DROP COLUMN futureId, MODIFY stockId int(11) UNSIGNED NOT NULL;
+DROP TABLE IF EXISTS FuturePositions;
+DROP TABLE IF EXISTS FutureContracts;
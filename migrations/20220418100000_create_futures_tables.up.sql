@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS FutureContracts (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL DEFAULT 0,
+	lotSize bigint(20) UNSIGNED NOT NULL,
+	expiryDay int(11) UNSIGNED NOT NULL,
+	isSettled tinyint(1) NOT NULL DEFAULT 0,
+	settlementPrice bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS FuturePositions (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	userId int(11) UNSIGNED NOT NULL,
+	futureId int(11) UNSIGNED NOT NULL,
+	quantity bigint(20) NOT NULL DEFAULT 0,
+	markPrice bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	realisedProfit bigint(20) NOT NULL DEFAULT 0,
+	margin bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	updatedAt varchar(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE KEY (userId, futureId),
+	FOREIGN KEY (userId) REFERENCES Users(id),
+	FOREIGN KEY (futureId) REFERENCES FutureContracts(id)
+);
+
+ALTER TABLE Asks ADD futureId int(11) UNSIGNED NOT NULL DEFAULT 0, MODIFY stockId int(11) UNSIGNED NULL;
+
+ALTER TABLE Bids ADD futureId int(11) UNSIGNED NOT NULL DEFAULT 0, MODIFY stockId int(11) UNSIGNED NULL;
+
+ALTER TABLE Transactions ADD futureId int(11) UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction');
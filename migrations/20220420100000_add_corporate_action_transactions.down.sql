@@ -0,0 +1,2 @@
+DELETE FROM Transactions WHERE type IN ('StockSplitTransaction', 'BonusIssueTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction');
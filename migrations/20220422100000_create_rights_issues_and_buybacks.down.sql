@@ -0,0 +1,6 @@
+DELETE FROM Transactions WHERE type IN ('RightsIssueTransaction', 'BuybackTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction', 'StockSplitTransaction', 'BonusIssueTransaction');
+DROP TABLE IF EXISTS BuybackTenders;
+DROP TABLE IF EXISTS Buybacks;
+DROP TABLE IF EXISTS RightsEntitlements;
+DROP TABLE IF EXISTS RightsIssues;
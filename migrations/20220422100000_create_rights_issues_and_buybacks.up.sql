@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS RightsIssues (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL,
+	newShares bigint(20) UNSIGNED NOT NULL,
+	heldShares bigint(20) UNSIGNED NOT NULL,
+	price bigint(20) UNSIGNED NOT NULL,
+	closesOnDay int(11) UNSIGNED NOT NULL,
+	isClosed tinyint(1) NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+);
+
+CREATE TABLE IF NOT EXISTS RightsEntitlements (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	rightsIssueId int(11) UNSIGNED NOT NULL,
+	userId int(11) UNSIGNED NOT NULL,
+	entitledQuantity bigint(20) UNSIGNED NOT NULL,
+	subscribedQuantity bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	UNIQUE KEY (rightsIssueId, userId),
+	FOREIGN KEY (rightsIssueId) REFERENCES RightsIssues(id),
+	FOREIGN KEY (userId) REFERENCES Users(id)
+);
+
+CREATE TABLE IF NOT EXISTS Buybacks (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL,
+	price bigint(20) UNSIGNED NOT NULL,
+	stockQuantity bigint(20) UNSIGNED NOT NULL,
+	closesOnDay int(11) UNSIGNED NOT NULL,
+	isSettled tinyint(1) NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+);
+
+CREATE TABLE IF NOT EXISTS BuybackTenders (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	buybackId int(11) UNSIGNED NOT NULL,
+	userId int(11) UNSIGNED NOT NULL,
+	stockQuantity bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	acceptedQuantity bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	UNIQUE KEY (buybackId, userId),
+	FOREIGN KEY (buybackId) REFERENCES Buybacks(id),
+	FOREIGN KEY (userId) REFERENCES Users(id)
+);
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction', 'StockSplitTransaction', 'BonusIssueTransaction', 'RightsIssueTransaction', 'BuybackTransaction');
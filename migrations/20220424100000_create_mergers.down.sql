@@ -0,0 +1,4 @@
+DELETE FROM Transactions WHERE type IN ('MergerTransaction');
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction', 'StockSplitTransaction', 'BonusIssueTransaction', 'RightsIssueTransaction', 'BuybackTransaction');
+ALTER TABLE Stocks DROP COLUMN isDelisted;
+DROP TABLE IF EXISTS Mergers;
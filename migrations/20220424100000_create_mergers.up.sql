@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS Mergers (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	fromStockId int(11) UNSIGNED NOT NULL,
+	toStockId int(11) UNSIGNED NOT NULL,
+	newShares bigint(20) UNSIGNED NOT NULL,
+	oldShares bigint(20) UNSIGNED NOT NULL,
+	cashPerShare bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (fromStockId) REFERENCES Stocks(id),
+	FOREIGN KEY (toStockId) REFERENCES Stocks(id)
+);
+
+ALTER TABLE Stocks ADD isDelisted tinyint(1) NOT NULL DEFAULT 0;
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction', 'StockSplitTransaction', 'BonusIssueTransaction', 'RightsIssueTransaction', 'BuybackTransaction', 'MergerTransaction');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS DividendHolders;
+DROP TABLE IF EXISTS Dividends;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS Dividends (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL,
+	dividendAmount bigint(20) UNSIGNED NOT NULL,
+	announcedOnDay int(11) UNSIGNED NOT NULL,
+	recordDay int(11) UNSIGNED NOT NULL,
+	paymentDay int(11) UNSIGNED NOT NULL,
+	isRecorded tinyint(1) NOT NULL DEFAULT 0,
+	isExAdjusted tinyint(1) NOT NULL DEFAULT 0,
+	isPaid tinyint(1) NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+);
+
+CREATE TABLE IF NOT EXISTS DividendHolders (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	dividendId int(11) UNSIGNED NOT NULL,
+	userId int(11) UNSIGNED NOT NULL,
+	stockQuantity bigint(20) UNSIGNED NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (dividendId, userId),
+	FOREIGN KEY (dividendId) REFERENCES Dividends(id),
+	FOREIGN KEY (userId) REFERENCES Users(id)
+);
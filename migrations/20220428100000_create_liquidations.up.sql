@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS Liquidations (
+	id int(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+	stockId int(11) UNSIGNED NOT NULL,
+	liquidationValue bigint(20) UNSIGNED NOT NULL DEFAULT 0,
+	createdAt varchar(255) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (stockId),
+	FOREIGN KEY (stockId) REFERENCES Stocks(id)
+);
+
+ALTER TABLE Transactions MODIFY type enum('FromExchangeTransaction', 'OrderFillTransaction', 'MortgageTransaction', 'DividendTransaction', 'OrderFeeTransaction', 'TaxTransaction', 'PlaceOrderTransaction', 'CancelOrderTransaction', 'ShortSellTransaction', 'IpoAllotmentTransaction', 'ModifyOrderTransaction', 'MarginTransaction', 'MarginInterestTransaction', 'ShortSellFeeTransaction', 'OptionTradeTransaction', 'OptionCollateralTransaction', 'OptionSettlementTransaction', 'FutureTradeTransaction', 'FutureVariationMarginTransaction', 'FutureSettlementTransaction', 'StockSplitTransaction', 'BonusIssueTransaction', 'RightsIssueTransaction', 'BuybackTransaction', 'MergerTransaction', 'LiquidationTransaction');
@@ -0,0 +1,5 @@
+ALTER TABLE DailyChallenge DROP COLUMN indexValue;
+DELETE UserState FROM UserState INNER JOIN DailyChallenge ON UserState.challengeId = DailyChallenge.id WHERE DailyChallenge.challengeType = 'MarketIndex';
+DELETE FROM DailyChallenge WHERE challengeType = 'MarketIndex';
+ALTER TABLE DailyChallenge MODIFY challengeType enum('Cash','NetWorth','StockWorth','SpecificStock');
+DROP TABLE IF EXISTS MarketIndexHistory;
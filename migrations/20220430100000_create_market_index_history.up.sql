@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS MarketIndexHistory (
+	indexName varchar(255) NOT NULL,
+	close bigint(20) UNSIGNED NOT NULL,
+	createdAt varchar(255) NOT NULL,
+	intervalRecord int(11) UNSIGNED NOT NULL,
+	open bigint(20) UNSIGNED NOT NULL,
+	high bigint(20) UNSIGNED NOT NULL,
+	low bigint(20) UNSIGNED NOT NULL,
+	PRIMARY KEY (indexName, intervalRecord, createdAt)
+);
+
+ALTER TABLE DailyChallenge MODIFY challengeType enum('Cash','NetWorth','StockWorth','SpecificStock','MarketIndex');
+ALTER TABLE DailyChallenge ADD indexValue bigint(20) UNSIGNED NOT NULL DEFAULT 0;
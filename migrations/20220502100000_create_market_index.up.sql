@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS MarketIndex (
+	indexName varchar(255) NOT NULL,
+	divisor double NOT NULL,
+	stockIds text NOT NULL,
+	PRIMARY KEY (indexName)
+);
@@ -81,3 +81,23 @@ func (m *MockMarketDepthStream) CloseOrder(isMarket, isAsk bool, price, stockQua
 func (mr *MockMarketDepthStreamMockRecorder) CloseOrder(isMarket, isAsk, price, stockQuantity interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseOrder", reflect.TypeOf((*MockMarketDepthStream)(nil).CloseOrder), isMarket, isAsk, price, stockQuantity)
 }
+
+// SetIndicativePrice mocks base method
+func (m *MockMarketDepthStream) SetIndicativePrice(price, volume uint64) {
+	m.ctrl.Call(m, "SetIndicativePrice", price, volume)
+}
+
+// SetIndicativePrice indicates an expected call of SetIndicativePrice
+func (mr *MockMarketDepthStreamMockRecorder) SetIndicativePrice(price, volume interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetIndicativePrice", reflect.TypeOf((*MockMarketDepthStream)(nil).SetIndicativePrice), price, volume)
+}
+
+// Clear mocks base method
+func (m *MockMarketDepthStream) Clear() {
+	m.ctrl.Call(m, "Clear")
+}
+
+// Clear indicates an expected call of Clear
+func (mr *MockMarketDepthStreamMockRecorder) Clear() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clear", reflect.TypeOf((*MockMarketDepthStream)(nil).Clear))
+}
@@ -5,70 +5,264 @@
 package mocks
 
 import (
+	reflect "reflect"
+	time "time"
+
 	models "github.com/delta/dalal-street-server/models"
 	gomock "github.com/golang/mock/gomock"
-	reflect "reflect"
 )
 
-// MockMatchingEngine is a mock of MatchingEngine interface
+// MockMatchingEngine is a mock of MatchingEngine interface.
 type MockMatchingEngine struct {
 	ctrl     *gomock.Controller
 	recorder *MockMatchingEngineMockRecorder
 }
 
-// MockMatchingEngineMockRecorder is the mock recorder for MockMatchingEngine
+// MockMatchingEngineMockRecorder is the mock recorder for MockMatchingEngine.
 type MockMatchingEngineMockRecorder struct {
 	mock *MockMatchingEngine
 }
 
-// NewMockMatchingEngine creates a new mock instance
+// NewMockMatchingEngine creates a new mock instance.
 func NewMockMatchingEngine(ctrl *gomock.Controller) *MockMatchingEngine {
 	mock := &MockMatchingEngine{ctrl: ctrl}
 	mock.recorder = &MockMatchingEngineMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockMatchingEngine) EXPECT() *MockMatchingEngineMockRecorder {
 	return m.recorder
 }
 
-// AddAskOrder mocks base method
+// AddAskOrder mocks base method.
 func (m *MockMatchingEngine) AddAskOrder(arg0 *models.Ask) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "AddAskOrder", arg0)
 }
 
-// AddAskOrder indicates an expected call of AddAskOrder
+// AddAskOrder indicates an expected call of AddAskOrder.
 func (mr *MockMatchingEngineMockRecorder) AddAskOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAskOrder", reflect.TypeOf((*MockMatchingEngine)(nil).AddAskOrder), arg0)
 }
 
-// AddBidOrder mocks base method
+// AddBidOrder mocks base method.
 func (m *MockMatchingEngine) AddBidOrder(arg0 *models.Bid) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "AddBidOrder", arg0)
 }
 
-// AddBidOrder indicates an expected call of AddBidOrder
+// AddBidOrder indicates an expected call of AddBidOrder.
 func (mr *MockMatchingEngineMockRecorder) AddBidOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBidOrder", reflect.TypeOf((*MockMatchingEngine)(nil).AddBidOrder), arg0)
 }
 
-// CancelAskOrder mocks base method
+// AddFuture mocks base method.
+func (m *MockMatchingEngine) AddFuture(futureId, stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddFuture", futureId, stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddFuture indicates an expected call of AddFuture.
+func (mr *MockMatchingEngineMockRecorder) AddFuture(futureId, stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddFuture", reflect.TypeOf((*MockMatchingEngine)(nil).AddFuture), futureId, stockId)
+}
+
+// AddOption mocks base method.
+func (m *MockMatchingEngine) AddOption(optionId, stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOption", optionId, stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOption indicates an expected call of AddOption.
+func (mr *MockMatchingEngineMockRecorder) AddOption(optionId, stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOption", reflect.TypeOf((*MockMatchingEngine)(nil).AddOption), optionId, stockId)
+}
+
+// AddStock mocks base method.
+func (m *MockMatchingEngine) AddStock(stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddStock", stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddStock indicates an expected call of AddStock.
+func (mr *MockMatchingEngineMockRecorder) AddStock(stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddStock", reflect.TypeOf((*MockMatchingEngine)(nil).AddStock), stockId)
+}
+
+// CancelAskOrder mocks base method.
 func (m *MockMatchingEngine) CancelAskOrder(arg0 *models.Ask) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "CancelAskOrder", arg0)
 }
 
-// CancelAskOrder indicates an expected call of CancelAskOrder
+// CancelAskOrder indicates an expected call of CancelAskOrder.
 func (mr *MockMatchingEngineMockRecorder) CancelAskOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelAskOrder", reflect.TypeOf((*MockMatchingEngine)(nil).CancelAskOrder), arg0)
 }
 
-// CancelBidOrder mocks base method
+// CancelBidOrder mocks base method.
 func (m *MockMatchingEngine) CancelBidOrder(arg0 *models.Bid) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "CancelBidOrder", arg0)
 }
 
-// CancelBidOrder indicates an expected call of CancelBidOrder
+// CancelBidOrder indicates an expected call of CancelBidOrder.
 func (mr *MockMatchingEngineMockRecorder) CancelBidOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelBidOrder", reflect.TypeOf((*MockMatchingEngine)(nil).CancelBidOrder), arg0)
 }
+
+// ExpireOrders mocks base method.
+func (m *MockMatchingEngine) ExpireOrders() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ExpireOrders")
+}
+
+// ExpireOrders indicates an expected call of ExpireOrders.
+func (mr *MockMatchingEngineMockRecorder) ExpireOrders() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireOrders", reflect.TypeOf((*MockMatchingEngine)(nil).ExpireOrders))
+}
+
+// ModifyOrder mocks base method.
+func (m *MockMatchingEngine) ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ModifyOrder", userId, orderId, isAsk, price, stockQuantity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ModifyOrder indicates an expected call of ModifyOrder.
+func (mr *MockMatchingEngineMockRecorder) ModifyOrder(userId, orderId, isAsk, price, stockQuantity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ModifyOrder", reflect.TypeOf((*MockMatchingEngine)(nil).ModifyOrder), userId, orderId, isAsk, price, stockQuantity)
+}
+
+// RemoveStock mocks base method.
+func (m *MockMatchingEngine) RemoveStock(stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveStock", stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveStock indicates an expected call of RemoveStock.
+func (mr *MockMatchingEngineMockRecorder) RemoveStock(stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveStock", reflect.TypeOf((*MockMatchingEngine)(nil).RemoveStock), stockId)
+}
+
+// RestructureStock mocks base method.
+func (m *MockMatchingEngine) RestructureStock(stockId uint32, restructure func() error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestructureStock", stockId, restructure)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestructureStock indicates an expected call of RestructureStock.
+func (mr *MockMatchingEngineMockRecorder) RestructureStock(stockId, restructure interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestructureStock", reflect.TypeOf((*MockMatchingEngine)(nil).RestructureStock), stockId, restructure)
+}
+
+// SettleExpiredFutures mocks base method.
+func (m *MockMatchingEngine) SettleExpiredFutures() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SettleExpiredFutures")
+}
+
+// SettleExpiredFutures indicates an expected call of SettleExpiredFutures.
+func (mr *MockMatchingEngineMockRecorder) SettleExpiredFutures() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SettleExpiredFutures", reflect.TypeOf((*MockMatchingEngine)(nil).SettleExpiredFutures))
+}
+
+// SettleExpiredOptions mocks base method.
+func (m *MockMatchingEngine) SettleExpiredOptions() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SettleExpiredOptions")
+}
+
+// SettleExpiredOptions indicates an expected call of SettleExpiredOptions.
+func (mr *MockMatchingEngineMockRecorder) SettleExpiredOptions() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SettleExpiredOptions", reflect.TypeOf((*MockMatchingEngine)(nil).SettleExpiredOptions))
+}
+
+// StartCallAuction mocks base method.
+func (m *MockMatchingEngine) StartCallAuction() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StartCallAuction")
+}
+
+// StartCallAuction indicates an expected call of StartCallAuction.
+func (mr *MockMatchingEngineMockRecorder) StartCallAuction() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartCallAuction", reflect.TypeOf((*MockMatchingEngine)(nil).StartCallAuction))
+}
+
+// StartMarginRiskChecker mocks base method.
+func (m *MockMatchingEngine) StartMarginRiskChecker(interval time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StartMarginRiskChecker", interval)
+}
+
+// StartMarginRiskChecker indicates an expected call of StartMarginRiskChecker.
+func (mr *MockMatchingEngineMockRecorder) StartMarginRiskChecker(interval interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartMarginRiskChecker", reflect.TypeOf((*MockMatchingEngine)(nil).StartMarginRiskChecker), interval)
+}
+
+// SuspendStock mocks base method.
+func (m *MockMatchingEngine) SuspendStock(stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SuspendStock", stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SuspendStock indicates an expected call of SuspendStock.
+func (mr *MockMatchingEngineMockRecorder) SuspendStock(stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SuspendStock", reflect.TypeOf((*MockMatchingEngine)(nil).SuspendStock), stockId)
+}
+
+// Uncross mocks base method.
+func (m *MockMatchingEngine) Uncross() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Uncross")
+}
+
+// Uncross indicates an expected call of Uncross.
+func (mr *MockMatchingEngineMockRecorder) Uncross() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Uncross", reflect.TypeOf((*MockMatchingEngine)(nil).Uncross))
+}
+
+// UnsuspendStock mocks base method.
+func (m *MockMatchingEngine) UnsuspendStock(stockId uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnsuspendStock", stockId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnsuspendStock indicates an expected call of UnsuspendStock.
+func (mr *MockMatchingEngineMockRecorder) UnsuspendStock(stockId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnsuspendStock", reflect.TypeOf((*MockMatchingEngine)(nil).UnsuspendStock), stockId)
+}
@@ -5,110 +5,218 @@
 package mocks
 
 import (
+	reflect "reflect"
+
 	models "github.com/delta/dalal-street-server/models"
 	gomock "github.com/golang/mock/gomock"
-	reflect "reflect"
 )
 
-// MockOrderBook is a mock of OrderBook interface
+// MockOrderBook is a mock of OrderBook interface.
 type MockOrderBook struct {
 	ctrl     *gomock.Controller
 	recorder *MockOrderBookMockRecorder
 }
 
-// MockOrderBookMockRecorder is the mock recorder for MockOrderBook
+// MockOrderBookMockRecorder is the mock recorder for MockOrderBook.
 type MockOrderBookMockRecorder struct {
 	mock *MockOrderBook
 }
 
-// NewMockOrderBook creates a new mock instance
+// NewMockOrderBook creates a new mock instance.
 func NewMockOrderBook(ctrl *gomock.Controller) *MockOrderBook {
 	mock := &MockOrderBook{ctrl: ctrl}
 	mock.recorder = &MockOrderBookMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockOrderBook) EXPECT() *MockOrderBookMockRecorder {
 	return m.recorder
 }
 
-// LoadOldTransactions mocks base method
-func (m *MockOrderBook) LoadOldTransactions(txs []*models.Transaction) {
-	m.ctrl.Call(m, "LoadOldTransactions", txs)
+// AddAskOrder mocks base method.
+func (m *MockOrderBook) AddAskOrder(arg0 *models.Ask) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AddAskOrder", arg0)
 }
 
-// LoadOldTransactions indicates an expected call of LoadOldTransactions
-func (mr *MockOrderBookMockRecorder) LoadOldTransactions(txs interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadOldTransactions", reflect.TypeOf((*MockOrderBook)(nil).LoadOldTransactions), txs)
+// AddAskOrder indicates an expected call of AddAskOrder.
+func (mr *MockOrderBookMockRecorder) AddAskOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAskOrder", reflect.TypeOf((*MockOrderBook)(nil).AddAskOrder), arg0)
+}
+
+// AddBidOrder mocks base method.
+func (m *MockOrderBook) AddBidOrder(arg0 *models.Bid) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AddBidOrder", arg0)
+}
+
+// AddBidOrder indicates an expected call of AddBidOrder.
+func (mr *MockOrderBookMockRecorder) AddBidOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBidOrder", reflect.TypeOf((*MockOrderBook)(nil).AddBidOrder), arg0)
+}
+
+// CancelAskOrder mocks base method.
+func (m *MockOrderBook) CancelAskOrder(arg0 *models.Ask) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "CancelAskOrder", arg0)
+}
+
+// CancelAskOrder indicates an expected call of CancelAskOrder.
+func (mr *MockOrderBookMockRecorder) CancelAskOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelAskOrder", reflect.TypeOf((*MockOrderBook)(nil).CancelAskOrder), arg0)
 }
 
-// LoadOldAsk mocks base method
+// CancelBidOrder mocks base method.
+func (m *MockOrderBook) CancelBidOrder(arg0 *models.Bid) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "CancelBidOrder", arg0)
+}
+
+// CancelBidOrder indicates an expected call of CancelBidOrder.
+func (mr *MockOrderBookMockRecorder) CancelBidOrder(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelBidOrder", reflect.TypeOf((*MockOrderBook)(nil).CancelBidOrder), arg0)
+}
+
+// LoadOldAsk mocks base method.
 func (m *MockOrderBook) LoadOldAsk(arg0 *models.Ask) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "LoadOldAsk", arg0)
 }
 
-// LoadOldAsk indicates an expected call of LoadOldAsk
+// LoadOldAsk indicates an expected call of LoadOldAsk.
 func (mr *MockOrderBookMockRecorder) LoadOldAsk(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadOldAsk", reflect.TypeOf((*MockOrderBook)(nil).LoadOldAsk), arg0)
 }
 
-// LoadOldBid mocks base method
+// LoadOldBid mocks base method.
 func (m *MockOrderBook) LoadOldBid(arg0 *models.Bid) {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "LoadOldBid", arg0)
 }
 
-// LoadOldBid indicates an expected call of LoadOldBid
+// LoadOldBid indicates an expected call of LoadOldBid.
 func (mr *MockOrderBookMockRecorder) LoadOldBid(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadOldBid", reflect.TypeOf((*MockOrderBook)(nil).LoadOldBid), arg0)
 }
 
-// AddAskOrder mocks base method
-func (m *MockOrderBook) AddAskOrder(arg0 *models.Ask) {
-	m.ctrl.Call(m, "AddAskOrder", arg0)
+// LoadOldTransactions mocks base method.
+func (m *MockOrderBook) LoadOldTransactions(txs []*models.Transaction) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "LoadOldTransactions", txs)
 }
 
-// AddAskOrder indicates an expected call of AddAskOrder
-func (mr *MockOrderBookMockRecorder) AddAskOrder(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAskOrder", reflect.TypeOf((*MockOrderBook)(nil).AddAskOrder), arg0)
+// LoadOldTransactions indicates an expected call of LoadOldTransactions.
+func (mr *MockOrderBookMockRecorder) LoadOldTransactions(txs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadOldTransactions", reflect.TypeOf((*MockOrderBook)(nil).LoadOldTransactions), txs)
 }
 
-// AddBidOrder mocks base method
-func (m *MockOrderBook) AddBidOrder(arg0 *models.Bid) {
-	m.ctrl.Call(m, "AddBidOrder", arg0)
+// ModifyOrder mocks base method.
+func (m *MockOrderBook) ModifyOrder(userId, orderId uint32, isAsk bool, price, stockQuantity uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ModifyOrder", userId, orderId, isAsk, price, stockQuantity)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// AddBidOrder indicates an expected call of AddBidOrder
-func (mr *MockOrderBookMockRecorder) AddBidOrder(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBidOrder", reflect.TypeOf((*MockOrderBook)(nil).AddBidOrder), arg0)
+// ModifyOrder indicates an expected call of ModifyOrder.
+func (mr *MockOrderBookMockRecorder) ModifyOrder(userId, orderId, isAsk, price, stockQuantity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ModifyOrder", reflect.TypeOf((*MockOrderBook)(nil).ModifyOrder), userId, orderId, isAsk, price, stockQuantity)
 }
 
-// CancelAskOrder mocks base method
-func (m *MockOrderBook) CancelAskOrder(arg0 *models.Ask) {
-	m.ctrl.Call(m, "CancelAskOrder", arg0)
+// QueueSizes mocks base method.
+func (m *MockOrderBook) QueueSizes() (int, int, int, int) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueSizes")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(int)
+	ret3, _ := ret[3].(int)
+	return ret0, ret1, ret2, ret3
 }
 
-// CancelAskOrder indicates an expected call of CancelAskOrder
-func (mr *MockOrderBookMockRecorder) CancelAskOrder(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelAskOrder", reflect.TypeOf((*MockOrderBook)(nil).CancelAskOrder), arg0)
+// QueueSizes indicates an expected call of QueueSizes.
+func (mr *MockOrderBookMockRecorder) QueueSizes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueSizes", reflect.TypeOf((*MockOrderBook)(nil).QueueSizes))
 }
 
-// CancelBidOrder mocks base method
-func (m *MockOrderBook) CancelBidOrder(arg0 *models.Bid) {
-	m.ctrl.Call(m, "CancelBidOrder", arg0)
+// StartCallAuction mocks base method.
+func (m *MockOrderBook) StartCallAuction() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StartCallAuction")
 }
 
-// CancelBidOrder indicates an expected call of CancelBidOrder
-func (mr *MockOrderBookMockRecorder) CancelBidOrder(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelBidOrder", reflect.TypeOf((*MockOrderBook)(nil).CancelBidOrder), arg0)
+// StartCallAuction indicates an expected call of StartCallAuction.
+func (mr *MockOrderBookMockRecorder) StartCallAuction() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartCallAuction", reflect.TypeOf((*MockOrderBook)(nil).StartCallAuction))
 }
 
-// StartStockMatching mocks base method
+// StartStockMatching mocks base method.
 func (m *MockOrderBook) StartStockMatching() {
+	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "StartStockMatching")
 }
 
-// StartStockMatching indicates an expected call of StartStockMatching
+// StartStockMatching indicates an expected call of StartStockMatching.
 func (mr *MockOrderBookMockRecorder) StartStockMatching() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartStockMatching", reflect.TypeOf((*MockOrderBook)(nil).StartStockMatching))
 }
+
+// Stop mocks base method.
+func (m *MockOrderBook) Stop() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Stop")
+}
+
+// Stop indicates an expected call of Stop.
+func (mr *MockOrderBookMockRecorder) Stop() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockOrderBook)(nil).Stop))
+}
+
+// Suspend mocks base method.
+func (m *MockOrderBook) Suspend() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Suspend")
+}
+
+// Suspend indicates an expected call of Suspend.
+func (mr *MockOrderBookMockRecorder) Suspend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Suspend", reflect.TypeOf((*MockOrderBook)(nil).Suspend))
+}
+
+// Uncross mocks base method.
+func (m *MockOrderBook) Uncross() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Uncross")
+}
+
+// Uncross indicates an expected call of Uncross.
+func (mr *MockOrderBookMockRecorder) Uncross() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Uncross", reflect.TypeOf((*MockOrderBook)(nil).Uncross))
+}
+
+// Unsuspend mocks base method.
+func (m *MockOrderBook) Unsuspend() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Unsuspend")
+}
+
+// Unsuspend indicates an expected call of Unsuspend.
+func (mr *MockOrderBookMockRecorder) Unsuspend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsuspend", reflect.TypeOf((*MockOrderBook)(nil).Unsuspend))
+}
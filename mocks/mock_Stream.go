@@ -45,6 +45,30 @@ func (mr *MockManagerMockRecorder) GetMarketDepthStream(stockId interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMarketDepthStream", reflect.TypeOf((*MockManager)(nil).GetMarketDepthStream), stockId)
 }
 
+// GetOptionMarketDepthStream mocks base method
+func (m *MockManager) GetOptionMarketDepthStream(optionId uint32) datastreams.MarketDepthStream {
+	ret := m.ctrl.Call(m, "GetOptionMarketDepthStream", optionId)
+	ret0, _ := ret[0].(datastreams.MarketDepthStream)
+	return ret0
+}
+
+// GetOptionMarketDepthStream indicates an expected call of GetOptionMarketDepthStream
+func (mr *MockManagerMockRecorder) GetOptionMarketDepthStream(optionId interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOptionMarketDepthStream", reflect.TypeOf((*MockManager)(nil).GetOptionMarketDepthStream), optionId)
+}
+
+// GetFutureMarketDepthStream mocks base method
+func (m *MockManager) GetFutureMarketDepthStream(futureId uint32) datastreams.MarketDepthStream {
+	ret := m.ctrl.Call(m, "GetFutureMarketDepthStream", futureId)
+	ret0, _ := ret[0].(datastreams.MarketDepthStream)
+	return ret0
+}
+
+// GetFutureMarketDepthStream indicates an expected call of GetFutureMarketDepthStream
+func (mr *MockManagerMockRecorder) GetFutureMarketDepthStream(futureId interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFutureMarketDepthStream", reflect.TypeOf((*MockManager)(nil).GetFutureMarketDepthStream), futureId)
+}
+
 // GetMarketEventsStream mocks base method
 func (m *MockManager) GetMarketEventsStream() datastreams.MarketEventsStream {
 	ret := m.ctrl.Call(m, "GetMarketEventsStream")
@@ -140,3 +164,15 @@ func (m *MockManager) GetGameStateStream() datastreams.GameStateStream {
 func (mr *MockManagerMockRecorder) GetGameStateStream() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGameStateStream", reflect.TypeOf((*MockManager)(nil).GetGameStateStream))
 }
+
+// GetMarketIndexStream mocks base method
+func (m *MockManager) GetMarketIndexStream() datastreams.MarketIndexStream {
+	ret := m.ctrl.Call(m, "GetMarketIndexStream")
+	ret0, _ := ret[0].(datastreams.MarketIndexStream)
+	return ret0
+}
+
+// GetMarketIndexStream indicates an expected call of GetMarketIndexStream
+func (mr *MockManagerMockRecorder) GetMarketIndexStream() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMarketIndexStream", reflect.TypeOf((*MockManager)(nil).GetMarketIndexStream))
+}
@@ -24,6 +24,10 @@ func (ot *OrderType) Scan(value interface{}) error {
 		*ot = StopLoss
 	case "StopLossActive":
 		*ot = StopLossActive
+	case "StopLimit":
+		*ot = StopLimit
+	case "StopLimitActive":
+		*ot = StopLimitActive
 	default:
 		return fmt.Errorf("Invalid value for OrderType. Got %s", string(value.([]byte)))
 	}
@@ -37,6 +41,10 @@ const (
 	Market
 	StopLoss
 	StopLossActive
+	// StopLimit orders wait in the stoploss queue like StopLoss orders till their trigger price is
+	// reached. They then become StopLimitActive orders, which rest in the book at their limit price.
+	StopLimit
+	StopLimitActive
 )
 
 var orderTypes = [...]string{
@@ -44,12 +52,19 @@ var orderTypes = [...]string{
 	"Market",
 	"StopLoss",
 	"StopLossActive",
+	"StopLimit",
+	"StopLimitActive",
 }
 
 func (ot OrderType) String() string {
 	return orderTypes[ot]
 }
 
+// IsStop checks if an order of this type waits in the stoploss queue till it gets triggered
+func (ot OrderType) IsStop() bool {
+	return ot == StopLoss || ot == StopLimit
+}
+
 func OrderTypeFromProto(pOt models_pb.OrderType) OrderType {
 	if pOt == models_pb.OrderType_LIMIT {
 		return Limit
@@ -57,23 +72,118 @@ func OrderTypeFromProto(pOt models_pb.OrderType) OrderType {
 		return Market
 	} else if pOt == models_pb.OrderType_STOPLOSS {
 		return StopLoss
+	} else if pOt == models_pb.OrderType_STOPLIMIT {
+		return StopLimit
 	} else {
 		return StopLossActive
 	}
 }
 
+// TimeInForce decides how long an order stays in the order book
+type TimeInForce uint8
+
+func (tif *TimeInForce) Scan(value interface{}) error {
+	switch string(value.([]byte)) {
+	case "GoodTillCancelled":
+		*tif = GoodTillCancelled
+	case "Day":
+		*tif = Day
+	case "GoodTillDate":
+		*tif = GoodTillDate
+	case "ImmediateOrCancel":
+		*tif = ImmediateOrCancel
+	case "FillOrKill":
+		*tif = FillOrKill
+	default:
+		return fmt.Errorf("Invalid value for TimeInForce. Got %s", string(value.([]byte)))
+	}
+	return nil
+}
+
+func (tif TimeInForce) Value() (driver.Value, error) { return tif.String(), nil }
+
+const (
+	// GoodTillCancelled orders stay in the order book till they are fulfilled or cancelled
+	GoodTillCancelled TimeInForce = iota
+	// Day orders expire when the market closes
+	Day
+	// GoodTillDate orders expire when the market closes on their ExpiresOnDay
+	GoodTillDate
+	// ImmediateOrCancel orders are matched as much as possible when they're placed. The rest expires.
+	ImmediateOrCancel
+	// FillOrKill orders are either fulfilled completely when they're placed, or they expire
+	FillOrKill
+)
+
+var timesInForce = [...]string{
+	"GoodTillCancelled",
+	"Day",
+	"GoodTillDate",
+	"ImmediateOrCancel",
+	"FillOrKill",
+}
+
+func (tif TimeInForce) String() string {
+	return timesInForce[tif]
+}
+
+// IsImmediate checks if an order with this TimeInForce must never rest in the order book
+func (tif TimeInForce) IsImmediate() bool {
+	return tif == ImmediateOrCancel || tif == FillOrKill
+}
+
+func TimeInForceFromProto(pTif models_pb.TimeInForce) TimeInForce {
+	if pTif == models_pb.TimeInForce_DAY {
+		return Day
+	} else if pTif == models_pb.TimeInForce_GOOD_TILL_DATE {
+		return GoodTillDate
+	} else if pTif == models_pb.TimeInForce_IMMEDIATE_OR_CANCEL {
+		return ImmediateOrCancel
+	} else if pTif == models_pb.TimeInForce_FILL_OR_KILL {
+		return FillOrKill
+	} else {
+		return GoodTillCancelled
+	}
+}
+
+func (tif TimeInForce) ToProto() models_pb.TimeInForce {
+	m := make(map[TimeInForce]models_pb.TimeInForce)
+	m[GoodTillCancelled] = models_pb.TimeInForce_GOOD_TILL_CANCELLED
+	m[Day] = models_pb.TimeInForce_DAY
+	m[GoodTillDate] = models_pb.TimeInForce_GOOD_TILL_DATE
+	m[ImmediateOrCancel] = models_pb.TimeInForce_IMMEDIATE_OR_CANCEL
+	m[FillOrKill] = models_pb.TimeInForce_FILL_OR_KILL
+
+	return m[tif]
+}
+
 type Ask struct {
 	sync.Mutex
-	Id                     uint32    `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
-	UserId                 uint32    `gorm:"column:userId;not null" json:"user_id"`
-	StockId                uint32    `gorm:"column:stockId;not null" json:"stock_id"`
-	OrderType              OrderType `gorm:"column:orderType;not null" json:"order_type"`
-	Price                  uint64    `gorm:"not null" json:"price"`
-	StockQuantity          uint64    `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
-	StockQuantityFulfilled uint64    `gorm:"column:stockQuantityFulFilled;not null" json:"stock_quantity_fulfilled"`
-	IsClosed               bool      `gorm:"column:isClosed;not null" json:"is_closed"`
-	CreatedAt              string    `gorm:"column:createdAt;not null" json:"created_at"`
-	UpdatedAt              string    `gorm:"column:updatedAt;not null" json:"updated_at"`
+	Id                     uint32      `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId                 uint32      `gorm:"column:userId;not null" json:"user_id"`
+	StockId                uint32      `gorm:"column:stockId;not null" json:"stock_id"`
+	OrderType              OrderType   `gorm:"column:orderType;not null" json:"order_type"`
+	Price                  uint64      `gorm:"not null" json:"price"`
+	StockQuantity          uint64      `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	StockQuantityFulfilled uint64      `gorm:"column:stockQuantityFulFilled;not null" json:"stock_quantity_fulfilled"`
+	IsClosed               bool        `gorm:"column:isClosed;not null" json:"is_closed"`
+	TimeInForce            TimeInForce `gorm:"column:timeInForce;not null" json:"time_in_force"`
+	ExpiresOnDay           uint32      `gorm:"column:expiresOnDay;not null" json:"expires_on_day"`
+	IsIceberg              bool        `gorm:"column:isIceberg;not null" json:"is_iceberg"`
+	DisplayQuantity        uint64      `gorm:"column:displayQuantity;not null" json:"display_quantity"`
+	TrailingAmount         uint64      `gorm:"column:trailingAmount;not null" json:"trailing_amount"`
+	TrailingPercent        uint64      `gorm:"column:trailingPercent;not null" json:"trailing_percent"`
+	LimitPrice             uint64      `gorm:"column:limitPrice;not null" json:"limit_price"`
+	GroupId                uint32      `gorm:"column:groupId;not null" json:"group_id"`
+	CreatedAt              string      `gorm:"column:createdAt;not null" json:"created_at"`
+	UpdatedAt              string      `gorm:"column:updatedAt;not null" json:"updated_at"`
+	// option the order is for, or 0 for a stock order. StockId is the option's stock for option orders.
+	OptionId uint32 `gorm:"column:optionId;not null" json:"option_id"`
+	// true if the ask writes new contracts of the option, instead of selling ones the user holds
+	IsOptionWrite bool `gorm:"column:isOptionWrite;not null" json:"is_option_write"`
+	// future the order is for, or 0 for a stock order. StockId is the future's stock for future orders,
+	// and 0 for orders of futures on the index.
+	FutureId uint32 `gorm:"column:futureId;not null" json:"future_id"`
 }
 
 func (*Ask) TableName() string {
@@ -85,6 +195,8 @@ func (ask *Ask) ToProto() *models_pb.Ask {
 	m[Limit] = models_pb.OrderType_LIMIT
 	m[Market] = models_pb.OrderType_MARKET
 	m[StopLoss] = models_pb.OrderType_STOPLOSS
+	m[StopLimit] = models_pb.OrderType_STOPLIMIT
+	m[StopLimitActive] = models_pb.OrderType_STOPLIMIT
 
 	pAsk := &models_pb.Ask{
 		Id:                     ask.Id,
@@ -95,14 +207,31 @@ func (ask *Ask) ToProto() *models_pb.Ask {
 		StockQuantity:          ask.StockQuantity,
 		StockQuantityFulfilled: ask.StockQuantityFulfilled,
 		IsClosed:               ask.IsClosed,
+		TimeInForce:            ask.TimeInForce.ToProto(),
+		ExpiresOnDay:           ask.ExpiresOnDay,
+		IsIceberg:              ask.IsIceberg,
+		DisplayQuantity:        ask.DisplayQuantity,
+		TrailingAmount:         ask.TrailingAmount,
+		TrailingPercent:        ask.TrailingPercent,
+		LimitPrice:             ask.LimitPrice,
+		GroupId:                ask.GroupId,
 		CreatedAt:              ask.CreatedAt,
 		UpdatedAt:              ask.UpdatedAt,
+		OptionId:               ask.OptionId,
+		IsOptionWrite:          ask.IsOptionWrite,
+		FutureId:               ask.FutureId,
 	}
 
 	return pAsk
 }
 
-// TriggerStoploss will set OrderType to StopLossActive if the ordertype is StopLoss
+// IsTrailingStop checks if the ask is a stoploss whose trigger price follows the market price
+func (ask *Ask) IsTrailingStop() bool {
+	return ask.OrderType == StopLoss && (ask.TrailingAmount > 0 || ask.TrailingPercent > 0)
+}
+
+// TriggerStoploss will set OrderType to StopLossActive if the ordertype is StopLoss.
+// A StopLimit order becomes StopLimitActive, and its Price is set to its LimitPrice.
 // Error is returned if that wasn't done successfully.
 func (ask *Ask) TriggerStoploss() error {
 	var l = logger.WithFields(logrus.Fields{
@@ -113,9 +242,14 @@ func (ask *Ask) TriggerStoploss() error {
 	l.Debugf("Attempting")
 
 	db := getDB()
-	if ask.OrderType == StopLoss {
+	if ask.OrderType.IsStop() {
 		ask.Lock()
-		ask.OrderType = StopLossActive
+		if ask.OrderType == StopLimit {
+			ask.OrderType = StopLimitActive
+			ask.Price = ask.LimitPrice
+		} else {
+			ask.OrderType = StopLossActive
+		}
 		ask.UpdatedAt = utils.GetCurrentTimeISO8601()
 		ask.Unlock()
 		if err := db.Save(ask).Error; err != nil {
@@ -188,7 +322,7 @@ func createAsk(ask *Ask, tx *gorm.DB) error {
 	ask.CreatedAt = utils.GetCurrentTimeISO8601()
 	ask.UpdatedAt = ask.CreatedAt
 
-	if err := tx.Create(ask).Error; err != nil {
+	if err := saveAsk(ask, tx); err != nil {
 		return err
 	}
 
@@ -202,6 +336,14 @@ func createAsk(ask *Ask, tx *gorm.DB) error {
 	return nil
 }
 
+// saveAsk saves an ask. Orders of futures on the index have no stock, so their stockId is left NULL.
+func saveAsk(ask *Ask, tx *gorm.DB) error {
+	if ask.FutureId != 0 && ask.StockId == 0 {
+		return tx.Omit("stockId").Save(ask).Error
+	}
+	return tx.Save(ask).Error
+}
+
 // AlreadyClosedError is given out when user tries to Cancel an already closed order.
 // Unlikely to happen, but still possible
 type AlreadyClosedError struct{ orderID uint32 }
@@ -228,7 +370,7 @@ func (ask *Ask) Close(tx *gorm.DB) error {
 	ask.UpdatedAt = utils.GetCurrentTimeISO8601()
 	ask.Unlock()
 
-	if err := tx.Save(ask).Error; err != nil {
+	if err := saveAsk(ask, tx); err != nil {
 		l.Error(err)
 		return err
 	}
@@ -242,7 +384,8 @@ func (ask *Ask) Close(tx *gorm.DB) error {
 	return nil
 }
 
-// GetAllOpenAsks returns all open asks. This will be called by MatchingEngine while initializing.
+// GetAllOpenAsks returns all open asks in the order they were placed. This will be called by MatchingEngine
+// while initializing, and the orders keep their time priority as they are loaded in that order.
 func GetAllOpenAsks() ([]*Ask, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "GetAllOpenAsks",
@@ -255,7 +398,7 @@ func GetAllOpenAsks() ([]*Ask, error) {
 	var openAsks []*Ask
 
 	//Load open ask orders from database
-	if err := db.Where("isClosed = ?", 0).Find(&openAsks).Error; err != nil {
+	if err := db.Where("isClosed = ?", 0).Order("id").Find(&openAsks).Error; err != nil {
 		panic("Error loading open ask orders in matching engine: " + err.Error())
 	}
 
@@ -271,6 +414,37 @@ func GetAllOpenAsks() ([]*Ask, error) {
 	return openAsks, nil
 }
 
+// GetOpenAsksForStock returns the open asks of a stock, leaving out the ones for its options and futures. It is
+// called by MatchingEngine to load the order book of a stock again after a corporate action.
+func GetOpenAsksForStock(stockId uint32) ([]*Ask, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOpenAsksForStock",
+		"param_stockId": stockId,
+	})
+
+	l.Infof("Attempting")
+
+	db := getDB()
+
+	var openAsks []*Ask
+
+	if err := db.Where("stockId = ? AND optionId = ? AND futureId = ? AND isClosed = ?", stockId, 0, 0, 0).Order("id").Find(&openAsks).Error; err != nil {
+		l.Errorf("Error loading open ask orders: %+v", err)
+		return nil, err
+	}
+
+	asksMap.Lock()
+	defer asksMap.Unlock()
+
+	for _, ask := range openAsks {
+		asksMap.m[ask.Id] = ask
+	}
+
+	l.Infof("Done")
+
+	return openAsks, nil
+}
+
 func GetMyOpenAsks(userId uint32) ([]*Ask, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "GetMyOpenAsks",
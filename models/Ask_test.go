@@ -199,3 +199,51 @@ func Test_GetMyClosedAsks(t *testing.T) {
 	}
 
 }
+
+func Test_TriggerStoplossStopLimit(t *testing.T) {
+	user := &User{Id: 3}
+	stock := &Stock{Id: 1}
+	ask := &Ask{
+		UserId:        3,
+		StockId:       1,
+		OrderType:     StopLimit,
+		Price:         200,
+		LimitPrice:    190,
+		StockQuantity: 10,
+	}
+
+	db := getDB()
+
+	defer func() {
+		db.Delete(ask)
+		db.Delete(stock)
+		db.Delete(user)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(ask).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ask.TriggerStoploss(); err != nil {
+		t.Fatalf("TriggerStoploss errored: %+v", err)
+	}
+
+	if ask.OrderType != StopLimitActive || ask.Price != 190 {
+		t.Fatalf("Got order type %s and price %d; want StopLimitActive and 190", ask.OrderType, ask.Price)
+	}
+
+	savedAsk := &Ask{}
+	if err := db.First(savedAsk, ask.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if savedAsk.OrderType != StopLimitActive || savedAsk.Price != 190 {
+		t.Fatalf("Saved order type %s and price %d; want StopLimitActive and 190", savedAsk.OrderType, savedAsk.Price)
+	}
+}
@@ -13,16 +13,29 @@ import (
 
 type Bid struct {
 	sync.Mutex
-	Id                     uint32    `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
-	UserId                 uint32    `gorm:"column:userId;not null" json:"user_id"`
-	StockId                uint32    `gorm:"column:stockId;not null" json:"stock_id"`
-	OrderType              OrderType `gorm:"column:orderType;not null" json:"order_type"`
-	Price                  uint64    `gorm:"not null" json:"price"`
-	StockQuantity          uint64    `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
-	StockQuantityFulfilled uint64    `gorm:"column:stockQuantityFulFilled;not null" json:"stock_quantity_fulfilled"`
-	IsClosed               bool      `gorm:"column:isClosed;not null" json:"is_closed"`
-	CreatedAt              string    `gorm:"column:createdAt;not null" json:"created_at"`
-	UpdatedAt              string    `gorm:"column:updatedAt;not null" json:"updated_at"`
+	Id                     uint32      `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId                 uint32      `gorm:"column:userId;not null" json:"user_id"`
+	StockId                uint32      `gorm:"column:stockId;not null" json:"stock_id"`
+	OrderType              OrderType   `gorm:"column:orderType;not null" json:"order_type"`
+	Price                  uint64      `gorm:"not null" json:"price"`
+	StockQuantity          uint64      `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	StockQuantityFulfilled uint64      `gorm:"column:stockQuantityFulFilled;not null" json:"stock_quantity_fulfilled"`
+	IsClosed               bool        `gorm:"column:isClosed;not null" json:"is_closed"`
+	TimeInForce            TimeInForce `gorm:"column:timeInForce;not null" json:"time_in_force"`
+	ExpiresOnDay           uint32      `gorm:"column:expiresOnDay;not null" json:"expires_on_day"`
+	IsIceberg              bool        `gorm:"column:isIceberg;not null" json:"is_iceberg"`
+	DisplayQuantity        uint64      `gorm:"column:displayQuantity;not null" json:"display_quantity"`
+	TrailingAmount         uint64      `gorm:"column:trailingAmount;not null" json:"trailing_amount"`
+	TrailingPercent        uint64      `gorm:"column:trailingPercent;not null" json:"trailing_percent"`
+	LimitPrice             uint64      `gorm:"column:limitPrice;not null" json:"limit_price"`
+	GroupId                uint32      `gorm:"column:groupId;not null" json:"group_id"`
+	CreatedAt              string      `gorm:"column:createdAt;not null" json:"created_at"`
+	UpdatedAt              string      `gorm:"column:updatedAt;not null" json:"updated_at"`
+	// option the order is for, or 0 for a stock order. StockId is the option's stock for option orders.
+	OptionId uint32 `gorm:"column:optionId;not null" json:"option_id"`
+	// future the order is for, or 0 for a stock order. StockId is the future's stock for future orders,
+	// and 0 for orders of futures on the index.
+	FutureId uint32 `gorm:"column:futureId;not null" json:"future_id"`
 }
 
 func (*Bid) TableName() string {
@@ -34,6 +47,8 @@ func (bid *Bid) ToProto() *models_pb.Bid {
 	m[Limit] = models_pb.OrderType_LIMIT
 	m[Market] = models_pb.OrderType_MARKET
 	m[StopLoss] = models_pb.OrderType_STOPLOSS
+	m[StopLimit] = models_pb.OrderType_STOPLIMIT
+	m[StopLimitActive] = models_pb.OrderType_STOPLIMIT
 
 	pBid := &models_pb.Bid{
 		Id:                     bid.Id,
@@ -44,14 +59,30 @@ func (bid *Bid) ToProto() *models_pb.Bid {
 		StockQuantity:          bid.StockQuantity,
 		StockQuantityFulfilled: bid.StockQuantityFulfilled,
 		IsClosed:               bid.IsClosed,
+		TimeInForce:            bid.TimeInForce.ToProto(),
+		ExpiresOnDay:           bid.ExpiresOnDay,
+		IsIceberg:              bid.IsIceberg,
+		DisplayQuantity:        bid.DisplayQuantity,
+		TrailingAmount:         bid.TrailingAmount,
+		TrailingPercent:        bid.TrailingPercent,
+		LimitPrice:             bid.LimitPrice,
+		GroupId:                bid.GroupId,
 		CreatedAt:              bid.CreatedAt,
 		UpdatedAt:              bid.UpdatedAt,
+		OptionId:               bid.OptionId,
+		FutureId:               bid.FutureId,
 	}
 
 	return pBid
 }
 
-// TriggerStoploss will set OrderType to StopLossActive if the ordertype is StopLoss
+// IsTrailingStop checks if the bid is a stoploss whose trigger price follows the market price
+func (bid *Bid) IsTrailingStop() bool {
+	return bid.OrderType == StopLoss && (bid.TrailingAmount > 0 || bid.TrailingPercent > 0)
+}
+
+// TriggerStoploss will set OrderType to StopLossActive if the ordertype is StopLoss.
+// A StopLimit order becomes StopLimitActive, and its Price is set to its LimitPrice.
 // Error is returned if that wasn't done successfully.
 func (bid *Bid) TriggerStoploss() error {
 	var l = logger.WithFields(logrus.Fields{
@@ -62,9 +93,14 @@ func (bid *Bid) TriggerStoploss() error {
 	l.Debugf("Attempting")
 
 	db := getDB()
-	if bid.OrderType == StopLoss {
+	if bid.OrderType.IsStop() {
 		bid.Lock()
-		bid.OrderType = StopLossActive
+		if bid.OrderType == StopLimit {
+			bid.OrderType = StopLimitActive
+			bid.Price = bid.LimitPrice
+		} else {
+			bid.OrderType = StopLossActive
+		}
 		bid.UpdatedAt = utils.GetCurrentTimeISO8601()
 		bid.Unlock()
 		if err := db.Save(bid).Error; err != nil {
@@ -135,7 +171,7 @@ func createBid(bid *Bid, tx *gorm.DB) error {
 	bid.CreatedAt = utils.GetCurrentTimeISO8601()
 	bid.UpdatedAt = bid.CreatedAt
 
-	if err := tx.Create(bid).Error; err != nil {
+	if err := saveBid(bid, tx); err != nil {
 		return err
 	}
 
@@ -148,6 +184,14 @@ func createBid(bid *Bid, tx *gorm.DB) error {
 	return nil
 }
 
+// saveBid saves a bid. Orders of futures on the index have no stock, so their stockId is left NULL.
+func saveBid(bid *Bid, tx *gorm.DB) error {
+	if bid.FutureId != 0 && bid.StockId == 0 {
+		return tx.Omit("stockId").Save(bid).Error
+	}
+	return tx.Save(bid).Error
+}
+
 func (bid *Bid) Close(tx *gorm.DB) error {
 	var l = logger.WithFields(logrus.Fields{
 		"method":    "Bid.Close",
@@ -165,7 +209,7 @@ func (bid *Bid) Close(tx *gorm.DB) error {
 	bid.UpdatedAt = utils.GetCurrentTimeISO8601()
 	bid.Unlock()
 
-	if err := tx.Save(bid).Error; err != nil {
+	if err := saveBid(bid, tx); err != nil {
 		l.Error(err)
 		return err
 	}
@@ -178,7 +222,8 @@ func (bid *Bid) Close(tx *gorm.DB) error {
 	return nil
 }
 
-// GetAllOpenBids returns all open bids. This will be called by MatchingEngine while initializing.
+// GetAllOpenBids returns all open bids in the order they were placed. This will be called by MatchingEngine
+// while initializing, and the orders keep their time priority as they are loaded in that order.
 func GetAllOpenBids() ([]*Bid, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "GetAllOpenBids",
@@ -191,7 +236,7 @@ func GetAllOpenBids() ([]*Bid, error) {
 	var openBids []*Bid
 
 	//Load open bid orders from database
-	if err := db.Where("isClosed = ?", 0).Find(&openBids).Error; err != nil {
+	if err := db.Where("isClosed = ?", 0).Order("id").Find(&openBids).Error; err != nil {
 		panic("Error loading open bid orders in matching engine: " + err.Error())
 	}
 
@@ -207,6 +252,37 @@ func GetAllOpenBids() ([]*Bid, error) {
 	return openBids, nil
 }
 
+// GetOpenBidsForStock returns the open bids of a stock, leaving out the ones for its options and futures. It is
+// called by MatchingEngine to load the order book of a stock again after a corporate action.
+func GetOpenBidsForStock(stockId uint32) ([]*Bid, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOpenBidsForStock",
+		"param_stockId": stockId,
+	})
+
+	l.Infof("Attempting")
+
+	db := getDB()
+
+	var openBids []*Bid
+
+	if err := db.Where("stockId = ? AND optionId = ? AND futureId = ? AND isClosed = ?", stockId, 0, 0, 0).Order("id").Find(&openBids).Error; err != nil {
+		l.Errorf("Error loading open bid orders: %+v", err)
+		return nil, err
+	}
+
+	bidsMap.Lock()
+	defer bidsMap.Unlock()
+
+	for _, bid := range openBids {
+		bidsMap.m[bid.Id] = bid
+	}
+
+	l.Infof("Done")
+
+	return openBids, nil
+}
+
 func GetMyOpenBids(userId uint32) ([]*Bid, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "GetMyOpenBids",
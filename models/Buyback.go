@@ -0,0 +1,451 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// Buyback is a tender offer by a company to buy back StockQuantity of its stocks from its holders at Price,
+// which is above the stock's price. Holders tender their stocks till the market closes on ClosesOnDay. The
+// tendered stocks are then bought back, pro-rata if more were tendered than asked for, and retired.
+type Buyback struct {
+	Id            uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId       uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	Price         uint64 `gorm:"column:price;not null" json:"price"`
+	StockQuantity uint64 `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	ClosesOnDay   uint32 `gorm:"column:closesOnDay;not null" json:"closes_on_day"`
+	IsSettled     bool   `gorm:"column:isSettled;not null" json:"is_settled"`
+	CreatedAt     string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (Buyback) TableName() string {
+	return "Buybacks"
+}
+
+func (b *Buyback) ToProto() *models_pb.Buyback {
+	return &models_pb.Buyback{
+		Id:            b.Id,
+		StockId:       b.StockId,
+		Price:         b.Price,
+		StockQuantity: b.StockQuantity,
+		ClosesOnDay:   b.ClosesOnDay,
+		IsSettled:     b.IsSettled,
+		CreatedAt:     b.CreatedAt,
+	}
+}
+
+// BuybackTender holds the stocks a user tendered in a buyback. They're held in the user's reserved
+// stocks till the buyback is settled.
+type BuybackTender struct {
+	Id            uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	BuybackId     uint32 `gorm:"column:buybackId;not null" json:"buyback_id"`
+	UserId        uint32 `gorm:"column:userId;not null" json:"user_id"`
+	StockQuantity uint64 `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	// stocks bought back from the user. 0 till the buyback is settled.
+	AcceptedQuantity uint64 `gorm:"column:acceptedQuantity;not null" json:"accepted_quantity"`
+}
+
+func (BuybackTender) TableName() string {
+	return "BuybackTenders"
+}
+
+func (bt *BuybackTender) ToProto() *models_pb.BuybackTender {
+	return &models_pb.BuybackTender{
+		BuybackId:        bt.BuybackId,
+		StockQuantity:    bt.StockQuantity,
+		AcceptedQuantity: bt.AcceptedQuantity,
+	}
+}
+
+// InvalidBuybackError is returned if a buyback can't be announced, or tendered to as requested
+type InvalidBuybackError struct{ reason string }
+
+func (e InvalidBuybackError) Error() string {
+	return e.reason
+}
+
+// getProRataAcceptance returns how many of the tendered stocks of a user are bought back, when
+// totalTendered stocks were tendered in all for a buyback of buybackQuantity stocks. Everything is
+// accepted if the buyback isn't oversubscribed. Otherwise fractions are dropped.
+func getProRataAcceptance(tendered, totalTendered, buybackQuantity uint64) uint64 {
+	if totalTendered <= buybackQuantity {
+		return tendered
+	}
+	return tendered * buybackQuantity / totalTendered
+}
+
+// getBuyback returns a buyback from the database
+func getBuyback(db *gorm.DB, buybackId uint32) (*Buyback, error) {
+	buyback := &Buyback{}
+	if err := db.First(buyback, buybackId).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidBuybackError{"Invalid buyback"}
+		}
+		return nil, err
+	}
+	return buyback, nil
+}
+
+// AnnounceBuyback announces a buyback of stockQuantity stocks of a stock at price, closing on closesOnDay
+func AnnounceBuyback(stockId uint32, price, stockQuantity uint64, closesOnDay uint32) (*Buyback, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":              "AnnounceBuyback",
+		"param_stockId":       stockId,
+		"param_price":         price,
+		"param_stockQuantity": stockQuantity,
+		"param_closesOnDay":   closesOnDay,
+	})
+
+	l.Infof("Attempting")
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil || stock.IsDelisted {
+		return nil, InvalidStockIdError{}
+	}
+	if stock.IsBankrupt {
+		return nil, StockBankruptError{}
+	}
+	if price <= stock.CurrentPrice {
+		return nil, InvalidBuybackError{fmt.Sprintf("The price has to be more than the stock's price of %d.", stock.CurrentPrice)}
+	}
+	if stockQuantity == 0 || stockQuantity > stock.StocksInMarket {
+		return nil, InvalidBuybackError{fmt.Sprintf("The buyback has to be of at least 1 and at most %d shares.", stock.StocksInMarket)}
+	}
+	if closesOnDay < GetMarketDay() {
+		return nil, InvalidBuybackError{"The buyback can't close on a market day that's over."}
+	}
+
+	buyback := &Buyback{
+		StockId:       stockId,
+		Price:         price,
+		StockQuantity: stockQuantity,
+		ClosesOnDay:   closesOnDay,
+		CreatedAt:     utils.GetCurrentTimeISO8601(),
+	}
+
+	db := getDB()
+	if err := db.Create(buyback).Error; err != nil {
+		l.Errorf("Error while creating the buyback: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Announced buyback %d", buyback.Id)
+
+	go func() {
+		message := fmt.Sprintf("%s will buy back up to %d of its shares at %d per share. Shares can be tendered till day %d.", stock.FullName, stockQuantity, price, closesOnDay)
+		SendPushNotification(0, PushNotification{
+			Title:   "Message from Dalal Street! A company just announced a buyback.",
+			Message: message,
+			LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+		})
+		SendNotification(0, message, true)
+	}()
+
+	return buyback, nil
+}
+
+// GetOpenBuybacks returns the buybacks that haven't been settled yet
+func GetOpenBuybacks() ([]*Buyback, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetOpenBuybacks",
+	})
+
+	db := getDB()
+
+	var buybacks []*Buyback
+	if err := db.Where("isSettled = ?", false).Order("id").Find(&buybacks).Error; err != nil {
+		l.Errorf("Error while loading buybacks: %+v", err)
+		return nil, err
+	}
+
+	return buybacks, nil
+}
+
+// GetBuybackTenders returns a user's tenders in the buybacks that haven't been settled yet
+func GetBuybackTenders(userId uint32) ([]*BuybackTender, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetBuybackTenders",
+		"param_userId": userId,
+	})
+
+	db := getDB()
+
+	var tenders []*BuybackTender
+	err := db.Joins("JOIN Buybacks ON Buybacks.id = BuybackTenders.buybackId").
+		Where("BuybackTenders.userId = ? AND Buybacks.isSettled = ?", userId, false).
+		Find(&tenders).Error
+	if err != nil {
+		l.Errorf("Error while loading tenders: %+v", err)
+		return nil, err
+	}
+
+	return tenders, nil
+}
+
+// TenderForBuyback tenders stockQuantity stocks of a user in a buyback. The stocks are moved to the user's
+// reserved stocks till the buyback is settled. Only stocks the user owns outright can be tendered.
+func TenderForBuyback(userId, buybackId uint32, stockQuantity uint64) (*Transaction, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":              "TenderForBuyback",
+		"param_userId":        userId,
+		"param_buybackId":     buybackId,
+		"param_stockQuantity": stockQuantity,
+	})
+
+	l.Infof("Attempting")
+
+	if stockQuantity == 0 {
+		return nil, InvalidBuybackError{"You have to tender at least 1 share."}
+	}
+
+	db := getDB()
+
+	buyback, err := getBuyback(db, buybackId)
+	if err != nil {
+		return nil, err
+	}
+	if buyback.IsSettled || buyback.ClosesOnDay < GetMarketDay() {
+		return nil, InvalidBuybackError{"The buyback has closed."}
+	}
+	if IsStockBankrupt(buyback.StockId) {
+		return nil, StockBankruptError{}
+	}
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return nil, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	numStocks, err := getSingleStockCount(user, buyback.StockId)
+	if err != nil {
+		l.Errorf("Error while getting the user's stocks: %+v", err)
+		return nil, err
+	}
+	if numStocks < int64(stockQuantity) {
+		l.Debugf("User has only %d stocks. Failing.", numStocks)
+		if numStocks < 0 {
+			numStocks = 0
+		}
+		return nil, NotEnoughStocksError{numStocks}
+	}
+
+	tender := &BuybackTender{}
+	if err := db.Where("buybackId = ? AND userId = ?", buybackId, userId).FirstOrInit(tender).Error; err != nil {
+		l.Errorf("Error while loading the tender: %+v", err)
+		return nil, err
+	}
+	tender.BuybackId = buybackId
+	tender.UserId = userId
+	tender.StockQuantity += stockQuantity
+
+	transaction := GetTransactionRef(userId, buyback.StockId, BuybackTransaction, int64(stockQuantity), -int64(stockQuantity), buyback.Price, 0, 0)
+
+	tx := db.Begin()
+
+	if err := tx.Save(transaction).Error; err != nil {
+		l.Errorf("Error creating the transaction. Rolling back. Error: %+v", err)
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Save(tender).Error; err != nil {
+		l.Errorf("Error saving the tender. Rolling back. Error: %+v", err)
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error committing the transaction. Failing. %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Tendered %d stocks. %d tendered in all", stockQuantity, tender.StockQuantity)
+
+	go func() {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(transaction.ToProto())
+	}()
+
+	return transaction, nil
+}
+
+// SettleBuybacks settles the buybacks whose last market day is over. It's called when the market closes.
+func SettleBuybacks() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "SettleBuybacks",
+	})
+
+	l.Infof("Attempting")
+
+	buybacks, err := GetOpenBuybacks()
+	if err != nil {
+		l.Errorf("Unable to get the buybacks: %+v", err)
+		return
+	}
+
+	marketDay := GetMarketDay()
+
+	for _, buyback := range buybacks {
+		if buyback.ClosesOnDay > marketDay {
+			continue
+		}
+		if err := settleBuyback(buyback); err != nil {
+			l.Errorf("Unable to settle buyback %d: %+v", buyback.Id, err)
+		}
+	}
+}
+
+// settleBuyback buys back the stocks tendered in a buyback, pro-rata if it's oversubscribed, and returns
+// the rest to their holders. The stocks bought back are retired, so they're taken out of the market.
+func settleBuyback(buyback *Buyback) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":          "settleBuyback",
+		"param_buybackId": buyback.Id,
+	})
+
+	db := getDB()
+
+	var tenders []*BuybackTender
+	if err := db.Where("buybackId = ? AND stockQuantity > 0", buyback.Id).Order("id").Find(&tenders).Error; err != nil {
+		return err
+	}
+
+	var totalTendered uint64
+	for _, tender := range tenders {
+		totalTendered += tender.StockQuantity
+	}
+
+	stockId := buyback.StockId
+	// a bankrupt company doesn't buy back anything. The tendered stocks are returned.
+	isBankrupt := IsStockBankrupt(stockId)
+
+	oldCash := make(map[uint32]uint64)
+	var users []*User
+	var transactions []*Transaction
+	var boughtBack uint64
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		for _, user := range users {
+			user.Cash = oldCash[user.Id]
+		}
+		tx.Rollback()
+		return err
+	}
+
+	for _, tender := range tenders {
+		ch, user, err := getUserExclusively(tender.UserId)
+		if err != nil {
+			return errorHelper("Error acquiring exclusive write on user. Rolling back. Error: %+v", err)
+		}
+		defer close(ch)
+
+		oldCash[user.Id] = user.Cash
+		users = append(users, user)
+
+		if !isBankrupt {
+			tender.AcceptedQuantity = getProRataAcceptance(tender.StockQuantity, totalTendered, buyback.StockQuantity)
+		}
+		accepted := tender.AcceptedQuantity
+		total := accepted * buyback.Price
+
+		transaction := GetTransactionRef(user.Id, stockId, BuybackTransaction, -int64(tender.StockQuantity), int64(tender.StockQuantity-accepted), buyback.Price, 0, int64(total))
+		if err := tx.Save(transaction).Error; err != nil {
+			return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+		}
+		transactions = append(transactions, transaction)
+
+		if accepted > 0 {
+			// selling the stocks back to the company is taxed like any other sale
+			transactionSummary, taxRecord := getTaxForAskingUser(tx, stockId, accepted, buyback.Price, user)
+			if err := tx.Save(transactionSummary).Error; err != nil {
+				return errorHelper("Error updating the transaction summary. Rolling back. Error: %+v", err)
+			}
+			taxTransaction, err := saveTaxRecord(tx, taxRecord, transaction)
+			if err != nil {
+				return errorHelper("Error creating the tax transaction. Rolling back. Error: %+v", err)
+			}
+			if taxTransaction != nil {
+				transactions = append(transactions, taxTransaction)
+			}
+		}
+
+		user.Cash += total
+		if err := tx.Save(user).Error; err != nil {
+			return errorHelper("Error updating user's cash. Rolling back. Error: %+v", err)
+		}
+		if err := tx.Save(tender).Error; err != nil {
+			return errorHelper("Error saving the tender. Rolling back. Error: %+v", err)
+		}
+
+		boughtBack += accepted
+	}
+
+	allStocks.m[stockId].Lock()
+	defer allStocks.m[stockId].Unlock()
+	stock := allStocks.m[stockId].stock
+
+	oldStocksInMarket := stock.StocksInMarket
+	oldUpdatedAt := stock.UpdatedAt
+	if boughtBack > stock.StocksInMarket {
+		stock.StocksInMarket = 0
+	} else {
+		stock.StocksInMarket -= boughtBack
+	}
+	stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	stockErrorHelper := func(format string, err error) error {
+		stock.StocksInMarket = oldStocksInMarket
+		stock.UpdatedAt = oldUpdatedAt
+		return errorHelper(format, err)
+	}
+
+	if err := tx.Save(stock).Error; err != nil {
+		return stockErrorHelper("Error retiring the stocks. Rolling back. Error: %+v", err)
+	}
+
+	buyback.IsSettled = true
+	if err := tx.Save(buyback).Error; err != nil {
+		buyback.IsSettled = false
+		return stockErrorHelper("Error saving the buyback. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		buyback.IsSettled = false
+		return stockErrorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	l.Infof("Bought back %d of %d tendered stocks @ %d per stock", boughtBack, totalTendered, buyback.Price)
+
+	go func(stock Stock) {
+		stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+		stockExchangeStream.SendStockExchangeUpdate(stockId, &datastreams_pb.StockExchangeDataPoint{
+			Price:            stock.CurrentPrice,
+			StocksInExchange: stock.StocksInExchange,
+			StocksInMarket:   stock.StocksInMarket,
+		})
+
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		for _, transaction := range transactions {
+			transactionsStream.SendTransaction(transaction.ToProto())
+		}
+
+		for _, tender := range tenders {
+			SendNotification(tender.UserId, fmt.Sprintf("%d of the %d shares of %s you tendered were bought back at %d per share. The rest have been returned to you.", tender.AcceptedQuantity, tender.StockQuantity, stock.FullName, buyback.Price), false)
+		}
+	}(*stock)
+
+	return nil
+}
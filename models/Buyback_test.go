@@ -0,0 +1,120 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetProRataAcceptance(t *testing.T) {
+	var tests = []struct {
+		tendered        uint64
+		totalTendered   uint64
+		buybackQuantity uint64
+		accepted        uint64
+	}{
+		// undersubscribed, everything is accepted
+		{30, 80, 100, 30},
+		{100, 100, 100, 100},
+		// oversubscribed
+		{50, 200, 100, 25},
+		{3, 7, 5, 2},
+		{1, 1000, 10, 0},
+	}
+
+	for _, test := range tests {
+		if accepted := getProRataAcceptance(test.tendered, test.totalTendered, test.buybackQuantity); accepted != test.accepted {
+			t.Errorf("getProRataAcceptance(%+v) = %d, expected %d", test, accepted, test.accepted)
+		}
+	}
+}
+
+func Test_Buyback(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 1000}}
+	stock := &Stock{Id: 1, CurrentPrice: 100, StocksInExchange: 50, StocksInMarket: 20}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM TaxRecords")
+		db.Exec("DELETE FROM TransactionSummary")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM BuybackTenders")
+		db.Exec("DELETE FROM Buybacks")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stock)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// user 2 holds 10 stocks and user 3 holds 6
+	if err := db.Create(GetTransactionRef(2, stock.Id, FromExchangeTransaction, 0, 10, 100, 0, -1000)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, stock.Id, FromExchangeTransaction, 0, 6, 100, 0, -600)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AnnounceBuyback(stock.Id, 150, 21, GetMarketDay()); err == nil {
+		t.Fatalf("Expected a buyback of more than the stocks in the market to fail")
+	}
+
+	buyback, err := AnnounceBuyback(stock.Id, 150, 8, GetMarketDay())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TenderForBuyback(3, buyback.Id, 7); err == nil {
+		t.Fatalf("Expected tendering more than the stocks held to fail")
+	}
+	for i, tendered := range []uint64{10, 6} {
+		transaction, err := TenderForBuyback(users[i].Id, buyback.Id, tendered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the tendered stocks are reserved till the buyback is settled
+		testutils.AssertEqual(t, int64(tendered), transaction.ReservedStockQuantity)
+		testutils.AssertEqual(t, -int64(tendered), transaction.StockQuantity)
+	}
+
+	// 16 stocks are tendered for 8, so half of each tender is bought back and the rest returned
+	SettleBuybacks()
+
+	type holding struct {
+		StockQuantity         int64
+		ReservedStockQuantity int64
+		Total                 int64
+	}
+	for i, expected := range []holding{{-5, 0, 750}, {-3, 0, 450}} {
+		var h holding
+		sql := "SELECT SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity, SUM(total) AS total FROM Transactions WHERE userId = ? AND type = ?"
+		if err := db.Raw(sql, users[i].Id, BuybackTransaction.String()).Scan(&h).Error; err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, expected, h)
+
+		u := &User{}
+		db.First(u, users[i].Id)
+		testutils.AssertEqual(t, uint64(1000+expected.Total), u.Cash)
+	}
+
+	// the stocks bought back are retired
+	dbStock := &Stock{}
+	db.First(dbStock, stock.Id)
+	testutils.AssertEqual(t, uint64(12), dbStock.StocksInMarket)
+	testutils.AssertEqual(t, uint64(50), dbStock.StocksInExchange)
+
+	if _, err := TenderForBuyback(2, buyback.Id, 1); err == nil {
+		t.Fatalf("Expected tendering to a settled buyback to fail")
+	}
+}
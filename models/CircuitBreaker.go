@@ -0,0 +1,288 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// circuitBreaker tracks the prices a stock trades at, and whether trading in it has been halted
+type circuitBreaker struct {
+	// referencePrice is the price the stock traded at when the current rolling window started
+	referencePrice uint64
+	referenceSetAt time.Time
+	lastTradePrice uint64
+
+	isHalted    bool
+	haltedUntil time.Time
+
+	// dayBandTripped is true once the band around the previous day's close has halted trading in the stock.
+	// Only the rolling band can halt it again till the market opens next.
+	dayBandTripped bool
+
+	// isSuspended is true while trading in the stock is suspended. It lasts till UnsuspendStock is called.
+	isSuspended bool
+}
+
+var circuitBreakers = struct {
+	sync.Mutex
+	m map[uint32]*circuitBreaker
+}{
+	sync.Mutex{},
+	make(map[uint32]*circuitBreaker),
+}
+
+// StockHaltedError is given out when trading in a stock has been halted by its circuit breaker,
+// or suspended. haltedUntil is zero for a suspension.
+type StockHaltedError struct{ haltedUntil time.Time }
+
+func (e StockHaltedError) Error() string {
+	if e.haltedUntil.IsZero() {
+		return "Trading in this stock has been suspended."
+	}
+	return fmt.Sprintf("Trading in this stock has been halted as its price moved too much. It resumes at %s.", e.haltedUntil.Format(time.Kitchen))
+}
+
+// isOutsideBand checks if price has moved away from referencePrice by more than bandPercent percent.
+// A band of 0 percent, or a reference price of 0 never gets breached.
+func isOutsideBand(price, referencePrice, bandPercent uint64) bool {
+	if bandPercent == 0 || referencePrice == 0 {
+		return false
+	}
+
+	var change uint64
+	if price > referencePrice {
+		change = price - referencePrice
+	} else {
+		change = referencePrice - price
+	}
+
+	return change*100 > referencePrice*bandPercent
+}
+
+// CheckCircuitBreaker is called by the matching engine after every trade of a stock. If the trade
+// price is outside the bands around the previous day's close or around the rolling reference price,
+// trading in the stock is halted. true is returned along with the cooling-off period in that case.
+// The matching engine resumes trading once the cooling-off period is over.
+func CheckCircuitBreaker(stockId uint32, price uint64) (bool, time.Duration) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "CheckCircuitBreaker",
+		"param_stockId": stockId,
+		"param_price":   price,
+	})
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil {
+		l.Errorf("Unable to get stock: %+v", err)
+		return false, 0
+	}
+
+	circuitBreakers.Lock()
+	defer circuitBreakers.Unlock()
+
+	now := time.Now()
+
+	cb, ok := circuitBreakers.m[stockId]
+	if !ok {
+		cb = &circuitBreaker{}
+		circuitBreakers.m[stockId] = cb
+	}
+
+	if cb.isHalted || cb.isSuspended {
+		return false, 0
+	}
+
+	// the first trade sets the rolling reference price
+	if cb.referenceSetAt.IsZero() {
+		cb.referencePrice = price
+		cb.referenceSetAt = now
+		cb.lastTradePrice = price
+	}
+
+	// the rolling window starts again from the last trade once it's over
+	rollingWindow := time.Duration(config.CircuitBreakerRollingWindow) * time.Second
+	if now.Sub(cb.referenceSetAt) > rollingWindow {
+		cb.referencePrice = cb.lastTradePrice
+		cb.referenceSetAt = now
+	}
+	cb.lastTradePrice = price
+
+	breachedDayBand := !cb.dayBandTripped && isOutsideBand(price, stock.PreviousDayClose, config.CircuitBreakerDayBandPercent)
+	breachedRollingBand := isOutsideBand(price, cb.referencePrice, config.CircuitBreakerRollingBandPercent)
+	if !breachedDayBand && !breachedRollingBand {
+		return false, 0
+	}
+
+	coolOffPeriod := time.Duration(config.CircuitBreakerCoolOffPeriod) * time.Second
+	cb.isHalted = true
+	if breachedDayBand {
+		cb.dayBandTripped = true
+	}
+	cb.haltedUntil = now.Add(coolOffPeriod)
+
+	l.Infof("Halting trading till %s. Previous day close %d, reference price %d", cb.haltedUntil, stock.PreviousDayClose, cb.referencePrice)
+
+	go sendStockHaltUpdate(stockId, true, cb.haltedUntil)
+
+	return true, coolOffPeriod
+}
+
+// ResumeStock resumes trading in a stock that was halted by its circuit breaker.
+// The rolling window starts again from the stock's current price.
+func ResumeStock(stockId uint32) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "ResumeStock",
+		"param_stockId": stockId,
+	})
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil {
+		l.Errorf("Unable to get stock: %+v", err)
+		return
+	}
+
+	circuitBreakers.Lock()
+	cb, ok := circuitBreakers.m[stockId]
+	if !ok || !cb.isHalted {
+		circuitBreakers.Unlock()
+		return
+	}
+
+	cb.isHalted = false
+	cb.referencePrice = stock.CurrentPrice
+	cb.referenceSetAt = time.Now()
+	cb.lastTradePrice = stock.CurrentPrice
+	isSuspended := cb.isSuspended
+	circuitBreakers.Unlock()
+
+	l.Infof("Resumed trading")
+
+	// a suspended stock stays halted
+	if !isSuspended {
+		go sendStockHaltUpdate(stockId, false, time.Time{})
+	}
+}
+
+// SuspendStock suspends trading in a stock till UnsuspendStock is called. Orders can't be placed
+// or modified meanwhile. Suspensions aren't saved, so they're lifted when the server restarts.
+func SuspendStock(stockId uint32) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SuspendStock",
+		"param_stockId": stockId,
+	})
+
+	circuitBreakers.Lock()
+	cb, ok := circuitBreakers.m[stockId]
+	if !ok {
+		cb = &circuitBreaker{}
+		circuitBreakers.m[stockId] = cb
+	}
+	cb.isSuspended = true
+	circuitBreakers.Unlock()
+
+	l.Infof("Suspended trading")
+
+	go sendStockHaltUpdate(stockId, true, time.Time{})
+}
+
+// UnsuspendStock lifts the suspension of a stock. If its circuit breaker tripped meanwhile and the
+// cooling-off period isn't over, it stays halted till then.
+func UnsuspendStock(stockId uint32) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "UnsuspendStock",
+		"param_stockId": stockId,
+	})
+
+	circuitBreakers.Lock()
+	cb, ok := circuitBreakers.m[stockId]
+	if !ok || !cb.isSuspended {
+		circuitBreakers.Unlock()
+		return
+	}
+
+	cb.isSuspended = false
+	isHalted, haltedUntil := cb.isHalted, cb.haltedUntil
+	// the circuit breaker starts afresh from the next trade, other than the day band
+	if !isHalted {
+		circuitBreakers.m[stockId] = &circuitBreaker{dayBandTripped: cb.dayBandTripped}
+	}
+	circuitBreakers.Unlock()
+
+	l.Infof("Lifted suspension")
+
+	go sendStockHaltUpdate(stockId, isHalted, haltedUntil)
+}
+
+// resetDayBands lets the day bands of all circuit breakers halt trading again. It's called when the
+// market opens, as the day bands are around the previous day's close.
+func resetDayBands() {
+	circuitBreakers.Lock()
+	defer circuitBreakers.Unlock()
+
+	for _, cb := range circuitBreakers.m {
+		cb.dayBandTripped = false
+	}
+}
+
+// rescaleCircuitBreaker scales the prices tracked by the circuit breaker of a stock whose stocks got
+// split newShares:oldShares, so that the split itself doesn't look like a price move
+func rescaleCircuitBreaker(stockId uint32, newShares, oldShares uint64) {
+	circuitBreakers.Lock()
+	defer circuitBreakers.Unlock()
+
+	if cb, ok := circuitBreakers.m[stockId]; ok {
+		cb.referencePrice = scalePrice(cb.referencePrice, newShares, oldShares)
+		cb.lastTradePrice = scalePrice(cb.lastTradePrice, newShares, oldShares)
+	}
+}
+
+// lowerCircuitBreakerPrices lowers the prices tracked by the circuit breaker of a stock whose price was
+// adjusted down by amount, like when it goes ex-dividend, so that the adjustment doesn't look like a price move
+func lowerCircuitBreakerPrices(stockId uint32, amount uint64) {
+	circuitBreakers.Lock()
+	defer circuitBreakers.Unlock()
+
+	if cb, ok := circuitBreakers.m[stockId]; ok {
+		cb.referencePrice = getExDividendPrice(cb.referencePrice, amount)
+		cb.lastTradePrice = getExDividendPrice(cb.lastTradePrice, amount)
+	}
+}
+
+// checkStockHalted returns StockHaltedError if trading in the stock has been halted
+func checkStockHalted(stockId uint32) error {
+	circuitBreakers.Lock()
+	defer circuitBreakers.Unlock()
+
+	if cb, ok := circuitBreakers.m[stockId]; ok && cb.isSuspended {
+		return StockHaltedError{}
+	} else if ok && cb.isHalted {
+		return StockHaltedError{cb.haltedUntil}
+	}
+	return nil
+}
+
+// IsStockHalted checks if trading in a stock has been halted by its circuit breaker, or suspended
+func IsStockHalted(stockId uint32) bool {
+	return checkStockHalted(stockId) != nil
+}
+
+func sendStockHaltUpdate(stockId uint32, isHalted bool, haltedUntil time.Time) {
+	var resumesAt string
+	if isHalted && !haltedUntil.IsZero() {
+		resumesAt = haltedUntil.Format(time.RFC3339)
+	}
+
+	gameStateStream := datastreamsManager.GetGameStateStream()
+	g := &GameState{
+		UserID: 0,
+		Sh: &StockHaltState{
+			StockId:   stockId,
+			IsHalted:  isHalted,
+			ResumesAt: resumesAt,
+		},
+		GsType: StockHaltStateUpdate,
+	}
+	gameStateStream.SendGameStateUpdate(g.ToProto())
+}
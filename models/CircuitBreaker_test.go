@@ -0,0 +1,143 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestIsOutsideBand(t *testing.T) {
+	var tests = []struct {
+		price          uint64
+		referencePrice uint64
+		bandPercent    uint64
+		isOutside      bool
+	}{
+		{110, 100, 10, false},
+		{111, 100, 10, true},
+		{90, 100, 10, false},
+		{89, 100, 10, true},
+		{200, 100, 0, false},
+		{200, 0, 10, false},
+	}
+
+	for _, test := range tests {
+		if isOutside := isOutsideBand(test.price, test.referencePrice, test.bandPercent); isOutside != test.isOutside {
+			t.Errorf("isOutsideBand(%+v) = %t, expected %t", test, isOutside, test.isOutside)
+		}
+	}
+}
+
+func TestCheckCircuitBreaker(t *testing.T) {
+	var stockId uint32 = 1000
+
+	oldDayBand, oldRollingBand := config.CircuitBreakerDayBandPercent, config.CircuitBreakerRollingBandPercent
+	oldStock, hadStock := allStocks.m[stockId]
+	defer func() {
+		config.CircuitBreakerDayBandPercent, config.CircuitBreakerRollingBandPercent = oldDayBand, oldRollingBand
+		allStocks.Lock()
+		if hadStock {
+			allStocks.m[stockId] = oldStock
+		} else {
+			delete(allStocks.m, stockId)
+		}
+		allStocks.Unlock()
+		circuitBreakers.Lock()
+		delete(circuitBreakers.m, stockId)
+		circuitBreakers.Unlock()
+	}()
+
+	config.CircuitBreakerDayBandPercent = 20
+	config.CircuitBreakerRollingBandPercent = 10
+
+	allStocks.Lock()
+	allStocks.m[stockId] = &stockAndLock{stock: &Stock{Id: stockId, CurrentPrice: 100, PreviousDayClose: 100}}
+	allStocks.Unlock()
+
+	// the first trade sets the rolling reference price
+	if halted, _ := CheckCircuitBreaker(stockId, 105); halted {
+		t.Fatalf("Stock got halted within the bands")
+	}
+	if halted, _ := CheckCircuitBreaker(stockId, 115); halted {
+		t.Fatalf("Stock got halted within the bands")
+	}
+	if IsStockHalted(stockId) {
+		t.Fatalf("Stock is halted although it's within the bands")
+	}
+
+	// 117 is within the day band, but 11% above the rolling reference price of 105
+	if halted, _ := CheckCircuitBreaker(stockId, 117); !halted {
+		t.Fatalf("Stock wasn't halted after breaching the rolling band")
+	}
+	if _, ok := checkStockHalted(stockId).(StockHaltedError); !ok {
+		t.Fatalf("Expected StockHaltedError for a halted stock")
+	}
+
+	// trading resumes around the current price
+	allStocks.m[stockId].Lock()
+	allStocks.m[stockId].stock.CurrentPrice = 115
+	allStocks.m[stockId].Unlock()
+
+	ResumeStock(stockId)
+	if IsStockHalted(stockId) {
+		t.Fatalf("Stock is still halted after resuming")
+	}
+
+	// 121 is within the rolling band around 115, but outside the day band
+	if halted, _ := CheckCircuitBreaker(stockId, 121); !halted {
+		t.Fatalf("Stock wasn't halted after breaching the day band")
+	}
+
+	allStocks.m[stockId].Lock()
+	allStocks.m[stockId].stock.CurrentPrice = 121
+	allStocks.m[stockId].Unlock()
+
+	// the day band has tripped already, so trading outside it doesn't halt the stock again the same day
+	ResumeStock(stockId)
+	if halted, _ := CheckCircuitBreaker(stockId, 123); halted {
+		t.Fatalf("Stock got halted by the day band again after resuming")
+	}
+	if IsStockHalted(stockId) {
+		t.Fatalf("Stock is halted although only the day band it tripped already is breached")
+	}
+
+	// the next day, the day band halts it again
+	resetDayBands()
+	if halted, _ := CheckCircuitBreaker(stockId, 124); !halted {
+		t.Fatalf("Stock wasn't halted after breaching the day band on a new day")
+	}
+}
+
+func TestSuspendStock(t *testing.T) {
+	var stockId uint32 = 1001
+
+	defer func() {
+		allStocks.Lock()
+		delete(allStocks.m, stockId)
+		allStocks.Unlock()
+		circuitBreakers.Lock()
+		delete(circuitBreakers.m, stockId)
+		circuitBreakers.Unlock()
+	}()
+
+	allStocks.Lock()
+	allStocks.m[stockId] = &stockAndLock{stock: &Stock{Id: stockId, CurrentPrice: 100, PreviousDayClose: 100}}
+	allStocks.Unlock()
+
+	SuspendStock(stockId)
+	if err, ok := checkStockHalted(stockId).(StockHaltedError); !ok || !err.haltedUntil.IsZero() {
+		t.Fatalf("Expected StockHaltedError without an end for a suspended stock")
+	}
+
+	// a suspended stock doesn't trip its circuit breaker, and stays halted when a halt ends
+	if halted, _ := CheckCircuitBreaker(stockId, 1000); halted {
+		t.Fatalf("Suspended stock got halted by its circuit breaker")
+	}
+	ResumeStock(stockId)
+	if !IsStockHalted(stockId) {
+		t.Fatalf("Suspension was lifted by ResumeStock")
+	}
+
+	UnsuspendStock(stockId)
+	if IsStockHalted(stockId) {
+		t.Fatalf("Stock is still halted after lifting the suspension")
+	}
+}
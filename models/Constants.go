@@ -8,7 +8,6 @@ const MINIMUM_CASH_LIMIT = 0
 const BUY_FROM_EXCHANGE_LIMIT = 20
 const ORDER_PRICE_WINDOW = 20
 const MINIMUM_ORDER_PRICE = 10
-const ORDER_FEE_PERCENT = 3
 const STOCK_AVERAGE_PERCENT = 1
 const MAX_AVERAGE_STOCK_COUNT = 3
 
@@ -0,0 +1,576 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// CorporateActionError is returned when a corporate action can't be carried out on a stock
+type CorporateActionError struct{ reason string }
+
+func (e CorporateActionError) Error() string {
+	return fmt.Sprintf("Corporate action not possible. %s", e.reason)
+}
+
+// scaleQuantity returns the number of stocks that quantity stocks become when every oldShares
+// stocks become newShares stocks. It rounds down.
+func scaleQuantity(quantity, newShares, oldShares uint64) uint64 {
+	return quantity * newShares / oldShares
+}
+
+// scaleDisplayQuantity scales the display quantity of an iceberg order. It is kept at 1 at least.
+func scaleDisplayQuantity(displayQuantity, newShares, oldShares uint64) uint64 {
+	if scaled := scaleQuantity(displayQuantity, newShares, oldShares); scaled > 0 {
+		return scaled
+	}
+	return 1
+}
+
+// scalePrice returns what a price becomes when every oldShares stocks become newShares stocks. It rounds down.
+func scalePrice(price, newShares, oldShares uint64) uint64 {
+	return price * oldShares / newShares
+}
+
+// splitHolding returns the whole number of stocks that a holding becomes when every oldShares stocks
+// become newShares stocks, and the fraction of a stock left over, in 1/oldShares of a stock. Short
+// holdings are rounded down too, so the fraction left over is never negative.
+func splitHolding(holding int64, newShares, oldShares uint64) (int64, int64) {
+	scaled := holding * int64(newShares)
+	newHolding := scaled / int64(oldShares)
+	if scaled%int64(oldShares) < 0 {
+		newHolding--
+	}
+	return newHolding, scaled - newHolding*int64(oldShares)
+}
+
+// getCashInLieu returns the cash paid for fraction/oldShares of a stock worth newPrice
+func getCashInLieu(fraction int64, newPrice, oldShares uint64) uint64 {
+	return uint64(fraction) * newPrice / oldShares
+}
+
+// stockHolding is the stocks of a stock a user holds, along with the ones reserved for their asks
+type stockHolding struct {
+	UserId                uint32
+	StockQuantity         int64
+	ReservedStockQuantity int64
+}
+
+// mortgagedStocks is a row of MortgageDetails
+type mortgagedStocks struct {
+	UserId        uint32
+	StocksInBank  uint64
+	MortgagePrice uint64
+}
+
+// holderIds returns the ids of the users in holdingOf in ascending order. Users are locked in that order,
+// like getUserPairExclusive does, so that actions locking several of them can't deadlock each other.
+func holderIds(holdingOf map[uint32]*stockHolding) []uint32 {
+	userIds := make([]uint32, 0, len(holdingOf))
+	for userId := range holdingOf {
+		userIds = append(userIds, userId)
+	}
+	sort.Slice(userIds, func(i, j int) bool {
+		return userIds[i] < userIds[j]
+	})
+	return userIds
+}
+
+// SplitStock splits every oldShares stocks of a stock into newShares stocks. Prices are scaled
+// the other way, so that holdings keep their worth. A reverse split has newShares below oldShares.
+func SplitStock(stockId uint32, newShares, oldShares uint64) error {
+	return restructureStock(stockId, newShares, oldShares, StockSplitTransaction)
+}
+
+// IssueBonusShares gives bonusShares free stocks of a stock for every heldShares stocks held.
+// It works out to a split of heldShares stocks into heldShares+bonusShares stocks.
+func IssueBonusShares(stockId uint32, bonusShares, heldShares uint64) error {
+	if bonusShares == 0 {
+		return CorporateActionError{"Bonus shares have to be issued for the shares held."}
+	}
+	return restructureStock(stockId, heldShares+bonusShares, heldShares, BonusIssueTransaction)
+}
+
+// checkStockRestructure checks if the stocks of a stock can be split newShares:oldShares
+func checkStockRestructure(stockId uint32, newShares, oldShares uint64) error {
+	if newShares == 0 || oldShares == 0 || newShares == oldShares {
+		return CorporateActionError{"The ratio has to be N:M with N and M positive and different."}
+	}
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil || stock.IsDelisted {
+		return InvalidStockIdError{}
+	}
+	if stock.IsBankrupt {
+		return StockBankruptError{}
+	}
+	if scalePrice(stock.CurrentPrice, newShares, oldShares) == 0 {
+		return CorporateActionError{"The price of the stock would drop to 0."}
+	}
+
+	return checkNoOpenContracts(stockId)
+}
+
+// checkNoOpenContracts checks that nothing is open on a stock that's worked out in stocks of its
+// current size, like options, futures, rights issues, buybacks and dividends
+func checkNoOpenContracts(stockId uint32) error {
+	options, err := GetOpenOptions()
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if option.StockId == stockId {
+			return CorporateActionError{"The stock has options that haven't expired yet."}
+		}
+	}
+
+	// futures on the index get affected too, as the index moves with the price of the stock
+	futures, err := GetOpenFutures()
+	if err != nil {
+		return err
+	}
+	for _, future := range futures {
+		if future.StockId == stockId || future.StockId == 0 {
+			return CorporateActionError{"There are futures that haven't expired yet on the stock or the index."}
+		}
+	}
+
+	// entitlements and tenders are in stocks of the old size
+	rightsIssues, err := GetOpenRightsIssues()
+	if err != nil {
+		return err
+	}
+	for _, rightsIssue := range rightsIssues {
+		if rightsIssue.StockId == stockId {
+			return CorporateActionError{"The stock has a rights issue that hasn't closed yet."}
+		}
+	}
+
+	buybacks, err := GetOpenBuybacks()
+	if err != nil {
+		return err
+	}
+	for _, buyback := range buybacks {
+		if buyback.StockId == stockId {
+			return CorporateActionError{"The stock has a buyback that hasn't been settled yet."}
+		}
+	}
+
+	dividends, err := getPendingDividends()
+	if err != nil {
+		return err
+	}
+	for _, dividend := range dividends {
+		if dividend.StockId == stockId {
+			return CorporateActionError{"The stock has a dividend that hasn't been paid yet."}
+		}
+	}
+
+	return nil
+}
+
+// cancelUnscalableOrders expires the open orders of a stock that can't be carried over the split.
+// Those are the partially filled ones, as the cash reserved for a bid is used up in proportion to
+// its quantity, and the ones whose unfulfilled quantity would become 0. The orders left open are returned.
+func cancelUnscalableOrders(stockId uint32, newShares, oldShares uint64) ([]*Ask, []*Bid, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "cancelUnscalableOrders",
+		"param_stockId": stockId,
+	})
+
+	asks, err := GetOpenAsksForStock(stockId)
+	if err != nil {
+		return nil, nil, err
+	}
+	bids, err := GetOpenBidsForStock(stockId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var openAsks []*Ask
+	for _, ask := range asks {
+		if ask.StockQuantityFulfilled == 0 && scaleQuantity(ask.StockQuantity, newShares, oldShares) > 0 {
+			openAsks = append(openAsks, ask)
+			continue
+		}
+		// the other legs of its group get closed along with it
+		if err := ExpireAskOrder(ask); err != nil {
+			if _, ok := err.(AlreadyClosedError); ok {
+				continue
+			}
+			l.Errorf("Unable to cancel ask %d: %+v", ask.Id, err)
+			return nil, nil, err
+		}
+	}
+
+	var openBids []*Bid
+	for _, bid := range bids {
+		if bid.StockQuantityFulfilled == 0 && scaleQuantity(bid.StockQuantity, newShares, oldShares) > 0 {
+			openBids = append(openBids, bid)
+			continue
+		}
+		if err := ExpireBidOrder(bid); err != nil {
+			if _, ok := err.(AlreadyClosedError); ok {
+				continue
+			}
+			l.Errorf("Unable to cancel bid %d: %+v", bid.Id, err)
+			return nil, nil, err
+		}
+	}
+
+	// legs whose sibling got cancelled are closed already
+	var asksLeft []*Ask
+	for _, ask := range openAsks {
+		if !ask.IsClosed {
+			asksLeft = append(asksLeft, ask)
+		}
+	}
+	var bidsLeft []*Bid
+	for _, bid := range openBids {
+		if !bid.IsClosed {
+			bidsLeft = append(bidsLeft, bid)
+		}
+	}
+
+	return asksLeft, bidsLeft, nil
+}
+
+// scaleOpenOrders scales the prices and quantities of open orders, along with their groups. It returns
+// the stocks that were reserved for the asks of each user, and what they become. The legs of a group
+// share a single reservation.
+func scaleOpenOrders(asks []*Ask, bids []*Bid, newShares, oldShares uint64, tx *gorm.DB) (map[uint32]int64, map[uint32]int64, error) {
+	oldReserved := make(map[uint32]int64)
+	newReserved := make(map[uint32]int64)
+	groupQuantities := make(map[uint32]uint64)
+
+	updatedAt := utils.GetCurrentTimeISO8601()
+
+	for _, ask := range asks {
+		ask.Lock()
+		if ask.GroupId == 0 || groupQuantities[ask.GroupId] == 0 {
+			oldReserved[ask.UserId] += int64(ask.StockQuantity)
+			newReserved[ask.UserId] += int64(scaleQuantity(ask.StockQuantity, newShares, oldShares))
+		}
+		ask.Price = scalePrice(ask.Price, newShares, oldShares)
+		ask.LimitPrice = scalePrice(ask.LimitPrice, newShares, oldShares)
+		ask.TrailingAmount = scalePrice(ask.TrailingAmount, newShares, oldShares)
+		ask.StockQuantity = scaleQuantity(ask.StockQuantity, newShares, oldShares)
+		if ask.IsIceberg {
+			ask.DisplayQuantity = scaleDisplayQuantity(ask.DisplayQuantity, newShares, oldShares)
+		}
+		ask.UpdatedAt = updatedAt
+		if ask.GroupId != 0 {
+			groupQuantities[ask.GroupId] = ask.StockQuantity
+		}
+		ask.Unlock()
+
+		if err := tx.Save(ask).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, bid := range bids {
+		bid.Lock()
+		bid.Price = scalePrice(bid.Price, newShares, oldShares)
+		bid.LimitPrice = scalePrice(bid.LimitPrice, newShares, oldShares)
+		bid.TrailingAmount = scalePrice(bid.TrailingAmount, newShares, oldShares)
+		bid.StockQuantity = scaleQuantity(bid.StockQuantity, newShares, oldShares)
+		if bid.IsIceberg {
+			bid.DisplayQuantity = scaleDisplayQuantity(bid.DisplayQuantity, newShares, oldShares)
+		}
+		bid.UpdatedAt = updatedAt
+		if bid.GroupId != 0 {
+			groupQuantities[bid.GroupId] = bid.StockQuantity
+		}
+		bid.Unlock()
+
+		if err := tx.Save(bid).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for groupId, stockQuantity := range groupQuantities {
+		if err := tx.Model(&OrderGroup{Id: groupId}).Update("stockQuantity", stockQuantity).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return oldReserved, newReserved, nil
+}
+
+// restructureStock splits every oldShares stocks of a stock into newShares stocks. It goes through
+// everything that counts stocks or prices of the stock:
+//  1. Open orders that can't be scaled get cancelled. Prices and quantities of the others are scaled.
+//  2. Holdings of every user are scaled through a transaction of type ttype. Fractions of a stock
+//     left over are paid for in cash, at the price after the split.
+//  3. Stocks in mortgage, short sell lends, the short sell bank and transaction summaries are scaled.
+//  4. The stock history is back-adjusted, so that charts don't jump at the split.
+//  5. The prices and counts of the stock itself are scaled.
+//
+// Trading in the stock must be stopped meanwhile, so it should be called through the matching engine.
+func restructureStock(stockId uint32, newShares, oldShares uint64, ttype TransactionType) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":          "restructureStock",
+		"param_stockId":   stockId,
+		"param_newShares": newShares,
+		"param_oldShares": oldShares,
+		"param_ttype":     ttype.String(),
+	})
+
+	l.Infof("Attempting")
+
+	if err := checkStockRestructure(stockId, newShares, oldShares); err != nil {
+		l.Errorf("Checks failed: %+v", err)
+		return err
+	}
+
+	change := startMarketIndexChange()
+	defer change.end()
+
+	asks, bids, err := cancelUnscalableOrders(stockId, newShares, oldShares)
+	if err != nil {
+		return err
+	}
+
+	db := getDB()
+
+	var holdings []*stockHolding
+	sql := "SELECT userId AS user_id, SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity FROM Transactions WHERE stockId = ? GROUP BY userId HAVING stock_quantity != 0 OR reserved_stock_quantity != 0"
+	if err := db.Raw(sql, stockId).Scan(&holdings).Error; err != nil {
+		l.Errorf("Unable to get the holdings: %+v", err)
+		return err
+	}
+
+	var mortgages []*mortgagedStocks
+	sql = "SELECT userId AS user_id, stocksInBank AS stocks_in_bank, mortgagePrice AS mortgage_price FROM MortgageDetails WHERE stockId = ?"
+	if err := db.Raw(sql, stockId).Scan(&mortgages).Error; err != nil {
+		l.Errorf("Unable to get the mortgages: %+v", err)
+		return err
+	}
+
+	holdingOf := make(map[uint32]*stockHolding)
+	for _, holding := range holdings {
+		holdingOf[holding.UserId] = holding
+	}
+
+	// fractions of a stock left over in the mortgages of each user, and what the mortgages become
+	mortgageFractions := make(map[uint32]int64)
+	newMortgages := make(map[mortgagedStocks]uint64)
+	for _, mortgage := range mortgages {
+		if _, ok := holdingOf[mortgage.UserId]; !ok {
+			holdingOf[mortgage.UserId] = &stockHolding{UserId: mortgage.UserId}
+		}
+		stocksInBank, fraction := splitHolding(int64(mortgage.StocksInBank), newShares, oldShares)
+		mortgageFractions[mortgage.UserId] += fraction
+		if stocksInBank > 0 {
+			key := mortgagedStocks{UserId: mortgage.UserId, MortgagePrice: scalePrice(mortgage.MortgagePrice, newShares, oldShares)}
+			newMortgages[key] += uint64(stocksInBank)
+		}
+	}
+
+	userIds := holderIds(holdingOf)
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil {
+		return err
+	}
+	newPrice := scalePrice(stock.CurrentPrice, newShares, oldShares)
+
+	tx := db.Begin()
+
+	var transactions []*Transaction
+	oldCash := make(map[*User]uint64)
+
+	errorHelper := func(format string, args ...interface{}) error {
+		l.Errorf(format, args...)
+		for user, cash := range oldCash {
+			user.Cash = cash
+		}
+		tx.Rollback()
+		return fmt.Errorf(format, args...)
+	}
+
+	oldReserved, newReserved, err := scaleOpenOrders(asks, bids, newShares, oldShares, tx)
+	if err != nil {
+		return errorHelper("Error scaling the open orders. Rolling back. Error: %+v", err)
+	}
+
+	for _, userId := range userIds {
+		holding := holdingOf[userId]
+
+		l.Debugf("Acquiring exclusive write on user %d", userId)
+		ch, user, err := getUserExclusively(userId)
+		if err != nil {
+			return errorHelper("Error acquiring exclusive write on user %d. Rolling back. Error: %+v", userId, err)
+		}
+		defer func(ch chan struct{}) {
+			close(ch)
+			l.Debugf("Released exclusive write on user")
+		}(ch)
+
+		// stocks reserved outside of the open asks, if any, are scaled as a whole
+		reservedRest, _ := splitHolding(holding.ReservedStockQuantity-oldReserved[userId], newShares, oldShares)
+		reservedStockQuantity := newReserved[userId] + reservedRest
+
+		total, fraction := splitHolding(holding.StockQuantity+holding.ReservedStockQuantity, newShares, oldShares)
+		stockQuantity := total - reservedStockQuantity
+		cashInLieu := getCashInLieu(fraction+mortgageFractions[userId], newPrice, oldShares)
+
+		if stockQuantity == holding.StockQuantity && reservedStockQuantity == holding.ReservedStockQuantity && cashInLieu == 0 {
+			continue
+		}
+
+		transaction := GetTransactionRef(
+			userId,
+			stockId,
+			ttype,
+			reservedStockQuantity-holding.ReservedStockQuantity,
+			stockQuantity-holding.StockQuantity,
+			newPrice,
+			0,
+			int64(cashInLieu),
+		)
+		if err := tx.Save(transaction).Error; err != nil {
+			return errorHelper("Error creating the transaction for user %d. Rolling back. Error: %+v", userId, err)
+		}
+		transactions = append(transactions, transaction)
+
+		if cashInLieu > 0 {
+			oldCash[user] = user.Cash
+			user.Cash += cashInLieu
+			if err := tx.Save(user).Error; err != nil {
+				return errorHelper("Error paying cash in lieu to user %d. Rolling back. Error: %+v", userId, err)
+			}
+		}
+
+		l.Debugf("Scaled holdings of user %d. Stocks: %d, reserved: %d, cash in lieu: %d", userId, stockQuantity, reservedStockQuantity, cashInLieu)
+	}
+
+	if err := tx.Exec("DELETE FROM MortgageDetails WHERE stockId = ?", stockId).Error; err != nil {
+		return errorHelper("Error clearing the mortgages. Rolling back. Error: %+v", err)
+	}
+	for mortgage, stocksInBank := range newMortgages {
+		sql := "INSERT into MortgageDetails (userId, stockId, stocksInBank, mortgagePrice) VALUES (?, ?, ?, ?)"
+		if err := tx.Exec(sql, mortgage.UserId, stockId, stocksInBank, mortgage.MortgagePrice).Error; err != nil {
+			return errorHelper("Error scaling the mortgages. Rolling back. Error: %+v", err)
+		}
+	}
+
+	// a lend is a debt of stocks, so it's rounded up
+	sql = "UPDATE ShortSellLends SET stockQuantity = CEIL(stockQuantity * ? / ?) WHERE stockId = ? AND isSquaredOff = 0"
+	if err := tx.Exec(sql, newShares, oldShares, stockId).Error; err != nil {
+		return errorHelper("Error scaling the short sell lends. Rolling back. Error: %+v", err)
+	}
+
+	sql = "UPDATE ShortSellBank SET availableStocks = FLOOR(availableStocks * ? / ?) WHERE stockId = ?"
+	if err := tx.Exec(sql, newShares, oldShares, stockId).Error; err != nil {
+		return errorHelper("Error scaling the short sell bank. Rolling back. Error: %+v", err)
+	}
+
+	// the average price stocks were bought at is scaled too, so that taxes on gains stay the same
+	sql = "UPDATE TransactionSummary SET stockQuantity = FLOOR(stockQuantity * ? / ?), price = price * ? / ? WHERE stockId = ?"
+	if err := tx.Exec(sql, newShares, oldShares, oldShares, newShares, stockId).Error; err != nil {
+		return errorHelper("Error scaling the transaction summaries. Rolling back. Error: %+v", err)
+	}
+
+	sql = "UPDATE StockHistory SET open = FLOOR(open * ? / ?), high = FLOOR(high * ? / ?), low = FLOOR(low * ? / ?), close = FLOOR(close * ? / ?), volume = FLOOR(volume * ? / ?) WHERE stockId = ?"
+	if err := tx.Exec(sql,
+		oldShares, newShares,
+		oldShares, newShares,
+		oldShares, newShares,
+		oldShares, newShares,
+		newShares, oldShares,
+		stockId).Error; err != nil {
+		return errorHelper("Error back-adjusting the stock history. Rolling back. Error: %+v", err)
+	}
+
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[stockId]
+	allStocks.Unlock()
+	if !ok {
+		return errorHelper("Not found stock for id %d", stockId)
+	}
+
+	stockNLock.Lock()
+	defer stockNLock.Unlock()
+
+	s := stockNLock.stock
+	oldStockCopy := *s
+
+	s.CurrentPrice = newPrice
+	s.PreviousDayClose = scalePrice(s.PreviousDayClose, newShares, oldShares)
+	s.DayHigh = scalePrice(s.DayHigh, newShares, oldShares)
+	s.DayLow = scalePrice(s.DayLow, newShares, oldShares)
+	s.AllTimeHigh = scalePrice(s.AllTimeHigh, newShares, oldShares)
+	s.AllTimeLow = scalePrice(s.AllTimeLow, newShares, oldShares)
+	s.LastTradePrice = scalePrice(s.LastTradePrice, newShares, oldShares)
+	s.RealAvgPrice = s.RealAvgPrice * float64(oldShares) / float64(newShares)
+	s.StocksInExchange = scaleQuantity(s.StocksInExchange, newShares, oldShares)
+	s.StocksInMarket = scaleQuantity(s.StocksInMarket, newShares, oldShares)
+	s.open = scalePrice(s.open, newShares, oldShares)
+	s.high = scalePrice(s.high, newShares, oldShares)
+	s.low = scalePrice(s.low, newShares, oldShares)
+	s.volume = scaleQuantity(s.volume, newShares, oldShares)
+	s.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	if err := tx.Save(s).Error; err != nil {
+		*s = oldStockCopy
+		return errorHelper("Error saving the stock. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		*s = oldStockCopy
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	change.changed(stockId, &oldStockCopy)
+
+	avgLastPrice.Lock()
+	avgLastPrice.m[stockId] = avgLastPrice.m[stockId] * float64(oldShares) / float64(newShares)
+	avgLastPrice.Unlock()
+
+	rescaleCircuitBreaker(stockId, newShares, oldShares)
+
+	l.Infof("Committed. %d holders, %d asks and %d bids scaled", len(userIds), len(asks), len(bids))
+
+	go sendStockRestructureUpdates(*s, transactions, newShares, oldShares, ttype)
+
+	return nil
+}
+
+// sendStockRestructureUpdates sends the stock and the transactions of a split or bonus issue through
+// the datastreams, and lets everybody know about it
+func sendStockRestructureUpdates(stock Stock, transactions []*Transaction, newShares, oldShares uint64, ttype TransactionType) {
+	stockPriceStream := datastreamsManager.GetStockPricesStream()
+	stockPriceStream.SendStockPriceUpdate(stock.Id, stock.CurrentPrice)
+
+	stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+	stockExchangeStream.SendStockExchangeUpdate(stock.Id, &datastreams_pb.StockExchangeDataPoint{
+		Price:            stock.CurrentPrice,
+		StocksInExchange: stock.StocksInExchange,
+		StocksInMarket:   stock.StocksInMarket,
+	})
+
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+	for _, transaction := range transactions {
+		transactionsStream.SendTransaction(transaction.ToProto())
+	}
+
+	var message string
+	if ttype == BonusIssueTransaction {
+		message = fmt.Sprintf("%s has issued %d bonus shares for every %d shares held.", stock.FullName, newShares-oldShares, oldShares)
+	} else {
+		message = fmt.Sprintf("%s has split every %d of its shares into %d. Its price is now %d.", stock.FullName, oldShares, newShares, stock.CurrentPrice)
+	}
+
+	SendPushNotification(0, PushNotification{
+		Title:   "Message from Dalal Street! A company just restructured its shares.",
+		Message: message,
+		LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+	})
+	SendNotification(0, message, true)
+}
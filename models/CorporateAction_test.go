@@ -0,0 +1,210 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestScaleQuantityAndPrice(t *testing.T) {
+	var tests = []struct {
+		newShares       uint64
+		oldShares       uint64
+		value           uint64
+		quantity        uint64
+		price           uint64
+		displayQuantity uint64
+	}{
+		{2, 1, 7, 14, 3, 14},
+		{1, 2, 7, 3, 14, 3},
+		{3, 2, 10, 15, 6, 15},
+		{1, 10, 5, 0, 50, 1},
+		{2, 1, 1, 2, 0, 2},
+	}
+
+	for _, test := range tests {
+		if quantity := scaleQuantity(test.value, test.newShares, test.oldShares); quantity != test.quantity {
+			t.Errorf("scaleQuantity(%+v) = %d, expected %d", test, quantity, test.quantity)
+		}
+		if price := scalePrice(test.value, test.newShares, test.oldShares); price != test.price {
+			t.Errorf("scalePrice(%+v) = %d, expected %d", test, price, test.price)
+		}
+		if displayQuantity := scaleDisplayQuantity(test.value, test.newShares, test.oldShares); displayQuantity != test.displayQuantity {
+			t.Errorf("scaleDisplayQuantity(%+v) = %d, expected %d", test, displayQuantity, test.displayQuantity)
+		}
+	}
+}
+
+func TestSplitHolding(t *testing.T) {
+	var tests = []struct {
+		holding    int64
+		newShares  uint64
+		oldShares  uint64
+		newHolding int64
+		fraction   int64
+	}{
+		{7, 2, 1, 14, 0},
+		{7, 1, 2, 3, 1},
+		{5, 3, 2, 7, 1},
+		{0, 3, 2, 0, 0},
+		// short holdings are rounded down too
+		{-7, 1, 2, -4, 1},
+		{-4, 3, 2, -6, 0},
+	}
+
+	for _, test := range tests {
+		newHolding, fraction := splitHolding(test.holding, test.newShares, test.oldShares)
+		if newHolding != test.newHolding || fraction != test.fraction {
+			t.Errorf("splitHolding(%+v) = (%d, %d), expected (%d, %d)", test, newHolding, fraction, test.newHolding, test.fraction)
+		}
+	}
+}
+
+func TestGetCashInLieu(t *testing.T) {
+	var tests = []struct {
+		fraction   int64
+		newPrice   uint64
+		oldShares  uint64
+		cashInLieu uint64
+	}{
+		{1, 150, 2, 75},
+		{2, 100, 3, 66},
+		{0, 100, 3, 0},
+	}
+
+	for _, test := range tests {
+		if cashInLieu := getCashInLieu(test.fraction, test.newPrice, test.oldShares); cashInLieu != test.cashInLieu {
+			t.Errorf("getCashInLieu(%+v) = %d, expected %d", test, cashInLieu, test.cashInLieu)
+		}
+	}
+}
+
+func TestHolderIds(t *testing.T) {
+	holdingOf := map[uint32]*stockHolding{
+		7:  {UserId: 7},
+		2:  {UserId: 2},
+		13: {UserId: 13},
+		5:  {UserId: 5},
+	}
+
+	userIds := holderIds(holdingOf)
+	if !reflect.DeepEqual(userIds, []uint32{2, 5, 7, 13}) {
+		t.Errorf("holderIds(%+v) = %v, expected [2 5 7 13]", holdingOf, userIds)
+	}
+}
+
+func Test_SplitStock(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 1000}}
+	stock := &Stock{Id: 1, CurrentPrice: 300, StocksInExchange: 100, StocksInMarket: 30}
+	ssb := &ShortSellBank{StockId: 1, AvailableStocks: 5}
+	lend := &ShortSellLends{UserId: 3, StockId: 1, StockQuantity: 5}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM ShortSellLends")
+		db.Exec("DELETE FROM ShortSellBank")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stock)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+	if err := db.Create(ssb).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(lend).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// user 2 holds 5 stocks and user 3 holds 4
+	if err := db.Create(GetTransactionRef(2, stock.Id, FromExchangeTransaction, 0, 5, 300, 0, -1500)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, stock.Id, FromExchangeTransaction, 0, 4, 300, 0, -1200)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	getTransactions := func(ttype TransactionType) []*Transaction {
+		var transactions []*Transaction
+		if err := db.Where("type = ?", ttype.String()).Order("userId").Find(&transactions).Error; err != nil {
+			t.Fatal(err)
+		}
+		return transactions
+	}
+	checkHoldings := func(ttype TransactionType, stockQuantities []int64, price uint64, totals []int64) {
+		transactions := getTransactions(ttype)
+		if len(transactions) != len(stockQuantities) {
+			t.Fatalf("Expected %d %s transactions, got %+v", len(stockQuantities), ttype.String(), transactions)
+		}
+		for i, transaction := range transactions {
+			testutils.AssertEqual(t, users[i].Id, transaction.UserId)
+			testutils.AssertEqual(t, stockQuantities[i], transaction.StockQuantity)
+			testutils.AssertEqual(t, price, transaction.Price)
+			testutils.AssertEqual(t, totals[i], transaction.Total)
+		}
+	}
+	checkStock := func(price, stocksInExchange, stocksInMarket uint64, availableStocks, lendQuantity uint32) {
+		s, err := GetStockCopy(stock.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, price, s.CurrentPrice)
+		testutils.AssertEqual(t, stocksInExchange, s.StocksInExchange)
+		testutils.AssertEqual(t, stocksInMarket, s.StocksInMarket)
+
+		dbStock := &Stock{}
+		db.First(dbStock, stock.Id)
+		testutils.AssertEqual(t, price, dbStock.CurrentPrice)
+		testutils.AssertEqual(t, stocksInExchange, dbStock.StocksInExchange)
+		testutils.AssertEqual(t, stocksInMarket, dbStock.StocksInMarket)
+
+		b := &ShortSellBank{}
+		db.First(b, stock.Id)
+		testutils.AssertEqual(t, availableStocks, b.AvailableStocks)
+
+		l := &ShortSellLends{}
+		db.First(l, lend.Id)
+		testutils.AssertEqual(t, lendQuantity, l.StockQuantity)
+	}
+	checkCash := func(cash []uint64) {
+		for i, user := range users {
+			u := &User{}
+			db.First(u, user.Id)
+			testutils.AssertEqual(t, cash[i], u.Cash)
+		}
+	}
+
+	// 3:2. User 2's 5 stocks become 7.5, and the half a stock is paid for at the new price of 200
+	if err := SplitStock(stock.Id, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkHoldings(StockSplitTransaction, []int64{2, 2}, 200, []int64{100, 0})
+	checkCash([]uint64{1100, 1000})
+	// the lend of 5 stocks is rounded up to 8, and the 7.5 stocks in the bank down to 7
+	checkStock(200, 150, 45, 7, 8)
+
+	// 1 bonus stock for every stock held
+	if err := IssueBonusShares(stock.Id, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkHoldings(BonusIssueTransaction, []int64{7, 6}, 100, []int64{0, 0})
+	checkCash([]uint64{1100, 1000})
+	checkStock(100, 300, 90, 14, 16)
+
+	// the price can't be split down to 0
+	if err := SplitStock(stock.Id, 101, 1); err == nil {
+		t.Fatalf("Expected the split to fail")
+	}
+}
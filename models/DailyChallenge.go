@@ -25,6 +25,8 @@ type DailyChallenge struct {
 	Value         uint64 `gorm:"column:value;not null" json:"value"`
 	StockId       uint32 `gorm:"column:stockId;default null" json:"stock_id"`
 	Reward        uint32 `gorm:"column:reward; not null" json:"reward"`
+	// value of the market index when the challenge opened. Only MarketIndex challenges note it down
+	IndexValue uint64 `gorm:"column:indexValue;not null" json:"index_value"`
 }
 
 //UserState model
@@ -58,6 +60,7 @@ type updateUserStateQueryData struct {
 	ChallengeType string
 	Value         uint64
 	StockId       uint32
+	IndexValue    uint64
 }
 
 type getMyRewardQueryData struct {
@@ -86,6 +89,7 @@ func (d *DailyChallenge) ToProto() *models_pb.DailyChallenge {
 		Value:         d.Value,
 		StockId:       d.StockId,
 		Reward:        d.Reward,
+		IndexValue:    d.IndexValue,
 	}
 	return pDailyChallenge
 }
@@ -301,7 +305,7 @@ func updateUserState(marketday uint32) error {
 
 	var queryResults []updateUserStateQueryData
 
-	query := fmt.Sprintf(`SELECT U.id AS id,U.userId AS user_id, U.challengeId AS challenge_id,U.initialValue AS initial_value,D.challengeType AS challenge_type,D.value AS value,D.stockId AS stock_id
+	query := fmt.Sprintf(`SELECT U.id AS id,U.userId AS user_id, U.challengeId AS challenge_id,U.initialValue AS initial_value,D.challengeType AS challenge_type,D.value AS value,D.stockId AS stock_id,D.indexValue AS index_value
 	 FROM
 	UserState U INNER JOIN DailyChallenge D ON U.challengeId = D.id WHERE D.marketDay = %d;`, marketday)
 
@@ -373,6 +377,37 @@ func updateUserState(marketday uint32) error {
 
 			l.Debugf("updated userstate challenge type networth")
 
+		case "MarketIndex":
+			userStateEntry := &UserState{
+				Id: q.Id,
+			}
+
+			ch, user, err := getUserExclusively(q.UserId)
+			if err != nil {
+				l.Errorf("Errored : %+v ", err)
+				return err
+			}
+			l.Debugf("Acquired")
+
+			// net worth has to grow by Value percent more than the market index did
+			if beatsMarketIndex(q.InitialValue, int64(user.Total), q.IndexValue, GetMarketIndexValue(), q.Value) {
+				userStateEntry.IsCompleted = true
+			}
+
+			userStateEntry.FinalValue = int64(user.Total)
+
+			if err := tx.Table("UserState").Select("FinalValue", "IsCompleted").Save(userStateEntry).Error; err != nil {
+				l.Errorf("failed saving userState market index Challenge type %+e", err)
+				tx.Rollback()
+				close(ch)
+				return err
+			}
+
+			close(ch)
+			l.Debugf("Released exclusive write on user")
+
+			l.Debugf("updated userstate challenge type market index")
+
 		case "SpecificStock":
 			userStateEntry := &UserState{
 				Id: q.Id,
@@ -487,6 +522,17 @@ func saveUsersState(c []*DailyChallenge, marketday uint32) error {
 		return err
 	}
 
+	// margin loans are part of the cash, so what's owed on them is taken off the net worth
+	marginDebts, err := getMarginDebts(tx)
+	if err != nil {
+		l.Errorf("error, fetching margin debts %+e", err)
+		tx.Rollback()
+		return err
+	}
+	for i := range queryResults {
+		queryResults[i].Total -= int64(marginDebts[queryResults[i].UserId])
+	}
+
 	for _, challenge := range c {
 
 		switch challenge.ChallengeType {
@@ -529,6 +575,31 @@ func saveUsersState(c []*DailyChallenge, marketday uint32) error {
 				}
 			}
 
+		case "MarketIndex":
+			var userStateEntry *UserState
+
+			if err := tx.Table("DailyChallenge").Where("id = ?", challenge.Id).Update("indexValue", GetMarketIndexValue()).Error; err != nil {
+				l.Errorf("failed saving market index value of challenge %+e", err)
+				tx.Rollback()
+				return err
+			}
+
+			for _, u := range queryResults {
+				userStateEntry = &UserState{
+					ChallengeId:     challenge.Id,
+					UserId:          u.UserId,
+					InitialValue:    u.Total,
+					IsCompleted:     false,
+					IsRewardClamied: false,
+				}
+
+				if err := tx.Table("UserState").Omit("FinalValue").Save(userStateEntry).Error; err != nil {
+					l.Errorf("failed saving userState MarketIndex Challenge type %+e", err)
+					tx.Rollback()
+					return err
+				}
+			}
+
 		case "StockWorth":
 			var userStateEntry *UserState
 
@@ -774,7 +845,7 @@ func saveNewUserState(userId uint32) error {
 
 	for _, c := range totalChallenges {
 
-		if c.ChallengeType == "Cash" || c.ChallengeType == "NetWorth" {
+		if c.ChallengeType == "Cash" || c.ChallengeType == "NetWorth" || c.ChallengeType == "MarketIndex" {
 			userStateEntry := &UserState{
 				ChallengeId:  c.Id,
 				UserId:       userId,
@@ -82,6 +82,7 @@ type dailyLeaderboardQueryData struct {
 	UserId     uint32
 	UserName   string
 	Cash       int64
+	Debt       uint64
 	StockWorth int64
 	Total      int64
 	IsBlocked  bool
@@ -104,6 +105,7 @@ func UpdateDailyLeaderboard() {
 	query := fmt.Sprintf(`
 	SELECT L.userId as user_id, L.userName as user_name, L.isBlocked as is_blocked,
 	       ifnull(L.cash,0) - ifnull(E.cash,%d) as cash,
+	       ifnull(L.debt,0) as debt,
 	       ifnull(L.stockWorth,0) - ifnull(E.stockWorth,0) as stock_worth,
 	       ifnull(L.totalWorth,0) - ifnull(E.totalWorth,%d) as total
 	FROM Leaderboard L
@@ -123,7 +125,7 @@ func UpdateDailyLeaderboard() {
 			UserName:   result.UserName,
 			Cash:       result.Cash,
 			Rank:       uint32(rank),
-			Debt:       0,
+			Debt:       result.Debt,
 			StockWorth: result.StockWorth,
 			TotalWorth: result.Total,
 			IsBlocked:  result.IsBlocked,
@@ -26,4 +26,5 @@ func Init(conf *utils.Config, dsm datastreams.Manager) {
 
 	ConfigDataInit()
 	LoadStocks()
+	loadTaxBrackets()
 }
@@ -3,6 +3,8 @@ package models
 import (
 	"fmt"
 
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -110,6 +112,27 @@ func PerformDividendsTransaction(stockID uint32, dividendAmount uint64) error {
 		l.Infof("Updated user's cash. New balance: %d", currentUser.Cash)
 	}
 
+	// dividends sent right away go in the dividend history too
+	marketDay := GetMarketDay()
+	dividend := &Dividend{
+		StockId:        stockID,
+		DividendAmount: dividendAmount,
+		AnnouncedOnDay: marketDay,
+		RecordDay:      marketDay,
+		PaymentDay:     marketDay,
+		IsRecorded:     true,
+		IsExAdjusted:   true,
+		IsPaid:         true,
+		CreatedAt:      utils.GetCurrentTimeISO8601(),
+	}
+	if err := tx.Create(dividend).Error; err != nil {
+		errRevert := RevertToOldState(dividendsMap)
+		if errRevert != nil {
+			return errRevert
+		}
+		return errorHelper("Error recording the dividend. Rolling back. Error: %+v", err)
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		errRevert := RevertToOldState(dividendsMap)
 		if errRevert != nil {
@@ -199,3 +222,445 @@ func GetmaxStockID() (uint32, error) {
 		return maxStockID, nil
 	}
 }
+
+// Dividend is a dividend of DividendAmount per stock, announced by a company ahead of time. Holders of the
+// stock when the market closes on RecordDay get it. The stock goes ex-dividend when the market opens after
+// that, as buyers don't get the dividend anymore, and its price drops by the dividend. It's paid out when
+// the market opens on PaymentDay.
+type Dividend struct {
+	Id             uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId        uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	DividendAmount uint64 `gorm:"column:dividendAmount;not null" json:"dividend_amount"`
+	AnnouncedOnDay uint32 `gorm:"column:announcedOnDay;not null" json:"announced_on_day"`
+	RecordDay      uint32 `gorm:"column:recordDay;not null" json:"record_day"`
+	PaymentDay     uint32 `gorm:"column:paymentDay;not null" json:"payment_day"`
+	// IsRecorded is true once the holders on the record day have been noted down
+	IsRecorded bool `gorm:"column:isRecorded;not null" json:"is_recorded"`
+	// IsExAdjusted is true once the price of the stock has been adjusted for the dividend
+	IsExAdjusted bool   `gorm:"column:isExAdjusted;not null" json:"is_ex_adjusted"`
+	IsPaid       bool   `gorm:"column:isPaid;not null" json:"is_paid"`
+	CreatedAt    string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (Dividend) TableName() string {
+	return "Dividends"
+}
+
+func (d *Dividend) ToProto() *models_pb.Dividend {
+	return &models_pb.Dividend{
+		Id:             d.Id,
+		StockId:        d.StockId,
+		DividendAmount: d.DividendAmount,
+		AnnouncedOnDay: d.AnnouncedOnDay,
+		RecordDay:      d.RecordDay,
+		PaymentDay:     d.PaymentDay,
+		IsRecorded:     d.IsRecorded,
+		IsPaid:         d.IsPaid,
+		CreatedAt:      d.CreatedAt,
+	}
+}
+
+// DividendHolder is the stocks a user held when the market closed on the record day of a dividend
+type DividendHolder struct {
+	Id            uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	DividendId    uint32 `gorm:"column:dividendId;not null" json:"dividend_id"`
+	UserId        uint32 `gorm:"column:userId;not null" json:"user_id"`
+	StockQuantity uint64 `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+}
+
+func (DividendHolder) TableName() string {
+	return "DividendHolders"
+}
+
+// InvalidDividendError is returned if a dividend can't be announced as requested
+type InvalidDividendError struct{ reason string }
+
+func (e InvalidDividendError) Error() string {
+	return e.reason
+}
+
+// getExDividendPrice returns what a price becomes once the stock goes ex-dividend. It's left as it is
+// if the dividend would take it to 0.
+func getExDividendPrice(price, dividendAmount uint64) uint64 {
+	if price <= dividendAmount {
+		return price
+	}
+	return price - dividendAmount
+}
+
+// getDividendYield returns the dividends per stock as a percentage of the price of the stock
+func getDividendYield(dividendsPerStock, price uint64) float64 {
+	if price == 0 {
+		return 0
+	}
+	return float64(dividendsPerStock) * 100 / float64(price)
+}
+
+// AnnounceDividend announces a dividend of dividendAmount per stock, for the holders of the stock when
+// the market closes on recordDay. It's paid out when the market opens on paymentDay.
+func AnnounceDividend(stockId uint32, dividendAmount uint64, recordDay, paymentDay uint32) (*Dividend, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":               "AnnounceDividend",
+		"param_stockId":        stockId,
+		"param_dividendAmount": dividendAmount,
+		"param_recordDay":      recordDay,
+		"param_paymentDay":     paymentDay,
+	})
+
+	l.Infof("Attempting")
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil || stock.IsDelisted {
+		return nil, InvalidStockIdError{}
+	}
+	if stock.IsBankrupt {
+		return nil, StockBankruptError{}
+	}
+	if dividendAmount == 0 || dividendAmount >= stock.CurrentPrice {
+		return nil, InvalidDividendError{fmt.Sprintf("The dividend has to be more than 0 and less than the stock's price of %d.", stock.CurrentPrice)}
+	}
+
+	marketDay := GetMarketDay()
+	if recordDay < marketDay {
+		return nil, InvalidDividendError{"The record day can't be a market day that's over."}
+	}
+	if paymentDay <= recordDay {
+		return nil, InvalidDividendError{"The payment day has to be after the record day."}
+	}
+
+	dividend := &Dividend{
+		StockId:        stockId,
+		DividendAmount: dividendAmount,
+		AnnouncedOnDay: marketDay,
+		RecordDay:      recordDay,
+		PaymentDay:     paymentDay,
+		CreatedAt:      utils.GetCurrentTimeISO8601(),
+	}
+
+	db := getDB()
+	if err := db.Create(dividend).Error; err != nil {
+		l.Errorf("Error while creating the dividend: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Announced dividend %d", dividend.Id)
+
+	go func() {
+		message := fmt.Sprintf("%s has announced a dividend of %d per share for its holders at the close of day %d. It will be paid on day %d.", stock.FullName, dividendAmount, recordDay, paymentDay)
+		SendPushNotification(0, PushNotification{
+			Title:   "Message from Dalal Street! A company just announced a dividend.",
+			Message: message,
+			LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+		})
+		SendNotification(0, message, true)
+	}()
+
+	return dividend, nil
+}
+
+// getPendingDividends returns the dividends that haven't been paid yet
+func getPendingDividends() ([]*Dividend, error) {
+	db := getDB()
+
+	var dividends []*Dividend
+	if err := db.Where("isPaid = ?", false).Order("id").Find(&dividends).Error; err != nil {
+		return nil, err
+	}
+
+	return dividends, nil
+}
+
+// GetDividendHistory returns the dividends of a stock, or of all the stocks if stockId is 0, latest
+// first. Upcoming dividends are included. The dividend yield of each stock is returned too. It's worked
+// out from all the dividends it announced, at its current price.
+func GetDividendHistory(stockId uint32) ([]*Dividend, map[uint32]float64, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetDividendHistory",
+		"param_stockId": stockId,
+	})
+
+	db := getDB()
+
+	query := db.Order("id desc")
+	if stockId != 0 {
+		query = query.Where("stockId = ?", stockId)
+	}
+
+	var dividends []*Dividend
+	if err := query.Find(&dividends).Error; err != nil {
+		l.Errorf("Error while loading dividends: %+v", err)
+		return nil, nil, err
+	}
+
+	dividendsPerStock := make(map[uint32]uint64)
+	for _, dividend := range dividends {
+		dividendsPerStock[dividend.StockId] += dividend.DividendAmount
+	}
+
+	yields := make(map[uint32]float64)
+	for id, stock := range GetAllStocks() {
+		if stockId != 0 && id != stockId {
+			continue
+		}
+		yields[id] = getDividendYield(dividendsPerStock[id], stock.CurrentPrice)
+	}
+
+	return dividends, yields, nil
+}
+
+// RecordDividendHolders notes down the holders of the stocks whose dividends have their record day on
+// the current market day or before. It's called when the market closes.
+func RecordDividendHolders() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "RecordDividendHolders",
+	})
+
+	l.Infof("Attempting")
+
+	dividends, err := getPendingDividends()
+	if err != nil {
+		l.Errorf("Unable to get the dividends: %+v", err)
+		return
+	}
+
+	db := getDB()
+	marketDay := GetMarketDay()
+
+	for _, dividend := range dividends {
+		if dividend.IsRecorded || dividend.RecordDay > marketDay {
+			continue
+		}
+
+		var holders []*userDetails
+		sql := "SELECT userId AS user_id, (SUM(stockQuantity)+SUM(reservedStockQuantity)) AS stock_quantity FROM Transactions WHERE stockId = ? GROUP BY userId HAVING stock_quantity > 0"
+		if err := db.Raw(sql, dividend.StockId).Scan(&holders).Error; err != nil {
+			l.Errorf("Unable to get the holders of dividend %d: %+v", dividend.Id, err)
+			continue
+		}
+
+		tx := db.Begin()
+
+		failed := false
+		for _, holder := range holders {
+			dividendHolder := &DividendHolder{
+				DividendId:    dividend.Id,
+				UserId:        holder.UserID,
+				StockQuantity: holder.StockQuantity,
+			}
+			if err := tx.Create(dividendHolder).Error; err != nil {
+				l.Errorf("Unable to record user %d for dividend %d: %+v", holder.UserID, dividend.Id, err)
+				failed = true
+				break
+			}
+		}
+		if !failed {
+			dividend.IsRecorded = true
+			if err := tx.Save(dividend).Error; err != nil {
+				l.Errorf("Unable to save dividend %d: %+v", dividend.Id, err)
+				failed = true
+			}
+		}
+		if failed {
+			dividend.IsRecorded = false
+			tx.Rollback()
+			continue
+		}
+		if err := tx.Commit().Error; err != nil {
+			dividend.IsRecorded = false
+			l.Errorf("Unable to commit the holders of dividend %d: %+v", dividend.Id, err)
+			continue
+		}
+
+		l.Infof("Recorded %d holders for dividend %d", len(holders), dividend.Id)
+
+		go func(dividend *Dividend, holders []*userDetails) {
+			for _, holder := range holders {
+				SendNotification(holder.UserID, fmt.Sprintf("You will get a dividend of %d for your %d shares on day %d.", dividend.DividendAmount*holder.StockQuantity, holder.StockQuantity, dividend.PaymentDay), false)
+			}
+		}(dividend, holders)
+	}
+}
+
+// AdjustExDividendPrices drops the prices of the stocks that went ex-dividend by their dividends, along
+// with their previous day's closes, so that the drop doesn't look like a move in the market. It's called
+// when the market opens.
+func AdjustExDividendPrices() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "AdjustExDividendPrices",
+	})
+
+	dividends, err := getPendingDividends()
+	if err != nil {
+		l.Errorf("Unable to get the dividends: %+v", err)
+		return
+	}
+
+	db := getDB()
+
+	for _, dividend := range dividends {
+		if !dividend.IsRecorded || dividend.IsExAdjusted {
+			continue
+		}
+
+		allStocks.RLock()
+		stockNLock, ok := allStocks.m[dividend.StockId]
+		allStocks.RUnlock()
+		if !ok {
+			l.Errorf("Not found stock for id %d", dividend.StockId)
+			continue
+		}
+
+		stockNLock.Lock()
+		stock := stockNLock.stock
+		oldStockCopy := *stock
+
+		stock.CurrentPrice = getExDividendPrice(stock.CurrentPrice, dividend.DividendAmount)
+		stock.PreviousDayClose = getExDividendPrice(stock.PreviousDayClose, dividend.DividendAmount)
+		stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+		dividend.IsExAdjusted = true
+
+		tx := db.Begin()
+		if err := tx.Save(stock).Error; err != nil {
+			l.Errorf("Unable to adjust the price of stock %d: %+v", stock.Id, err)
+			*stock = oldStockCopy
+			dividend.IsExAdjusted = false
+			tx.Rollback()
+			stockNLock.Unlock()
+			continue
+		}
+		if err := tx.Save(dividend).Error; err != nil {
+			l.Errorf("Unable to save dividend %d: %+v", dividend.Id, err)
+			*stock = oldStockCopy
+			dividend.IsExAdjusted = false
+			tx.Rollback()
+			stockNLock.Unlock()
+			continue
+		}
+		if err := tx.Commit().Error; err != nil {
+			l.Errorf("Unable to commit the adjustment for dividend %d: %+v", dividend.Id, err)
+			*stock = oldStockCopy
+			dividend.IsExAdjusted = false
+			stockNLock.Unlock()
+			continue
+		}
+
+		stockId, price := stock.Id, stock.CurrentPrice
+		stockNLock.Unlock()
+
+		lowerCircuitBreakerPrices(stockId, oldStockCopy.CurrentPrice-price)
+
+		l.Infof("Stock %d went ex-dividend. Price is now %d", stockId, price)
+
+		go func() {
+			stockPriceStream := datastreamsManager.GetStockPricesStream()
+			stockPriceStream.SendStockPriceUpdate(stockId, price)
+		}()
+	}
+}
+
+// PayDueDividends pays the dividends whose payment day is the current market day or before, to the
+// holders noted down on their record days. It's called when the market opens.
+func PayDueDividends() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "PayDueDividends",
+	})
+
+	l.Infof("Attempting")
+
+	dividends, err := getPendingDividends()
+	if err != nil {
+		l.Errorf("Unable to get the dividends: %+v", err)
+		return
+	}
+
+	marketDay := GetMarketDay()
+
+	for _, dividend := range dividends {
+		if !dividend.IsRecorded || dividend.PaymentDay > marketDay {
+			continue
+		}
+		if err := payDividend(dividend); err != nil {
+			l.Errorf("Unable to pay dividend %d: %+v", dividend.Id, err)
+		}
+	}
+}
+
+// payDividend pays a dividend to the holders noted down on its record day
+func payDividend(dividend *Dividend) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":           "payDividend",
+		"param_dividendId": dividend.Id,
+	})
+
+	db := getDB()
+
+	var holders []*DividendHolder
+	if err := db.Where("dividendId = ?", dividend.Id).Find(&holders).Error; err != nil {
+		return err
+	}
+
+	var transactions []*Transaction
+	oldCash := make(map[*User]uint64)
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		for user, cash := range oldCash {
+			user.Cash = cash
+		}
+		dividend.IsPaid = false
+		tx.Rollback()
+		return err
+	}
+
+	for _, holder := range holders {
+		ch, user, err := getUserExclusively(holder.UserId)
+		if err != nil {
+			return errorHelper("Error acquiring exclusive write on user. Rolling back. Error: %+v", err)
+		}
+		defer close(ch)
+
+		total := dividend.DividendAmount * holder.StockQuantity
+		transaction := GetTransactionRef(holder.UserId, dividend.StockId, DividendTransaction, 0, 0, dividend.DividendAmount, 0, int64(total))
+		if err := tx.Save(transaction).Error; err != nil {
+			return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+		}
+		transactions = append(transactions, transaction)
+
+		oldCash[user] = user.Cash
+		user.Cash += total
+		if err := tx.Save(user).Error; err != nil {
+			return errorHelper("Error updating user's cash. Rolling back. Error: %+v", err)
+		}
+	}
+
+	dividend.IsPaid = true
+	if err := tx.Save(dividend).Error; err != nil {
+		return errorHelper("Error saving the dividend. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	l.Infof("Paid the dividend to %d holders", len(holders))
+
+	go func() {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		for _, transaction := range transactions {
+			transactionsStream.SendTransaction(transaction.ToProto())
+		}
+
+		stock, err := GetStockCopy(dividend.StockId)
+		if err != nil {
+			l.Errorf("Unable to get the stock: %+v", err)
+			return
+		}
+		for _, holder := range holders {
+			SendNotification(holder.UserId, fmt.Sprintf("You have been paid a dividend of %d for your %d shares of %s.", dividend.DividendAmount*holder.StockQuantity, holder.StockQuantity, stock.FullName), false)
+		}
+		SendNotification(0, fmt.Sprintf("Company %+v has paid out its dividend of %d per share.", stock.FullName, dividend.DividendAmount), true)
+	}()
+
+	return nil
+}
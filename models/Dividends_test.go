@@ -0,0 +1,156 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetExDividendPrice(t *testing.T) {
+	var tests = []struct {
+		price          uint64
+		dividendAmount uint64
+		exPrice        uint64
+	}{
+		{100, 5, 95},
+		{100, 99, 1},
+		// a dividend that would take the price to 0 leaves it as it is
+		{100, 100, 100},
+		{0, 5, 0},
+	}
+
+	for _, test := range tests {
+		if exPrice := getExDividendPrice(test.price, test.dividendAmount); exPrice != test.exPrice {
+			t.Errorf("getExDividendPrice(%+v) = %d, expected %d", test, exPrice, test.exPrice)
+		}
+	}
+}
+
+func TestGetDividendYield(t *testing.T) {
+	var tests = []struct {
+		dividendsPerStock uint64
+		price             uint64
+		yield             float64
+	}{
+		{5, 100, 5},
+		{3, 200, 1.5},
+		{0, 100, 0},
+		{5, 0, 0},
+	}
+
+	for _, test := range tests {
+		if yield := getDividendYield(test.dividendsPerStock, test.price); yield != test.yield {
+			t.Errorf("getDividendYield(%+v) = %v, expected %v", test, yield, test.yield)
+		}
+	}
+}
+
+func Test_Dividend(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 1000}}
+	stock := &Stock{Id: 1, CurrentPrice: 100, PreviousDayClose: 100}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM DividendHolders")
+		db.Exec("DELETE FROM Dividends")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stock)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// user 2 holds 5 stocks, 2 of them reserved for an ask, and user 3 has short sold 3
+	if err := db.Create(GetTransactionRef(2, stock.Id, FromExchangeTransaction, 0, 5, 100, 0, -500)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(2, stock.Id, PlaceOrderTransaction, 2, -2, 0, 0, 0)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, stock.Id, OrderFillTransaction, 0, -3, 100, 0, 300)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	marketDay := GetMarketDay()
+	if _, err := AnnounceDividend(stock.Id, 10, marketDay, marketDay); err == nil {
+		t.Fatalf("Expected a dividend paid on its record day to fail")
+	}
+	dividend, err := AnnounceDividend(stock.Id, 10, marketDay, marketDay+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only holders with stocks on the record day get the dividend
+	RecordDividendHolders()
+
+	var holders []*DividendHolder
+	if err := db.Where("dividendId = ?", dividend.Id).Find(&holders).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 1 {
+		t.Fatalf("Expected 1 holder, got %+v", holders)
+	}
+	testutils.AssertEqual(t, uint32(2), holders[0].UserId)
+	testutils.AssertEqual(t, uint64(5), holders[0].StockQuantity)
+
+	// selling the stocks after the record day doesn't take the dividend away
+	if err := db.Create(GetTransactionRef(2, stock.Id, OrderFillTransaction, -2, -3, 100, 0, 500)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	AdjustExDividendPrices()
+
+	s, err := GetStockCopy(stock.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, uint64(90), s.CurrentPrice)
+	testutils.AssertEqual(t, uint64(90), s.PreviousDayClose)
+
+	// the payment day hasn't come yet
+	PayDueDividends()
+
+	var count int
+	db.Table("Transactions").Where("type = ?", DividendTransaction.String()).Count(&count)
+	testutils.AssertEqual(t, 0, count)
+
+	dividends, err := getPendingDividends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dividends) != 1 {
+		t.Fatalf("Expected 1 pending dividend, got %+v", dividends)
+	}
+	if err := payDividend(dividends[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var totals []int64
+	if err := db.Table("Transactions").Where("type = ?", DividendTransaction.String()).Order("id").Pluck("total", &totals).Error; err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, []int64{50}, totals)
+
+	for i, cash := range []uint64{1050, 1000} {
+		u := &User{}
+		db.First(u, users[i].Id)
+		testutils.AssertEqual(t, cash, u.Cash)
+	}
+
+	dividends, err = getPendingDividends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, 0, len(dividends))
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigFeeBasisPoints as the fee of a stock makes it use the fee in the config
+const ConfigFeeBasisPoints int64 = -1
+
+// InvalidFeeError is returned when a stock is given a negative fee other than ConfigFeeBasisPoints
+var InvalidFeeError = errors.New("Invalid fee")
+
+// FeeSchedule has the fees a user pays for trading a stock
+type FeeSchedule struct {
+	// Fee, in basis points of the traded value, of an order that was resting in the order book
+	MakerFeeBasisPoints uint64
+	// Fee, in basis points of the traded value, of an incoming order
+	TakerFeeBasisPoints uint64
+	// Value of the stocks the user has traded in the market day
+	DayVolume uint64
+	// Discount the user gets on the fees for DayVolume
+	DiscountPercent uint64
+	// All the tiers of discounts, by increasing MinVolume
+	Tiers []utils.FeeTier
+}
+
+// dayVolumes has the value of the stocks each user traded in the market day. A user's volume
+// is loaded from the database the first time it's needed, so it survives a restart.
+var dayVolumes = struct {
+	sync.Mutex
+	m map[uint32]uint64
+	// since is when the market day started. It's zero till the market opens, and the volume
+	// is counted from the start of the calendar day then.
+	since time.Time
+}{
+	m: make(map[uint32]uint64),
+}
+
+// resetDayVolumes starts counting the volumes of a new market day
+func resetDayVolumes() {
+	dayVolumes.Lock()
+	dayVolumes.m = make(map[uint32]uint64)
+	dayVolumes.since = time.Now()
+	dayVolumes.Unlock()
+}
+
+// getUserDayVolume returns the value of the stocks the user has traded in the market day
+func getUserDayVolume(userId uint32) (uint64, error) {
+	dayVolumes.Lock()
+	defer dayVolumes.Unlock()
+
+	if volume, ok := dayVolumes.m[userId]; ok {
+		return volume, nil
+	}
+
+	since := dayVolumes.since
+	if since.IsZero() {
+		now := time.Now()
+		since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+
+	// the ask side of a fill has its quantity in reservedStockQuantity, and the bid side in stockQuantity
+	var result struct {
+		Volume uint64
+	}
+	db := getDB()
+	err := db.Raw("SELECT COALESCE(SUM(ABS(stockQuantity + reservedStockQuantity) * price), 0) AS volume FROM Transactions WHERE userId = ? AND type = ? AND createdAt >= ?",
+		userId, OrderFillTransaction.String(), since.Format(time.RFC3339)).Scan(&result).Error
+	if err != nil {
+		return 0, err
+	}
+
+	dayVolumes.m[userId] = result.Volume
+	return result.Volume, nil
+}
+
+// addUserDayVolume adds the value of a trade to the user's volume of the market day
+func addUserDayVolume(userId uint32, volume uint64) {
+	dayVolumes.Lock()
+	if _, ok := dayVolumes.m[userId]; ok {
+		dayVolumes.m[userId] += volume
+	}
+	dayVolumes.Unlock()
+}
+
+// getFeeTiers returns the tiers in the config, by increasing MinVolume
+func getFeeTiers() []utils.FeeTier {
+	tiers := append([]utils.FeeTier(nil), config.FeeTiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	return tiers
+}
+
+// getFeeDiscountPercent returns the discount on fees for a user who has traded stocks worth volume in the market day
+func getFeeDiscountPercent(volume uint64) uint64 {
+	var discount uint64
+	for _, tier := range getFeeTiers() {
+		if volume >= tier.MinVolume {
+			discount = tier.DiscountPercent
+		}
+	}
+	if discount > 100 {
+		return 100
+	}
+	return discount
+}
+
+// getFeeRates returns the maker and taker fees of a stock. Stocks without fees of their own use the ones in the config.
+func getFeeRates(stockId uint32) (uint64, uint64) {
+	makerFee, takerFee := config.MakerFeeBasisPoints, config.TakerFeeBasisPoints
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil {
+		return makerFee, takerFee
+	}
+	if stock.MakerFeeBasisPoints >= 0 {
+		makerFee = uint64(stock.MakerFeeBasisPoints)
+	}
+	if stock.TakerFeeBasisPoints >= 0 {
+		takerFee = uint64(stock.TakerFeeBasisPoints)
+	}
+	return makerFee, takerFee
+}
+
+// getFee returns the fee for a trade worth total, after the discount
+func getFee(total, feeBasisPoints, discountPercent uint64) uint64 {
+	return total * feeBasisPoints * (100 - discountPercent) / (10000 * 100)
+}
+
+// getMaxOrderFee returns the fee the user would pay if the whole order traded as a taker
+func getMaxOrderFee(userId, stockId uint32, quantity, price uint64) uint64 {
+	volume, err := getUserDayVolume(userId)
+	if err != nil {
+		logger.Errorf("Unable to get day volume of user %d. Assuming no discount. Error: %+v", userId, err)
+	}
+	_, takerFee := getFeeRates(stockId)
+	return getFee(quantity*price, takerFee, getFeeDiscountPercent(volume))
+}
+
+// GetFeeSchedule returns the fees the user pays for trading a stock
+func GetFeeSchedule(userId, stockId uint32) (*FeeSchedule, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetFeeSchedule",
+		"param_userId":  userId,
+		"param_stockId": stockId,
+	})
+
+	if _, err := GetStockCopy(stockId); err != nil {
+		return nil, InvalidStockError
+	}
+
+	volume, err := getUserDayVolume(userId)
+	if err != nil {
+		l.Errorf("Unable to get day volume of the user: %+v", err)
+		return nil, err
+	}
+
+	makerFee, takerFee := getFeeRates(stockId)
+
+	return &FeeSchedule{
+		MakerFeeBasisPoints: makerFee,
+		TakerFeeBasisPoints: takerFee,
+		DayVolume:           volume,
+		DiscountPercent:     getFeeDiscountPercent(volume),
+		Tiers:               getFeeTiers(),
+	}, nil
+}
+
+// isValidStockFee says if a fee can be set for a stock. It's either ConfigFeeBasisPoints or not negative.
+func isValidStockFee(feeBasisPoints int64) bool {
+	return feeBasisPoints >= 0 || feeBasisPoints == ConfigFeeBasisPoints
+}
+
+// SetStockFees sets the maker and taker fees of a stock in basis points.
+// ConfigFeeBasisPoints makes the stock use the fee in the config again.
+func SetStockFees(stockId uint32, makerFeeBasisPoints, takerFeeBasisPoints int64) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":                    "SetStockFees",
+		"param_stockId":             stockId,
+		"param_makerFeeBasisPoints": makerFeeBasisPoints,
+		"param_takerFeeBasisPoints": takerFeeBasisPoints,
+	})
+
+	l.Infof("Attempting")
+
+	if !isValidStockFee(makerFeeBasisPoints) || !isValidStockFee(takerFeeBasisPoints) {
+		l.Debugf("Fees can't be negative")
+		return InvalidFeeError
+	}
+
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[stockId]
+	if !ok {
+		allStocks.Unlock()
+		return InvalidStockError
+	}
+	defer allStocks.Unlock()
+
+	stockNLock.Lock()
+	defer stockNLock.Unlock()
+
+	stock := stockNLock.stock
+	oldStockCopy := *stock
+
+	stock.MakerFeeBasisPoints = makerFeeBasisPoints
+	stock.TakerFeeBasisPoints = takerFeeBasisPoints
+
+	db := getDB()
+
+	if err := db.Save(stock).Error; err != nil {
+		l.Errorf("Error while saving stock: %+v", err)
+		*stock = oldStockCopy
+		return err
+	}
+
+	l.Infof("Done")
+	return nil
+}
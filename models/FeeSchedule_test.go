@@ -0,0 +1,128 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/delta/dalal-street-server/utils"
+)
+
+func TestGetFeeRates(t *testing.T) {
+	var stockId uint32 = 1000
+
+	oldMakerFee, oldTakerFee := config.MakerFeeBasisPoints, config.TakerFeeBasisPoints
+	oldStock, hadStock := allStocks.m[stockId]
+	defer func() {
+		config.MakerFeeBasisPoints, config.TakerFeeBasisPoints = oldMakerFee, oldTakerFee
+		allStocks.Lock()
+		if hadStock {
+			allStocks.m[stockId] = oldStock
+		} else {
+			delete(allStocks.m, stockId)
+		}
+		allStocks.Unlock()
+	}()
+
+	config.MakerFeeBasisPoints, config.TakerFeeBasisPoints = 10, 30
+
+	allStocks.Lock()
+	allStocks.m[stockId] = &stockAndLock{stock: &Stock{Id: stockId}}
+	allStocks.Unlock()
+
+	var tests = []struct {
+		stockMakerFee int64
+		stockTakerFee int64
+		makerFee      uint64
+		takerFee      uint64
+	}{
+		{ConfigFeeBasisPoints, ConfigFeeBasisPoints, 10, 30},
+		{0, ConfigFeeBasisPoints, 0, 30},
+		{5, 50, 5, 50},
+		{ConfigFeeBasisPoints, 0, 10, 0},
+	}
+
+	for _, test := range tests {
+		allStocks.m[stockId].stock.MakerFeeBasisPoints = test.stockMakerFee
+		allStocks.m[stockId].stock.TakerFeeBasisPoints = test.stockTakerFee
+
+		if makerFee, takerFee := getFeeRates(stockId); makerFee != test.makerFee || takerFee != test.takerFee {
+			t.Errorf("getFeeRates(%+v) = %d, %d, expected %d, %d", test, makerFee, takerFee, test.makerFee, test.takerFee)
+		}
+	}
+
+	// stocks that aren't listed pay the fees in the config
+	if makerFee, takerFee := getFeeRates(stockId + 1); makerFee != 10 || takerFee != 30 {
+		t.Errorf("getFeeRates of unknown stock = %d, %d, expected 10, 30", makerFee, takerFee)
+	}
+}
+
+func TestGetFeeDiscountPercent(t *testing.T) {
+	oldTiers := config.FeeTiers
+	defer func() {
+		config.FeeTiers = oldTiers
+	}()
+
+	// the tiers needn't be in order in the config
+	config.FeeTiers = []utils.FeeTier{
+		{MinVolume: 1000000, DiscountPercent: 25},
+		{MinVolume: 100000, DiscountPercent: 10},
+		{MinVolume: 5000000, DiscountPercent: 150},
+	}
+
+	var tests = []struct {
+		volume   uint64
+		discount uint64
+	}{
+		{0, 0},
+		{99999, 0},
+		{100000, 10},
+		{999999, 10},
+		{1000000, 25},
+		{5000000, 100},
+	}
+
+	for _, test := range tests {
+		if discount := getFeeDiscountPercent(test.volume); discount != test.discount {
+			t.Errorf("getFeeDiscountPercent(%d) = %d, expected %d", test.volume, discount, test.discount)
+		}
+	}
+}
+
+func TestGetFee(t *testing.T) {
+	var tests = []struct {
+		total           uint64
+		feeBasisPoints  uint64
+		discountPercent uint64
+		fee             uint64
+	}{
+		{100000, 30, 0, 300},
+		{100000, 30, 10, 270},
+		{100000, 30, 100, 0},
+		{100000, 0, 0, 0},
+		{999, 10, 0, 0},
+	}
+
+	for _, test := range tests {
+		if fee := getFee(test.total, test.feeBasisPoints, test.discountPercent); fee != test.fee {
+			t.Errorf("getFee(%+v) = %d, expected %d", test, fee, test.fee)
+		}
+	}
+}
+
+func TestIsValidStockFee(t *testing.T) {
+	var tests = []struct {
+		feeBasisPoints int64
+		isValid        bool
+	}{
+		{0, true},
+		{30, true},
+		{ConfigFeeBasisPoints, true},
+		{-2, false},
+		{-30, false},
+	}
+
+	for _, test := range tests {
+		if isValid := isValidStockFee(test.feeBasisPoints); isValid != test.isValid {
+			t.Errorf("isValidStockFee(%d) = %t, expected %t", test.feeBasisPoints, isValid, test.isValid)
+		}
+	}
+}
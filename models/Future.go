@@ -0,0 +1,585 @@
+package models
+
+import (
+	"fmt"
+	"math/bits"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// FutureContract is a cash-settled future on a stock, or on the market index if StockId is 0. It's listed
+// by the admin, and users open and close positions in it by trading its contracts with each other on its
+// order book. Positions are marked to market every time the market closes, against the price of the
+// underlying, and settle finally when it closes on ExpiryDay.
+type FutureContract struct {
+	Id        uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId   uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	LotSize   uint64 `gorm:"column:lotSize;not null" json:"lot_size"`
+	ExpiryDay uint32 `gorm:"column:expiryDay;not null" json:"expiry_day"`
+	IsSettled bool   `gorm:"column:isSettled;not null" json:"is_settled"`
+	// price of the underlying the contracts were settled at. 0 till then.
+	SettlementPrice uint64 `gorm:"column:settlementPrice;not null" json:"settlement_price"`
+	CreatedAt       string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (FutureContract) TableName() string {
+	return "FutureContracts"
+}
+
+func (fc *FutureContract) ToProto() *models_pb.FutureContract {
+	return &models_pb.FutureContract{
+		Id:              fc.Id,
+		StockId:         fc.StockId,
+		LotSize:         fc.LotSize,
+		ExpiryDay:       fc.ExpiryDay,
+		IsSettled:       fc.IsSettled,
+		SettlementPrice: fc.SettlementPrice,
+		CreatedAt:       fc.CreatedAt,
+	}
+}
+
+// FuturePosition holds the contracts of a future a user is long or short in
+type FuturePosition struct {
+	Id       uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId   uint32 `gorm:"column:userId;not null" json:"user_id"`
+	FutureId uint32 `gorm:"column:futureId;not null" json:"future_id"`
+	// contracts bought if positive, contracts sold if negative
+	Quantity int64 `gorm:"column:quantity;not null" json:"quantity"`
+	// price the position was last marked at, by a trade or when the market closed. Profits and losses
+	// since then haven't been paid yet.
+	MarkPrice uint64 `gorm:"column:markPrice;not null" json:"mark_price"`
+	// profit or loss the position made before it was last marked by a trade. It's paid along with the
+	// variation margin when the market closes.
+	RealisedProfit int64 `gorm:"column:realisedProfit;not null" json:"realised_profit"`
+	// initial margin of the position. It's held in the user's ReservedCash.
+	Margin    uint64 `gorm:"column:margin;not null" json:"margin"`
+	UpdatedAt string `gorm:"column:updatedAt;not null" json:"updated_at"`
+
+	// profit or loss at the current price of the underlying that hasn't been paid yet
+	UnrealisedProfit int64 `gorm:"-" json:"unrealised_profit"`
+}
+
+func (FuturePosition) TableName() string {
+	return "FuturePositions"
+}
+
+func (fp *FuturePosition) ToProto() *models_pb.FuturePosition {
+	return &models_pb.FuturePosition{
+		FutureId:         fp.FutureId,
+		Quantity:         fp.Quantity,
+		MarkPrice:        fp.MarkPrice,
+		Margin:           fp.Margin,
+		UnrealisedProfit: fp.UnrealisedProfit,
+	}
+}
+
+// InvalidFutureError is returned if a future doesn't exist, or can't be traded anymore
+type InvalidFutureError struct{ reason string }
+
+func (e InvalidFutureError) Error() string {
+	return e.reason
+}
+
+// getFutureProfit returns what a position of quantity contracts made since it was marked at markPrice
+func getFutureProfit(quantity int64, lotSize, markPrice, price uint64) int64 {
+	return quantity * int64(lotSize) * (int64(price) - int64(markPrice))
+}
+
+// getFutureMargin returns the initial margin of a position of quantity contracts at the given price
+func getFutureMargin(quantity int64, lotSize, price uint64) uint64 {
+	if quantity < 0 {
+		quantity = -quantity
+	}
+	return uint64(quantity) * lotSize * price * config.FuturesInitialMarginPercent / 100
+}
+
+// collectVariationMargin returns how much of a loss is paid out of a user's cash, and how much out of the
+// margin of their position. Losses come out of the cash first. What both can't cover isn't paid.
+func collectVariationMargin(cash, margin, loss uint64) (fromCash uint64, fromMargin uint64) {
+	fromCash = minUint64(loss, cash)
+	fromMargin = minUint64(loss-fromCash, margin)
+	return fromCash, fromMargin
+}
+
+// shareVariationMargin splits what was collected from the losers of a future among its winners. As every
+// contract is held long by one user and short by another, the profits add up to the losses, and winners
+// are paid in full. If a loser couldn't pay, each winner gets a share of what was collected in proportion
+// to their profit. What's left over from rounding goes to the first winners, so that all of it is paid out.
+func shareVariationMargin(profits []uint64, collected uint64) []uint64 {
+	var total uint64
+	for _, profit := range profits {
+		total += profit
+	}
+
+	paid := make([]uint64, len(profits))
+	if collected >= total {
+		copy(paid, profits)
+		return paid
+	}
+
+	left := collected
+	for i, profit := range profits {
+		// profit * collected doesn't fit in 64 bits for large amounts
+		hi, lo := bits.Mul64(profit, collected)
+		paid[i], _ = bits.Div64(hi, lo, total)
+		left -= paid[i]
+	}
+	for i := 0; left > 0 && i < len(paid); i++ {
+		if paid[i] < profits[i] {
+			paid[i]++
+			left--
+		}
+	}
+	return paid
+}
+
+// getFuturePrice returns the current price of the underlying of a future
+func getFuturePrice(future *FutureContract) (uint64, error) {
+	if future.StockId == 0 {
+		return GetMarketIndexValue(), nil
+	}
+
+	stock, err := GetStockCopy(future.StockId)
+	if err != nil {
+		return 0, err
+	}
+	return stock.CurrentPrice, nil
+}
+
+// getFutureContract returns a future from the database
+func getFutureContract(db *gorm.DB, futureId uint32) (*FutureContract, error) {
+	future := &FutureContract{}
+	if err := db.First(future, futureId).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidFutureError{"Invalid future"}
+		}
+		return nil, err
+	}
+	return future, nil
+}
+
+// getFuturePosition returns a user's position in a future. An empty one is returned if the user
+// never traded the future.
+func getFuturePosition(db *gorm.DB, userId, futureId uint32) (*FuturePosition, error) {
+	position := &FuturePosition{}
+	if err := db.Where("userId = ? and futureId = ?", userId, futureId).FirstOrInit(position).Error; err != nil {
+		return nil, err
+	}
+	position.UserId = userId
+	position.FutureId = futureId
+	return position, nil
+}
+
+// rebaseFuturePosition marks a position at the price of a trade. The profit or loss made since it was last
+// marked is added to RealisedProfit, to be paid when the market closes.
+func rebaseFuturePosition(position *FuturePosition, lotSize, price uint64) {
+	position.RealisedProfit += getFutureProfit(position.Quantity, lotSize, position.MarkPrice, price)
+	position.MarkPrice = price
+}
+
+// ListFuture lists a new future on a stock, or on the market index if stockId is 0, expiring on expiryDay
+func ListFuture(stockId uint32, lotSize uint64, expiryDay uint32) (*FutureContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":          "ListFuture",
+		"param_stockId":   stockId,
+		"param_lotSize":   lotSize,
+		"param_expiryDay": expiryDay,
+	})
+
+	l.Infof("Attempting")
+
+	if stockId != 0 {
+		if stock, err := GetStockCopy(stockId); err != nil || stock.IsDelisted {
+			return nil, InvalidStockIdError{}
+		}
+		if IsStockBankrupt(stockId) {
+			return nil, StockBankruptError{}
+		}
+	}
+	if lotSize == 0 {
+		return nil, InvalidFutureError{"Lot size must be more than 0."}
+	}
+	if expiryDay < GetMarketDay() {
+		return nil, InvalidFutureError{"The future can't expire on a market day that's over."}
+	}
+
+	future := &FutureContract{
+		StockId:   stockId,
+		LotSize:   lotSize,
+		ExpiryDay: expiryDay,
+		CreatedAt: utils.GetCurrentTimeISO8601(),
+	}
+
+	db := getDB()
+	if err := db.Create(future).Error; err != nil {
+		l.Errorf("Error while creating the future: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Listed future %d", future.Id)
+	return future, nil
+}
+
+// GetOpenFutures returns the futures that haven't been settled yet
+func GetOpenFutures() ([]*FutureContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetOpenFutures",
+	})
+
+	db := getDB()
+
+	var futures []*FutureContract
+	if err := db.Where("isSettled = ?", false).Order("id").Find(&futures).Error; err != nil {
+		l.Errorf("Error while loading futures: %+v", err)
+		return nil, err
+	}
+
+	return futures, nil
+}
+
+// GetFuturePositions returns the positions of a user in futures that are open, or have profits that haven't
+// been paid yet, with their unrealised profits
+func GetFuturePositions(userId uint32) ([]*FuturePosition, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetFuturePositions",
+		"param_userId": userId,
+	})
+
+	db := getDB()
+
+	var positions []*FuturePosition
+	if err := db.Where("userId = ? and (quantity != 0 or realisedProfit != 0)", userId).Find(&positions).Error; err != nil {
+		l.Errorf("Error while loading future positions: %+v", err)
+		return nil, err
+	}
+
+	for _, position := range positions {
+		future, err := getFutureContract(db, position.FutureId)
+		if err != nil {
+			l.Errorf("Error while loading future %d: %+v", position.FutureId, err)
+			return nil, err
+		}
+		price, err := getFuturePrice(future)
+		if err != nil {
+			l.Errorf("Error while getting the price of future %d: %+v", position.FutureId, err)
+			return nil, err
+		}
+		position.UnrealisedProfit = getFutureProfit(position.Quantity, future.LotSize, position.MarkPrice, price) + position.RealisedProfit
+	}
+
+	return positions, nil
+}
+
+// getFuturesProfits returns the unrealised profits of every user's positions in futures
+func getFuturesProfits() (map[uint32]int64, error) {
+	futures, err := GetOpenFutures()
+	if err != nil {
+		return nil, err
+	}
+
+	db := getDB()
+	profits := make(map[uint32]int64)
+
+	for _, future := range futures {
+		price, err := getFuturePrice(future)
+		if err != nil {
+			return nil, err
+		}
+
+		var positions []*FuturePosition
+		if err := db.Where("futureId = ? and (quantity != 0 or realisedProfit != 0)", future.Id).Find(&positions).Error; err != nil {
+			return nil, err
+		}
+
+		for _, position := range positions {
+			profits[position.UserId] += getFutureProfit(position.Quantity, future.LotSize, position.MarkPrice, price) + position.RealisedProfit
+		}
+	}
+
+	return profits, nil
+}
+
+// GetExpiringFutures returns the futures that have to be settled, as they expire on the current market day or before
+func GetExpiringFutures() ([]*FutureContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetExpiringFutures",
+	})
+
+	db := getDB()
+
+	var futures []*FutureContract
+	if err := db.Where("isSettled = ? and expiryDay <= ?", false, GetMarketDay()).Find(&futures).Error; err != nil {
+		l.Errorf("Error while loading expiring futures: %+v", err)
+		return nil, err
+	}
+
+	return futures, nil
+}
+
+// MarkFuturesToMarket marks the positions in the open futures that don't expire on the current market day
+// to market at the current prices of their underlyings, paying their profits and losses. It's called when
+// the market closes. Futures that expire are settled by SettleFuture instead.
+func MarkFuturesToMarket() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "MarkFuturesToMarket",
+	})
+
+	l.Infof("Attempting")
+
+	futures, err := GetOpenFutures()
+	if err != nil {
+		l.Errorf("Unable to get the futures: %+v", err)
+		return
+	}
+
+	marketDay := GetMarketDay()
+
+	for _, future := range futures {
+		if future.ExpiryDay <= marketDay {
+			continue
+		}
+
+		price, err := getFuturePrice(future)
+		if err != nil {
+			l.Errorf("Unable to get the price of future %d: %+v", future.Id, err)
+			continue
+		}
+
+		if err := markFutureToMarket(future, price, false); err != nil {
+			l.Errorf("Unable to mark future %d to market: %+v", future.Id, err)
+			continue
+		}
+		l.Infof("Marked future %d to market at %d", future.Id, price)
+	}
+}
+
+// SettleFuture settles an expired future in cash against the current price of its underlying. Its open
+// orders are closed first, returning the margin they reserved. The positions are then marked to market
+// a last time, and closed along with their margins.
+// The matching engine has to drop the future's order book before calling it.
+func SettleFuture(futureId uint32) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "SettleFuture",
+		"param_futureId": futureId,
+	})
+
+	l.Infof("Attempting")
+
+	db := getDB()
+
+	future, err := getFutureContract(db, futureId)
+	if err != nil {
+		l.Errorf("Error while loading the future: %+v", err)
+		return err
+	}
+	if future.IsSettled {
+		return nil
+	}
+
+	price, err := getFuturePrice(future)
+	if err != nil {
+		l.Errorf("Error while getting the price: %+v", err)
+		return err
+	}
+
+	var askIds, bidIds []uint32
+	if err := db.Model(&Ask{}).Where("futureId = ? and isClosed = ?", futureId, false).Pluck("id", &askIds).Error; err != nil {
+		l.Errorf("Error while loading open asks: %+v", err)
+		return err
+	}
+	if err := db.Model(&Bid{}).Where("futureId = ? and isClosed = ?", futureId, false).Pluck("id", &bidIds).Error; err != nil {
+		l.Errorf("Error while loading open bids: %+v", err)
+		return err
+	}
+
+	for _, id := range askIds {
+		ask, err := getAsk(id)
+		if err != nil {
+			return err
+		}
+		if err := ExpireAskOrder(ask); err != nil {
+			if _, ok := err.(AlreadyClosedError); !ok {
+				l.Errorf("Error while closing ask %d: %+v", id, err)
+				return err
+			}
+		}
+	}
+
+	for _, id := range bidIds {
+		bid, err := getBid(id)
+		if err != nil {
+			return err
+		}
+		if err := ExpireBidOrder(bid); err != nil {
+			if _, ok := err.(AlreadyClosedError); !ok {
+				l.Errorf("Error while closing bid %d: %+v", id, err)
+				return err
+			}
+		}
+	}
+
+	if err := markFutureToMarket(future, price, true); err != nil {
+		l.Errorf("Error while settling the positions: %+v", err)
+		return err
+	}
+
+	future.IsSettled = true
+	future.SettlementPrice = price
+	if err := db.Save(future).Error; err != nil {
+		l.Errorf("Error while marking the future settled: %+v", err)
+		return err
+	}
+
+	l.Infof("Settled at %d", price)
+	return nil
+}
+
+// markFutureToMarket marks all the positions in a future to market at price, in a single transaction.
+// What the losers pay out of their cash and margins is paid to the winners, so no cash is made or lost.
+// The positions are closed, and their margins released, if the future is settled.
+func markFutureToMarket(future *FutureContract, price uint64, settle bool) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "markFutureToMarket",
+		"param_futureId": future.Id,
+		"param_price":    price,
+		"param_settle":   settle,
+	})
+
+	db := getDB()
+
+	var positions []*FuturePosition
+	if err := db.Where("futureId = ? and (quantity != 0 or realisedProfit != 0)", future.Id).Order("userId").Find(&positions).Error; err != nil {
+		l.Errorf("Error while loading the positions: %+v", err)
+		return err
+	}
+
+	// the users are locked in the order of their ids, like getUserPairExclusive does
+	users := make([]*User, len(positions))
+	for i, position := range positions {
+		ch, user, err := getUserExclusively(position.UserId)
+		if err != nil {
+			l.Errorf("Unable to acquire a lock on user %d: %+v", position.UserId, err)
+			return err
+		}
+		defer close(ch)
+		users[i] = user
+	}
+
+	oldCash := make([]uint64, len(users))
+	oldReservedCash := make([]uint64, len(users))
+	oldPositions := make([]FuturePosition, len(positions))
+	for i := range positions {
+		oldCash[i], oldReservedCash[i] = users[i].Cash, users[i].ReservedCash
+		oldPositions[i] = *positions[i]
+	}
+
+	transactions := make([]*Transaction, len(positions))
+	var collected uint64
+	var winners []int
+	var profits []uint64
+
+	for i, position := range positions {
+		user := users[i]
+		profit := getFutureProfit(position.Quantity, future.LotSize, position.MarkPrice, price) + position.RealisedProfit
+
+		if profit > 0 {
+			winners = append(winners, i)
+			profits = append(profits, uint64(profit))
+			continue
+		}
+
+		fromCash, fromMargin := collectVariationMargin(user.Cash, position.Margin, uint64(-profit))
+		user.Cash -= fromCash
+		user.ReservedCash -= fromMargin
+		position.Margin -= fromMargin
+		collected += fromCash + fromMargin
+
+		if fromCash != 0 || fromMargin != 0 {
+			transactions[i] = GetTransactionRef(user.Id, future.StockId, FutureVariationMarginTransaction, 0, 0, price, -int64(fromMargin), -int64(fromCash))
+		}
+		if uint64(-profit) > fromCash+fromMargin {
+			l.Warnf("User %d couldn't pay %d of their loss", user.Id, uint64(-profit)-fromCash-fromMargin)
+		}
+	}
+
+	for j, paid := range shareVariationMargin(profits, collected) {
+		i := winners[j]
+		users[i].Cash += paid
+		transactions[i] = GetTransactionRef(users[i].Id, future.StockId, FutureVariationMarginTransaction, 0, 0, price, 0, int64(paid))
+	}
+
+	updatedAt := utils.GetCurrentTimeISO8601()
+	quantities := make([]int64, len(positions))
+
+	for i, position := range positions {
+		position.MarkPrice = price
+		position.RealisedProfit = 0
+		position.UpdatedAt = updatedAt
+		quantities[i] = position.Quantity
+
+		if !settle {
+			continue
+		}
+
+		released := position.Margin
+		users[i].Cash += released
+		users[i].ReservedCash -= released
+		position.Margin = 0
+		position.Quantity = 0
+
+		if transactions[i] == nil {
+			transactions[i] = GetTransactionRef(users[i].Id, future.StockId, FutureSettlementTransaction, 0, 0, price, 0, 0)
+		}
+		transactions[i].Type = FutureSettlementTransaction
+		transactions[i].ReservedCashTotal -= int64(released)
+		transactions[i].Total += int64(released)
+	}
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		for i := range positions {
+			users[i].Cash, users[i].ReservedCash = oldCash[i], oldReservedCash[i]
+			*positions[i] = oldPositions[i]
+		}
+		tx.Rollback()
+		return err
+	}
+
+	for i, position := range positions {
+		if t := transactions[i]; t != nil {
+			t.FutureId = future.Id
+			if err := saveTransaction(tx, t); err != nil {
+				return errorHelper("Error while saving the transaction. Rolling back. Error: %+v", err)
+			}
+		}
+		if err := tx.Save(users[i]).Error; err != nil {
+			return errorHelper("Error while saving the user. Rolling back. Error: %+v", err)
+		}
+		if err := tx.Save(position).Error; err != nil {
+			return errorHelper("Error while saving the position. Rolling back. Error: %+v", err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error while committing. Rolling back. Error: %+v", err)
+	}
+
+	go func() {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		for i, t := range transactions {
+			if t != nil {
+				transactionsStream.SendTransaction(t.ToProto())
+			}
+			if settle && quantities[i] != 0 {
+				SendNotification(users[i].Id, fmt.Sprintf("Your position of %d contracts in future#%d has been settled at %d.", quantities[i], future.Id, price), false)
+			}
+		}
+	}()
+
+	l.Infof("Marked %d positions. Collected %d from the losers", len(positions), collected)
+	return nil
+}
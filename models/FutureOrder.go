@@ -0,0 +1,489 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// checkFutureOrder checks an order placed for a future, and returns the future.
+// Futures only take plain limit orders, like options.
+func checkFutureOrder(futureId uint32, orderType OrderType, timeInForce TimeInForce, expiresOnDay uint32, price, quantity, quantityLimit uint64, isPlain bool) (*FutureContract, error) {
+	future, err := getFutureContract(getDB(), futureId)
+	if err != nil {
+		return nil, err
+	}
+
+	if future.IsSettled || future.ExpiryDay < GetMarketDay() {
+		return nil, InvalidFutureError{"The future has expired."}
+	}
+	if future.StockId != 0 {
+		if IsStockBankrupt(future.StockId) {
+			return nil, StockBankruptError{}
+		}
+		if err := checkStockHalted(future.StockId); err != nil {
+			return nil, err
+		}
+	}
+
+	if orderType != Limit || !isPlain {
+		return nil, InvalidFutureError{"Only plain limit orders can be placed for futures."}
+	}
+	if err := checkTimeInForce(orderType, timeInForce, expiresOnDay); err != nil {
+		return nil, err
+	}
+	if timeInForce == GoodTillDate && expiresOnDay > future.ExpiryDay {
+		return nil, InvalidTimeInForceError{fmt.Sprintf("The order must expire by day %d, when the future expires.", future.ExpiryDay)}
+	}
+
+	if price == 0 {
+		return nil, InvalidFutureError{"The price must be more than 0."}
+	}
+	if quantity > quantityLimit || quantity < 1 {
+		return nil, OrderStockLimitExceeded{}
+	}
+
+	return future, nil
+}
+
+// sendNewFutureOrderUpdates adds a newly placed future order to the user's open orders
+func sendNewFutureOrderUpdates(userId, orderId uint32, isAsk bool, stockId, futureId uint32, price, quantity uint64, placeOrderTransaction *Transaction) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:            orderId,
+		IsAsk:         isAsk,
+		IsNewOrder:    true,
+		StockId:       stockId,
+		FutureId:      futureId,
+		OrderPrice:    price,
+		StockQuantity: quantity,
+	})
+
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+	transactionsStream.SendTransaction(placeOrderTransaction.ToProto())
+}
+
+// placeFutureOrder reserves the initial margin of an order for quantity contracts of a future at price, and
+// creates the order through create. The margin is reserved even if the order would close contracts the user
+// holds, as it isn't known which of the user's orders trade first. It's released as the order trades.
+func placeFutureOrder(userId uint32, future *FutureContract, isAsk bool, price, quantity uint64, create func(tx *gorm.DB) (uint32, error)) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "placeFutureOrder",
+		"param_userId":   userId,
+		"param_futureId": future.Id,
+		"param_isAsk":    isAsk,
+	})
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return 0, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	margin := getFutureMargin(int64(quantity), future.LotSize, price)
+	if user.Cash < margin {
+		l.Debugf("Not enough cash for the margin. Has %d, needs %d", user.Cash, margin)
+		return 0, NotEnoughCashError{}
+	}
+
+	tx := getDB().Begin()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	var errorHelper = func(format string, err error) (uint32, error) {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return 0, err
+	}
+
+	orderId, err := create(tx)
+	if err != nil {
+		return errorHelper("Error while creating the order. Rolling back. Error: %+v", err)
+	}
+
+	if err := AddUserReservedCash(user, margin, tx); err != nil {
+		return errorHelper("Error while adding reserved cash to the user. Rolling back. Error: %+v", err)
+	}
+	if err := SubtractUserCash(user, margin, tx); err != nil {
+		return errorHelper("Error subtracting cash. Rolling back. Error: %+v", err)
+	}
+
+	placeOrderTransaction := GetTransactionRef(userId, future.StockId, PlaceOrderTransaction, 0, 0, 0, int64(margin), -int64(margin))
+	placeOrderTransaction.FutureId = future.Id
+
+	if err := savePlaceOrderTransaction(orderId, placeOrderTransaction, isAsk, tx); err != nil {
+		return errorHelper("Error reserving the margin. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	go sendNewFutureOrderUpdates(userId, orderId, isAsk, future.StockId, future.Id, price, quantity, placeOrderTransaction)
+
+	return orderId, nil
+}
+
+// PlaceFutureAskOrder places an ask selling contracts of a future. StockQuantity of the ask is the number of
+// contracts. It opens a short position, or closes a long one, as it trades.
+func PlaceFutureAskOrder(userId uint32, ask *Ask) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "PlaceFutureAskOrder",
+		"param_id":  userId,
+		"param_ask": fmt.Sprintf("%+v", ask),
+	})
+
+	l.Infof("PlaceFutureAskOrder requested")
+
+	isPlain := !ask.IsIceberg && ask.TrailingAmount == 0 && ask.TrailingPercent == 0 && ask.GroupId == 0
+	future, err := checkFutureOrder(ask.FutureId, ask.OrderType, ask.TimeInForce, ask.ExpiresOnDay, ask.Price, ask.StockQuantity, ASK_LIMIT, isPlain)
+	if err != nil {
+		l.Debugf("Future order check failed: %+v", err)
+		return 0, err
+	}
+	ask.StockId = future.StockId
+
+	orderId, err := placeFutureOrder(userId, future, true, ask.Price, ask.StockQuantity, func(tx *gorm.DB) (uint32, error) {
+		err := createAsk(ask, tx)
+		return ask.Id, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	l.Infof("Placed ask %d selling %d contracts", orderId, ask.StockQuantity)
+	return orderId, nil
+}
+
+// PlaceFutureBidOrder places a bid buying contracts of a future. StockQuantity of the bid is the number of
+// contracts. It opens a long position, or closes a short one, as it trades.
+func PlaceFutureBidOrder(userId uint32, bid *Bid) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "PlaceFutureBidOrder",
+		"param_id":  userId,
+		"param_bid": fmt.Sprintf("%+v", bid),
+	})
+
+	l.Infof("PlaceFutureBidOrder requested")
+
+	isPlain := !bid.IsIceberg && bid.TrailingAmount == 0 && bid.TrailingPercent == 0 && bid.GroupId == 0
+	future, err := checkFutureOrder(bid.FutureId, bid.OrderType, bid.TimeInForce, bid.ExpiresOnDay, bid.Price, bid.StockQuantity, BID_LIMIT, isPlain)
+	if err != nil {
+		l.Debugf("Future order check failed: %+v", err)
+		return 0, err
+	}
+	bid.StockId = future.StockId
+
+	orderId, err := placeFutureOrder(userId, future, false, bid.Price, bid.StockQuantity, func(tx *gorm.DB) (uint32, error) {
+		err := createBid(bid, tx)
+		return bid.Id, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	l.Infof("Placed bid %d buying %d contracts", orderId, bid.StockQuantity)
+	return orderId, nil
+}
+
+// saveFutureAskCancelTransaction returns the margin reserved for the unfulfilled part of a closed future ask.
+// Bids of futures reserve cash like bids of stocks, and are cancelled the same way.
+func saveFutureAskCancelTransaction(askOrder *Ask, user *User, tx *gorm.DB) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":  "saveFutureAskCancelTransaction",
+		"userId":  user.Id,
+		"orderId": askOrder.Id,
+	})
+
+	reservedCash, _, err := getPlaceOrderTransactionDetails(askOrder.Id, true)
+	if err != nil {
+		l.Errorf("Could not retrieve reserved cash. Error: %+v", err)
+		return err
+	}
+
+	reservedCash = getReservedCashLeft(reservedCash, askOrder.StockQuantity, askOrder.StockQuantityFulfilled)
+	cancelOrderTransaction := GetTransactionRef(user.Id, askOrder.StockId, CancelOrderTransaction, 0, 0, 0, -reservedCash, reservedCash)
+	cancelOrderTransaction.FutureId = askOrder.FutureId
+
+	user.Cash += uint64(reservedCash)
+	user.ReservedCash -= uint64(reservedCash)
+
+	if err := tx.Save(user).Error; err != nil {
+		user.Cash -= uint64(reservedCash)
+		user.ReservedCash += uint64(reservedCash)
+		l.Errorf("Error while adding reserved cash back to user. Error: %+v", err)
+		return err
+	}
+
+	if err := saveTransaction(tx, cancelOrderTransaction); err != nil {
+		user.Cash -= uint64(reservedCash)
+		user.ReservedCash += uint64(reservedCash)
+		l.Errorf("Error while saving cancelOrderTransaction %+v", err)
+		return err
+	}
+
+	go func(cancelOrderTransaction *Transaction) {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(cancelOrderTransaction.ToProto())
+
+		l.Infof("Sent through the datastreams")
+	}(cancelOrderTransaction)
+
+	return nil
+}
+
+// tradeFuturePosition adds quantity contracts traded at price to a user's position in a future, and returns the
+// change in the cash the user has reserved. quantity is negative for contracts sold. orderReserved is the cash
+// the order reserved for the contracts. It's released, along with the margin of the contracts the trade closes,
+// and contracts the trade opens get their own margin out of it. If the user can't pay for all of that margin,
+// the position holds what they could pay.
+func tradeFuturePosition(user *User, position *FuturePosition, lotSize, price uint64, quantity int64, orderReserved uint64) int64 {
+	rebaseFuturePosition(position, lotSize, price)
+
+	held, traded := position.Quantity, quantity
+	if held < 0 {
+		held, traded = -held, -traded
+	}
+
+	// contracts traded the other way from the position close it first
+	var closed int64
+	if held > 0 && traded < 0 {
+		closed = -traded
+		if closed > held {
+			closed = held
+		}
+	}
+
+	released := orderReserved
+	if closed != 0 {
+		fromPosition := position.Margin * uint64(closed) / uint64(held)
+		position.Margin -= fromPosition
+		released += fromPosition
+	}
+
+	opened := traded
+	if opened < 0 {
+		opened = -opened
+	}
+	opened -= closed
+
+	added := minUint64(getFutureMargin(opened, lotSize, price), user.Cash+released)
+	position.Margin += added
+	position.Quantity += quantity
+
+	user.Cash = user.Cash + released - added
+	if released > user.ReservedCash {
+		user.ReservedCash = added
+	} else {
+		user.ReservedCash = user.ReservedCash - released + added
+	}
+
+	return int64(added) - int64(released)
+}
+
+// performFutureFillTransaction trades quantity contracts of a future between an ask and a bid at price. It's called
+// by PerformOrderFillTransaction for future orders. The positions of both users are marked at price, and the
+// profits and losses so far are paid when the market closes. No cash changes hands till then, apart from
+// the margins. Futures pay no fees or taxes, and don't move the price of their underlying.
+func performFutureFillTransaction(ask *Ask, bid *Bid, price uint64, quantity uint64) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "performFutureFillTransaction",
+		"askingUserId":  ask.UserId,
+		"biddingUserId": bid.UserId,
+		"futureId":      ask.FutureId,
+	})
+
+	l.Infof("Attempting")
+
+	done, askingUser, biddingUser, err := getUserPairExclusive(ask.UserId, bid.UserId)
+	if err != nil {
+		l.Errorf("Unable to acquire locks on the user pair: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	defer close(done)
+
+	askStatus := AskUndone
+	bidStatus := BidUndone
+
+	if ask.IsClosed {
+		askStatus = AskAlreadyClosed
+	}
+	if bid.IsClosed {
+		bidStatus = BidAlreadyClosed
+	}
+
+	if askStatus == AskAlreadyClosed || bidStatus == BidAlreadyClosed {
+		l.Infof("Done. One of the orders already closed. %d and %d", askStatus, bidStatus)
+		return askStatus, bidStatus, nil
+	}
+
+	db := getDB()
+
+	future, err := getFutureContract(db, ask.FutureId)
+	if err != nil {
+		l.Errorf("Error while loading the future: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+
+	reservedCashForAsk, _, err := getPlaceOrderTransactionDetails(ask.Id, true)
+	if err != nil {
+		l.Errorf("Error while getting the cash reserved for the ask. Error: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	reservedCashForBid, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+	if err != nil {
+		l.Errorf("Error while getting the cash reserved for the bid. Error: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	askReservedForTrade := getReservedCashLeft(reservedCashForAsk, ask.StockQuantity, ask.StockQuantityFulfilled) -
+		getReservedCashLeft(reservedCashForAsk, ask.StockQuantity, ask.StockQuantityFulfilled+quantity)
+	bidReservedForTrade := getReservedCashLeft(reservedCashForBid, bid.StockQuantity, bid.StockQuantityFulfilled) -
+		getReservedCashLeft(reservedCashForBid, bid.StockQuantity, bid.StockQuantityFulfilled+quantity)
+
+	askingPosition, err := getFuturePosition(db, ask.UserId, ask.FutureId)
+	if err != nil {
+		l.Errorf("Error while loading the asking user's position: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	biddingPosition, err := getFuturePosition(db, bid.UserId, bid.FutureId)
+	if err != nil {
+		l.Errorf("Error while loading the bidding user's position: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+
+	// in case things go wrong and we've to roll back
+	askingUserOldCash, askingUserOldReservedCash := askingUser.Cash, askingUser.ReservedCash
+	biddingUserOldCash, biddingUserOldReservedCash := biddingUser.Cash, biddingUser.ReservedCash
+	oldAskingPosition, oldBiddingPosition := *askingPosition, *biddingPosition
+	oldAskStockQuantityFulfilled := ask.StockQuantityFulfilled
+	oldBidStockQuantityFulfilled := bid.StockQuantityFulfilled
+	oldAskIsClosed := ask.IsClosed
+	oldBidIsClosed := bid.IsClosed
+
+	askReservedChange := tradeFuturePosition(askingUser, askingPosition, future.LotSize, price, -int64(quantity), uint64(askReservedForTrade))
+	bidReservedChange := tradeFuturePosition(biddingUser, biddingPosition, future.LotSize, price, int64(quantity), uint64(bidReservedForTrade))
+
+	askTransaction := GetTransactionRef(ask.UserId, ask.StockId, FutureTradeTransaction, 0, 0, price, askReservedChange, -askReservedChange)
+	bidTransaction := GetTransactionRef(bid.UserId, bid.StockId, FutureTradeTransaction, 0, 0, price, bidReservedChange, -bidReservedChange)
+	askTransaction.FutureId = ask.FutureId
+	bidTransaction.FutureId = bid.FutureId
+
+	askingPosition.UpdatedAt = utils.GetCurrentTimeISO8601()
+	biddingPosition.UpdatedAt = askingPosition.UpdatedAt
+
+	ask.StockQuantityFulfilled += quantity
+	bid.StockQuantityFulfilled += quantity
+	ask.IsClosed = ask.StockQuantity == ask.StockQuantityFulfilled
+	bid.IsClosed = bid.StockQuantity == bid.StockQuantityFulfilled
+
+	tx := db.Begin()
+	txStart := time.Now()
+
+	var revertToOldState = func(format string, err error) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
+		l.Errorf(format, err)
+		askingUser.Cash, askingUser.ReservedCash = askingUserOldCash, askingUserOldReservedCash
+		biddingUser.Cash, biddingUser.ReservedCash = biddingUserOldCash, biddingUserOldReservedCash
+		*askingPosition, *biddingPosition = oldAskingPosition, oldBiddingPosition
+
+		ask.StockQuantityFulfilled = oldAskStockQuantityFulfilled
+		bid.StockQuantityFulfilled = oldBidStockQuantityFulfilled
+		ask.IsClosed = oldAskIsClosed
+		bid.IsClosed = oldBidIsClosed
+
+		tx.Rollback()
+		orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "rolled_back")
+		return AskUndone, BidUndone, nil
+	}
+
+	if err := saveTransaction(tx, askTransaction); err != nil {
+		return revertToOldState("Error creating the askTransaction. Rolling back. Error: %+v", err)
+	}
+	if err := saveTransaction(tx, bidTransaction); err != nil {
+		return revertToOldState("Error creating the bidTransaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(askingUser).Error; err != nil {
+		return revertToOldState("Error updating askingUser. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(biddingUser).Error; err != nil {
+		return revertToOldState("Error updating biddingUser. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(askingPosition).Error; err != nil {
+		return revertToOldState("Error updating the asking user's position. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(biddingPosition).Error; err != nil {
+		return revertToOldState("Error updating the bidding user's position. Rolling back. Error: %+v", err)
+	}
+	if err := saveAsk(ask, tx); err != nil {
+		return revertToOldState("Error updating ask.{StockQuantityFulfilled,IsClosed}. Rolling back. Error: %+v", err)
+	}
+	if err := saveBid(bid, tx); err != nil {
+		return revertToOldState("Error updating bid.{StockQuantityFulfilled,IsClosed}. Rolling back. Error: %+v", err)
+	}
+
+	of := &OrderFill{
+		AskId:         ask.Id,
+		BidId:         bid.Id,
+		TransactionId: askTransaction.Id,
+	}
+	if err := tx.Save(of).Error; err != nil {
+		return revertToOldState("Error saving an orderfill. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return revertToOldState("Error committing transaction. Rolling back. Error: %+v", err)
+	}
+	orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "committed")
+
+	go func() {
+		myOrdersStream := datastreamsManager.GetMyOrdersStream()
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+
+		myOrdersStream.SendOrder(ask.UserId, &datastreams_pb.MyOrderUpdate{
+			Id:            ask.Id,
+			IsAsk:         true,
+			StockQuantity: ask.StockQuantity,
+			TradeQuantity: quantity,
+			IsClosed:      ask.IsClosed,
+		})
+		myOrdersStream.SendOrder(bid.UserId, &datastreams_pb.MyOrderUpdate{
+			Id:            bid.Id,
+			IsAsk:         false,
+			StockQuantity: bid.StockQuantity,
+			TradeQuantity: quantity,
+			IsClosed:      bid.IsClosed,
+		})
+
+		transactionsStream.SendTransaction(askTransaction.ToProto())
+		transactionsStream.SendTransaction(bidTransaction.ToProto())
+
+		l.Infof("Sent through the datastreams")
+	}()
+
+	l.Infof("Transaction committed successfully. Traded %d contracts at %d.", quantity, price)
+
+	if ask.IsClosed {
+		askStatus = AskDone
+	}
+	if bid.IsClosed {
+		bidStatus = BidDone
+	}
+
+	// The order book reads the traded quantity off the ask's transaction, like for fills of stocks.
+	// A copy is handed to it, as the saved transaction doesn't move any stocks.
+	trade := *askTransaction
+	trade.StockQuantity = -int64(quantity)
+	return askStatus, bidStatus, &trade
+}
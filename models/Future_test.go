@@ -0,0 +1,283 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetFutureProfit(t *testing.T) {
+	var tests = []struct {
+		quantity  int64
+		lotSize   uint64
+		markPrice uint64
+		price     uint64
+		profit    int64
+	}{
+		{2, 10, 100, 130, 600},
+		{2, 10, 100, 70, -600},
+		{-2, 10, 100, 130, -600},
+		{-2, 10, 100, 70, 600},
+		{0, 10, 100, 130, 0},
+	}
+
+	for _, test := range tests {
+		if profit := getFutureProfit(test.quantity, test.lotSize, test.markPrice, test.price); profit != test.profit {
+			t.Errorf("getFutureProfit(%+v) = %d, expected %d", test, profit, test.profit)
+		}
+	}
+}
+
+func TestGetFutureMargin(t *testing.T) {
+	oldMarginPercent := config.FuturesInitialMarginPercent
+	defer func() {
+		config.FuturesInitialMarginPercent = oldMarginPercent
+	}()
+
+	config.FuturesInitialMarginPercent = 20
+
+	if margin := getFutureMargin(3, 10, 100); margin != 600 {
+		t.Errorf("getFutureMargin(3, 10, 100) = %d, expected 600", margin)
+	}
+	if margin := getFutureMargin(-3, 10, 100); margin != 600 {
+		t.Errorf("getFutureMargin(-3, 10, 100) = %d, expected 600", margin)
+	}
+}
+
+func TestCollectVariationMargin(t *testing.T) {
+	var tests = []struct {
+		cash       uint64
+		margin     uint64
+		loss       uint64
+		fromCash   uint64
+		fromMargin uint64
+	}{
+		{1000, 600, 300, 300, 0},
+		{200, 600, 500, 200, 300},
+		{200, 600, 1000, 200, 600},
+		{0, 0, 1000, 0, 0},
+	}
+
+	for _, test := range tests {
+		fromCash, fromMargin := collectVariationMargin(test.cash, test.margin, test.loss)
+		if fromCash != test.fromCash || fromMargin != test.fromMargin {
+			t.Errorf("collectVariationMargin(%+v) = (%d, %d), expected (%d, %d)", test, fromCash, fromMargin, test.fromCash, test.fromMargin)
+		}
+	}
+}
+
+func TestShareVariationMargin(t *testing.T) {
+	var tests = []struct {
+		profits   []uint64
+		collected uint64
+		paid      []uint64
+	}{
+		{[]uint64{300, 100}, 400, []uint64{300, 100}},
+		{[]uint64{300, 100}, 200, []uint64{150, 50}},
+		{[]uint64{100, 100, 100}, 100, []uint64{34, 33, 33}},
+		{[]uint64{100}, 0, []uint64{0}},
+		{nil, 0, []uint64{}},
+		{[]uint64{1 << 62, 1 << 62}, 1 << 62, []uint64{1 << 61, 1 << 61}},
+	}
+
+	for _, test := range tests {
+		paid := shareVariationMargin(test.profits, test.collected)
+		if !reflect.DeepEqual(paid, test.paid) {
+			t.Errorf("shareVariationMargin(%v, %d) = %v, expected %v", test.profits, test.collected, paid, test.paid)
+		}
+	}
+}
+
+func TestTradeFuturePosition(t *testing.T) {
+	oldMarginPercent := config.FuturesInitialMarginPercent
+	defer func() {
+		config.FuturesInitialMarginPercent = oldMarginPercent
+	}()
+
+	config.FuturesInitialMarginPercent = 20
+
+	var tests = []struct {
+		name          string
+		cash          uint64
+		reserved      uint64
+		position      FuturePosition
+		quantity      int64
+		orderReserved uint64
+		// expected
+		cash2          uint64
+		reserved2      uint64
+		position2      FuturePosition
+		reservedChange int64
+	}{
+		{
+			name: "opens a position", cash: 1000, reserved: 400,
+			position: FuturePosition{}, quantity: 2, orderReserved: 400,
+			cash2: 1000, reserved2: 400, position2: FuturePosition{Quantity: 2, MarkPrice: 100, Margin: 400}, reservedChange: 0,
+		},
+		{
+			name: "adds to a position at a higher price", cash: 1000, reserved: 800,
+			position: FuturePosition{Quantity: 2, MarkPrice: 100, Margin: 400}, quantity: 2, orderReserved: 400,
+			cash2: 960, reserved2: 840, position2: FuturePosition{Quantity: 4, MarkPrice: 110, Margin: 840, RealisedProfit: 200}, reservedChange: 40,
+		},
+		{
+			name: "closes half a position", cash: 1000, reserved: 800,
+			position: FuturePosition{Quantity: 4, MarkPrice: 100, Margin: 800}, quantity: -2, orderReserved: 400,
+			cash2: 1800, reserved2: 0, position2: FuturePosition{Quantity: 2, MarkPrice: 90, Margin: 400, RealisedProfit: -400}, reservedChange: -800,
+		},
+		{
+			name: "goes from long to short", cash: 0, reserved: 1000,
+			position: FuturePosition{Quantity: 2, MarkPrice: 100, Margin: 400}, quantity: -3, orderReserved: 600,
+			cash2: 800, reserved2: 200, position2: FuturePosition{Quantity: -1, MarkPrice: 100, Margin: 200}, reservedChange: -800,
+		},
+		{
+			name: "holds what the user can pay", cash: 0, reserved: 300,
+			position: FuturePosition{}, quantity: -2, orderReserved: 300,
+			cash2: 0, reserved2: 300, position2: FuturePosition{Quantity: -2, MarkPrice: 120, Margin: 300}, reservedChange: 0,
+		},
+	}
+
+	prices := []uint64{100, 110, 90, 100, 120}
+
+	for i, test := range tests {
+		user := &User{Cash: test.cash, ReservedCash: test.reserved}
+		position := test.position
+
+		reservedChange := tradeFuturePosition(user, &position, 10, prices[i], test.quantity, test.orderReserved)
+
+		if reservedChange != test.reservedChange {
+			t.Errorf("%s: reserved cash changed by %d, expected %d", test.name, reservedChange, test.reservedChange)
+		}
+		if user.Cash != test.cash2 || user.ReservedCash != test.reserved2 {
+			t.Errorf("%s: user has %d cash and %d reserved, expected %d and %d", test.name, user.Cash, user.ReservedCash, test.cash2, test.reserved2)
+		}
+		if position != test.position2 {
+			t.Errorf("%s: position is %+v, expected %+v", test.name, position, test.position2)
+		}
+	}
+}
+
+func Test_MarkFuturesToMarket(t *testing.T) {
+	oldMarginPercent := config.FuturesInitialMarginPercent
+	defer func() {
+		config.FuturesInitialMarginPercent = oldMarginPercent
+	}()
+	config.FuturesInitialMarginPercent = 20
+
+	shortUser := &User{Id: 2, Cash: 10000}
+	longUser := &User{Id: 3, Cash: 10000}
+	stock := &Stock{Id: 1, CurrentPrice: 110}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderFills")
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Asks")
+		db.Exec("DELETE FROM Bids")
+		db.Exec("DELETE FROM FuturePositions")
+		db.Exec("DELETE FROM FutureContracts")
+		db.Delete(shortUser)
+		db.Delete(longUser)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+		delete(userLocks.m, 3)
+	}()
+
+	for _, user := range []*User{shortUser, longUser} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	future, err := ListFuture(stock.Id, 10, GetMarketDay()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ask := &Ask{UserId: shortUser.Id, FutureId: future.Id, OrderType: Limit, Price: 100, StockQuantity: 2}
+	bid := &Bid{UserId: longUser.Id, FutureId: future.Id, OrderType: Limit, Price: 100, StockQuantity: 2}
+	if _, err := PlaceFutureAskOrder(shortUser.Id, ask); err != nil {
+		t.Fatalf("Did not expect error while placing the ask. Error: %+v", err)
+	}
+	if _, err := PlaceFutureBidOrder(longUser.Id, bid); err != nil {
+		t.Fatalf("Did not expect error while placing the bid. Error: %+v", err)
+	}
+
+	askStatus, bidStatus, tr := PerformOrderFillTransaction(ask, bid, 100, 2, false, true)
+	if askStatus != AskDone || bidStatus != BidDone || tr == nil {
+		t.Fatalf("Expected both orders to be filled, got %v and %v", askStatus, bidStatus)
+	}
+
+	checkUser := func(userId uint32, cash, reservedCash uint64, quantity int64, markPrice uint64) {
+		t.Helper()
+		u := &User{}
+		if err := db.First(u, userId).Error; err != nil {
+			t.Fatal(err)
+		}
+		position, err := getFuturePosition(db, userId, future.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Cash != cash || u.ReservedCash != reservedCash || position.Quantity != quantity {
+			t.Errorf("User %d has %d cash, %d reserved and %d contracts; want %d, %d and %d", userId, u.Cash, u.ReservedCash, position.Quantity, cash, reservedCash, quantity)
+		}
+		testutils.AssertEqual(t, markPrice, position.MarkPrice)
+		testutils.AssertEqual(t, int64(0), position.RealisedProfit)
+	}
+
+	// the margins of the orders become the margins of the positions, and no other cash changes hands
+	checkUser(shortUser.Id, 9600, 400, -2, 100)
+	checkUser(longUser.Id, 9600, 400, 2, 100)
+
+	// the short pays the long for the rise from 100 to 110
+	MarkFuturesToMarket()
+
+	checkUser(shortUser.Id, 9400, 400, -2, 110)
+	checkUser(longUser.Id, 9800, 400, 2, 110)
+
+	// settling at the same price only releases the margins
+	if err := db.Model(future).Update("expiryDay", GetMarketDay()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SettleFuture(future.Id); err != nil {
+		t.Fatalf("Did not expect error while settling. Error: %+v", err)
+	}
+	checkUser(shortUser.Id, 9800, 0, 0, 110)
+	checkUser(longUser.Id, 10200, 0, 0, 110)
+
+	type row struct {
+		UserId            uint32
+		Type              string
+		ReservedCashTotal int64
+		Total             int64
+	}
+	var saved []row
+	if err := db.Raw("SELECT userId AS user_id, type, reservedCashTotal AS reserved_cash_total, total FROM Transactions WHERE futureId = ? ORDER BY id", future.Id).Scan(&saved).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []row{
+		{2, PlaceOrderTransaction.String(), 400, -400},
+		{3, PlaceOrderTransaction.String(), 400, -400},
+		{2, FutureTradeTransaction.String(), 0, 0},
+		{3, FutureTradeTransaction.String(), 0, 0},
+		{2, FutureVariationMarginTransaction.String(), 0, -200},
+		{3, FutureVariationMarginTransaction.String(), 0, 200},
+		{2, FutureSettlementTransaction.String(), -400, 400},
+		{3, FutureSettlementTransaction.String(), -400, 400},
+	}
+	if len(saved) != len(expected) {
+		t.Fatalf("Expected %d transactions, got %d", len(expected), len(saved))
+	}
+	for i, e := range expected {
+		if saved[i] != e {
+			t.Errorf("Transaction %d: got %+v, want %+v", i, saved[i], e)
+		}
+	}
+}
@@ -9,7 +9,8 @@ import (
 type GameStateType uint8
 
 type MarketState struct {
-	IsMarketOpen bool
+	IsMarketOpen         bool
+	IsCallAuctionRunning bool
 }
 
 // StockDividendState determines if a company gives dividend
@@ -44,6 +45,13 @@ type UserRewardCredit struct {
 	Cash uint64
 }
 
+// StockHaltState determines if trading in a stock has been halted by its circuit breaker
+type StockHaltState struct {
+	StockId   uint32
+	IsHalted  bool
+	ResumesAt string
+}
+
 var gameStateTypes = [...]string{
 	"MarketStateUpdate",
 	"StockDividendStateUpdate",
@@ -53,6 +61,7 @@ var gameStateTypes = [...]string{
 	"UserReferredCreditUpdate",
 	"DailyChallengeStatusUpdate",
 	"UserRewardCreditUpdate",
+	"StockHaltStateUpdate",
 }
 
 const (
@@ -64,6 +73,7 @@ const (
 	UserReferredCreditUpdate
 	DailyChallengeStatusUpdate
 	UserRewardCreditUpdate
+	StockHaltStateUpdate
 )
 
 func (gsType GameStateType) String() string {
@@ -82,6 +92,7 @@ type GameState struct {
 	Uc     *UserReferredCredit
 	Dc     *DailyChallengeStatus
 	Ur     *UserRewardCredit
+	Sh     *StockHaltState
 }
 
 func (g *GameState) ToProto() *models_pb.GameState {
@@ -92,7 +103,8 @@ func (g *GameState) ToProto() *models_pb.GameState {
 	if g.GsType == MarketStateUpdate {
 		pGameState.Type = models_pb.GameStateUpdateType_MarketStateUpdate
 		pGameState.MarketState = &models_pb.MarketState{
-			IsMarketOpen: g.Ms.IsMarketOpen,
+			IsMarketOpen:         g.Ms.IsMarketOpen,
+			IsCallAuctionRunning: g.Ms.IsCallAuctionRunning,
 		}
 	} else if g.GsType == StockDividendStateUpdate {
 		pGameState.Type = models_pb.GameStateUpdateType_StockDividendStateUpdate
@@ -132,6 +144,13 @@ func (g *GameState) ToProto() *models_pb.GameState {
 		pGameState.UserRewardCredit = &models_pb.UserRewardCredit{
 			Cash: g.Ur.Cash,
 		}
+	} else if g.GsType == StockHaltStateUpdate {
+		pGameState.Type = models_pb.GameStateUpdateType_StockHaltStateUpdate
+		pGameState.StockHaltState = &models_pb.StockHaltState{
+			StockId:   g.Sh.StockId,
+			IsHalted:  g.Sh.IsHalted,
+			ResumesAt: g.Sh.ResumesAt,
+		}
 	}
 
 	return pGameState
@@ -124,7 +124,9 @@ func AllowIpoBidding(IpoStockId uint32) error {
 	return nil
 }
 
-func AllotSlots(IpoStockId uint32) error {
+// AllotSlots closes bidding for an IPO, allots its slots, and lists the stock in the market.
+// The id of the listed stock is returned.
+func AllotSlots(IpoStockId uint32) (uint32, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":           "AllotSlots",
 		"param_IpoStockId": IpoStockId,
@@ -137,15 +139,15 @@ func AllotSlots(IpoStockId uint32) error {
 	IpoStock := &IpoStock{}
 	if err := db.First(IpoStock, IpoStockId).Error; err != nil {
 		l.Error(err)
-		return err
+		return 0, err
 	}
 
 	if IpoStock == nil {
-		return InvalidStockIdError{}
+		return 0, InvalidStockIdError{}
 	}
 
 	if IpoStock.IsBiddable == false {
-		return IpoNotBiddableError{IpoStockId}
+		return 0, IpoNotBiddableError{IpoStockId}
 	}
 
 	IpoStock.IsBiddable = false
@@ -153,7 +155,7 @@ func AllotSlots(IpoStockId uint32) error {
 
 	if err := db.Save(IpoStock).Error; err != nil {
 		l.Error(err)
-		return err
+		return 0, err
 	}
 	totalslots := IpoStock.SlotQuantity
 
@@ -162,7 +164,7 @@ func AllotSlots(IpoStockId uint32) error {
 	//Load open ipoBid orders from database
 	if err := db.Where("IpoStockId = ? AND isClosed = ?", IpoStockId, 0).Find(&openIpoBids).Error; err != nil {
 		l.Error(err)
-		return err
+		return 0, err
 	}
 
 	l.Infof("Fetched %d open ipoBids for IpoStockId= %d. Attempting to allot these stocks", len(openIpoBids), IpoStockId)
@@ -202,12 +204,15 @@ func AllotSlots(IpoStockId uint32) error {
 		CreatedAt:        utils.GetCurrentTimeISO8601(),
 		GivesDividends:   false,
 		IsBankrupt:       false,
+
+		MakerFeeBasisPoints: ConfigFeeBasisPoints,
+		TakerFeeBasisPoints: ConfigFeeBasisPoints,
 	}
 	newStock.UpdatedAt = newStock.CreatedAt
 
 	if err := db.Create(newStock).Error; err != nil {
 		l.Error(err)
-		return err
+		return 0, err
 	}
 
 	cost := int64(IpoStock.SlotPrice)
@@ -217,7 +222,7 @@ func AllotSlots(IpoStockId uint32) error {
 
 			if err := allotIpoSlotToUser(ipoBid, newStock.Id, ipoBid.SlotQuantity, IpoStock.StocksPerSlot, cost, ListingPrice); err != nil {
 				l.Error(err)
-				return err
+				return 0, err
 			}
 
 		}
@@ -230,14 +235,14 @@ func AllotSlots(IpoStockId uint32) error {
 		// select 'totalslots' number of bids randomly
 		if err := db.Raw("SELECT * FROM IpoBids WHERE ipoStockId = ? AND isClosed = ? ORDER BY RAND() LIMIT ?", IpoStockId, 0, totalslots).Scan(&AllotedIpoBids).Error; err != nil {
 			l.Error(err)
-			return err
+			return 0, err
 		}
 
 		for _, AllotedIpoBid := range AllotedIpoBids {
 
 			if err := allotIpoSlotToUser(AllotedIpoBid, newStock.Id, AllotedIpoBid.SlotQuantity, IpoStock.StocksPerSlot, cost, ListingPrice); err != nil {
 				l.Error(err)
-				return err
+				return 0, err
 			}
 		}
 
@@ -246,14 +251,14 @@ func AllotSlots(IpoStockId uint32) error {
 		//Load open unfulfilled ipoBid orders from database
 		if err := db.Where("ipoStockId = ? AND isClosed = ? AND isFulfilled = ?", IpoStockId, 0, 0).Find(&UnfulIpoBids).Error; err != nil {
 			l.Error(err)
-			return err
+			return 0, err
 		}
 
 		for _, UnfulipoBid := range UnfulIpoBids {
 			//  Refund slotprice amount to userid
 			if err := RefundIpoSlotToUser(UnfulipoBid, newStock.Id, UnfulipoBid.SlotQuantity, IpoStock.StocksPerSlot, cost); err != nil {
 				l.Error(err)
-				return err
+				return 0, err
 			}
 		}
 
@@ -267,7 +272,7 @@ func AllotSlots(IpoStockId uint32) error {
 
 	if err := db.Save(newStock).Error; err != nil {
 		l.Error(err)
-		return err
+		return 0, err
 	}
 
 	SendPushNotification(0, PushNotification{
@@ -279,7 +284,7 @@ func AllotSlots(IpoStockId uint32) error {
 
 	LoadStocks() // reload stocks (usually called when market day opens)
 
-	return nil
+	return newStock.Id, nil
 }
 
 func allotIpoSlotToUser(ipoBid *IpoBid, newStockId, SlotQuantity, StocksPerSlot uint32, cost int64, stockPrice uint64) error {
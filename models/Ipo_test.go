@@ -198,7 +198,7 @@ func Test_AllotIpoSlots(t *testing.T) {
 	}
 	fmt.Printf("IpoBids ids = %d  %d  %d  %d  %d  %d \n", ipoBidId1, ipoBidId2, ipoBidId3, ipoBidId4, ipoBidId5, ipoBidId6)
 
-	if err := AllotSlots(ipoStockid); err != nil {
+	if _, err := AllotSlots(ipoStockid); err != nil {
 		t.Fatal(err)
 	}
 	var FulfilledIpoBids []*IpoBid
@@ -260,7 +260,7 @@ func Test_AllotIpoSlots(t *testing.T) {
 	}
 	fmt.Printf("IpoBids ids = %d  %d  %d  %d  %d  %d \n", ipoBidId1, ipoBidId2, ipoBidId3, ipoBidId4, ipoBidId5, ipoBidId6)
 
-	if err := AllotSlots(ipoStockid); err != nil {
+	if _, err := AllotSlots(ipoStockid); err != nil {
 		t.Fatal(err)
 	}
 
@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	models_pb "github.com/delta/dalal-street-server/proto_build/models"
@@ -140,6 +141,38 @@ func UpdateLeaderboard() {
 	`, config.MaxBlockCount)
 	tx.Raw(query).Scan(&results)
 
+	// profits of futures positions since they were last marked to market count towards the total too
+	futuresProfits, err := getFuturesProfits()
+	if err != nil {
+		l.Errorf("Error getting profits of futures positions. Failing. %+v", err)
+		tx.Rollback()
+		return
+	}
+	// margin loans are part of the cash, so what's owed on them is taken off the total
+	marginDebts, err := getMarginDebts(tx)
+	if err != nil {
+		l.Errorf("Error getting margin debts. Failing. %+v", err)
+		tx.Rollback()
+		return
+	}
+	// the borrow fees left unpaid on short sell lends are taken off too
+	unpaidBorrowFees, err := getUnpaidBorrowFees(tx)
+	if err != nil {
+		l.Errorf("Error getting unpaid borrow fees. Failing. %+v", err)
+		tx.Rollback()
+		return
+	}
+	if len(futuresProfits) > 0 || len(marginDebts) > 0 || len(unpaidBorrowFees) > 0 {
+		for i := range results {
+			results[i].Total += futuresProfits[results[i].UserId]
+			results[i].Total -= int64(marginDebts[results[i].UserId])
+			results[i].Total -= int64(unpaidBorrowFees[results[i].UserId])
+		}
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Total > results[j].Total
+		})
+	}
+
 	var rank = 1
 	var counter = 1
 
@@ -150,7 +183,7 @@ func UpdateLeaderboard() {
 			UserName:   result.UserName,
 			Cash:       result.Cash,
 			Rank:       uint32(rank),
-			Debt:       0,
+			Debt:       marginDebts[result.UserId],
 			StockWorth: result.StockWorth,
 			TotalWorth: result.Total,
 			IsBlocked:  result.IsBlocked,
@@ -0,0 +1,302 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Liquidation records a bankrupt stock that was wound up. LiquidationValue was paid for every stock of it
+// held, after which it was delisted.
+type Liquidation struct {
+	Id               uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId          uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	LiquidationValue uint64 `gorm:"column:liquidationValue;not null" json:"liquidation_value"`
+	CreatedAt        string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (Liquidation) TableName() string {
+	return "Liquidations"
+}
+
+func (lq *Liquidation) ToProto() *models_pb.Liquidation {
+	return &models_pb.Liquidation{
+		Id:               lq.Id,
+		StockId:          lq.StockId,
+		LiquidationValue: lq.LiquidationValue,
+		CreatedAt:        lq.CreatedAt,
+	}
+}
+
+// liquidatedHolding is what a user got for their holding of the liquidated stock
+type liquidatedHolding struct {
+	userId       uint32
+	quantity     int64
+	stocksInBank uint64
+	cash         int64
+}
+
+// getLiquidationPayout returns the cash paid for a holding of quantity stocks at liquidationValue a stock.
+// Short sellers pay it instead, as far as their cash goes.
+func getLiquidationPayout(quantity int64, liquidationValue, cash uint64) int64 {
+	payout := quantity * int64(liquidationValue)
+	if payout < 0 && uint64(-payout) > cash {
+		return -int64(cash)
+	}
+	return payout
+}
+
+// getMortgageSurplus returns what's left for the user once the bank sells stocksInBank stocks mortgaged at
+// mortgagePrice for liquidationValue each, and takes back what retrieving them would have cost
+func getMortgageSurplus(stocksInBank, mortgagePrice, liquidationValue uint64) uint64 {
+	proceeds := stocksInBank * liquidationValue
+	owed := mortgagePrice * stocksInBank * MORTGAGE_RETRIEVE_RATE / 100
+	if proceeds <= owed {
+		return 0
+	}
+	return proceeds - owed
+}
+
+// checkStockLiquidation checks if a stock can be liquidated
+func checkStockLiquidation(stockId uint32) error {
+	stock, err := GetStockCopy(stockId)
+	if err != nil || stock.IsDelisted {
+		return InvalidStockIdError{}
+	}
+	if !stock.IsBankrupt {
+		return CorporateActionError{"Only a bankrupt stock can be liquidated."}
+	}
+
+	return checkNoOpenContracts(stockId)
+}
+
+// LiquidateStock winds up a bankrupt stock. It goes through everything that holds stocks of it:
+//  1. Its open orders get cancelled, which refunds their reserved cash and stocks.
+//  2. Every holder gets liquidationValue for each stock through a LiquidationTransaction. Short sellers
+//     pay it instead, as far as their cash goes, and their lends are squared off.
+//  3. Mortgages are closed. The bank sells the stocks in mortgage, and pays the user whatever is left
+//     after taking back what retrieving them would have cost.
+//  4. The stock is delisted. Its stock history is kept for charts.
+//
+// Trading in the stock must be stopped meanwhile, so it should be called through the matching engine,
+// which drops its order book afterwards.
+func LiquidateStock(stockId uint32, liquidationValue uint64) (*Liquidation, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":                 "LiquidateStock",
+		"param_stockId":          stockId,
+		"param_liquidationValue": liquidationValue,
+	})
+
+	l.Infof("Attempting")
+
+	if err := checkStockLiquidation(stockId); err != nil {
+		l.Errorf("Checks failed: %+v", err)
+		return nil, err
+	}
+
+	change := startMarketIndexChange()
+	defer change.end()
+
+	if err := cancelOpenOrders(stockId); err != nil {
+		return nil, err
+	}
+
+	db := getDB()
+
+	var holdings []*stockHolding
+	sql := "SELECT userId AS user_id, SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity FROM Transactions WHERE stockId = ? GROUP BY userId HAVING stock_quantity != 0 OR reserved_stock_quantity != 0"
+	if err := db.Raw(sql, stockId).Scan(&holdings).Error; err != nil {
+		l.Errorf("Unable to get the holdings: %+v", err)
+		return nil, err
+	}
+
+	var mortgages []*mortgagedStocks
+	sql = "SELECT userId AS user_id, stocksInBank AS stocks_in_bank, mortgagePrice AS mortgage_price FROM MortgageDetails WHERE stockId = ?"
+	if err := db.Raw(sql, stockId).Scan(&mortgages).Error; err != nil {
+		l.Errorf("Unable to get the mortgages: %+v", err)
+		return nil, err
+	}
+
+	holdingOf := make(map[uint32]*stockHolding)
+	for _, holding := range holdings {
+		holdingOf[holding.UserId] = holding
+	}
+
+	stocksInBank := make(map[uint32]uint64)
+	mortgageSurplus := make(map[uint32]uint64)
+	for _, mortgage := range mortgages {
+		if _, ok := holdingOf[mortgage.UserId]; !ok {
+			holdingOf[mortgage.UserId] = &stockHolding{UserId: mortgage.UserId}
+		}
+		stocksInBank[mortgage.UserId] += mortgage.StocksInBank
+		mortgageSurplus[mortgage.UserId] += getMortgageSurplus(mortgage.StocksInBank, mortgage.MortgagePrice, liquidationValue)
+	}
+
+	userIds := holderIds(holdingOf)
+
+	liquidation := &Liquidation{
+		StockId:          stockId,
+		LiquidationValue: liquidationValue,
+		CreatedAt:        utils.GetCurrentTimeISO8601(),
+	}
+
+	tx := db.Begin()
+
+	var transactions []*Transaction
+	var liquidated []liquidatedHolding
+	oldCash := make(map[*User]uint64)
+
+	errorHelper := func(format string, args ...interface{}) (*Liquidation, error) {
+		l.Errorf(format, args...)
+		for user, cash := range oldCash {
+			user.Cash = cash
+		}
+		tx.Rollback()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	if err := tx.Create(liquidation).Error; err != nil {
+		return errorHelper("Error recording the liquidation. Rolling back. Error: %+v", err)
+	}
+
+	for _, userId := range userIds {
+		holding := holdingOf[userId]
+
+		l.Debugf("Acquiring exclusive write on user %d", userId)
+		ch, user, err := getUserExclusively(userId)
+		if err != nil {
+			return errorHelper("Error acquiring exclusive write on user %d. Rolling back. Error: %+v", userId, err)
+		}
+		defer func(ch chan struct{}) {
+			close(ch)
+			l.Debugf("Released exclusive write on user")
+		}(ch)
+
+		quantity := holding.StockQuantity + holding.ReservedStockQuantity
+		cash := getLiquidationPayout(quantity, liquidationValue, user.Cash) + int64(mortgageSurplus[userId])
+
+		if quantity != 0 || cash != 0 {
+			transaction := GetTransactionRef(userId, stockId, LiquidationTransaction, -holding.ReservedStockQuantity, -holding.StockQuantity, liquidationValue, 0, cash)
+			if err := tx.Save(transaction).Error; err != nil {
+				return errorHelper("Error creating the transaction for user %d. Rolling back. Error: %+v", userId, err)
+			}
+			transactions = append(transactions, transaction)
+		}
+
+		if cash != 0 {
+			oldCash[user] = user.Cash
+			user.Cash = uint64(int64(user.Cash) + cash)
+			if err := tx.Save(user).Error; err != nil {
+				return errorHelper("Error paying the cash to user %d. Rolling back. Error: %+v", userId, err)
+			}
+		}
+
+		liquidated = append(liquidated, liquidatedHolding{userId, quantity, stocksInBank[userId], cash})
+
+		l.Debugf("Liquidated holdings of user %d. %d stocks, %d in mortgage, cash: %d", userId, quantity, stocksInBank[userId], cash)
+	}
+
+	if err := tx.Exec("DELETE FROM MortgageDetails WHERE stockId = ?", stockId).Error; err != nil {
+		return errorHelper("Error closing the mortgages. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Exec("UPDATE ShortSellLends SET isSquaredOff = 1 WHERE stockId = ? AND isSquaredOff = 0", stockId).Error; err != nil {
+		return errorHelper("Error squaring off the short sell lends. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Exec("DELETE FROM ShortSellBank WHERE stockId = ?", stockId).Error; err != nil {
+		return errorHelper("Error clearing the short sell bank. Rolling back. Error: %+v", err)
+	}
+
+	// positions are closed out at the liquidation value
+	var summaries []*TransactionSummary
+	if err := tx.Where("stockId = ? AND stockQuantity != 0", stockId).Find(&summaries).Error; err != nil {
+		return errorHelper("Error loading the transaction summaries. Rolling back. Error: %+v", err)
+	}
+	for _, summary := range summaries {
+		summary.RealizedGain += float64(summary.StockQuantity) * (float64(liquidationValue) - summary.Price)
+		summary.StockQuantity = 0
+		if err := tx.Save(summary).Error; err != nil {
+			return errorHelper("Error closing the transaction summary of user %d. Rolling back. Error: %+v", summary.UserId, err)
+		}
+	}
+
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[stockId]
+	allStocks.Unlock()
+	if !ok {
+		return errorHelper("Not found stock for id %d", stockId)
+	}
+
+	stockNLock.Lock()
+	defer stockNLock.Unlock()
+
+	stock := stockNLock.stock
+	oldStockCopy := *stock
+
+	stock.IsDelisted = true
+	stock.StocksInExchange = 0
+	stock.StocksInMarket = 0
+	stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	if err := tx.Save(stock).Error; err != nil {
+		*stock = oldStockCopy
+		return errorHelper("Error delisting the stock. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		*stock = oldStockCopy
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	change.changed(stockId, &oldStockCopy)
+
+	l.Infof("Liquidated stock %d. Paid out the holdings of %d users", stockId, len(liquidated))
+
+	go sendLiquidationUpdates(*stock, liquidation, transactions, liquidated)
+
+	return liquidation, nil
+}
+
+func sendLiquidationUpdates(stock Stock, liquidation *Liquidation, transactions []*Transaction, liquidated []liquidatedHolding) {
+	datastreamsManager.GetStockPricesStream().RemoveStock(stock.Id)
+
+	stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+	stockExchangeStream.SendStockExchangeUpdate(stock.Id, &datastreams_pb.StockExchangeDataPoint{
+		Price:            stock.CurrentPrice,
+		StocksInExchange: stock.StocksInExchange,
+		StocksInMarket:   stock.StocksInMarket,
+	})
+
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+	for _, transaction := range transactions {
+		transactionsStream.SendTransaction(transaction.ToProto())
+	}
+
+	for _, holding := range liquidated {
+		var text string
+		switch {
+		case holding.quantity < 0:
+			text = fmt.Sprintf("Your short position of %d shares of %s has been closed. You paid %d in cash", -holding.quantity, stock.FullName, -holding.cash)
+		case holding.quantity > 0:
+			text = fmt.Sprintf("Your %d shares of %s have been liquidated for %d in cash", holding.quantity, stock.FullName, holding.cash)
+		default:
+			text = fmt.Sprintf("Your mortgage on %s has been closed, and you got %d in cash", stock.FullName, holding.cash)
+		}
+		if holding.quantity != 0 && holding.stocksInBank > 0 {
+			text += fmt.Sprintf(". Your mortgage of %d shares has been closed too", holding.stocksInBank)
+		}
+		SendNotification(holding.userId, text+".", false)
+	}
+
+	message := fmt.Sprintf("%s has been liquidated at %d per share and delisted.", stock.FullName, liquidation.LiquidationValue)
+
+	SendPushNotification(0, PushNotification{
+		Title:   "Message from Dalal Street! A bankrupt company has been wound up.",
+		Message: message,
+		LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+	})
+	SendNotification(0, message, true)
+}
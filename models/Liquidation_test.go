@@ -0,0 +1,164 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetLiquidationPayout(t *testing.T) {
+	var tests = []struct {
+		quantity         int64
+		liquidationValue uint64
+		cash             uint64
+		payout           int64
+	}{
+		{10, 5, 0, 50},
+		{10, 0, 100, 0},
+		{-10, 5, 100, -50},
+		// what a short seller can't pay is written off
+		{-10, 5, 30, -30},
+		{0, 5, 100, 0},
+	}
+
+	for _, test := range tests {
+		if payout := getLiquidationPayout(test.quantity, test.liquidationValue, test.cash); payout != test.payout {
+			t.Errorf("getLiquidationPayout(%+v) = %d, expected %d", test, payout, test.payout)
+		}
+	}
+}
+
+func TestGetMortgageSurplus(t *testing.T) {
+	var tests = []struct {
+		stocksInBank     uint64
+		mortgagePrice    uint64
+		liquidationValue uint64
+		surplus          uint64
+	}{
+		{10, 100, 100, 100},
+		{10, 100, 90, 0},
+		{10, 100, 0, 0},
+		{0, 100, 100, 0},
+	}
+
+	for _, test := range tests {
+		if surplus := getMortgageSurplus(test.stocksInBank, test.mortgagePrice, test.liquidationValue); surplus != test.surplus {
+			t.Errorf("getMortgageSurplus(%+v) = %d, expected %d", test, surplus, test.surplus)
+		}
+	}
+}
+
+func Test_LiquidateStock(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 50}, {Id: 4, Cash: 1000}}
+	stock := &Stock{Id: 1, CurrentPrice: 5, StocksInExchange: 10, StocksInMarket: 20}
+	lend := &ShortSellLends{UserId: 3, StockId: 1, StockQuantity: 10}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM MortgageDetails")
+		db.Exec("DELETE FROM ShortSellLends")
+		db.Exec("DELETE FROM Liquidations")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stock)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+	if err := db.Create(lend).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// user 2 holds 10 stocks and has mortgaged 4 more at 10, user 3 has short sold 10,
+	// and user 4 holds 3, 1 of them reserved for an ask
+	if err := db.Create(GetTransactionRef(2, stock.Id, FromExchangeTransaction, 0, 10, 5, 0, -50)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT into MortgageDetails (userId, stockId, stocksInBank, mortgagePrice) VALUES (?, ?, ?, ?)", 2, stock.Id, 4, 10).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, stock.Id, OrderFillTransaction, 0, -10, 5, 0, 50)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(4, stock.Id, FromExchangeTransaction, 0, 3, 5, 0, -15)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(4, stock.Id, PlaceOrderTransaction, 1, -1, 0, 0, 0)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LiquidateStock(stock.Id, 20); err == nil {
+		t.Fatalf("Expected liquidating a stock that isn't bankrupt to fail")
+	}
+
+	stock.IsBankrupt = true
+	if err := db.Save(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	if _, err := LiquidateStock(stock.Id, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	type liquidated struct {
+		ReservedStockQuantity int64
+		StockQuantity         int64
+		Total                 int64
+	}
+	var tests = []struct {
+		userId      uint32
+		transaction liquidated
+		cash        uint64
+	}{
+		// 200 for the 10 stocks, and 44 left over once the bank sells the 4 mortgaged stocks for 80
+		// and takes back the 36 retrieving them would have cost
+		{2, liquidated{0, -10, 244}, 1244},
+		// the short seller owes 200 but only has 50
+		{3, liquidated{0, 10, -50}, 0},
+		{4, liquidated{-1, -2, 60}, 1060},
+	}
+	for _, test := range tests {
+		var transactions []*Transaction
+		if err := db.Where("userId = ? AND type = ?", test.userId, LiquidationTransaction.String()).Find(&transactions).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(transactions) != 1 {
+			t.Fatalf("Expected 1 liquidation transaction for user %d, got %+v", test.userId, transactions)
+		}
+		got := liquidated{transactions[0].ReservedStockQuantity, transactions[0].StockQuantity, transactions[0].Total}
+		testutils.AssertEqual(t, test.transaction, got)
+
+		u := &User{}
+		db.First(u, test.userId)
+		testutils.AssertEqual(t, test.cash, u.Cash)
+	}
+
+	var count int
+	db.Table("MortgageDetails").Where("stockId = ?", stock.Id).Count(&count)
+	testutils.AssertEqual(t, 0, count)
+
+	l := &ShortSellLends{}
+	db.First(l, lend.Id)
+	testutils.AssertEqual(t, true, l.IsSquaredOff)
+
+	dbStock := &Stock{}
+	db.First(dbStock, stock.Id)
+	testutils.AssertEqual(t, true, dbStock.IsDelisted)
+	testutils.AssertEqual(t, uint64(0), dbStock.StocksInExchange)
+	testutils.AssertEqual(t, uint64(0), dbStock.StocksInMarket)
+
+	if _, err := LiquidateStock(stock.Id, 20); err == nil {
+		t.Fatalf("Expected liquidating a delisted stock to fail")
+	}
+}
@@ -0,0 +1,786 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// MarginAccount lets a user buy stocks on credit. The loan is paid out as cash, and the
+// stocks and cash of the user are the collateral for it.
+type MarginAccount struct {
+	UserId uint32 `gorm:"primary_key;column:userId" json:"user_id"`
+	Loan   uint64 `gorm:"column:loan;not null" json:"loan"`
+	// When the user was asked to add equity to the account. Empty if the account isn't in a margin call
+	MarginCallAt string `gorm:"column:marginCallAt;not null" json:"margin_call_at"`
+	// Market day interest was last charged on the loan
+	LastInterestDay uint32 `gorm:"column:lastInterestDay;not null" json:"last_interest_day"`
+	CreatedAt       string `gorm:"column:createdAt;not null" json:"created_at"`
+	UpdatedAt       string `gorm:"column:updatedAt;not null" json:"updated_at"`
+}
+
+func (MarginAccount) TableName() string {
+	return "MarginAccounts"
+}
+
+// MarginStatus is how much a margin account is worth at current prices
+type MarginStatus struct {
+	Loan uint64
+	// Cash and stocks of the user, at current prices
+	Assets int64
+	// Assets less the loan
+	Equity int64
+	// Loan the user can have at most with the equity
+	MaxLoan      uint64
+	MarginCallAt string
+}
+
+func (s *MarginStatus) ToProto() *models_pb.MarginAccount {
+	return &models_pb.MarginAccount{
+		Loan:                     s.Loan,
+		Assets:                   s.Assets,
+		Equity:                   s.Equity,
+		MaxLoan:                  s.MaxLoan,
+		MarginCallAt:             s.MarginCallAt,
+		Leverage:                 config.MarginLeverage,
+		InterestBasisPoints:      config.MarginInterestBasisPoints,
+		MaintenanceMarginPercent: config.MaintenanceMarginPercent,
+	}
+}
+
+// MarginLiquidation is a sale of a user's stocks to pay off the margin loan
+type MarginLiquidation struct {
+	UserId        uint32
+	StockId       uint32
+	StockQuantity uint64
+}
+
+// MarginAccountNotOpenError is returned when the user doesn't have a margin account
+type MarginAccountNotOpenError struct{}
+
+func (e MarginAccountNotOpenError) Error() string {
+	return "You don't have a margin account."
+}
+
+// MarginAccountAlreadyOpenError is returned when the user opens a second margin account
+type MarginAccountAlreadyOpenError struct{}
+
+func (e MarginAccountAlreadyOpenError) Error() string {
+	return "You already have a margin account."
+}
+
+// MarginLimitExceededError is returned when the user borrows more than the equity allows
+type MarginLimitExceededError struct {
+	canBorrow uint64
+}
+
+func (e MarginLimitExceededError) Error() string {
+	return fmt.Sprintf("You can borrow at most %d more.", e.canBorrow)
+}
+
+// MarginCallError is returned when the user borrows while the account is in a margin call
+type MarginCallError struct{}
+
+func (e MarginCallError) Error() string {
+	return "Your margin account is in a margin call. You can't borrow till you add equity to it."
+}
+
+// MarginLoanOutstandingError is returned when the user closes a margin account that has a loan
+type MarginLoanOutstandingError struct {
+	loan uint64
+}
+
+func (e MarginLoanOutstandingError) Error() string {
+	return fmt.Sprintf("Repay the loan of %d before closing the margin account.", e.loan)
+}
+
+// marginHolding is the quantity of a stock the user holds
+type marginHolding struct {
+	StockId uint32 `gorm:"column:stockId"`
+	// Stocks that aren't reserved for asks, and can be sold
+	Available int64 `gorm:"column:available"`
+	Total     int64 `gorm:"column:total"`
+	Price     uint64
+	// Bankrupt and halted stocks can't be sold to pay off the loan
+	IsTradable bool
+}
+
+// getMaxMarginLoan returns the most a user with the given equity can borrow
+func getMaxMarginLoan(equity int64) uint64 {
+	if equity <= 0 || config.MarginLeverage <= 1 {
+		return 0
+	}
+	return uint64(equity) * (config.MarginLeverage - 1)
+}
+
+// isBelowMaintenance tells if the equity is too small a part of the assets for the loan
+func isBelowMaintenance(assets, equity int64) bool {
+	if equity <= 0 {
+		return true
+	}
+	return equity*100 < int64(config.MaintenanceMarginPercent)*assets
+}
+
+// getLiquidationAmount returns the worth of the stocks that must be sold, and the money used to pay off
+// the loan, to bring the equity back to the maintenance margin.
+//
+// Paying off x of the loan leaves the equity as is and brings the assets down to assets-x. The equity
+// is maintenance percent of the assets again once x = assets - equity*100/maintenance.
+func getLiquidationAmount(assets, equity int64) uint64 {
+	if config.MaintenanceMarginPercent == 0 {
+		return 0
+	}
+	amount := assets - equity*100/int64(config.MaintenanceMarginPercent)
+	if amount <= 0 {
+		return 0
+	}
+	return uint64(amount)
+}
+
+// pickLiquidations returns the stocks to sell to raise amount. The biggest holdings are sold first.
+func pickLiquidations(userId uint32, holdings []marginHolding, amount uint64) []*MarginLiquidation {
+	holdings = append([]marginHolding(nil), holdings...)
+	sort.SliceStable(holdings, func(i, j int) bool {
+		return holdings[i].Available*int64(holdings[i].Price) > holdings[j].Available*int64(holdings[j].Price)
+	})
+
+	var liquidations []*MarginLiquidation
+	for _, h := range holdings {
+		if amount == 0 {
+			break
+		}
+		if !h.IsTradable || h.Available <= 0 || h.Price == 0 {
+			continue
+		}
+
+		quantity := (amount + h.Price - 1) / h.Price
+		if quantity > uint64(h.Available) {
+			quantity = uint64(h.Available)
+		}
+		if quantity > ASK_LIMIT {
+			quantity = ASK_LIMIT
+		}
+
+		liquidations = append(liquidations, &MarginLiquidation{
+			UserId:        userId,
+			StockId:       h.StockId,
+			StockQuantity: quantity,
+		})
+
+		if worth := quantity * h.Price; worth < amount {
+			amount -= worth
+		} else {
+			amount = 0
+		}
+	}
+
+	return liquidations
+}
+
+// getMarginHoldings returns the stocks the user holds, at current prices
+func getMarginHoldings(userId uint32) ([]marginHolding, error) {
+	var holdings []marginHolding
+
+	db := getDB()
+	sql := `SELECT stockId, SUM(stockQuantity) AS available, SUM(stockQuantity) + SUM(reservedStockQuantity) AS total
+		FROM Transactions WHERE userId = ? AND stockId IS NOT NULL GROUP BY stockId`
+	if err := db.Raw(sql, userId).Scan(&holdings).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range holdings {
+		stock, err := GetStockCopy(holdings[i].StockId)
+		if err != nil {
+			// delisted stocks are worth nothing
+			continue
+		}
+		holdings[i].Price = stock.CurrentPrice
+		holdings[i].IsTradable = !stock.IsBankrupt && checkStockHalted(stock.Id) == nil
+	}
+
+	return holdings, nil
+}
+
+// getMarginStatus works out the assets and equity of a user with a margin account. The user must be locked.
+func getMarginStatus(user *User, account *MarginAccount) (*MarginStatus, []marginHolding, error) {
+	holdings, err := getMarginHoldings(user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	assets := int64(user.Cash + user.ReservedCash)
+	for _, h := range holdings {
+		assets += h.Total * int64(h.Price)
+	}
+	equity := assets - int64(account.Loan)
+
+	return &MarginStatus{
+		Loan:         account.Loan,
+		Assets:       assets,
+		Equity:       equity,
+		MaxLoan:      getMaxMarginLoan(equity),
+		MarginCallAt: account.MarginCallAt,
+	}, holdings, nil
+}
+
+// getMarginAccount loads the margin account of a user
+func getMarginAccount(db *gorm.DB, userId uint32) (*MarginAccount, error) {
+	account := &MarginAccount{}
+	err := db.Where("userId = ?", userId).First(account).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, MarginAccountNotOpenError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// getMarginDebt returns what a user owes on a margin loan: the loan, and the interest for marketDay if it
+// hasn't been charged yet
+func getMarginDebt(loan uint64, lastInterestDay, marketDay uint32) uint64 {
+	if lastInterestDay >= marketDay {
+		return loan
+	}
+	return loan + loan*config.MarginInterestBasisPoints/10000
+}
+
+// getMarginDebts returns what every user with a margin loan owes on it. The borrowed cash is part of the
+// user's cash, so it has to be taken off wherever their worth is totalled.
+func getMarginDebts(db *gorm.DB) (map[uint32]uint64, error) {
+	var accounts []MarginAccount
+	if err := db.Where("loan > 0").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	debts := make(map[uint32]uint64)
+	if len(accounts) == 0 {
+		return debts, nil
+	}
+
+	marketDay := GetMarketDay()
+	for _, a := range accounts {
+		debts[a.UserId] = getMarginDebt(a.Loan, a.LastInterestDay, marketDay)
+	}
+
+	return debts, nil
+}
+
+func sendMarginTransactions(transactions ...*Transaction) {
+	go func() {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		for _, t := range transactions {
+			transactionsStream.SendTransaction(t.ToProto())
+		}
+	}()
+}
+
+// OpenMarginAccount opens a margin account for the user
+func OpenMarginAccount(userId uint32) (*MarginStatus, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "OpenMarginAccount",
+		"param_userId": userId,
+	})
+
+	l.Infof("Attempting")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return nil, err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	if _, err := getMarginAccount(db, userId); err == nil {
+		return nil, MarginAccountAlreadyOpenError{}
+	} else if _, ok := err.(MarginAccountNotOpenError); !ok {
+		l.Errorf("Unable to get margin account: %+v", err)
+		return nil, err
+	}
+
+	// interest is charged from the market day the account is opened on
+	var lastInterestDay uint32
+	if marketDay := GetMarketDay(); marketDay > 0 {
+		lastInterestDay = marketDay - 1
+	}
+
+	now := utils.GetCurrentTimeISO8601()
+	account := &MarginAccount{
+		UserId:          userId,
+		LastInterestDay: lastInterestDay,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := db.Create(account).Error; err != nil {
+		l.Errorf("Unable to create margin account: %+v", err)
+		return nil, err
+	}
+
+	status, _, err := getMarginStatus(user, account)
+	if err != nil {
+		l.Errorf("Unable to get margin status: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Done")
+	return status, nil
+}
+
+// CloseMarginAccount closes the margin account of the user. The loan must be repaid first.
+func CloseMarginAccount(userId uint32) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "CloseMarginAccount",
+		"param_userId": userId,
+	})
+
+	l.Infof("Attempting")
+
+	ch, _, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	account, err := getMarginAccount(db, userId)
+	if err != nil {
+		return err
+	}
+	if account.Loan > 0 {
+		return MarginLoanOutstandingError{account.Loan}
+	}
+
+	if err := db.Delete(account).Error; err != nil {
+		l.Errorf("Unable to delete margin account: %+v", err)
+		return err
+	}
+
+	l.Infof("Done")
+	return nil
+}
+
+// GetMarginAccount returns the margin account of the user, at current prices
+func GetMarginAccount(userId uint32) (*MarginStatus, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetMarginAccount",
+		"param_userId": userId,
+	})
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return nil, err
+	}
+	defer close(ch)
+
+	account, err := getMarginAccount(getDB(), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	status, _, err := getMarginStatus(user, account)
+	if err != nil {
+		l.Errorf("Unable to get margin status: %+v", err)
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// BorrowOnMargin lends the user cash against the margin account
+func BorrowOnMargin(userId uint32, amount uint64) (*Transaction, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "BorrowOnMargin",
+		"param_userId": userId,
+		"param_amount": amount,
+	})
+
+	l.Infof("Attempting")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return nil, err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	account, err := getMarginAccount(db, userId)
+	if err != nil {
+		return nil, err
+	}
+	if account.MarginCallAt != "" {
+		return nil, MarginCallError{}
+	}
+
+	status, _, err := getMarginStatus(user, account)
+	if err != nil {
+		l.Errorf("Unable to get margin status: %+v", err)
+		return nil, err
+	}
+	if account.Loan+amount > status.MaxLoan {
+		var canBorrow uint64
+		if status.MaxLoan > account.Loan {
+			canBorrow = status.MaxLoan - account.Loan
+		}
+		return nil, MarginLimitExceededError{canBorrow}
+	}
+
+	oldCash, oldLoan, oldUpdatedAt := user.Cash, account.Loan, account.UpdatedAt
+	user.Cash += amount
+	account.Loan += amount
+	account.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	transaction := GetTransactionRef(userId, 0, MarginTransaction, 0, 0, 0, 0, int64(amount))
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, args ...interface{}) (*Transaction, error) {
+		l.Errorf(format, args...)
+		user.Cash, account.Loan, account.UpdatedAt = oldCash, oldLoan, oldUpdatedAt
+		tx.Rollback()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	if err := saveTransaction(tx, transaction); err != nil {
+		return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(account).Error; err != nil {
+		return errorHelper("Error updating margin account. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error updating user's cash. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	sendMarginTransactions(transaction)
+
+	l.Infof("Done. Loan is now %d", account.Loan)
+	return transaction, nil
+}
+
+// RepayMarginLoan pays off the margin loan from the user's cash. At most the loan is taken.
+func RepayMarginLoan(userId uint32, amount uint64) (*Transaction, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "RepayMarginLoan",
+		"param_userId": userId,
+		"param_amount": amount,
+	})
+
+	l.Infof("Attempting")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return nil, err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	account, err := getMarginAccount(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount > account.Loan {
+		amount = account.Loan
+	}
+	if amount > user.Cash {
+		return nil, NotEnoughCashError{}
+	}
+
+	transaction, err := repayMarginLoan(user, account, amount)
+	if err != nil {
+		l.Errorf("Unable to repay loan: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Done. Loan is now %d", account.Loan)
+	return transaction, nil
+}
+
+// repayMarginLoan moves amount from the user's cash to the loan. The user must be locked and have the cash.
+func repayMarginLoan(user *User, account *MarginAccount, amount uint64) (*Transaction, error) {
+	oldCash, oldLoan, oldUpdatedAt := user.Cash, account.Loan, account.UpdatedAt
+	user.Cash -= amount
+	account.Loan -= amount
+	account.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	transaction := GetTransactionRef(user.Id, 0, MarginTransaction, 0, 0, 0, 0, -int64(amount))
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(format string, args ...interface{}) (*Transaction, error) {
+		user.Cash, account.Loan, account.UpdatedAt = oldCash, oldLoan, oldUpdatedAt
+		tx.Rollback()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	if err := saveTransaction(tx, transaction); err != nil {
+		return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(account).Error; err != nil {
+		return errorHelper("Error updating margin account. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error updating user's cash. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	sendMarginTransactions(transaction)
+
+	return transaction, nil
+}
+
+// ChargeMarginInterest charges a market day's interest on every margin loan. Interest is taken from
+// the user's cash, and whatever the cash can't cover is added to the loan. Loans already charged
+// for the market day are skipped, so closing the market twice in a day doesn't charge twice.
+func ChargeMarginInterest() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "ChargeMarginInterest",
+	})
+
+	l.Infof("Attempting")
+
+	marketDay := GetMarketDay()
+
+	var accounts []MarginAccount
+	db := getDB()
+	if err := db.Where("loan > 0 AND lastInterestDay < ?", marketDay).Find(&accounts).Error; err != nil {
+		l.Errorf("Unable to get margin accounts: %+v", err)
+		return
+	}
+
+	for _, a := range accounts {
+		if err := chargeMarginInterest(a.UserId, marketDay); err != nil {
+			l.Errorf("Unable to charge interest to user %d: %+v", a.UserId, err)
+		}
+	}
+
+	l.Infof("Charged interest on %d margin loans", len(accounts))
+}
+
+func chargeMarginInterest(userId, marketDay uint32) error {
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		return err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	account, err := getMarginAccount(db, userId)
+	if err != nil {
+		return err
+	}
+	if account.LastInterestDay >= marketDay {
+		return nil
+	}
+
+	interest := account.Loan * config.MarginInterestBasisPoints / 10000
+
+	// the part of the interest the cash can't cover is borrowed
+	var borrowed uint64
+	if interest > user.Cash {
+		borrowed = interest - user.Cash
+	}
+
+	oldCash, oldAccount := user.Cash, *account
+	user.Cash = user.Cash + borrowed - interest
+	account.Loan += borrowed
+	account.LastInterestDay = marketDay
+	account.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	var transactions []*Transaction
+	if borrowed > 0 {
+		transactions = append(transactions, GetTransactionRef(userId, 0, MarginTransaction, 0, 0, 0, 0, int64(borrowed)))
+	}
+	if interest > 0 {
+		transactions = append(transactions, GetTransactionRef(userId, 0, MarginInterestTransaction, 0, 0, 0, 0, -int64(interest)))
+	}
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, args ...interface{}) error {
+		user.Cash, *account = oldCash, oldAccount
+		tx.Rollback()
+		return fmt.Errorf(format, args...)
+	}
+
+	for _, t := range transactions {
+		if err := saveTransaction(tx, t); err != nil {
+			return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+		}
+	}
+	if err := tx.Save(account).Error; err != nil {
+		return errorHelper("Error updating margin account. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error updating user's cash. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	sendMarginTransactions(transactions...)
+
+	return nil
+}
+
+// CheckMarginAccounts checks every margin loan against the maintenance margin. A user whose equity
+// falls below it gets a margin call. If the equity isn't back above it within the grace period, the
+// user's cash goes to the loan and the stocks to sell to pay off the rest are returned.
+func CheckMarginAccounts() ([]*MarginLiquidation, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "CheckMarginAccounts",
+	})
+
+	var accounts []MarginAccount
+	db := getDB()
+	if err := db.Where("loan > 0 OR marginCallAt != ''").Find(&accounts).Error; err != nil {
+		l.Errorf("Unable to get margin accounts: %+v", err)
+		return nil, err
+	}
+
+	var liquidations []*MarginLiquidation
+	for _, a := range accounts {
+		userLiquidations, err := checkMarginAccount(a.UserId)
+		if err != nil {
+			l.Errorf("Unable to check margin account of user %d: %+v", a.UserId, err)
+			continue
+		}
+		liquidations = append(liquidations, userLiquidations...)
+	}
+
+	return liquidations, nil
+}
+
+func checkMarginAccount(userId uint32) ([]*MarginLiquidation, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "checkMarginAccount",
+		"param_userId": userId,
+	})
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		return nil, err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	account, err := getMarginAccount(db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	status, holdings, err := getMarginStatus(user, account)
+	if err != nil {
+		return nil, err
+	}
+
+	setMarginCall := func(at string) error {
+		oldMarginCallAt, oldUpdatedAt := account.MarginCallAt, account.UpdatedAt
+		account.MarginCallAt = at
+		account.UpdatedAt = utils.GetCurrentTimeISO8601()
+		if err := db.Save(account).Error; err != nil {
+			account.MarginCallAt, account.UpdatedAt = oldMarginCallAt, oldUpdatedAt
+			return err
+		}
+		return nil
+	}
+
+	if account.Loan == 0 || !isBelowMaintenance(status.Assets, status.Equity) {
+		if account.MarginCallAt == "" {
+			return nil, nil
+		}
+		if err := setMarginCall(""); err != nil {
+			return nil, err
+		}
+		go SendNotification(userId, "Your margin account is back above the maintenance margin. The margin call is over.", false)
+		l.Infof("Margin call met")
+		return nil, nil
+	}
+
+	if account.MarginCallAt == "" {
+		if err := setMarginCall(utils.GetCurrentTimeISO8601()); err != nil {
+			return nil, err
+		}
+		shortfall := getLiquidationAmount(status.Assets, status.Equity)
+		go SendNotification(userId, fmt.Sprintf("Margin call! Your equity is below %d%% of your assets. Repay %d of your margin loan within %d minutes, or your stocks will be sold to pay it off.",
+			config.MaintenanceMarginPercent, shortfall, config.MarginCallGracePeriod/60), false)
+		l.Infof("Margin call made. Shortfall is %d", shortfall)
+		return nil, nil
+	}
+
+	calledAt, err := time.Parse(time.RFC3339, account.MarginCallAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(calledAt) < time.Duration(config.MarginCallGracePeriod)*time.Second {
+		return nil, nil
+	}
+
+	// the call wasn't met in time. The cash goes to the loan first.
+	if repay := minUint64(user.Cash, account.Loan); repay > 0 {
+		if _, err := repayMarginLoan(user, account, repay); err != nil {
+			return nil, err
+		}
+		status.Assets -= int64(repay)
+		status.Loan -= repay
+		l.Infof("Repaid %d of the loan from cash", repay)
+	}
+
+	if account.Loan == 0 || !isBelowMaintenance(status.Assets, status.Equity) {
+		return nil, nil
+	}
+
+	// stocks already up for sale bring in cash for the loan too
+	amount := getLiquidationAmount(status.Assets, status.Equity)
+	for _, h := range holdings {
+		if h.Total <= h.Available {
+			continue
+		}
+		pending := uint64(h.Total-h.Available) * h.Price
+		if pending >= amount {
+			amount = 0
+			break
+		}
+		amount -= pending
+	}
+	if amount == 0 {
+		return nil, nil
+	}
+
+	liquidations := pickLiquidations(userId, holdings, amount)
+	if len(liquidations) > 0 {
+		go SendNotification(userId, "Your margin call wasn't met in time. Your stocks are being sold to pay off your margin loan.", false)
+	}
+
+	l.Infof("Liquidating stocks worth %d in %d orders", amount, len(liquidations))
+	return liquidations, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
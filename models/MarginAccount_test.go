@@ -0,0 +1,316 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetMaxMarginLoan(t *testing.T) {
+	oldLeverage := config.MarginLeverage
+	defer func() {
+		config.MarginLeverage = oldLeverage
+	}()
+
+	var tests = []struct {
+		leverage uint64
+		equity   int64
+		maxLoan  uint64
+	}{
+		{2, 100000, 100000},
+		{3, 100000, 200000},
+		{1, 100000, 0},
+		{2, 0, 0},
+		{2, -500, 0},
+	}
+
+	for _, test := range tests {
+		config.MarginLeverage = test.leverage
+		if maxLoan := getMaxMarginLoan(test.equity); maxLoan != test.maxLoan {
+			t.Errorf("getMaxMarginLoan(%+v) = %d, expected %d", test, maxLoan, test.maxLoan)
+		}
+	}
+}
+
+func TestMaintenanceMargin(t *testing.T) {
+	oldMaintenance := config.MaintenanceMarginPercent
+	defer func() {
+		config.MaintenanceMarginPercent = oldMaintenance
+	}()
+
+	config.MaintenanceMarginPercent = 25
+
+	var tests = []struct {
+		assets             int64
+		equity             int64
+		isBelowMaintenance bool
+		liquidationAmount  uint64
+	}{
+		{200000, 100000, false, 0},
+		{200000, 50000, false, 0},
+		{200000, 40000, true, 40000},
+		{200000, 0, true, 200000},
+		{100000, -10000, true, 140000},
+	}
+
+	for _, test := range tests {
+		if isBelow := isBelowMaintenance(test.assets, test.equity); isBelow != test.isBelowMaintenance {
+			t.Errorf("isBelowMaintenance(%+v) = %v, expected %v", test, isBelow, test.isBelowMaintenance)
+		}
+		if amount := getLiquidationAmount(test.assets, test.equity); amount != test.liquidationAmount {
+			t.Errorf("getLiquidationAmount(%+v) = %d, expected %d", test, amount, test.liquidationAmount)
+		}
+	}
+}
+
+func TestPickLiquidations(t *testing.T) {
+	holdings := []marginHolding{
+		{StockId: 1, Available: 10, Total: 10, Price: 100, IsTradable: true},
+		{StockId: 2, Available: 100, Total: 120, Price: 200, IsTradable: true},
+		{StockId: 3, Available: 1000, Total: 1000, Price: 500, IsTradable: false},
+		{StockId: 4, Available: 0, Total: 5, Price: 1000, IsTradable: true},
+	}
+
+	var tests = []struct {
+		amount       uint64
+		liquidations []MarginLiquidation
+	}{
+		{0, nil},
+		// the biggest holding goes first, and a part of a stock is sold as a whole stock
+		{1050, []MarginLiquidation{{7, 2, 6}}},
+		// at most ASK_LIMIT stocks are sold in one order
+		{20000, []MarginLiquidation{{7, 2, ASK_LIMIT}, {7, 1, 10}}},
+		{1000000, []MarginLiquidation{{7, 2, ASK_LIMIT}, {7, 1, 10}}},
+	}
+
+	for _, test := range tests {
+		liquidations := pickLiquidations(7, holdings, test.amount)
+		if len(liquidations) != len(test.liquidations) {
+			t.Errorf("pickLiquidations(%d) = %+v, expected %+v", test.amount, liquidations, test.liquidations)
+			continue
+		}
+		for i, liq := range liquidations {
+			if *liq != test.liquidations[i] {
+				t.Errorf("pickLiquidations(%d)[%d] = %+v, expected %+v", test.amount, i, *liq, test.liquidations[i])
+			}
+		}
+	}
+}
+
+func TestBorrowingKeepsNetWorth(t *testing.T) {
+	oldInterest := config.MarginInterestBasisPoints
+	defer func() {
+		config.MarginInterestBasisPoints = oldInterest
+	}()
+
+	config.MarginInterestBasisPoints = 50
+
+	var tests = []struct {
+		cash            int64
+		stockWorth      int64
+		borrowed        uint64
+		lastInterestDay uint32
+		marketDay       uint32
+		debt            uint64
+		netWorth        int64
+	}{
+		{100000, 50000, 0, 3, 3, 0, 150000},
+		{100000, 50000, 100000, 3, 3, 100000, 150000},
+		{0, 50000, 40000, 3, 3, 40000, 50000},
+		// the day's interest is owed until it's charged at close
+		{100000, 50000, 100000, 2, 3, 100500, 149500},
+	}
+
+	for _, test := range tests {
+		debt := getMarginDebt(test.borrowed, test.lastInterestDay, test.marketDay)
+		if debt != test.debt {
+			t.Errorf("getMarginDebt(%+v) = %d, expected %d", test, debt, test.debt)
+		}
+		// the borrowed cash is paid out to the user
+		netWorth := test.cash + int64(test.borrowed) + test.stockWorth - int64(debt)
+		if netWorth != test.netWorth {
+			t.Errorf("net worth after borrowing %+v = %d, expected %d", test, netWorth, test.netWorth)
+		}
+	}
+}
+
+func Test_MarginLoan(t *testing.T) {
+	oldInterest := config.MarginInterestBasisPoints
+	defer func() {
+		config.MarginInterestBasisPoints = oldInterest
+	}()
+	config.MarginInterestBasisPoints = 100
+
+	user := &User{Id: 2, Cash: 1000}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM MarginAccounts")
+		db.Delete(user)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenMarginAccount(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	borrowTransaction, err := BorrowOnMargin(user.Id, 500)
+	if err != nil {
+		t.Fatalf("Did not expect error while borrowing. Error: %+v", err)
+	}
+	if _, err := RepayMarginLoan(user.Id, 200); err != nil {
+		t.Fatalf("Did not expect error while repaying. Error: %+v", err)
+	}
+	if err := chargeMarginInterest(user.Id, 1000); err != nil {
+		t.Fatalf("Did not expect error while charging interest. Error: %+v", err)
+	}
+
+	// the transactions of a margin account are for no stock
+	var saved []struct {
+		Type    string
+		StockId *uint32
+		Total   int64
+	}
+	if err := db.Raw("SELECT type, stockId AS stock_id, total FROM Transactions WHERE userId = ? ORDER BY id", user.Id).Scan(&saved).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		Type  string
+		Total int64
+	}{
+		{MarginTransaction.String(), 500},
+		{MarginTransaction.String(), -200},
+		{MarginInterestTransaction.String(), -3},
+	}
+	if len(saved) != len(expected) {
+		t.Fatalf("Expected %d transactions, got %d", len(expected), len(saved))
+	}
+	for i, e := range expected {
+		if saved[i].Type != e.Type || saved[i].Total != e.Total || saved[i].StockId != nil {
+			t.Errorf("Transaction %d: got %s of %d with stockId %v; want %s of %d with no stockId", i, saved[i].Type, saved[i].Total, saved[i].StockId, e.Type, e.Total)
+		}
+	}
+	if borrowTransaction.Id == 0 {
+		t.Errorf("Expected the id of the saved transaction to be set")
+	}
+
+	status, err := GetMarginAccount(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, uint64(300), status.Loan)
+
+	u := &User{}
+	db.First(u, user.Id)
+	testutils.AssertEqual(t, uint64(1297), u.Cash)
+}
+
+func Test_CheckMarginAccounts(t *testing.T) {
+	oldLeverage, oldMaintenance, oldGracePeriod := config.MarginLeverage, config.MaintenanceMarginPercent, config.MarginCallGracePeriod
+	defer func() {
+		config.MarginLeverage, config.MaintenanceMarginPercent, config.MarginCallGracePeriod = oldLeverage, oldMaintenance, oldGracePeriod
+	}()
+	config.MarginLeverage = 2
+	config.MaintenanceMarginPercent = 30
+	config.MarginCallGracePeriod = 0
+
+	user := &User{Id: 2, Cash: 1000}
+	stock := &Stock{Id: 1, CurrentPrice: 100}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM MarginAccounts")
+		db.Delete(user)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// the user holds 10 stocks, and borrows as much as the equity
+	if err := db.Create(GetTransactionRef(user.Id, stock.Id, FromExchangeTransaction, 0, 10, 100, 0, -1000)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMarginAccount(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BorrowOnMargin(user.Id, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// and spends the cash on 19 more stocks
+	ch, lockedUser, err := getUserExclusively(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedUser.Cash = 100
+	err = db.Save(lockedUser).Error
+	close(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(user.Id, stock.Id, FromExchangeTransaction, 0, 19, 100, 0, -1900)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The price falls to 40. The assets are 100 + 29*40 = 1260, and the equity 260 is below 30% of them
+	stock.CurrentPrice = 40
+	if err := db.Save(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	liquidations, err := CheckMarginAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(liquidations) != 0 {
+		t.Fatalf("Expected a margin call without liquidations, got %+v", liquidations)
+	}
+	account, err := getMarginAccount(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.MarginCallAt == "" {
+		t.Fatalf("Expected a margin call to be made")
+	}
+
+	// The call isn't met. The cash repays 100 of the loan, and stocks worth 1160 - 260*100/30 = 294 are sold
+	liquidations, err = CheckMarginAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*MarginLiquidation{{UserId: user.Id, StockId: stock.Id, StockQuantity: 8}}
+	testutils.AssertEqual(t, expected, liquidations)
+
+	account, err = getMarginAccount(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, uint64(900), account.Loan)
+
+	u := &User{}
+	db.First(u, user.Id)
+	testutils.AssertEqual(t, uint64(0), u.Cash)
+
+	var totals []int64
+	if err := db.Table("Transactions").Where("userId = ? AND type = ?", user.Id, MarginTransaction.String()).Order("id").Pluck("total", &totals).Error; err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, []int64{1000, -100}, totals)
+}
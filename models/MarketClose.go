@@ -7,8 +7,22 @@ import (
 
 var isMarketOpen = false
 
+// isCallAuctionRunning is true while orders are being collected for an opening or closing call auction.
+// The auction ends when the market opens or closes.
+var isCallAuctionRunning = false
+
 func OpenMarket(updateDayHighAndLow bool) error {
+	// trading starts off at the prices of the stocks that went ex-dividend
+	AdjustExDividendPrices()
+	go PayDueDividends()
+
 	isMarketOpen = true
+	isCallAuctionRunning = false
+
+	// fee tiers go by the volume traded in the market day
+	resetDayVolumes()
+	// circuit breakers go by the new previous day's close
+	resetDayBands()
 
 	db := getDB()
 
@@ -39,13 +53,31 @@ func OpenMarket(updateDayHighAndLow bool) error {
 	return nil
 }
 
+// runMarketCloseJobs runs the jobs that settle the market day, one after the other. They all change
+// holdings and cash, so they go in this order:
+//  1. ChargeMarginInterest and MarkFuturesToMarket only move cash. Futures expiring today are settled by the
+//     matching engine afterwards, once their order books are stopped.
+//  2. CloseRightsIssues allots the stocks subscribed to, and SettleBuybacks takes in the stocks accepted.
+//  3. RecordDividendHolders goes last, so that record day holdings include everything above.
+func runMarketCloseJobs() {
+	ChargeMarginInterest()
+	MarkFuturesToMarket()
+	CloseRightsIssues()
+	SettleBuybacks()
+	RecordDividendHolders()
+}
+
 func CloseMarket(updatePreviousDayClose bool) error {
 	isMarketOpen = false
+	isCallAuctionRunning = false
 
 	db := getDB()
 
 	db.Exec("Update Config set isMarketOpen = false")
 
+	// the market is reported closed only once the day is settled
+	runMarketCloseJobs()
+
 	gameStateStream := datastreamsManager.GetGameStateStream()
 	g := &GameState{
 		UserID: 0,
@@ -68,3 +100,24 @@ func CloseMarket(updatePreviousDayClose bool) error {
 func IsMarketOpen() bool {
 	return isMarketOpen
 }
+
+// StartCallAuction starts collecting orders for a call auction. Orders can be placed even if the
+// market is closed, but they aren't matched till the order books uncross.
+func StartCallAuction() {
+	isCallAuctionRunning = true
+
+	gameStateStream := datastreamsManager.GetGameStateStream()
+	g := &GameState{
+		UserID: 0,
+		Ms: &MarketState{
+			IsMarketOpen:         isMarketOpen,
+			IsCallAuctionRunning: true,
+		},
+		GsType: MarketStateUpdate,
+	}
+	gameStateStream.SendGameStateUpdate(g.ToProto())
+}
+
+func IsCallAuctionRunning() bool {
+	return isCallAuctionRunning
+}
@@ -0,0 +1,478 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+)
+
+// Ways of weighting the stocks in the market index
+const (
+	// PriceWeighted makes the index the average current price of its stocks
+	PriceWeighted = "PriceWeighted"
+	// CapWeighted makes the index the average current price of its stocks weighted by their stocks in the
+	// market, so that every stock counts as much as its market capitalisation
+	CapWeighted = "CapWeighted"
+)
+
+// MarketIndexHistory is an interval in the history of the market index, like StockHistory is for a stock
+type MarketIndexHistory struct {
+	IndexName string `gorm:"column:indexName;not null" json:"index_name"`
+	Close     uint64 `gorm:"column:close;not null" json:"close"`
+	CreatedAt string `gorm:"column:createdAt;not null" json:"created_at"`
+	Interval  uint32 `gorm:"column:intervalRecord;not null" json:"interval"`
+	Open      uint64 `gorm:"column:open;not null" json:"open"`
+	High      uint64 `gorm:"column:high;not null" json:"high"`
+	Low       uint64 `gorm:"column:low;not null" json:"low"`
+}
+
+func (MarketIndexHistory) TableName() string {
+	return "MarketIndexHistory"
+}
+
+func (h *MarketIndexHistory) ToProto() *models_pb.MarketIndexHistory {
+	return &models_pb.MarketIndexHistory{
+		IndexName: h.IndexName,
+		Close:     h.Close,
+		CreatedAt: h.CreatedAt,
+		Interval:  h.Interval,
+		Open:      h.Open,
+		High:      h.High,
+		Low:       h.Low,
+	}
+}
+
+// MarketIndex is what's needed to work out the market index, kept across restarts. The index is the
+// weighted total of the current prices of its stocks, divided by the divisor. The stocks are picked
+// again, and the divisor moved, only when the stocks change in a way that isn't a move in their prices,
+// like a listing or a split, so that the index doesn't jump then.
+type MarketIndex struct {
+	IndexName string  `gorm:"column:indexName;primary_key" json:"index_name"`
+	Divisor   float64 `gorm:"column:divisor;not null" json:"divisor"`
+	StockIds  string  `gorm:"column:stockIds;not null" json:"stock_ids"`
+}
+
+func (MarketIndex) TableName() string {
+	return "MarketIndex"
+}
+
+// marketIndexBase holds the stocks and the divisor of the market index. It stays locked while the stocks
+// of the index are being changed, so that the index isn't worked out halfway through a change.
+var marketIndexBase = struct {
+	sync.Mutex
+	loaded   bool
+	stockIds []uint32
+	divisor  float64
+}{}
+
+// marketIndex holds the last value of the market index, and its open, high and low in the current minute
+var marketIndex = struct {
+	sync.Mutex
+	value uint64
+	open  uint64
+	high  uint64
+	low   uint64
+}{}
+
+// pickMarketIndexStocks returns the ids of the size stocks with the largest market capitalisation among
+// stocks, in ascending order. Bankrupt and delisted stocks are left out, and size 0 includes every other stock.
+func pickMarketIndexStocks(stocks map[uint32]*Stock, size int) []uint32 {
+	var constituents []*Stock
+	for _, stock := range stocks {
+		if stock.IsBankrupt || stock.IsDelisted {
+			continue
+		}
+		constituents = append(constituents, stock)
+	}
+
+	if size > 0 && len(constituents) > size {
+		sort.Slice(constituents, func(i, j int) bool {
+			capI := constituents[i].CurrentPrice * constituents[i].StocksInMarket
+			capJ := constituents[j].CurrentPrice * constituents[j].StocksInMarket
+			if capI != capJ {
+				return capI > capJ
+			}
+			return constituents[i].Id < constituents[j].Id
+		})
+		constituents = constituents[:size]
+	}
+
+	stockIds := make([]uint32, 0, len(constituents))
+	for _, stock := range constituents {
+		stockIds = append(stockIds, stock.Id)
+	}
+	sort.Slice(stockIds, func(i, j int) bool { return stockIds[i] < stockIds[j] })
+
+	return stockIds
+}
+
+// getMarketIndexTotal returns the total of the current prices of the given stocks weighted as per weighting,
+// and the total of their weights. Stocks that aren't there count for nothing.
+func getMarketIndexTotal(stocks map[uint32]*Stock, stockIds []uint32, weighting string) (total, weights uint64) {
+	for _, stockId := range stockIds {
+		stock, ok := stocks[stockId]
+		if !ok {
+			continue
+		}
+		weight := uint64(1)
+		if weighting == CapWeighted {
+			weight = stock.StocksInMarket
+		}
+		total += stock.CurrentPrice * weight
+		weights += weight
+	}
+	return total, weights
+}
+
+// computeMarketIndex returns the value of a market index made up of the given stocks, weighted as per
+// weighting, rounded off. It's 0 if the divisor is.
+func computeMarketIndex(stocks map[uint32]*Stock, stockIds []uint32, weighting string, divisor float64) uint64 {
+	if divisor <= 0 {
+		return 0
+	}
+	total, _ := getMarketIndexTotal(stocks, stockIds, weighting)
+	return uint64(math.Round(float64(total) / divisor))
+}
+
+// rebaseMarketIndex picks the stocks of a market index again after a change to stocks, and returns them
+// along with the divisor that keeps the index where it was before the change. before has the stocks
+// that were changed as they were before it, with nil for the ones that didn't exist. If the index
+// was 0, the new divisor makes it the weighted average price of its stocks.
+func rebaseMarketIndex(stocks, before map[uint32]*Stock, stockIds []uint32, divisor float64, weighting string, size int) ([]uint32, float64) {
+	oldStocks := make(map[uint32]*Stock)
+	for stockId, stock := range stocks {
+		oldStocks[stockId] = stock
+	}
+	for stockId, stock := range before {
+		if stock == nil {
+			delete(oldStocks, stockId)
+		} else {
+			oldStocks[stockId] = stock
+		}
+	}
+	oldTotal, _ := getMarketIndexTotal(oldStocks, stockIds, weighting)
+
+	newStockIds := pickMarketIndexStocks(stocks, size)
+	newTotal, newWeights := getMarketIndexTotal(stocks, newStockIds, weighting)
+
+	if divisor <= 0 || oldTotal == 0 || newTotal == 0 {
+		return newStockIds, float64(newWeights)
+	}
+	return newStockIds, divisor * float64(newTotal) / float64(oldTotal)
+}
+
+// loadMarketIndexBase loads the stocks and the divisor of the market index from the database. They're
+// left empty if the index hasn't been saved yet. marketIndexBase must be locked.
+func loadMarketIndexBase() error {
+	db := getDB()
+
+	index := &MarketIndex{}
+	err := db.Where("indexName = ?", config.MarketIndexName).First(index).Error
+	if gorm.IsRecordNotFoundError(err) {
+		marketIndexBase.loaded = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var stockIds []uint32
+	for _, field := range strings.Split(index.StockIds, ",") {
+		if field == "" {
+			continue
+		}
+		stockId, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return err
+		}
+		stockIds = append(stockIds, uint32(stockId))
+	}
+
+	marketIndexBase.loaded = true
+	marketIndexBase.stockIds = stockIds
+	marketIndexBase.divisor = index.Divisor
+	return nil
+}
+
+// marketIndexChange is a change to stocks that isn't a move in their prices, like a listing, a bankruptcy,
+// a delisting, a merger or a split. The stocks of the market index are picked again after it, and the
+// divisor is moved so that the index stays where it was.
+type marketIndexChange struct {
+	before map[uint32]*Stock
+}
+
+// startMarketIndexChange starts a change to stocks. The market index isn't updated till end is called. It
+// must be called before locking any stock.
+func startMarketIndexChange() *marketIndexChange {
+	marketIndexBase.Lock()
+	return &marketIndexChange{before: make(map[uint32]*Stock)}
+}
+
+// changed notes down a stock as it was before the change. before is nil for a stock that didn't exist.
+func (c *marketIndexChange) changed(stockId uint32, before *Stock) {
+	if _, ok := c.before[stockId]; ok {
+		return
+	}
+	if before != nil {
+		stockCopy := *before
+		before = &stockCopy
+	}
+	c.before[stockId] = before
+}
+
+// end moves the divisor of the market index to make up for the stocks that changed, saves it, and sends out
+// the index. No stock may be locked when it's called.
+func (c *marketIndexChange) end() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "marketIndexChange.end",
+	})
+
+	defer marketIndexBase.Unlock()
+
+	if !marketIndexBase.loaded {
+		if err := loadMarketIndexBase(); err != nil {
+			l.Errorf("Error loading the market index: %+v", err)
+			return
+		}
+	} else if len(c.before) == 0 {
+		return
+	}
+
+	stockIds, divisor := rebaseMarketIndex(GetAllStocks(), c.before, marketIndexBase.stockIds, marketIndexBase.divisor, config.MarketIndexWeighting, config.MarketIndexSize)
+
+	fields := make([]string, 0, len(stockIds))
+	for _, stockId := range stockIds {
+		fields = append(fields, strconv.FormatUint(uint64(stockId), 10))
+	}
+	index := &MarketIndex{
+		IndexName: config.MarketIndexName,
+		Divisor:   divisor,
+		StockIds:  strings.Join(fields, ","),
+	}
+	if err := getDB().Save(index).Error; err != nil {
+		l.Errorf("Error saving the market index %+v: %+v", index, err)
+		return
+	}
+
+	marketIndexBase.stockIds = stockIds
+	marketIndexBase.divisor = divisor
+	l.Infof("Market index now has %d stocks, with a divisor of %f", len(stockIds), divisor)
+
+	refreshMarketIndex()
+}
+
+// GetMarketIndexValue returns the current value of the market index, as set up in the config. Index futures
+// are settled against it.
+func GetMarketIndexValue() uint64 {
+	marketIndex.Lock()
+	defer marketIndex.Unlock()
+	return marketIndex.value
+}
+
+// GetMarketIndexName returns the name the market index is shown with
+func GetMarketIndexName() string {
+	return config.MarketIndexName
+}
+
+// refreshMarketIndex works out the market index from the current prices of its stocks, and sends it out.
+// marketIndexBase must be locked.
+func refreshMarketIndex() {
+	value := computeMarketIndex(GetAllStocks(), marketIndexBase.stockIds, config.MarketIndexWeighting, marketIndexBase.divisor)
+
+	marketIndex.Lock()
+	marketIndex.value = value
+	if marketIndex.open == 0 {
+		marketIndex.open = value
+		marketIndex.high = value
+		marketIndex.low = value
+	}
+	if value > marketIndex.high {
+		marketIndex.high = value
+	} else if value < marketIndex.low {
+		marketIndex.low = value
+	}
+	marketIndex.Unlock()
+
+	marketIndexStream := datastreamsManager.GetMarketIndexStream()
+	marketIndexStream.SendMarketIndexUpdate(config.MarketIndexName, value)
+}
+
+// updateMarketIndex works out the market index after the prices of stocks changed, and sends it out
+func updateMarketIndex() {
+	marketIndexBase.Lock()
+	defer marketIndexBase.Unlock()
+
+	if marketIndexBase.loaded {
+		refreshMarketIndex()
+	}
+}
+
+// UpdateMarketIndexTicker updates the market index every second. It's the only place the index follows the
+// prices of stocks from, so the updates don't race each other.
+func UpdateMarketIndexTicker() {
+	for {
+		updateMarketIndex()
+		time.Sleep(time.Second)
+	}
+}
+
+// aggregateMarketIndexHistory returns the N minute interval ending at recordingTime, made from the one minute
+// intervals in histories that fall within it. histories are in the order of newest first. It's nil if none
+// of them do.
+func aggregateMarketIndexHistory(histories []*MarketIndexHistory, N Resolution, recordingTime time.Time) *MarketIndexHistory {
+	modifiedRange := recordingTime.Add(time.Duration(N) * -time.Minute).UTC().Format(time.RFC3339)
+
+	var inRange []*MarketIndexHistory
+	for _, history := range histories {
+		if history.CreatedAt > modifiedRange {
+			inRange = append(inRange, history)
+		}
+	}
+	if len(inRange) == 0 {
+		return nil
+	}
+
+	aggregate := &MarketIndexHistory{
+		IndexName: inRange[0].IndexName,
+		Close:     inRange[0].Close,
+		CreatedAt: recordingTime.UTC().Format(time.RFC3339),
+		Interval:  uint32(N),
+		Open:      inRange[len(inRange)-1].Open,
+		High:      inRange[0].High,
+		Low:       inRange[0].Low,
+	}
+	for _, history := range inRange {
+		if history.High > aggregate.High {
+			aggregate.High = history.High
+		}
+		if history.Low < aggregate.Low {
+			aggregate.Low = history.Low
+		}
+	}
+
+	return aggregate
+}
+
+func marketIndexHistoryStreamUpdate(history *MarketIndexHistory) {
+	marketIndexStream := datastreamsManager.GetMarketIndexStream()
+	marketIndexStream.SendMarketIndexHistoryUpdate(history.ToProto())
+}
+
+// recordMarketIndexOHLC records the market index's one minute interval ending at recordingTime, and the
+// higher intervals that end then. It's called along with the stock history recorder.
+func recordMarketIndexOHLC(db *gorm.DB, recordingTime time.Time) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "recordMarketIndexOHLC",
+	})
+
+	marketIndex.Lock()
+	value := marketIndex.value
+	if marketIndex.open == 0 {
+		marketIndex.open = value
+		marketIndex.high = value
+		marketIndex.low = value
+	}
+	history := &MarketIndexHistory{
+		IndexName: config.MarketIndexName,
+		Close:     value,
+		CreatedAt: recordingTime.UTC().Format(time.RFC3339),
+		Interval:  uint32(OneMinute),
+		Open:      marketIndex.open,
+		High:      marketIndex.high,
+		Low:       marketIndex.low,
+	}
+	if value > history.High {
+		history.High = value
+	}
+	if value < history.Low {
+		history.Low = value
+	}
+	// the next interval opens at this one's close
+	marketIndex.open = value
+	marketIndex.high = value
+	marketIndex.low = value
+	marketIndex.Unlock()
+
+	if err := db.Save(history).Error; err != nil {
+		l.Errorf("Error registering market index history point %+v. Error: %+v", history, err)
+		return err
+	}
+	marketIndexHistoryStreamUpdate(history)
+
+	currMin := recordingTime.Minute()
+	if currMin%5 != 0 {
+		return nil
+	}
+
+	var histories []*MarketIndexHistory
+	minReqdTime := recordingTime.Add(-60 * time.Minute).UTC().Format(time.RFC3339)
+	query := db.Where("indexName = ? AND intervalRecord = ? AND createdAt >= ?", config.MarketIndexName, OneMinute, minReqdTime)
+	if err := query.Order("createdAt desc").Limit(TIMES_RESOLUTION).Find(&histories).Error; err != nil {
+		l.Errorf("Error loading market index history: %+v", err)
+		return err
+	}
+
+	for _, N := range []Resolution{FiveMinutes, FifteenMinutes, ThirtyMinutes, SixtyMinutes} {
+		if currMin%int(N) != 0 {
+			continue
+		}
+		aggregate := aggregateMarketIndexHistory(histories, N, recordingTime)
+		if aggregate == nil {
+			continue
+		}
+		if err := db.Save(aggregate).Error; err != nil {
+			l.Errorf("Error registering market index history point %+v. Error: %+v", aggregate, err)
+			return err
+		}
+		marketIndexHistoryStreamUpdate(aggregate)
+	}
+
+	return nil
+}
+
+// GetMarketIndexHistory returns the latest intervals of the market index at a resolution
+func GetMarketIndexHistory(interval Resolution) ([]*MarketIndexHistory, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":   "GetMarketIndexHistory",
+		"interval": interval,
+	})
+
+	l.Infof("Attempting to get the market index history")
+
+	db := getDB()
+
+	var histories []*MarketIndexHistory
+	//Interval 0 refers to Day interval, which isn't recorded
+	if interval != OneDay {
+		db = db.Where("indexName = ? AND intervalRecord = ?", config.MarketIndexName, interval)
+		if err := db.Order("createdAt desc").Limit(TIMES_RESOLUTION).Find(&histories).Error; err != nil {
+			l.Errorf("Error loading market index history: %+v", err)
+			return nil, err
+		}
+	}
+
+	return histories, nil
+}
+
+// beatsMarketIndex checks if a worth that went from initialWorth to finalWorth grew by at least margin
+// percent more than the market index did when it went from initialIndex to finalIndex
+func beatsMarketIndex(initialWorth, finalWorth int64, initialIndex, finalIndex uint64, margin uint64) bool {
+	if initialWorth <= 0 {
+		return false
+	}
+	growth := float64(finalWorth-initialWorth) * 100 / float64(initialWorth)
+
+	var indexGrowth float64
+	if initialIndex > 0 {
+		indexGrowth = (float64(finalIndex) - float64(initialIndex)) * 100 / float64(initialIndex)
+	}
+
+	return growth >= indexGrowth+float64(margin)
+}
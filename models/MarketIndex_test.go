@@ -0,0 +1,173 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComputeMarketIndex(t *testing.T) {
+	stocks := map[uint32]*Stock{
+		1: {Id: 1, CurrentPrice: 100, StocksInMarket: 10},
+		2: {Id: 2, CurrentPrice: 200, StocksInMarket: 30},
+		3: {Id: 3, CurrentPrice: 400, StocksInMarket: 5},
+		4: {Id: 4, CurrentPrice: 1000, StocksInMarket: 100, IsBankrupt: true},
+		5: {Id: 5, CurrentPrice: 1000, StocksInMarket: 100, IsDelisted: true},
+	}
+
+	var tests = []struct {
+		weighting string
+		size      int
+		stockIds  []uint32
+		value     uint64
+	}{
+		{PriceWeighted, 0, []uint32{1, 2, 3}, 233},
+		{CapWeighted, 0, []uint32{1, 2, 3}, 200},
+		// the two stocks with the largest capitalisation are 2 and 3
+		{PriceWeighted, 2, []uint32{2, 3}, 300},
+		{CapWeighted, 2, []uint32{2, 3}, 229},
+		{CapWeighted, 10, []uint32{1, 2, 3}, 200},
+	}
+
+	for _, test := range tests {
+		stockIds := pickMarketIndexStocks(stocks, test.size)
+		if !reflect.DeepEqual(stockIds, test.stockIds) {
+			t.Errorf("pickMarketIndexStocks(%+v) = %v, expected %v", test, stockIds, test.stockIds)
+		}
+		// a fresh index is the weighted average price of its stocks
+		stockIds, divisor := rebaseMarketIndex(stocks, nil, nil, 0, test.weighting, test.size)
+		if value := computeMarketIndex(stocks, stockIds, test.weighting, divisor); value != test.value {
+			t.Errorf("computeMarketIndex(%+v) = %d, expected %d", test, value, test.value)
+		}
+	}
+
+	if value := computeMarketIndex(stocks, nil, CapWeighted, 0); value != 0 {
+		t.Errorf("computeMarketIndex with no stocks = %d, expected 0", value)
+	}
+}
+
+func TestRebaseMarketIndex(t *testing.T) {
+	newStocks := func() map[uint32]*Stock {
+		return map[uint32]*Stock{
+			1: {Id: 1, CurrentPrice: 100, StocksInMarket: 10},
+			2: {Id: 2, CurrentPrice: 200, StocksInMarket: 30},
+			3: {Id: 3, CurrentPrice: 400, StocksInMarket: 5},
+		}
+	}
+
+	var tests = []struct {
+		name   string
+		change func(stocks map[uint32]*Stock)
+	}{
+		{"split", func(stocks map[uint32]*Stock) {
+			stocks[3].CurrentPrice /= 2
+			stocks[3].StocksInMarket *= 2
+		}},
+		{"bankruptcy", func(stocks map[uint32]*Stock) {
+			stocks[2].IsBankrupt = true
+			stocks[2].CurrentPrice = 0
+		}},
+		{"delisting", func(stocks map[uint32]*Stock) {
+			stocks[1].IsDelisted = true
+			stocks[1].StocksInMarket = 0
+		}},
+		{"merger", func(stocks map[uint32]*Stock) {
+			stocks[2].StocksInMarket += stocks[1].StocksInMarket / 2
+			stocks[1].IsDelisted = true
+			stocks[1].StocksInMarket = 0
+		}},
+		{"listing", func(stocks map[uint32]*Stock) {
+			stocks[4] = &Stock{Id: 4, CurrentPrice: 1000, StocksInMarket: 100}
+		}},
+	}
+
+	for _, weighting := range []string{PriceWeighted, CapWeighted} {
+		for _, test := range tests {
+			stocks := newStocks()
+			stockIds, divisor := rebaseMarketIndex(stocks, nil, nil, 0, weighting, 0)
+
+			// the index moves with the prices first
+			stocks[1].CurrentPrice = 130
+			stocks[3].CurrentPrice = 360
+			valueBefore := computeMarketIndex(stocks, stockIds, weighting, divisor)
+
+			before := make(map[uint32]*Stock)
+			for stockId := range newStocks() {
+				stockCopy := *stocks[stockId]
+				before[stockId] = &stockCopy
+			}
+			test.change(stocks)
+			for stockId := range stocks {
+				if _, ok := before[stockId]; !ok {
+					before[stockId] = nil
+				}
+			}
+
+			stockIds, divisor = rebaseMarketIndex(stocks, before, stockIds, divisor, weighting, 0)
+			if value := computeMarketIndex(stocks, stockIds, weighting, divisor); value != valueBefore {
+				t.Errorf("%s of a %s index moved it from %d to %d", test.name, weighting, valueBefore, value)
+			}
+		}
+	}
+}
+
+func TestAggregateMarketIndexHistory(t *testing.T) {
+	recordingTime := time.Date(2022, 4, 30, 10, 15, 0, 0, time.UTC)
+	minute := func(m int) string {
+		return recordingTime.Add(time.Duration(-m) * time.Minute).Format(time.RFC3339)
+	}
+
+	// newest first
+	histories := []*MarketIndexHistory{
+		{IndexName: "DALAL50", CreatedAt: minute(0), Open: 104, High: 106, Low: 103, Close: 105},
+		{IndexName: "DALAL50", CreatedAt: minute(1), Open: 102, High: 104, Low: 101, Close: 104},
+		{IndexName: "DALAL50", CreatedAt: minute(4), Open: 100, High: 103, Low: 99, Close: 102},
+		{IndexName: "DALAL50", CreatedAt: minute(5), Open: 90, High: 120, Low: 80, Close: 100},
+	}
+
+	aggregate := aggregateMarketIndexHistory(histories, FiveMinutes, recordingTime)
+	if aggregate == nil {
+		t.Fatalf("aggregateMarketIndexHistory returned nil")
+	}
+	expected := MarketIndexHistory{
+		IndexName: "DALAL50",
+		Close:     105,
+		CreatedAt: minute(0),
+		Interval:  uint32(FiveMinutes),
+		Open:      100,
+		High:      106,
+		Low:       99,
+	}
+	if *aggregate != expected {
+		t.Errorf("aggregateMarketIndexHistory = %+v, expected %+v", *aggregate, expected)
+	}
+
+	if aggregate := aggregateMarketIndexHistory(histories[3:], FiveMinutes, recordingTime); aggregate != nil {
+		t.Errorf("aggregateMarketIndexHistory with nothing in range = %+v, expected nil", *aggregate)
+	}
+}
+
+func TestBeatsMarketIndex(t *testing.T) {
+	var tests = []struct {
+		initialWorth int64
+		finalWorth   int64
+		initialIndex uint64
+		finalIndex   uint64
+		margin       uint64
+		beats        bool
+	}{
+		{1000, 1100, 100, 105, 0, true},
+		{1000, 1100, 100, 105, 5, true},
+		{1000, 1100, 100, 105, 6, false},
+		// losing less than the index beats it
+		{1000, 950, 100, 90, 0, true},
+		{1000, 1000, 0, 0, 0, true},
+		{0, 1000, 100, 100, 0, false},
+	}
+
+	for _, test := range tests {
+		if beats := beatsMarketIndex(test.initialWorth, test.finalWorth, test.initialIndex, test.finalIndex, test.margin); beats != test.beats {
+			t.Errorf("beatsMarketIndex(%+v) = %v, expected %v", test, beats, test.beats)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Merger records a stock that was absorbed into another. Every OldShares stocks of FromStockId became
+// NewShares stocks of ToStockId, and CashPerShare was paid for every stock of FromStockId.
+type Merger struct {
+	Id           uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	FromStockId  uint32 `gorm:"column:fromStockId;not null" json:"from_stock_id"`
+	ToStockId    uint32 `gorm:"column:toStockId;not null" json:"to_stock_id"`
+	NewShares    uint64 `gorm:"column:newShares;not null" json:"new_shares"`
+	OldShares    uint64 `gorm:"column:oldShares;not null" json:"old_shares"`
+	CashPerShare uint64 `gorm:"column:cashPerShare;not null" json:"cash_per_share"`
+	CreatedAt    string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (Merger) TableName() string {
+	return "Mergers"
+}
+
+func (m *Merger) ToProto() *models_pb.Merger {
+	return &models_pb.Merger{
+		Id:           m.Id,
+		FromStockId:  m.FromStockId,
+		ToStockId:    m.ToStockId,
+		NewShares:    m.NewShares,
+		OldShares:    m.OldShares,
+		CashPerShare: m.CashPerShare,
+		CreatedAt:    m.CreatedAt,
+	}
+}
+
+// mergedHolding is what a user's holding of the absorbed stock was converted into
+type mergedHolding struct {
+	userId      uint32
+	oldQuantity int64
+	newQuantity int64
+	cash        int64
+}
+
+// mergePosition adds addedQuantity stocks, bought at addedPrice or short sold if negative, to a position
+// of quantity stocks at price. The resulting position is returned. Stocks that net off a position keep
+// its price, and a position that flips over takes the price of the added stocks.
+func mergePosition(quantity int64, price float64, addedQuantity int64, addedPrice float64) (int64, float64) {
+	total := quantity + addedQuantity
+	switch {
+	case addedQuantity == 0:
+		return quantity, price
+	case quantity == 0:
+		return addedQuantity, addedPrice
+	case (quantity > 0) == (addedQuantity > 0):
+		return total, (float64(quantity)*price + float64(addedQuantity)*addedPrice) / float64(total)
+	case total == 0 || (total > 0) == (quantity > 0):
+		return total, price
+	default:
+		return total, addedPrice
+	}
+}
+
+// checkStockMerger checks if a stock can be merged into another at a swap ratio of newShares:oldShares
+func checkStockMerger(fromStockId, toStockId uint32, newShares, oldShares uint64) error {
+	if newShares == 0 || oldShares == 0 {
+		return CorporateActionError{"The swap ratio has to be N:M with N and M positive."}
+	}
+	if fromStockId == toStockId {
+		return CorporateActionError{"A stock can't be merged into itself."}
+	}
+
+	for _, stockId := range []uint32{fromStockId, toStockId} {
+		stock, err := GetStockCopy(stockId)
+		if err != nil || stock.IsDelisted {
+			return InvalidStockIdError{}
+		}
+		if stock.IsBankrupt {
+			return StockBankruptError{}
+		}
+	}
+
+	return checkNoOpenContracts(fromStockId)
+}
+
+// cancelOpenOrders expires all the open orders of a stock
+func cancelOpenOrders(stockId uint32) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "cancelOpenOrders",
+		"param_stockId": stockId,
+	})
+
+	asks, err := GetOpenAsksForStock(stockId)
+	if err != nil {
+		return err
+	}
+	bids, err := GetOpenBidsForStock(stockId)
+	if err != nil {
+		return err
+	}
+
+	// legs of a group that got closed along with a sibling give AlreadyClosedError
+	for _, ask := range asks {
+		if err := ExpireAskOrder(ask); err != nil {
+			if _, ok := err.(AlreadyClosedError); ok {
+				continue
+			}
+			l.Errorf("Unable to cancel ask %d: %+v", ask.Id, err)
+			return err
+		}
+	}
+	for _, bid := range bids {
+		if err := ExpireBidOrder(bid); err != nil {
+			if _, ok := err.(AlreadyClosedError); ok {
+				continue
+			}
+			l.Errorf("Unable to cancel bid %d: %+v", bid.Id, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MergeStocks absorbs the stock fromStockId into toStockId. Every oldShares stocks of it become newShares
+// stocks of toStockId, and cashPerShare is paid for every stock of it. It goes through everything that
+// holds stocks of the absorbed stock:
+//  1. Its open orders get cancelled.
+//  2. Holdings of every user are converted through a pair of MergerTransactions, one taking out the stocks
+//     of the absorbed stock and one giving the stocks of toStockId and the cash. Fractions of a stock left
+//     over are paid for in cash. Short sellers pay the cash part, as far as their cash goes.
+//  3. Stocks in mortgage, short sell lends, the short sell bank and transaction summaries are moved over.
+//  4. The absorbed stock is delisted, and its stocks in the exchange and the market are moved over.
+//
+// Trading in the absorbed stock must be stopped meanwhile, so it should be called through the matching
+// engine, which drops its order book afterwards.
+func MergeStocks(fromStockId, toStockId uint32, newShares, oldShares, cashPerShare uint64) (*Merger, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":             "MergeStocks",
+		"param_fromStockId":  fromStockId,
+		"param_toStockId":    toStockId,
+		"param_newShares":    newShares,
+		"param_oldShares":    oldShares,
+		"param_cashPerShare": cashPerShare,
+	})
+
+	l.Infof("Attempting")
+
+	if err := checkStockMerger(fromStockId, toStockId, newShares, oldShares); err != nil {
+		l.Errorf("Checks failed: %+v", err)
+		return nil, err
+	}
+
+	change := startMarketIndexChange()
+	defer change.end()
+
+	if err := cancelOpenOrders(fromStockId); err != nil {
+		return nil, err
+	}
+
+	db := getDB()
+
+	var holdings []*stockHolding
+	sql := "SELECT userId AS user_id, SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity FROM Transactions WHERE stockId = ? GROUP BY userId HAVING stock_quantity != 0 OR reserved_stock_quantity != 0"
+	if err := db.Raw(sql, fromStockId).Scan(&holdings).Error; err != nil {
+		l.Errorf("Unable to get the holdings: %+v", err)
+		return nil, err
+	}
+
+	var mortgages []*mortgagedStocks
+	sql = "SELECT userId AS user_id, stocksInBank AS stocks_in_bank, mortgagePrice AS mortgage_price FROM MortgageDetails WHERE stockId = ?"
+	if err := db.Raw(sql, fromStockId).Scan(&mortgages).Error; err != nil {
+		l.Errorf("Unable to get the mortgages: %+v", err)
+		return nil, err
+	}
+
+	holdingOf := make(map[uint32]*stockHolding)
+	for _, holding := range holdings {
+		holdingOf[holding.UserId] = holding
+	}
+
+	// stocks in mortgage get the cash part too
+	mortgagedQuantity := make(map[uint32]int64)
+	mortgageFractions := make(map[uint32]int64)
+	newMortgages := make(map[mortgagedStocks]uint64)
+	for _, mortgage := range mortgages {
+		if _, ok := holdingOf[mortgage.UserId]; !ok {
+			holdingOf[mortgage.UserId] = &stockHolding{UserId: mortgage.UserId}
+		}
+		mortgagedQuantity[mortgage.UserId] += int64(mortgage.StocksInBank)
+		stocksInBank, fraction := splitHolding(int64(mortgage.StocksInBank), newShares, oldShares)
+		mortgageFractions[mortgage.UserId] += fraction
+		if stocksInBank > 0 {
+			key := mortgagedStocks{UserId: mortgage.UserId, MortgagePrice: scalePrice(mortgage.MortgagePrice, newShares, oldShares)}
+			newMortgages[key] += uint64(stocksInBank)
+		}
+	}
+
+	userIds := holderIds(holdingOf)
+
+	fromStock, err := GetStockCopy(fromStockId)
+	if err != nil {
+		return nil, err
+	}
+	toStock, err := GetStockCopy(toStockId)
+	if err != nil {
+		return nil, err
+	}
+
+	merger := &Merger{
+		FromStockId:  fromStockId,
+		ToStockId:    toStockId,
+		NewShares:    newShares,
+		OldShares:    oldShares,
+		CashPerShare: cashPerShare,
+		CreatedAt:    utils.GetCurrentTimeISO8601(),
+	}
+
+	tx := db.Begin()
+
+	var transactions []*Transaction
+	var merged []mergedHolding
+	oldCash := make(map[*User]uint64)
+
+	errorHelper := func(format string, args ...interface{}) (*Merger, error) {
+		l.Errorf(format, args...)
+		for user, cash := range oldCash {
+			user.Cash = cash
+		}
+		tx.Rollback()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	if err := tx.Create(merger).Error; err != nil {
+		return errorHelper("Error recording the merger. Rolling back. Error: %+v", err)
+	}
+
+	for _, userId := range userIds {
+		holding := holdingOf[userId]
+
+		l.Debugf("Acquiring exclusive write on user %d", userId)
+		ch, user, err := getUserExclusively(userId)
+		if err != nil {
+			return errorHelper("Error acquiring exclusive write on user %d. Rolling back. Error: %+v", userId, err)
+		}
+		defer func(ch chan struct{}) {
+			close(ch)
+			l.Debugf("Released exclusive write on user")
+		}(ch)
+
+		oldQuantity := holding.StockQuantity + holding.ReservedStockQuantity
+		newQuantity, fraction := splitHolding(oldQuantity, newShares, oldShares)
+		cash := int64(getCashInLieu(fraction+mortgageFractions[userId], toStock.CurrentPrice, oldShares))
+		cash += (oldQuantity + mortgagedQuantity[userId]) * int64(cashPerShare)
+		// what a short seller can't pay is written off
+		if cash < 0 && uint64(-cash) > user.Cash {
+			cash = -int64(user.Cash)
+		}
+
+		if holding.StockQuantity != 0 || holding.ReservedStockQuantity != 0 {
+			transaction := GetTransactionRef(userId, fromStockId, MergerTransaction, -holding.ReservedStockQuantity, -holding.StockQuantity, fromStock.CurrentPrice, 0, 0)
+			if err := tx.Save(transaction).Error; err != nil {
+				return errorHelper("Error creating the transaction on the absorbed stock for user %d. Rolling back. Error: %+v", userId, err)
+			}
+			transactions = append(transactions, transaction)
+		}
+
+		if newQuantity != 0 || cash != 0 {
+			transaction := GetTransactionRef(userId, toStockId, MergerTransaction, 0, newQuantity, toStock.CurrentPrice, 0, cash)
+			if err := tx.Save(transaction).Error; err != nil {
+				return errorHelper("Error creating the transaction on the absorbing stock for user %d. Rolling back. Error: %+v", userId, err)
+			}
+			transactions = append(transactions, transaction)
+		}
+
+		if cash != 0 {
+			oldCash[user] = user.Cash
+			user.Cash = uint64(int64(user.Cash) + cash)
+			if err := tx.Save(user).Error; err != nil {
+				return errorHelper("Error paying the cash to user %d. Rolling back. Error: %+v", userId, err)
+			}
+		}
+
+		merged = append(merged, mergedHolding{userId, oldQuantity + mortgagedQuantity[userId], newQuantity, cash})
+
+		l.Debugf("Converted holdings of user %d. %d stocks became %d, cash: %d", userId, oldQuantity, newQuantity, cash)
+	}
+
+	if err := tx.Exec("DELETE FROM MortgageDetails WHERE stockId = ?", fromStockId).Error; err != nil {
+		return errorHelper("Error clearing the mortgages. Rolling back. Error: %+v", err)
+	}
+	for mortgage, stocksInBank := range newMortgages {
+		sql := "INSERT into MortgageDetails (userId, stockId, stocksInBank, mortgagePrice) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE stocksInBank = stocksInBank + VALUES(stocksInBank)"
+		if err := tx.Exec(sql, mortgage.UserId, toStockId, stocksInBank, mortgage.MortgagePrice).Error; err != nil {
+			return errorHelper("Error moving the mortgages. Rolling back. Error: %+v", err)
+		}
+	}
+
+	// a lend is a debt of stocks, so it's rounded up
+	sql = "UPDATE ShortSellLends SET stockId = ?, stockQuantity = CEIL(stockQuantity * ? / ?) WHERE stockId = ? AND isSquaredOff = 0"
+	if err := tx.Exec(sql, toStockId, newShares, oldShares, fromStockId).Error; err != nil {
+		return errorHelper("Error moving the short sell lends. Rolling back. Error: %+v", err)
+	}
+
+	fromBank := &ShortSellBank{}
+	if err := tx.Where("stockId = ?", fromStockId).FirstOrInit(fromBank).Error; err != nil {
+		return errorHelper("Error loading the short sell bank. Rolling back. Error: %+v", err)
+	}
+	if fromBank.AvailableStocks > 0 {
+		toBank := &ShortSellBank{}
+		if err := tx.Where("stockId = ?", toStockId).FirstOrInit(toBank).Error; err != nil {
+			return errorHelper("Error loading the short sell bank. Rolling back. Error: %+v", err)
+		}
+		toBank.StockId = toStockId
+		toBank.AvailableStocks += uint32(scaleQuantity(uint64(fromBank.AvailableStocks), newShares, oldShares))
+		if err := tx.Save(toBank).Error; err != nil {
+			return errorHelper("Error moving the short sell bank. Rolling back. Error: %+v", err)
+		}
+	}
+	if err := tx.Exec("DELETE FROM ShortSellBank WHERE stockId = ?", fromStockId).Error; err != nil {
+		return errorHelper("Error clearing the short sell bank. Rolling back. Error: %+v", err)
+	}
+
+	// positions carry over at the price they were taken at, so that taxes on gains stay the same.
+	// Gains realized on the absorbed stock stay in its summaries.
+	var summaries []*TransactionSummary
+	if err := tx.Where("stockId = ? AND stockQuantity != 0", fromStockId).Find(&summaries).Error; err != nil {
+		return errorHelper("Error loading the transaction summaries. Rolling back. Error: %+v", err)
+	}
+	for _, summary := range summaries {
+		toSummary := &TransactionSummary{}
+		if err := tx.Where("userId = ? AND stockId = ?", summary.UserId, toStockId).FirstOrInit(toSummary).Error; err != nil {
+			return errorHelper("Error loading the transaction summary of user %d. Rolling back. Error: %+v", summary.UserId, err)
+		}
+		toSummary.UserId = summary.UserId
+		toSummary.StockId = toStockId
+
+		quantity, _ := splitHolding(summary.StockQuantity, newShares, oldShares)
+		price := summary.Price * float64(oldShares) / float64(newShares)
+		toSummary.StockQuantity, toSummary.Price = mergePosition(toSummary.StockQuantity, toSummary.Price, quantity, price)
+
+		summary.StockQuantity = 0
+		if err := tx.Save(summary).Error; err != nil {
+			return errorHelper("Error clearing the transaction summary of user %d. Rolling back. Error: %+v", summary.UserId, err)
+		}
+		if err := tx.Save(toSummary).Error; err != nil {
+			return errorHelper("Error moving the transaction summary of user %d. Rolling back. Error: %+v", summary.UserId, err)
+		}
+	}
+
+	allStocks.Lock()
+	fromStockNLock, fromOk := allStocks.m[fromStockId]
+	toStockNLock, toOk := allStocks.m[toStockId]
+	allStocks.Unlock()
+	if !fromOk || !toOk {
+		return errorHelper("Not found stock for id %d or %d", fromStockId, toStockId)
+	}
+
+	// the stocks are locked in the order of their ids, like users are in getUserPairExclusive,
+	// so that this can't deadlock with anything else that locks both of them
+	firstStockNLock, secondStockNLock := fromStockNLock, toStockNLock
+	if toStockId < fromStockId {
+		firstStockNLock, secondStockNLock = toStockNLock, fromStockNLock
+	}
+	firstStockNLock.Lock()
+	defer firstStockNLock.Unlock()
+	secondStockNLock.Lock()
+	defer secondStockNLock.Unlock()
+
+	from, to := fromStockNLock.stock, toStockNLock.stock
+	oldFromCopy, oldToCopy := *from, *to
+
+	stockErrorHelper := func(format string, args ...interface{}) (*Merger, error) {
+		*from, *to = oldFromCopy, oldToCopy
+		return errorHelper(format, args...)
+	}
+
+	updatedAt := utils.GetCurrentTimeISO8601()
+
+	to.StocksInExchange += scaleQuantity(from.StocksInExchange, newShares, oldShares)
+	to.StocksInMarket += scaleQuantity(from.StocksInMarket, newShares, oldShares)
+	to.UpdatedAt = updatedAt
+
+	from.IsDelisted = true
+	from.StocksInExchange = 0
+	from.StocksInMarket = 0
+	from.UpdatedAt = updatedAt
+
+	if err := tx.Save(from).Error; err != nil {
+		return stockErrorHelper("Error delisting the absorbed stock. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(to).Error; err != nil {
+		return stockErrorHelper("Error saving the absorbing stock. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return stockErrorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	change.changed(fromStockId, &oldFromCopy)
+	change.changed(toStockId, &oldToCopy)
+
+	l.Infof("Merged stock %d into %d. Converted the holdings of %d users", fromStockId, toStockId, len(merged))
+
+	go sendMergerUpdates(*from, *to, merger, transactions, merged)
+
+	return merger, nil
+}
+
+func sendMergerUpdates(from, to Stock, merger *Merger, transactions []*Transaction, merged []mergedHolding) {
+	stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+	for _, stock := range []Stock{from, to} {
+		stockExchangeStream.SendStockExchangeUpdate(stock.Id, &datastreams_pb.StockExchangeDataPoint{
+			Price:            stock.CurrentPrice,
+			StocksInExchange: stock.StocksInExchange,
+			StocksInMarket:   stock.StocksInMarket,
+		})
+	}
+
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+	for _, transaction := range transactions {
+		transactionsStream.SendTransaction(transaction.ToProto())
+	}
+
+	for _, holding := range merged {
+		text := fmt.Sprintf("Your %d shares of %s have been converted into %d shares of %s", holding.oldQuantity, from.FullName, holding.newQuantity, to.FullName)
+		if holding.cash > 0 {
+			text += fmt.Sprintf(", along with %d in cash", holding.cash)
+		} else if holding.cash < 0 {
+			text += fmt.Sprintf(". You paid %d in cash for your short position", -holding.cash)
+		}
+		SendNotification(holding.userId, text+".", false)
+	}
+
+	message := fmt.Sprintf("%s has been merged into %s. Every %d shares of %s became %d shares of %s", from.FullName, to.FullName, merger.OldShares, from.FullName, merger.NewShares, to.FullName)
+	if merger.CashPerShare > 0 {
+		message += fmt.Sprintf(" along with %d in cash per share", merger.CashPerShare)
+	}
+	message += "."
+
+	SendPushNotification(0, PushNotification{
+		Title:   "Message from Dalal Street! Two companies just merged.",
+		Message: message,
+		LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+	})
+	SendNotification(0, message, true)
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestMergePosition(t *testing.T) {
+	var tests = []struct {
+		quantity      int64
+		price         float64
+		addedQuantity int64
+		addedPrice    float64
+		newQuantity   int64
+		newPrice      float64
+	}{
+		{10, 100, 0, 40, 10, 100},
+		{0, 0, 6, 50, 6, 50},
+		{10, 100, 5, 40, 15, 80},
+		{-10, 100, -10, 50, -20, 75},
+		// the added stocks net off part of the position
+		{10, 100, -4, 50, 6, 100},
+		{10, 100, -10, 50, 0, 100},
+		// the position flips over
+		{10, 100, -15, 50, -5, 50},
+		{-3, 100, 5, 50, 2, 50},
+	}
+
+	for _, test := range tests {
+		newQuantity, newPrice := mergePosition(test.quantity, test.price, test.addedQuantity, test.addedPrice)
+		if newQuantity != test.newQuantity || newPrice != test.newPrice {
+			t.Errorf("mergePosition(%+v) = (%d, %v), expected (%d, %v)", test, newQuantity, newPrice, test.newQuantity, test.newPrice)
+		}
+	}
+}
+
+func Test_MergeStocks(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 1000}}
+	stocks := []*Stock{
+		{Id: 1, CurrentPrice: 100, StocksInExchange: 40, StocksInMarket: 20},
+		{Id: 2, CurrentPrice: 300, StocksInExchange: 100, StocksInMarket: 30},
+	}
+	ssb := &ShortSellBank{StockId: 1, AvailableStocks: 7}
+	lend := &ShortSellLends{UserId: 3, StockId: 1, StockQuantity: 3}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM ShortSellLends")
+		db.Exec("DELETE FROM ShortSellBank")
+		db.Exec("DELETE FROM Mergers")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stocks)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stock := range stocks {
+		if err := db.Create(stock).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	LoadStocks()
+	if err := db.Create(ssb).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(lend).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// user 2 holds 5 stocks of stock 1, and user 3 has short sold 3 of them
+	if err := db.Create(GetTransactionRef(2, 1, FromExchangeTransaction, 0, 5, 100, 0, -500)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, 1, OrderFillTransaction, 0, -3, 100, 0, 300)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MergeStocks(1, 1, 1, 2, 10); err == nil {
+		t.Fatalf("Expected merging a stock into itself to fail")
+	}
+
+	// every 2 stocks of stock 1 become 1 of stock 2 and 20 in cash. Half a stock of stock 2 is worth 150.
+	if _, err := MergeStocks(1, 2, 1, 2, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	type merged struct {
+		StockId       uint32
+		StockQuantity int64
+		Total         int64
+	}
+	var tests = []struct {
+		userId       uint32
+		transactions []merged
+		cash         uint64
+	}{
+		// 5 stocks become 2, with 150 for the half a stock and 50 for the 5 stocks
+		{2, []merged{{1, -5, 0}, {2, 2, 200}}, 1200},
+		// a short of 3 is 1.5 stocks of stock 2, rounded down to a short of 2. The half a stock more is paid
+		// for with 150, and the short seller pays the 30 for the 3 stocks.
+		{3, []merged{{1, 3, 0}, {2, -2, 120}}, 1120},
+	}
+	for _, test := range tests {
+		var transactions []*Transaction
+		if err := db.Where("userId = ? AND type = ?", test.userId, MergerTransaction.String()).Order("id").Find(&transactions).Error; err != nil {
+			t.Fatal(err)
+		}
+		var got []merged
+		for _, transaction := range transactions {
+			got = append(got, merged{transaction.StockId, transaction.StockQuantity, transaction.Total})
+		}
+		testutils.AssertEqual(t, test.transactions, got)
+
+		u := &User{}
+		db.First(u, test.userId)
+		testutils.AssertEqual(t, test.cash, u.Cash)
+	}
+
+	from, to := &Stock{}, &Stock{}
+	db.First(from, 1)
+	db.First(to, 2)
+	testutils.AssertEqual(t, true, from.IsDelisted)
+	testutils.AssertEqual(t, uint64(0), from.StocksInExchange)
+	testutils.AssertEqual(t, uint64(0), from.StocksInMarket)
+	testutils.AssertEqual(t, uint64(120), to.StocksInExchange)
+	testutils.AssertEqual(t, uint64(40), to.StocksInMarket)
+
+	// the lend of 3 is rounded up to 2 stocks of stock 2, and the 7 stocks in the bank down to 3
+	l := &ShortSellLends{}
+	db.First(l, lend.Id)
+	testutils.AssertEqual(t, uint32(2), l.StockId)
+	testutils.AssertEqual(t, uint32(2), l.StockQuantity)
+
+	var banks []*ShortSellBank
+	db.Find(&banks)
+	testutils.AssertEqual(t, []*ShortSellBank{{StockId: 2, AvailableStocks: 3}}, banks)
+
+	if _, err := MergeStocks(1, 2, 1, 2, 10); err == nil {
+		t.Fatalf("Expected merging a delisted stock to fail")
+	}
+}
@@ -0,0 +1,554 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+type OptionType uint8
+
+func (ot *OptionType) Scan(value interface{}) error {
+	switch string(value.([]byte)) {
+	case "Call":
+		*ot = Call
+	case "Put":
+		*ot = Put
+	default:
+		return fmt.Errorf("Invalid value for OptionType. Got %s", string(value.([]byte)))
+	}
+	return nil
+}
+
+func (ot OptionType) Value() (driver.Value, error) { return ot.String(), nil }
+
+const (
+	// Call gives its holder the stock's rise over the strike price
+	Call OptionType = iota
+	// Put gives its holder the stock's fall below the strike price
+	Put
+)
+
+var optionTypes = [...]string{
+	"Call",
+	"Put",
+}
+
+func (ot OptionType) String() string {
+	return optionTypes[ot]
+}
+
+func OptionTypeFromProto(pOt models_pb.OptionType) OptionType {
+	if pOt == models_pb.OptionType_PUT {
+		return Put
+	}
+	return Call
+}
+
+func (ot OptionType) ToProto() models_pb.OptionType {
+	if ot == Put {
+		return models_pb.OptionType_PUT
+	}
+	return models_pb.OptionType_CALL
+}
+
+// OptionContract is a call or put on a stock listed by the admin. Its orders trade on an order book
+// of its own. Prices of its orders are premiums per stock, so a contract costs Price * LotSize.
+// Contracts are settled in cash against the stock's current price when the market closes on ExpiryDay.
+type OptionContract struct {
+	Id          uint32     `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId     uint32     `gorm:"column:stockId;not null" json:"stock_id"`
+	Type        OptionType `gorm:"column:type;not null" json:"type"`
+	StrikePrice uint64     `gorm:"column:strikePrice;not null" json:"strike_price"`
+	LotSize     uint64     `gorm:"column:lotSize;not null" json:"lot_size"`
+	ExpiryDay   uint32     `gorm:"column:expiryDay;not null" json:"expiry_day"`
+	IsSettled   bool       `gorm:"column:isSettled;not null" json:"is_settled"`
+	// price of the stock the contracts were settled at. 0 till then.
+	SettlementPrice uint64 `gorm:"column:settlementPrice;not null" json:"settlement_price"`
+	CreatedAt       string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (OptionContract) TableName() string {
+	return "OptionContracts"
+}
+
+func (oc *OptionContract) ToProto() *models_pb.OptionContract {
+	return &models_pb.OptionContract{
+		Id:              oc.Id,
+		StockId:         oc.StockId,
+		Type:            oc.Type.ToProto(),
+		StrikePrice:     oc.StrikePrice,
+		LotSize:         oc.LotSize,
+		ExpiryDay:       oc.ExpiryDay,
+		IsSettled:       oc.IsSettled,
+		SettlementPrice: oc.SettlementPrice,
+		CreatedAt:       oc.CreatedAt,
+	}
+}
+
+// OptionPosition holds the contracts of an option a user holds or has written
+type OptionPosition struct {
+	Id       uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId   uint32 `gorm:"column:userId;not null" json:"user_id"`
+	OptionId uint32 `gorm:"column:optionId;not null" json:"option_id"`
+	// contracts held if positive, contracts written if negative
+	Quantity int64 `gorm:"column:quantity;not null" json:"quantity"`
+	// contracts held that are being sold by open asks
+	ReservedQuantity uint64 `gorm:"column:reservedQuantity;not null" json:"reserved_quantity"`
+	// cash and stocks the written contracts are collateralised with. They stay reserved till the contracts
+	// are bought back or settled.
+	CollateralCash   uint64 `gorm:"column:collateralCash;not null" json:"collateral_cash"`
+	CollateralStocks uint64 `gorm:"column:collateralStocks;not null" json:"collateral_stocks"`
+	UpdatedAt        string `gorm:"column:updatedAt;not null" json:"updated_at"`
+}
+
+func (OptionPosition) TableName() string {
+	return "OptionPositions"
+}
+
+func (op *OptionPosition) ToProto() *models_pb.OptionPosition {
+	return &models_pb.OptionPosition{
+		OptionId:         op.OptionId,
+		Quantity:         op.Quantity,
+		ReservedQuantity: op.ReservedQuantity,
+		CollateralCash:   op.CollateralCash,
+		CollateralStocks: op.CollateralStocks,
+	}
+}
+
+// InvalidOptionError is returned if an option doesn't exist, or can't be traded anymore
+type InvalidOptionError struct{ reason string }
+
+func (e InvalidOptionError) Error() string {
+	return e.reason
+}
+
+// InvalidOptionOrderError is returned if an order can't be placed for an option
+type InvalidOptionOrderError struct{ reason string }
+
+func (e InvalidOptionOrderError) Error() string {
+	return e.reason
+}
+
+// NotEnoughOptionsError is returned if a user sells more contracts than they hold
+type NotEnoughOptionsError struct{ available uint64 }
+
+func (e NotEnoughOptionsError) Error() string {
+	return fmt.Sprintf("You can sell at most %d contracts of the option you hold. Close your position before writing new contracts.", e.available)
+}
+
+// getOptionIntrinsicValue returns what a contract of the option is worth per stock at the given price of the stock
+func getOptionIntrinsicValue(optionType OptionType, strikePrice, price uint64) uint64 {
+	if optionType == Call && price > strikePrice {
+		return price - strikePrice
+	}
+	if optionType == Put && strikePrice > price {
+		return strikePrice - price
+	}
+	return 0
+}
+
+// getOptionCollateral returns the cash and stocks that collateralise quantity written contracts of the
+// option. Calls are covered by the stocks, and puts by the cash needed to buy them at the strike price.
+func getOptionCollateral(option *OptionContract, quantity uint64) (cash uint64, stocks uint64) {
+	if option.Type == Call {
+		return 0, quantity * option.LotSize
+	}
+	return quantity * option.LotSize * option.StrikePrice, 0
+}
+
+// getReleasedCollateral returns the part of the collateral of written contracts that's released when
+// closed of them are bought back. All of it is released once all of them are.
+func getReleasedCollateral(collateral, closed, written uint64) uint64 {
+	if closed >= written {
+		return collateral
+	}
+	return collateral * closed / written
+}
+
+// getWriterSettlement returns the collateral a writer gets back after paying owed at settlement.
+// owed comes out of the cash collateral first. The rest is paid with collateral stocks, valued at price.
+// Any change from the last stock is paid back in cash.
+func getWriterSettlement(owed, collateralCash, collateralStocks, price uint64) (cashReturned uint64, stocksReturned uint64) {
+	paid := owed
+	if paid > collateralCash {
+		paid = collateralCash
+	}
+	cashReturned = collateralCash - paid
+	rest := owed - paid
+
+	if rest == 0 || price == 0 {
+		return cashReturned, collateralStocks
+	}
+
+	sold := (rest + price - 1) / price
+	if sold > collateralStocks {
+		sold = collateralStocks
+	}
+	if sold*price > rest {
+		cashReturned += sold*price - rest
+	}
+	return cashReturned, collateralStocks - sold
+}
+
+// getOptionContract returns an option from the database
+func getOptionContract(db *gorm.DB, optionId uint32) (*OptionContract, error) {
+	option := &OptionContract{}
+	if err := db.First(option, optionId).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidOptionError{"Invalid option"}
+		}
+		return nil, err
+	}
+	return option, nil
+}
+
+// getOptionPosition returns a user's position in an option. An empty one is returned if the user
+// never traded the option.
+func getOptionPosition(db *gorm.DB, userId, optionId uint32) (*OptionPosition, error) {
+	position := &OptionPosition{}
+	if err := db.Where("userId = ? and optionId = ?", userId, optionId).FirstOrInit(position).Error; err != nil {
+		return nil, err
+	}
+	position.UserId = userId
+	position.OptionId = optionId
+	return position, nil
+}
+
+// ListOption lists a new call or put on a stock, expiring on expiryDay. The matching engine has to
+// be told to open an order book for it.
+func ListOption(stockId uint32, optionType OptionType, strikePrice, lotSize uint64, expiryDay uint32) (*OptionContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":            "ListOption",
+		"param_stockId":     stockId,
+		"param_optionType":  optionType,
+		"param_strikePrice": strikePrice,
+		"param_lotSize":     lotSize,
+		"param_expiryDay":   expiryDay,
+	})
+
+	l.Infof("Attempting")
+
+	if stock, err := GetStockCopy(stockId); err != nil || stock.IsDelisted {
+		return nil, InvalidStockIdError{}
+	}
+	if IsStockBankrupt(stockId) {
+		return nil, StockBankruptError{}
+	}
+	if strikePrice == 0 {
+		return nil, InvalidOptionError{"Strike price must be more than 0."}
+	}
+	if lotSize == 0 {
+		return nil, InvalidOptionError{"Lot size must be more than 0."}
+	}
+	if expiryDay < GetMarketDay() {
+		return nil, InvalidOptionError{"The option can't expire on a market day that's over."}
+	}
+
+	option := &OptionContract{
+		StockId:     stockId,
+		Type:        optionType,
+		StrikePrice: strikePrice,
+		LotSize:     lotSize,
+		ExpiryDay:   expiryDay,
+		CreatedAt:   utils.GetCurrentTimeISO8601(),
+	}
+
+	db := getDB()
+	if err := db.Create(option).Error; err != nil {
+		l.Errorf("Error while creating the option: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Listed option %d", option.Id)
+	return option, nil
+}
+
+// GetOpenOptions returns the options that haven't been settled yet
+func GetOpenOptions() ([]*OptionContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetOpenOptions",
+	})
+
+	db := getDB()
+
+	var options []*OptionContract
+	if err := db.Where("isSettled = ?", false).Order("id").Find(&options).Error; err != nil {
+		l.Errorf("Error while loading options: %+v", err)
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// GetOptionPositions returns the open positions of a user in options
+func GetOptionPositions(userId uint32) ([]*OptionPosition, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetOptionPositions",
+		"param_userId": userId,
+	})
+
+	db := getDB()
+
+	var positions []*OptionPosition
+	if err := db.Where("userId = ? and quantity != 0", userId).Find(&positions).Error; err != nil {
+		l.Errorf("Error while loading option positions: %+v", err)
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// GetExpiringOptions returns the options that expire on the current market day or before, and haven't
+// been settled yet
+func GetExpiringOptions() ([]*OptionContract, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetExpiringOptions",
+	})
+
+	db := getDB()
+
+	var options []*OptionContract
+	if err := db.Where("isSettled = ? and expiryDay <= ?", false, GetMarketDay()).Find(&options).Error; err != nil {
+		l.Errorf("Error while loading expiring options: %+v", err)
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// SettleOption settles an expired option in cash against the current price of its stock. Its open
+// orders are closed first, returning what they reserved. Holders are then paid what their contracts
+// are worth, and writers pay that out of their collateral and get the rest of it back.
+// The matching engine has to drop the option's order book before calling it.
+func SettleOption(optionId uint32) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "SettleOption",
+		"param_optionId": optionId,
+	})
+
+	l.Infof("Attempting")
+
+	db := getDB()
+
+	option, err := getOptionContract(db, optionId)
+	if err != nil {
+		l.Errorf("Error while loading the option: %+v", err)
+		return err
+	}
+	if option.IsSettled {
+		return nil
+	}
+
+	stock, err := GetStockCopy(option.StockId)
+	if err != nil {
+		l.Errorf("Error while loading stock %d: %+v", option.StockId, err)
+		return err
+	}
+	price := stock.CurrentPrice
+
+	var askIds, bidIds []uint32
+	if err := db.Model(&Ask{}).Where("optionId = ? and isClosed = ?", optionId, false).Pluck("id", &askIds).Error; err != nil {
+		l.Errorf("Error while loading open asks: %+v", err)
+		return err
+	}
+	if err := db.Model(&Bid{}).Where("optionId = ? and isClosed = ?", optionId, false).Pluck("id", &bidIds).Error; err != nil {
+		l.Errorf("Error while loading open bids: %+v", err)
+		return err
+	}
+
+	for _, id := range askIds {
+		ask, err := getAsk(id)
+		if err != nil {
+			return err
+		}
+		if err := ExpireAskOrder(ask); err != nil {
+			if _, ok := err.(AlreadyClosedError); !ok {
+				l.Errorf("Error while closing ask %d: %+v", id, err)
+				return err
+			}
+		}
+	}
+
+	for _, id := range bidIds {
+		bid, err := getBid(id)
+		if err != nil {
+			return err
+		}
+		if err := ExpireBidOrder(bid); err != nil {
+			if _, ok := err.(AlreadyClosedError); !ok {
+				l.Errorf("Error while closing bid %d: %+v", id, err)
+				return err
+			}
+		}
+	}
+
+	var positions []*OptionPosition
+	if err := db.Where("optionId = ? and quantity != 0", optionId).Find(&positions).Error; err != nil {
+		l.Errorf("Error while loading positions: %+v", err)
+		return err
+	}
+
+	for _, position := range positions {
+		if err := settleOptionPosition(option, position, price); err != nil {
+			l.Errorf("Error while settling the position of user %d: %+v", position.UserId, err)
+			return err
+		}
+	}
+
+	option.IsSettled = true
+	option.SettlementPrice = price
+	if err := db.Save(option).Error; err != nil {
+		l.Errorf("Error while marking the option settled: %+v", err)
+		return err
+	}
+
+	l.Infof("Settled %d positions at %d", len(positions), price)
+	return nil
+}
+
+// settleOptionPosition settles a user's position in an expired option at the given price of its stock
+func settleOptionPosition(option *OptionContract, position *OptionPosition, price uint64) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "settleOptionPosition",
+		"param_optionId": option.Id,
+		"param_userId":   position.UserId,
+		"param_price":    price,
+	})
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(position.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	l.Debugf("Acquiring exclusive write on stock")
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[option.StockId]
+	if !ok {
+		allStocks.Unlock()
+		return InvalidStockError
+	}
+	allStocks.Unlock()
+
+	stockNLock.Lock()
+	stock := stockNLock.stock
+	defer func() {
+		stockNLock.Unlock()
+		l.Debugf("Released exclusive write on stock")
+	}()
+
+	intrinsicValue := getOptionIntrinsicValue(option.Type, option.StrikePrice, price)
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	var settlementTransaction *Transaction
+	var notification string
+	// collateral stocks that were sold to pay what a writer owes. They go back to the exchange.
+	var stocksSold uint64
+
+	if position.Quantity > 0 {
+		payout := uint64(position.Quantity) * option.LotSize * intrinsicValue
+		settlementTransaction = GetTransactionRef(user.Id, option.StockId, OptionSettlementTransaction, 0, 0, price, 0, int64(payout))
+		user.Cash += payout
+		notification = fmt.Sprintf("Your %d contracts of option#%d have been settled at %d. You've been paid %d.", position.Quantity, option.Id, price, payout)
+	} else {
+		owed := uint64(-position.Quantity) * option.LotSize * intrinsicValue
+		cashReturned, stocksReturned := getWriterSettlement(owed, position.CollateralCash, position.CollateralStocks, price)
+		settlementTransaction = GetTransactionRef(
+			user.Id,
+			option.StockId,
+			OptionSettlementTransaction,
+			-int64(position.CollateralStocks),
+			int64(stocksReturned),
+			price,
+			-int64(position.CollateralCash),
+			int64(cashReturned),
+		)
+		user.ReservedCash -= position.CollateralCash
+		user.Cash += cashReturned
+		stocksSold = position.CollateralStocks - stocksReturned
+		notification = fmt.Sprintf("The %d contracts of option#%d you wrote have been settled at %d. You paid %d out of their collateral.", -position.Quantity, option.Id, price, owed)
+	}
+	settlementTransaction.OptionId = option.Id
+
+	position.Quantity = 0
+	position.ReservedQuantity = 0
+	position.CollateralCash = 0
+	position.CollateralStocks = 0
+	position.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	oldStocksInMarket := stock.StocksInMarket
+	oldStocksInExchange := stock.StocksInExchange
+	oldUpdatedAt := stock.UpdatedAt
+
+	if stocksSold > 0 {
+		if stocksSold > stock.StocksInMarket {
+			stocksSold = stock.StocksInMarket
+		}
+		stock.StocksInMarket -= stocksSold
+		stock.StocksInExchange += stocksSold
+		stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+	}
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		stock.StocksInMarket = oldStocksInMarket
+		stock.StocksInExchange = oldStocksInExchange
+		stock.UpdatedAt = oldUpdatedAt
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error while saving the user. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(settlementTransaction).Error; err != nil {
+		return errorHelper("Error while saving the settlement transaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(position).Error; err != nil {
+		return errorHelper("Error while saving the position. Rolling back. Error: %+v", err)
+	}
+	if stocksSold > 0 {
+		if err := tx.Save(stock).Error; err != nil {
+			return errorHelper("Error while returning the sold collateral stocks to the exchange. Rolling back. Error: %+v", err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error while committing. Rolling back. Error: %+v", err)
+	}
+
+	go func(price, inExchange, inMarket uint64) {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(settlementTransaction.ToProto())
+		if stocksSold > 0 {
+			stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+			stockExchangeStream.SendStockExchangeUpdate(option.StockId, &datastreams_pb.StockExchangeDataPoint{
+				Price:            price,
+				StocksInExchange: inExchange,
+				StocksInMarket:   inMarket,
+			})
+		}
+		SendNotification(user.Id, notification, false)
+	}(stock.CurrentPrice, stock.StocksInExchange, stock.StocksInMarket)
+
+	l.Infof("Settled")
+	return nil
+}
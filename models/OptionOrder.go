@@ -0,0 +1,633 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// checkOptionOrder checks an order placed for an option, and returns the option.
+// Options only take plain limit orders. They can't be icebergs, trailing stops or legs of groups.
+func checkOptionOrder(optionId uint32, orderType OrderType, timeInForce TimeInForce, expiresOnDay uint32, price, quantity, quantityLimit uint64, isPlain bool) (*OptionContract, error) {
+	option, err := getOptionContract(getDB(), optionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if option.IsSettled || option.ExpiryDay < GetMarketDay() {
+		return nil, InvalidOptionError{"The option has expired."}
+	}
+	if IsStockBankrupt(option.StockId) {
+		return nil, StockBankruptError{}
+	}
+	if err := checkStockHalted(option.StockId); err != nil {
+		return nil, err
+	}
+
+	if orderType != Limit || !isPlain {
+		return nil, InvalidOptionOrderError{"Only plain limit orders can be placed for options."}
+	}
+	if err := checkTimeInForce(orderType, timeInForce, expiresOnDay); err != nil {
+		return nil, err
+	}
+	if timeInForce == GoodTillDate && expiresOnDay > option.ExpiryDay {
+		return nil, InvalidTimeInForceError{fmt.Sprintf("The order must expire by day %d, when the option expires.", option.ExpiryDay)}
+	}
+
+	if price == 0 {
+		return nil, InvalidOptionOrderError{"The premium must be more than 0."}
+	}
+	if quantity > quantityLimit || quantity < 1 {
+		return nil, OrderStockLimitExceeded{}
+	}
+
+	return option, nil
+}
+
+// countOpenOptionOrders returns the number of open orders a user has for an option. Write asks or bids are counted.
+func countOpenOptionOrders(db *gorm.DB, userId, optionId uint32, writeAsks bool) (int, error) {
+	var count int
+	var err error
+	if writeAsks {
+		err = db.Model(&Ask{}).Where("userId = ? and optionId = ? and isOptionWrite = ? and isClosed = ?", userId, optionId, true, false).Count(&count).Error
+	} else {
+		err = db.Model(&Bid{}).Where("userId = ? and optionId = ? and isClosed = ?", userId, optionId, false).Count(&count).Error
+	}
+	return count, err
+}
+
+// sendNewOptionOrderUpdates adds a newly placed option order to the user's open orders
+func sendNewOptionOrderUpdates(userId, orderId uint32, isAsk bool, stockId, optionId uint32, price, quantity uint64, placeOrderTransaction *Transaction) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:            orderId,
+		IsAsk:         isAsk,
+		IsNewOrder:    true,
+		StockId:       stockId,
+		OptionId:      optionId,
+		OrderPrice:    price,
+		StockQuantity: quantity,
+	})
+
+	if placeOrderTransaction != nil {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(placeOrderTransaction.ToProto())
+	}
+}
+
+// PlaceOptionAskOrder places an ask for contracts of an option. StockQuantity of the ask is the number of contracts.
+// An ask of a user holding contracts sells them, and they're reserved till it's closed. Otherwise the ask
+// writes new contracts, and their collateral is reserved: the stocks for calls and the cash for puts.
+// A user can't write contracts while they have bids open for the option.
+func PlaceOptionAskOrder(userId uint32, ask *Ask) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "PlaceOptionAskOrder",
+		"param_id":  userId,
+		"param_ask": fmt.Sprintf("%+v", ask),
+	})
+
+	l.Infof("PlaceOptionAskOrder requested")
+
+	isPlain := !ask.IsIceberg && ask.TrailingAmount == 0 && ask.TrailingPercent == 0 && ask.GroupId == 0
+	option, err := checkOptionOrder(ask.OptionId, ask.OrderType, ask.TimeInForce, ask.ExpiresOnDay, ask.Price, ask.StockQuantity, ASK_LIMIT, isPlain)
+	if err != nil {
+		l.Debugf("Option order check failed: %+v", err)
+		return 0, err
+	}
+	ask.StockId = option.StockId
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return 0, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+
+	position, err := getOptionPosition(db, userId, ask.OptionId)
+	if err != nil {
+		l.Errorf("Error while loading the position: %+v", err)
+		return 0, err
+	}
+
+	// Selling contracts the user holds
+	if position.Quantity > 0 {
+		available := uint64(position.Quantity) - position.ReservedQuantity
+		if ask.StockQuantity > available {
+			l.Debugf("Not enough contracts. Has %d available", available)
+			return 0, NotEnoughOptionsError{available}
+		}
+
+		tx := db.Begin()
+
+		ask.IsOptionWrite = false
+		if err := createAsk(ask, tx); err != nil {
+			l.Errorf("Error while creating ask. Rolling back. Error: %+v", err)
+			tx.Rollback()
+			return 0, err
+		}
+
+		position.ReservedQuantity += ask.StockQuantity
+		position.UpdatedAt = utils.GetCurrentTimeISO8601()
+		if err := tx.Save(position).Error; err != nil {
+			l.Errorf("Error while reserving contracts. Rolling back. Error: %+v", err)
+			tx.Rollback()
+			return 0, err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			l.Errorf("Error committing the transaction. Failing. %+v", err)
+			tx.Rollback()
+			return 0, err
+		}
+
+		go sendNewOptionOrderUpdates(userId, ask.Id, true, ask.StockId, ask.OptionId, ask.Price, ask.StockQuantity, nil)
+
+		l.Infof("Placed ask %d selling %d contracts", ask.Id, ask.StockQuantity)
+		return ask.Id, nil
+	}
+
+	// Writing new contracts
+	openBids, err := countOpenOptionOrders(db, userId, ask.OptionId, false)
+	if err != nil {
+		l.Errorf("Error while counting open bids: %+v", err)
+		return 0, err
+	}
+	if openBids > 0 {
+		return 0, InvalidOptionOrderError{"Cancel your buy orders of the option before writing contracts."}
+	}
+
+	collateralCash, collateralStocks := getOptionCollateral(option, ask.StockQuantity)
+
+	if collateralStocks > 0 {
+		stocks, err := getSingleStockCount(user, ask.StockId)
+		if err != nil {
+			l.Errorf("Error while counting stocks: %+v", err)
+			return 0, err
+		}
+		if stocks < int64(collateralStocks) {
+			l.Debugf("Not enough stocks to cover the calls. Has %d", stocks)
+			return 0, NotEnoughStocksError{stocks}
+		}
+	}
+	if user.Cash < collateralCash {
+		l.Debugf("Not enough cash to cover the puts. Has %d", user.Cash)
+		return 0, NotEnoughCashError{}
+	}
+
+	tx := db.Begin()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	var errorHelper = func(format string, err error) (uint32, error) {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return 0, err
+	}
+
+	ask.IsOptionWrite = true
+	if err := createAsk(ask, tx); err != nil {
+		return errorHelper("Error while creating ask. Rolling back. Error: %+v", err)
+	}
+
+	if collateralCash > 0 {
+		if err := AddUserReservedCash(user, collateralCash, tx); err != nil {
+			return errorHelper("Error while adding reserved cash to the user. Rolling back. Error: %+v", err)
+		}
+		if err := SubtractUserCash(user, collateralCash, tx); err != nil {
+			return errorHelper("Error subtracting cash. Rolling back. Error: %+v", err)
+		}
+	}
+
+	placeOrderTransaction := GetTransactionRef(
+		userId,
+		ask.StockId,
+		PlaceOrderTransaction,
+		int64(collateralStocks),
+		-int64(collateralStocks),
+		0,
+		int64(collateralCash),
+		-int64(collateralCash),
+	)
+	placeOrderTransaction.OptionId = ask.OptionId
+
+	if err := savePlaceOrderTransaction(ask.Id, placeOrderTransaction, true, tx); err != nil {
+		return errorHelper("Error reserving collateral. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	go sendNewOptionOrderUpdates(userId, ask.Id, true, ask.StockId, ask.OptionId, ask.Price, ask.StockQuantity, placeOrderTransaction)
+
+	l.Infof("Placed ask %d writing %d contracts", ask.Id, ask.StockQuantity)
+	return ask.Id, nil
+}
+
+// PlaceOptionBidOrder places a bid for contracts of an option. StockQuantity of the bid is the number of contracts.
+// The premium for all of them is reserved, like for bids of stocks. A user can't place bids while they
+// have asks open that write contracts of the option.
+func PlaceOptionBidOrder(userId uint32, bid *Bid) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "PlaceOptionBidOrder",
+		"param_id":  userId,
+		"param_bid": fmt.Sprintf("%+v", bid),
+	})
+
+	l.Infof("PlaceOptionBidOrder requested")
+
+	isPlain := !bid.IsIceberg && bid.TrailingAmount == 0 && bid.TrailingPercent == 0 && bid.GroupId == 0
+	option, err := checkOptionOrder(bid.OptionId, bid.OrderType, bid.TimeInForce, bid.ExpiresOnDay, bid.Price, bid.StockQuantity, BID_LIMIT, isPlain)
+	if err != nil {
+		l.Debugf("Option order check failed: %+v", err)
+		return 0, err
+	}
+	bid.StockId = option.StockId
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return 0, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+
+	openWrites, err := countOpenOptionOrders(db, userId, bid.OptionId, true)
+	if err != nil {
+		l.Errorf("Error while counting open asks: %+v", err)
+		return 0, err
+	}
+	if openWrites > 0 {
+		return 0, InvalidOptionOrderError{"Cancel your orders writing contracts of the option before buying it."}
+	}
+
+	reservedCash := bid.Price * option.LotSize * bid.StockQuantity
+	if user.Cash < reservedCash {
+		l.Debugf("Not enough cash. Has %d, needs %d", user.Cash, reservedCash)
+		return 0, NotEnoughCashError{}
+	}
+
+	tx := db.Begin()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	var errorHelper = func(format string, err error) (uint32, error) {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := createBid(bid, tx); err != nil {
+		return errorHelper("Error while creating bid. Rolling back. Error: %+v", err)
+	}
+
+	if err := AddUserReservedCash(user, reservedCash, tx); err != nil {
+		return errorHelper("Error while adding reserved cash to the user. Rolling back. Error: %+v", err)
+	}
+	if err := SubtractUserCash(user, reservedCash, tx); err != nil {
+		return errorHelper("Error subtracting cash. Rolling back. Error: %+v", err)
+	}
+
+	placeOrderTransaction := GetTransactionRef(userId, bid.StockId, PlaceOrderTransaction, 0, 0, 0, int64(reservedCash), -int64(reservedCash))
+	placeOrderTransaction.OptionId = bid.OptionId
+
+	if err := savePlaceOrderTransaction(bid.Id, placeOrderTransaction, false, tx); err != nil {
+		return errorHelper("Error reserving cash. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	go sendNewOptionOrderUpdates(userId, bid.Id, false, bid.StockId, bid.OptionId, bid.Price, bid.StockQuantity, placeOrderTransaction)
+
+	l.Infof("Placed bid %d for %d contracts", bid.Id, bid.StockQuantity)
+	return bid.Id, nil
+}
+
+// saveOptionAskCancelTransaction returns what was reserved for the unfulfilled part of a closed option ask.
+// Contracts the ask was selling are unreserved in the user's position. The collateral of contracts it
+// was writing is returned through a CancelOrderTransaction.
+func saveOptionAskCancelTransaction(askOrder *Ask, user *User, tx *gorm.DB) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":  "saveOptionAskCancelTransaction",
+		"userId":  user.Id,
+		"orderId": askOrder.Id,
+	})
+
+	unfulfilled := askOrder.StockQuantity - askOrder.StockQuantityFulfilled
+
+	if !askOrder.IsOptionWrite {
+		position, err := getOptionPosition(tx, user.Id, askOrder.OptionId)
+		if err != nil {
+			l.Errorf("Error while loading the position: %+v", err)
+			return err
+		}
+
+		if unfulfilled > position.ReservedQuantity {
+			unfulfilled = position.ReservedQuantity
+		}
+		position.ReservedQuantity -= unfulfilled
+		position.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+		if err := tx.Save(position).Error; err != nil {
+			l.Errorf("Error while unreserving contracts: %+v", err)
+			return err
+		}
+		return nil
+	}
+
+	option, err := getOptionContract(tx, askOrder.OptionId)
+	if err != nil {
+		l.Errorf("Error while loading the option: %+v", err)
+		return err
+	}
+
+	cash, stocks := getOptionCollateral(option, unfulfilled)
+	cancelOrderTransaction := GetTransactionRef(user.Id, askOrder.StockId, CancelOrderTransaction, -int64(stocks), int64(stocks), 0, -int64(cash), int64(cash))
+	cancelOrderTransaction.OptionId = askOrder.OptionId
+
+	if cash > 0 {
+		user.Cash += cash
+		user.ReservedCash -= cash
+
+		if err := tx.Save(user).Error; err != nil {
+			user.Cash -= cash
+			user.ReservedCash += cash
+			l.Errorf("Error while adding reserved cash back to user. Error: %+v", err)
+			return err
+		}
+	}
+
+	if err := tx.Save(cancelOrderTransaction).Error; err != nil {
+		user.Cash -= cash
+		user.ReservedCash += cash
+		l.Errorf("Error while saving cancelOrderTransaction %+v", err)
+		return err
+	}
+
+	go func(cancelOrderTransaction *Transaction) {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(cancelOrderTransaction.ToProto())
+
+		l.Infof("Sent through the datastreams")
+	}(cancelOrderTransaction)
+
+	return nil
+}
+
+// performOptionFillTransaction trades quantity contracts of an option between an ask and a bid at a premium
+// of price per stock. It's called by PerformOrderFillTransaction for option orders.
+// The asking user gets the premium. Collateral of contracts the ask writes moves to the asking user's position,
+// and the bidding user gets back the collateral of contracts they had written and are buying back.
+// Options pay no fees or taxes, and don't move the price of their stock.
+func performOptionFillTransaction(ask *Ask, bid *Bid, price uint64, quantity uint64) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "performOptionFillTransaction",
+		"askingUserId":  ask.UserId,
+		"biddingUserId": bid.UserId,
+		"optionId":      ask.OptionId,
+	})
+
+	l.Infof("Attempting")
+
+	done, askingUser, biddingUser, err := getUserPairExclusive(ask.UserId, bid.UserId)
+	if err != nil {
+		l.Errorf("Unable to acquire locks on the user pair: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	defer close(done)
+
+	askStatus := AskUndone
+	bidStatus := BidUndone
+
+	if ask.IsClosed {
+		askStatus = AskAlreadyClosed
+	}
+	if bid.IsClosed {
+		bidStatus = BidAlreadyClosed
+	}
+
+	if askStatus == AskAlreadyClosed || bidStatus == BidAlreadyClosed {
+		l.Infof("Done. One of the orders already closed. %d and %d", askStatus, bidStatus)
+		return askStatus, bidStatus, nil
+	}
+
+	db := getDB()
+
+	option, err := getOptionContract(db, ask.OptionId)
+	if err != nil {
+		l.Errorf("Error while loading the option: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+
+	reservedCashForOrder, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+	if err != nil {
+		l.Errorf("Error while getting reserved cash. Error: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	reservedCashForTrade := getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled) -
+		getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled+quantity)
+	total := int64(price * quantity * option.LotSize)
+
+	askingPosition, err := getOptionPosition(db, ask.UserId, ask.OptionId)
+	if err != nil {
+		l.Errorf("Error while loading the asking user's position: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	biddingPosition, err := getOptionPosition(db, bid.UserId, bid.OptionId)
+	if err != nil {
+		l.Errorf("Error while loading the bidding user's position: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+
+	askTransaction := GetTransactionRef(ask.UserId, ask.StockId, OptionTradeTransaction, 0, 0, price, 0, total)
+	bidTransaction := GetTransactionRef(bid.UserId, bid.StockId, OptionTradeTransaction, 0, 0, price, -reservedCashForTrade, -total+reservedCashForTrade)
+	askTransaction.OptionId = ask.OptionId
+	bidTransaction.OptionId = bid.OptionId
+
+	// in case things go wrong and we've to roll back
+	askingUserOldCash := askingUser.Cash
+	biddingUserOldCash := biddingUser.Cash
+	biddingUserOldReservedCash := biddingUser.ReservedCash
+	oldAskStockQuantityFulfilled := ask.StockQuantityFulfilled
+	oldBidStockQuantityFulfilled := bid.StockQuantityFulfilled
+	oldAskIsClosed := ask.IsClosed
+	oldBidIsClosed := bid.IsClosed
+
+	askingUser.Cash += uint64(total)
+	biddingUser.Cash = uint64(int64(biddingUser.Cash) - total + reservedCashForTrade)
+	if uint64(reservedCashForTrade) > biddingUser.ReservedCash {
+		biddingUser.ReservedCash = 0
+	} else {
+		biddingUser.ReservedCash -= uint64(reservedCashForTrade)
+	}
+
+	if ask.IsOptionWrite {
+		cash, stocks := getOptionCollateral(option, quantity)
+		askingPosition.CollateralCash += cash
+		askingPosition.CollateralStocks += stocks
+	} else {
+		askingPosition.ReservedQuantity -= quantity
+	}
+	askingPosition.Quantity -= int64(quantity)
+
+	// Contracts the bidding user had written are bought back first
+	var collateralTransaction *Transaction
+	if biddingPosition.Quantity < 0 {
+		written := uint64(-biddingPosition.Quantity)
+		closed := quantity
+		if closed > written {
+			closed = written
+		}
+
+		cash := getReleasedCollateral(biddingPosition.CollateralCash, closed, written)
+		stocks := getReleasedCollateral(biddingPosition.CollateralStocks, closed, written)
+		biddingPosition.CollateralCash -= cash
+		biddingPosition.CollateralStocks -= stocks
+
+		collateralTransaction = GetTransactionRef(bid.UserId, bid.StockId, OptionCollateralTransaction, -int64(stocks), int64(stocks), 0, -int64(cash), int64(cash))
+		collateralTransaction.OptionId = bid.OptionId
+
+		biddingUser.Cash += cash
+		biddingUser.ReservedCash -= cash
+	}
+	biddingPosition.Quantity += int64(quantity)
+
+	askingPosition.UpdatedAt = utils.GetCurrentTimeISO8601()
+	biddingPosition.UpdatedAt = askingPosition.UpdatedAt
+
+	ask.StockQuantityFulfilled += quantity
+	bid.StockQuantityFulfilled += quantity
+	ask.IsClosed = ask.StockQuantity == ask.StockQuantityFulfilled
+	bid.IsClosed = bid.StockQuantity == bid.StockQuantityFulfilled
+
+	tx := db.Begin()
+	txStart := time.Now()
+
+	var revertToOldState = func(format string, err error) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
+		l.Errorf(format, err)
+		askingUser.Cash = askingUserOldCash
+		biddingUser.Cash = biddingUserOldCash
+		biddingUser.ReservedCash = biddingUserOldReservedCash
+
+		ask.StockQuantityFulfilled = oldAskStockQuantityFulfilled
+		bid.StockQuantityFulfilled = oldBidStockQuantityFulfilled
+		ask.IsClosed = oldAskIsClosed
+		bid.IsClosed = oldBidIsClosed
+
+		tx.Rollback()
+		orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "rolled_back")
+		return AskUndone, BidUndone, nil
+	}
+
+	if err := tx.Save(askTransaction).Error; err != nil {
+		return revertToOldState("Error creating the askTransaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(bidTransaction).Error; err != nil {
+		return revertToOldState("Error creating the bidTransaction. Rolling back. Error: %+v", err)
+	}
+	if collateralTransaction != nil {
+		if err := tx.Save(collateralTransaction).Error; err != nil {
+			return revertToOldState("Error creating the collateralTransaction. Rolling back. Error: %+v", err)
+		}
+	}
+	if err := tx.Save(askingUser).Error; err != nil {
+		return revertToOldState("Error updating askingUser. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(biddingUser).Error; err != nil {
+		return revertToOldState("Error updating biddingUser. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(askingPosition).Error; err != nil {
+		return revertToOldState("Error updating the asking user's position. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(biddingPosition).Error; err != nil {
+		return revertToOldState("Error updating the bidding user's position. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(ask).Error; err != nil {
+		return revertToOldState("Error updating ask.{StockQuantityFulfilled,IsClosed}. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(bid).Error; err != nil {
+		return revertToOldState("Error updating bid.{StockQuantityFulfilled,IsClosed}. Rolling back. Error: %+v", err)
+	}
+
+	of := &OrderFill{
+		AskId:         ask.Id,
+		BidId:         bid.Id,
+		TransactionId: askTransaction.Id,
+	}
+	if err := tx.Save(of).Error; err != nil {
+		return revertToOldState("Error saving an orderfill. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return revertToOldState("Error committing transaction. Rolling back. Error: %+v", err)
+	}
+	orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "committed")
+
+	go func() {
+		myOrdersStream := datastreamsManager.GetMyOrdersStream()
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+
+		myOrdersStream.SendOrder(ask.UserId, &datastreams_pb.MyOrderUpdate{
+			Id:            ask.Id,
+			IsAsk:         true,
+			StockQuantity: ask.StockQuantity,
+			TradeQuantity: quantity,
+			IsClosed:      ask.IsClosed,
+		})
+		myOrdersStream.SendOrder(bid.UserId, &datastreams_pb.MyOrderUpdate{
+			Id:            bid.Id,
+			IsAsk:         false,
+			StockQuantity: bid.StockQuantity,
+			TradeQuantity: quantity,
+			IsClosed:      bid.IsClosed,
+		})
+
+		transactionsStream.SendTransaction(askTransaction.ToProto())
+		transactionsStream.SendTransaction(bidTransaction.ToProto())
+		if collateralTransaction != nil {
+			transactionsStream.SendTransaction(collateralTransaction.ToProto())
+		}
+
+		l.Infof("Sent through the datastreams")
+	}()
+
+	l.Infof("Transaction committed successfully. Traded %d contracts at %d per stock. Total %d.", quantity, price, total)
+
+	if ask.IsClosed {
+		askStatus = AskDone
+	}
+	if bid.IsClosed {
+		bidStatus = BidDone
+	}
+
+	// The order book reads the traded quantity off the ask's transaction, like for fills of stocks.
+	// A copy is handed to it, as the saved transaction doesn't move any stocks.
+	trade := *askTransaction
+	trade.StockQuantity = -int64(quantity)
+	return askStatus, bidStatus, &trade
+}
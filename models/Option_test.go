@@ -0,0 +1,232 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetOptionIntrinsicValue(t *testing.T) {
+	var tests = []struct {
+		optionType     OptionType
+		strikePrice    uint64
+		price          uint64
+		intrinsicValue uint64
+	}{
+		{Call, 100, 130, 30},
+		{Call, 100, 100, 0},
+		{Call, 100, 70, 0},
+		{Put, 100, 70, 30},
+		{Put, 100, 100, 0},
+		{Put, 100, 130, 0},
+	}
+
+	for _, test := range tests {
+		if value := getOptionIntrinsicValue(test.optionType, test.strikePrice, test.price); value != test.intrinsicValue {
+			t.Errorf("getOptionIntrinsicValue(%+v) = %d, expected %d", test, value, test.intrinsicValue)
+		}
+	}
+}
+
+func TestGetOptionCollateral(t *testing.T) {
+	call := &OptionContract{Type: Call, StrikePrice: 100, LotSize: 10}
+	put := &OptionContract{Type: Put, StrikePrice: 100, LotSize: 10}
+
+	if cash, stocks := getOptionCollateral(call, 3); cash != 0 || stocks != 30 {
+		t.Errorf("getOptionCollateral(call, 3) = (%d, %d), expected (0, 30)", cash, stocks)
+	}
+	if cash, stocks := getOptionCollateral(put, 3); cash != 3000 || stocks != 0 {
+		t.Errorf("getOptionCollateral(put, 3) = (%d, %d), expected (3000, 0)", cash, stocks)
+	}
+}
+
+func TestGetReleasedCollateral(t *testing.T) {
+	var tests = []struct {
+		collateral uint64
+		closed     uint64
+		written    uint64
+		released   uint64
+	}{
+		{3000, 1, 3, 1000},
+		{3000, 3, 3, 3000},
+		{3000, 5, 3, 3000},
+		{100, 1, 3, 33},
+	}
+
+	for _, test := range tests {
+		if released := getReleasedCollateral(test.collateral, test.closed, test.written); released != test.released {
+			t.Errorf("getReleasedCollateral(%+v) = %d, expected %d", test, released, test.released)
+		}
+	}
+}
+
+func TestGetWriterSettlement(t *testing.T) {
+	var tests = []struct {
+		owed             uint64
+		collateralCash   uint64
+		collateralStocks uint64
+		price            uint64
+		cashReturned     uint64
+		stocksReturned   uint64
+	}{
+		// puts are paid out of the cash collateral
+		{0, 3000, 0, 70, 3000, 0},
+		{900, 3000, 0, 70, 2100, 0},
+		// calls are paid with collateral stocks, with the change from the last stock in cash
+		{0, 0, 30, 130, 0, 30},
+		{900, 0, 30, 130, 10, 23},
+		{3900, 0, 30, 130, 0, 0},
+	}
+
+	for _, test := range tests {
+		cash, stocks := getWriterSettlement(test.owed, test.collateralCash, test.collateralStocks, test.price)
+		if cash != test.cashReturned || stocks != test.stocksReturned {
+			t.Errorf("getWriterSettlement(%+v) = (%d, %d), expected (%d, %d)", test, cash, stocks, test.cashReturned, test.stocksReturned)
+		}
+	}
+}
+
+func Test_SettleOption(t *testing.T) {
+	writer := &User{Id: 2, Cash: 1000}
+	holder := &User{Id: 3, Cash: 1000}
+	stock := &Stock{Id: 1, CurrentPrice: 100, StocksInExchange: 50, StocksInMarket: 50}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderFills")
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Asks")
+		db.Exec("DELETE FROM Bids")
+		db.Exec("DELETE FROM OptionPositions")
+		db.Exec("DELETE FROM OptionContracts")
+		db.Delete(writer)
+		db.Delete(holder)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+		delete(userLocks.m, 3)
+	}()
+
+	for _, user := range []*User{writer, holder} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// the writer covers the calls with 20 stocks
+	if err := db.Create(GetTransactionRef(writer.Id, stock.Id, FromExchangeTransaction, 0, 20, 100, 0, -2000)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	option, err := ListOption(stock.Id, Call, 90, 10, GetMarketDay())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PlaceOptionAskOrder(writer.Id, &Ask{UserId: writer.Id, OptionId: option.Id, OrderType: Market, StockQuantity: 2}); err == nil {
+		t.Fatalf("Expected a market order for an option to fail")
+	}
+
+	ask := &Ask{UserId: writer.Id, OptionId: option.Id, OrderType: Limit, Price: 5, StockQuantity: 2}
+	if _, err := PlaceOptionAskOrder(writer.Id, ask); err != nil {
+		t.Fatalf("Did not expect error while placing the ask. Error: %+v", err)
+	}
+	testutils.AssertEqual(t, true, ask.IsOptionWrite)
+	// all of the stocks are collateral now
+	if _, err := PlaceOptionAskOrder(writer.Id, &Ask{UserId: writer.Id, OptionId: option.Id, OrderType: Limit, Price: 5, StockQuantity: 1}); err == nil {
+		t.Fatalf("Expected writing calls without the stocks to cover them to fail")
+	}
+
+	bid := &Bid{UserId: holder.Id, OptionId: option.Id, OrderType: Limit, Price: 5, StockQuantity: 2}
+	if _, err := PlaceOptionBidOrder(holder.Id, bid); err != nil {
+		t.Fatalf("Did not expect error while placing the bid. Error: %+v", err)
+	}
+	if _, err := PlaceOptionAskOrder(holder.Id, &Ask{UserId: holder.Id, OptionId: option.Id, OrderType: Limit, Price: 5, StockQuantity: 1}); err == nil {
+		t.Fatalf("Expected writing contracts with a bid open to fail")
+	}
+
+	askStatus, bidStatus, tr := PerformOrderFillTransaction(ask, bid, 5, 2, false, true)
+	if askStatus != AskDone || bidStatus != BidDone || tr == nil {
+		t.Fatalf("Expected both orders to be filled, got %v and %v", askStatus, bidStatus)
+	}
+
+	checkUser := func(userId uint32, cash uint64, quantity int64, collateralStocks uint64) {
+		t.Helper()
+		u := &User{}
+		if err := db.First(u, userId).Error; err != nil {
+			t.Fatal(err)
+		}
+		position, err := getOptionPosition(db, userId, option.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Cash != cash || u.ReservedCash != 0 || position.Quantity != quantity || position.CollateralStocks != collateralStocks {
+			t.Errorf("User %d has %d cash, %d reserved, %d contracts and %d collateral stocks; want %d, 0, %d and %d", userId, u.Cash, u.ReservedCash, position.Quantity, position.CollateralStocks, cash, quantity, collateralStocks)
+		}
+	}
+
+	// the holder pays the writer a premium of 5 * 10 stocks * 2 contracts
+	checkUser(writer.Id, 1100, -2, 20)
+	checkUser(holder.Id, 900, 2, 0)
+
+	// the stock rises to 120, so every contract is worth 30 * 10. The writer sells 5 of the
+	// collateral stocks at 120 to pay the 600 owed, and gets back the other 15.
+	stock.CurrentPrice = 120
+	if err := db.Save(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	if err := SettleOption(option.Id); err != nil {
+		t.Fatalf("Did not expect error while settling. Error: %+v", err)
+	}
+	checkUser(writer.Id, 1100, 0, 0)
+	checkUser(holder.Id, 1500, 0, 0)
+
+	settled := &OptionContract{}
+	db.First(settled, option.Id)
+	testutils.AssertEqual(t, true, settled.IsSettled)
+	testutils.AssertEqual(t, uint64(120), settled.SettlementPrice)
+
+	// the stocks sold go back to the exchange
+	dbStock := &Stock{}
+	db.First(dbStock, stock.Id)
+	testutils.AssertEqual(t, uint64(45), dbStock.StocksInMarket)
+	testutils.AssertEqual(t, uint64(55), dbStock.StocksInExchange)
+
+	type row struct {
+		UserId                uint32
+		Type                  string
+		ReservedStockQuantity int64
+		StockQuantity         int64
+		ReservedCashTotal     int64
+		Total                 int64
+	}
+	var saved []row
+	sql := "SELECT userId AS user_id, type, reservedStockQuantity AS reserved_stock_quantity, stockQuantity AS stock_quantity, reservedCashTotal AS reserved_cash_total, total FROM Transactions WHERE optionId = ? ORDER BY userId, id"
+	if err := db.Raw(sql, option.Id).Scan(&saved).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []row{
+		{2, PlaceOrderTransaction.String(), 20, -20, 0, 0},
+		{2, OptionTradeTransaction.String(), 0, 0, 0, 100},
+		{2, OptionSettlementTransaction.String(), -20, 15, 0, 0},
+		{3, PlaceOrderTransaction.String(), 0, 0, 100, -100},
+		{3, OptionTradeTransaction.String(), 0, 0, -100, 0},
+		{3, OptionSettlementTransaction.String(), 0, 0, 0, 600},
+	}
+	if len(saved) != len(expected) {
+		t.Fatalf("Expected %d transactions, got %d", len(expected), len(saved))
+	}
+	for i, e := range expected {
+		if saved[i] != e {
+			t.Errorf("Transaction %d: got %+v, want %+v", i, saved[i], e)
+		}
+	}
+}
@@ -26,7 +26,8 @@ func makeOrderDepositTransactionRef(transactionID, orderID uint32, isAsk bool) *
 	}
 }
 
-// getPlaceOrderTransactionDetails returns price and quantity at which PlaceOrderTransaction was created
+// getPlaceOrderTransactionDetails returns the cash and stocks reserved for an order. These are the sum of
+// the PlaceOrderTransaction and any ModifyOrderTransactions made for the order.
 func getPlaceOrderTransactionDetails(orderID uint32, isAsk bool) (int64, int64, error) {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "getPlaceOrderTransactionDetails",
@@ -35,10 +36,11 @@ func getPlaceOrderTransactionDetails(orderID uint32, isAsk bool) (int64, int64,
 
 	db := getDB()
 
+	var count int64
 	var totalPrice int64
 	var stocksInBank int64
 
-	sql := "Select tx.total as totalPrice, tx.stockQuantity as stocksInBank from Transactions tx INNER JOIN OrderDepositTransactions odtx on tx.id = odtx.transactionID WHERE odtx.orderID = ? and isAsk = ?"
+	sql := "Select count(*) as count, ifnull(sum(tx.total), 0) as totalPrice, ifnull(sum(tx.stockQuantity), 0) as stocksInBank from Transactions tx INNER JOIN OrderDepositTransactions odtx on tx.id = odtx.transactionID WHERE odtx.orderID = ? and isAsk = ?"
 	rows, err := db.Raw(sql, orderID, isAsk).Rows()
 	if err != nil {
 		l.Errorf("Error retrieving transactionId. Error: %+v", err)
@@ -50,7 +52,11 @@ func getPlaceOrderTransactionDetails(orderID uint32, isAsk bool) (int64, int64,
 		return 0, 0, InvalidOrderIDError{}
 	}
 
-	rows.Scan(&totalPrice, &stocksInBank)
+	rows.Scan(&count, &totalPrice, &stocksInBank)
+
+	if count == 0 {
+		return 0, 0, InvalidOrderIDError{}
+	}
 
 	l.Infof("Retrieved reserved asset. Cash reserved %d and Stock Reserved %d for order %d %t", totalPrice, stocksInBank, orderID, isAsk)
 
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	"github.com/sirupsen/logrus"
+)
+
+// sendClosedOrderUpdate tells the user that his order has been closed before getting fulfilled,
+// either because it expired or because another order of its group traded
+func sendClosedOrderUpdate(userId, orderId uint32, isAsk bool, stockQuantity uint64) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:            orderId,
+		IsAsk:         isAsk,
+		StockQuantity: stockQuantity,
+		IsClosed:      true,
+	})
+}
+
+// ExpireAskOrder closes an ask whose time in force has run out. The stocks reserved for its
+// unfulfilled part are returned through a CancelOrderTransaction, just like for a cancelled ask.
+// It is called by the matching engine for immediate-or-cancel and fill-or-kill asks that couldn't
+// be fulfilled, and by ExpireOrders when the market closes.
+// AlreadyClosedError is returned if the ask got closed meanwhile.
+func ExpireAskOrder(ask *Ask) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "ExpireAskOrder",
+		"param_ask": fmt.Sprintf("%+v", ask),
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(ask.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+	tx := db.Begin()
+
+	if err := ask.Close(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := saveAskCancelOrderTransaction(ask, user, tx); err != nil {
+		l.Errorf("Error while returning reserved stocks. Error: %+v", err)
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error while commiting %+v", err)
+		tx.Rollback()
+		return err
+	}
+
+	go sendClosedOrderUpdate(ask.UserId, ask.Id, true, ask.StockQuantity)
+
+	l.Infof("Expired ask")
+	return nil
+}
+
+// ExpireBidOrder closes a bid whose time in force has run out. The cash reserved for its
+// unfulfilled part is returned through a CancelOrderTransaction, just like for a cancelled bid.
+// It is called by the matching engine for immediate-or-cancel and fill-or-kill bids that couldn't
+// be fulfilled, and by ExpireOrders when the market closes.
+// AlreadyClosedError is returned if the bid got closed meanwhile.
+func ExpireBidOrder(bid *Bid) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "ExpireBidOrder",
+		"param_bid": fmt.Sprintf("%+v", bid),
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(bid.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+	tx := db.Begin()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	errorHelper := func(err error) error {
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return err
+	}
+
+	if err := bid.Close(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := saveBidCancelOrderTransaction(bid, user, tx); err != nil {
+		l.Errorf("Error while returning reserved cash. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error while commiting %+v", err)
+		return errorHelper(err)
+	}
+
+	go sendClosedOrderUpdate(bid.UserId, bid.Id, false, bid.StockQuantity)
+
+	l.Infof("Expired bid")
+	return nil
+}
+
+// ExpireOrders expires all open Day orders, and the open GoodTillDate orders that expire on the
+// current market day or before. It is called when the market closes.
+// The orders that got expired are returned, so that the matching engine can remove them from the
+// order books.
+func ExpireOrders() ([]*Ask, []*Bid, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "ExpireOrders",
+	})
+
+	marketDay := GetMarketDay()
+
+	l.Infof("Expiring orders on market day %d", marketDay)
+
+	db := getDB()
+
+	var (
+		askIds      []uint32
+		bidIds      []uint32
+		expiredAsks []*Ask
+		expiredBids []*Bid
+	)
+
+	expiryQuery := "isClosed = ? AND (timeInForce = ? OR (timeInForce = ? AND expiresOnDay <= ?))"
+
+	if err := db.Model(&Ask{}).Where(expiryQuery, 0, Day.String(), GoodTillDate.String(), marketDay).Pluck("id", &askIds).Error; err != nil {
+		l.Errorf("Error while loading asks to expire: %+v", err)
+		return nil, nil, err
+	}
+
+	if err := db.Model(&Bid{}).Where(expiryQuery, 0, Day.String(), GoodTillDate.String(), marketDay).Pluck("id", &bidIds).Error; err != nil {
+		l.Errorf("Error while loading bids to expire: %+v", err)
+		return nil, nil, err
+	}
+
+	for _, askId := range askIds {
+		ask, err := getAsk(askId)
+		if err != nil {
+			l.Errorf("Unable to load ask %d: %+v", askId, err)
+			continue
+		}
+
+		err = ExpireAskOrder(ask)
+		if _, ok := err.(AlreadyClosedError); ok {
+			continue
+		}
+		if err != nil {
+			l.Errorf("Unable to expire ask %d: %+v", askId, err)
+			continue
+		}
+
+		expiredAsks = append(expiredAsks, ask)
+	}
+
+	for _, bidId := range bidIds {
+		bid, err := getBid(bidId)
+		if err != nil {
+			l.Errorf("Unable to load bid %d: %+v", bidId, err)
+			continue
+		}
+
+		err = ExpireBidOrder(bid)
+		if _, ok := err.(AlreadyClosedError); ok {
+			continue
+		}
+		if err != nil {
+			l.Errorf("Unable to expire bid %d: %+v", bidId, err)
+			continue
+		}
+
+		expiredBids = append(expiredBids, bid)
+	}
+
+	l.Infof("Expired %d asks and %d bids", len(expiredAsks), len(expiredBids))
+
+	return expiredAsks, expiredBids, nil
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func Test_ExpireOrders(t *testing.T) {
+	user := &User{Id: 2, Cash: 3000}
+	stock := &Stock{Id: 1, CurrentPrice: 200}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Asks")
+		db.Exec("DELETE FROM Bids")
+		db.Delete(user)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	if err := db.Create(GetTransactionRef(user.Id, stock.Id, FromExchangeTransaction, 0, 10, 200, 0, -2000)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	marketDay := GetMarketDay()
+
+	dayAsk := &Ask{UserId: user.Id, StockId: stock.Id, OrderType: Limit, TimeInForce: Day, Price: 200, StockQuantity: 2}
+	gtcAsk := &Ask{UserId: user.Id, StockId: stock.Id, OrderType: Limit, TimeInForce: GoodTillCancelled, Price: 200, StockQuantity: 3}
+	for _, ask := range []*Ask{dayAsk, gtcAsk} {
+		if _, err := PlaceAskOrder(user.Id, ask); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiringBid := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: Limit, TimeInForce: GoodTillDate, ExpiresOnDay: marketDay, Price: 100, StockQuantity: 1}
+	laterBid := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: Limit, TimeInForce: GoodTillDate, ExpiresOnDay: marketDay + 1, Price: 100, StockQuantity: 1}
+	dayBid := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: Limit, TimeInForce: Day, Price: 100, StockQuantity: 2}
+	for _, bid := range []*Bid{expiringBid, laterBid, dayBid} {
+		if _, err := PlaceBidOrder(user.Id, bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Day orders and the good-till-date orders that expire today are expired
+	expiredAsks, expiredBids, err := ExpireOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var askIds, bidIds []uint32
+	for _, ask := range expiredAsks {
+		askIds = append(askIds, ask.Id)
+	}
+	for _, bid := range expiredBids {
+		bidIds = append(bidIds, bid.Id)
+	}
+	testutils.AssertEqual(t, []uint32{dayAsk.Id}, askIds)
+	testutils.AssertEqual(t, []uint32{expiringBid.Id, dayBid.Id}, bidIds)
+
+	var openAskIds, openBidIds []uint32
+	db.Model(&Ask{}).Where("isClosed = ?", false).Order("id").Pluck("id", &openAskIds)
+	db.Model(&Bid{}).Where("isClosed = ?", false).Order("id").Pluck("id", &openBidIds)
+	testutils.AssertEqual(t, []uint32{gtcAsk.Id}, openAskIds)
+	testutils.AssertEqual(t, []uint32{laterBid.Id}, openBidIds)
+
+	// only the stocks of the open ask stay reserved
+	var holding = struct {
+		StockQuantity         int64
+		ReservedStockQuantity int64
+	}{}
+	sql := "SELECT SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity FROM Transactions WHERE userId = ? AND stockId = ?"
+	if err := db.Raw(sql, user.Id, stock.Id).Scan(&holding).Error; err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, int64(7), holding.StockQuantity)
+	testutils.AssertEqual(t, int64(3), holding.ReservedStockQuantity)
+
+	// and only the cash of the open bid
+	reservedCash, _, err := getPlaceOrderTransactionDetails(laterBid.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &User{}
+	db.First(u, user.Id)
+	testutils.AssertEqual(t, uint64(reservedCash), u.ReservedCash)
+	testutils.AssertEqual(t, uint64(3000), u.Cash+u.ReservedCash)
+
+	if err := ExpireAskOrder(dayAsk); err == nil {
+		t.Fatalf("Expected expiring a closed ask to fail")
+	} else if _, ok := err.(AlreadyClosedError); !ok {
+		t.Fatalf("Expected AlreadyClosedError, got %+v", err)
+	}
+
+	// nothing is left to expire
+	expiredAsks, expiredBids, err = ExpireOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, 0, len(expiredAsks)+len(expiredBids))
+}
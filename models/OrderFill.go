@@ -8,6 +8,9 @@ type OrderFill struct {
 	TransactionId uint32 `gorm:"column:transactionId;not null" json:"transaction_id"`
 	BidId         uint32 `gorm:"column:bidId;not null" json:"bid_id"`
 	AskId         uint32 `gorm:"column:askId;not null" json:"ask_id"`
+	// OrderFeeTransactions of the fill. 0 if the side paid no fee
+	AskFeeTransactionId uint32 `gorm:"column:askFeeTransactionId;not null" json:"ask_fee_transaction_id"`
+	BidFeeTransactionId uint32 `gorm:"column:bidFeeTransactionId;not null" json:"bid_fee_transaction_id"`
 }
 
 func (OrderFill) TableName() string {
@@ -19,5 +22,8 @@ func (gOrderFill *OrderFill) ToProto() *models_pb.OrderFill {
 		TransactionId: gOrderFill.TransactionId,
 		BidId:         gOrderFill.BidId,
 		AskId:         gOrderFill.AskId,
+
+		AskFeeTransactionId: gOrderFill.AskFeeTransactionId,
+		BidFeeTransactionId: gOrderFill.BidFeeTransactionId,
 	}
 }
@@ -0,0 +1,509 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	"github.com/delta/dalal-street-server/utils"
+)
+
+// OrderGroup links the legs of a one-cancels-other order. A group is placed on an open position,
+// with a take-profit Limit leg and a protective StopLoss or StopLimit leg. Both legs are on the
+// same side and for the same quantity, and once one of them trades, the other gets cancelled.
+// The stocks or cash for the group are reserved only once, through a single PlaceOrderTransaction
+// that both legs share.
+type OrderGroup struct {
+	Id            uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId        uint32 `gorm:"column:userId;not null" json:"user_id"`
+	StockId       uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	IsAsk         bool   `gorm:"column:isAsk;not null" json:"is_ask"`
+	StockQuantity uint64 `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	CreatedAt     string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (OrderGroup) TableName() string {
+	return "OrderGroups"
+}
+
+// InvalidOrderGroupError is given out when a one-cancels-other order can't be placed as requested
+type InvalidOrderGroupError struct{ reason string }
+
+func (e InvalidOrderGroupError) Error() string {
+	return fmt.Sprintf("Invalid one-cancels-other order. %s", e.reason)
+}
+
+// checkOrderGroup runs the checks common to ask and bid groups before they get placed.
+// The stop leg has to trigger on the losing side of the current price, and the take-profit
+// leg has to be placed on the winning side of the stop.
+func checkOrderGroup(isAsk bool, stockId uint32, takeProfitType, stopType OrderType, takeProfitPrice, stopPrice uint64, timeInForce TimeInForce) error {
+	if takeProfitType != Limit {
+		return InvalidOrderGroupError{"The take-profit order must be a limit order."}
+	}
+
+	if !stopType.IsStop() {
+		return InvalidOrderGroupError{"The protective order must be a stoploss or a stop-limit order."}
+	}
+
+	if timeInForce.IsImmediate() {
+		return InvalidOrderGroupError{"Immediate-or-cancel and fill-or-kill orders can't be linked."}
+	}
+
+	if err := checkOrderPrice(stockId, takeProfitPrice); err != nil {
+		return err
+	}
+
+	allStocks.m[stockId].RLock()
+	currentPrice := allStocks.m[stockId].stock.CurrentPrice
+	allStocks.m[stockId].RUnlock()
+
+	if isAsk && (stopPrice >= currentPrice || takeProfitPrice <= stopPrice) {
+		return InvalidOrderGroupError{"The stop price must be below the current price, and below the take-profit price."}
+	}
+
+	if !isAsk && (stopPrice <= currentPrice || takeProfitPrice >= stopPrice) {
+		return InvalidOrderGroupError{"The stop price must be above the current price, and above the take-profit price."}
+	}
+
+	return nil
+}
+
+// getOrderGroupFeePrice returns the price the reservation of a group, and the fee it needs cash for, are calculated on.
+// Only one leg of the group will trade, so it's the higher of the prices of its legs.
+func getOrderGroupFeePrice(stockId uint32, takeProfitPrice uint64, stopType OrderType, stopPrice, stopLimitPrice uint64) uint64 {
+	stopFeePrice := getOrderFeePrice(stopPrice, stopLimitPrice, stockId, stopType)
+	takeProfitFeePrice := getOrderFeePrice(takeProfitPrice, 0, stockId, Limit)
+	if stopFeePrice > takeProfitFeePrice {
+		return stopFeePrice
+	}
+	return takeProfitFeePrice
+}
+
+// createOrderGroup creates the group for a pair of legs. The legs are linked to it by the caller.
+func createOrderGroup(userId, stockId uint32, isAsk bool, stockQuantity uint64, tx *gorm.DB) (*OrderGroup, error) {
+	group := &OrderGroup{
+		UserId:        userId,
+		StockId:       stockId,
+		IsAsk:         isAsk,
+		StockQuantity: stockQuantity,
+		CreatedAt:     utils.GetCurrentTimeISO8601(),
+	}
+
+	if err := tx.Create(group).Error; err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// saveOrderGroupPlaceOrderTransaction saves the PlaceOrderTransaction of a group, and links it to
+// each of its legs. This way the reservation is made once, but every leg sees all of it.
+func saveOrderGroupPlaceOrderTransaction(orderIds []uint32, placeOrderTransaction *Transaction, isAsk bool, tx *gorm.DB) error {
+	if err := savePlaceOrderTransaction(orderIds[0], placeOrderTransaction, isAsk, tx); err != nil {
+		return err
+	}
+
+	for _, orderId := range orderIds[1:] {
+		orderDepositTransaction := makeOrderDepositTransactionRef(placeOrderTransaction.Id, orderId, isAsk)
+		if err := tx.Save(orderDepositTransaction).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendNewOrderGroupUpdates tells the user about the legs of the group he has placed, and the
+// transaction made for them
+func sendNewOrderGroupUpdates(userId uint32, isAsk bool, legs []*datastreams_pb.MyOrderUpdate, placeOrderTransaction *Transaction) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+
+	for _, leg := range legs {
+		leg.IsAsk = isAsk
+		leg.IsNewOrder = true
+		myOrdersStream.SendOrder(userId, leg)
+	}
+
+	transactionsStream.SendTransaction(placeOrderTransaction.ToProto())
+}
+
+// PlaceAskOcoOrder places a take-profit ask and a protective stop ask for the user, linked as one
+// order group. The user must own the stocks being sold, as the group can't be used to short sell.
+// The stocks are reserved once for both legs. Fees are charged only on the leg that trades.
+//
+// The method is thread-safe like other exported methods of this package.
+//
+// Possible outcomes:
+//  1. The asks get placed successfully, and the id of their group is returned
+//  2. InvalidOrderGroupError is returned
+//  3. NotEnoughStocksError is returned
+//  4. Other error is returned (e.g. if Database connection doesn't open)
+func PlaceAskOcoOrder(userId uint32, takeProfit, stop *Ask) (uint32, error) {
+	l := logger.WithFields(logrus.Fields{
+		"method":           "PlaceAskOcoOrder",
+		"param_userId":     userId,
+		"param_takeProfit": fmt.Sprintf("%+v", takeProfit),
+		"param_stop":       fmt.Sprintf("%+v", stop),
+	})
+
+	l.Infof("PlaceAskOcoOrder requested")
+
+	if isBankrupt := IsStockBankrupt(takeProfit.StockId); isBankrupt {
+		l.Infof("Stock already bankrupt. Returning function.")
+		return 0, StockBankruptError{}
+	}
+
+	if IsStockDelisted(takeProfit.StockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return 0, StockDelistedError{}
+	}
+
+	if err := checkStockHalted(takeProfit.StockId); err != nil {
+		l.Infof("Trading in the stock has been halted. Returning function.")
+		return 0, err
+	}
+
+	if stop.StockId != takeProfit.StockId || stop.StockQuantity != takeProfit.StockQuantity ||
+		stop.TimeInForce != takeProfit.TimeInForce || stop.ExpiresOnDay != takeProfit.ExpiresOnDay {
+		return 0, InvalidOrderGroupError{"Both orders must be for the same stock, quantity and time in force."}
+	}
+
+	if err := checkOrderGroup(true, takeProfit.StockId, takeProfit.OrderType, stop.OrderType, takeProfit.Price, stop.Price, takeProfit.TimeInForce); err != nil {
+		l.Debugf("Order group check failed: %+v", err)
+		return 0, err
+	}
+
+	if err := checkTimeInForce(takeProfit.OrderType, takeProfit.TimeInForce, takeProfit.ExpiresOnDay); err != nil {
+		l.Debugf("Time in force check failed for ask order group")
+		return 0, err
+	}
+
+	if err := checkStopLimitOrder(stop.OrderType, stop.StockId, stop.LimitPrice); err != nil {
+		l.Debugf("Stop-limit check failed for ask order group")
+		return 0, err
+	}
+
+	l.Debugf("Acquiring exclusive write on user")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return 0, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	if takeProfit.StockQuantity > ASK_LIMIT || takeProfit.StockQuantity < 1 {
+		l.Debugf("Check1: Failed.")
+		return 0, OrderStockLimitExceeded{}
+	}
+
+	numStocks, err := getSingleStockCount(user, takeProfit.StockId)
+	if err != nil {
+		return 0, err
+	}
+
+	if numStocks < int64(takeProfit.StockQuantity) {
+		l.Debugf("Check2: Failed. User has only %d stocks", numStocks)
+		if numStocks < 0 {
+			numStocks = 0
+		}
+		return 0, NotEnoughStocksError{numStocks}
+	}
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(err error) (uint32, error) {
+		tx.Rollback()
+		return 0, err
+	}
+
+	group, err := createOrderGroup(userId, takeProfit.StockId, true, takeProfit.StockQuantity, tx)
+	if err != nil {
+		l.Errorf("Error while creating order group. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	for _, ask := range []*Ask{takeProfit, stop} {
+		ask.GroupId = group.Id
+		if err := createAsk(ask, tx); err != nil {
+			l.Errorf("Error while creating Ask. Rolling back. Error: %+v", err)
+			return errorHelper(err)
+		}
+	}
+
+	placeOrderTransaction := GetTransactionRef(
+		userId,
+		takeProfit.StockId,
+		PlaceOrderTransaction,
+		int64(takeProfit.StockQuantity),
+		-1*int64(takeProfit.StockQuantity),
+		0,
+		0,
+		0,
+	)
+
+	if err := saveOrderGroupPlaceOrderTransaction([]uint32{takeProfit.Id, stop.Id}, placeOrderTransaction, true, tx); err != nil {
+		l.Errorf("Error reserving stocks. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error committing the transaction. Failing. %+v", err)
+		return errorHelper(err)
+	}
+
+	l.Infof("Placed order group %d with asks %d and %d", group.Id, takeProfit.Id, stop.Id)
+
+	go sendNewOrderGroupUpdates(userId, true, []*datastreams_pb.MyOrderUpdate{
+		{Id: takeProfit.Id, StockId: takeProfit.StockId, OrderPrice: takeProfit.Price, OrderType: takeProfit.ToProto().OrderType, StockQuantity: takeProfit.StockQuantity},
+		{Id: stop.Id, StockId: stop.StockId, OrderPrice: stop.Price, OrderType: stop.ToProto().OrderType, StockQuantity: stop.StockQuantity},
+	}, placeOrderTransaction)
+
+	return group.Id, nil
+}
+
+// PlaceBidOcoOrder places a take-profit bid and a protective stop bid for the user, linked as one
+// order group. This is how a short position gets covered at either a profit or a limited loss.
+// The cash is reserved once for both legs, on the higher of the prices of the legs. Fees are
+// charged only on the leg that trades.
+//
+// The method is thread-safe like other exported methods of this package.
+//
+// Possible outcomes:
+//  1. The bids get placed successfully, and the id of their group is returned
+//  2. InvalidOrderGroupError is returned
+//  3. NotEnoughCashError is returned
+//  4. Other error is returned (e.g. if Database connection doesn't open)
+func PlaceBidOcoOrder(userId uint32, takeProfit, stop *Bid) (uint32, error) {
+	l := logger.WithFields(logrus.Fields{
+		"method":           "PlaceBidOcoOrder",
+		"param_userId":     userId,
+		"param_takeProfit": fmt.Sprintf("%+v", takeProfit),
+		"param_stop":       fmt.Sprintf("%+v", stop),
+	})
+
+	l.Infof("PlaceBidOcoOrder requested")
+
+	if isBankrupt := IsStockBankrupt(takeProfit.StockId); isBankrupt {
+		l.Infof("Stock already bankrupt. Returning function.")
+		return 0, StockBankruptError{}
+	}
+
+	if IsStockDelisted(takeProfit.StockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return 0, StockDelistedError{}
+	}
+
+	if err := checkStockHalted(takeProfit.StockId); err != nil {
+		l.Infof("Trading in the stock has been halted. Returning function.")
+		return 0, err
+	}
+
+	if stop.StockId != takeProfit.StockId || stop.StockQuantity != takeProfit.StockQuantity ||
+		stop.TimeInForce != takeProfit.TimeInForce || stop.ExpiresOnDay != takeProfit.ExpiresOnDay {
+		return 0, InvalidOrderGroupError{"Both orders must be for the same stock, quantity and time in force."}
+	}
+
+	if err := checkOrderGroup(false, takeProfit.StockId, takeProfit.OrderType, stop.OrderType, takeProfit.Price, stop.Price, takeProfit.TimeInForce); err != nil {
+		l.Debugf("Order group check failed: %+v", err)
+		return 0, err
+	}
+
+	if err := checkTimeInForce(takeProfit.OrderType, takeProfit.TimeInForce, takeProfit.ExpiresOnDay); err != nil {
+		l.Debugf("Time in force check failed for bid order group")
+		return 0, err
+	}
+
+	if err := checkStopLimitOrder(stop.OrderType, stop.StockId, stop.LimitPrice); err != nil {
+		l.Debugf("Stop-limit check failed for bid order group")
+		return 0, err
+	}
+
+	l.Debugf("Acquiring exclusive write on user")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return 0, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	if takeProfit.StockQuantity > BID_LIMIT || takeProfit.StockQuantity < 1 {
+		l.Debugf("Check1: Failed.")
+		return 0, OrderStockLimitExceeded{}
+	}
+
+	orderPrice := getOrderGroupFeePrice(takeProfit.StockId, takeProfit.Price, stop.OrderType, stop.Price, stop.LimitPrice)
+	// fees are charged when a leg trades, so the fee is reserved too, as if the leg traded as a taker
+	orderFee := getMaxOrderFee(userId, takeProfit.StockId, takeProfit.StockQuantity, orderPrice)
+	reservedCash := takeProfit.StockQuantity*orderPrice + orderFee
+	cashLeft := int64(user.Cash) - int64(reservedCash)
+
+	l.Debugf("Check2: User has %d cash currently. Will be left with %d cash after placing.", user.Cash, cashLeft)
+
+	if cashLeft < MINIMUM_CASH_LIMIT {
+		l.Debugf("Check2: Failed. Not enough cash.")
+		return 0, NotEnoughCashError{}
+	}
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(err error) (uint32, error) {
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return 0, err
+	}
+
+	group, err := createOrderGroup(userId, takeProfit.StockId, false, takeProfit.StockQuantity, tx)
+	if err != nil {
+		l.Errorf("Error while creating order group. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	for _, bid := range []*Bid{takeProfit, stop} {
+		bid.GroupId = group.Id
+		if err := createBid(bid, tx); err != nil {
+			l.Errorf("Error while creating Bid. Rolling back. Error: %+v", err)
+			return errorHelper(err)
+		}
+	}
+
+	if err := SubtractUserCash(user, reservedCash, tx); err != nil {
+		l.Errorf("Error while subtracting reserved cash from user. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	if err := AddUserReservedCash(user, reservedCash, tx); err != nil {
+		l.Errorf("Error while adding reserved cash to the user. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	placeOrderTransaction := GetTransactionRef(
+		userId,
+		takeProfit.StockId,
+		PlaceOrderTransaction,
+		0,
+		0,
+		0,
+		int64(reservedCash),
+		-1*int64(reservedCash),
+	)
+
+	if err := saveOrderGroupPlaceOrderTransaction([]uint32{takeProfit.Id, stop.Id}, placeOrderTransaction, false, tx); err != nil {
+		l.Errorf("Error reserving cash. Rolling back. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error committing the transaction. Failing. %+v", err)
+		return errorHelper(err)
+	}
+
+	l.Infof("Placed order group %d with bids %d and %d", group.Id, takeProfit.Id, stop.Id)
+
+	go sendNewOrderGroupUpdates(userId, false, []*datastreams_pb.MyOrderUpdate{
+		{Id: takeProfit.Id, StockId: takeProfit.StockId, OrderPrice: takeProfit.Price, OrderType: takeProfit.ToProto().OrderType, StockQuantity: takeProfit.StockQuantity},
+		{Id: stop.Id, StockId: stop.StockId, OrderPrice: stop.Price, OrderType: stop.ToProto().OrderType, StockQuantity: stop.StockQuantity},
+	}, placeOrderTransaction)
+
+	return group.Id, nil
+}
+
+// closeSiblingAsks closes the other open legs of an ask's group in the given transaction. Nothing
+// is returned to the user for them, as the reservation they share stays with the ask.
+// The closed legs are returned, so that the caller can notify the user once the transaction commits.
+func closeSiblingAsks(ask *Ask, tx *gorm.DB) ([]*Ask, error) {
+	if ask.GroupId == 0 {
+		return nil, nil
+	}
+
+	var siblingIds []uint32
+	if err := tx.Model(&Ask{}).Where("groupId = ? AND id != ? AND isClosed = ?", ask.GroupId, ask.Id, 0).Pluck("id", &siblingIds).Error; err != nil {
+		return nil, err
+	}
+
+	var siblings []*Ask
+	for _, siblingId := range siblingIds {
+		sibling, err := getAsk(siblingId)
+		if err != nil {
+			return siblings, err
+		}
+
+		err = sibling.Close(tx)
+		if _, ok := err.(AlreadyClosedError); ok {
+			continue
+		}
+		if err != nil {
+			return siblings, err
+		}
+
+		siblings = append(siblings, sibling)
+	}
+
+	return siblings, nil
+}
+
+// closeSiblingBids closes the other open legs of a bid's group in the given transaction. Nothing
+// is returned to the user for them, as the reservation they share stays with the bid.
+// The closed legs are returned, so that the caller can notify the user once the transaction commits.
+func closeSiblingBids(bid *Bid, tx *gorm.DB) ([]*Bid, error) {
+	if bid.GroupId == 0 {
+		return nil, nil
+	}
+
+	var siblingIds []uint32
+	if err := tx.Model(&Bid{}).Where("groupId = ? AND id != ? AND isClosed = ?", bid.GroupId, bid.Id, 0).Pluck("id", &siblingIds).Error; err != nil {
+		return nil, err
+	}
+
+	var siblings []*Bid
+	for _, siblingId := range siblingIds {
+		sibling, err := getBid(siblingId)
+		if err != nil {
+			return siblings, err
+		}
+
+		err = sibling.Close(tx)
+		if _, ok := err.(AlreadyClosedError); ok {
+			continue
+		}
+		if err != nil {
+			return siblings, err
+		}
+
+		siblings = append(siblings, sibling)
+	}
+
+	return siblings, nil
+}
+
+// notifyClosedSiblingAsks sends updates for asks closed by closeSiblingAsks
+func notifyClosedSiblingAsks(siblings []*Ask) {
+	for _, sibling := range siblings {
+		sendClosedOrderUpdate(sibling.UserId, sibling.Id, true, sibling.StockQuantity)
+	}
+}
+
+// notifyClosedSiblingBids sends updates for bids closed by closeSiblingBids
+func notifyClosedSiblingBids(siblings []*Bid) {
+	for _, sibling := range siblings {
+		sendClosedOrderUpdate(sibling.UserId, sibling.Id, false, sibling.StockQuantity)
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func Test_PlaceAskOcoOrder(t *testing.T) {
+	seller := &User{Id: 2, Cash: 1000}
+	buyer := &User{Id: 3, Cash: 10000}
+	stock := &Stock{Id: 1, CurrentPrice: 200}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderFills")
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM TaxRecords")
+		db.Exec("DELETE FROM TransactionSummary")
+		db.Exec("DELETE FROM StockHistory")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Asks")
+		db.Exec("DELETE FROM Bids")
+		db.Exec("DELETE FROM OrderGroups")
+		db.Delete(seller)
+		db.Delete(buyer)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+		delete(userLocks.m, 3)
+	}()
+
+	for _, user := range []*User{seller, buyer} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	if err := db.Create(GetTransactionRef(seller.Id, stock.Id, FromExchangeTransaction, 0, 10, 200, 0, -2000)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	makeLegs := func(takeProfitPrice, stopPrice, takeProfitQuantity, stopQuantity uint64) (*Ask, *Ask) {
+		takeProfit := &Ask{UserId: seller.Id, StockId: stock.Id, OrderType: Limit, Price: takeProfitPrice, StockQuantity: takeProfitQuantity}
+		stop := &Ask{UserId: seller.Id, StockId: stock.Id, OrderType: StopLoss, Price: stopPrice, StockQuantity: stopQuantity}
+		return takeProfit, stop
+	}
+
+	var invalid = []struct {
+		takeProfitPrice    uint64
+		stopPrice          uint64
+		takeProfitQuantity uint64
+		stopQuantity       uint64
+	}{
+		// the legs are for different quantities
+		{220, 180, 5, 4},
+		// the stop is above the current price
+		{220, 210, 5, 5},
+		// the take-profit is below the stop
+		{170, 180, 5, 5},
+		// the user doesn't have the stocks
+		{220, 180, 11, 11},
+	}
+	for _, test := range invalid {
+		takeProfit, stop := makeLegs(test.takeProfitPrice, test.stopPrice, test.takeProfitQuantity, test.stopQuantity)
+		if _, err := PlaceAskOcoOrder(seller.Id, takeProfit, stop); err == nil {
+			t.Fatalf("Expected placing %+v to fail", test)
+		}
+	}
+
+	takeProfit, stop := makeLegs(220, 180, 5, 5)
+	groupId, err := PlaceAskOcoOrder(seller.Id, takeProfit, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, groupId, takeProfit.GroupId)
+	testutils.AssertEqual(t, groupId, stop.GroupId)
+
+	// the stocks are reserved once, and both legs see the reservation
+	var reserved []int64
+	if err := db.Table("Transactions").Where("userId = ? AND type = ?", seller.Id, PlaceOrderTransaction.String()).Pluck("reservedStockQuantity", &reserved).Error; err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, []int64{5}, reserved)
+	for _, ask := range []*Ask{takeProfit, stop} {
+		_, stocks, err := getPlaceOrderTransactionDetails(ask.Id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, int64(-5), stocks)
+	}
+
+	// the take-profit trades, and the stop is cancelled along with it
+	bid := &Bid{UserId: buyer.Id, StockId: stock.Id, OrderType: Limit, Price: 220, StockQuantity: 5}
+	if _, err := PlaceBidOrder(buyer.Id, bid); err != nil {
+		t.Fatal(err)
+	}
+	askStatus, bidStatus, tr := PerformOrderFillTransaction(takeProfit, bid, 220, 5, false, true)
+	if askStatus != AskDone || bidStatus != BidDone || tr == nil {
+		t.Fatalf("Expected both orders to be filled, got %v and %v", askStatus, bidStatus)
+	}
+
+	savedStop := &Ask{}
+	db.First(savedStop, stop.Id)
+	testutils.AssertEqual(t, true, savedStop.IsClosed)
+	testutils.AssertEqual(t, uint64(0), savedStop.StockQuantityFulfilled)
+
+	// nothing is returned for the stop, as the stocks it shared were sold
+	var holding = struct {
+		StockQuantity         int64
+		ReservedStockQuantity int64
+	}{}
+	sql := "SELECT SUM(stockQuantity) AS stock_quantity, SUM(reservedStockQuantity) AS reserved_stock_quantity FROM Transactions WHERE userId = ? AND stockId = ?"
+	if err := db.Raw(sql, seller.Id, stock.Id).Scan(&holding).Error; err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, int64(5), holding.StockQuantity)
+	testutils.AssertEqual(t, int64(0), holding.ReservedStockQuantity)
+
+	var count int
+	db.Table("Transactions").Where("userId = ? AND type = ?", seller.Id, CancelOrderTransaction.String()).Count(&count)
+	testutils.AssertEqual(t, 0, count)
+}
+
+func Test_PlaceBidOcoOrder(t *testing.T) {
+	user := &User{Id: 2, Cash: 10000}
+	stock := &Stock{Id: 1, CurrentPrice: 200}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Bids")
+		db.Exec("DELETE FROM OrderGroups")
+		db.Delete(user)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// the stop of a bid group has to be above the current price
+	takeProfit := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: Limit, Price: 180, StockQuantity: 5}
+	stop := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: StopLoss, Price: 190, StockQuantity: 5}
+	if _, err := PlaceBidOcoOrder(user.Id, takeProfit, stop); err == nil {
+		t.Fatalf("Expected a stop below the current price to fail")
+	}
+
+	takeProfit = &Bid{UserId: user.Id, StockId: stock.Id, OrderType: Limit, Price: 180, StockQuantity: 5}
+	stop = &Bid{UserId: user.Id, StockId: stock.Id, OrderType: StopLoss, Price: 220, StockQuantity: 5}
+	groupId, err := PlaceBidOcoOrder(user.Id, takeProfit, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, groupId, takeProfit.GroupId)
+	testutils.AssertEqual(t, groupId, stop.GroupId)
+
+	// the cash is reserved once, on the higher of the prices of the legs
+	orderPrice := getOrderGroupFeePrice(stock.Id, 180, StopLoss, 220, 0)
+	reservedCash := 5*orderPrice + getMaxOrderFee(user.Id, stock.Id, 5, orderPrice)
+	for _, bid := range []*Bid{takeProfit, stop} {
+		cash, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, int64(reservedCash), cash)
+	}
+
+	u := &User{}
+	db.First(u, user.Id)
+	testutils.AssertEqual(t, reservedCash, u.ReservedCash)
+	testutils.AssertEqual(t, 10000-reservedCash, u.Cash)
+
+	// cancelling a leg cancels the group, and returns the cash once
+	if _, _, err := CancelOrder(user.Id, takeProfit.Id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var openBids int
+	db.Model(&Bid{}).Where("groupId = ? AND isClosed = ?", groupId, false).Count(&openBids)
+	testutils.AssertEqual(t, 0, openBids)
+
+	u = &User{}
+	db.First(u, user.Id)
+	testutils.AssertEqual(t, uint64(0), u.ReservedCash)
+	testutils.AssertEqual(t, uint64(10000), u.Cash)
+}
@@ -0,0 +1,407 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// RightsIssue offers the holders of a stock NewShares new stocks for every HeldShares stocks they hold,
+// at Price, which is below the stock's price. Entitlements are worked out from the holdings when the issue
+// is announced. They can be subscribed to till the market closes on ClosesOnDay, after which they lapse.
+type RightsIssue struct {
+	Id          uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	StockId     uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	NewShares   uint64 `gorm:"column:newShares;not null" json:"new_shares"`
+	HeldShares  uint64 `gorm:"column:heldShares;not null" json:"held_shares"`
+	Price       uint64 `gorm:"column:price;not null" json:"price"`
+	ClosesOnDay uint32 `gorm:"column:closesOnDay;not null" json:"closes_on_day"`
+	IsClosed    bool   `gorm:"column:isClosed;not null" json:"is_closed"`
+	CreatedAt   string `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (RightsIssue) TableName() string {
+	return "RightsIssues"
+}
+
+func (ri *RightsIssue) ToProto() *models_pb.RightsIssue {
+	return &models_pb.RightsIssue{
+		Id:          ri.Id,
+		StockId:     ri.StockId,
+		NewShares:   ri.NewShares,
+		HeldShares:  ri.HeldShares,
+		Price:       ri.Price,
+		ClosesOnDay: ri.ClosesOnDay,
+		IsClosed:    ri.IsClosed,
+		CreatedAt:   ri.CreatedAt,
+	}
+}
+
+// RightsEntitlement holds the new stocks a user can subscribe to in a rights issue
+type RightsEntitlement struct {
+	Id               uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	RightsIssueId    uint32 `gorm:"column:rightsIssueId;not null" json:"rights_issue_id"`
+	UserId           uint32 `gorm:"column:userId;not null" json:"user_id"`
+	EntitledQuantity uint64 `gorm:"column:entitledQuantity;not null" json:"entitled_quantity"`
+	// stocks subscribed to so far. It never goes above EntitledQuantity.
+	SubscribedQuantity uint64 `gorm:"column:subscribedQuantity;not null" json:"subscribed_quantity"`
+}
+
+func (RightsEntitlement) TableName() string {
+	return "RightsEntitlements"
+}
+
+func (re *RightsEntitlement) ToProto() *models_pb.RightsEntitlement {
+	return &models_pb.RightsEntitlement{
+		RightsIssueId:      re.RightsIssueId,
+		EntitledQuantity:   re.EntitledQuantity,
+		SubscribedQuantity: re.SubscribedQuantity,
+	}
+}
+
+// InvalidRightsIssueError is returned if a rights issue can't be announced, or subscribed to as requested
+type InvalidRightsIssueError struct{ reason string }
+
+func (e InvalidRightsIssueError) Error() string {
+	return e.reason
+}
+
+// getRightsEntitlement returns the new stocks a holding entitles its holder to. Fractions are dropped.
+func getRightsEntitlement(holding int64, newShares, heldShares uint64) uint64 {
+	if holding <= 0 {
+		return 0
+	}
+	return uint64(holding) * newShares / heldShares
+}
+
+// getRightsIssue returns a rights issue from the database
+func getRightsIssue(db *gorm.DB, rightsIssueId uint32) (*RightsIssue, error) {
+	rightsIssue := &RightsIssue{}
+	if err := db.First(rightsIssue, rightsIssueId).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidRightsIssueError{"Invalid rights issue"}
+		}
+		return nil, err
+	}
+	return rightsIssue, nil
+}
+
+// AnnounceRightsIssue announces a rights issue of newShares stocks for every heldShares held, at price.
+// Every user holding the stock right now gets entitled to their share of it, and is notified.
+func AnnounceRightsIssue(stockId uint32, newShares, heldShares, price uint64, closesOnDay uint32) (*RightsIssue, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":            "AnnounceRightsIssue",
+		"param_stockId":     stockId,
+		"param_newShares":   newShares,
+		"param_heldShares":  heldShares,
+		"param_price":       price,
+		"param_closesOnDay": closesOnDay,
+	})
+
+	l.Infof("Attempting")
+
+	stock, err := GetStockCopy(stockId)
+	if err != nil || stock.IsDelisted {
+		return nil, InvalidStockIdError{}
+	}
+	if stock.IsBankrupt {
+		return nil, StockBankruptError{}
+	}
+	if newShares == 0 || heldShares == 0 {
+		return nil, InvalidRightsIssueError{"The ratio has to be N:M with N and M positive."}
+	}
+	if price == 0 || price >= stock.CurrentPrice {
+		return nil, InvalidRightsIssueError{fmt.Sprintf("The price has to be more than 0 and less than the stock's price of %d.", stock.CurrentPrice)}
+	}
+	if closesOnDay < GetMarketDay() {
+		return nil, InvalidRightsIssueError{"The rights issue can't close on a market day that's over."}
+	}
+
+	db := getDB()
+
+	var holders []*userDetails
+	sql := "SELECT userId AS user_id, (SUM(stockQuantity)+SUM(reservedStockQuantity)) AS stock_quantity FROM Transactions WHERE stockId = ? GROUP BY userId HAVING stock_quantity > 0"
+	if err := db.Raw(sql, stockId).Scan(&holders).Error; err != nil {
+		l.Errorf("Error while loading the holders of the stock: %+v", err)
+		return nil, err
+	}
+
+	rightsIssue := &RightsIssue{
+		StockId:     stockId,
+		NewShares:   newShares,
+		HeldShares:  heldShares,
+		Price:       price,
+		ClosesOnDay: closesOnDay,
+		CreatedAt:   utils.GetCurrentTimeISO8601(),
+	}
+
+	tx := db.Begin()
+
+	if err := tx.Create(rightsIssue).Error; err != nil {
+		l.Errorf("Error while creating the rights issue: %+v", err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	var entitlements []*RightsEntitlement
+	for _, holder := range holders {
+		entitledQuantity := getRightsEntitlement(int64(holder.StockQuantity), newShares, heldShares)
+		if entitledQuantity == 0 {
+			continue
+		}
+
+		entitlement := &RightsEntitlement{
+			RightsIssueId:    rightsIssue.Id,
+			UserId:           holder.UserID,
+			EntitledQuantity: entitledQuantity,
+		}
+		if err := tx.Create(entitlement).Error; err != nil {
+			l.Errorf("Error while creating the entitlement of user %d: %+v", holder.UserID, err)
+			tx.Rollback()
+			return nil, err
+		}
+		entitlements = append(entitlements, entitlement)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error while committing: %+v", err)
+		return nil, err
+	}
+
+	l.Infof("Announced rights issue %d with %d entitlements", rightsIssue.Id, len(entitlements))
+
+	go func() {
+		message := fmt.Sprintf("%s has announced a rights issue of %d shares for every %d held, at %d per share. It closes on day %d.", stock.FullName, newShares, heldShares, price, closesOnDay)
+		SendPushNotification(0, PushNotification{
+			Title:   "Message from Dalal Street! A company just announced a rights issue.",
+			Message: message,
+			LogoUrl: fmt.Sprintf("%v/static/dalalfavicon.png", config.BackendUrl),
+		})
+		SendNotification(0, message, true)
+
+		for _, entitlement := range entitlements {
+			SendNotification(entitlement.UserId, fmt.Sprintf("You are entitled to subscribe to %d shares of %s in its rights issue.", entitlement.EntitledQuantity, stock.FullName), false)
+		}
+	}()
+
+	return rightsIssue, nil
+}
+
+// GetOpenRightsIssues returns the rights issues that can still be subscribed to
+func GetOpenRightsIssues() ([]*RightsIssue, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "GetOpenRightsIssues",
+	})
+
+	db := getDB()
+
+	var rightsIssues []*RightsIssue
+	if err := db.Where("isClosed = ?", false).Order("id").Find(&rightsIssues).Error; err != nil {
+		l.Errorf("Error while loading rights issues: %+v", err)
+		return nil, err
+	}
+
+	return rightsIssues, nil
+}
+
+// GetRightsEntitlements returns a user's entitlements in the rights issues that can still be subscribed to
+func GetRightsEntitlements(userId uint32) ([]*RightsEntitlement, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetRightsEntitlements",
+		"param_userId": userId,
+	})
+
+	db := getDB()
+
+	var entitlements []*RightsEntitlement
+	err := db.Joins("JOIN RightsIssues ON RightsIssues.id = RightsEntitlements.rightsIssueId").
+		Where("RightsEntitlements.userId = ? AND RightsIssues.isClosed = ?", userId, false).
+		Find(&entitlements).Error
+	if err != nil {
+		l.Errorf("Error while loading entitlements: %+v", err)
+		return nil, err
+	}
+
+	return entitlements, nil
+}
+
+// SubscribeRightsIssue buys stockQuantity new stocks of a rights issue for a user, out of their entitlement.
+// The stocks are newly issued, so they add to the stocks in the market.
+func SubscribeRightsIssue(userId, rightsIssueId uint32, stockQuantity uint64) (*Transaction, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":              "SubscribeRightsIssue",
+		"param_userId":        userId,
+		"param_rightsIssueId": rightsIssueId,
+		"param_stockQuantity": stockQuantity,
+	})
+
+	l.Infof("Attempting")
+
+	if stockQuantity == 0 {
+		return nil, InvalidRightsIssueError{"You have to subscribe to at least 1 share."}
+	}
+
+	db := getDB()
+
+	rightsIssue, err := getRightsIssue(db, rightsIssueId)
+	if err != nil {
+		return nil, err
+	}
+	if rightsIssue.IsClosed || rightsIssue.ClosesOnDay < GetMarketDay() {
+		return nil, InvalidRightsIssueError{"The rights issue has closed."}
+	}
+
+	stockId := rightsIssue.StockId
+	if IsStockBankrupt(stockId) {
+		return nil, StockBankruptError{}
+	}
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return nil, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	entitlement := &RightsEntitlement{}
+	if err := db.Where("rightsIssueId = ? AND userId = ?", rightsIssueId, userId).First(entitlement).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidRightsIssueError{"You aren't entitled to this rights issue."}
+		}
+		l.Errorf("Error while loading the entitlement: %+v", err)
+		return nil, err
+	}
+
+	remaining := entitlement.EntitledQuantity - entitlement.SubscribedQuantity
+	if stockQuantity > remaining {
+		return nil, InvalidRightsIssueError{fmt.Sprintf("You can subscribe to at most %d more shares.", remaining)}
+	}
+
+	cost := stockQuantity * rightsIssue.Price
+	if cost > user.Cash {
+		l.Debugf("User does not have enough cash. Want %d, Have %d. Failing.", cost, user.Cash)
+		return nil, NotEnoughCashError{}
+	}
+
+	l.Debugf("Acquiring exclusive write on stock")
+	allStocks.m[stockId].Lock()
+	stock := allStocks.m[stockId].stock
+	defer func() {
+		l.Debugf("Released exclusive write on stock")
+		allStocks.m[stockId].Unlock()
+	}()
+
+	transaction := GetTransactionRef(userId, stockId, RightsIssueTransaction, 0, int64(stockQuantity), rightsIssue.Price, 0, -int64(cost))
+
+	oldCash := user.Cash
+	oldSubscribedQuantity := entitlement.SubscribedQuantity
+	oldStocksInMarket := stock.StocksInMarket
+	oldUpdatedAt := stock.UpdatedAt
+
+	user.Cash -= cost
+	entitlement.SubscribedQuantity += stockQuantity
+	stock.StocksInMarket += stockQuantity
+	stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) (*Transaction, error) {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		entitlement.SubscribedQuantity = oldSubscribedQuantity
+		stock.StocksInMarket = oldStocksInMarket
+		stock.UpdatedAt = oldUpdatedAt
+		tx.Rollback()
+		return nil, err
+	}
+
+	// the subscription price becomes part of the user's cost of holding the stock
+	transactionSummary, taxRecord := getTaxForBiddingUser(tx, stockId, stockQuantity, rightsIssue.Price, user)
+
+	if err := tx.Save(transactionSummary).Error; err != nil {
+		return errorHelper("Error updating the transaction summary. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(transaction).Error; err != nil {
+		return errorHelper("Error creating the transaction. Rolling back. Error: %+v", err)
+	}
+	taxTransaction, err := saveTaxRecord(tx, taxRecord, transaction)
+	if err != nil {
+		return errorHelper("Error creating the tax transaction. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error deducting the cash from user's account. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(entitlement).Error; err != nil {
+		return errorHelper("Error updating the entitlement. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Save(stock).Error; err != nil {
+		return errorHelper("Error issuing the stocks. Rolling back. Error: %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	l.Infof("Subscribed to %d stocks @ %d per stock. New balance: %d", stockQuantity, rightsIssue.Price, user.Cash)
+
+	go func(price, inExchange, inMarket uint64) {
+		stockExchangeStream := datastreamsManager.GetStockExchangeStream()
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+
+		stockExchangeStream.SendStockExchangeUpdate(stockId, &datastreams_pb.StockExchangeDataPoint{
+			Price:            price,
+			StocksInExchange: inExchange,
+			StocksInMarket:   inMarket,
+		})
+		transactionsStream.SendTransaction(transaction.ToProto())
+		if taxTransaction != nil {
+			transactionsStream.SendTransaction(taxTransaction.ToProto())
+		}
+	}(stock.CurrentPrice, stock.StocksInExchange, stock.StocksInMarket)
+
+	return transaction, nil
+}
+
+// CloseRightsIssues closes the rights issues whose last market day is over. Entitlements that weren't
+// subscribed to lapse. It's called when the market closes.
+func CloseRightsIssues() {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "CloseRightsIssues",
+	})
+
+	l.Infof("Attempting")
+
+	rightsIssues, err := GetOpenRightsIssues()
+	if err != nil {
+		l.Errorf("Unable to get the rights issues: %+v", err)
+		return
+	}
+
+	db := getDB()
+	marketDay := GetMarketDay()
+
+	for _, rightsIssue := range rightsIssues {
+		if rightsIssue.ClosesOnDay > marketDay {
+			continue
+		}
+
+		rightsIssue.IsClosed = true
+		if err := db.Save(rightsIssue).Error; err != nil {
+			l.Errorf("Unable to close rights issue %d: %+v", rightsIssue.Id, err)
+			continue
+		}
+
+		var subscribed = struct{ Total uint64 }{0}
+		db.Raw("SELECT SUM(subscribedQuantity) AS total FROM RightsEntitlements WHERE rightsIssueId = ?", rightsIssue.Id).Scan(&subscribed)
+
+		l.Infof("Closed rights issue %d. %d stocks were subscribed to", rightsIssue.Id, subscribed.Total)
+	}
+}
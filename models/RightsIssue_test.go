@@ -0,0 +1,129 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetRightsEntitlement(t *testing.T) {
+	var tests = []struct {
+		holding          int64
+		newShares        uint64
+		heldShares       uint64
+		entitledQuantity uint64
+	}{
+		{10, 1, 2, 5},
+		{11, 1, 2, 5},
+		{7, 3, 1, 21},
+		{1, 1, 5, 0},
+		{0, 1, 2, 0},
+		// short sellers aren't entitled to anything
+		{-10, 1, 2, 0},
+	}
+
+	for _, test := range tests {
+		if entitledQuantity := getRightsEntitlement(test.holding, test.newShares, test.heldShares); entitledQuantity != test.entitledQuantity {
+			t.Errorf("getRightsEntitlement(%+v) = %d, expected %d", test, entitledQuantity, test.entitledQuantity)
+		}
+	}
+}
+
+func Test_RightsIssue(t *testing.T) {
+	users := []*User{{Id: 2, Cash: 1000}, {Id: 3, Cash: 1000}}
+	stock := &Stock{Id: 1, CurrentPrice: 100, StocksInExchange: 50, StocksInMarket: 20}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM TaxRecords")
+		db.Exec("DELETE FROM TransactionSummary")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM RightsEntitlements")
+		db.Exec("DELETE FROM RightsIssues")
+		for _, user := range users {
+			db.Delete(user)
+			delete(userLocks.m, user.Id)
+		}
+		db.Delete(stock)
+	}()
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	// user 2 holds 10 stocks and user 3 holds 5
+	if err := db.Create(GetTransactionRef(2, stock.Id, FromExchangeTransaction, 0, 10, 100, 0, -1000)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(GetTransactionRef(3, stock.Id, FromExchangeTransaction, 0, 5, 100, 0, -500)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AnnounceRightsIssue(stock.Id, 1, 2, 100, GetMarketDay()); err == nil {
+		t.Fatalf("Expected a rights issue at the stock's price to fail")
+	}
+
+	// 1 new stock @ 60 for every 2 held. User 3's half a stock is dropped.
+	rightsIssue, err := AnnounceRightsIssue(stock.Id, 1, 2, 60, GetMarketDay())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entitled := range []uint64{5, 2} {
+		entitlements, err := GetRightsEntitlements(users[i].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entitlements) != 1 {
+			t.Fatalf("Expected 1 entitlement for user %d, got %+v", users[i].Id, entitlements)
+		}
+		testutils.AssertEqual(t, entitled, entitlements[0].EntitledQuantity)
+	}
+
+	transaction, err := SubscribeRightsIssue(2, rightsIssue.Id, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, RightsIssueTransaction, transaction.Type)
+	testutils.AssertEqual(t, int64(3), transaction.StockQuantity)
+	testutils.AssertEqual(t, int64(-180), transaction.Total)
+
+	// only 2 of the 5 are left
+	if _, err := SubscribeRightsIssue(2, rightsIssue.Id, 3); err == nil {
+		t.Fatalf("Expected subscribing beyond the entitlement to fail")
+	}
+
+	u := &User{}
+	db.First(u, 2)
+	testutils.AssertEqual(t, uint64(820), u.Cash)
+
+	dbStock := &Stock{}
+	db.First(dbStock, stock.Id)
+	testutils.AssertEqual(t, uint64(23), dbStock.StocksInMarket)
+	testutils.AssertEqual(t, uint64(50), dbStock.StocksInExchange)
+
+	// the entitlements that weren't subscribed to lapse when the issue closes
+	CloseRightsIssues()
+
+	if _, err := SubscribeRightsIssue(3, rightsIssue.Id, 2); err == nil {
+		t.Fatalf("Expected subscribing to a closed rights issue to fail")
+	}
+	entitlements, err := GetRightsEntitlements(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, 0, len(entitlements))
+
+	u = &User{}
+	db.First(u, 3)
+	testutils.AssertEqual(t, uint64(1000), u.Cash)
+
+	var count int
+	db.Table("Transactions").Where("type = ?", RightsIssueTransaction.String()).Count(&count)
+	testutils.AssertEqual(t, 1, count)
+}
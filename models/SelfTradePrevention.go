@@ -0,0 +1,438 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// SelfTradePrevention decides what the matching engine does when an incoming order would trade
+// with an order of the same user
+type SelfTradePrevention uint8
+
+func (stp *SelfTradePrevention) Scan(value interface{}) error {
+	mode, ok := selfTradePreventionFromString(string(value.([]byte)))
+	if !ok {
+		return fmt.Errorf("Invalid value for SelfTradePrevention. Got %s", string(value.([]byte)))
+	}
+	*stp = mode
+	return nil
+}
+
+func (stp SelfTradePrevention) Value() (driver.Value, error) { return stp.String(), nil }
+
+const (
+	// DefaultSelfTradePrevention makes a stock use the mode set in the config
+	DefaultSelfTradePrevention SelfTradePrevention = iota
+	// SkipOwnOrders lets the incoming order skip the user's own orders. They stay in the book.
+	SkipOwnOrders
+	// CancelNewest cancels the rest of the incoming order
+	CancelNewest
+	// CancelOldest cancels the user's order resting in the book. The incoming order goes on matching.
+	CancelOldest
+	// CancelBoth cancels the incoming order as well as the resting one
+	CancelBoth
+	// DecrementAndCancel takes the smaller unfulfilled quantity off both orders. The one that
+	// has nothing left is cancelled, and the other one goes on with the rest.
+	DecrementAndCancel
+)
+
+var selfTradePreventions = [...]string{
+	"Default",
+	"SkipOwnOrders",
+	"CancelNewest",
+	"CancelOldest",
+	"CancelBoth",
+	"DecrementAndCancel",
+}
+
+func (stp SelfTradePrevention) String() string {
+	return selfTradePreventions[stp]
+}
+
+func selfTradePreventionFromString(s string) (SelfTradePrevention, bool) {
+	for i, name := range selfTradePreventions {
+		if name == s {
+			return SelfTradePrevention(i), true
+		}
+	}
+	return DefaultSelfTradePrevention, false
+}
+
+func SelfTradePreventionFromProto(pStp models_pb.SelfTradePrevention) SelfTradePrevention {
+	if pStp == models_pb.SelfTradePrevention_SKIP_OWN_ORDERS {
+		return SkipOwnOrders
+	} else if pStp == models_pb.SelfTradePrevention_CANCEL_NEWEST {
+		return CancelNewest
+	} else if pStp == models_pb.SelfTradePrevention_CANCEL_OLDEST {
+		return CancelOldest
+	} else if pStp == models_pb.SelfTradePrevention_CANCEL_BOTH {
+		return CancelBoth
+	} else if pStp == models_pb.SelfTradePrevention_DECREMENT_AND_CANCEL {
+		return DecrementAndCancel
+	} else {
+		return DefaultSelfTradePrevention
+	}
+}
+
+func (stp SelfTradePrevention) ToProto() models_pb.SelfTradePrevention {
+	m := make(map[SelfTradePrevention]models_pb.SelfTradePrevention)
+	m[DefaultSelfTradePrevention] = models_pb.SelfTradePrevention_DEFAULT
+	m[SkipOwnOrders] = models_pb.SelfTradePrevention_SKIP_OWN_ORDERS
+	m[CancelNewest] = models_pb.SelfTradePrevention_CANCEL_NEWEST
+	m[CancelOldest] = models_pb.SelfTradePrevention_CANCEL_OLDEST
+	m[CancelBoth] = models_pb.SelfTradePrevention_CANCEL_BOTH
+	m[DecrementAndCancel] = models_pb.SelfTradePrevention_DECREMENT_AND_CANCEL
+
+	return m[stp]
+}
+
+// GetSelfTradePrevention returns the self-trade prevention mode the matching engine uses for a stock.
+// Stocks without a mode of their own use the one in the config. Orders of the same user are
+// skipped if that isn't set either.
+func GetSelfTradePrevention(stockId uint32) SelfTradePrevention {
+	stock, err := GetStockCopy(stockId)
+	if err == nil && stock.SelfTradePrevention != DefaultSelfTradePrevention {
+		return stock.SelfTradePrevention
+	}
+
+	mode, ok := selfTradePreventionFromString(config.SelfTradePrevention)
+	if !ok || mode == DefaultSelfTradePrevention {
+		return SkipOwnOrders
+	}
+	return mode
+}
+
+// SetSelfTradePrevention sets the self-trade prevention mode of a stock.
+// DefaultSelfTradePrevention makes the stock use the mode in the config again.
+func SetSelfTradePrevention(stockId uint32, mode SelfTradePrevention) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SetSelfTradePrevention",
+		"param_stockId": stockId,
+		"param_mode":    mode,
+	})
+
+	l.Infof("Attempting")
+
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[stockId]
+	if !ok {
+		allStocks.Unlock()
+		return InvalidStockError
+	}
+	defer allStocks.Unlock()
+
+	stockNLock.Lock()
+	defer stockNLock.Unlock()
+
+	stock := stockNLock.stock
+	oldStockCopy := *stock
+
+	stock.SelfTradePrevention = mode
+
+	db := getDB()
+
+	if err := db.Save(stock).Error; err != nil {
+		l.Errorf("Error while saving stock: %+v", err)
+		*stock = oldStockCopy
+		return err
+	}
+
+	l.Infof("Done")
+	return nil
+}
+
+// sendSelfTradeOrderUpdate tells the user that an order of theirs was cancelled or decremented
+// to keep it from trading with another order of theirs
+func sendSelfTradeOrderUpdate(userId, orderId uint32, isAsk bool, stockQuantity uint64, isClosed bool, transaction *Transaction) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:                   orderId,
+		IsAsk:                isAsk,
+		StockQuantity:        stockQuantity,
+		IsClosed:             isClosed,
+		IsModified:           !isClosed,
+		IsSelfTradePrevented: true,
+	})
+
+	if transaction != nil {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(transaction.ToProto())
+	}
+}
+
+// CancelSelfTradeAsk cancels an ask that would have traded with a bid of the same user. The stocks
+// reserved for its unfulfilled part are returned through a CancelOrderTransaction, just like for
+// an ask the user cancelled.
+// AlreadyClosedError is returned if the ask got closed meanwhile.
+func CancelSelfTradeAsk(ask *Ask) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "CancelSelfTradeAsk",
+		"param_ask": fmt.Sprintf("%+v", ask),
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(ask.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+	tx := db.Begin()
+
+	if err := ask.Close(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := saveAskCancelOrderTransaction(ask, user, tx); err != nil {
+		l.Errorf("Error while returning reserved stocks. Error: %+v", err)
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error while commiting %+v", err)
+		tx.Rollback()
+		return err
+	}
+
+	go sendSelfTradeOrderUpdate(ask.UserId, ask.Id, true, ask.StockQuantity, true, nil)
+
+	l.Infof("Cancelled ask")
+	return nil
+}
+
+// CancelSelfTradeBid cancels a bid that would have traded with an ask of the same user. The cash
+// reserved for its unfulfilled part is returned through a CancelOrderTransaction, just like for
+// a bid the user cancelled.
+// AlreadyClosedError is returned if the bid got closed meanwhile.
+func CancelSelfTradeBid(bid *Bid) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":    "CancelSelfTradeBid",
+		"param_bid": fmt.Sprintf("%+v", bid),
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(bid.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	db := getDB()
+	tx := db.Begin()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	errorHelper := func(err error) error {
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		tx.Rollback()
+		return err
+	}
+
+	if err := bid.Close(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := saveBidCancelOrderTransaction(bid, user, tx); err != nil {
+		l.Errorf("Error while returning reserved cash. Error: %+v", err)
+		return errorHelper(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error while commiting %+v", err)
+		return errorHelper(err)
+	}
+
+	go sendSelfTradeOrderUpdate(bid.UserId, bid.Id, false, bid.StockQuantity, true, nil)
+
+	l.Infof("Cancelled bid")
+	return nil
+}
+
+// DecrementSelfTradeAsk takes stockQuantity off the unfulfilled part of an ask that would have
+// traded with a bid of the same user. The stocks reserved for them are returned through a
+// ModifyOrderTransaction. stockQuantity has to be less than the unfulfilled quantity of the ask.
+// AlreadyClosedError is returned if the ask got closed meanwhile.
+func DecrementSelfTradeAsk(ask *Ask, stockQuantity uint64) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":              "DecrementSelfTradeAsk",
+		"param_ask":           fmt.Sprintf("%+v", ask),
+		"param_stockQuantity": stockQuantity,
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(ask.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	ask.Lock()
+	if ask.IsClosed {
+		ask.Unlock()
+		return AlreadyClosedError{ask.Id}
+	}
+	if stockQuantity >= ask.StockQuantity-ask.StockQuantityFulfilled {
+		ask.Unlock()
+		return fmt.Errorf("Cannot take %d stocks off ask %d with %d stocks unfulfilled", stockQuantity, ask.Id, ask.StockQuantity-ask.StockQuantityFulfilled)
+	}
+	oldStockQuantity := ask.StockQuantity
+	oldUpdatedAt := ask.UpdatedAt
+	ask.StockQuantity -= stockQuantity
+	ask.UpdatedAt = utils.GetCurrentTimeISO8601()
+	ask.Unlock()
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		ask.Lock()
+		ask.StockQuantity = oldStockQuantity
+		ask.UpdatedAt = oldUpdatedAt
+		ask.Unlock()
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(ask).Error; err != nil {
+		return errorHelper("Error while saving Ask. Rolling back. Error: %+v", err)
+	}
+
+	modifyOrderTransaction := GetTransactionRef(user.Id, ask.StockId, ModifyOrderTransaction, -int64(stockQuantity), int64(stockQuantity), 0, 0, 0)
+	if err := savePlaceOrderTransaction(ask.Id, modifyOrderTransaction, true, tx); err != nil {
+		return errorHelper("Error returning reserved stocks. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error while commiting %+v", err)
+	}
+
+	go sendSelfTradeOrderUpdate(ask.UserId, ask.Id, true, ask.StockQuantity, false, modifyOrderTransaction)
+
+	l.Infof("Decremented ask")
+	return nil
+}
+
+// DecrementSelfTradeBid takes stockQuantity off the unfulfilled part of a bid that would have
+// traded with an ask of the same user. The cash reserved for them is returned through a
+// ModifyOrderTransaction. stockQuantity has to be less than the unfulfilled quantity of the bid.
+// AlreadyClosedError is returned if the bid got closed meanwhile.
+func DecrementSelfTradeBid(bid *Bid, stockQuantity uint64) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":              "DecrementSelfTradeBid",
+		"param_bid":           fmt.Sprintf("%+v", bid),
+		"param_stockQuantity": stockQuantity,
+	})
+
+	l.Infof("Attempting")
+
+	l.Debugf("Acquiring exclusive write on user")
+	ch, user, err := getUserExclusively(bid.UserId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	reservedCash, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+	if err != nil {
+		l.Errorf("Could not retrieve reserved cash. Error: %+v", err)
+		return err
+	}
+
+	bid.Lock()
+	if bid.IsClosed {
+		bid.Unlock()
+		return AlreadyClosedError{bid.Id}
+	}
+	if stockQuantity >= bid.StockQuantity-bid.StockQuantityFulfilled {
+		bid.Unlock()
+		return fmt.Errorf("Cannot take %d stocks off bid %d with %d stocks unfulfilled", stockQuantity, bid.Id, bid.StockQuantity-bid.StockQuantityFulfilled)
+	}
+	// the cash is reserved in proportion to the quantity (see saveBidCancelOrderTransaction)
+	returnedCash := int64(float64(reservedCash) * float64(stockQuantity) / float64(bid.StockQuantity))
+	oldStockQuantity := bid.StockQuantity
+	oldUpdatedAt := bid.UpdatedAt
+	bid.StockQuantity -= stockQuantity
+	bid.UpdatedAt = utils.GetCurrentTimeISO8601()
+	bid.Unlock()
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(format string, err error) error {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		bid.Lock()
+		bid.StockQuantity = oldStockQuantity
+		bid.UpdatedAt = oldUpdatedAt
+		bid.Unlock()
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(bid).Error; err != nil {
+		return errorHelper("Error while saving Bid. Rolling back. Error: %+v", err)
+	}
+
+	user.Cash += uint64(returnedCash)
+	user.ReservedCash -= uint64(returnedCash)
+
+	if err := tx.Save(user).Error; err != nil {
+		return errorHelper("Error while returning reserved cash to the user. Rolling back. Error: %+v", err)
+	}
+
+	modifyOrderTransaction := GetTransactionRef(user.Id, bid.StockId, ModifyOrderTransaction, 0, 0, 0, -returnedCash, returnedCash)
+	if err := savePlaceOrderTransaction(bid.Id, modifyOrderTransaction, false, tx); err != nil {
+		return errorHelper("Error updating reserved cash. Rolling back. Error: %+v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error while commiting %+v", err)
+	}
+
+	go sendSelfTradeOrderUpdate(bid.UserId, bid.Id, false, bid.StockQuantity, false, modifyOrderTransaction)
+
+	l.Infof("Decremented bid")
+	return nil
+}
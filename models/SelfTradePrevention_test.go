@@ -0,0 +1,48 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetSelfTradePrevention(t *testing.T) {
+	var stockId uint32 = 1000
+
+	oldMode := config.SelfTradePrevention
+	oldStock, hadStock := allStocks.m[stockId]
+	defer func() {
+		config.SelfTradePrevention = oldMode
+		allStocks.Lock()
+		if hadStock {
+			allStocks.m[stockId] = oldStock
+		} else {
+			delete(allStocks.m, stockId)
+		}
+		allStocks.Unlock()
+	}()
+
+	allStocks.Lock()
+	allStocks.m[stockId] = &stockAndLock{stock: &Stock{Id: stockId}}
+	allStocks.Unlock()
+
+	var tests = []struct {
+		stockMode  SelfTradePrevention
+		configMode string
+		mode       SelfTradePrevention
+	}{
+		{DefaultSelfTradePrevention, "CancelNewest", CancelNewest},
+		{DefaultSelfTradePrevention, "", SkipOwnOrders},
+		{DefaultSelfTradePrevention, "Default", SkipOwnOrders},
+		{DefaultSelfTradePrevention, "NoSuchMode", SkipOwnOrders},
+		{DecrementAndCancel, "CancelNewest", DecrementAndCancel},
+		{CancelBoth, "", CancelBoth},
+	}
+
+	for _, test := range tests {
+		config.SelfTradePrevention = test.configMode
+		allStocks.m[stockId].stock.SelfTradePrevention = test.stockMode
+
+		if mode := GetSelfTradePrevention(stockId); mode != test.mode {
+			t.Errorf("GetSelfTradePrevention(%+v) = %s, expected %s", test, mode, test.mode)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"fmt"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 )
@@ -13,13 +17,42 @@ type ShortSellBank struct {
 }
 
 // short sell lends stores all the stock lends to a user
-// this will be used to square-off at EOD
+// a lend stays open across market days till the user covers it, or it's squared off after a recall
 type ShortSellLends struct {
 	Id            uint32 `gorm:"column:id;" json:"id"`
 	StockId       uint32 `gorm:"column:stockId;" json:"stockId"`
 	UserId        uint32 `gorm:"column:userId;" json:"userId"`
 	StockQuantity uint32 `gorm:"column:stockQuantity;" json:"stockQuantity"`
 	IsSquaredOff  bool   `gorm:"column:isSquaredOff; default false;" json:"isSquaredOff"`
+	CreatedAt     string `gorm:"column:createdAt;" json:"createdAt"`
+	// market day the borrow fee was last charged for
+	LastFeeDay uint32 `gorm:"column:lastFeeDay;" json:"lastFeeDay"`
+	// market day the bank recalled the lend on. 0 if it hasn't been recalled
+	RecalledOnDay uint32 `gorm:"column:recalledOnDay;" json:"recalledOnDay"`
+	// borrow fees the user didn't have the cash for. They're taken when the lend is closed
+	UnpaidFee uint64 `gorm:"column:unpaidFee;" json:"unpaidFee"`
+}
+
+func (ssl *ShortSellLends) ToProto() *models_pb.ShortSellLend {
+	pLend := &models_pb.ShortSellLend{
+		Id:            ssl.Id,
+		StockId:       ssl.StockId,
+		StockQuantity: ssl.StockQuantity,
+		CreatedAt:     ssl.CreatedAt,
+		RecalledOnDay: ssl.RecalledOnDay,
+		UnpaidFee:     ssl.UnpaidFee,
+	}
+	if ssl.RecalledOnDay > 0 {
+		pLend.CoverByDay = ssl.RecalledOnDay + config.ShortSellRecallGraceDays
+	}
+	return pLend
+}
+
+// InvalidShortSellLendError is returned when a user covers a lend that isn't theirs, or is closed
+type InvalidShortSellLendError struct{}
+
+func (e InvalidShortSellLendError) Error() string {
+	return "Invalid lend. It may have been covered already."
 }
 
 func (ShortSellBank) TableName() string {
@@ -103,11 +136,19 @@ func createShortSellLend(stockId, userId, stockQuantity uint32, tx *gorm.DB) err
 
 	l.Debugf("attemting to save short sell lend of %d quantity", userId)
 
+	// the borrow fee is charged from the market day the stocks are lent on
+	var lastFeeDay uint32
+	if marketDay := GetMarketDay(); marketDay > 0 {
+		lastFeeDay = marketDay - 1
+	}
+
 	ssl := &ShortSellLends{
 		StockId:       stockId,
 		UserId:        userId,
 		StockQuantity: stockQuantity,
 		IsSquaredOff:  false,
+		CreatedAt:     utils.GetCurrentTimeISO8601(),
+		LastFeeDay:    lastFeeDay,
 	}
 
 	if err := tx.Create(ssl).Error; err != nil {
@@ -118,10 +159,334 @@ func createShortSellLend(stockId, userId, stockQuantity uint32, tx *gorm.DB) err
 	return nil
 }
 
+// getBorrowFeeBasisPoints returns the daily borrow fee of a stock whose bank has lent stocks and
+// available stocks. The fee goes up from the min to the max as more of the bank is lent out.
+func getBorrowFeeBasisPoints(lent, available uint64) uint64 {
+	minFee, maxFee := config.ShortSellMinBorrowFeeBasisPoints, config.ShortSellMaxBorrowFeeBasisPoints
+	if lent+available == 0 || maxFee <= minFee {
+		return minFee
+	}
+	return minFee + (maxFee-minFee)*lent/(lent+available)
+}
+
+// getBorrowFee returns the fee for a market day on stockQuantity lent stocks
+func getBorrowFee(stockQuantity, price, feeBasisPoints uint64) uint64 {
+	return stockQuantity * price * feeBasisPoints / 10000
+}
+
+// pickLendsToRecall returns the lends the bank recalls to bring the part of it that's lent out below
+// the recall percent. lends must be the lends that haven't been recalled, oldest first, and lent the
+// stocks lent out in them.
+func pickLendsToRecall(lends []ShortSellLends, lent, available uint64) []ShortSellLends {
+	total := lent + available
+
+	var recalled []ShortSellLends
+	for _, lend := range lends {
+		if lent*100 < config.ShortSellRecallUtilisationPercent*total {
+			break
+		}
+		recalled = append(recalled, lend)
+		lent -= uint64(lend.StockQuantity)
+	}
+	return recalled
+}
+
+// getLentStocks returns the stocks of a stock lent out in open lends
+func getLentStocks(stockId uint32) (uint64, error) {
+	db := getDB()
+
+	var lent struct {
+		StockQuantity uint64 `gorm:"column:stockQty"`
+	}
+	sql := "SELECT COALESCE(SUM(stockQuantity), 0) AS stockQty FROM ShortSellLends WHERE isSquaredOff = 0 AND stockId = ?"
+	if err := db.Raw(sql, stockId).Scan(&lent).Error; err != nil {
+		return 0, err
+	}
+
+	return lent.StockQuantity, nil
+}
+
+// GetBorrowFeeBasisPoints returns the daily borrow fee of a stock as per how much of its bank is lent out
+func GetBorrowFeeBasisPoints(stockId uint32) (uint64, error) {
+	available, err := getAvailableLendStocks(stockId)
+	if err != nil {
+		return 0, err
+	}
+	lent, err := getLentStocks(stockId)
+	if err != nil {
+		return 0, err
+	}
+	return getBorrowFeeBasisPoints(lent, uint64(available)), nil
+}
+
+// GetShortSellLends returns the open lends of a user, oldest first
+func GetShortSellLends(userId uint32) ([]ShortSellLends, error) {
+	l := logger.WithFields(logrus.Fields{
+		"method": "GetShortSellLends",
+		"userId": userId,
+	})
+
+	db := getDB()
+
+	var lends []ShortSellLends
+	if err := db.Where("userId = ? AND isSquaredOff = ?", userId, false).Order("id asc").Find(&lends).Error; err != nil {
+		l.Errorf("error fetching lends from db %+v", err)
+		return nil, err
+	}
+
+	return lends, nil
+}
+
+// closeLend returns the stocks of a lend to the bank, and takes the borrow fees left unpaid on it from
+// the user's cash. What the user can't pay is left on the squared off lend. The user must be locked.
+func closeLend(lend *ShortSellLends, user *User) (*Transaction, error) {
+	db := getDB()
+	tx := db.Begin() // begin Transaction
+
+	currentPrice := uint64(0)
+	if stock, err := GetStockCopy(lend.StockId); err == nil {
+		currentPrice = stock.CurrentPrice
+	}
+
+	shortSellTransaction := GetTransactionRef(lend.UserId, lend.StockId, ShortSellTransaction, 0, -int64(lend.StockQuantity), currentPrice, 0, 0)
+
+	fee := lend.UnpaidFee
+	if fee > user.Cash {
+		fee = user.Cash
+	}
+	feeTransaction := GetTransactionRef(lend.UserId, lend.StockId, ShortSellFeeTransaction, 0, 0, currentPrice, 0, -int64(fee))
+
+	oldCash, oldUnpaidFee := user.Cash, lend.UnpaidFee
+
+	errorHelper := func(format string, args ...interface{}) (*Transaction, error) {
+		user.Cash, lend.UnpaidFee = oldCash, oldUnpaidFee
+		lend.IsSquaredOff = false
+		tx.Rollback()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	if err := tx.Save(shortSellTransaction).Error; err != nil {
+		return errorHelper("error saving shortsell transaction %+v", err)
+	}
+
+	if fee > 0 {
+		user.Cash -= fee
+		lend.UnpaidFee -= fee
+
+		if err := tx.Save(feeTransaction).Error; err != nil {
+			return errorHelper("error saving borrow fee transaction %+v", err)
+		}
+		if err := tx.Save(user).Error; err != nil {
+			return errorHelper("error updating user's cash %+v", err)
+		}
+	}
+
+	lend.IsSquaredOff = true
+	if err := tx.Save(lend).Error; err != nil {
+		return errorHelper("error updating shortSellLends %+v", err)
+	}
+
+	// restore the stock quantity back to shortsellbank
+	if err := updateShortSellBank(lend.StockId, lend.StockQuantity, tx); err != nil {
+		return errorHelper("error updating shortsellbank %+v", err)
+	}
+
+	// commit transaction
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("error commiting the transaction %+v", err)
+	}
+
+	// Update datastream for short sell transaction
+	go func() {
+		transactionsStream := datastreamsManager.GetTransactionsStream()
+		transactionsStream.SendTransaction(shortSellTransaction.ToProto())
+		if fee > 0 {
+			transactionsStream.SendTransaction(feeTransaction.ToProto())
+		}
+	}()
+
+	return shortSellTransaction, nil
+}
+
+// CoverShortSell closes a lend of the user by returning its stocks to the bank. The user must hold
+// the stocks, unreserved, so stocks sold short have to be bought back first. Borrow fees left unpaid
+// on the lend have to be paid too.
+func CoverShortSell(userId, lendId uint32) (*Transaction, error) {
+	l := logger.WithFields(logrus.Fields{
+		"method": "CoverShortSell",
+		"userId": userId,
+		"lendId": lendId,
+	})
+
+	l.Info("Attempting")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored : %+v ", err)
+		return nil, err
+	}
+	defer close(ch)
+
+	db := getDB()
+
+	var lend ShortSellLends
+	if err := db.Where("id = ? AND userId = ? AND isSquaredOff = ?", lendId, userId, false).First(&lend).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, InvalidShortSellLendError{}
+		}
+		l.Errorf("error fetching lend from db %+v", err)
+		return nil, err
+	}
+
+	numStocks, err := getSingleStockCount(user, lend.StockId)
+	if err != nil {
+		l.Error(err)
+		return nil, err
+	}
+	if numStocks < int64(lend.StockQuantity) {
+		l.Debugf("Have %d stocks, lend is of %d", numStocks, lend.StockQuantity)
+		return nil, NotEnoughStocksError{numStocks}
+	}
+
+	if user.Cash < lend.UnpaidFee {
+		l.Debugf("Have %d cash, unpaid borrow fee is %d", user.Cash, lend.UnpaidFee)
+		return nil, NotEnoughCashError{}
+	}
+
+	transaction, err := closeLend(&lend, user)
+	if err != nil {
+		l.Error(err)
+		return nil, err
+	}
+
+	l.Infof("Covered lend of %d stocks", lend.StockQuantity)
+	return transaction, nil
+}
+
+// chargeBorrowFee charges the borrow fee of a market day on a lend, along with the fees left unpaid on it
+// before. What the user doesn't have the cash for is left unpaid on the lend, and the lend is recalled, so
+// that it's squared off if the user doesn't cover it.
+func chargeBorrowFee(lend *ShortSellLends, feeBasisPoints uint64, marketDay uint32) error {
+	ch, user, err := getUserExclusively(lend.UserId)
+	if err != nil {
+		return err
+	}
+	defer close(ch)
+
+	currentPrice := uint64(0)
+	if stock, err := GetStockCopy(lend.StockId); err == nil {
+		currentPrice = stock.CurrentPrice
+	}
+
+	owed := getBorrowFee(uint64(lend.StockQuantity), currentPrice, feeBasisPoints) + lend.UnpaidFee
+	fee := owed
+	if fee > user.Cash {
+		fee = user.Cash
+	}
+
+	oldCash, oldLastFeeDay, oldUnpaidFee, oldRecalledOnDay := user.Cash, lend.LastFeeDay, lend.UnpaidFee, lend.RecalledOnDay
+	user.Cash -= fee
+	lend.LastFeeDay = marketDay
+	lend.UnpaidFee = owed - fee
+
+	isRecalled := lend.UnpaidFee > 0 && lend.RecalledOnDay == 0
+	if isRecalled {
+		lend.RecalledOnDay = marketDay
+	}
+
+	feeTransaction := GetTransactionRef(lend.UserId, lend.StockId, ShortSellFeeTransaction, 0, 0, currentPrice, 0, -int64(fee))
+
+	db := getDB()
+	tx := db.Begin()
+
+	errorHelper := func(format string, args ...interface{}) error {
+		user.Cash, lend.LastFeeDay, lend.UnpaidFee, lend.RecalledOnDay = oldCash, oldLastFeeDay, oldUnpaidFee, oldRecalledOnDay
+		tx.Rollback()
+		return fmt.Errorf(format, args...)
+	}
+
+	if fee > 0 {
+		if err := tx.Save(feeTransaction).Error; err != nil {
+			return errorHelper("error saving borrow fee transaction %+v", err)
+		}
+		if err := tx.Save(user).Error; err != nil {
+			return errorHelper("error updating user's cash %+v", err)
+		}
+	}
+	if err := tx.Save(lend).Error; err != nil {
+		return errorHelper("error updating shortSellLends %+v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("error commiting the transaction %+v", err)
+	}
+
+	if fee > 0 {
+		go func() {
+			transactionsStream := datastreamsManager.GetTransactionsStream()
+			transactionsStream.SendTransaction(feeTransaction.ToProto())
+		}()
+	}
+
+	if isRecalled {
+		go SendNotification(lend.UserId, fmt.Sprintf("You didn't have the cash to pay the borrow fee of the %d stocks of stock %d lent to you, so they have been recalled. Cover the short sell by the end of market day %d, or it will be squared off at the current price.",
+			lend.StockQuantity, lend.StockId, marketDay+config.ShortSellRecallGraceDays), false)
+	}
+
+	return nil
+}
+
+// getUnpaidBorrowFees returns the borrow fees left unpaid on the lends of each user
+func getUnpaidBorrowFees(db *gorm.DB) (map[uint32]uint64, error) {
+	var lends []ShortSellLends
+	if err := db.Where("unpaidFee > 0").Find(&lends).Error; err != nil {
+		return nil, err
+	}
+
+	fees := make(map[uint32]uint64)
+	for _, lend := range lends {
+		fees[lend.UserId] += lend.UnpaidFee
+	}
+
+	return fees, nil
+}
+
+// recallLends recalls lends of a stock if too much of its bank has been lent out
+func recallLends(stockId, marketDay uint32) error {
+	available, err := getAvailableLendStocks(stockId)
+	if err != nil {
+		return err
+	}
+
+	db := getDB()
+
+	var lends []ShortSellLends
+	if err := db.Where("stockId = ? AND isSquaredOff = ? AND recalledOnDay = 0", stockId, false).Order("id asc").Find(&lends).Error; err != nil {
+		return err
+	}
+
+	var lent uint64
+	for _, lend := range lends {
+		lent += uint64(lend.StockQuantity)
+	}
+
+	for _, lend := range pickLendsToRecall(lends, lent, uint64(available)) {
+		lend.RecalledOnDay = marketDay
+		if err := db.Save(&lend).Error; err != nil {
+			return err
+		}
+
+		go SendNotification(lend.UserId, fmt.Sprintf("The %d stocks of stock %d lent to you have been recalled. Cover the short sell by the end of market day %d, or it will be squared off at the current price.",
+			lend.StockQuantity, lend.StockId, marketDay+config.ShortSellRecallGraceDays), false)
+	}
+
+	return nil
+}
+
 /*
-squareOffLends square off the active intra day lends
-- takes back the stocks given to the user(from stocks they owns) on that day
-- the difference in the worth is the profit/loss the user made in shorting
+SquareOffLends settles the open short sell lends at the end of a market day
+- charges the day's borrow fee on every open lend, as per how much of the stock's bank is lent out
+- squares off recalled lends that weren't covered in time, taking back the stocks from the ones the user owns
+- recalls lends of stocks whose banks are running out
 
 **must be called after market is closed**
 */
@@ -130,7 +495,9 @@ func SquareOffLends() error {
 		"method": "squareOffLends",
 	})
 
-	l.Debug("Attempting to square off active lends")
+	l.Debug("Attempting to settle active lends")
+
+	marketDay := GetMarketDay()
 
 	db := getDB()
 
@@ -141,51 +508,56 @@ func SquareOffLends() error {
 		return err
 	}
 
+	feeBasisPoints := make(map[uint32]uint64)
 	for _, lend := range shortSellActiveLends {
-		tx := db.Begin() // begin Transaction
-
-		allStocks.m[lend.StockId].RLock()
-		currentPrice := allStocks.m[lend.StockId].stock.CurrentPrice
-		allStocks.m[lend.StockId].RUnlock()
+		if _, ok := feeBasisPoints[lend.StockId]; ok {
+			continue
+		}
+		fee, err := GetBorrowFeeBasisPoints(lend.StockId)
+		if err != nil {
+			l.Errorf("error getting borrow fee of stock %d %+v", lend.StockId, err)
+			return err
+		}
+		feeBasisPoints[lend.StockId] = fee
+	}
 
-		shortSellTransaction := GetTransactionRef(lend.UserId, lend.StockId, ShortSellTransaction, 0, -int64(lend.StockQuantity), currentPrice, 0, 0)
+	for i := range shortSellActiveLends {
+		lend := &shortSellActiveLends[i]
 
-		l.Infof("Saving ShortsellTransaction, stockId : %d, stockQuantity : %d, userId : %d", lend.StockId, lend.StockQuantity, lend.UserId)
+		if lend.LastFeeDay < marketDay {
+			if err := chargeBorrowFee(lend, feeBasisPoints[lend.StockId], marketDay); err != nil {
+				l.Errorf("error charging borrow fee on lend %d %+v", lend.Id, err)
+				return err
+			}
+		}
 
-		if err := tx.Save(shortSellTransaction).Error; err != nil {
-			l.Errorf("rolling back, error saving shortsell transaction %+v", err)
-			tx.Rollback()
-			return err
+		if lend.RecalledOnDay == 0 || lend.RecalledOnDay+config.ShortSellRecallGraceDays > marketDay {
+			continue
 		}
 
-		lend.IsSquaredOff = true
-		if err := tx.Save(lend).Error; err != nil {
-			l.Errorf("rolling back, error updating shortSellLends %+v", err)
-			tx.Rollback()
+		l.Infof("Squaring off recalled lend %d, stockId : %d, stockQuantity : %d, userId : %d", lend.Id, lend.StockId, lend.StockQuantity, lend.UserId)
+
+		ch, user, err := getUserExclusively(lend.UserId)
+		if err != nil {
+			l.Errorf("error locking user %+v", err)
 			return err
 		}
-
-		// restore the stock quantity back to shortsellbank
-		if err := updateShortSellBank(lend.StockId, lend.StockQuantity, tx); err != nil {
-			l.Errorf("rolling back, error updating shortsellbank %+v", err)
-			tx.Rollback()
+		_, err = closeLend(lend, user)
+		close(ch)
+		if err != nil {
+			l.Errorf("rolling back, %+v", err)
 			return err
 		}
+	}
 
-		// commit transaction
-		if err := tx.Commit().Error; err != nil {
-			l.Errorf("error commiting the transaction %+v", err)
+	for stockId := range feeBasisPoints {
+		if err := recallLends(stockId, marketDay); err != nil {
+			l.Errorf("error recalling lends of stock %d %+v", stockId, err)
 			return err
 		}
-
-		// Update datastream for short sell transaction
-		go func() {
-			transactionsStream := datastreamsManager.GetTransactionsStream()
-			transactionsStream.SendTransaction(shortSellTransaction.ToProto())
-		}()
 	}
 
-	l.Info("squared off all the active short sell lends")
+	l.Info("settled all the active short sell lends")
 
 	return nil
 }
@@ -90,6 +90,7 @@ func Test_SaveShortSellLendTransaction(t *testing.T) {
 	if err := db.First(&savedSsl).Error; err != nil {
 		t.Fatal(err)
 	}
+	expectedSsl.CreatedAt = savedSsl.CreatedAt
 
 	if !testutils.AssertEqual(t, expectedSsl, savedSsl) {
 		t.Fatalf("Expected %+v but got %+v", expectedSsl, savedSsl)
@@ -174,29 +175,205 @@ func Test_SquareOffLends(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// lends that weren't recalled are carried over to the next market day
+	lends, err := GetShortSellLends(user.Id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lends) != len(testCases) {
+		t.Fatalf("expected %d open lends got %d", len(testCases), len(lends))
+	}
+
+	for _, lend := range lends {
+		if lend.RecalledOnDay != 0 {
+			t.Fatalf("expected lend %d to not be recalled", lend.Id)
+		}
+	}
+
 	stockOwned, err := GetStocksOwned(user.Id)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if stockOwned[stocks[0].Id] != -20 {
-		t.Fatalf("expected -20 got %d", stockOwned[stocks[0].Id])
+	if stockOwned[stocks[0].Id] != 0 {
+		t.Fatalf("expected 0 got %d", stockOwned[stocks[0].Id])
 	}
 
-	if stockOwned[stocks[1].Id] != -10 {
-		t.Fatalf("expected -10 got %d", stockOwned[stocks[1].Id])
+	if stockOwned[stocks[1].Id] != 0 {
+		t.Fatalf("expected 0 got %d", stockOwned[stocks[1].Id])
 	}
 
-	for _, ssb := range ssbs {
+	for i, ssb := range ssbs {
 		availableStocks, err := getAvailableLendStocks(ssb.StockId)
 
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if availableStocks != 100 {
-			t.Fatalf("expected 100 got %d", availableStocks)
+		if expected := []uint32{80, 90}[i]; availableStocks != expected {
+			t.Fatalf("expected %d got %d", expected, availableStocks)
+		}
+	}
+}
+
+func Test_UnpaidBorrowFee(t *testing.T) {
+	user := &User{Id: 2, Cash: 30}
+	stock := &Stock{Id: 1, CurrentPrice: 1000}
+	ssb := &ShortSellBank{StockId: 1, AvailableStocks: 90}
+	lend := &ShortSellLends{StockId: 1, UserId: 2, StockQuantity: 10}
+
+	db := getDB()
+
+	defer func() {
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM ShortSellLends")
+		db.Exec("DELETE FROM ShortSellBank")
+		db.Delete(user)
+		db.Delete(stock)
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+	if err := db.Create(ssb).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(lend).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	marketDay := GetMarketDay()
+
+	// the fee is 100, and the user only has 30 for it
+	if err := chargeBorrowFee(lend, 100, marketDay); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved ShortSellLends
+	if err := db.First(&saved, lend.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.UnpaidFee != 70 || saved.RecalledOnDay != marketDay {
+		t.Fatalf("Expected 70 unpaid on a lend recalled on day %d, got %d unpaid on a lend recalled on day %d", marketDay, saved.UnpaidFee, saved.RecalledOnDay)
+	}
+
+	fees, err := getUnpaidBorrowFees(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fees[user.Id] != 70 {
+		t.Fatalf("Expected 70 unpaid borrow fees for the user, got %d", fees[user.Id])
+	}
+
+	// the lend is squared off with 50 cash, so 20 stays unpaid
+	ch, lockedUser, err := getUserExclusively(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedUser.Cash = 50
+	_, err = closeLend(lend, lockedUser)
+	close(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.First(&saved, lend.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !saved.IsSquaredOff || saved.UnpaidFee != 20 {
+		t.Fatalf("Expected the lend to be squared off with 20 unpaid, got %+v", saved)
+	}
+
+	var savedUser User
+	if err := db.First(&savedUser, user.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if savedUser.Cash != 0 {
+		t.Fatalf("Expected the user to be left with 0 cash, got %d", savedUser.Cash)
+	}
+
+	var totals []int64
+	if err := db.Table("Transactions").Where("userId = ? AND type = ?", user.Id, ShortSellFeeTransaction.String()).Order("id").Pluck("total", &totals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || totals[0] != -30 || totals[1] != -50 {
+		t.Fatalf("Expected borrow fee transactions of -30 and -50, got %v", totals)
+	}
+}
+
+func Test_GetBorrowFeeBasisPoints(t *testing.T) {
+	oldMinFee, oldMaxFee := config.ShortSellMinBorrowFeeBasisPoints, config.ShortSellMaxBorrowFeeBasisPoints
+	defer func() {
+		config.ShortSellMinBorrowFeeBasisPoints, config.ShortSellMaxBorrowFeeBasisPoints = oldMinFee, oldMaxFee
+	}()
+
+	config.ShortSellMinBorrowFeeBasisPoints, config.ShortSellMaxBorrowFeeBasisPoints = 10, 100
+
+	var tests = []struct {
+		lent      uint64
+		available uint64
+		fee       uint64
+	}{
+		{0, 0, 10},
+		{0, 100, 10},
+		{50, 50, 55},
+		{90, 10, 91},
+		{100, 0, 100},
+	}
+
+	for _, test := range tests {
+		if fee := getBorrowFeeBasisPoints(test.lent, test.available); fee != test.fee {
+			t.Fatalf("getBorrowFeeBasisPoints(%d, %d) = %d, expected %d", test.lent, test.available, fee, test.fee)
+		}
+	}
+
+	if fee := getBorrowFee(20, 500, 55); fee != 55 {
+		t.Fatalf("getBorrowFee(20, 500, 55) = %d, expected 55", fee)
+	}
+}
+
+func Test_PickLendsToRecall(t *testing.T) {
+	oldRecallPercent := config.ShortSellRecallUtilisationPercent
+	defer func() {
+		config.ShortSellRecallUtilisationPercent = oldRecallPercent
+	}()
+
+	config.ShortSellRecallUtilisationPercent = 90
+
+	lends := []ShortSellLends{
+		{Id: 1, StockQuantity: 5},
+		{Id: 2, StockQuantity: 10},
+		{Id: 3, StockQuantity: 80},
+	}
+
+	var tests = []struct {
+		available uint64
+		recalled  []uint32
+	}{
+		{100, nil},
+		{11, nil},
+		// 95 of 105 lent is over 90%. Recalling the oldest lend brings it to 90 of 105
+		{10, []uint32{1}},
+		{0, []uint32{1, 2}},
+	}
+
+	for _, test := range tests {
+		recalled := pickLendsToRecall(lends, 95, test.available)
+		if len(recalled) != len(test.recalled) {
+			t.Fatalf("pickLendsToRecall(available %d) recalled %+v, expected lends %v", test.available, recalled, test.recalled)
+		}
+		for i, lend := range recalled {
+			if lend.Id != test.recalled[i] {
+				t.Fatalf("pickLendsToRecall(available %d) recalled %+v, expected lends %v", test.available, recalled, test.recalled)
+			}
 		}
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"time"
 
 	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
 	models_pb "github.com/delta/dalal-street-server/proto_build/models"
@@ -38,6 +39,13 @@ type Stock struct {
 	UpdatedAt        string  `gorm:"column:updatedAt;not null" json:"updated_at"`
 	GivesDividends   bool    `gorm:"column:givesDividends;not null" json:"gives_dividends"`
 	IsBankrupt       bool    `gorm:"column:isBankrupt;not null" json:"is_bankrupt"`
+	// IsDelisted is true once the stock stops trading for good, like after it's merged into another stock
+	IsDelisted bool `gorm:"column:isDelisted;not null" json:"is_delisted"`
+
+	SelfTradePrevention SelfTradePrevention `gorm:"column:selfTradePrevention;not null" json:"self_trade_prevention"`
+	// Fees of the stock in basis points. -1 makes the stock use the fees in the config
+	MakerFeeBasisPoints int64 `gorm:"column:makerFeeBasisPoints;not null" json:"maker_fee_basis_points"`
+	TakerFeeBasisPoints int64 `gorm:"column:takerFeeBasisPoints;not null" json:"taker_fee_basis_points"`
 
 	// HACK: Getting last minute's hl from transactions used by stock history
 	open   uint64 // Used to store Open for the last minute
@@ -71,6 +79,11 @@ func (gStock *Stock) ToProto() *models_pb.Stock {
 		UpdatedAt:        gStock.UpdatedAt,
 		GivesDividends:   gStock.GivesDividends,
 		IsBankrupt:       gStock.IsBankrupt,
+		IsDelisted:       gStock.IsDelisted,
+
+		SelfTradePrevention: gStock.SelfTradePrevention.ToProto(),
+		MakerFeeBasisPoints: gStock.MakerFeeBasisPoints,
+		TakerFeeBasisPoints: gStock.TakerFeeBasisPoints,
 	}
 }
 
@@ -127,6 +140,17 @@ func GetAllStocks() map[uint32]*Stock {
 	return allStocksCopy
 }
 
+// GetListedStocks returns copies of the stocks that haven't been delisted
+func GetListedStocks() map[uint32]*Stock {
+	listedStocks := GetAllStocks()
+	for stockId, stock := range listedStocks {
+		if stock.IsDelisted {
+			delete(listedStocks, stockId)
+		}
+	}
+	return listedStocks
+}
+
 func UpdateStockPrice(stockId uint32, price uint64, quantity uint64) error {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "UpdateStockPrice",
@@ -216,6 +240,105 @@ func UpdateStockVolume(stockId uint32, volume uint64) {
 	allStocks.Unlock()
 }
 
+// SetAuctionPrice sets the price of a stock to the price at which its order book uncrossed in a
+// call auction. The auction is recorded as a one minute interval of its own in the stock history,
+// and the next interval opens at the auction price.
+func SetAuctionPrice(stockId uint32, price uint64, volume uint64) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "SetAuctionPrice",
+		"param_stockId": stockId,
+		"param_price":   price,
+		"param_volume":  volume,
+	})
+
+	l.Infof("Attempting")
+
+	allStocks.Lock()
+	stockNLock, ok := allStocks.m[stockId]
+	if !ok {
+		allStocks.Unlock()
+		return fmt.Errorf("Not found stock for id %d", stockId)
+	}
+	allStocks.Unlock()
+
+	stockNLock.Lock()
+	defer stockNLock.Unlock()
+
+	stock := stockNLock.stock
+	oldStockCopy := *stock
+
+	stock.CurrentPrice = price
+	stock.LastTradePrice = price
+	stock.RealAvgPrice = float64(price)
+	stock.UpdatedAt = utils.GetCurrentTimeISO8601()
+
+	if price > stock.DayHigh {
+		stock.DayHigh = price
+	} else if price < stock.DayLow {
+		stock.DayLow = price
+	}
+
+	if price > stock.AllTimeHigh {
+		stock.AllTimeHigh = price
+	} else if price < stock.AllTimeLow {
+		stock.AllTimeLow = price
+	}
+
+	stock.UpOrDown = price > stock.PreviousDayClose
+
+	// the trades of the auction have been added to the volume already. They go into the auction's interval
+	stock.open = price
+	stock.high = price
+	stock.low = price
+	stock.volume = 0
+
+	stkHistoryPoint := &StockHistory{
+		StockId:   stockId,
+		Close:     price,
+		Interval:  1,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Volume:    volume,
+	}
+
+	db := getDB()
+	tx := db.Begin()
+
+	if err := tx.Save(stock).Error; err != nil {
+		l.Errorf("Error saving stock: %+v", err)
+		tx.Rollback()
+		*stock = oldStockCopy
+		return err
+	}
+
+	if err := tx.Save(stkHistoryPoint).Error; err != nil {
+		l.Errorf("Error registering stock history point %+v. Error: %+v", stkHistoryPoint, err)
+		tx.Rollback()
+		*stock = oldStockCopy
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error committing transaction: %+v", err)
+		*stock = oldStockCopy
+		return err
+	}
+
+	avgLastPrice.Lock()
+	avgLastPrice.m[stockId] = float64(price)
+	avgLastPrice.Unlock()
+
+	stockPriceStream := datastreamsManager.GetStockPricesStream()
+	stockPriceStream.SendStockPriceUpdate(stockId, price)
+	go stockHistoryStreamUpdate(stockId, stkHistoryPoint)
+
+	l.Infof("Done")
+
+	return nil
+}
+
 func LoadStocks() error {
 	var l = logger.WithFields(logrus.Fields{
 		"method": "loadStocks",
@@ -230,8 +353,22 @@ func LoadStocks() error {
 		return err
 	}
 
+	// stocks that got listed show up here, so the market index has to make up for them
+	change := startMarketIndexChange()
+	defer change.end()
+
 	allStocks.Lock()
 	avgLastPrice.Lock()
+	if len(allStocks.m) > 0 {
+		for stockId, stockNLock := range allStocks.m {
+			change.changed(stockId, stockNLock.stock)
+		}
+		for _, stock := range stocks {
+			if _, ok := allStocks.m[stock.Id]; !ok {
+				change.changed(stock.Id, nil)
+			}
+		}
+	}
 	allStocks.m = make(map[uint32]*stockAndLock)
 	avgLastPrice.m = make(map[uint32]float64)
 
@@ -447,9 +584,13 @@ func SetBankruptcy(stockId uint32, isBankrupt bool) error {
 
 	l.Infof("Attempting to setBankruptcy")
 
+	change := startMarketIndexChange()
+	defer change.end()
+
 	allStocks.Lock()
 	stockNLock, ok := allStocks.m[stockId]
 	if !ok {
+		allStocks.Unlock()
 		return InvalidStockError
 	}
 	defer allStocks.Unlock()
@@ -460,6 +601,11 @@ func SetBankruptcy(stockId uint32, isBankrupt bool) error {
 	stock := stockNLock.stock
 	oldStockCopy := *stock
 
+	// a delisted stock has been wound up for good
+	if stock.IsDelisted {
+		return InvalidStockError
+	}
+
 	stock.IsBankrupt = isBankrupt
 	stock.CurrentPrice = 0
 
@@ -470,6 +616,8 @@ func SetBankruptcy(stockId uint32, isBankrupt bool) error {
 		return err
 	}
 
+	change.changed(stockId, &oldStockCopy)
+
 	stockPriceStream := datastreamsManager.GetStockPricesStream()
 	stockPriceStream.SendStockPriceUpdate(stockId, stock.CurrentPrice)
 
@@ -499,3 +647,11 @@ func IsStockBankrupt(stockId uint32) bool {
 	allStocks.m[stockId].RUnlock()
 	return isBankrupt
 }
+
+// IsStockDelisted checks if a stock has been delisted, and can't be traded anymore
+func IsStockDelisted(stockId uint32) bool {
+	allStocks.m[stockId].RLock()
+	isDelisted := allStocks.m[stockId].stock.IsDelisted
+	allStocks.m[stockId].RUnlock()
+	return isDelisted
+}
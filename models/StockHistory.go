@@ -113,6 +113,12 @@ func recordOneMinuteOHLC(db *gorm.DB, recordingTime time.Time) error {
 	for stockId := range allStocks.m {
 		allStocks.m[stockId].Lock()
 
+		// the history of a delisted stock stays as it was for charts
+		if allStocks.m[stockId].stock.IsDelisted {
+			allStocks.m[stockId].Unlock()
+			continue
+		}
+
 		currentMinuteOHLCV := &ohlcv{
 			allStocks.m[stockId].stock.open,
 			allStocks.m[stockId].stock.high,
@@ -242,6 +248,13 @@ func recorderHigherIntervalOHLCs(db *gorm.DB, recordingTime time.Time) error {
 	defer allStocks.RUnlock()
 
 	for stockId := range allStocks.m {
+		allStocks.m[stockId].RLock()
+		isDelisted := allStocks.m[stockId].stock.IsDelisted
+		allStocks.m[stockId].RUnlock()
+		if isDelisted {
+			continue
+		}
+
 		var retrievedHistories []StockHistory
 		// Need to do this because db = db. chains the wheres
 		dbCurrStock := db.Where("intervalRecord = ? AND stockId = ? AND createdAt >= ?", 1, stockId, maxTimeRangeStr)
@@ -334,6 +347,11 @@ loop:
 				l.Errorf("Error recording one higher minute intervals %+v", err)
 			}
 
+			// record the market index's intervals
+			if err := recordMarketIndexOHLC(db, currentTime); err != nil {
+				l.Errorf("Error recording market index intervals %+v", err)
+			}
+
 			l.Info("Recorded history")
 		}
 	}
@@ -152,7 +152,7 @@ func Test_VolumeRecording(t *testing.T) {
 	defer db.Delete(ask)
 	PlaceBidOrder(bid.UserId, bid)
 	defer db.Delete(bid)
-	PerformOrderFillTransaction(ask, bid, 5, 6)
+	PerformOrderFillTransaction(ask, bid, 5, 6, true, false)
 	defer db.Exec("Delete FROM Transactions")
 	defer db.Exec("DELETE FROM OrderFills")
 	defer db.Exec("DELETE FROM TransactionSummary")
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/sirupsen/logrus"
+)
+
+// TaxBracket is a rate of tax on the part of a gain that takes the user's net worth above LowerLimit
+type TaxBracket struct {
+	Id         uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	LowerLimit int64  `gorm:"column:lowerLimit;not null" json:"lower_limit"`
+	TaxPercent uint64 `gorm:"column:taxPercent;not null" json:"tax_percent"`
+}
+
+func (TaxBracket) TableName() string {
+	return "TaxBrackets"
+}
+
+func (tb *TaxBracket) ToProto() *models_pb.TaxBracket {
+	return &models_pb.TaxBracket{
+		LowerLimit: tb.LowerLimit,
+		TaxPercent: tb.TaxPercent,
+	}
+}
+
+// InvalidTaxBracketsError is returned when the brackets being set don't make sense
+type InvalidTaxBracketsError struct {
+	reason string
+}
+
+func (e InvalidTaxBracketsError) Error() string {
+	return fmt.Sprintf("Invalid tax brackets. %s", e.reason)
+}
+
+// defaultTaxBrackets are used till others are set. Gains that keep the net worth at or below 0 aren't taxed.
+//
+// 0 < Net worth <= 100000 => tax percentage = 2%
+// 100000 < Net worth <= 500000 => tax percentage = 5%
+// 500000 < Net worth <= 1000000 => tax percentage = 9%
+// 1000000 < Net worth <= 2000000 => tax percentage = 15%
+// Net worth > 2000000 => tax percentage = 25%
+var defaultTaxBrackets = []TaxBracket{
+	{LowerLimit: 0, TaxPercent: 2},
+	{LowerLimit: 100000, TaxPercent: 5},
+	{LowerLimit: 500000, TaxPercent: 9},
+	{LowerLimit: 1000000, TaxPercent: 15},
+	{LowerLimit: 2000000, TaxPercent: 25},
+}
+
+// taxBrackets has the brackets in use, by increasing LowerLimit
+var taxBrackets = struct {
+	sync.RWMutex
+	b []TaxBracket
+}{
+	b: defaultTaxBrackets,
+}
+
+// loadTaxBrackets loads the brackets from the database. The default ones stay if there are none.
+func loadTaxBrackets() error {
+	var l = logger.WithFields(logrus.Fields{
+		"method": "loadTaxBrackets",
+	})
+
+	var brackets []TaxBracket
+	db := getDB()
+	if err := db.Order("lowerLimit asc").Find(&brackets).Error; err != nil {
+		l.Errorf("Error loading tax brackets: %+v", err)
+		return err
+	}
+
+	if len(brackets) == 0 {
+		l.Infof("No tax brackets set. Using the default ones")
+		return nil
+	}
+
+	taxBrackets.Lock()
+	taxBrackets.b = brackets
+	taxBrackets.Unlock()
+
+	l.Infof("Loaded %d tax brackets", len(brackets))
+	return nil
+}
+
+// GetTaxBrackets returns the brackets in use, by increasing LowerLimit
+func GetTaxBrackets() []TaxBracket {
+	taxBrackets.RLock()
+	defer taxBrackets.RUnlock()
+	return append([]TaxBracket(nil), taxBrackets.b...)
+}
+
+// SetTaxBrackets replaces the brackets in use. Gains are taxed as per the new ones from the next trade on.
+func SetTaxBrackets(brackets []TaxBracket) error {
+	var l = logger.WithFields(logrus.Fields{
+		"method":         "SetTaxBrackets",
+		"param_brackets": fmt.Sprintf("%+v", brackets),
+	})
+
+	l.Infof("Attempting")
+
+	if len(brackets) == 0 {
+		return InvalidTaxBracketsError{"At least one bracket is needed."}
+	}
+
+	brackets = append([]TaxBracket(nil), brackets...)
+	sort.Slice(brackets, func(i, j int) bool { return brackets[i].LowerLimit < brackets[j].LowerLimit })
+
+	for i := range brackets {
+		brackets[i].Id = 0
+		if brackets[i].TaxPercent > 100 {
+			return InvalidTaxBracketsError{"Tax can't be more than 100%."}
+		}
+		if i > 0 && brackets[i].LowerLimit == brackets[i-1].LowerLimit {
+			return InvalidTaxBracketsError{fmt.Sprintf("There are two brackets from %d.", brackets[i].LowerLimit)}
+		}
+	}
+
+	// other users mustn't be taxed as per the old brackets after the new ones are saved
+	taxBrackets.Lock()
+	defer taxBrackets.Unlock()
+
+	db := getDB()
+	tx := db.Begin()
+
+	if err := tx.Delete(TaxBracket{}).Error; err != nil {
+		l.Errorf("Error deleting old tax brackets: %+v", err)
+		tx.Rollback()
+		return err
+	}
+
+	for i := range brackets {
+		if err := tx.Create(&brackets[i]).Error; err != nil {
+			l.Errorf("Error saving tax bracket %+v: %+v", brackets[i], err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		l.Errorf("Error committing: %+v", err)
+		return err
+	}
+
+	taxBrackets.b = brackets
+
+	l.Infof("Done")
+	return nil
+}
+
+// getTaxBracket returns the bracket the user's net worth is in, i.e. the rate the next rupee of gain is taxed at.
+// nil is returned if the net worth is below every bracket.
+func getTaxBracket(netCash int64) *TaxBracket {
+	taxBrackets.RLock()
+	defer taxBrackets.RUnlock()
+
+	var bracket *TaxBracket
+	for i := range taxBrackets.b {
+		if netCash > taxBrackets.b[i].LowerLimit {
+			b := taxBrackets.b[i]
+			bracket = &b
+		}
+	}
+	return bracket
+}
+
+// Helper function to determine what percentage the next rupee of the user's gain should be taxed
+func getTaxPercent(netCash int64) uint64 {
+	if bracket := getTaxBracket(netCash); bracket != nil {
+		return bracket.TaxPercent
+	}
+	return 0
+}
+
+// getTax returns the tax on a gain of a user with net worth netCash. The gain is taxed marginally,
+// as if it took the net worth from netCash to netCash+gain. Every part of it is taxed at the rate
+// of the bracket that part falls in.
+func getTax(netCash int64, gain float64) uint64 {
+	if gain <= 0 {
+		return 0
+	}
+
+	taxBrackets.RLock()
+	defer taxBrackets.RUnlock()
+
+	from, to := float64(netCash), float64(netCash)+gain
+
+	var tax float64
+	for i, bracket := range taxBrackets.b {
+		lower := float64(bracket.LowerLimit)
+		upper := math.Inf(1)
+		if i+1 < len(taxBrackets.b) {
+			upper = float64(taxBrackets.b[i+1].LowerLimit)
+		}
+
+		taxed := math.Min(to, upper) - math.Max(from, lower)
+		if taxed > 0 {
+			tax += taxed * float64(bracket.TaxPercent) / 100
+		}
+	}
+
+	return uint64(tax)
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetTax(t *testing.T) {
+	var tests = []struct {
+		netCash int64
+		gain    float64
+		tax     uint64
+	}{
+		{-50000, 10000, 0},
+		{-5000, 10000, 100},
+		{0, 50000, 1000},
+		{50000, 50000, 1000},
+		// only the part above 100000 is taxed at 5%
+		{90000, 20000, 200 + 500},
+		{1900000, 200000, 15000 + 25000},
+		{2500000, 1000, 250},
+		{100000, 0, 0},
+		{100000, -500, 0},
+	}
+
+	for _, test := range tests {
+		if tax := getTax(test.netCash, test.gain); tax != test.tax {
+			t.Errorf("getTax(%d, %v) = %d, expected %d", test.netCash, test.gain, tax, test.tax)
+		}
+	}
+}
+
+func TestGetTaxBracket(t *testing.T) {
+	if bracket := getTaxBracket(0); bracket != nil {
+		t.Errorf("getTaxBracket(0) = %+v, expected nil", bracket)
+	}
+	if bracket := getTaxBracket(100001); bracket == nil || bracket.LowerLimit != 100000 || bracket.TaxPercent != 5 {
+		t.Errorf("getTaxBracket(100001) = %+v, expected the 5%% bracket from 100000", bracket)
+	}
+}
+
+func TestSetTaxBracketsValidation(t *testing.T) {
+	var tests = [][]TaxBracket{
+		nil,
+		{{LowerLimit: 0, TaxPercent: 101}},
+		{{LowerLimit: 1000, TaxPercent: 5}, {LowerLimit: 0, TaxPercent: 2}, {LowerLimit: 1000, TaxPercent: 9}},
+	}
+
+	for _, brackets := range tests {
+		if err := SetTaxBrackets(brackets); err == nil {
+			t.Errorf("SetTaxBrackets(%+v) succeeded", brackets)
+		} else if _, ok := err.(InvalidTaxBracketsError); !ok {
+			t.Errorf("SetTaxBrackets(%+v) = %+v, expected InvalidTaxBracketsError", brackets, err)
+		}
+	}
+
+	if len(GetTaxBrackets()) != len(defaultTaxBrackets) {
+		t.Errorf("Invalid brackets replaced the ones in use")
+	}
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/delta/dalal-street-server/utils"
+)
+
+// TaxRecord is a gain or a loss a user realized on a trade, and the tax paid on it. It's noted down when
+// the trade is settled, so it keeps the position the gain was realized on as it was then.
+type TaxRecord struct {
+	Id      uint32 `gorm:"primary_key;AUTO_INCREMENT" json:"id"`
+	UserId  uint32 `gorm:"column:userId;not null" json:"user_id"`
+	StockId uint32 `gorm:"column:stockId;not null" json:"stock_id"`
+	// TransactionId is the trade the gain was realized in
+	TransactionId uint32 `gorm:"column:transactionId;not null" json:"transaction_id"`
+	// TaxTransactionId is the TaxTransaction the tax was paid in. 0 if no tax was paid
+	TaxTransactionId uint32 `gorm:"column:taxTransactionId;not null" json:"tax_transaction_id"`
+	// StockQuantity is how much of the position was closed. Negative for a short position
+	StockQuantity int64   `gorm:"column:stockQuantity;not null" json:"stock_quantity"`
+	AveragePrice  float64 `gorm:"column:price;not null" json:"average_price"`
+	RealizedGain  float64 `gorm:"column:realizedGain;not null" json:"realized_gain"`
+	Tax           uint64  `gorm:"column:tax;not null" json:"tax"`
+	CreatedAt     string  `gorm:"column:createdAt;not null" json:"created_at"`
+}
+
+func (TaxRecord) TableName() string {
+	return "TaxRecords"
+}
+
+// getTaxRecordRef returns a TaxRecord for a gain realized by closing stockQuantity of a position held at averagePrice
+func getTaxRecordRef(userId, stockId uint32, stockQuantity int64, averagePrice, realizedGain float64, tax uint64) *TaxRecord {
+	return &TaxRecord{
+		UserId:        userId,
+		StockId:       stockId,
+		StockQuantity: stockQuantity,
+		AveragePrice:  averagePrice,
+		RealizedGain:  realizedGain,
+		Tax:           tax,
+		CreatedAt:     utils.GetCurrentTimeISO8601(),
+	}
+}
+
+// saveTaxRecord saves a gain realized in the trade transaction, which must have been saved already. The
+// TaxTransaction for the tax paid on the gain is saved along with it, and returned. It's nil if no tax was
+// paid. Nothing is saved for a nil record.
+func saveTaxRecord(tx *gorm.DB, record *TaxRecord, transaction *Transaction) (*Transaction, error) {
+	if record == nil {
+		return nil, nil
+	}
+
+	var taxTransaction *Transaction
+	if record.Tax > 0 {
+		taxTransaction = GetTransactionRef(record.UserId, record.StockId, TaxTransaction, 0, 0, 0, 0, -int64(record.Tax))
+		if err := tx.Save(taxTransaction).Error; err != nil {
+			return nil, err
+		}
+		record.TaxTransactionId = taxTransaction.Id
+	}
+
+	record.TransactionId = transaction.Id
+	if err := tx.Save(record).Error; err != nil {
+		return nil, err
+	}
+
+	return taxTransaction, nil
+}
+
+// TaxReportStock has the gains the user has realized on a stock
+type TaxReportStock struct {
+	StockId       uint32  `gorm:"column:stockId" json:"stock_id"`
+	StockQuantity int64   `gorm:"column:stockQuantity" json:"stock_quantity"`
+	AveragePrice  float64 `gorm:"column:price" json:"average_price"`
+	RealizedGain  float64 `gorm:"column:realizedGain" json:"realized_gain"`
+}
+
+func (s *TaxReportStock) ToProto() *models_pb.TaxReportStock {
+	return &models_pb.TaxReportStock{
+		StockId:       s.StockId,
+		StockQuantity: s.StockQuantity,
+		AveragePrice:  s.AveragePrice,
+		RealizedGain:  s.RealizedGain,
+	}
+}
+
+// TaxReportEntry is a gain the user realized on a trade and the tax paid on it, along with the position
+// it was realized on, as they were when the trade was settled
+type TaxReportEntry struct {
+	TransactionId uint32  `gorm:"column:transactionId" json:"transaction_id"`
+	StockId       uint32  `gorm:"column:stockId" json:"stock_id"`
+	Tax           uint64  `gorm:"column:tax" json:"tax"`
+	CreatedAt     string  `gorm:"column:createdAt" json:"created_at"`
+	StockQuantity int64   `gorm:"column:stockQuantity" json:"stock_quantity"`
+	AveragePrice  float64 `gorm:"column:price" json:"average_price"`
+	RealizedGain  float64 `gorm:"column:realizedGain" json:"realized_gain"`
+}
+
+func (e *TaxReportEntry) ToProto() *models_pb.TaxReportEntry {
+	return &models_pb.TaxReportEntry{
+		TransactionId: e.TransactionId,
+		StockId:       e.StockId,
+		Tax:           e.Tax,
+		CreatedAt:     e.CreatedAt,
+		StockQuantity: e.StockQuantity,
+		AveragePrice:  e.AveragePrice,
+		RealizedGain:  e.RealizedGain,
+	}
+}
+
+// TaxReport breaks down the gains a user has realized and the tax paid on them
+type TaxReport struct {
+	NetWorth int64
+	// Bracket the next gain of the user starts getting taxed in. nil if gains aren't taxed yet
+	CurrentBracket    *TaxBracket
+	Brackets          []TaxBracket
+	TotalRealizedGain float64
+	TotalTaxPaid      uint64
+	Stocks            []*TaxReportStock
+	Taxes             []*TaxReportEntry
+}
+
+func (r *TaxReport) ToProto() *models_pb.TaxReport {
+	pReport := &models_pb.TaxReport{
+		NetWorth:          r.NetWorth,
+		TotalRealizedGain: r.TotalRealizedGain,
+		TotalTaxPaid:      r.TotalTaxPaid,
+	}
+
+	if r.CurrentBracket != nil {
+		pReport.CurrentBracket = r.CurrentBracket.ToProto()
+	}
+	for i := range r.Brackets {
+		pReport.Brackets = append(pReport.Brackets, r.Brackets[i].ToProto())
+	}
+	for _, stock := range r.Stocks {
+		pReport.Stocks = append(pReport.Stocks, stock.ToProto())
+	}
+	for _, entry := range r.Taxes {
+		pReport.Taxes = append(pReport.Taxes, entry.ToProto())
+	}
+
+	return pReport
+}
+
+// GetTaxReport returns the tax report of a user
+func GetTaxReport(userId uint32) (*TaxReport, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":       "GetTaxReport",
+		"param_userId": userId,
+	})
+
+	l.Infof("Attempting")
+
+	user, err := GetUserCopy(userId)
+	if err != nil {
+		l.Errorf("Unable to get user: %+v", err)
+		return nil, err
+	}
+
+	report := &TaxReport{
+		NetWorth:       user.Total,
+		CurrentBracket: getTaxBracket(user.Total),
+		Brackets:       GetTaxBrackets(),
+	}
+
+	db := getDB()
+
+	if err := db.Raw("SELECT stockId, stockQuantity, price, realizedGain FROM TransactionSummary WHERE userId = ? ORDER BY stockId", userId).Scan(&report.Stocks).Error; err != nil {
+		l.Errorf("Unable to get transaction summary: %+v", err)
+		return nil, err
+	}
+
+	query := "SELECT transactionId, stockId, tax, createdAt, stockQuantity, price, realizedGain FROM TaxRecords WHERE userId = ? ORDER BY id DESC"
+	if err := db.Raw(query, userId).Scan(&report.Taxes).Error; err != nil {
+		l.Errorf("Unable to get tax records: %+v", err)
+		return nil, err
+	}
+
+	for _, stock := range report.Stocks {
+		report.TotalRealizedGain += stock.RealizedGain
+	}
+	for _, entry := range report.Taxes {
+		report.TotalTaxPaid += entry.Tax
+	}
+
+	l.Infof("Done")
+	return report, nil
+}
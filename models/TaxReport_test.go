@@ -0,0 +1,104 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func Test_GetTaxReport(t *testing.T) {
+	user := &User{Id: 2, Cash: 1000, Total: 5000}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM TaxRecords")
+		db.Exec("DELETE FROM TransactionSummary")
+		db.Exec("DELETE FROM Transactions")
+		db.Delete(user)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := []*TransactionSummary{
+		{UserId: 2, StockId: 2, StockQuantity: -3, Price: 50, RealizedGain: -20},
+		{UserId: 2, StockId: 1, StockQuantity: 5, Price: 100, RealizedGain: 150},
+	}
+	for _, summary := range summaries {
+		if err := db.Create(summary).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a gain of 150 taxed 15 on stock 1, and a loss of 20 on stock 2 that isn't taxed
+	records := []*TaxRecord{
+		getTaxRecordRef(2, 1, 5, 100, 150, 15),
+		getTaxRecordRef(2, 2, -3, 50, -20, 0),
+	}
+	var trades []*Transaction
+	var taxTransactions []*Transaction
+	for _, record := range records {
+		tx := db.Begin()
+		trade := GetTransactionRef(2, record.StockId, OrderFillTransaction, 0, -record.StockQuantity, 130, 0, 0)
+		if err := tx.Save(trade).Error; err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		taxTransaction, err := saveTaxRecord(tx, record, trade)
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			t.Fatal(err)
+		}
+		trades = append(trades, trade)
+		taxTransactions = append(taxTransactions, taxTransaction)
+	}
+
+	// the tax is paid through a TaxTransaction linked to the record
+	if taxTransactions[0] == nil || taxTransactions[1] != nil {
+		t.Fatalf("Expected a tax transaction only for the taxed gain, got %+v", taxTransactions)
+	}
+	testutils.AssertEqual(t, TaxTransaction, taxTransactions[0].Type)
+	testutils.AssertEqual(t, int64(-15), taxTransactions[0].Total)
+	testutils.AssertEqual(t, taxTransactions[0].Id, records[0].TaxTransactionId)
+	testutils.AssertEqual(t, uint32(0), records[1].TaxTransactionId)
+
+	// nothing is saved for a trade that didn't realize a gain
+	if taxTransaction, err := saveTaxRecord(db, nil, trades[0]); taxTransaction != nil || err != nil {
+		t.Fatalf("Expected nothing to be saved for a nil record, got (%+v, %+v)", taxTransaction, err)
+	}
+
+	report, err := GetTaxReport(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutils.AssertEqual(t, int64(5000), report.NetWorth)
+	testutils.AssertEqual(t, 130.0, report.TotalRealizedGain)
+	testutils.AssertEqual(t, uint64(15), report.TotalTaxPaid)
+
+	expectedStocks := []*TaxReportStock{
+		{StockId: 1, StockQuantity: 5, AveragePrice: 100, RealizedGain: 150},
+		{StockId: 2, StockQuantity: -3, AveragePrice: 50, RealizedGain: -20},
+	}
+	testutils.AssertEqual(t, expectedStocks, report.Stocks)
+
+	// the latest records come first
+	if len(report.Taxes) != 2 {
+		t.Fatalf("Expected 2 tax records, got %+v", report.Taxes)
+	}
+	for i, record := range []*TaxRecord{records[1], records[0]} {
+		entry := report.Taxes[i]
+		testutils.AssertEqual(t, record.TransactionId, entry.TransactionId)
+		testutils.AssertEqual(t, record.StockId, entry.StockId)
+		testutils.AssertEqual(t, record.Tax, entry.Tax)
+		testutils.AssertEqual(t, record.StockQuantity, entry.StockQuantity)
+		testutils.AssertEqual(t, record.AveragePrice, entry.AveragePrice)
+		testutils.AssertEqual(t, record.RealizedGain, entry.RealizedGain)
+	}
+}
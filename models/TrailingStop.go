@@ -0,0 +1,146 @@
+package models
+
+import (
+	"fmt"
+
+	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
+	models_pb "github.com/delta/dalal-street-server/proto_build/models"
+	"github.com/sirupsen/logrus"
+
+	"github.com/delta/dalal-street-server/utils"
+)
+
+// InvalidTrailingStopError is given out when a trailing stoploss order can't be placed as requested
+type InvalidTrailingStopError struct{ reason string }
+
+func (e InvalidTrailingStopError) Error() string {
+	return fmt.Sprintf("Invalid trailing stoploss order. %s", e.reason)
+}
+
+// getTrail returns how far the trigger price of a trailing stoploss stays from the market price
+func getTrail(price, trailingAmount, trailingPercent uint64) uint64 {
+	if trailingPercent > 0 {
+		return price * trailingPercent / 100
+	}
+	return trailingAmount
+}
+
+// getTrailingAskTrigger returns the trigger price of a trailing stoploss ask for a given market price.
+// 0 is returned if the trail is larger than the price itself.
+func getTrailingAskTrigger(price, trailingAmount, trailingPercent uint64) uint64 {
+	trail := getTrail(price, trailingAmount, trailingPercent)
+	if trail >= price {
+		return 0
+	}
+	return price - trail
+}
+
+// getTrailingBidTrigger returns the trigger price of a trailing stoploss bid for a given market price
+func getTrailingBidTrigger(price, trailingAmount, trailingPercent uint64) uint64 {
+	return price + getTrail(price, trailingAmount, trailingPercent)
+}
+
+// checkTrailingStop checks if an order can be placed with the given trailing amount or percentage
+func checkTrailingStop(orderType OrderType, trailingAmount, trailingPercent uint64) error {
+	if trailingAmount == 0 && trailingPercent == 0 {
+		return nil
+	}
+
+	if orderType != StopLoss {
+		return InvalidTrailingStopError{"Only stoploss orders can trail the market price."}
+	}
+
+	if trailingAmount > 0 && trailingPercent > 0 {
+		return InvalidTrailingStopError{"Trail by either an amount or a percentage, not both."}
+	}
+
+	if trailingPercent >= 100 {
+		return InvalidTrailingStopError{"The trailing percentage must be less than 100."}
+	}
+
+	return nil
+}
+
+// sendTrailingStopUpdate tells the user that the trigger price of his trailing stoploss has moved
+func sendTrailingStopUpdate(userId, orderId, stockId uint32, isAsk bool, triggerPrice, stockQuantity uint64) {
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:            orderId,
+		IsAsk:         isAsk,
+		IsModified:    true,
+		StockId:       stockId,
+		OrderPrice:    triggerPrice,
+		OrderType:     models_pb.OrderType_STOPLOSS,
+		StockQuantity: stockQuantity,
+	})
+}
+
+// UpdateTrailingAskStop moves the trigger price of a trailing stoploss ask after a trade at the given price.
+// The trigger price of an ask only goes up, as the ask has to sell once the price falls by the trail from
+// its highest point. It returns true if the trigger price has moved.
+// It has to be called by the matching engine, as the ask's price decides its place in the stoploss queue.
+func UpdateTrailingAskStop(ask *Ask, price uint64) (bool, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":      "UpdateTrailingAskStop",
+		"param_askId": ask.Id,
+		"param_price": price,
+	})
+
+	ask.Lock()
+	triggerPrice := getTrailingAskTrigger(price, ask.TrailingAmount, ask.TrailingPercent)
+	if ask.IsClosed || !ask.IsTrailingStop() || triggerPrice <= ask.Price {
+		ask.Unlock()
+		return false, nil
+	}
+	oldTriggerPrice := ask.Price
+	ask.Price = triggerPrice
+	ask.UpdatedAt = utils.GetCurrentTimeISO8601()
+	ask.Unlock()
+
+	db := getDB()
+	if err := db.Save(ask).Error; err != nil {
+		l.Errorf("Error while saving the trigger price: %+v", err)
+		return true, err
+	}
+
+	l.Debugf("Moved trigger price from %d to %d", oldTriggerPrice, triggerPrice)
+
+	go sendTrailingStopUpdate(ask.UserId, ask.Id, ask.StockId, true, triggerPrice, ask.StockQuantity)
+
+	return true, nil
+}
+
+// UpdateTrailingBidStop moves the trigger price of a trailing stoploss bid after a trade at the given price.
+// The trigger price of a bid only goes down, as the bid has to buy once the price rises by the trail from
+// its lowest point. It returns true if the trigger price has moved.
+// It has to be called by the matching engine, as the bid's price decides its place in the stoploss queue.
+func UpdateTrailingBidStop(bid *Bid, price uint64) (bool, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":      "UpdateTrailingBidStop",
+		"param_bidId": bid.Id,
+		"param_price": price,
+	})
+
+	bid.Lock()
+	triggerPrice := getTrailingBidTrigger(price, bid.TrailingAmount, bid.TrailingPercent)
+	if bid.IsClosed || !bid.IsTrailingStop() || triggerPrice >= bid.Price {
+		bid.Unlock()
+		return false, nil
+	}
+	oldTriggerPrice := bid.Price
+	bid.Price = triggerPrice
+	bid.UpdatedAt = utils.GetCurrentTimeISO8601()
+	bid.Unlock()
+
+	db := getDB()
+	if err := db.Save(bid).Error; err != nil {
+		l.Errorf("Error while saving the trigger price: %+v", err)
+		return true, err
+	}
+
+	l.Debugf("Moved trigger price from %d to %d", oldTriggerPrice, triggerPrice)
+
+	go sendTrailingStopUpdate(bid.UserId, bid.Id, bid.StockId, false, triggerPrice, bid.StockQuantity)
+
+	return true, nil
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"testing"
+
+	testutils "github.com/delta/dalal-street-server/utils/test"
+)
+
+func TestGetTrailingTriggers(t *testing.T) {
+	var tests = []struct {
+		price           uint64
+		trailingAmount  uint64
+		trailingPercent uint64
+		askTrigger      uint64
+		bidTrigger      uint64
+	}{
+		{200, 10, 0, 190, 210},
+		{200, 0, 5, 190, 210},
+		// percentages are rounded down
+		{99, 0, 10, 90, 108},
+		// the ask can't trail by more than the price
+		{50, 60, 0, 0, 110},
+		{50, 50, 0, 0, 100},
+	}
+
+	for _, test := range tests {
+		askTrigger := getTrailingAskTrigger(test.price, test.trailingAmount, test.trailingPercent)
+		bidTrigger := getTrailingBidTrigger(test.price, test.trailingAmount, test.trailingPercent)
+		if askTrigger != test.askTrigger || bidTrigger != test.bidTrigger {
+			t.Errorf("Triggers for %+v = (%d, %d), expected (%d, %d)", test, askTrigger, bidTrigger, test.askTrigger, test.bidTrigger)
+		}
+	}
+}
+
+func TestCheckTrailingStop(t *testing.T) {
+	var tests = []struct {
+		orderType       OrderType
+		trailingAmount  uint64
+		trailingPercent uint64
+		valid           bool
+	}{
+		{Limit, 0, 0, true},
+		{StopLoss, 0, 0, true},
+		{StopLoss, 10, 0, true},
+		{StopLoss, 0, 99, true},
+		{Limit, 10, 0, false},
+		{StopLimit, 0, 5, false},
+		{StopLoss, 10, 5, false},
+		{StopLoss, 0, 100, false},
+	}
+
+	for _, test := range tests {
+		err := checkTrailingStop(test.orderType, test.trailingAmount, test.trailingPercent)
+		if (err == nil) != test.valid {
+			t.Errorf("checkTrailingStop(%+v) = %v", test, err)
+		}
+	}
+}
+
+func Test_UpdateTrailingStops(t *testing.T) {
+	user := &User{Id: 2, Cash: 10000}
+	stock := &Stock{Id: 1, CurrentPrice: 200}
+
+	db := getDB()
+	defer func() {
+		db.Exec("DELETE FROM OrderDepositTransactions")
+		db.Exec("DELETE FROM Transactions")
+		db.Exec("DELETE FROM Asks")
+		db.Exec("DELETE FROM Bids")
+		db.Delete(user)
+		db.Delete(stock)
+
+		delete(userLocks.m, 2)
+	}()
+
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(stock).Error; err != nil {
+		t.Fatal(err)
+	}
+	LoadStocks()
+
+	if err := db.Create(GetTransactionRef(user.Id, stock.Id, FromExchangeTransaction, 0, 10, 200, 0, -2000)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PlaceAskOrder(user.Id, &Ask{UserId: user.Id, StockId: stock.Id, OrderType: StopLoss, TrailingAmount: 250, StockQuantity: 2}); err == nil {
+		t.Fatalf("Expected a trail of more than the price to fail")
+	}
+
+	// the trigger prices start off trailing the current price
+	ask := &Ask{UserId: user.Id, StockId: stock.Id, OrderType: StopLoss, TrailingAmount: 10, StockQuantity: 2}
+	if _, err := PlaceAskOrder(user.Id, ask); err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, uint64(190), ask.Price)
+
+	bid := &Bid{UserId: user.Id, StockId: stock.Id, OrderType: StopLoss, TrailingPercent: 5, StockQuantity: 1}
+	if _, err := PlaceBidOrder(user.Id, bid); err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, uint64(210), bid.Price)
+
+	savedPrices := func() (uint64, uint64) {
+		savedAsk, savedBid := &Ask{}, &Bid{}
+		db.First(savedAsk, ask.Id)
+		db.First(savedBid, bid.Id)
+		return savedAsk.Price, savedBid.Price
+	}
+
+	var tests = []struct {
+		price      uint64
+		askMoved   bool
+		bidMoved   bool
+		askTrigger uint64
+		bidTrigger uint64
+	}{
+		// the ask only follows the price up, and the bid only follows it down
+		{195, false, true, 190, 204},
+		{220, true, false, 210, 204},
+		{180, false, true, 210, 189},
+		{190, false, false, 210, 189},
+	}
+
+	for _, test := range tests {
+		askMoved, err := UpdateTrailingAskStop(ask, test.price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bidMoved, err := UpdateTrailingBidStop(bid, test.price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		askTrigger, bidTrigger := savedPrices()
+		if askMoved != test.askMoved || bidMoved != test.bidMoved || askTrigger != test.askTrigger || bidTrigger != test.bidTrigger {
+			t.Errorf("After a trade at %d, got (%t, %t, %d, %d), expected %+v", test.price, askMoved, bidMoved, askTrigger, bidTrigger, test)
+		}
+	}
+
+	// closed orders don't trail anymore
+	if _, _, err := CancelOrder(user.Id, ask.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	savedAsk, err := getAsk(ask.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := UpdateTrailingAskStop(savedAsk, 300); moved || err != nil {
+		t.Fatalf("Expected a closed ask to stay put, got (%t, %+v)", moved, err)
+	}
+}
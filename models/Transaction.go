@@ -6,6 +6,7 @@ import (
 
 	models_pb "github.com/delta/dalal-street-server/proto_build/models"
 	"github.com/delta/dalal-street-server/utils"
+	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,6 +36,38 @@ func (tt *TransactionType) Scan(value interface{}) error {
 		*tt = 9
 	case "IpoAllotmentTransaction":
 		*tt = 10
+	case "ModifyOrderTransaction":
+		*tt = 11
+	case "MarginTransaction":
+		*tt = 12
+	case "MarginInterestTransaction":
+		*tt = 13
+	case "ShortSellFeeTransaction":
+		*tt = 14
+	case "OptionTradeTransaction":
+		*tt = 15
+	case "OptionCollateralTransaction":
+		*tt = 16
+	case "OptionSettlementTransaction":
+		*tt = 17
+	case "FutureTradeTransaction":
+		*tt = 18
+	case "FutureVariationMarginTransaction":
+		*tt = 19
+	case "FutureSettlementTransaction":
+		*tt = 20
+	case "StockSplitTransaction":
+		*tt = 21
+	case "BonusIssueTransaction":
+		*tt = 22
+	case "RightsIssueTransaction":
+		*tt = 23
+	case "BuybackTransaction":
+		*tt = 24
+	case "MergerTransaction":
+		*tt = 25
+	case "LiquidationTransaction":
+		*tt = 26
 	default:
 		return fmt.Errorf("invalid value for TransactionType. Got %s", string(value.([]byte)))
 	}
@@ -55,6 +88,22 @@ const (
 	ReserveUpdateTransaction
 	ShortSellTransaction
 	IpoAllotmentTransaction
+	ModifyOrderTransaction
+	MarginTransaction
+	MarginInterestTransaction
+	ShortSellFeeTransaction
+	OptionTradeTransaction
+	OptionCollateralTransaction
+	OptionSettlementTransaction
+	FutureTradeTransaction
+	FutureVariationMarginTransaction
+	FutureSettlementTransaction
+	StockSplitTransaction
+	BonusIssueTransaction
+	RightsIssueTransaction
+	BuybackTransaction
+	MergerTransaction
+	LiquidationTransaction
 )
 
 var transactionTypes = [...]string{
@@ -69,6 +118,22 @@ var transactionTypes = [...]string{
 	"ReserveUpdateTransaction",
 	"ShortSellTransaction",
 	"IpoAllotmentTransaction",
+	"ModifyOrderTransaction",
+	"MarginTransaction",
+	"MarginInterestTransaction",
+	"ShortSellFeeTransaction",
+	"OptionTradeTransaction",
+	"OptionCollateralTransaction",
+	"OptionSettlementTransaction",
+	"FutureTradeTransaction",
+	"FutureVariationMarginTransaction",
+	"FutureSettlementTransaction",
+	"StockSplitTransaction",
+	"BonusIssueTransaction",
+	"RightsIssueTransaction",
+	"BuybackTransaction",
+	"MergerTransaction",
+	"LiquidationTransaction",
 }
 
 func (trType TransactionType) String() string {
@@ -86,12 +151,47 @@ type Transaction struct {
 	ReservedCashTotal     int64           `gorm:"column:reservedCashTotal;not null" json:"reserved_cash_total"`
 	Total                 int64           `gorm:"not null" json:"total"`
 	CreatedAt             string          `gorm:"column:createdAt;not null" json:"created_at"`
+	// option the transaction is for. 0 if it isn't for an option
+	OptionId uint32 `gorm:"column:optionId;not null" json:"option_id"`
+	// future the transaction is for. 0 if it isn't for a future
+	FutureId uint32 `gorm:"column:futureId;not null" json:"future_id"`
 }
 
 func (Transaction) TableName() string {
 	return "Transactions"
 }
 
+// transactionTypeToProto maps every TransactionType to its protobuf counterpart
+var transactionTypeToProto = map[TransactionType]models_pb.TransactionType{
+	FromExchangeTransaction:          models_pb.TransactionType_FROM_EXCHANGE_TRANSACTION,
+	OrderFillTransaction:             models_pb.TransactionType_ORDER_FILL_TRANSACTION,
+	MortgageTransaction:              models_pb.TransactionType_MORTGAGE_TRANSACTION,
+	DividendTransaction:              models_pb.TransactionType_DIVIDEND_TRANSACTION,
+	OrderFeeTransaction:              models_pb.TransactionType_ORDER_FEE_TRANSACTION,
+	TaxTransaction:                   models_pb.TransactionType_TAX_TRANSACTION,
+	PlaceOrderTransaction:            models_pb.TransactionType_PLACE_ORDER_TRANSACTION,
+	CancelOrderTransaction:           models_pb.TransactionType_CANCEL_ORDER_TRANSACTION,
+	ReserveUpdateTransaction:         models_pb.TransactionType_RESERVE_UPDATE_TRANSACTION,
+	ShortSellTransaction:             models_pb.TransactionType_SHORT_SELL_TRANSACTION,
+	IpoAllotmentTransaction:          models_pb.TransactionType_IPO_ALLOTMENT_TRANSACTION,
+	ModifyOrderTransaction:           models_pb.TransactionType_MODIFY_ORDER_TRANSACTION,
+	MarginTransaction:                models_pb.TransactionType_MARGIN_TRANSACTION,
+	MarginInterestTransaction:        models_pb.TransactionType_MARGIN_INTEREST_TRANSACTION,
+	ShortSellFeeTransaction:          models_pb.TransactionType_SHORT_SELL_FEE_TRANSACTION,
+	OptionTradeTransaction:           models_pb.TransactionType_OPTION_TRADE_TRANSACTION,
+	OptionCollateralTransaction:      models_pb.TransactionType_OPTION_COLLATERAL_TRANSACTION,
+	OptionSettlementTransaction:      models_pb.TransactionType_OPTION_SETTLEMENT_TRANSACTION,
+	FutureTradeTransaction:           models_pb.TransactionType_FUTURE_TRADE_TRANSACTION,
+	FutureVariationMarginTransaction: models_pb.TransactionType_FUTURE_VARIATION_MARGIN_TRANSACTION,
+	FutureSettlementTransaction:      models_pb.TransactionType_FUTURE_SETTLEMENT_TRANSACTION,
+	StockSplitTransaction:            models_pb.TransactionType_STOCK_SPLIT_TRANSACTION,
+	BonusIssueTransaction:            models_pb.TransactionType_BONUS_ISSUE_TRANSACTION,
+	RightsIssueTransaction:           models_pb.TransactionType_RIGHTS_ISSUE_TRANSACTION,
+	BuybackTransaction:               models_pb.TransactionType_BUYBACK_TRANSACTION,
+	MergerTransaction:                models_pb.TransactionType_MERGER_TRANSACTION,
+	LiquidationTransaction:           models_pb.TransactionType_LIQUIDATION_TRANSACTION,
+}
+
 func (t *Transaction) ToProto() *models_pb.Transaction {
 	pTrans := &models_pb.Transaction{
 		Id:      t.Id,
@@ -104,31 +204,11 @@ func (t *Transaction) ToProto() *models_pb.Transaction {
 		ReservedCashTotal:     t.ReservedCashTotal,
 		Total:                 t.Total,
 		CreatedAt:             t.CreatedAt,
+		OptionId:              t.OptionId,
+		FutureId:              t.FutureId,
 	}
 
-	if t.Type == FromExchangeTransaction {
-		pTrans.Type = models_pb.TransactionType_FROM_EXCHANGE_TRANSACTION
-	} else if t.Type == OrderFillTransaction {
-		pTrans.Type = models_pb.TransactionType_ORDER_FILL_TRANSACTION
-	} else if t.Type == MortgageTransaction {
-		pTrans.Type = models_pb.TransactionType_MORTGAGE_TRANSACTION
-	} else if t.Type == DividendTransaction {
-		pTrans.Type = models_pb.TransactionType_DIVIDEND_TRANSACTION
-	} else if t.Type == OrderFeeTransaction {
-		pTrans.Type = models_pb.TransactionType_ORDER_FEE_TRANSACTION
-	} else if t.Type == TaxTransaction {
-		pTrans.Type = models_pb.TransactionType_TAX_TRANSACTION
-	} else if t.Type == PlaceOrderTransaction {
-		pTrans.Type = models_pb.TransactionType_PLACE_ORDER_TRANSACTION
-	} else if t.Type == CancelOrderTransaction {
-		pTrans.Type = models_pb.TransactionType_CANCEL_ORDER_TRANSACTION
-	} else if t.Type == ReserveUpdateTransaction {
-		pTrans.Type = models_pb.TransactionType_RESERVE_UPDATE_TRANSACTION
-	} else if t.Type == ShortSellTransaction {
-		pTrans.Type = models_pb.TransactionType_SHORT_SELL_TRANSACTION
-	} else if t.Type == IpoAllotmentTransaction {
-		pTrans.Type = models_pb.TransactionType_IPO_ALLOTMENT_TRANSACTION
-	}
+	pTrans.Type = transactionTypeToProto[t.Type]
 
 	return pTrans
 }
@@ -203,3 +283,26 @@ func GetTransactionRef(userID, stockID uint32, ttype TransactionType, reservedSt
 		CreatedAt:             utils.GetCurrentTimeISO8601(),
 	}
 }
+
+// saveTransaction saves a new transaction. A transaction that isn't for any stock, like those of margin
+// accounts and index futures, has stockId saved as NULL, as it has to be either that or a stock's id.
+func saveTransaction(tx *gorm.DB, t *Transaction) error {
+	if t.StockId != 0 {
+		return tx.Save(t).Error
+	}
+
+	sql := "INSERT INTO Transactions (userId, stockId, type, reservedStockQuantity, stockQuantity, price, reservedCashTotal, total, createdAt, optionId, futureId) VALUES (?, NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if err := tx.Exec(sql, t.UserId, t.Type.String(), t.ReservedStockQuantity, t.StockQuantity, t.Price, t.ReservedCashTotal, t.Total, t.CreatedAt, t.OptionId, t.FutureId).Error; err != nil {
+		return err
+	}
+
+	var inserted struct {
+		Id uint32
+	}
+	if err := tx.Raw("SELECT LAST_INSERT_ID() AS id").Scan(&inserted).Error; err != nil {
+		return err
+	}
+	t.Id = inserted.Id
+
+	return nil
+}
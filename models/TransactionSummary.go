@@ -9,6 +9,8 @@ type TransactionSummary struct {
 	StockId       uint32  `gorm:"column:stockId;not null"`
 	StockQuantity int64   `gorm:"column:stockQuantity;not null"`
 	Price         float64 `gorm:"column:price;not null"`
+	// Gain, or loss if negative, made by selling stocks held and buying back stocks short sold
+	RealizedGain float64 `gorm:"column:realizedGain;not null"`
 }
 
 func (TransactionSummary) TableName() string {
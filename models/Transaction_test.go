@@ -27,3 +27,11 @@ func TestTransactionToProto(t *testing.T) {
 		t.Fatal("Converted values not equal!")
 	}
 }
+
+func TestTransactionTypeToProto(t *testing.T) {
+	for tt := range transactionTypes {
+		if _, ok := transactionTypeToProto[TransactionType(tt)]; !ok {
+			t.Errorf("%s has no protobuf transaction type", TransactionType(tt))
+		}
+	}
+}
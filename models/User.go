@@ -11,10 +11,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/delta/dalal-street-server/metrics"
 	datastreams_pb "github.com/delta/dalal-street-server/proto_build/datastreams"
 	"github.com/delta/dalal-street-server/templates"
 	"github.com/delta/dalal-street-server/utils"
@@ -37,22 +38,6 @@ var (
 	InvalidTemporaryPasswordError = errors.New("Invalid temporary password")
 	UserNotFoundError             = errors.New("Invalid userId.")
 	InvalidReferralCodeError      = errors.New("Invalid Referral Code")
-	/*
-		Net worth <= 0 => tax percentage = 0%
-		0 < Net worth <= 100000 => tax percentage = 2%
-		100000 < Net worth <= 500000 => tax percentage = 5%
-		500000 < Net worth <= 1000000 => tax percentage = 9%
-		1000000 < Net worth <= 2000000 => tax percentage = 15%
-		Net worth > 2000000 => tax percentage = 25%
-	*/
-	TaxBrackets = map[int64]uint64{
-		0:       0,
-		100000:  2,
-		500000:  5,
-		1000000: 9,
-		2000000: 15,
-	}
-	MaxTaxPercent uint64 = 25
 )
 
 var TotalUserCount uint32
@@ -323,7 +308,9 @@ func checkPasswordHash(password, hash string) bool {
 	return hash == sha1Hash
 }
 
-func getOrderFeePrice(price uint64, stockId uint32, o OrderType) uint64 {
+// getOrderFeePrice returns the price cash is reserved and fees are charged at for an order of type o.
+// A stop-limit order trades like a limit order at its limit price once it's triggered.
+func getOrderFeePrice(price, limitPrice uint64, stockId uint32, o OrderType) uint64 {
 	var orderFeePrice uint64
 	switch o {
 	case Limit:
@@ -334,14 +321,12 @@ func getOrderFeePrice(price uint64, stockId uint32, o OrderType) uint64 {
 		allStocks.m[stockId].RUnlock()
 	case StopLoss:
 		orderFeePrice = price
+	case StopLimit:
+		orderFeePrice = limitPrice
 	}
 	return orderFeePrice
 }
 
-func getOrderFee(quantity, price uint64) uint64 {
-	return uint64((ORDER_FEE_PERCENT / 100.0) * float64(quantity*price))
-}
-
 // createUser() creates a user given his email and name.
 func createUser(name string, email string) (*User, error) {
 	var l = logger.WithFields(logrus.Fields{
@@ -632,6 +617,34 @@ func (e InvalidOrderIDError) Error() string {
 	return fmt.Sprintf("Invalid order id")
 }
 
+// OrderNotModifiableError is given out when a user tries to modify an order that can't be modified
+type OrderNotModifiableError struct{ reason string }
+
+func (e OrderNotModifiableError) Error() string {
+	return fmt.Sprintf("Cannot modify this order. %s", e.reason)
+}
+
+// InvalidTimeInForceError is given out when an order's time in force can't be used with it
+type InvalidTimeInForceError struct{ reason string }
+
+func (e InvalidTimeInForceError) Error() string {
+	return fmt.Sprintf("Invalid time in force. %s", e.reason)
+}
+
+// InvalidIcebergOrderError is given out when an iceberg order can't be placed as requested
+type InvalidIcebergOrderError struct{ reason string }
+
+func (e InvalidIcebergOrderError) Error() string {
+	return fmt.Sprintf("Invalid iceberg order. %s", e.reason)
+}
+
+// InvalidStopLimitOrderError is given out when a stop-limit order can't be placed as requested
+type InvalidStopLimitOrderError struct{ reason string }
+
+func (e InvalidStopLimitOrderError) Error() string {
+	return fmt.Sprintf("Invalid stop-limit order. %s", e.reason)
+}
+
 // InvalidRetrievePriceError is given out when a user tries to cancel an order he didn't make or that didn't exist
 type InvalidRetrievePriceError struct{}
 
@@ -668,6 +681,13 @@ func (e StockBankruptError) Error() string {
 	return fmt.Sprintf("This stock is already bankrupt you are not allowed to perform any action on this stock.")
 }
 
+// StockDelistedError is given out when the stock has been delisted.
+type StockDelistedError struct{}
+
+func (e StockDelistedError) Error() string {
+	return fmt.Sprintf("This stock has been delisted. It can't be traded anymore.")
+}
+
 //
 // Methods
 //
@@ -884,6 +904,80 @@ func AddUserReservedCash(user *User, reservedCash uint64, tx *gorm.DB) error {
 	return nil
 }
 
+// checkTimeInForce checks if an order can be placed with the given time in force
+func checkTimeInForce(orderType OrderType, timeInForce TimeInForce, expiresOnDay uint32) error {
+	if timeInForce.IsImmediate() && orderType != Limit && orderType != Market {
+		return InvalidTimeInForceError{"Only limit and market orders can be immediate-or-cancel or fill-or-kill."}
+	}
+
+	if timeInForce.IsImmediate() && IsCallAuctionRunning() {
+		return InvalidTimeInForceError{"Immediate-or-cancel and fill-or-kill orders can't be placed during a call auction."}
+	}
+
+	if timeInForce != GoodTillDate {
+		if expiresOnDay != 0 {
+			return InvalidTimeInForceError{"Only good-till-date orders can have an expiry day."}
+		}
+		return nil
+	}
+
+	if marketDay := GetMarketDay(); expiresOnDay < marketDay {
+		return InvalidTimeInForceError{fmt.Sprintf("The order must expire on day %d or later.", marketDay)}
+	}
+
+	return nil
+}
+
+// checkIcebergOrder checks if an order can be placed as an iceberg with the given display quantity
+func checkIcebergOrder(orderType OrderType, timeInForce TimeInForce, isIceberg bool, stockQuantity, displayQuantity uint64) error {
+	if !isIceberg {
+		if displayQuantity != 0 {
+			return InvalidIcebergOrderError{"Only iceberg orders can have a display quantity."}
+		}
+		return nil
+	}
+
+	if orderType != Limit {
+		return InvalidIcebergOrderError{"Only limit orders can be iceberg orders."}
+	}
+
+	if timeInForce.IsImmediate() {
+		return InvalidIcebergOrderError{"Immediate-or-cancel and fill-or-kill orders can't be iceberg orders."}
+	}
+
+	if displayQuantity < 1 || displayQuantity >= stockQuantity {
+		return InvalidIcebergOrderError{"Display quantity must be at least 1 and less than the order's quantity."}
+	}
+
+	return nil
+}
+
+// checkIcebergModification checks if an iceberg order can still show its display quantity after its quantity is changed
+func checkIcebergModification(isIceberg bool, quantityFulfilled, newQuantity, displayQuantity uint64) error {
+	if !isIceberg {
+		return nil
+	}
+
+	if displayQuantity < 1 || displayQuantity > newQuantity-quantityFulfilled {
+		return OrderNotModifiableError{"The display quantity of an iceberg order can't be more than its unfulfilled quantity."}
+	}
+
+	return nil
+}
+
+// checkStopLimitOrder checks if an order of the given stock can be placed with the given limit price.
+// A triggered stop-limit order rests in the book at its limit price, so it has to be within the price window like that of a limit order.
+func checkStopLimitOrder(orderType OrderType, stockId uint32, limitPrice uint64) error {
+	if orderType != StopLimit {
+		if limitPrice != 0 {
+			return InvalidStopLimitOrderError{"Only stop-limit orders can have a limit price."}
+		}
+		return nil
+	}
+
+	return checkOrderPrice(stockId, limitPrice)
+}
+
 // PlaceAskOrder places an Ask order for the user.
 //
 // The method is thread-safe like other exported methods of this package.
@@ -906,6 +1000,49 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 		return 0, StockBankruptError{}
 	}
 
+	if IsStockDelisted(ask.StockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return 0, StockDelistedError{}
+	}
+
+	if err := checkStockHalted(ask.StockId); err != nil {
+		l.Infof("Trading in the stock has been halted. Returning function.")
+		return 0, err
+	}
+
+	if err := checkTimeInForce(ask.OrderType, ask.TimeInForce, ask.ExpiresOnDay); err != nil {
+		l.Debugf("Time in force check failed for ask order")
+		return 0, err
+	}
+
+	if err := checkIcebergOrder(ask.OrderType, ask.TimeInForce, ask.IsIceberg, ask.StockQuantity, ask.DisplayQuantity); err != nil {
+		l.Debugf("Iceberg check failed for ask order")
+		return 0, err
+	}
+
+	if err := checkTrailingStop(ask.OrderType, ask.TrailingAmount, ask.TrailingPercent); err != nil {
+		l.Debugf("Trailing stop check failed for ask order")
+		return 0, err
+	}
+
+	if err := checkStopLimitOrder(ask.OrderType, ask.StockId, ask.LimitPrice); err != nil {
+		l.Debugf("Stop-limit check failed for ask order")
+		return 0, err
+	}
+
+	// The trigger price of a trailing stoploss starts off trailing the current price
+	if ask.IsTrailingStop() {
+		allStocks.m[ask.StockId].RLock()
+		currentPrice := allStocks.m[ask.StockId].stock.CurrentPrice
+		allStocks.m[ask.StockId].RUnlock()
+
+		ask.Price = getTrailingAskTrigger(currentPrice, ask.TrailingAmount, ask.TrailingPercent)
+		if ask.Price == 0 {
+			l.Debugf("Trailing amount is more than the current price")
+			return 0, InvalidTrailingStopError{"The trail must be less than the current price."}
+		}
+	}
+
 	// Place cap on order price only for limit orders
 	if ask.OrderType == Limit {
 		if ask.Price <= MINIMUM_ORDER_PRICE {
@@ -984,7 +1121,7 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 			return 0, NotEnoughStocksError{int64(availableStocks)}
 		}
 
-		// checking intra day limit for user
+		// checking the limit on stocks lent to the user in open lends
 		lentStocks, err := getUserShortSellStocks(ask.StockId, ask.UserId)
 
 		if err != nil {
@@ -992,7 +1129,7 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 		}
 
 		if lentStocks+uint32(shortsellQty) > SHORT_SELL_BORROW_LIMIT {
-			l.Debug("Check2: failed, user crossed intra-day limit")
+			l.Debug("Check2: failed, user crossed the borrow limit")
 			currentAllowedQty := SHORT_SELL_BORROW_LIMIT - int64(lentStocks)
 
 			return 0, NotEnoughStocksError{currentAllowedQty}
@@ -1001,18 +1138,7 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 		l.Debug("check2 : passed")
 	}
 
-	orderPrice := getOrderFeePrice(ask.Price, ask.StockId, ask.OrderType)
-	orderFee := getOrderFee(ask.StockQuantity, orderPrice)
-	cashLeft := int64(user.Cash) - int64(orderFee)
-
-	l.Debugf("Check3: User has %d cash currently. Will be left with %d cash after trade.", user.Cash, cashLeft)
-
-	if cashLeft < MINIMUM_CASH_LIMIT {
-		l.Debugf("Check3: Failed. Not enough cash.")
-		return 0, NotEnoughCashError{}
-	}
-
-	l.Debugf("Check3: Passed. Creating Ask.")
+	l.Debugf("Creating Ask.")
 
 	oldCash := user.Cash
 
@@ -1032,27 +1158,6 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 
 	l.Infof("Created Ask order. AskId: %d", ask.Id)
 
-	if err := SubtractUserCash(user, orderFee, tx); err != nil {
-		return errorHelper("Error while subtracting order fee from user. Rolling back. Error: %+v", err)
-	}
-
-	orderFeeTransaction := GetTransactionRef(
-		userId,
-		ask.StockId,
-		OrderFeeTransaction,
-		0,
-		0,
-		0,
-		0,
-		int64(-orderFee),
-	)
-
-	if err := tx.Save(orderFeeTransaction).Error; err != nil {
-		return errorHelper("Error saving OrderFeeTransaction. Rolling back. Error: %+v", err)
-	}
-
-	l.Infof("Saved OrderFeeTransaction for bid %d", ask.Id)
-
 	var shortSellTransaction *Transaction
 
 	// lend transaction only happens while shorting
@@ -1097,7 +1202,7 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 	l.Infof("Committed successfully for bid %d", ask.Id)
 
 	// Update datastreams to add newly placed order in OpenOrders
-	go func(ask *Ask, orderFeeTransaction, placeOrderTransaction *Transaction) {
+	go func(ask *Ask, placeOrderTransaction *Transaction) {
 		myOrdersStream := datastreamsManager.GetMyOrdersStream()
 		transactionsStream := datastreamsManager.GetTransactionsStream()
 
@@ -1114,11 +1219,10 @@ func PlaceAskOrder(userId uint32, ask *Ask) (uint32, error) {
 		if shortSellTransaction != nil {
 			transactionsStream.SendTransaction(shortSellTransaction.ToProto())
 		}
-		transactionsStream.SendTransaction(orderFeeTransaction.ToProto())
 		transactionsStream.SendTransaction(placeOrderTransaction.ToProto())
 
 		l.Infof("Sent through the datastreams")
-	}(ask, orderFeeTransaction, placeOrderTransaction)
+	}(ask, placeOrderTransaction)
 
 	return ask.Id, nil
 }
@@ -1144,6 +1248,49 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 		return 0, StockBankruptError{}
 	}
 
+	if IsStockDelisted(bid.StockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return 0, StockDelistedError{}
+	}
+
+	if err := checkStockHalted(bid.StockId); err != nil {
+		l.Infof("Trading in the stock has been halted. Returning function.")
+		return 0, err
+	}
+
+	if err := checkTimeInForce(bid.OrderType, bid.TimeInForce, bid.ExpiresOnDay); err != nil {
+		l.Debugf("Time in force check failed for bid order")
+		return 0, err
+	}
+
+	if err := checkIcebergOrder(bid.OrderType, bid.TimeInForce, bid.IsIceberg, bid.StockQuantity, bid.DisplayQuantity); err != nil {
+		l.Debugf("Iceberg check failed for bid order")
+		return 0, err
+	}
+
+	if err := checkTrailingStop(bid.OrderType, bid.TrailingAmount, bid.TrailingPercent); err != nil {
+		l.Debugf("Trailing stop check failed for bid order")
+		return 0, err
+	}
+
+	if err := checkStopLimitOrder(bid.OrderType, bid.StockId, bid.LimitPrice); err != nil {
+		l.Debugf("Stop-limit check failed for bid order")
+		return 0, err
+	}
+
+	// The trigger price of a trailing stoploss starts off trailing the current price
+	if bid.IsTrailingStop() {
+		allStocks.m[bid.StockId].RLock()
+		currentPrice := allStocks.m[bid.StockId].stock.CurrentPrice
+		allStocks.m[bid.StockId].RUnlock()
+
+		bid.Price = getTrailingBidTrigger(currentPrice, bid.TrailingAmount, bid.TrailingPercent)
+		if bid.Price == 0 {
+			l.Debugf("Trailing amount is more than the current price")
+			return 0, InvalidTrailingStopError{"The trail must be less than the current price."}
+		}
+	}
+
 	// Place cap on order price only for limit orders
 	if bid.OrderType == Limit {
 		if bid.Price <= MINIMUM_ORDER_PRICE {
@@ -1192,11 +1339,13 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 	l.Debugf("Check1: Passed.")
 
 	// Second Check: User should have enough cash
-	orderPrice := getOrderFeePrice(bid.Price, bid.StockId, bid.OrderType)
-	orderFee := getOrderFee(bid.StockQuantity, orderPrice)
-	cashLeft := int64(user.Cash) - int64(bid.StockQuantity*orderPrice+orderFee)
+	orderPrice := getOrderFeePrice(bid.Price, bid.LimitPrice, bid.StockId, bid.OrderType)
+	// fees are charged when the bid trades, so the fee is reserved too, as if the whole bid traded as a taker.
+	// What isn't charged is given back with the rest of the reservation when the bid trades or is cancelled.
+	orderFee := getMaxOrderFee(userId, bid.StockId, bid.StockQuantity, orderPrice)
+	reservedCash := bid.StockQuantity*orderPrice + orderFee
+	cashLeft := int64(user.Cash) - int64(reservedCash)
 
-	reservedCash := uint64(bid.StockQuantity * orderPrice)
 	l.Debugf("Cash to be reserved is %d", reservedCash)
 	l.Debugf("Check2: User has %d cash currently. Will be left with %d cash after trade.", user.Cash, cashLeft)
 
@@ -1227,33 +1376,13 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 
 	l.Infof("Created Bid order. BidId: %d", bid.Id)
 
-	if err := SubtractUserCash(user, orderFee, tx); err != nil {
-		return errorHelper("Error while subtracting order fee from user. Rolling back. Error: %+v", err)
-	}
-
 	if err := AddUserReservedCash(user, reservedCash, tx); err != nil {
 		return errorHelper("Error while adding reserved cash to the user. Rolling back. Error: %+v", err)
 	}
 
-	// Update datastreams to add newly placed order in OpenOrders
-	orderFeeTransaction := GetTransactionRef(
-		userId,
-		bid.StockId,
-		OrderFeeTransaction,
-		0,
-		0,
-		0,
-		0,
-		int64(-orderFee),
-	)
-
-	if err := tx.Save(orderFeeTransaction).Error; err != nil {
-		return errorHelper("Error saving OrderFeeTransaction. Rolling back. Error: %+v", err)
-	}
-
-	l.Infof("Saved OrderFeeTransaction for bid. Now subtracting user cash for bid %d", bid.Id)
+	l.Infof("Now subtracting user cash for bid %d", bid.Id)
 
-	if err := SubtractUserCash(user, bid.StockQuantity*orderPrice, tx); err != nil {
+	if err := SubtractUserCash(user, reservedCash, tx); err != nil {
 		return errorHelper("Error subtracting cash. Rolling back. Error: %+v", err)
 	}
 
@@ -1265,8 +1394,8 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 		0,
 		0,
 		0,
-		int64(bid.StockQuantity*orderPrice),
-		-1*int64(bid.StockQuantity*orderPrice),
+		int64(reservedCash),
+		-1*int64(reservedCash),
 	)
 
 	l.Infof("Reserving cash for bid %d", bid.Id)
@@ -1282,7 +1411,7 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 	l.Infof("Commited successfully for bid %d", bid.Id)
 
 	// Update datastreams to add newly placed order in OpenOrders
-	go func(bid *Bid, orderFeeTransaction, placeOrderTransaction *Transaction) {
+	go func(bid *Bid, placeOrderTransaction *Transaction) {
 		myOrdersStream := datastreamsManager.GetMyOrdersStream()
 		transactionsStream := datastreamsManager.GetTransactionsStream()
 
@@ -1295,11 +1424,10 @@ func PlaceBidOrder(userId uint32, bid *Bid) (uint32, error) {
 			OrderType:     bid.ToProto().OrderType,
 			StockQuantity: bid.StockQuantity,
 		})
-		transactionsStream.SendTransaction(orderFeeTransaction.ToProto())
 		transactionsStream.SendTransaction(placeOrderTransaction.ToProto())
 
 		l.Infof("Sent through the datastreams")
-	}(bid, orderFeeTransaction, placeOrderTransaction)
+	}(bid, placeOrderTransaction)
 
 	return bid.Id, nil
 }
@@ -1312,6 +1440,20 @@ func saveAskCancelOrderTransaction(askOrder *Ask, user *User, tx *gorm.DB) error
 		"orderId": askOrder.Id,
 	})
 
+	if askOrder.OptionId != 0 {
+		return saveOptionAskCancelTransaction(askOrder, user, tx)
+	}
+	if askOrder.FutureId != 0 {
+		return saveFutureAskCancelTransaction(askOrder, user, tx)
+	}
+
+	// the other legs of its group share the stocks reserved for it. So they can't stay open.
+	closedSiblings, err := closeSiblingAsks(askOrder, tx)
+	if err != nil {
+		l.Errorf("Error while closing the other asks of group %d. Error: %+v", askOrder.GroupId, err)
+		return err
+	}
+
 	cancelOrderTransaction := GetTransactionRef(
 		user.Id,
 		askOrder.StockId,
@@ -1331,6 +1473,7 @@ func saveAskCancelOrderTransaction(askOrder *Ask, user *User, tx *gorm.DB) error
 	go func(cancelOrderTransaction *Transaction) {
 		transactionsStream := datastreamsManager.GetTransactionsStream()
 		transactionsStream.SendTransaction(cancelOrderTransaction.ToProto())
+		notifyClosedSiblingAsks(closedSiblings)
 
 		l.Infof("Sent through the datastreams")
 	}(cancelOrderTransaction)
@@ -1338,6 +1481,16 @@ func saveAskCancelOrderTransaction(askOrder *Ask, user *User, tx *gorm.DB) error
 	return nil
 }
 
+// getReservedCashLeft returns what's left of the cash reserved for a bid after stockQuantityFulfilled of its
+// stocks have traded. Each fill takes the difference of this before and after it, so the fills of the whole
+// bid take exactly what was reserved.
+func getReservedCashLeft(reservedCash int64, stockQuantity, stockQuantityFulfilled uint64) int64 {
+	if stockQuantity == 0 || stockQuantityFulfilled >= stockQuantity {
+		return 0
+	}
+	return reservedCash - int64(float64(reservedCash)*float64(stockQuantityFulfilled)/float64(stockQuantity))
+}
+
 // saveBidCancelOrderTransaction creates a CancelOrderTransaction for Ask orders and pushses to stream
 func saveBidCancelOrderTransaction(bidOrder *Bid, user *User, tx *gorm.DB) error {
 	var l = logger.WithFields(logrus.Fields{
@@ -1352,8 +1505,14 @@ func saveBidCancelOrderTransaction(bidOrder *Bid, user *User, tx *gorm.DB) error
 		return err
 	}
 
-	stocksFulfilled := bidOrder.StockQuantity - bidOrder.StockQuantityFulfilled
-	reservedCash = int64(float64(reservedCash) * float64(stocksFulfilled) / float64(bidOrder.StockQuantity))
+	// the other legs of its group share the cash reserved for it. So they can't stay open.
+	closedSiblings, err := closeSiblingBids(bidOrder, tx)
+	if err != nil {
+		l.Errorf("Error while closing the other bids of group %d. Error: %+v", bidOrder.GroupId, err)
+		return err
+	}
+
+	reservedCash = getReservedCashLeft(reservedCash, bidOrder.StockQuantity, bidOrder.StockQuantityFulfilled)
 	cancelOrderTransaction := GetTransactionRef(
 		user.Id,
 		bidOrder.StockId,
@@ -1364,6 +1523,8 @@ func saveBidCancelOrderTransaction(bidOrder *Bid, user *User, tx *gorm.DB) error
 		-1*reservedCash,
 		reservedCash,
 	)
+	cancelOrderTransaction.OptionId = bidOrder.OptionId
+	cancelOrderTransaction.FutureId = bidOrder.FutureId
 
 	user.Cash += uint64(reservedCash)
 	user.ReservedCash -= uint64(reservedCash)
@@ -1373,7 +1534,7 @@ func saveBidCancelOrderTransaction(bidOrder *Bid, user *User, tx *gorm.DB) error
 		return err
 	}
 
-	if err := tx.Save(cancelOrderTransaction).Error; err != nil {
+	if err := saveTransaction(tx, cancelOrderTransaction); err != nil {
 		l.Errorf("Error while saving cancelOrderTransaction %+v", err)
 		return err
 	}
@@ -1381,6 +1542,7 @@ func saveBidCancelOrderTransaction(bidOrder *Bid, user *User, tx *gorm.DB) error
 	go func(cancelOrderTransaction *Transaction) {
 		transactionsStream := datastreamsManager.GetTransactionsStream()
 		transactionsStream.SendTransaction(cancelOrderTransaction.ToProto())
+		notifyClosedSiblingBids(closedSiblings)
 
 		l.Infof("Sent through the datastreams")
 	}(cancelOrderTransaction)
@@ -1502,27 +1664,457 @@ func CancelOrder(userId uint32, orderId uint32, isAsk bool) (*Ask, *Bid, error)
 	}
 }
 
-// Helper function to determine what percentage the user should be taxed
-func getTaxPercent(netCash int64) uint64 {
+// ModifiedOrder is returned by ModifyOrder. It holds the Ask/Bid (whichever it was - the other is nil)
+// that got modified, along with its price and quantity from before the modification. The matching
+// engine needs those to update the depth.
+type ModifiedOrder struct {
+	Ask              *Ask
+	Bid              *Bid
+	OldPrice         uint64
+	OldStockQuantity uint64
+}
+
+// GetOrderStockId returns the id of the stock an order is for. It'll check if the user was the one who
+// placed it. The matching engine uses it to find the order book that has to handle a ModifyOrder.
+// Orders of options can't be modified, so OrderNotModifiableError is returned for them.
+func GetOrderStockId(userId, orderId uint32, isAsk bool) (uint32, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "GetOrderStockId",
+		"param_userId":  userId,
+		"param_orderId": orderId,
+		"param_isAsk":   isAsk,
+	})
+
+	if isAsk {
+		askOrder, err := getAsk(orderId)
+		if askOrder == nil || askOrder.UserId != userId {
+			l.Errorf("Invalid ask id provided")
+			return 0, InvalidOrderIDError{}
+		} else if err != nil {
+			l.Errorf("Unknown error in getAsk: %+v", err)
+			return 0, err
+		}
+		if askOrder.OptionId != 0 {
+			return 0, OrderNotModifiableError{"Orders of options can't be modified."}
+		}
+		if askOrder.FutureId != 0 {
+			return 0, OrderNotModifiableError{"Orders of futures can't be modified."}
+		}
+		return askOrder.StockId, nil
+	}
+
+	bidOrder, err := getBid(orderId)
+	if bidOrder == nil || bidOrder.UserId != userId {
+		l.Errorf("Invalid bid id provided")
+		return 0, InvalidOrderIDError{}
+	} else if err != nil {
+		l.Errorf("Unknown error in getBid: %+v", err)
+		return 0, err
+	}
+	if bidOrder.OptionId != 0 {
+		return 0, OrderNotModifiableError{"Orders of options can't be modified."}
+	}
+	if bidOrder.FutureId != 0 {
+		return 0, OrderNotModifiableError{"Orders of futures can't be modified."}
+	}
+	return bidOrder.StockId, nil
+}
+
+// checkOrderPrice checks whether price can be used as the price of a limit order of the given stock
+func checkOrderPrice(stockId uint32, price uint64) error {
+	if price <= MINIMUM_ORDER_PRICE {
+		return MinimumPriceThresholdError{}
+	}
+
+	allStocks.m[stockId].RLock()
+	currentPrice := allStocks.m[stockId].stock.CurrentPrice
+	allStocks.m[stockId].RUnlock()
+
+	var upperLimit = uint64((1 + ORDER_PRICE_WINDOW/100.0) * float64(currentPrice))
+	var lowerLimit = uint64((1 - ORDER_PRICE_WINDOW/100.0) * float64(currentPrice))
+
+	if price > upperLimit || price < lowerLimit {
+		return OrderPriceOutOfWindowError{currentPrice}
+	}
+
+	return nil
+}
+
+// checkOrderModification runs the checks common to asks and bids before an order gets modified
+func checkOrderModification(stockId uint32, orderType OrderType, timeInForce TimeInForce, isClosed bool, quantityFulfilled, newPrice, newQuantity, quantityLimit uint64) error {
+	if isClosed {
+		return OrderNotModifiableError{"It is already closed."}
+	}
+
+	if err := checkStockHalted(stockId); err != nil {
+		return err
+	}
+
+	if timeInForce.IsImmediate() {
+		return OrderNotModifiableError{"Immediate-or-cancel and fill-or-kill orders never rest in the order book."}
+	}
+
+	if orderType != Limit && orderType != StopLoss {
+		return OrderNotModifiableError{"Only limit orders and untriggered stoploss orders can be modified."}
+	}
+
+	if newQuantity > quantityLimit || newQuantity < 1 {
+		return OrderStockLimitExceeded{}
+	}
+
+	if newQuantity <= quantityFulfilled {
+		return OrderNotModifiableError{fmt.Sprintf("%d stocks of it have been traded already.", quantityFulfilled)}
+	}
+
+	// Place cap on order price only for limit orders
+	if orderType == Limit {
+		if err := checkOrderPrice(stockId, newPrice); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ModifyOrder changes the price and/or the quantity of a user's open order. It'll check if the user
+// was the one who placed it. Only Limit orders and StopLoss orders that haven't been triggered yet can
+// be modified. The reservation made for the order is adjusted in the same database transaction, through
+// a ModifyOrderTransaction.
+//
+// It has to be called by the matching engine, as the order must not be matched while it is being modified.
+// The returned ModifiedOrder is used by the matching engine to update its queues and the depth.
+//
+// Possible outcomes:
+// 	1. Order gets modified successfully
+//  2. InvalidOrderIDError is returned
+//  3. OrderNotModifiableError is returned
+//  4. OrderStockLimitExceeded, MinimumPriceThresholdError or OrderPriceOutOfWindowError is returned
+//  5. NotEnoughStocksError or NotEnoughCashError is returned
+//  6. Other error is returned (e.g. if Database connection doesn't open)
+func ModifyOrder(userId, orderId uint32, isAsk bool, newPrice, newStockQuantity uint64) (*ModifiedOrder, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":                 "ModifyOrder",
+		"param_userId":           userId,
+		"param_orderId":          orderId,
+		"param_isAsk":            isAsk,
+		"param_newPrice":         newPrice,
+		"param_newStockQuantity": newStockQuantity,
+	})
+
+	l.Infof("ModifyOrder requested")
+
+	l.Debugf("Acquiring exclusive write on user")
+
+	ch, user, err := getUserExclusively(userId)
+	if err != nil {
+		l.Errorf("Errored: %+v", err)
+		return nil, err
+	}
+	l.Debugf("Acquired")
+	defer func() {
+		close(ch)
+		l.Debugf("Released exclusive write on user")
+	}()
+
+	// A lock on user has been acquired. The order can't get filled
+	// or cancelled till it's released. So its fields can be read safely.
+
+	if isAsk {
+		askOrder, err := getAsk(orderId)
+		if askOrder == nil || askOrder.UserId != userId {
+			l.Errorf("Invalid ask id provided")
+			return nil, InvalidOrderIDError{}
+		} else if err != nil {
+			l.Errorf("Unknown error in getAsk: %+v", err)
+			return nil, err
+		}
+
+		return modifyAskOrder(user, askOrder, newPrice, newStockQuantity)
+	}
+
+	bidOrder, err := getBid(orderId)
+	if bidOrder == nil || bidOrder.UserId != userId {
+		l.Errorf("Invalid bid id provided")
+		return nil, InvalidOrderIDError{}
+	} else if err != nil {
+		l.Errorf("Unknown error in getBid: %+v", err)
+		return nil, err
+	}
+
+	return modifyBidOrder(user, bidOrder, newPrice, newStockQuantity)
+}
+
+// modifyAskOrder modifies an ask order. Stocks are reserved or returned as per the change in quantity.
+// This function should be called ONLY AFTER lock is obtained on user
+func modifyAskOrder(user *User, ask *Ask, newPrice, newStockQuantity uint64) (*ModifiedOrder, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":                 "modifyAskOrder",
+		"param_userId":           user.Id,
+		"param_ask":              fmt.Sprintf("%+v", ask),
+		"param_newPrice":         newPrice,
+		"param_newStockQuantity": newStockQuantity,
+	})
+
+	if ask.GroupId != 0 {
+		return nil, OrderNotModifiableError{"It is linked to another order as one-cancels-other."}
+	}
+
+	if ask.IsTrailingStop() && newPrice != ask.Price {
+		return nil, OrderNotModifiableError{"The trigger price of a trailing stoploss follows the market price."}
+	}
+
+	if err := checkOrderModification(ask.StockId, ask.OrderType, ask.TimeInForce, ask.IsClosed, ask.StockQuantityFulfilled, newPrice, newStockQuantity, ASK_LIMIT); err != nil {
+		l.Debugf("Checks failed: %+v", err)
+		return nil, err
+	}
+
+	if err := checkIcebergModification(ask.IsIceberg, ask.StockQuantityFulfilled, newStockQuantity, ask.DisplayQuantity); err != nil {
+		l.Debugf("Iceberg check failed: %+v", err)
+		return nil, err
+	}
 
-	keys := make([]int64, 0)
-	for taxBracket := range TaxBrackets {
-		keys = append(keys, taxBracket)
+	modifiedOrder := &ModifiedOrder{
+		Ask:              ask,
+		OldPrice:         ask.Price,
+		OldStockQuantity: ask.StockQuantity,
 	}
-	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
 
-	for _, taxBracket := range keys {
-		if netCash <= taxBracket {
-			return TaxBrackets[taxBracket]
+	if newPrice == ask.Price && newStockQuantity == ask.StockQuantity {
+		l.Debugf("Nothing to modify")
+		return modifiedOrder, nil
+	}
+
+	// stocks to be reserved additionally. Negative if stocks are to be returned.
+	reservedStockDelta := int64(newStockQuantity) - int64(ask.StockQuantity)
+
+	if reservedStockDelta > 0 {
+		numStocks, err := getSingleStockCount(user, ask.StockId)
+		if err != nil {
+			return nil, err
+		}
+
+		// stocks aren't borrowed for short selling while modifying an order
+		if numStocks < reservedStockDelta {
+			l.Debugf("Not enough stocks. User has %d, needs %d more", numStocks, reservedStockDelta)
+			if numStocks < 0 {
+				numStocks = 0
+			}
+			return nil, NotEnoughStocksError{int64(ask.StockQuantity) + numStocks}
 		}
 	}
-	return MaxTaxPercent
+
+	oldUpdatedAt := ask.UpdatedAt
+
+	db := getDB()
+	tx := db.Begin()
+
+	var errorHelper = func(format string, err error) (*ModifiedOrder, error) {
+		l.Errorf(format, err)
+		ask.Lock()
+		ask.Price = modifiedOrder.OldPrice
+		ask.StockQuantity = modifiedOrder.OldStockQuantity
+		ask.UpdatedAt = oldUpdatedAt
+		ask.Unlock()
+		tx.Rollback()
+		return nil, err
+	}
+
+	ask.Lock()
+	ask.Price = newPrice
+	ask.StockQuantity = newStockQuantity
+	ask.UpdatedAt = utils.GetCurrentTimeISO8601()
+	ask.Unlock()
+
+	if err := tx.Save(ask).Error; err != nil {
+		return errorHelper("Error while saving Ask. Rolling back. Error: %+v", err)
+	}
+
+	var modifyOrderTransaction *Transaction
+	if reservedStockDelta != 0 {
+		modifyOrderTransaction = GetTransactionRef(user.Id, ask.StockId, ModifyOrderTransaction, reservedStockDelta, -reservedStockDelta, 0, 0, 0)
+
+		l.Infof("Updating stocks reserved for ask %d by %d", ask.Id, reservedStockDelta)
+
+		if err := savePlaceOrderTransaction(ask.Id, modifyOrderTransaction, true, tx); err != nil {
+			return errorHelper("Error updating reserved stocks. Rolling back. Error: %+v", err)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	l.Infof("Committed successfully for ask %d", ask.Id)
+
+	go sendModifiedOrderUpdates(user.Id, ask.ToProto().OrderType, true, ask.Id, ask.StockId, newPrice, newStockQuantity, modifyOrderTransaction)
+
+	return modifiedOrder, nil
+}
+
+// modifyBidOrder modifies a bid order. Cash is reserved or returned as per the change in price and quantity.
+// This function should be called ONLY AFTER lock is obtained on user
+func modifyBidOrder(user *User, bid *Bid, newPrice, newStockQuantity uint64) (*ModifiedOrder, error) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":                 "modifyBidOrder",
+		"param_userId":           user.Id,
+		"param_bid":              fmt.Sprintf("%+v", bid),
+		"param_newPrice":         newPrice,
+		"param_newStockQuantity": newStockQuantity,
+	})
+
+	if bid.GroupId != 0 {
+		return nil, OrderNotModifiableError{"It is linked to another order as one-cancels-other."}
+	}
+
+	if bid.IsTrailingStop() && newPrice != bid.Price {
+		return nil, OrderNotModifiableError{"The trigger price of a trailing stoploss follows the market price."}
+	}
+
+	if err := checkOrderModification(bid.StockId, bid.OrderType, bid.TimeInForce, bid.IsClosed, bid.StockQuantityFulfilled, newPrice, newStockQuantity, BID_LIMIT); err != nil {
+		l.Debugf("Checks failed: %+v", err)
+		return nil, err
+	}
+
+	if err := checkIcebergModification(bid.IsIceberg, bid.StockQuantityFulfilled, newStockQuantity, bid.DisplayQuantity); err != nil {
+		l.Debugf("Iceberg check failed: %+v", err)
+		return nil, err
+	}
+
+	modifiedOrder := &ModifiedOrder{
+		Bid:              bid,
+		OldPrice:         bid.Price,
+		OldStockQuantity: bid.StockQuantity,
+	}
+
+	if newPrice == bid.Price && newStockQuantity == bid.StockQuantity {
+		l.Debugf("Nothing to modify")
+		return modifiedOrder, nil
+	}
+
+	// The cash used for a trade is taken out of the reservation in proportion to the
+	// quantity traded (see PerformOrderFillTransaction). That stays right only if every
+	// stock of the order has the same price reserved. Stocks that were traded already
+	// were reserved at the old price, so the price can't change once some are traded.
+	if newPrice != bid.Price && bid.StockQuantityFulfilled > 0 {
+		l.Debugf("Price of a partially filled bid can't be changed")
+		return nil, OrderNotModifiableError{"The price of a partially filled buy order cannot be changed."}
+	}
+
+	reservedCash, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+	if err != nil {
+		l.Errorf("Could not retrieve reserved cash. Error: %+v", err)
+		return nil, err
+	}
+
+	orderPrice := getOrderFeePrice(newPrice, bid.LimitPrice, bid.StockId, bid.OrderType)
+	// the fee is reserved with the bid, as if the whole bid traded as a taker (see PlaceBidOrder)
+	orderFee := getMaxOrderFee(user.Id, bid.StockId, newStockQuantity, orderPrice)
+	// cash to be reserved additionally. Negative if cash is to be returned.
+	reservedCashDelta := int64(newStockQuantity*orderPrice+orderFee) - reservedCash
+
+	cashLeft := int64(user.Cash) - reservedCashDelta
+
+	l.Debugf("Cash to be reserved additionally is %d", reservedCashDelta)
+	l.Debugf("User has %d cash currently. Will be left with %d cash after modification.", user.Cash, cashLeft)
+
+	if cashLeft < MINIMUM_CASH_LIMIT {
+		l.Debugf("Not enough cash.")
+		return nil, NotEnoughCashError{}
+	}
+
+	oldCash := user.Cash
+	oldReservedCash := user.ReservedCash
+	oldUpdatedAt := bid.UpdatedAt
+
+	db := getDB()
+	tx := db.Begin()
+
+	var errorHelper = func(format string, err error) (*ModifiedOrder, error) {
+		l.Errorf(format, err)
+		user.Cash = oldCash
+		user.ReservedCash = oldReservedCash
+		bid.Lock()
+		bid.Price = modifiedOrder.OldPrice
+		bid.StockQuantity = modifiedOrder.OldStockQuantity
+		bid.UpdatedAt = oldUpdatedAt
+		bid.Unlock()
+		tx.Rollback()
+		return nil, err
+	}
+
+	bid.Lock()
+	bid.Price = newPrice
+	bid.StockQuantity = newStockQuantity
+	bid.UpdatedAt = utils.GetCurrentTimeISO8601()
+	bid.Unlock()
+
+	if err := tx.Save(bid).Error; err != nil {
+		return errorHelper("Error while saving Bid. Rolling back. Error: %+v", err)
+	}
+
+	var modifyOrderTransaction *Transaction
+	if reservedCashDelta != 0 {
+		user.Cash = uint64(int64(user.Cash) - reservedCashDelta)
+		user.ReservedCash = uint64(int64(user.ReservedCash) + reservedCashDelta)
+
+		if err := tx.Save(user).Error; err != nil {
+			return errorHelper("Error while updating reserved cash of the user. Rolling back. Error: %+v", err)
+		}
+
+		modifyOrderTransaction = GetTransactionRef(user.Id, bid.StockId, ModifyOrderTransaction, 0, 0, 0, reservedCashDelta, -reservedCashDelta)
+
+		l.Infof("Updating cash reserved for bid %d by %d", bid.Id, reservedCashDelta)
+
+		if err := savePlaceOrderTransaction(bid.Id, modifyOrderTransaction, false, tx); err != nil {
+			return errorHelper("Error updating reserved cash. Rolling back. Error: %+v", err)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorHelper("Error committing the transaction. Failing. %+v", err)
+	}
+
+	l.Infof("Committed successfully for bid %d", bid.Id)
+
+	go sendModifiedOrderUpdates(user.Id, bid.ToProto().OrderType, false, bid.Id, bid.StockId, newPrice, newStockQuantity, modifyOrderTransaction)
+
+	return modifiedOrder, nil
+}
+
+// sendModifiedOrderUpdates sends the update of a modified order to MyOrdersStream, and the
+// transaction made while modifying it to TransactionsStream. A nil transaction is skipped.
+func sendModifiedOrderUpdates(userId uint32, orderType models_pb.OrderType, isAsk bool, orderId, stockId uint32, price, stockQuantity uint64, modifyOrderTransaction *Transaction) {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "sendModifiedOrderUpdates",
+		"param_userId":  userId,
+		"param_orderId": orderId,
+		"param_isAsk":   isAsk,
+	})
+
+	myOrdersStream := datastreamsManager.GetMyOrdersStream()
+	transactionsStream := datastreamsManager.GetTransactionsStream()
+
+	myOrdersStream.SendOrder(userId, &datastreams_pb.MyOrderUpdate{
+		Id:            orderId,
+		IsAsk:         isAsk,
+		IsModified:    true,
+		StockId:       stockId,
+		OrderPrice:    price,
+		OrderType:     orderType,
+		StockQuantity: stockQuantity,
+	})
+
+	if modifyOrderTransaction != nil {
+		transactionsStream.SendTransaction(modifyOrderTransaction.ToProto())
+	}
+
+	l.Infof("Sent through the datastreams")
 }
 
 // Helper to calculate tax for bidding user.
 // It is assumed that an Exclusive Read and Write lock is already obtained for the user.
 // DO NOT call this function without obtaining the lock.
-func getTaxForBiddingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stockPrice uint64, biddingUser *User) (*TransactionSummary, *Transaction) {
+func getTaxForBiddingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stockPrice uint64, biddingUser *User) (*TransactionSummary, *TaxRecord) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":              "getTaxForBiddingUser",
 		"param_stockId":       stockId,
@@ -1537,6 +2129,8 @@ func getTaxForBiddingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, sto
 
 	// Default value = 0
 	var tax uint64
+	// the gain realized by the trade, if any
+	var taxRecord *TaxRecord
 
 	transactionSummary := &TransactionSummary{UserId: userId, StockId: stockId}
 	tx.Where("userId = ? AND stockId = ?", userId, stockId).First(&transactionSummary)
@@ -1561,12 +2155,14 @@ func getTaxForBiddingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, sto
 			profit := taxableStockQty * (transactionSummary.Price - stockPriceFloat64)
 			if profit > 0 {
 				netCash := biddingUser.Total
-				tax = uint64(profit) * getTaxPercent(netCash) / 100
+				tax = getTax(netCash, profit)
 				biddingUser.Cash -= tax
-				l.Debugf("Profit = %v. Tax = %v * %v / 100 = %v", profit, profit, getTaxPercent(netCash), tax)
+				l.Debugf("Profit = %v. Tax on going from %v to %v = %v", profit, netCash, float64(netCash)+profit, tax)
 			} else {
 				l.Debugf("Profit = %v <= 0. Therefore, no tax.", profit)
 			}
+			taxRecord = getTaxRecordRef(userId, stockId, -int64(taxableStockQty), transactionSummary.Price, profit, tax)
+			transactionSummary.RealizedGain += profit
 			transactionSummary.StockQuantity += int64(stockQuantity)
 			if taxableStockQty == -currentStocksHeld {
 				// User has bough back all of his short sold stocks and is now buying more, therefore the
@@ -1590,19 +2186,13 @@ func getTaxForBiddingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, sto
 		l.Debugf("Effectively the first time user is buying these stocks. No tax is added, but database is going to be updated - %+v", transactionSummary)
 	}
 
-	taxTransaction := GetTransactionRef(userId, stockId, TaxTransaction, 0, 0, 0, 0, -int64(tax))
-
-	if tax == 0 {
-		return transactionSummary, nil
-	}
-
-	return transactionSummary, taxTransaction
+	return transactionSummary, taxRecord
 }
 
 // Helper to calculate tax for asking user
 // It is assumed that an Exclusive Read and Write lock is already obtained for the user.
 // DO NOT call this function without obtaining the lock.
-func getTaxForAskingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stockPrice uint64, askingUser *User) (*TransactionSummary, *Transaction) {
+func getTaxForAskingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stockPrice uint64, askingUser *User) (*TransactionSummary, *TaxRecord) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":              "getTaxForAskingUser",
 		"param_stockId":       stockId,
@@ -1617,6 +2207,8 @@ func getTaxForAskingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stoc
 
 	// Default value = 0
 	var tax uint64
+	// the gain realized by the trade, if any
+	var taxRecord *TaxRecord
 
 	transactionSummary := &TransactionSummary{UserId: userId, StockId: stockId}
 	tx.Where("userId = ? AND stockId = ?", userId, stockId).First(&transactionSummary)
@@ -1641,12 +2233,14 @@ func getTaxForAskingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stoc
 			profit := taxableStockQty * (stockPriceFloat64 - transactionSummary.Price)
 			if profit > 0 {
 				netCash := askingUser.Total
-				tax = uint64(profit) * getTaxPercent(netCash) / 100
+				tax = getTax(netCash, profit)
 				askingUser.Cash -= tax
-				l.Debugf("Profit = %v. Tax = %v * %v / 100 = %v", profit, profit, getTaxPercent(netCash), tax)
+				l.Debugf("Profit = %v. Tax on going from %v to %v = %v", profit, netCash, float64(netCash)+profit, tax)
 			} else {
 				l.Debugf("Profit = %v <= 0. Therefore, no tax.", profit)
 			}
+			taxRecord = getTaxRecordRef(userId, stockId, int64(taxableStockQty), transactionSummary.Price, profit, tax)
+			transactionSummary.RealizedGain += profit
 			transactionSummary.StockQuantity -= int64(stockQuantity)
 			if taxableStockQty == currentStocksHeld {
 				// User has sold all of his stocks and is now short selling, therefore the price in the
@@ -1670,13 +2264,7 @@ func getTaxForAskingUser(tx *gorm.DB, stockId uint32, stockQuantity uint64, stoc
 		l.Debugf("Effectively the first time user is short selling these stocks. No tax is added, but database is going to be updated - %+v", transactionSummary)
 	}
 
-	taxTransaction := GetTransactionRef(userId, stockId, TaxTransaction, 0, 0, 0, 0, -int64(tax))
-
-	if tax == 0 {
-		return transactionSummary, nil
-	}
-
-	return transactionSummary, taxTransaction
+	return transactionSummary, taxRecord
 }
 
 func PerformBuyFromExchangeTransaction(userId uint32, stockId uint32, stockQuantity uint64) (*Transaction, error) {
@@ -1694,6 +2282,11 @@ func PerformBuyFromExchangeTransaction(userId uint32, stockId uint32, stockQuant
 		return nil, StockBankruptError{}
 	}
 
+	if IsStockDelisted(stockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return nil, StockDelistedError{}
+	}
+
 	if stockQuantity > BUY_LIMIT {
 		l.Debugf("Exceeded buy limit. PerformBuyFromExchange failing")
 		return nil, BuyLimitExceededError{}
@@ -1769,7 +2362,7 @@ func PerformBuyFromExchangeTransaction(userId uint32, stockId uint32, stockQuant
 	}
 
 	// Tax Calculation
-	transactionSummary, taxTransaction := getTaxForBiddingUser(tx, stockId, stockQuantityRemoved, price, user)
+	transactionSummary, taxRecord := getTaxForBiddingUser(tx, stockId, stockQuantityRemoved, price, user)
 
 	if err := tx.Save(transactionSummary).Error; err != nil {
 		return errorHelper("Error updating the transaction summary. Rolling back. Error : +%v", err)
@@ -1784,12 +2377,11 @@ func PerformBuyFromExchangeTransaction(userId uint32, stockId uint32, stockQuant
 	l.Debugf("Added transaction to Transactions table")
 
 	//save taxTransaction
-	if taxTransaction != nil {
-		if err := tx.Save(taxTransaction).Error; err != nil {
-			return errorHelper("Error creating the TaxTransaction - %+v - for asking user in the db. Rolling back. Error : +%v", taxTransaction, err)
-		}
-		l.Debugf("Added TaxTransaction to Transactions table.")
+	taxTransaction, err := saveTaxRecord(tx, taxRecord, transaction)
+	if err != nil {
+		return errorHelper("Error creating the TaxTransaction - %+v - for asking user in the db. Rolling back. Error : +%v", taxRecord, err)
 	}
+	l.Debugf("Added TaxTransaction to Transactions table.")
 
 	if err := tx.Save(user).Error; err != nil {
 		return errorHelper("Error deducting the cash from user's account. Rolling back. Error: %+v", err)
@@ -1838,6 +2430,8 @@ func PerformBuyFromExchangeTransaction(userId uint32, stockId uint32, stockQuant
 *				- save askTransaction, bidTransaction
 *				- update biddingUser and askingUser cash
 *				- update StockQuantityFulfilled and IsClosed for askOrder and bidOrder
+*				- save the OrderFeeTransactions of the askingUser and the biddingUser. The order that
+*				  was resting in the order book pays the maker fee, and the incoming one the taker fee
 *
 *
 *	Returns askDone, bidDone, Error
@@ -1860,7 +2454,61 @@ const (
 	BidUndone                                  // Order yet to complete
 )
 
-func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, stockTradeQty uint64) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
+// CanFillOrder checks if a trade of stockTradeQty stocks at stockTradePrice between ask and bid would go through.
+// The trade doesn't if the bidding user can't pay for it, in which case PerformOrderFillTransaction closes the bid.
+// Nothing is changed, so this can be used to check if an order can be fulfilled before trading it.
+func CanFillOrder(ask *Ask, bid *Bid, stockTradePrice uint64, stockTradeQty uint64, bidIsTaker bool) bool {
+	var l = logger.WithFields(logrus.Fields{
+		"method":        "CanFillOrder",
+		"askingUserId":  ask.UserId,
+		"biddingUserId": bid.UserId,
+		"stockId":       ask.StockId,
+	})
+
+	// the premium of an option contract is checked when it trades, and futures pay only margins
+	if ask.OptionId != 0 || ask.FutureId != 0 {
+		return true
+	}
+
+	biddingUserVolume, err := getUserDayVolume(bid.UserId)
+	if err != nil {
+		l.Errorf("Error while getting day volume of bidding user. Error: %+v", err)
+		return false
+	}
+
+	reservedCashForOrder, _, err := getPlaceOrderTransactionDetails(bid.Id, false)
+	if err != nil {
+		l.Errorf("Error while getting reserved cash. Error: %+v", err)
+		return false
+	}
+
+	ch, biddingUser, err := getUserExclusively(bid.UserId)
+	if err != nil {
+		l.Errorf("Unable to acquire lock on the bidding user: %+v", err)
+		return false
+	}
+	defer close(ch)
+
+	// same as in PerformOrderFillTransaction
+	reservedCashForTrade := getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled) -
+		getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled+stockTradeQty)
+	total := int64(stockTradePrice * stockTradeQty)
+
+	makerFee, takerFee := getFeeRates(bid.StockId)
+	bidFeeRate := makerFee
+	if bidIsTaker {
+		bidFeeRate = takerFee
+	}
+	bidFee := getFee(uint64(total), bidFeeRate, getFeeDiscountPercent(biddingUserVolume))
+
+	cashLeft := int64(biddingUser.Cash) - total + reservedCashForTrade - int64(bidFee)
+	return cashLeft >= MINIMUM_CASH_LIMIT
+}
+
+// orderFillTxDuration is the time taken by the database transaction of PerformOrderFillTransaction
+var orderFillTxDuration = metrics.NewHistogramVec("dalal_order_fill_tx_duration_seconds", "Time taken by the database transaction that fills an order.", nil, "result")
+
+func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, stockTradeQty uint64, askIsTaker bool, bidIsTaker bool) (AskOrderFillStatus, BidOrderFillStatus, *Transaction) {
 	var l = logger.WithFields(logrus.Fields{
 		"method":        "PerformOrderFillTransaction",
 		"askingUserId":  ask.UserId,
@@ -1870,6 +2518,14 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 
 	l.Infof("Attempting")
 
+	// contracts of options and futures are traded and collateralised differently
+	if ask.OptionId != 0 {
+		return performOptionFillTransaction(ask, bid, stockTradePrice, stockTradeQty)
+	}
+	if ask.FutureId != 0 {
+		return performFutureFillTransaction(ask, bid, stockTradePrice, stockTradeQty)
+	}
+
 	/*
 		if ask.isclosed() return askDone, bidNotDone
 		if bid.isClosed() return askNotDone, bidDone
@@ -1927,7 +2583,7 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 
 	/* We're here, so both orders are open now */
 
-	var updateDataStreams = func(askTrans, bidTrans, askTaxTrans, bidTaxTrans *Transaction) {
+	var updateDataStreams = func(askTrans, bidTrans, askTaxTrans, bidTaxTrans, askFeeTrans, bidFeeTrans *Transaction) {
 		myOrdersStream := datastreamsManager.GetMyOrdersStream()
 		transactionsStream := datastreamsManager.GetTransactionsStream()
 
@@ -1963,12 +2619,32 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 			transactionsStream.SendTransaction(bidTaxTrans.ToProto())
 		}
 
+		if askFeeTrans != nil {
+			transactionsStream.SendTransaction(askFeeTrans.ToProto())
+		}
+
+		if bidFeeTrans != nil {
+			transactionsStream.SendTransaction(bidFeeTrans.ToProto())
+		}
+
 		l.Infof("Sent through the datastreams")
 	}
 
+	askingUserVolume, err := getUserDayVolume(ask.UserId)
+	if err != nil {
+		l.Errorf("Error while getting day volume of asking user. Error: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+	biddingUserVolume, err := getUserDayVolume(bid.UserId)
+	if err != nil {
+		l.Errorf("Error while getting day volume of bidding user. Error: %+v", err)
+		return AskUndone, BidUndone, nil
+	}
+
 	// Begin transaction
 	db := getDB()
 	tx := db.Begin()
+	txStart := time.Now()
 
 	//Check if bidder has enough cash
 	reservedCashForOrder, _, err := getPlaceOrderTransactionDetails(bid.Id, false) // Total cash reserved for whole order
@@ -1976,9 +2652,24 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 		l.Errorf("Error while returning reserved cash. Error: %+v", err)
 		return AskUndone, BidUndone, nil
 	}
-	reservedCashForTrade := int64(float64(reservedCashForOrder) * float64(stockTradeQty) / float64(bid.StockQuantity)) // Part of total allowed for stockTradeQty
-	total := int64(stockTradePrice * stockTradeQty)                                                                    // Cash required for the Ask order
-	cashLeft := int64(biddingUser.Cash) - total + reservedCashForTrade
+	// Part of total allowed for stockTradeQty. The fill that closes the bid takes all that's left, with the
+	// part of the reserved fee that wasn't charged.
+	reservedCashForTrade := getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled) -
+		getReservedCashLeft(reservedCashForOrder, bid.StockQuantity, bid.StockQuantityFulfilled+stockTradeQty)
+	total := int64(stockTradePrice * stockTradeQty) // Cash required for the Ask order
+
+	makerFee, takerFee := getFeeRates(ask.StockId)
+	askFeeRate, bidFeeRate := makerFee, makerFee
+	if askIsTaker {
+		askFeeRate = takerFee
+	}
+	if bidIsTaker {
+		bidFeeRate = takerFee
+	}
+	askFee := getFee(uint64(total), askFeeRate, getFeeDiscountPercent(askingUserVolume))
+	bidFee := getFee(uint64(total), bidFeeRate, getFeeDiscountPercent(biddingUserVolume))
+
+	cashLeft := int64(biddingUser.Cash) - total + reservedCashForTrade - int64(bidFee)
 
 	//User has enough stocks reserved. Use that to make transaction
 	askTransaction := GetTransactionRef(ask.UserId, ask.StockId, OrderFillTransaction, -int64(stockTradeQty), 0, stockTradePrice, 0, total)
@@ -1989,8 +2680,8 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 	biddingUserOldCash := biddingUser.Cash
 	biddingUserOldReservedCash := biddingUser.ReservedCash
 
-	//calculate user's updated cash
-	askingUser.Cash += uint64(stockTradeQty) * stockTradePrice
+	//calculate user's updated cash. The fee comes out of what the asking user gets.
+	askingUser.Cash += uint64(stockTradeQty)*stockTradePrice - askFee
 
 	// bidding user's cash will first be subtracted from reserved cash and then if required from bidding user's cash
 	biddingUser.Cash = uint64(cashLeft)
@@ -2008,6 +2699,10 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 	ask.IsClosed = ask.StockQuantity == ask.StockQuantityFulfilled
 	bid.IsClosed = bid.StockQuantity == bid.StockQuantityFulfilled
 
+	// the other legs of the orders' groups, which get closed as the orders trade
+	var closedSiblingAsks []*Ask
+	var closedSiblingBids []*Bid
+
 	var revertToOldState = func(fmt string, willRollBack bool, args ...interface{}) {
 		l.Errorf(fmt, args...)
 		askingUser.Cash = askingUserOldCash
@@ -2020,8 +2715,16 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 		ask.IsClosed = oldAskIsClosed
 		bid.IsClosed = oldBidIsClosed
 
+		for _, sibling := range closedSiblingAsks {
+			sibling.IsClosed = false
+		}
+		for _, sibling := range closedSiblingBids {
+			sibling.IsClosed = false
+		}
+
 		if willRollBack {
 			tx.Rollback()
+			orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "rolled_back")
 		}
 	}
 
@@ -2039,13 +2742,14 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 			revertToOldState("Error while commiting. Rolling back. Error: %+v", true, err)
 			return AskUndone, BidUndone, nil
 		}
-		go updateDataStreams(nil, nil, nil, nil)
+		orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "bid_closed")
+		go updateDataStreams(nil, nil, nil, nil, nil, nil)
 		go SendNotification(biddingUser.Id, fmt.Sprintf("Your Buy order#%d has been closed due to insufficient cash", bid.Id), false)
 		return AskUndone, BidDone, nil
 	}
 
 	// Tax Calculation for asking user
-	transactionSummary, askTaxTransaction := getTaxForAskingUser(tx, ask.StockId, stockTradeQty, stockTradePrice, askingUser)
+	transactionSummary, askTaxRecord := getTaxForAskingUser(tx, ask.StockId, stockTradeQty, stockTradePrice, askingUser)
 
 	// Update transaction summary table for asking user
 	if err := tx.Save(transactionSummary).Error; err != nil {
@@ -2056,7 +2760,7 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 	l.Debugf("TransactionSummary table for asking user updated successfully.")
 
 	// Calculate tax for bidding user
-	transactionSummary, bidTaxTransaction := getTaxForBiddingUser(tx, bid.StockId, stockTradeQty, stockTradePrice, biddingUser)
+	transactionSummary, bidTaxRecord := getTaxForBiddingUser(tx, bid.StockId, stockTradeQty, stockTradePrice, biddingUser)
 
 	// Update transaction summary table for bidding user
 	if err := tx.Save(transactionSummary).Error; err != nil {
@@ -2080,21 +2784,39 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 	l.Debugf("Added bidTransaction to Transactions table")
 
 	//save askTaxTransaction
-	if askTaxTransaction != nil {
-		if err := tx.Save(askTaxTransaction).Error; err != nil {
-			revertToOldState("Error creating the askTaxTransaction - %+v. Rolling back. Error : +%v", true, askTaxTransaction, err)
+	askTaxTransaction, err := saveTaxRecord(tx, askTaxRecord, askTransaction)
+	if err != nil {
+		revertToOldState("Error creating the askTaxTransaction - %+v. Rolling back. Error : +%v", true, askTaxRecord, err)
+		return AskUndone, BidUndone, nil
+	}
+	l.Debugf("Added askTaxTransaction to Transactions table.")
+
+	//save bidTaxTransaction
+	bidTaxTransaction, err := saveTaxRecord(tx, bidTaxRecord, bidTransaction)
+	if err != nil {
+		revertToOldState("Error creating the bidTaxTransaction - %+v. Rolling back. Error : +%v", true, bidTaxRecord, err)
+		return AskUndone, BidUndone, nil
+	}
+	l.Debugf("Added bidTaxTransaction to Transactions table.")
+
+	//save the fee transactions. Whoever paid no fee gets none.
+	var askFeeTransaction, bidFeeTransaction *Transaction
+	if askFee > 0 {
+		askFeeTransaction = GetTransactionRef(ask.UserId, ask.StockId, OrderFeeTransaction, 0, 0, 0, 0, -int64(askFee))
+		if err := tx.Save(askFeeTransaction).Error; err != nil {
+			revertToOldState("Error creating the askFeeTransaction - %+v. Rolling back. Error : +%v", true, askFeeTransaction, err)
 			return AskUndone, BidUndone, nil
 		}
-		l.Debugf("Added askTaxTransaction to Transactions table.")
+		l.Debugf("Added askFeeTransaction to Transactions table.")
 	}
 
-	//save bidTaxTransaction
-	if bidTaxTransaction != nil {
-		if err := tx.Save(bidTaxTransaction).Error; err != nil {
-			revertToOldState("Error creating the bidTaxTransaction - %+v. Rolling back. Error : +%v", true, bidTaxTransaction, err)
+	if bidFee > 0 {
+		bidFeeTransaction = GetTransactionRef(bid.UserId, bid.StockId, OrderFeeTransaction, 0, 0, 0, 0, -int64(bidFee))
+		if err := tx.Save(bidFeeTransaction).Error; err != nil {
+			revertToOldState("Error creating the bidFeeTransaction - %+v. Rolling back. Error : +%v", true, bidFeeTransaction, err)
 			return AskUndone, BidUndone, nil
 		}
-		l.Debugf("Added bidTaxTransaction to Transactions table.")
+		l.Debugf("Added bidFeeTransaction to Transactions table.")
 	}
 
 	//update askingUser
@@ -2121,12 +2843,29 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 		return AskUndone, BidUndone, nil
 	}
 
+	// one-cancels-other: the other legs of the groups get cancelled along with this trade
+	if closedSiblingAsks, err = closeSiblingAsks(ask, tx); err != nil {
+		revertToOldState("Error closing the other asks of group %d. Rolling back. Error: %+v", true, ask.GroupId, err)
+		return AskUndone, BidUndone, nil
+	}
+
+	if closedSiblingBids, err = closeSiblingBids(bid, tx); err != nil {
+		revertToOldState("Error closing the other bids of group %d. Rolling back. Error: %+v", true, bid.GroupId, err)
+		return AskUndone, BidUndone, nil
+	}
+
 	// insert an OrderFill
 	of := &OrderFill{
 		AskId:         ask.Id,
 		BidId:         bid.Id,
 		TransactionId: askTransaction.Id, // We'll always store Ask
 	}
+	if askFeeTransaction != nil {
+		of.AskFeeTransactionId = askFeeTransaction.Id
+	}
+	if bidFeeTransaction != nil {
+		of.BidFeeTransactionId = bidFeeTransaction.Id
+	}
 	if err := tx.Save(of).Error; err != nil {
 		revertToOldState("Error saving an orderfill. Rolling back. Error: %+v", true, err)
 		return AskUndone, BidUndone, nil
@@ -2137,11 +2876,16 @@ func PerformOrderFillTransaction(ask *Ask, bid *Bid, stockTradePrice uint64, sto
 		revertToOldState("Error comming transaction. Rolling back. Error: %+v", true, err)
 		return AskUndone, BidUndone, nil
 	}
+	orderFillTxDuration.Observe(time.Since(txStart).Seconds(), "committed")
 
-	go updateDataStreams(askTransaction, bidTransaction, askTaxTransaction, bidTaxTransaction)
+	go updateDataStreams(askTransaction, bidTransaction, askTaxTransaction, bidTaxTransaction, askFeeTransaction, bidFeeTransaction)
+	go notifyClosedSiblingAsks(closedSiblingAsks)
+	go notifyClosedSiblingBids(closedSiblingBids)
 
 	UpdateStockVolume(ask.StockId, stockTradeQty)
-	l.Infof("Transaction committed successfully. Traded %d at %d per stock. Total %d.", stockTradeQty, stockTradePrice, total)
+	addUserDayVolume(ask.UserId, uint64(total))
+	addUserDayVolume(bid.UserId, uint64(total))
+	l.Infof("Transaction committed successfully. Traded %d at %d per stock. Total %d. Fees %d and %d.", stockTradeQty, stockTradePrice, total, askFee, bidFee)
 
 	if err := UpdateStockPrice(ask.StockId, stockTradePrice, stockTradeQty); err != nil {
 		l.Errorf("Error updating stock price. BUT SUPRRESSING IT.")
@@ -2196,6 +2940,11 @@ func PerformMortgageTransaction(userId, stockId uint32, stockQuantity int64, ret
 		return nil, StockBankruptError{}
 	}
 
+	if IsStockDelisted(stockId) {
+		l.Infof("Stock delisted. Returning function.")
+		return nil, StockDelistedError{}
+	}
+
 	l.Debugf("Acquiring exclusive write on user")
 	ch, user, err := getUserExclusively(userId)
 	if err != nil {
@@ -2397,7 +3146,7 @@ func GetCashSpent(userId uint32) (map[uint32]int64, error) {
 
 // savePlaceOrderTransaction saves PlaceOrderTransaction and creates a mapping between orderId and
 func savePlaceOrderTransaction(orderID uint32, placeOrderTransaction *Transaction, isAsk bool, tx *gorm.DB) error {
-	if err := tx.Save(placeOrderTransaction).Error; err != nil {
+	if err := saveTransaction(tx, placeOrderTransaction); err != nil {
 		return err
 	}
 
@@ -2599,7 +3348,14 @@ func GetUserStockWorth(userId uint32) (int64, error) {
 		l.Debugf("Released exclusive write on user")
 	}()
 
-	stockWorth = user.Total - (int64(user.Cash) + int64(user.ReservedCash))
+	// the total has what's owed on a margin loan taken off, which isn't part of the stock worth
+	marginDebts, err := getMarginDebts(getDB().Where("userId = ?", userId))
+	if err != nil {
+		l.Errorf("Unable to get margin debt: %+v", err)
+		return stockWorth, err
+	}
+
+	stockWorth = user.Total + int64(marginDebts[userId]) - (int64(user.Cash) + int64(user.ReservedCash))
 
 	l.Debugf("Got %d \n", stockWorth)
 
@@ -1108,3 +1108,23 @@ func TestGetTaxPercent(t *testing.T) {
 	testutils.AssertEqual(t, 15, getTaxPercent(1500000))
 	testutils.AssertEqual(t, 25, getTaxPercent(2500000))
 }
+
+func TestGetReservedCashLeft(t *testing.T) {
+	// 1003 is reserved for 3 stocks at 333 and a fee of 4
+	testutils.AssertEqual(t, int64(1003), getReservedCashLeft(1003, 3, 0))
+	testutils.AssertEqual(t, int64(669), getReservedCashLeft(1003, 3, 1))
+	testutils.AssertEqual(t, int64(335), getReservedCashLeft(1003, 3, 2))
+	testutils.AssertEqual(t, int64(0), getReservedCashLeft(1003, 3, 3))
+	testutils.AssertEqual(t, int64(0), getReservedCashLeft(1003, 0, 0))
+
+	// the fills of a bid take all that was reserved for it, however it's split
+	for _, fills := range [][]uint64{{1, 1, 1}, {2, 1}, {1, 2}, {3}} {
+		var released int64
+		var fulfilled uint64
+		for _, qty := range fills {
+			released += getReservedCashLeft(1003, 3, fulfilled) - getReservedCashLeft(1003, 3, fulfilled+qty)
+			fulfilled += qty
+		}
+		testutils.AssertEqual(t, int64(1003), released)
+	}
+}
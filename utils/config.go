@@ -85,6 +85,78 @@ type Config struct {
 	BackendUrl string
 	// Maximum number of verification email requests a user can request
 	MaxVerificationEmailRequestCount uint32
+
+	// Circuit breaker related options
+
+	// Percent by which a stock's price can move away from the previous day's close before trading in it is halted. 0 disables it
+	CircuitBreakerDayBandPercent uint64
+	// Percent by which a stock's price can move within a rolling window before trading in it is halted. 0 disables it
+	CircuitBreakerRollingBandPercent uint64
+	// Length of the rolling window in seconds
+	CircuitBreakerRollingWindow int
+	// Time in seconds for which trading in a stock stays halted
+	CircuitBreakerCoolOffPeriod int
+
+	// What the matching engine does with an order that would trade with an order of the same user.
+	// One of SkipOwnOrders, CancelNewest, CancelOldest, CancelBoth and DecrementAndCancel. Stocks can override it
+	SelfTradePrevention string
+
+	// Directory to which every order book writes a journal of the orders it processed, and the
+	// trades that came out of them. Nothing is journaled if it's empty
+	MatchingEngineJournalDir string
+
+	// Fee related options
+
+	// Fee, in basis points of the traded value, charged for an order that was resting in the order book when it traded
+	MakerFeeBasisPoints uint64
+	// Fee, in basis points of the traded value, charged for an incoming order that traded with a resting one
+	TakerFeeBasisPoints uint64
+	// Discounts on fees for users who have traded a lot in the market day. Stocks can override the rates, but not the tiers
+	FeeTiers []FeeTier
+
+	// Margin account related options
+
+	// How many times their equity users with a margin account can hold in assets. 1 or less disables borrowing
+	MarginLeverage uint64
+	// Interest charged on margin loans at the end of every market day, in basis points of the loan
+	MarginInterestBasisPoints uint64
+	// Percent of a margin account's assets its equity can't fall below without a margin call
+	MaintenanceMarginPercent uint64
+	// Time in seconds a user has to meet a margin call before their stocks get sold
+	MarginCallGracePeriod int
+	// Time in seconds between two checks of the margin accounts
+	MarginCheckInterval int
+
+	// Short sell related options
+
+	// Daily fee on stocks lent for short selling, in basis points of their worth, when none of the bank's stocks are lent
+	ShortSellMinBorrowFeeBasisPoints uint64
+	// Daily fee on stocks lent for short selling, in basis points of their worth, when all of the bank's stocks are lent
+	ShortSellMaxBorrowFeeBasisPoints uint64
+	// Percent of a stock's bank that can be lent out before the bank starts recalling lends
+	ShortSellRecallUtilisationPercent uint64
+	// Market days a user has to cover a recalled lend before it gets squared off at the current price
+	ShortSellRecallGraceDays uint32
+
+	// Futures related options
+
+	// Percent of the worth of a futures position reserved from the user's cash as its initial margin
+	FuturesInitialMarginPercent uint64
+
+	// Market index related options
+
+	// Name the market index is shown with, like DALAL50
+	MarketIndexName string
+	// How the stocks in the market index are weighted. Either PriceWeighted or CapWeighted
+	MarketIndexWeighting string
+	// Number of stocks with the largest market capitalisation that make up the market index. 0 includes every stock
+	MarketIndexSize int
+}
+
+// FeeTier is a discount on fees for users who have traded stocks worth at least MinVolume in the market day
+type FeeTier struct {
+	MinVolume       uint64
+	DiscountPercent uint64
 }
 
 // Struct to load configurations of all possible modes i.e dev, docker, prod, test
@@ -136,6 +208,34 @@ var config = &Config{
 	PushNotificationEmail:            "",
 	BackendUrl:                       "",
 	MaxVerificationEmailRequestCount: 5,
+	CircuitBreakerDayBandPercent:     0,
+	CircuitBreakerRollingBandPercent: 0,
+	CircuitBreakerRollingWindow:      300,
+	CircuitBreakerCoolOffPeriod:      120,
+	SelfTradePrevention:              "SkipOwnOrders",
+	MatchingEngineJournalDir:         "",
+	MakerFeeBasisPoints:              10,
+	TakerFeeBasisPoints:              30,
+	FeeTiers: []FeeTier{
+		{MinVolume: 100000, DiscountPercent: 10},
+		{MinVolume: 1000000, DiscountPercent: 25},
+	},
+	MarginLeverage:            2,
+	MarginInterestBasisPoints: 50,
+	MaintenanceMarginPercent:  25,
+	MarginCallGracePeriod:     600,
+	MarginCheckInterval:       30,
+
+	ShortSellMinBorrowFeeBasisPoints:  10,
+	ShortSellMaxBorrowFeeBasisPoints:  100,
+	ShortSellRecallUtilisationPercent: 90,
+	ShortSellRecallGraceDays:          1,
+
+	FuturesInitialMarginPercent: 20,
+
+	MarketIndexName:      "DALAL50",
+	MarketIndexWeighting: "CapWeighted",
+	MarketIndexSize:      50,
 }
 
 var configFileName *string